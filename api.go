@@ -10,16 +10,27 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/csv"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"os"
+	"path"
+	"strconv"
 	"strings"
 	"time"
+	"whatsapp-api/internal/storage"
+	"whatsapp-api/pkg/apierr"
+	"whatsapp-api/pkg/wajid"
 )
 
 // ============= MIDDLEWARE =============
@@ -47,10 +58,7 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 		// Get token from Authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success": false,
-				"error":   "Authorization header missing",
-			})
+			respondAPIError(c, apierr.ErrUnauthorized, "Authorization header missing")
 			c.Abort()
 			return
 		}
@@ -58,10 +66,7 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 		// Extract token
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success": false,
-				"error":   "Invalid authorization format",
-			})
+			respondAPIError(c, apierr.ErrUnauthorized, "Invalid authorization format")
 			c.Abort()
 			return
 		}
@@ -76,10 +81,7 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 		})
 
 		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success": false,
-				"error":   "Invalid or expired token",
-			})
+			respondAPIError(c, apierr.ErrUnauthorized, "Invalid or expired token")
 			c.Abort()
 			return
 		}
@@ -87,10 +89,7 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 		// Extract claims
 		claims, ok := token.Claims.(jwt.MapClaims)
 		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success": false,
-				"error":   "Invalid token claims",
-			})
+			respondAPIError(c, apierr.ErrUnauthorized, "Invalid token claims")
 			c.Abort()
 			return
 		}
@@ -98,10 +97,7 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 		// Get user ID
 		userIDFloat, ok := claims["user_id"].(float64)
 		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success": false,
-				"error":   "User ID not found in token",
-			})
+			respondAPIError(c, apierr.ErrUnauthorized, "User ID not found in token")
 			c.Abort()
 			return
 		}
@@ -117,6 +113,21 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 	}
 }
 
+// AdminMiddleware guards the admin endpoints with ADMIN_TOKEN, sent as
+// X-Admin-Token. It's separate from user JWTs: admin calls act on the whole
+// deployment.
+func AdminMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given := c.GetHeader("X-Admin-Token")
+		if given == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			respondAPIError(c, apierr.ErrUnauthorized, "invalid admin token")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 // CORSMiddleware handles CORS headers
 func CORSMiddleware(allowedOrigins string) gin.HandlerFunc {
 	origins := strings.Split(allowedOrigins, ",")
@@ -133,8 +144,9 @@ func CORSMiddleware(allowedOrigins string) gin.HandlerFunc {
 		}
 
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, Idempotency-Key")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After, API-Version, Deprecation, Sunset, Link, X-Usage-Period, X-Usage-Sent, X-Usage-Limit, X-Usage-Remaining, X-Usage-Warning")
 		c.Writer.Header().Set("Access-Control-Max-Age", "86400")
 
 		if c.Request.Method == "OPTIONS" {
@@ -168,6 +180,74 @@ func ErrorMiddleware() gin.HandlerFunc {
 	return gin.Recovery()
 }
 
+// ============= ERROR RESPONSES =============
+// Every error response is {"success": false, "error": <message>, "code": <code>}
+// with a code from pkg/apierr. Errors returned by services carry their own
+// code and status when they wrap an apierr.Error or a known sentinel error;
+// anything else gets the status the handler picked and its generic code.
+
+// apiError returns the API error of err, falling back to the generic error of
+// status
+func apiError(err error, status int) *apierr.Error {
+	if apiErr, ok := apierr.As(err); ok {
+		return apiErr
+	}
+
+	var limitErr *SendLimitError
+	switch {
+	case errors.As(err, &limitErr), isRateLimitError(err), errors.As(err, new(*SessionBusyError)):
+		return apierr.ErrRateLimited
+	case errors.Is(err, wajid.ErrNotOnWhatsApp):
+		return apierr.ErrRecipientNotOnWhatsApp
+	case errors.Is(err, wajid.ErrInvalidJID), errors.Is(err, wajid.ErrInvalidPhone):
+		return apierr.ErrInvalidRecipient
+	case errors.Is(err, ErrMediaTooLarge):
+		return apierr.ErrMediaTooLarge
+	case errors.Is(err, ErrSuppressed):
+		return apierr.ErrRecipientOptedOut
+	case errors.Is(err, ErrAvatarNotSet):
+		return apierr.ErrNotFound
+	}
+	return apierr.ForStatus(status)
+}
+
+// respondError writes the error response for err. Send limits, WhatsApp
+// throttling and busy sessions also get a Retry-After header and retry_at.
+func respondError(c *gin.Context, status int, err error) {
+	apiErr := apiError(err, status)
+	body := gin.H{
+		"success": false,
+		"error":   err.Error(),
+		"code":    apiErr.Code,
+	}
+
+	var limitErr *SendLimitError
+	var throttleErr *ThrottleError
+	var busyErr *SessionBusyError
+	switch {
+	case errors.As(err, &limitErr):
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(limitErr.RetryAt).Seconds())+1))
+		body["retry_at"] = limitErr.RetryAt
+	case errors.As(err, &throttleErr):
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(throttleErr.RetryAt).Seconds())+1))
+		body["retry_at"] = throttleErr.RetryAt
+	case errors.As(err, &busyErr):
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(busyErr.RetryAt).Seconds())+1))
+		body["retry_at"] = busyErr.RetryAt
+	}
+	c.JSON(apiErr.Status, body)
+}
+
+// respondAPIError writes an error response for a failure detected by the
+// handler itself
+func respondAPIError(c *gin.Context, apiErr *apierr.Error, message string) {
+	c.JSON(apiErr.Status, gin.H{
+		"success": false,
+		"error":   message,
+		"code":    apiErr.Code,
+	})
+}
+
 // ============= HANDLERS =============
 
 type APIHandlers struct {
@@ -192,25 +272,34 @@ func (h *APIHandlers) CreateSession(c *gin.Context) {
 
 	var req struct {
 		SessionName string `json:"session_name" binding:"required"`
+		ProjectID   *int64 `json:"project_id"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
+	if req.ProjectID != nil {
+		if _, err := h.whatsappService.GetProject(userID, *req.ProjectID); err != nil {
+			chatActionError(c, err)
+			return
+		}
+	}
+
 	// Create session
 	session, err := h.whatsappService.CreateSession(userID, req.SessionName)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
+	if req.ProjectID != nil {
+		if err := h.db.SetSessionProject(session.ID, req.ProjectID); err != nil {
+			respondError(c, http.StatusInternalServerError, err)
+			return
+		}
+		session.ProjectID = req.ProjectID
+	}
 
 	c.JSON(http.StatusCreated, gin.H{
 		"success": true,
@@ -219,32 +308,93 @@ func (h *APIHandlers) CreateSession(c *gin.Context) {
 			"user_id":      session.UserID,
 			"session_name": session.SessionName,
 			"status":       session.Status,
+			"project_id":   session.ProjectID,
 			"created_at":   session.CreatedAt,
 		},
 	})
 }
 
-// GetSessions gets all sessions for the authenticated user
+// UpsertSession returns the session named by the path, creating it when it
+// doesn't exist yet (201 for a new session, 200 for an existing one). Pending
+// sessions include their current QR code when one is available.
+func (h *APIHandlers) UpsertSession(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	// The route shares the :session_id wildcard of the other /sessions routes
+	sessionName := strings.TrimSpace(c.Param("session_id"))
+	if sessionName == "" {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Session name is required")
+		return
+	}
+
+	session, created, err := h.whatsappService.EnsureSession(userID, sessionName)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	data := gin.H{
+		"session_id":   session.ID,
+		"user_id":      session.UserID,
+		"session_name": session.SessionName,
+		"status":       session.Status,
+		"connected":    h.whatsappService.IsSessionConnected(session.ID),
+		"phone_number": session.PhoneNumber,
+		"jid":          session.JID,
+		"push_name":    session.PushName,
+		"connected_at": session.ConnectedAt,
+		"created_at":   session.CreatedAt,
+		"created":      created,
+	}
+	if session.Status == StatusPending || session.Status == StatusQRReady {
+		if qrCode, err := h.whatsappService.GetQRCode(session.ID, userID); err == nil {
+			data["qr_code"] = qrCode
+			data["qr_expires_at"] = session.QRExpiresAt
+		}
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// GetSessions lists the sessions of the authenticated user
+// (sort: created_at, name, status, last_seen; filters: status, project_id or
+// "none"; ?q= searches name, phone number and push name)
 func (h *APIHandlers) GetSessions(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
+	q, ok := parseListRequest(c, listSortFields{
+		"created_at": "created_at",
+		"name":       "session_name",
+		"status":     "status",
+		"last_seen":  "last_seen",
+	}, "-created_at", "status", "project_id")
+	if !ok {
+		return
+	}
+	if projectID, ok := q.Filters["project_id"]; ok && projectID != "none" {
+		if id, err := strconv.ParseInt(projectID, 10, 64); err != nil || id <= 0 {
+			respondAPIError(c, apierr.ErrInvalidRequest, "project_id must be a project ID or none")
+			return
+		}
+	}
+
 	// Get sessions
-	sessions, err := h.whatsappService.GetUserSessions(userID)
+	sessions, total, err := h.db.ListUserSessions(userID, q)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		respondError(c, http.StatusInternalServerError, err)
 		return
 	}
 
 	// Get summary
-	summary, err := h.db.GetUserDeviceSummary(userID)
+	summary, err := h.db.GetUserDeviceSummary(userID, h.whatsappService.MaxDevices(userID))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		respondError(c, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -252,17 +402,20 @@ func (h *APIHandlers) GetSessions(c *gin.Context) {
 	sessionList := make([]gin.H, 0, len(sessions))
 	for _, session := range sessions {
 		sessionList = append(sessionList, gin.H{
-			"id":           session.ID,
-			"session_name": session.SessionName,
-			"status":       session.Status,
-			"phone_number": session.PhoneNumber,
-			"jid":          session.JID,
-			"push_name":    session.PushName,
-			"platform":     session.Platform,
-			"connected_at": session.ConnectedAt,
-			"last_seen":    session.LastSeen,
-			"is_active":    session.IsActive,
-			"created_at":   session.CreatedAt,
+			"id":            session.ID,
+			"session_name":  session.SessionName,
+			"status":        session.Status,
+			"phone_number":  session.PhoneNumber,
+			"jid":           session.JID,
+			"push_name":     session.PushName,
+			"platform":      session.Platform,
+			"connected_at":  session.ConnectedAt,
+			"last_seen":     session.LastSeen,
+			"is_active":     session.IsActive,
+			"status_reason": session.StatusReason,
+			"banned_until":  session.BannedUntil,
+			"project_id":    session.ProjectID,
+			"created_at":    session.CreatedAt,
 		})
 	}
 
@@ -271,13 +424,14 @@ func (h *APIHandlers) GetSessions(c *gin.Context) {
 		"data": gin.H{
 			"sessions": sessionList,
 			"summary": gin.H{
-				"total_sessions":  len(sessions),
+				"total_sessions":  len(summary.Sessions),
 				"connected":       summary.ConnectedDevices,
 				"pending":         summary.UsedDevices - summary.ConnectedDevices,
-				"max_devices":     h.cfg.MaxDevicesPerUser,
+				"max_devices":     summary.MaxDevices,
 				"available_slots": summary.AvailableSlots,
 			},
 		},
+		"pagination": q.Meta(total),
 	})
 }
 
@@ -290,39 +444,27 @@ func (h *APIHandlers) GetSessionQR(c *gin.Context) {
 	// Parse session ID
 	sessionID, err := uuid.Parse(sessionIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid session ID",
-		})
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
 		return
 	}
 
 	// Get session
 	session, err := h.db.GetSession(sessionID, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Session not found",
-		})
+		respondAPIError(c, apierr.ErrSessionNotFound, "Session not found")
 		return
 	}
 
 	// Check if QR is available
 	if session.Status != StatusPending && session.Status != StatusQRReady {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   fmt.Sprintf("QR code not available for status: %s", session.Status),
-		})
+		respondAPIError(c, apierr.ErrQRNotAvailable, fmt.Sprintf("QR code not available for status: %s", session.Status))
 		return
 	}
 
 	// Get QR code
 	qrCode, err := h.whatsappService.GetQRCode(sessionIDStr, userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
@@ -332,10 +474,7 @@ func (h *APIHandlers) GetSessionQR(c *gin.Context) {
 		data := strings.TrimPrefix(qrCode, "data:image/png;base64,")
 		decoded, err := base64.StdEncoding.DecodeString(data)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"success": false,
-				"error":   "Failed to decode QR image",
-			})
+			respondAPIError(c, apierr.ErrInternal, "Failed to decode QR image")
 			return
 		}
 
@@ -360,20 +499,14 @@ func (h *APIHandlers) GetSessionStatus(c *gin.Context) {
 	// Parse session ID (validate format)
 	_, err := uuid.Parse(sessionIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid session ID",
-		})
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
 		return
 	}
 
 	// Get session status
 	session, err := h.whatsappService.GetSessionStatus(sessionIDStr, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Session not found",
-		})
+		respondAPIError(c, apierr.ErrSessionNotFound, "Session not found")
 		return
 	}
 
@@ -387,10 +520,68 @@ func (h *APIHandlers) GetSessionStatus(c *gin.Context) {
 			"push_name":    session.PushName,
 			"last_seen":    session.LastSeen,
 			"connected_at": session.ConnectedAt,
+			// Why a session is banned, connect_failed or unlinked
+			"status_reason":      session.StatusReason,
+			"status_reason_code": session.StatusReasonCode,
+			"banned_until":       session.BannedUntil,
 		},
 	})
 }
 
+// GetSessionHealth runs a health check of a session (?probe=true adds a
+// round trip to WhatsApp)
+func (h *APIHandlers) GetSessionHealth(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	health, err := h.whatsappService.GetSessionHealth(c.Param("session_id"), userID, c.Query("probe") == "true")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    health,
+	})
+}
+
+// ReconcileStore serves /api/v1/admin/store/reconcile: GET reports the
+// mismatches between sessions and the WhatsApp store, POST also repairs them
+func (h *APIHandlers) ReconcileStore(c *gin.Context) {
+	result, err := h.whatsappService.ReconcileStore(c.Request.Method == http.MethodPost)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// GetSessionStats returns the message usage of a session per day (?days=30)
+func (h *APIHandlers) GetSessionStats(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "days must be a number")
+		return
+	}
+
+	stats, err := h.whatsappService.GetSessionStats(c.Param("session_id"), userID, days)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    stats,
+	})
+}
+
 // DeleteSession deletes a session
 func (h *APIHandlers) DeleteSession(c *gin.Context) {
 	userID := c.GetInt("user_id")
@@ -399,19 +590,13 @@ func (h *APIHandlers) DeleteSession(c *gin.Context) {
 	// Parse session ID (validate format)
 	_, err := uuid.Parse(sessionIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid session ID",
-		})
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
 		return
 	}
 
 	// Delete session
 	if err := h.whatsappService.DeleteSession(sessionIDStr, userID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		respondError(c, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -421,17 +606,89 @@ func (h *APIHandlers) DeleteSession(c *gin.Context) {
 	})
 }
 
+// PurgeSession removes a deleted session with all of its data. Without a
+// confirm_token it answers with a token and what would be removed.
+func (h *APIHandlers) PurgeSession(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req SessionPurgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	confirmation, result, err := h.whatsappService.PurgeSession(c.Param("session_id"), userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+	if confirmation != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Repeat the request with confirm_token to purge the session",
+			"data":    confirmation,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// LogoutSession unlinks the session's device from the phone and deletes the
+// session with its synced data
+func (h *APIHandlers) LogoutSession(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	unlinked, err := h.whatsappService.LogoutSession(sessionIDStr, userID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	message := "Session logged out and unlinked from the phone"
+	if !unlinked {
+		message = "Session removed; it was not connected, so remove it from the phone's Linked Devices manually"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": message,
+		"data": gin.H{
+			"unlinked": unlinked,
+		},
+	})
+}
+
 // GetDeviceSummary gets device summary for a user
 func (h *APIHandlers) GetDeviceSummary(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
+	// ?project_id= narrows the summary to one project
+	if c.Query("project_id") != "" {
+		projectID, err := strconv.ParseInt(c.Query("project_id"), 10, 64)
+		if err != nil || projectID <= 0 {
+			respondAPIError(c, apierr.ErrInvalidRequest, "Invalid project ID")
+			return
+		}
+		summary, err := h.whatsappService.GetProjectDeviceSummary(userID, projectID)
+		if err != nil {
+			chatActionError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data":    summary,
+		})
+		return
+	}
+
 	// Get summary
-	summary, err := h.db.GetUserDeviceSummary(userID)
+	summary, err := h.db.GetUserDeviceSummary(userID, h.whatsappService.MaxDevices(userID))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		respondError(c, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -441,46 +698,89 @@ func (h *APIHandlers) GetDeviceSummary(c *gin.Context) {
 	})
 }
 
+// GetUsage returns the messages the user sent in a billing period
+// (?period=YYYY-MM, default the current month) and their quota
+func (h *APIHandlers) GetUsage(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	report, err := h.whatsappService.GetUsage(userID, c.Query("period"))
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    report,
+	})
+}
+
 // SendMessage sends a WhatsApp message
 func (h *APIHandlers) SendMessage(c *gin.Context) {
 	userID := c.GetInt("user_id")
 	sessionIDStr := c.Param("session_id")
 
 	var req struct {
-		To      string `json:"to" binding:"required"`
-		Message string `json:"message" binding:"required"`
+		To               string `json:"to" binding:"required"`
+		Message          string `json:"message"`
+		CannedResponseID int64  `json:"canned_response_id"` // instead of message
+		MentionAll       bool   `json:"mention_all"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
 	// Parse session ID (validate format)
 	_, err := uuid.Parse(sessionIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid session ID",
-		})
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
+		return
+	}
+
+	switch {
+	case req.CannedResponseID != 0 && req.Message != "":
+		respondAPIError(c, apierr.ErrInvalidRequest, "Send either message or canned_response_id")
+		return
+	case req.CannedResponseID != 0:
+		response, err := h.whatsappService.GetCannedResponse(userID, req.CannedResponseID)
+		if err != nil {
+			chatActionError(c, err)
+			return
+		}
+		req.Message = response.Text
+	case req.Message == "":
+		respondAPIError(c, apierr.ErrInvalidRequest, "message is required")
+		return
+	}
+
+	if wantsAsync(c) {
+		queued := h.enqueueSend(c, SendRequest{SessionID: sessionIDStr, To: req.To, Text: req.Message, MentionAll: req.MentionAll})
+		if queued && req.CannedResponseID != 0 {
+			h.whatsappService.recordCannedResponseUse(req.CannedResponseID)
+		}
 		return
 	}
 
 	// Send message
-	if err := h.whatsappService.SendMessage(sessionIDStr, userID, req.To, req.Message); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+	send := h.whatsappService.SendMessage
+	if req.MentionAll {
+		send = h.whatsappService.SendMentionAll
+	}
+	resp, err := send(sessionIDStr, userID, req.To, req.Message)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
+	if req.CannedResponseID != 0 {
+		h.whatsappService.recordCannedResponseUse(req.CannedResponseID)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "Message sent successfully",
+		"data":    resp,
 	})
 }
 
@@ -499,24 +799,24 @@ func (h *APIHandlers) SendMessageAdvanced(c *gin.Context) {
 			Filename    string `json:"filename"`
 			Mimetype    string `json:"mimetype"`
 			IsVoice     bool   `json:"is_voice"` // For audio messages
+
+			// For location messages
+			Latitude  *float64 `json:"latitude"`
+			Longitude *float64 `json:"longitude"`
+			Name      string   `json:"name"`
+			Address   string   `json:"address"`
 		} `json:"content"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid request: " + err.Error(),
-		})
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
 		return
 	}
 
 	// Validate session ID format
 	_, err := uuid.Parse(sessionIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid session ID",
-		})
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
 		return
 	}
 
@@ -527,12 +827,59 @@ func (h *APIHandlers) SendMessageAdvanced(c *gin.Context) {
 		"video":    true,
 		"audio":    true,
 		"document": true,
+		"location": true,
 	}
 
 	if !validTypes[req.MessageType] {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid message_type. Must be one of: text, image, video, audio, document",
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid message_type. Must be one of: text, image, video, audio, document, location")
+		return
+	}
+
+	if wantsAsync(c) {
+		send := SendRequest{SessionID: sessionIDStr, To: req.To, Text: req.Content.Text}
+		switch req.MessageType {
+		case "location":
+			if req.Content.Latitude == nil || req.Content.Longitude == nil {
+				respondAPIError(c, apierr.ErrInvalidRequest, "Latitude and longitude are required for location messages")
+				return
+			}
+			send.Location = &LocationPayload{
+				Latitude:  *req.Content.Latitude,
+				Longitude: *req.Content.Longitude,
+				Name:      req.Content.Name,
+				Address:   req.Content.Address,
+			}
+		case "image", "video", "audio", "document":
+			send.MediaType = req.MessageType
+			send.MediaURL = req.Content.MediaURL
+			send.MediaBase64 = req.Content.MediaBase64
+			send.Filename = req.Content.Filename
+			send.Mimetype = req.Content.Mimetype
+			send.IsVoice = req.Content.IsVoice
+		}
+		h.enqueueSend(c, send)
+		return
+	}
+
+	// Handle location messages
+	if req.MessageType == "location" {
+		if req.Content.Latitude == nil || req.Content.Longitude == nil {
+			respondAPIError(c, apierr.ErrInvalidRequest, "Latitude and longitude are required for location messages")
+			return
+		}
+
+		if _, err := h.whatsappService.SendLocationMessage(sessionIDStr, userID, req.To, *req.Content.Latitude, *req.Content.Longitude, req.Content.Name, req.Content.Address); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data": gin.H{
+				"message": "Location message sent successfully",
+				"to":      req.To,
+				"type":    req.MessageType,
+			},
 		})
 		return
 	}
@@ -540,27 +887,27 @@ func (h *APIHandlers) SendMessageAdvanced(c *gin.Context) {
 	// Handle text messages
 	if req.MessageType == "text" {
 		if req.Content.Text == "" {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"success": false,
-				"error":   "Text content is required for text messages",
-			})
+			respondAPIError(c, apierr.ErrInvalidRequest, "Text content is required for text messages")
 			return
 		}
 
-		if err := h.whatsappService.SendMessage(sessionIDStr, userID, req.To, req.Content.Text); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"success": false,
-				"error":   err.Error(),
-			})
+		resp, err := h.whatsappService.SendMessage(sessionIDStr, userID, req.To, req.Content.Text)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
 			return
 		}
 
+		data := gin.H{
+			"message":    "Text message sent successfully",
+			"to":         req.To,
+			"message_id": resp.MessageID,
+		}
+		if len(resp.MessageIDs) > 0 {
+			data["message_ids"] = resp.MessageIDs
+		}
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
-			"data": gin.H{
-				"message": "Text message sent successfully",
-				"to":      req.To,
-			},
+			"data":    data,
 		})
 		return
 	}
@@ -579,10 +926,7 @@ func (h *APIHandlers) SendMessageAdvanced(c *gin.Context) {
 
 		mediaData, err = base64.StdEncoding.DecodeString(base64Data)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"success": false,
-				"error":   "Invalid base64 media data: " + err.Error(),
-			})
+			respondAPIError(c, apierr.ErrInvalidRequest, "Invalid base64 media data: "+err.Error())
 			return
 		}
 	} else if req.Content.MediaURL != "" {
@@ -590,169 +934,4463 @@ func (h *APIHandlers) SendMessageAdvanced(c *gin.Context) {
 		maxSize := h.getMaxSizeForType(req.MessageType)
 		mediaData, err = h.whatsappService.downloadMediaFromURL(req.Content.MediaURL, maxSize)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"success": false,
-				"error":   "Failed to download media: " + err.Error(),
-			})
+			respondAPIError(c, apierr.ErrInvalidRequest, "Failed to download media: "+err.Error())
 			return
 		}
 	} else {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Either media_url or media_base64 is required for media messages",
-		})
+		respondAPIError(c, apierr.ErrInvalidRequest, "Either media_url or media_base64 is required for media messages")
 		return
 	}
 
 	// Validate media size
 	maxSize := h.getMaxSizeForType(req.MessageType)
 	if int64(len(mediaData)) > maxSize {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   fmt.Sprintf("Media file too large: %d bytes (max %d bytes)", len(mediaData), maxSize),
-		})
+		respondAPIError(c, apierr.ErrMediaTooLarge, fmt.Sprintf("Media file too large: %d bytes (max %d bytes)", len(mediaData), maxSize))
 		return
 	}
 
 	// Send appropriate message type
 	switch req.MessageType {
 	case "image":
-		err = h.whatsappService.SendImageMessage(sessionIDStr, userID, req.To, mediaData, req.Content.Text)
+		_, err = h.whatsappService.SendImageMessage(sessionIDStr, userID, req.To, mediaData, req.Content.Text)
 	case "video":
-		err = h.whatsappService.SendVideoMessage(sessionIDStr, userID, req.To, mediaData, req.Content.Text)
+		_, err = h.whatsappService.SendVideoMessage(sessionIDStr, userID, req.To, mediaData, req.Content.Text)
 	case "audio":
-		err = h.whatsappService.SendAudioMessage(sessionIDStr, userID, req.To, mediaData, req.Content.IsVoice)
+		_, err = h.whatsappService.SendAudioMessage(sessionIDStr, userID, req.To, mediaData, req.Content.IsVoice)
 	case "document":
-		err = h.whatsappService.SendDocumentMessage(sessionIDStr, userID, req.To, mediaData, req.Content.Filename, req.Content.Mimetype)
+		_, err = h.whatsappService.SendDocumentMessage(sessionIDStr, userID, req.To, mediaData, req.Content.Filename, req.Content.Mimetype)
 	}
 
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"message": fmt.Sprintf("%s message sent successfully", strings.Title(req.MessageType)),
+			"to":      req.To,
+			"type":    req.MessageType,
+		},
+	})
+}
+
+// StartLiveLocation starts a live location share
+func (h *APIHandlers) StartLiveLocation(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		SessionID             string   `json:"session_id" binding:"required"`
+		To                    string   `json:"to" binding:"required"`
+		Latitude              *float64 `json:"latitude" binding:"required"`
+		Longitude             *float64 `json:"longitude" binding:"required"`
+		AccuracyMeters        uint32   `json:"accuracy_meters"`
+		Caption               string   `json:"caption"`
+		DurationSeconds       int      `json:"duration_seconds"`
+		UpdateIntervalSeconds int      `json:"update_interval_seconds"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	if _, err := uuid.Parse(req.SessionID); err != nil {
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
+		return
+	}
+
+	share, err := h.whatsappService.StartLiveLocation(
+		req.SessionID, userID, req.To,
+		*req.Latitude, *req.Longitude, req.AccuracyMeters, req.Caption,
+		time.Duration(req.DurationSeconds)*time.Second,
+		time.Duration(req.UpdateIntervalSeconds)*time.Second,
+	)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    share,
+	})
+}
+
+// UpdateLiveLocation pushes new coordinates to an active live location share
+func (h *APIHandlers) UpdateLiveLocation(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		SessionID      string   `json:"session_id" binding:"required"`
+		ShareID        string   `json:"share_id" binding:"required"`
+		Latitude       *float64 `json:"latitude" binding:"required"`
+		Longitude      *float64 `json:"longitude" binding:"required"`
+		AccuracyMeters uint32   `json:"accuracy_meters"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	share, err := h.whatsappService.UpdateLiveLocation(req.SessionID, userID, req.ShareID, *req.Latitude, *req.Longitude, req.AccuracyMeters)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    share,
+	})
+}
+
+// StopLiveLocation ends an active live location share
+func (h *APIHandlers) StopLiveLocation(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		SessionID string `json:"session_id" binding:"required"`
+		ShareID   string `json:"share_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	if err := h.whatsappService.StopLiveLocation(req.SessionID, userID, req.ShareID); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Live location sharing stopped",
+	})
+}
+
+// GetLiveLocations lists the active live location shares of a session
+func (h *APIHandlers) GetLiveLocations(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	if _, err := uuid.Parse(sessionIDStr); err != nil {
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    h.whatsappService.GetLiveLocationShares(sessionIDStr, userID),
+	})
+}
+
+// SendContact shares one or more contact cards
+func (h *APIHandlers) SendContact(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		SessionID string        `json:"session_id" binding:"required"`
+		To        string        `json:"to" binding:"required"`
+		Contact   *ContactCard  `json:"contact"`
+		Contacts  []ContactCard `json:"contacts"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	if _, err := uuid.Parse(req.SessionID); err != nil {
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
+		return
+	}
+
+	contacts := req.Contacts
+	if req.Contact != nil {
+		contacts = append([]ContactCard{*req.Contact}, contacts...)
+	}
+
+	if wantsAsync(c) {
+		h.enqueueSend(c, SendRequest{SessionID: req.SessionID, To: req.To, Contacts: contacts})
+		return
+	}
+
+	if _, err := h.whatsappService.SendContactMessage(req.SessionID, userID, req.To, contacts); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"message":  "Contact message sent successfully",
+			"to":       req.To,
+			"contacts": len(contacts),
+		},
+	})
+}
+
+// SendAuto sends a single polymorphic payload, picking the message type from
+// the fields that are set: location, contact(s), buttons, media handle,
+// media, then text
+func (h *APIHandlers) SendAuto(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req SendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	if wantsAsync(c) {
+		h.enqueueSend(c, req)
+		return
+	}
+
+	resp, err := h.whatsappService.DispatchSend(userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    resp,
+	})
+}
+
+// SendImage sends an image from a multipart upload, base64 or URL
+func (h *APIHandlers) SendImage(c *gin.Context) { h.sendMedia(c, "image") }
+
+// SendVideo sends a video from a multipart upload, base64 or URL
+func (h *APIHandlers) SendVideo(c *gin.Context) { h.sendMedia(c, "video") }
+
+// SendAudio sends an audio file or voice note from a multipart upload, base64 or URL
+func (h *APIHandlers) SendAudio(c *gin.Context) { h.sendMedia(c, "audio") }
+
+// SendDocument sends a document from a multipart upload, base64 or URL
+func (h *APIHandlers) SendDocument(c *gin.Context) { h.sendMedia(c, "document") }
+
+// sendMedia accepts either multipart/form-data with a "file" part (streamed to
+// WhatsApp) or a JSON body with media_id, media_base64 or media_url
+func (h *APIHandlers) sendMedia(c *gin.Context, mediaType string) {
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		h.sendMediaMultipart(c, mediaType)
+		return
+	}
+
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		SessionID   string `json:"session_id"`
+		ChannelID   int64  `json:"channel_id"` // instead of session_id
+		To          string `json:"to" binding:"required"`
+		Caption     string `json:"caption"`
+		MediaID     string `json:"media_id"`
+		MediaURL    string `json:"media_url"`
+		MediaBase64 string `json:"media_base64"`
+		Filename    string `json:"filename"`
+		Mimetype    string `json:"mimetype"`
+		IsVoice     bool   `json:"is_voice"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	if req.MediaID == "" && req.MediaURL == "" && req.MediaBase64 == "" {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Either a file upload, media_id, media_url or media_base64 is required")
+		return
+	}
+
+	send := SendRequest{
+		SessionID:   req.SessionID,
+		ChannelID:   req.ChannelID,
+		To:          req.To,
+		Text:        req.Caption,
+		MediaType:   mediaType,
+		MediaID:     req.MediaID,
+		MediaURL:    req.MediaURL,
+		MediaBase64: req.MediaBase64,
+		Filename:    req.Filename,
+		Mimetype:    req.Mimetype,
+		IsVoice:     req.IsVoice,
+	}
+	if wantsAsync(c) {
+		h.enqueueSend(c, send)
+		return
+	}
+
+	resp, err := h.whatsappService.DispatchSend(userID, send)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    resp,
+	})
+}
+
+// sendMediaMultipart streams the uploaded file to WhatsApp and sends it
+func (h *APIHandlers) sendMediaMultipart(c *gin.Context, mediaType string) {
+	userID := c.GetInt("user_id")
+
+	fields, media, ok := h.readMultipartMedia(c, mediaType)
+	if !ok {
+		return
+	}
+
+	if fields["to"] == "" {
+		respondAPIError(c, apierr.ErrInvalidRequest, "to is required")
+		return
+	}
+
+	isVoice, _ := strconv.ParseBool(fields["is_voice"])
+
+	// The file can't wait in the outbox, so it is kept as a media handle
+	if wantsAsync(c) {
+		handle, err := h.whatsappService.CreateMediaHandle(fields["session_id"], userID, media)
+		if err != nil {
+			chatActionError(c, err)
+			return
+		}
+		h.enqueueSend(c, SendRequest{
+			SessionID: fields["session_id"],
+			To:        fields["to"],
+			Text:      fields["caption"],
+			MediaType: mediaType,
+			MediaID:   handle.ID,
+			IsVoice:   isVoice,
+		})
+		return
+	}
+
+	resp, err := h.whatsappService.SendMediaUpload(fields["session_id"], userID, fields["to"], media, fields["caption"], isVoice)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    resp,
+	})
+}
+
+// UploadMedia uploads media once and returns a handle (media_id) that send and
+// broadcast requests can reference instead of uploading the file again
+func (h *APIHandlers) UploadMedia(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var (
+		sessionID string
+		media     *MediaUpload
+		err       error
+	)
+
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		fields, upload, ok := h.readMultipartMedia(c, "")
+		if !ok {
+			return
+		}
+		sessionID, media = fields["session_id"], upload
+	} else {
+		var req struct {
+			SessionID   string `json:"session_id" binding:"required"`
+			MediaType   string `json:"media_type" binding:"required"`
+			MediaURL    string `json:"media_url"`
+			MediaBase64 string `json:"media_base64"`
+			Filename    string `json:"filename"`
+			Mimetype    string `json:"mimetype"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+			return
+		}
+		if _, err := whatsmeowMediaType(req.MediaType); err != nil {
+			chatActionError(c, err)
+			return
+		}
+
+		mediaData, ok := h.readMediaPayload(c, req.MediaType, req.MediaBase64, req.MediaURL)
+		if !ok {
+			return
+		}
+
+		sessionID = req.SessionID
+		media, err = h.whatsappService.UploadMediaBytes(sessionID, userID, req.MediaType, mediaData, req.Mimetype, req.Filename)
+		if err != nil {
+			chatActionError(c, err)
+			return
+		}
+	}
+
+	handle, err := h.whatsappService.CreateMediaHandle(sessionID, userID, media)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    handle,
+	})
+}
+
+// readMediaPayload decodes media_base64 or downloads media_url, enforcing the
+// size limit of the media type. Writes the error response on failure.
+func (h *APIHandlers) readMediaPayload(c *gin.Context, mediaType, mediaBase64, mediaURL string) ([]byte, bool) {
+	maxSize := h.getMaxSizeForType(mediaType)
+
+	var (
+		mediaData []byte
+		err       error
+	)
+	if mediaBase64 != "" {
+		if idx := strings.Index(mediaBase64, ","); idx != -1 {
+			mediaBase64 = mediaBase64[idx+1:]
+		}
+		mediaData, err = base64.StdEncoding.DecodeString(mediaBase64)
+		if err != nil {
+			respondAPIError(c, apierr.ErrInvalidRequest, "Invalid base64 media data: "+err.Error())
+			return nil, false
+		}
+	} else if mediaURL != "" {
+		mediaData, err = h.whatsappService.downloadMediaFromURL(mediaURL, maxSize)
+		if err != nil {
+			respondAPIError(c, apierr.ErrInvalidRequest, "Failed to download media: "+err.Error())
+			return nil, false
+		}
+	} else {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Either a file upload, media_id, media_url or media_base64 is required")
+		return nil, false
+	}
+
+	if int64(len(mediaData)) > maxSize {
+		respondAPIError(c, apierr.ErrMediaTooLarge, fmt.Sprintf("Media file too large: %d bytes (max %d bytes)", len(mediaData), maxSize))
+		return nil, false
+	}
+	return mediaData, true
+}
+
+// readMultipartMedia reads the form part by part so the file is never held in
+// memory. Text fields (session_id, to, media_type, caption, filename,
+// mimetype, is_voice) must come before the "file" part. An empty mediaType is
+// taken from the media_type field. Writes the error response on failure.
+func (h *APIHandlers) readMultipartMedia(c *gin.Context, mediaType string) (map[string]string, *MediaUpload, bool) {
+	userID := c.GetInt("user_id")
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid multipart request: "+err.Error())
+		return nil, nil, false
+	}
+
+	fields := make(map[string]string)
+	var media *MediaUpload
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondAPIError(c, apierr.ErrInvalidRequest, "Invalid multipart request: "+err.Error())
+			return nil, nil, false
+		}
+
+		if part.FormName() != "file" {
+			value, err := io.ReadAll(io.LimitReader(part, 64*1024))
+			part.Close()
+			if err != nil {
+				respondAPIError(c, apierr.ErrInvalidRequest, "Invalid multipart request: "+err.Error())
+				return nil, nil, false
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+
+		if media != nil {
+			part.Close()
+			respondAPIError(c, apierr.ErrInvalidRequest, "Only one file can be sent per request")
+			return nil, nil, false
+		}
+
+		fileType := mediaType
+		if fileType == "" {
+			fileType = fields["media_type"]
+		}
+		if fields["session_id"] == "" {
+			part.Close()
+			respondAPIError(c, apierr.ErrInvalidRequest, "session_id must be sent before the file part")
+			return nil, nil, false
+		}
+		if fileType == "" {
+			part.Close()
+			respondAPIError(c, apierr.ErrInvalidRequest, "media_type must be sent before the file part")
+			return nil, nil, false
+		}
+
+		filename := fields["filename"]
+		if filename == "" {
+			filename = part.FileName()
+		}
+		mimetype := fields["mimetype"]
+		if mimetype == "" {
+			mimetype = part.Header.Get("Content-Type")
+		}
+
+		media, err = h.whatsappService.UploadMediaStream(fields["session_id"], userID, fileType, part, mimetype, filename)
+		part.Close()
+		if err != nil {
+			chatActionError(c, err)
+			return nil, nil, false
+		}
+	}
+
+	if media == nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "file is required")
+		return nil, nil, false
+	}
+	return fields, media, true
+}
+
+// GetGroupJoinRequests lists pending join requests of a group
+func (h *APIHandlers) GetGroupJoinRequests(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	requests, err := h.whatsappService.GetGroupJoinRequests(sessionIDStr, userID, groupID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"requests": requests,
+			"total":    len(requests),
+		},
+	})
+}
+
+// ApproveGroupJoinRequests approves pending join requests
+func (h *APIHandlers) ApproveGroupJoinRequests(c *gin.Context) {
+	h.updateGroupJoinRequests(c, true)
+}
+
+// RejectGroupJoinRequests rejects pending join requests
+func (h *APIHandlers) RejectGroupJoinRequests(c *gin.Context) {
+	h.updateGroupJoinRequests(c, false)
+}
+
+func (h *APIHandlers) updateGroupJoinRequests(c *gin.Context, approve bool) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	var req struct {
+		Participants []string `json:"participants" binding:"required,min=1"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	results, err := h.whatsappService.UpdateGroupJoinRequests(sessionIDStr, userID, groupID, req.Participants, approve)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"results": results,
+		},
+	})
+}
+
+// AddGroupParticipants adds participants to a group, optionally DMing the
+// invite link to those whose privacy settings refuse the add
+func (h *APIHandlers) AddGroupParticipants(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	var req GroupParticipantAdd
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	results, err := h.whatsappService.AddGroupParticipants(sessionIDStr, userID, groupID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	summary := make(map[string]int)
+	for _, result := range results {
+		summary[result.Status]++
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"results": results,
+			"summary": summary,
+		},
+	})
+}
+
+// CreateGroupAndInvite creates a group with the given participants. Those
+// whose privacy settings refuse the add are sent the invite link by DM; the
+// result lists the outcome per number.
+func (h *APIHandlers) CreateGroupAndInvite(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req GroupCreateInvite
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	result, err := h.whatsappService.CreateGroupAndInvite(userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// ExportGroupParticipants returns the current participants of a group with
+// phone numbers, names and admin flags as JSON or, with ?format=csv, as a
+// CSV download
+func (h *APIHandlers) ExportGroupParticipants(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	format := strings.ToLower(c.DefaultQuery("format", "json"))
+	if format != "json" && format != "csv" {
+		respondAPIError(c, apierr.ErrInvalidRequest, "format must be json or csv")
+		return
+	}
+
+	info, participants, err := h.whatsappService.GetGroupParticipants(sessionIDStr, userID, groupID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data": gin.H{
+				"group_jid":    info.JID.String(),
+				"group_name":   info.Name,
+				"participants": participants,
+				"total":        len(participants),
+			},
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-participants.csv"`, info.JID.User))
+	w := csv.NewWriter(c.Writer)
+	w.Write(groupParticipantCSVHeader)
+	for _, participant := range participants {
+		w.Write(participant.csvRecord())
+	}
+	w.Flush()
+}
+
+// ImportGroupParticipants saves the current participants of a group as a
+// named contact list
+func (h *APIHandlers) ImportGroupParticipants(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	list, err := h.whatsappService.ImportGroupParticipants(sessionIDStr, userID, groupID, req.Name)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    list,
+	})
+}
+
+// GetGroupAnalytics returns message counts per day, top senders and the last
+// activity of a group (?days=30, ?top=10)
+func (h *APIHandlers) GetGroupAnalytics(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "days must be a number")
+		return
+	}
+	top, err := strconv.Atoi(c.DefaultQuery("top", "10"))
+	if err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "top must be a number")
+		return
+	}
+
+	analytics, err := h.whatsappService.GetGroupAnalytics(sessionIDStr, userID, groupID, days, top)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    analytics,
+	})
+}
+
+// SetGroupJoinApproval toggles the membership approval mode of a group
+func (h *APIHandlers) SetGroupJoinApproval(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	var req struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	if err := h.whatsappService.SetGroupJoinApproval(sessionIDStr, userID, groupID, *req.Enabled); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"group_id":               groupID,
+			"join_approval_required": *req.Enabled,
+		},
+	})
+}
+
+// UpdateGroupSettings changes name, description, announce/locked mode, ephemeral timer,
+// member-add mode and join approval of a group
+func (h *APIHandlers) UpdateGroupSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	var req GroupSettingsUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	applied, err := h.whatsappService.UpdateGroupSettings(sessionIDStr, userID, groupID, req)
+	if err != nil {
+		apiErr := apiError(err, errorStatus(err))
+		c.JSON(apiErr.Status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+			"code":    apiErr.Code,
+			"applied": applied,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"group_id": groupID,
+			"applied":  applied,
+		},
+	})
+}
+
+// RevokeGroupInviteLink revokes the invite link of a group and returns the regenerated one
+func (h *APIHandlers) RevokeGroupInviteLink(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	link, err := h.whatsappService.RevokeGroupInviteLink(sessionIDStr, userID, groupID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"group_id":    groupID,
+			"invite_link": link,
+		},
+	})
+}
+
+// GetGroupInviteLink returns the current invite link of a group
+func (h *APIHandlers) GetGroupInviteLink(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	link, err := h.whatsappService.GetGroupInviteLink(sessionIDStr, userID, groupID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    link,
+	})
+}
+
+// GetGroupInviteLinks lists the recorded invite links of a group with their joins
+func (h *APIHandlers) GetGroupInviteLinks(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	links, err := h.whatsappService.GetGroupInviteLinks(sessionIDStr, userID, groupID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    links,
+	})
+}
+
+// GetGroupInviteInfo previews a group from an invite link without joining it
+func (h *APIHandlers) GetGroupInviteInfo(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Query("session_id")
+	link := c.Query("link")
+
+	if sessionIDStr == "" || link == "" {
+		respondAPIError(c, apierr.ErrInvalidRequest, "session_id and link query parameters are required")
+		return
+	}
+
+	preview, err := h.whatsappService.GetGroupInviteInfo(sessionIDStr, userID, link)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    preview,
+	})
+}
+
+// StartGroupSync starts or resumes syncing a session's groups; ?force=true
+// (or {"force": true}) also re-fetches recently synced groups
+func (h *APIHandlers) StartGroupSync(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Force bool `json:"force"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+			return
+		}
+	}
+	if force, err := strconv.ParseBool(c.DefaultQuery("force", "false")); err == nil && force {
+		req.Force = true
+	}
+
+	job, err := h.whatsappService.StartGroupSync(c.Param("session_id"), userID, req.Force)
+	if errors.Is(err, ErrGroupSyncRunning) {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
 			"error":   err.Error(),
+			"code":    apierr.ErrConflict.Code,
+			"data":    job,
+		})
+		return
+	}
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"data":    job,
+	})
+}
+
+// GetGroupSync returns the latest group sync job of a session
+func (h *APIHandlers) GetGroupSync(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	job, err := h.whatsappService.GetGroupSync(c.Param("session_id"), userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    job,
+	})
+}
+
+// GetGroupSchedule returns the quiet-hours window of a group
+func (h *APIHandlers) GetGroupSchedule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	schedule, err := h.whatsappService.GetGroupSchedule(sessionIDStr, userID, groupID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    schedule,
+	})
+}
+
+// SetGroupSchedule creates or replaces the quiet-hours window of a group
+func (h *APIHandlers) SetGroupSchedule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	var req GroupScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	schedule, err := h.whatsappService.SetGroupSchedule(sessionIDStr, userID, groupID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    schedule,
+	})
+}
+
+// EnableGroupSchedule enables the quiet-hours window of a group
+func (h *APIHandlers) EnableGroupSchedule(c *gin.Context) {
+	h.setGroupScheduleEnabled(c, true)
+}
+
+// DisableGroupSchedule disables the quiet-hours window of a group
+func (h *APIHandlers) DisableGroupSchedule(c *gin.Context) {
+	h.setGroupScheduleEnabled(c, false)
+}
+
+func (h *APIHandlers) setGroupScheduleEnabled(c *gin.Context, enabled bool) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	schedule, err := h.whatsappService.SetGroupScheduleEnabled(sessionIDStr, userID, groupID, enabled)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    schedule,
+	})
+}
+
+// DeleteGroupSchedule removes the quiet-hours window of a group
+func (h *APIHandlers) DeleteGroupSchedule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	groupID := c.Param("group_id")
+
+	if err := h.whatsappService.DeleteGroupSchedule(sessionIDStr, userID, groupID); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Schedule deleted",
+	})
+}
+
+// GetSuppressions lists the phone numbers the user must not message
+// (sort: created_at, phone; filter: reason; ?q= searches the number)
+func (h *APIHandlers) GetSuppressions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	q, ok := parseListRequest(c, listSortFields{
+		"created_at": "created_at",
+		"phone":      "phone",
+	}, "-created_at", "reason")
+	if !ok {
+		return
+	}
+
+	suppressions, total, err := h.whatsappService.GetSuppressions(userID, q)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"data":       suppressions,
+		"pagination": q.Meta(total),
+	})
+}
+
+// AddSuppressions adds phone numbers to the suppression list
+func (h *APIHandlers) AddSuppressions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		PhoneNumbers []string `json:"phone_numbers" binding:"required,min=1,max=1000"`
+		Reason       string   `json:"reason"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	added, existing, invalid, err := h.whatsappService.AddSuppressions(userID, req.PhoneNumbers, req.Reason)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"added":              added,
+			"already_suppressed": existing,
+			"invalid":            invalid,
+		},
+	})
+}
+
+// RemoveSuppression takes a phone number off the suppression list
+func (h *APIHandlers) RemoveSuppression(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	if err := h.whatsappService.RemoveSuppression(userID, c.Param("phone")); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Suppression removed",
+	})
+}
+
+// SyncContacts writes the new and renamed contacts of a session's contact
+// store now instead of waiting for the periodic sync
+func (h *APIHandlers) SyncContacts(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	result, err := h.whatsappService.SyncContacts(c.Param("session_id"), userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// GetBlocklist lists the stored blocklist of a session
+func (h *APIHandlers) GetBlocklist(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	blocked, err := h.whatsappService.GetBlocklist(c.Param("session_id"), userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"blocked": blocked,
+			"total":   len(blocked),
+		},
+	})
+}
+
+// SyncBlocklist reconciles the stored blocklist of a session with WhatsApp
+func (h *APIHandlers) SyncBlocklist(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	result, err := h.whatsappService.SyncBlocklist(c.Param("session_id"), userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// ImportContacts imports a CSV file (phone, name and custom columns) as a
+// named contact list. Accepts multipart/form-data with "name" and a "file"
+// part, or a raw text/csv body with ?name=.
+func (h *APIHandlers) ImportContacts(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	var (
+		name string
+		body io.Reader
+	)
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		file, err := c.FormFile("file")
+		if err != nil {
+			respondAPIError(c, apierr.ErrInvalidRequest, "file is required")
+			return
+		}
+		if file.Size > contactImportMaxSize {
+			respondAPIError(c, apierr.ErrPayloadTooLarge, fmt.Sprintf("CSV file is larger than %d bytes", contactImportMaxSize))
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			respondAPIError(c, apierr.ErrInvalidRequest, "failed to read file")
+			return
+		}
+		defer f.Close()
+		name, body = c.PostForm("name"), f
+	} else {
+		name = c.Query("name")
+		body = http.MaxBytesReader(c.Writer, c.Request.Body, contactImportMaxSize)
+	}
+
+	result, err := h.whatsappService.ImportContactList(sessionIDStr, userID, name, body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondAPIError(c, apierr.ErrPayloadTooLarge, fmt.Sprintf("CSV file is larger than %d bytes", contactImportMaxSize))
+			return
+		}
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// GetContactLists lists the user's contact lists without their members
+func (h *APIHandlers) GetContactLists(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	lists, err := h.db.GetContactLists(userID)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to load contact lists")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    lists,
+	})
+}
+
+// GetContactList returns a contact list with its members (?status= filters them)
+func (h *APIHandlers) GetContactList(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	listID, ok := parseListID(c)
+	if !ok {
+		return
+	}
+
+	list, err := h.whatsappService.GetContactList(userID, listID, c.Query("status"))
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    list,
+	})
+}
+
+// DeleteContactList deletes a contact list
+func (h *APIHandlers) DeleteContactList(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	listID, ok := parseListID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.whatsappService.DeleteContactList(userID, listID); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Contact list deleted",
+	})
+}
+
+func parseCampaignID(c *gin.Context) (int64, bool) {
+	campaignID, err := strconv.ParseInt(c.Param("campaign_id"), 10, 64)
+	if err != nil || campaignID <= 0 {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid campaign ID")
+		return 0, false
+	}
+	return campaignID, true
+}
+
+// PreviewTemplate renders a message template with sample values
+func (h *APIHandlers) PreviewTemplate(c *gin.Context) {
+	var req TemplatePreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	rendered, err := h.whatsappService.PreviewTemplate(req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    rendered,
+	})
+}
+
+// CreateCampaign queues a bulk send to raw recipients, a contact list or a segment
+func (h *APIHandlers) CreateCampaign(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req CampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	campaign, err := h.whatsappService.CreateCampaign(userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"data":    campaign,
+	})
+}
+
+// GetCampaigns lists the user's campaigns
+// (sort: created_at, name, status; filters: session_id, status; ?q= searches
+// the name)
+func (h *APIHandlers) GetCampaigns(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	q, ok := parseListRequest(c, listSortFields{
+		"created_at": "created_at",
+		"name":       "name",
+		"status":     "status",
+	}, "-created_at", "session_id", "status")
+	if !ok {
+		return
+	}
+
+	campaigns, total, err := h.db.GetCampaigns(userID, q)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to load campaigns")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"data":       campaigns,
+		"pagination": q.Meta(total),
+	})
+}
+
+// GetCampaign returns a campaign and its delivery counters
+func (h *APIHandlers) GetCampaign(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	campaignID, ok := parseCampaignID(c)
+	if !ok {
+		return
+	}
+
+	campaign, err := h.whatsappService.GetCampaign(userID, campaignID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    campaign,
+	})
+}
+
+// GetCampaignRecipients lists per-recipient results (?status= filters them)
+func (h *APIHandlers) GetCampaignRecipients(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	campaignID, ok := parseCampaignID(c)
+	if !ok {
+		return
+	}
+
+	recipients, err := h.whatsappService.GetCampaignRecipients(userID, campaignID, c.Query("status"))
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    recipients,
+	})
+}
+
+// CancelCampaign stops a running campaign
+func (h *APIHandlers) CancelCampaign(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	campaignID, ok := parseCampaignID(c)
+	if !ok {
+		return
+	}
+
+	campaign, err := h.whatsappService.CancelCampaign(userID, campaignID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    campaign,
+	})
+}
+
+// RetryFailedCampaign requeues the failed recipients of a campaign
+func (h *APIHandlers) RetryFailedCampaign(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	campaignID, ok := parseCampaignID(c)
+	if !ok {
+		return
+	}
+
+	campaign, requeued, err := h.whatsappService.RetryFailedCampaign(userID, campaignID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"data":     campaign,
+		"requeued": requeued,
+	})
+}
+
+// parseStatusPostID parses the :status_id route parameter
+func parseStatusPostID(c *gin.Context) (int64, bool) {
+	postID, err := strconv.ParseInt(c.Param("status_id"), 10, 64)
+	if err != nil || postID <= 0 {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid status ID")
+		return 0, false
+	}
+	return postID, true
+}
+
+// CreateStatusPost posts a status now or schedules it
+func (h *APIHandlers) CreateStatusPost(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	var req StatusPostRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	post, err := h.whatsappService.CreateStatusPost(sessionIDStr, userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"data":    post,
+	})
+}
+
+// GetStatusPosts lists the status posts of a session
+// (sort: scheduled_at, created_at; filters: status; ?q= searches the text)
+func (h *APIHandlers) GetStatusPosts(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	if _, err := uuid.Parse(sessionIDStr); err != nil {
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
+		return
+	}
+
+	q, ok := parseListRequest(c, listSortFields{
+		"scheduled_at": "scheduled_at",
+		"created_at":   "created_at",
+	}, "-scheduled_at", "status")
+	if !ok {
+		return
+	}
+
+	posts, total, err := h.db.GetStatusPosts(sessionIDStr, userID, q)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to load status posts")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"data":       posts,
+		"pagination": q.Meta(total),
+	})
+}
+
+// GetStatusPost returns a status post
+func (h *APIHandlers) GetStatusPost(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	postID, ok := parseStatusPostID(c)
+	if !ok {
+		return
+	}
+
+	post, err := h.whatsappService.GetStatusPost(sessionIDStr, userID, postID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    post,
+	})
+}
+
+// CancelStatusPost cancels a scheduled status post
+func (h *APIHandlers) CancelStatusPost(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	postID, ok := parseStatusPostID(c)
+	if !ok {
+		return
+	}
+
+	post, err := h.whatsappService.CancelStatusPost(sessionIDStr, userID, postID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    post,
+	})
+}
+
+// parseSegmentID parses the :segment_id route parameter
+func parseSegmentID(c *gin.Context) (int64, bool) {
+	segmentID, err := strconv.ParseInt(c.Param("segment_id"), 10, 64)
+	if err != nil || segmentID <= 0 {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid segment ID")
+		return 0, false
+	}
+	return segmentID, true
+}
+
+// CreateSegment saves a contact filter
+func (h *APIHandlers) CreateSegment(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req SegmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	segment, err := h.whatsappService.CreateSegment(userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    segment,
+	})
+}
+
+// GetSegments lists the user's segments
+func (h *APIHandlers) GetSegments(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	segments, err := h.db.GetSegments(userID)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to load segments")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    segments,
+	})
+}
+
+// GetSegment returns a segment
+func (h *APIHandlers) GetSegment(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	segmentID, ok := parseSegmentID(c)
+	if !ok {
+		return
+	}
+
+	segment, err := h.whatsappService.GetSegment(userID, segmentID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    segment,
+	})
+}
+
+// UpdateSegment replaces a segment's name, description and filter
+func (h *APIHandlers) UpdateSegment(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	segmentID, ok := parseSegmentID(c)
+	if !ok {
+		return
+	}
+
+	var req SegmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	segment, err := h.whatsappService.UpdateSegment(userID, segmentID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    segment,
+	})
+}
+
+// DeleteSegment deletes a segment
+func (h *APIHandlers) DeleteSegment(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	segmentID, ok := parseSegmentID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.whatsappService.DeleteSegment(userID, segmentID); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Segment deleted",
+	})
+}
+
+// parseChannelID reads the channel ID path parameter, answering 400 if it's invalid
+func parseChannelID(c *gin.Context) (int64, bool) {
+	channelID, err := strconv.ParseInt(c.Param("channel_id"), 10, 64)
+	if err != nil || channelID <= 0 {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid channel ID")
+		return 0, false
+	}
+	return channelID, true
+}
+
+// CreateChannel pairs a primary and a backup session as a channel
+func (h *APIHandlers) CreateChannel(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req ChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	channel, err := h.whatsappService.CreateChannel(userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    channel,
+	})
+}
+
+// GetChannels lists the user's channels
+func (h *APIHandlers) GetChannels(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	channels, err := h.db.GetChannels(userID)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to load channels")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    channels,
+	})
+}
+
+// GetChannel returns a channel with its active session
+func (h *APIHandlers) GetChannel(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	channelID, ok := parseChannelID(c)
+	if !ok {
+		return
+	}
+
+	channel, err := h.whatsappService.GetChannel(userID, channelID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    channel,
+	})
+}
+
+// DeleteChannel removes a channel
+func (h *APIHandlers) DeleteChannel(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	channelID, ok := parseChannelID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.whatsappService.DeleteChannel(userID, channelID); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Channel deleted",
+	})
+}
+
+// parseCannedResponseID reads the canned response ID path parameter,
+// answering 400 if it's invalid
+func parseCannedResponseID(c *gin.Context) (int64, bool) {
+	responseID, err := strconv.ParseInt(c.Param("response_id"), 10, 64)
+	if err != nil || responseID <= 0 {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid canned response ID")
+		return 0, false
+	}
+	return responseID, true
+}
+
+// CreateCannedResponse saves a canned response
+func (h *APIHandlers) CreateCannedResponse(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req CannedResponseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	response, err := h.whatsappService.CreateCannedResponse(userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    response,
+	})
+}
+
+// GetCannedResponses lists the user's canned responses with their usage
+// (sort: shortcut, uses, last_used_at, created_at; filter: shortcut; ?q=
+// searches shortcut, title and text)
+func (h *APIHandlers) GetCannedResponses(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	q, ok := parseListRequest(c, listSortFields{
+		"shortcut":     "shortcut",
+		"uses":         "use_count",
+		"last_used_at": "last_used_at",
+		"created_at":   "created_at",
+	}, "shortcut", "shortcut")
+	if !ok {
+		return
+	}
+
+	responses, total, err := h.whatsappService.GetCannedResponses(userID, q)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"data":       responses,
+		"pagination": q.Meta(total),
+	})
+}
+
+// GetCannedResponse returns a canned response with its usage
+func (h *APIHandlers) GetCannedResponse(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	responseID, ok := parseCannedResponseID(c)
+	if !ok {
+		return
+	}
+
+	response, err := h.whatsappService.GetCannedResponse(userID, responseID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    response,
+	})
+}
+
+// UpdateCannedResponse replaces the shortcut, title and text of a canned
+// response
+func (h *APIHandlers) UpdateCannedResponse(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	responseID, ok := parseCannedResponseID(c)
+	if !ok {
+		return
+	}
+
+	var req CannedResponseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	response, err := h.whatsappService.UpdateCannedResponse(userID, responseID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    response,
+	})
+}
+
+// DeleteCannedResponse removes a canned response
+func (h *APIHandlers) DeleteCannedResponse(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	responseID, ok := parseCannedResponseID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.whatsappService.DeleteCannedResponse(userID, responseID); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Canned response deleted",
+	})
+}
+
+// parseSequenceID reads the sequence ID path parameter, answering 400 if it's
+// invalid
+func parseSequenceID(c *gin.Context) (int64, bool) {
+	sequenceID, err := strconv.ParseInt(c.Param("sequence_id"), 10, 64)
+	if err != nil || sequenceID <= 0 {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid sequence ID")
+		return 0, false
+	}
+	return sequenceID, true
+}
+
+// CreateSequence saves a sequence of timed messages
+func (h *APIHandlers) CreateSequence(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req SequenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	sequence, err := h.whatsappService.CreateSequence(userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    sequence,
+	})
+}
+
+// GetSequences lists the user's sequences
+func (h *APIHandlers) GetSequences(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	sequences, err := h.whatsappService.GetSequences(userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    sequences,
+	})
+}
+
+// GetSequence returns a sequence with its steps
+func (h *APIHandlers) GetSequence(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	sequenceID, ok := parseSequenceID(c)
+	if !ok {
+		return
+	}
+
+	sequence, err := h.whatsappService.GetSequence(userID, sequenceID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    sequence,
+	})
+}
+
+// UpdateSequence replaces the name, steps and state of a sequence
+func (h *APIHandlers) UpdateSequence(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	sequenceID, ok := parseSequenceID(c)
+	if !ok {
+		return
+	}
+
+	var req SequenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	sequence, err := h.whatsappService.UpdateSequence(userID, sequenceID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    sequence,
+	})
+}
+
+// DeleteSequence removes a sequence and its enrollments
+func (h *APIHandlers) DeleteSequence(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	sequenceID, ok := parseSequenceID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.whatsappService.DeleteSequence(userID, sequenceID); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Sequence deleted",
+	})
+}
+
+// EnrollInSequence enrolls contacts in a sequence
+func (h *APIHandlers) EnrollInSequence(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	sequenceID, ok := parseSequenceID(c)
+	if !ok {
+		return
+	}
+
+	var req EnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	enrolled, invalid, err := h.whatsappService.EnrollContacts(userID, sequenceID, req.Contacts)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"enrolled": enrolled,
+			"invalid":  invalid,
+		},
+	})
+}
+
+// GetSequenceEnrollments lists the enrollments of a sequence with their
+// progress (sort: enrolled_at, next_run_at; filter: status; ?q= searches the
+// contact JID)
+func (h *APIHandlers) GetSequenceEnrollments(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	sequenceID, ok := parseSequenceID(c)
+	if !ok {
+		return
+	}
+
+	q, ok := parseListRequest(c, listSortFields{
+		"enrolled_at": "enrolled_at",
+		"next_run_at": "next_run_at",
+	}, "-enrolled_at", "status")
+	if !ok {
+		return
+	}
+
+	enrollments, total, err := h.whatsappService.GetEnrollments(userID, sequenceID, q)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"data":       enrollments,
+		"pagination": q.Meta(total),
+	})
+}
+
+// UnenrollFromSequence cancels a contact's active enrollment in a sequence
+func (h *APIHandlers) UnenrollFromSequence(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	sequenceID, ok := parseSequenceID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.whatsappService.UnenrollContact(userID, sequenceID, c.Param("contact")); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Contact unenrolled",
+	})
+}
+
+// parseProjectID reads the project ID path parameter, answering 400 if it's invalid
+func parseProjectID(c *gin.Context) (int64, bool) {
+	projectID, err := strconv.ParseInt(c.Param("project_id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid project ID")
+		return 0, false
+	}
+	return projectID, true
+}
+
+// CreateProject creates a project to group sessions in
+func (h *APIHandlers) CreateProject(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req ProjectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	project, err := h.whatsappService.CreateProject(userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    project,
+	})
+}
+
+// GetProjects lists the user's projects with their session counts
+func (h *APIHandlers) GetProjects(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	projects, err := h.whatsappService.GetProjects(userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    projects,
+	})
+}
+
+// GetProject returns a project
+func (h *APIHandlers) GetProject(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	projectID, ok := parseProjectID(c)
+	if !ok {
+		return
+	}
+
+	project, err := h.whatsappService.GetProject(userID, projectID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    project,
+	})
+}
+
+// UpdateProject renames a project or changes its description
+func (h *APIHandlers) UpdateProject(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	projectID, ok := parseProjectID(c)
+	if !ok {
+		return
+	}
+
+	var req ProjectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	project, err := h.whatsappService.UpdateProject(userID, projectID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    project,
+	})
+}
+
+// DeleteProject removes a project; its sessions are kept without one
+func (h *APIHandlers) DeleteProject(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	projectID, ok := parseProjectID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.whatsappService.DeleteProject(userID, projectID); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Project deleted",
+	})
+}
+
+// GetProjectSummary returns the device summary of a project
+func (h *APIHandlers) GetProjectSummary(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	projectID, ok := parseProjectID(c)
+	if !ok {
+		return
+	}
+
+	summary, err := h.whatsappService.GetProjectDeviceSummary(userID, projectID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    summary,
+	})
+}
+
+// SetSessionProject moves a session to a project ({"project_id": null}
+// takes it out of its project)
+func (h *APIHandlers) SetSessionProject(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionID := c.Param("session_id")
+
+	var req struct {
+		ProjectID *int64 `json:"project_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	if err := h.whatsappService.SetSessionProject(sessionID, userID, req.ProjectID); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"session_id": sessionID,
+			"project_id": req.ProjectID,
+		},
+	})
+}
+
+// GetSegmentContacts previews the contacts currently matching a segment
+func (h *APIHandlers) GetSegmentContacts(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	segmentID, ok := parseSegmentID(c)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+
+	contacts, total, err := h.whatsappService.GetSegmentContacts(userID, segmentID, limit)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"contacts": contacts,
+			"total":    total,
+		},
+	})
+}
+
+// GetContacts lists the user's contacts with their tags
+// (sort: name, number, jid, created_at; filter: tag; ?q= searches name,
+// number and JID)
+func (h *APIHandlers) GetContacts(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	q, ok := parseListRequest(c, listSortFields{
+		"name":       "full_name",
+		"number":     "mobile_number",
+		"jid":        "jid",
+		"created_at": "created_at",
+	}, "name", "tag")
+	if !ok {
+		return
+	}
+
+	contacts, total, err := h.whatsappService.GetContacts(userID, q)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"data":       contacts,
+		"pagination": q.Meta(total),
+	})
+}
+
+// GetGroups lists the groups stored for the user's sessions
+// (sort: name, participants, created_at; filter: session_id; ?q= searches
+// name and JID)
+func (h *APIHandlers) GetGroups(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	q, ok := parseListRequest(c, listSortFields{
+		"name":         "group_name",
+		"participants": "participant_count",
+		"created_at":   "created_at",
+	}, "name", "session_id")
+	if !ok {
+		return
+	}
+
+	groups, total, err := h.db.GetUserGroups(userID, q)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to load groups")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"data":       groups,
+		"pagination": q.Meta(total),
+	})
+}
+
+// GetTags lists the user's tags with the number of contacts carrying each
+func (h *APIHandlers) GetTags(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	tags, err := h.db.GetTagCounts(userID)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to load tags")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    tags,
+	})
+}
+
+// GetContactTags lists the tags of a contact (:jid may be a phone number)
+func (h *APIHandlers) GetContactTags(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	tags, err := h.whatsappService.GetContactTags(userID, c.Param("jid"))
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    tags,
+	})
+}
+
+// AddContactTags tags a contact
+func (h *APIHandlers) AddContactTags(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		Tags []string `json:"tags" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	tags, err := h.whatsappService.AddContactTags(userID, c.Param("jid"), req.Tags)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    tags,
+	})
+}
+
+// GetContactNotes lists the internal notes on a contact
+func (h *APIHandlers) GetContactNotes(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	notes, err := h.whatsappService.GetContactNotes(userID, c.Param("jid"))
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    notes,
+	})
+}
+
+// AddContactNote leaves an internal note on a contact
+func (h *APIHandlers) AddContactNote(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req NoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	note, err := h.whatsappService.AddContactNote(userID, c.Param("jid"), req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    note,
+	})
+}
+
+// GetChatNotes lists the internal notes on a chat
+func (h *APIHandlers) GetChatNotes(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	notes, err := h.whatsappService.GetChatNotes(c.Param("session_id"), userID, c.Param("jid"))
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    notes,
+	})
+}
+
+// AddChatNote leaves an internal note on a chat
+func (h *APIHandlers) AddChatNote(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req NoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	note, err := h.whatsappService.AddChatNote(c.Param("session_id"), userID, c.Param("jid"), req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    note,
+	})
+}
+
+// parseNoteID parses the :note_id route parameter
+func parseNoteID(c *gin.Context) (int64, bool) {
+	noteID, err := strconv.ParseInt(c.Param("note_id"), 10, 64)
+	if err != nil || noteID <= 0 {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid note ID")
+		return 0, false
+	}
+	return noteID, true
+}
+
+// UpdateNote replaces the text and author of a note
+func (h *APIHandlers) UpdateNote(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	noteID, ok := parseNoteID(c)
+	if !ok {
+		return
+	}
+
+	var req NoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	note, err := h.whatsappService.UpdateNote(userID, noteID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    note,
+	})
+}
+
+// DeleteNote removes a note
+func (h *APIHandlers) DeleteNote(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	noteID, ok := parseNoteID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.whatsappService.DeleteNote(userID, noteID); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Note deleted",
+	})
+}
+
+// RemoveContactTag removes a tag from a contact
+func (h *APIHandlers) RemoveContactTag(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	if err := h.whatsappService.RemoveContactTag(userID, c.Param("jid"), c.Param("tag")); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Tag removed",
+	})
+}
+
+// parseRuleID parses the :rule_id route parameter
+func parseRuleID(c *gin.Context) (int64, bool) {
+	ruleID, err := strconv.ParseInt(c.Param("rule_id"), 10, 64)
+	if err != nil || ruleID <= 0 {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid rule ID")
+		return 0, false
+	}
+	return ruleID, true
+}
+
+// CreateAutoReplyRule adds a keyword rule that replies to and/or tags senders
+func (h *APIHandlers) CreateAutoReplyRule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req AutoReplyRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	rule, err := h.whatsappService.CreateAutoReplyRule(userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    rule,
+	})
+}
+
+// GetAutoReplyRules lists the user's auto-reply rules in evaluation order
+func (h *APIHandlers) GetAutoReplyRules(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	rules, err := h.db.GetAutoReplyRules(userID)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to load auto-reply rules")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    rules,
+	})
+}
+
+// GetAutoReplyRule returns an auto-reply rule
+func (h *APIHandlers) GetAutoReplyRule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	ruleID, ok := parseRuleID(c)
+	if !ok {
+		return
+	}
+
+	rule, err := h.whatsappService.GetAutoReplyRule(userID, ruleID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    rule,
+	})
+}
+
+// UpdateAutoReplyRule replaces an auto-reply rule
+func (h *APIHandlers) UpdateAutoReplyRule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	ruleID, ok := parseRuleID(c)
+	if !ok {
+		return
+	}
+
+	var req AutoReplyRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	rule, err := h.whatsappService.UpdateAutoReplyRule(userID, ruleID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    rule,
+	})
+}
+
+// DeleteAutoReplyRule deletes an auto-reply rule
+func (h *APIHandlers) DeleteAutoReplyRule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	ruleID, ok := parseRuleID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.whatsappService.DeleteAutoReplyRule(userID, ruleID); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Auto-reply rule deleted",
+	})
+}
+
+// CreateScoringRule saves a lead scoring rule
+func (h *APIHandlers) CreateScoringRule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req ScoringRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	rule, err := h.whatsappService.CreateScoringRule(userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    rule,
+	})
+}
+
+// GetScoringRules lists the user's scoring rules
+func (h *APIHandlers) GetScoringRules(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	rules, err := h.db.GetScoringRules(userID)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to load scoring rules")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    rules,
+	})
+}
+
+// GetScoringRule returns a scoring rule
+func (h *APIHandlers) GetScoringRule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	ruleID, ok := parseRuleID(c)
+	if !ok {
+		return
+	}
+
+	rule, err := h.whatsappService.GetScoringRule(userID, ruleID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    rule,
+	})
+}
+
+// UpdateScoringRule replaces a scoring rule
+func (h *APIHandlers) UpdateScoringRule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	ruleID, ok := parseRuleID(c)
+	if !ok {
+		return
+	}
+
+	var req ScoringRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	rule, err := h.whatsappService.UpdateScoringRule(userID, ruleID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    rule,
+	})
+}
+
+// DeleteScoringRule deletes a scoring rule
+func (h *APIHandlers) DeleteScoringRule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	ruleID, ok := parseRuleID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.whatsappService.DeleteScoringRule(userID, ruleID); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Scoring rule deleted",
+	})
+}
+
+// GetLeadSettings returns the user's hot lead threshold and webhook
+func (h *APIHandlers) GetLeadSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	settings, err := h.whatsappService.GetLeadSettings(userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// UpdateLeadSettings changes the user's hot lead threshold and webhook
+func (h *APIHandlers) UpdateLeadSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req LeadSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	settings, err := h.whatsappService.UpdateLeadSettings(userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// GetContactScores lists the user's scored contacts (sort: score,
+// last_scored_at, hot_lead_at; filter: hot; ?q= searches the JID)
+func (h *APIHandlers) GetContactScores(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	q, ok := parseListRequest(c, listSortFields{
+		"score":          "score",
+		"last_scored_at": "last_scored_at",
+		"hot_lead_at":    "hot_lead_at",
+	}, "-score", "hot")
+	if !ok {
+		return
+	}
+
+	scores, total, err := h.whatsappService.GetContactScores(userID, q)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"data":       scores,
+		"pagination": q.Meta(total),
+	})
+}
+
+// GetContactScore returns the score of a contact
+func (h *APIHandlers) GetContactScore(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	score, err := h.whatsappService.GetContactScore(userID, c.Param("jid"))
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    score,
+	})
+}
+
+// ResetContactScore sets the score of a contact back to 0
+func (h *APIHandlers) ResetContactScore(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	if err := h.whatsappService.ResetContactScore(userID, c.Param("jid")); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Contact score reset",
+	})
+}
+
+// GetSessionSafety returns the send budget, warm-up day and pause state of a session
+func (h *APIHandlers) GetSessionSafety(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	status, err := h.whatsappService.GetSessionSafety(c.Param("session_id"), userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    status,
+	})
+}
+
+// UpdateSessionSafety sets the warm-up profile or daily limit of a session
+func (h *APIHandlers) UpdateSessionSafety(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req SafetySettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	status, err := h.whatsappService.UpdateSessionSafety(c.Param("session_id"), userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    status,
+	})
+}
+
+// ResumeSessionSafety lifts a pause caused by a spike in send failures
+func (h *APIHandlers) ResumeSessionSafety(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	status, err := h.whatsappService.ResumeSessionSafety(c.Param("session_id"), userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    status,
+	})
+}
+
+// GetCallSettings returns the auto-reject settings of a session
+func (h *APIHandlers) GetCallSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	settings, err := h.whatsappService.GetCallSettings(c.Param("session_id"), userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// UpdateCallSettings changes the auto-reject settings of a session
+func (h *APIHandlers) UpdateCallSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req CallSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	settings, err := h.whatsappService.UpdateCallSettings(c.Param("session_id"), userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// GetInboundFilter returns the inbound filter settings of a session
+func (h *APIHandlers) GetInboundFilter(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	settings, err := h.whatsappService.GetInboundFilter(c.Param("session_id"), userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// UpdateInboundFilter changes what happens to messages from unknown senders
+func (h *APIHandlers) UpdateInboundFilter(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req InboundFilterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	settings, err := h.whatsappService.UpdateInboundFilter(c.Param("session_id"), userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// GetSenderRules lists the allowlist and denylist of a session's inbound
+// filter (sort: created_at, jid; filter: list; ?q= searches the JID)
+func (h *APIHandlers) GetSenderRules(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	q, ok := parseListRequest(c, listSortFields{
+		"created_at": "created_at",
+		"jid":        "jid",
+	}, "-created_at", "list")
+	if !ok {
+		return
+	}
+
+	rules, total, err := h.whatsappService.GetSenderRules(c.Param("session_id"), userID, q)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"data":       rules,
+		"pagination": q.Meta(total),
+	})
+}
+
+// AddSenderRules puts senders on the allowlist or denylist of a session
+func (h *APIHandlers) AddSenderRules(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req SenderRulesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	added, invalid, err := h.whatsappService.AddSenderRules(c.Param("session_id"), userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"added":   added,
+			"invalid": invalid,
+		},
+	})
+}
+
+// RemoveSenderRule takes a sender off the allowlist or denylist of a session
+func (h *APIHandlers) RemoveSenderRule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	if err := h.whatsappService.RemoveSenderRule(c.Param("session_id"), userID, c.Param("sender")); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Sender rule removed",
+	})
+}
+
+// GetReadReceiptSettings returns the read receipt settings of a session
+func (h *APIHandlers) GetReadReceiptSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	settings, err := h.whatsappService.GetReadReceiptSettings(c.Param("session_id"), userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// UpdateReadReceiptSettings changes whether a session sends read receipts
+// and the account's read receipts privacy setting
+func (h *APIHandlers) UpdateReadReceiptSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req ReadReceiptSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	settings, err := h.whatsappService.UpdateReadReceiptSettings(c.Param("session_id"), userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// GetCanarySettings returns the health canary settings and state of a session
+func (h *APIHandlers) GetCanarySettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	settings, err := h.whatsappService.GetCanarySettings(c.Param("session_id"), userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// UpdateCanarySettings sets or clears the health canary target of a session
+func (h *APIHandlers) UpdateCanarySettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req CanarySettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	settings, err := h.whatsappService.UpdateCanarySettings(c.Param("session_id"), userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// RunCanary runs the health canary of a session now and returns its state
+func (h *APIHandlers) RunCanary(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	state, err := h.whatsappService.RunCanary(c.Param("session_id"), userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    state,
+	})
+}
+
+// GetSessionDevice returns the linked device metadata of a session
+func (h *APIHandlers) GetSessionDevice(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	device, err := h.whatsappService.GetSessionDevice(c.Param("session_id"), userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    device,
+	})
+}
+
+// GetOutboxSettings returns the outbox settings of a session
+func (h *APIHandlers) GetOutboxSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	settings, err := h.whatsappService.GetOutboxSettings(c.Param("session_id"), userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// UpdateOutboxSettings changes how long queued sends of a session may wait
+func (h *APIHandlers) UpdateOutboxSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req OutboxSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	settings, err := h.whatsappService.UpdateOutboxSettings(c.Param("session_id"), userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// GetEventFilters lists the user's default event filter and those of single
+// sessions
+func (h *APIHandlers) GetEventFilters(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	filters, err := h.whatsappService.GetEventFilters(userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    filters,
+	})
+}
+
+// SetEventFilter replaces the user's default event filter, or that of the
+// session in the path
+func (h *APIHandlers) SetEventFilter(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var rules EventFilterRules
+	if err := c.ShouldBindJSON(&rules); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	filter, err := h.whatsappService.SetEventFilter(userID, c.Param("session_id"), rules)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    filter,
+	})
+}
+
+// DeleteEventFilter removes the user's default event filter, or that of the
+// session in the path
+func (h *APIHandlers) DeleteEventFilter(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	if err := h.whatsappService.DeleteEventFilter(userID, c.Param("session_id")); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Event filter deleted",
+	})
+}
+
+// GetMediaSettings returns the media settings of a session
+func (h *APIHandlers) GetMediaSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	settings, err := h.whatsappService.GetMediaSettings(c.Param("session_id"), userID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// UpdateMediaSettings turns copying incoming media to media storage on or off
+func (h *APIHandlers) UpdateMediaSettings(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req MediaSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	settings, err := h.whatsappService.UpdateMediaSettings(c.Param("session_id"), userID, req)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// GetCalls lists the calls received by a session
+// (sort: offered_at; filters: status, from; ?q= searches the caller)
+func (h *APIHandlers) GetCalls(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	if _, err := uuid.Parse(sessionIDStr); err != nil {
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
+		return
+	}
+
+	q, ok := parseListRequest(c, listSortFields{
+		"offered_at": "offered_at",
+	}, "-offered_at", "status", "from")
+	if !ok {
+		return
+	}
+
+	calls, total, err := h.db.GetCalls(sessionIDStr, userID, q)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to load calls")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"data":       calls,
+		"pagination": q.Meta(total),
+	})
+}
+
+// CheckContactsExist checks which phone numbers are registered on WhatsApp
+func (h *APIHandlers) CheckContactsExist(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	var req struct {
+		PhoneNumbers []string `json:"phone_numbers" binding:"required,min=1,max=500"`
+		ForceRefresh bool     `json:"force_refresh"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	results, invalid, err := h.whatsappService.CheckContactsExist(sessionIDStr, userID, req.PhoneNumbers, req.ForceRefresh)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	contacts := make([]gin.H, 0, len(req.PhoneNumbers))
+	for _, phone := range req.PhoneNumbers {
+		if reason, ok := invalid[phone]; ok {
+			contacts = append(contacts, gin.H{
+				"input":         phone,
+				"is_valid":      false,
+				"is_registered": false,
+				"error":         reason,
+			})
+			continue
+		}
+
+		lookup := results[phone]
+		var jid interface{}
+		if lookup.Registered {
+			jid = lookup.JID.String()
+		}
+		contacts = append(contacts, gin.H{
+			"input":         phone,
+			"phone_number":  lookup.Phone,
+			"is_valid":      true,
+			"is_registered": lookup.Registered,
+			"jid":           jid,
+			"cached":        lookup.Cached,
+			"checked_at":    lookup.CheckedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"contacts": contacts,
+			"total":    len(contacts),
+		},
+	})
+}
+
+// GetContactPicture serves the cached profile picture of a contact or group
+func (h *APIHandlers) GetContactPicture(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	jid := c.Param("jid")
+	forceRefresh := c.Query("refresh") == "true"
+
+	avatar, err := h.whatsappService.GetContactAvatar(sessionIDStr, userID, jid, forceRefresh)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	etag := `"` + avatar.ETag + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, max-age=3600")
+
+	if match := c.GetHeader("If-None-Match"); match != "" && (match == etag || match == "*") {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	if c.Query("url") == "true" {
+		url, expiresAt, err := h.whatsappService.AvatarURL(avatar)
+		if err != nil {
+			respondAPIError(c, apierr.ErrInternal, "Failed to sign picture URL")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data": gin.H{
+				"url":        url,
+				"expires_at": expiresAt,
+			},
+		})
+		return
+	}
+
+	file, err := h.whatsappService.OpenAvatar(avatar)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to read cached picture")
+		return
+	}
+	defer file.Close()
+
+	c.DataFromReader(http.StatusOK, int64(avatar.Size), avatar.ContentType, file, nil)
+}
+
+// GetContactPictureHistory lists the recorded profile picture changes of a
+// contact or group
+func (h *APIHandlers) GetContactPictureHistory(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	changes, err := h.whatsappService.GetPictureHistory(c.Param("session_id"), userID, c.Param("jid"))
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"changes": changes,
+			"total":   len(changes),
+		},
+	})
+}
+
+// GetMessageMedia serves the stored copy of a message's media (auto-downloaded
+// view-once media and auto-stored media); ?url=true returns a signed link instead
+func (h *APIHandlers) GetMessageMedia(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	media, err := h.whatsappService.GetMessageMedia(c.Param("session_id"), userID, c.Param("jid"), c.Param("message_id"))
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	if c.Query("url") == "true" {
+		url, expiresAt, err := h.whatsappService.MessageMediaURL(media)
+		if err != nil {
+			respondAPIError(c, apierr.ErrInternal, "Failed to sign media URL")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data": gin.H{
+				"url":        url,
+				"expires_at": expiresAt,
+			},
+		})
+		return
+	}
+
+	file, err := h.whatsappService.OpenMessageMedia(media)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondAPIError(c, apierr.ErrNotFound, "Media file not found")
+		return
+	} else if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to read stored media")
+		return
+	}
+	defer file.Close()
+
+	contentType := media.Mimetype
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Cache-Control", "private, max-age=300")
+	c.DataFromReader(http.StatusOK, -1, contentType, file, nil)
+}
+
+// ServeMediaFile serves a locally stored media file through a signed link
+// (?expires=&signature=, see storage.Local.SignedURL)
+func (h *APIHandlers) ServeMediaFile(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+
+	file, err := h.whatsappService.OpenSignedMedia(key, c.Query("expires"), c.Query("signature"))
+	if errors.Is(err, storage.ErrInvalidSignature) {
+		respondAPIError(c, apierr.ErrForbidden, "Invalid or expired link")
+		return
+	} else if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+		respondAPIError(c, apierr.ErrNotFound, "File not found")
+		return
+	} else if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	defer file.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Cache-Control", "private, max-age=300")
+	c.DataFromReader(http.StatusOK, -1, contentType, file, nil)
+}
+
+// GetChats lists the stored chats of a session
+// (sort: last_message_at, name, unread, created_at; filters: archived, pinned,
+// is_group, unread; ?q= searches name and JID)
+func (h *APIHandlers) GetChats(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	// Parse session ID
+	sessionID, err := uuid.Parse(sessionIDStr)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
+		return
+	}
+
+	// Verify user owns this session
+	if _, err := h.db.GetSession(sessionID, userID); err != nil {
+		respondAPIError(c, apierr.ErrSessionNotFound, "Session not found")
+		return
+	}
+
+	q, ok := parseListRequest(c, listSortFields{
+		"last_message_at": "last_message_at",
+		"name":            "name",
+		"unread":          "unread_count",
+		"created_at":      "created_at",
+	}, "-last_message_at", "archived:bool", "pinned:bool", "is_group:bool", "unread:bool")
+	if !ok {
+		return
+	}
+
+	chats, total, err := h.db.GetSessionChats(sessionIDStr, userID, q)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := h.whatsappService.attachChatNotes(sessionIDStr, userID, chats); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"chats": chats,
+			"total": total,
+		},
+		"pagination": q.Meta(total),
+	})
+}
+
+// GetChatMessages lists the stored messages of a chat, newest first
+// (sort: timestamp; filters: type, from_me; ?q= searches the text). Besides
+// ?offset=, ?before=<RFC3339 timestamp> pages back in time.
+func (h *APIHandlers) GetChatMessages(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	chatJID := c.Param("jid")
+
+	// Parse session ID
+	sessionID, err := uuid.Parse(sessionIDStr)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
+		return
+	}
+
+	// Verify user owns this session
+	if _, err := h.db.GetSession(sessionID, userID); err != nil {
+		respondAPIError(c, apierr.ErrSessionNotFound, "Session not found")
+		return
+	}
+
+	q, ok := parseListRequest(c, listSortFields{"timestamp": "timestamp"}, "-timestamp", "type", "from_me:bool")
+	if !ok {
+		return
+	}
+
+	var before *time.Time
+	if beforeStr := c.Query("before"); beforeStr != "" {
+		parsed, err := time.Parse(time.RFC3339, beforeStr)
+		if err != nil {
+			respondAPIError(c, apierr.ErrInvalidRequest, "Invalid before timestamp, expected RFC3339")
+			return
+		}
+		before = &parsed
+	}
+
+	messages, total, err := h.db.GetChatMessages(sessionIDStr, chatJID, before, q)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"chat_jid": chatJID,
+			"messages": messages,
+			"count":    len(messages),
+		},
+		"pagination": q.Meta(total),
+	})
+}
+
+// chatActionError writes the error response for chat management actions.
+// Errors without a code get a status from their message.
+func chatActionError(c *gin.Context, err error) {
+	respondError(c, errorStatus(err), err)
+}
+
+// errorStatus guesses the HTTP status of an error without a code
+func errorStatus(err error) int {
+	if strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "unauthorized") {
+		return http.StatusNotFound
+	} else if strings.Contains(err.Error(), "failed to") {
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// MarkChatRead marks all pending messages of a chat as read and sends read
+// receipts, unless the session has them turned off
+func (h *APIHandlers) MarkChatRead(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	chatJID := c.Param("jid")
+
+	count, receiptsSent, err := h.whatsappService.MarkChatRead(sessionIDStr, userID, chatJID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"chat_jid":      chatJID,
+			"messages_read": count,
+			"receipts_sent": receiptsSent,
+		},
+	})
+}
+
+// MarkChatUnread marks a chat as unread
+func (h *APIHandlers) MarkChatUnread(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	chatJID := c.Param("jid")
+
+	if err := h.whatsappService.MarkChatUnread(sessionIDStr, userID, chatJID); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Chat marked as unread",
+	})
+}
+
+// ArchiveChat archives or unarchives a chat (body: {"archived": bool}, defaults to true)
+func (h *APIHandlers) ArchiveChat(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	chatJID := c.Param("jid")
+
+	var req struct {
+		Archived *bool `json:"archived"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	archived := req.Archived == nil || *req.Archived
+	if err := h.whatsappService.SetChatArchived(sessionIDStr, userID, chatJID, archived); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"chat_jid": chatJID,
+			"archived": archived,
+		},
+	})
+}
+
+// PinChat pins or unpins a chat (body: {"pinned": bool}, defaults to true)
+func (h *APIHandlers) PinChat(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	chatJID := c.Param("jid")
+
+	var req struct {
+		Pinned *bool `json:"pinned"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	pinned := req.Pinned == nil || *req.Pinned
+	if err := h.whatsappService.SetChatPinned(sessionIDStr, userID, chatJID, pinned); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"chat_jid": chatJID,
+			"pinned":   pinned,
+		},
+	})
+}
+
+// MuteChat mutes or unmutes a chat
+// Body: {"muted": bool (default true), "duration_seconds": int (0 = forever)}
+func (h *APIHandlers) MuteChat(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+	chatJID := c.Param("jid")
+
+	var req struct {
+		Muted           *bool `json:"muted"`
+		DurationSeconds int64 `json:"duration_seconds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if req.DurationSeconds < 0 {
+		respondAPIError(c, apierr.ErrInvalidRequest, "duration_seconds must not be negative")
+		return
+	}
+
+	muted := req.Muted == nil || *req.Muted
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	if err := h.whatsappService.SetChatMuted(sessionIDStr, userID, chatJID, muted, duration); err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"chat_jid":         chatJID,
+			"muted":            muted,
+			"duration_seconds": req.DurationSeconds,
+		},
+	})
+}
+
+// ExportChat starts a background export of a stored conversation (?format=json|csv)
+func (h *APIHandlers) ExportChat(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	export, err := h.whatsappService.ExportChat(c.Param("session_id"), userID, c.Param("jid"), c.DefaultQuery("format", "json"))
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"data":    export,
+	})
+}
+
+// parseExportID parses the :export_id route parameter
+func parseExportID(c *gin.Context) (int64, bool) {
+	exportID, err := strconv.ParseInt(c.Param("export_id"), 10, 64)
+	if err != nil || exportID <= 0 {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid export ID")
+		return 0, false
+	}
+	return exportID, true
+}
+
+// GetChatExport returns the status of an export job
+func (h *APIHandlers) GetChatExport(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	exportID, ok := parseExportID(c)
+	if !ok {
+		return
+	}
+
+	export, err := h.whatsappService.GetChatExport(userID, exportID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    export,
+	})
+}
+
+// DownloadChatExport serves the file of a completed export
+func (h *APIHandlers) DownloadChatExport(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	exportID, ok := parseExportID(c)
+	if !ok {
+		return
+	}
+
+	export, err := h.whatsappService.GetChatExport(userID, exportID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	if export.Status != ExportCompleted {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   "Export is " + string(export.Status),
+			"code":    apierr.ErrConflict.Code,
+			"data":    export,
+		})
+		return
+	}
+	if _, err := os.Stat(export.FilePath); err != nil {
+		respondAPIError(c, apierr.ErrGone, "Export file is no longer available")
+		return
+	}
+
+	c.Header("Content-Type", chatExportFormats[export.Format])
+	c.FileAttachment(export.FilePath, chatExportFileName(export))
+}
+
+// SendNoteToSelf sends a text message to the session's own account ("Message yourself")
+func (h *APIHandlers) SendNoteToSelf(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	var req struct {
+		Message string `json:"message" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Parse session ID (validate format)
+	if _, err := uuid.Parse(sessionIDStr); err != nil {
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
+		return
+	}
+
+	if wantsAsync(c) {
+		h.enqueueSend(c, SendRequest{SessionID: sessionIDStr, To: "me", Text: req.Message})
+		return
+	}
+
+	if _, err := h.whatsappService.SendMessage(sessionIDStr, userID, "me", req.Message); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Note sent successfully",
+	})
+}
+
+// parseListID parses the :list_id route parameter
+func parseListID(c *gin.Context) (int64, bool) {
+	listID, err := strconv.ParseInt(c.Param("list_id"), 10, 64)
+	if err != nil || listID <= 0 {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid list ID")
+		return 0, false
+	}
+	return listID, true
+}
+
+// GetBroadcastLists lists the broadcast lists of a session
+func (h *APIHandlers) GetBroadcastLists(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	lists, err := h.db.GetBroadcastLists(sessionIDStr, userID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"broadcast_lists": lists,
+			"total":           len(lists),
+		},
+	})
+}
+
+// CreateBroadcastList creates a broadcast list for a session
+func (h *APIHandlers) CreateBroadcastList(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	var req struct {
+		Name       string   `json:"name" binding:"required"`
+		Recipients []string `json:"recipients"`
+		SegmentID  *int64   `json:"segment_id"` // members are added at send time
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	list, invalid, err := h.whatsappService.CreateBroadcastList(sessionIDStr, userID, req.Name, req.Recipients, req.SegmentID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"broadcast_list":     list,
+			"invalid_recipients": invalid,
+		},
+	})
+}
+
+// AddBroadcastListRecipients adds recipients to a broadcast list
+func (h *APIHandlers) AddBroadcastListRecipients(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	listID, ok := parseListID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Recipients []string `json:"recipients" binding:"required,min=1"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	list, invalid, err := h.whatsappService.AddBroadcastListRecipients(sessionIDStr, userID, listID, req.Recipients)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"broadcast_list":     list,
+			"invalid_recipients": invalid,
+		},
+	})
+}
+
+// RemoveBroadcastListRecipients removes recipients from a broadcast list
+func (h *APIHandlers) RemoveBroadcastListRecipients(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	listID, ok := parseListID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Recipients []string `json:"recipients" binding:"required,min=1"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	list, err := h.whatsappService.RemoveBroadcastListRecipients(sessionIDStr, userID, listID, req.Recipients)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"broadcast_list": list,
+		},
+	})
+}
+
+// DeleteBroadcastList deletes a broadcast list
+func (h *APIHandlers) DeleteBroadcastList(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	listID, ok := parseListID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.DeleteBroadcastList(sessionIDStr, userID, listID); err != nil {
+		respondAPIError(c, apierr.ErrNotFound, "Broadcast list not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Broadcast list deleted successfully",
+	})
+}
+
+// SendBroadcastList sends a text message to all members of a broadcast list,
+// or queues the send as a background job with ?async=true
+func (h *APIHandlers) SendBroadcastList(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	sessionIDStr := c.Param("session_id")
+
+	listID, ok := parseListID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Message string `json:"message"`  // text, or the caption when media_id is set
+		MediaID string `json:"media_id"` // handle from POST /media/upload
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if req.Message == "" && req.MediaID == "" {
+		respondAPIError(c, apierr.ErrInvalidRequest, "message or media_id is required")
+		return
+	}
+
+	// Large lists take a while; queued sends report through events
+	if wantsAsync(c) {
+		job, err := h.whatsappService.QueueBroadcastListSend(sessionIDStr, userID, listID, req.Message, req.MediaID)
+		if err != nil {
+			chatActionError(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"success": true,
+			"data":    job,
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"data": gin.H{
-			"message": fmt.Sprintf("%s message sent successfully", strings.Title(req.MessageType)),
-			"to":      req.To,
-			"type":    req.MessageType,
-		},
-	})
-}
+	deliveries, err := h.whatsappService.SendToBroadcastList(sessionIDStr, userID, listID, req.Message, req.MediaID)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	sent := 0
+	for _, delivery := range deliveries {
+		if delivery.Success {
+			sent++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"total":   len(deliveries),
+			"sent":    sent,
+			"failed":  len(deliveries) - sent,
+			"results": deliveries,
+		},
+	})
+}
+
+// wantsAsync reports whether the client asked for the send to be queued in the
+// outbox (?async=true or "Prefer: respond-async")
+func wantsAsync(c *gin.Context) bool {
+	if async, _ := strconv.ParseBool(c.Query("async")); async {
+		return true
+	}
+	return strings.Contains(c.GetHeader("Prefer"), "respond-async")
+}
+
+// enqueueSend queues a send in the outbox. New messages are answered with 202;
+// a reused Idempotency-Key returns the original record with 200. ?ttl= sets
+// the seconds the message may wait before it expires. Reports whether a new
+// message was queued.
+func (h *APIHandlers) enqueueSend(c *gin.Context, req SendRequest) bool {
+	userID := c.GetInt("user_id")
+
+	var ttl time.Duration
+	if raw := c.Query("ttl"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds <= 0 {
+			respondAPIError(c, apierr.ErrInvalidRequest, "ttl must be a positive number of seconds")
+			return false
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	msg, created, err := h.whatsappService.EnqueueSend(userID, c.GetHeader("Idempotency-Key"), req, ttl)
+	if err != nil {
+		chatActionError(c, err)
+		return false
+	}
+
+	status := http.StatusAccepted
+	if !created {
+		status = http.StatusOK
+	}
+	c.JSON(status, gin.H{
+		"success":   true,
+		"duplicate": !created,
+		"data":      msg,
+	})
+	return created
+}
+
+// GetOutboxMessage returns a queued message and its delivery status
+func (h *APIHandlers) GetOutboxMessage(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	id, err := strconv.ParseInt(c.Param("message_id"), 10, 64)
+	if err != nil || id <= 0 {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid message ID")
+		return
+	}
+
+	msg, err := h.whatsappService.GetOutboxMessage(userID, id)
+	if err != nil {
+		chatActionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    msg,
+	})
+}
+
+// getMaxSizeForType returns the maximum file size for each media type
+func (h *APIHandlers) getMaxSizeForType(messageType string) int64 {
+	return h.cfg.maxMediaSize(messageType)
+}
+
+// WebSocket upgrader
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		// Configure based on your needs
+		return true
+	},
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// HandleWebSocket handles WebSocket connections for real-time updates
+func (h *APIHandlers) HandleWebSocket(c *gin.Context) {
+	sessionIDStr := c.Param("session_id")
+	token := c.Query("token")
+
+	// Validate token
+	userID, err := h.validateWebSocketToken(token)
+	if err != nil {
+		respondAPIError(c, apierr.ErrUnauthorized, "Invalid token")
+		return
+	}
+
+	// Parse session ID
+	sessionID, err := uuid.Parse(sessionIDStr)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
+		return
+	}
+
+	// Verify user owns this session
+	session, err := h.db.GetSession(sessionID, userID)
+	if err != nil {
+		respondAPIError(c, apierr.ErrSessionNotFound, "Session not found")
+		return
+	}
+
+	var topics []string
+	if topicsParam := c.Query("topics"); topicsParam != "" {
+		if topics, err = parseWSTopics(strings.Split(topicsParam, ",")); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	// Upgrade to WebSocket
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade to WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// Add connection to manager
+	client := newWSClient(conn, sessionIDStr, userID, topics)
+	h.wsManager.AddConnection(sessionIDStr, client)
+	defer h.wsManager.RemoveConnection(sessionIDStr, client)
+
+	// Send initial status
+	client.send(WebSocketMessage{
+		Type: "status",
+		Data: map[string]interface{}{
+			"session_id": session.ID,
+			"status":     session.Status,
+			"connected":  session.Status == StatusConnected,
+			"topics":     client.subscriptions(),
+		},
+	})
+
+	// Answer subscribe/replay requests and send heartbeats until the client leaves
+	h.wsManager.serveWebSocket(client, conn)
+}
+
+// HandleUserWebSocket streams the events of all sessions of the user over one
+// connection; every frame carries its session_id
+func (h *APIHandlers) HandleUserWebSocket(c *gin.Context) {
+	userID, err := h.validateWebSocketToken(c.Query("token"))
+	if err != nil {
+		respondAPIError(c, apierr.ErrUnauthorized, "Invalid token")
+		return
+	}
+
+	var topics []string
+	if topicsParam := c.Query("topics"); topicsParam != "" {
+		if topics, err = parseWSTopics(strings.Split(topicsParam, ",")); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	sessions, err := h.db.GetUserSessions(userID)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to load sessions")
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade to WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	client := newWSClient(conn, "", userID, topics)
+	h.wsManager.AddUserConnection(userID, client)
+	defer h.wsManager.RemoveUserConnection(userID, client)
 
-// getMaxSizeForType returns the maximum file size for each media type
-func (h *APIHandlers) getMaxSizeForType(messageType string) int64 {
-	switch messageType {
-	case "image":
-		return 16 * 1024 * 1024 // 16 MB
-	case "video":
-		return 100 * 1024 * 1024 // 100 MB
-	case "audio":
-		return 16 * 1024 * 1024 // 16 MB
-	case "document":
-		return 100 * 1024 * 1024 // 100 MB
-	default:
-		return 16 * 1024 * 1024 // 16 MB default
+	statuses := make([]map[string]interface{}, 0, len(sessions))
+	for _, session := range sessions {
+		statuses = append(statuses, map[string]interface{}{
+			"session_id": session.ID,
+			"status":     session.Status,
+			"connected":  session.Status == StatusConnected,
+		})
 	}
-}
+	client.send(WebSocketMessage{
+		Type: "status",
+		Data: map[string]interface{}{
+			"sessions": statuses,
+			"topics":   client.subscriptions(),
+		},
+	})
 
-// WebSocket upgrader
-var wsUpgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		// Configure based on your needs
-		return true
-	},
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
+	h.wsManager.serveWebSocket(client, conn)
 }
 
-// HandleWebSocket handles WebSocket connections for real-time updates
-func (h *APIHandlers) HandleWebSocket(c *gin.Context) {
-	sessionIDStr := c.Param("session_id")
-	token := c.Query("token")
-
-	// Validate token
-	userID, err := h.validateWebSocketToken(token)
+// HandleSSE streams the events of a session as Server-Sent Events, resuming
+// after Last-Event-ID when the client reconnects
+func (h *APIHandlers) HandleSSE(c *gin.Context) {
+	userID, err := h.validateWebSocketToken(c.Query("token"))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"success": false,
-			"error":   "Invalid token",
-		})
+		respondAPIError(c, apierr.ErrUnauthorized, "Invalid token")
 		return
 	}
 
-	// Parse session ID
+	sessionIDStr := c.Param("session_id")
 	sessionID, err := uuid.Parse(sessionIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid session ID",
-		})
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
 		return
 	}
 
-	// Verify user owns this session
 	session, err := h.db.GetSession(sessionID, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Session not found",
-		})
+		respondAPIError(c, apierr.ErrSessionNotFound, "Session not found")
 		return
 	}
 
-	// Upgrade to WebSocket
-	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	var topics []string
+	if topicsParam := c.Query("topics"); topicsParam != "" {
+		if topics, err = parseWSTopics(strings.Split(topicsParam, ",")); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	since, resume, err := sseLastEventID(c.Request)
 	if err != nil {
-		log.Printf("Failed to upgrade to WebSocket: %v", err)
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
-	defer conn.Close()
 
-	// Add connection to manager
-	h.wsManager.AddConnection(sessionIDStr, conn)
-	defer h.wsManager.RemoveConnection(sessionIDStr, conn)
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		respondAPIError(c, apierr.ErrInternal, "Streaming not supported")
+		return
+	}
 
-	// Send initial status
-	conn.WriteJSON(WebSocketMessage{
+	clearWriteDeadline(c.Writer)
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	client := newSSEClient(c.Writer, flusher, sessionIDStr, userID, topics)
+	h.wsManager.AddConnection(sessionIDStr, client)
+	defer h.wsManager.RemoveConnection(sessionIDStr, client)
+
+	client.send(WebSocketMessage{
 		Type: "status",
 		Data: map[string]interface{}{
 			"session_id": session.ID,
 			"status":     session.Status,
 			"connected":  session.Status == StatusConnected,
+			"topics":     client.subscriptions(),
 		},
 	})
 
-	// Keep connection alive
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+	h.wsManager.serveStream(client, since, resume, c.Request.Context().Done())
+}
 
-	done := make(chan struct{})
+// qrStreamTimeout bounds a QR stream; WhatsApp's codes of one pairing
+// attempt run out well before it
+const qrStreamTimeout = 5 * time.Minute
 
-	// Read messages (for ping/pong)
-	go func() {
-		defer close(done)
-		for {
-			_, _, err := conn.ReadMessage()
-			if err != nil {
-				return
-			}
+// HandleQRStream follows the QR codes of a pairing session over Server-Sent
+// Events: the current code first, then every rotated code as "qr" events,
+// until the phone pairs ("paired") or the codes run out ("timeout"). Like the
+// event stream, it authenticates with ?token= so EventSource can use it.
+func (h *APIHandlers) HandleQRStream(c *gin.Context) {
+	userID, err := h.validateWebSocketToken(c.Query("token"))
+	if err != nil {
+		respondAPIError(c, apierr.ErrUnauthorized, "Invalid token")
+		return
+	}
+
+	sessionIDStr := c.Param("session_id")
+	sessionID, err := uuid.Parse(sessionIDStr)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
+		return
+	}
+
+	session, err := h.db.GetSession(sessionID, userID)
+	if err != nil {
+		respondAPIError(c, apierr.ErrSessionNotFound, "Session not found")
+		return
+	}
+
+	paired := session.Status == StatusConnected
+	if !paired && session.Status != StatusPending && session.Status != StatusQRReady && session.Status != StatusScanning {
+		respondAPIError(c, apierr.ErrQRNotAvailable, fmt.Sprintf("QR code not available for status: %s", session.Status))
+		return
+	}
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		respondAPIError(c, apierr.ErrInternal, "Streaming not supported")
+		return
+	}
+
+	// Subscribe before reading the current code so no rotation is missed
+	events := make(chan WebSocketMessage, 8)
+	client := newStreamClient(sessionIDStr, userID, []string{"qr", "session"}, func(message WebSocketMessage) error {
+		select {
+		case events <- message:
+		default: // the stream is behind; the next code supersedes this one
 		}
-	}()
+		return nil
+	})
+	defer client.close()
+	h.wsManager.AddConnection(sessionIDStr, client)
+	defer h.wsManager.RemoveConnection(sessionIDStr, client)
+
+	clearWriteDeadline(c.Writer)
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if paired {
+		writeSSE(c.Writer, flusher, "paired", gin.H{"session_id": session.ID, "jid": session.JID})
+		return
+	}
+	if qrCode, err := h.whatsappService.GetQRCode(sessionIDStr, userID); err == nil {
+		writeSSE(c.Writer, flusher, "qr", gin.H{"qr_code": qrCode, "expires_at": session.QRExpiresAt})
+	}
+
+	timeout := time.NewTimer(qrStreamTimeout)
+	defer timeout.Stop()
+	keepalive := time.NewTicker(wsHeartbeatInterval)
+	defer keepalive.Stop()
 
-	// Write ping messages
 	for {
 		select {
-		case <-ticker.C:
-			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+		case <-c.Request.Context().Done():
+			return
+		case <-timeout.C:
+			writeSSE(c.Writer, flusher, "timeout", gin.H{"session_id": session.ID})
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case message := <-events:
+			var err error
+			switch message.Type {
+			case "qr_ready":
+				err = writeSSE(c.Writer, flusher, "qr", message.Data)
+			case "pair_success", "connected":
+				writeSSE(c.Writer, flusher, "paired", message.Data)
+				return
+			case "qr_timeout":
+				writeSSE(c.Writer, flusher, "timeout", gin.H{"session_id": session.ID})
+				return
+			case "logged_out":
+				writeSSE(c.Writer, flusher, "failed", gin.H{"session_id": session.ID, "reason": message.Type})
+				return
+			}
+			if err != nil {
 				return
 			}
-		case <-done:
-			return
 		}
 	}
 }
@@ -794,131 +5432,142 @@ func (h *APIHandlers) validateWebSocketToken(tokenString string) (int, error) {
 
 // Health check endpoint
 func (h *APIHandlers) HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"success": true,
 		"status":  "healthy",
 		"time":    time.Now(),
+	}
+	// ?detail=true adds the utilization of the database pool and the
+	// message deduplication window
+	if c.Query("detail") == "true" {
+		pool, err := h.db.PoolStats()
+		if err != nil {
+			response["database_pool"] = gin.H{"error": err.Error()}
+		} else {
+			response["database_pool"] = pool
+		}
+		response["message_dedup"] = h.whatsappService.messageDedup.stats()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Readiness reports whether the service can take traffic: the database must
+// answer. Loaded sessions are summarized by health, the session registry by
+// state, the batch writer by its buffers and the read replica by its lag, but
+// they don't fail the probe, since one broken number shouldn't take the API
+// out of rotation.
+func (h *APIHandlers) Readiness(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	checks := gin.H{"database": "ok"}
+	status := http.StatusOK
+	if err := h.db.Ping(ctx); err != nil {
+		checks["database"] = err.Error()
+		status = http.StatusServiceUnavailable
+	}
+
+	c.JSON(status, gin.H{
+		"success":  status == http.StatusOK,
+		"ready":    status == http.StatusOK,
+		"checks":   checks,
+		"sessions": h.whatsappService.ReadinessSummary(),
+		"registry": h.whatsappService.sessions.Stats(),
+		"writer":   h.db.BatchWriterStats(),
+		"replica":  h.db.ReplicaStatus(),
+		"time":     time.Now(),
 	})
 }
 
+// ValidateAccount checks the health of one phone number (phone_number) or up
+// to 500 (phone_numbers): registration, business flag, profile picture,
+// devices and last activity. session_id picks the session that asks
+// WhatsApp; by default any connected session of the user is used.
 func (h *APIHandlers) ValidateAccount(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
 	var req struct {
-		PhoneNumber string `json:"phone_number" binding:"required"`
+		PhoneNumber  string   `json:"phone_number"`
+		PhoneNumbers []string `json:"phone_numbers" binding:"max=500"`
+		SessionID    string   `json:"session_id"`
+		ForceRefresh bool     `json:"force_refresh"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid request: " + err.Error(),
-		})
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
 		return
 	}
 
-	// Clean phone number - remove all non-digit characters
-	cleanNumber := ""
-	for _, char := range req.PhoneNumber {
-		if char >= '0' && char <= '9' {
-			cleanNumber += string(char)
+	single := len(req.PhoneNumbers) == 0
+	phones := req.PhoneNumbers
+	if single {
+		if req.PhoneNumber == "" {
+			respondAPIError(c, apierr.ErrInvalidRequest, "phone_number or phone_numbers is required")
+			return
 		}
+		if _, err := wajid.NormalizePhone(req.PhoneNumber); err != nil {
+			respondAPIError(c, apierr.ErrInvalidRecipient, "Invalid phone number format")
+			return
+		}
+		phones = []string{req.PhoneNumber}
 	}
 
-	// Validate cleaned number
-	if cleanNumber == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid phone number format",
-		})
-		return
-	}
-
-	// We need a connected session to validate numbers
-	// Try to find any connected session for this user
-	sessions, err := h.whatsappService.GetUserSessions(userID)
+	results, err := h.whatsappService.CheckNumberHealth(req.SessionID, userID, phones, req.ForceRefresh)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to retrieve sessions",
-		})
+		log.Printf("Failed to validate phone numbers: %v", err)
+		chatActionError(c, err)
 		return
 	}
 
-	// Find first connected session
-	var connectedSessionID string
-	for _, session := range sessions {
-		if session.Status == StatusConnected {
-			connectedSessionID = session.ID
-			break
-		}
-	}
-
-	if connectedSessionID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "No connected WhatsApp session found. Please connect at least one session first.",
+	if single {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data":    results[0],
 		})
 		return
 	}
 
-	// Get session client
-	sc, err := h.whatsappService.GetSessionClient(connectedSessionID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to get session client",
-		})
-		return
-	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"numbers": results,
+			"total":   len(results),
+		},
+	})
+}
 
-	if !sc.Client.IsConnected() {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Session is not connected",
-		})
+// ParseNumbers normalizes a list of raw phone numbers to E.164 with their
+// country, optionally checking WhatsApp registration
+func (h *APIHandlers) ParseNumbers(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req ParseNumbersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondAPIError(c, apierr.ErrInvalidRequest, "Invalid request: "+err.Error())
 		return
 	}
 
-	// Validate the number on WhatsApp
-	ctx := context.Background()
-	resp, err := sc.Client.IsOnWhatsApp(ctx, []string{"+" + cleanNumber})
+	results, err := h.whatsappService.ParseNumbers(userID, req)
 	if err != nil {
-		log.Printf("Failed to validate phone number %s: %v", cleanNumber, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to validate phone number: " + err.Error(),
-		})
+		chatActionError(c, err)
 		return
 	}
 
-	// Check response
-	if len(resp) == 0 {
-		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"data": gin.H{
-				"phone_number":  cleanNumber,
-				"is_valid":      false,
-				"is_registered": false,
-				"jid":           nil,
-			},
-		})
-		return
+	valid := 0
+	for _, result := range results {
+		if result.Possible {
+			valid++
+		}
 	}
-
-	// Return validation result
-	result := resp[0]
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
-			"phone_number":  cleanNumber,
-			"is_valid":      true,
-			"is_registered": result.IsIn,
-			"jid":           result.JID.String(),
+			"numbers": results,
+			"total":   len(results),
+			"valid":   valid,
+			"invalid": len(results) - valid,
 		},
 	})
-
-	log.Printf("✅ Validated phone number %s: registered=%v, jid=%s",
-		cleanNumber, result.IsIn, result.JID.String())
 }
 
 func (h *APIHandlers) RefreshSession(c *gin.Context) {
@@ -928,10 +5577,7 @@ func (h *APIHandlers) RefreshSession(c *gin.Context) {
 	// Parse session ID (validate format)
 	_, err := uuid.Parse(sessionIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid session ID",
-		})
+		respondAPIError(c, apierr.ErrInvalidSessionID, "Invalid session ID")
 		return
 	}
 
@@ -945,20 +5591,14 @@ func (h *APIHandlers) RefreshSession(c *gin.Context) {
 			statusCode = http.StatusBadRequest
 		}
 
-		c.JSON(statusCode, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		respondError(c, statusCode, err)
 		return
 	}
 
 	// Get updated session status
 	session, err := h.whatsappService.GetSessionStatus(sessionIDStr, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to get updated session status",
-		})
+		respondAPIError(c, apierr.ErrInternal, "Failed to get updated session status")
 		return
 	}
 
@@ -976,3 +5616,148 @@ func (h *APIHandlers) RefreshSession(c *gin.Context) {
 		},
 	})
 }
+
+// ReactivateSession starts pairing an expired session again
+func (h *APIHandlers) ReactivateSession(c *gin.Context) {
+	session, err := h.whatsappService.ReactivateSession(c.Param("session_id"), c.GetInt("user_id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Session reactivated, scan the new QR code",
+		"data":    session,
+	})
+}
+
+// GetQRAttempts lists the pairing attempts of a session (sort: started_at;
+// filter: outcome)
+func (h *APIHandlers) GetQRAttempts(c *gin.Context) {
+	q, ok := parseListRequest(c, listSortFields{
+		"started_at": "started_at",
+	}, "-started_at", "outcome")
+	if !ok {
+		return
+	}
+
+	attempts, total, err := h.whatsappService.GetQRAttempts(c.Param("session_id"), c.GetInt("user_id"), q)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"data":       attempts,
+		"pagination": q.Meta(total),
+	})
+}
+
+// GetAuditLogs lists the audit log of the user's mutating API calls
+// (sort: created_at, status_code; filters: session_id, method, endpoint,
+// status_code, from/to as RFC3339; ?q= searches the request path)
+func (h *APIHandlers) GetAuditLogs(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	q, ok := parseListRequest(c, listSortFields{
+		"created_at":  "created_at",
+		"status_code": "status_code",
+	}, "-created_at", "session_id", "method", "endpoint", "status_code")
+	if !ok {
+		return
+	}
+	if method, ok := q.Filters["method"]; ok {
+		q.Filters["method"] = strings.ToUpper(method)
+	}
+
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+
+	entries, total, err := h.db.GetAuditLogs(userID, q, from, to)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to load audit log")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"data":       entries,
+		"pagination": q.Meta(total),
+	})
+}
+
+// parseTimeRange reads the RFC 3339 ?from= and ?to= of a list request and
+// answers 400 when they are invalid
+func parseTimeRange(c *gin.Context) (from, to *time.Time, ok bool) {
+	for name, dest := range map[string]**time.Time{"from": &from, "to": &to} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondAPIError(c, apierr.ErrInvalidRequest, "Invalid "+name+" timestamp, expected RFC3339")
+			return nil, nil, false
+		}
+		*dest = &parsed
+	}
+	return from, to, true
+}
+
+// ListEvents pages through the stored events of the user's sessions
+// (?session_id=, ?event_type=, ?from=, ?to=, ?q= on the event type)
+func (h *APIHandlers) ListEvents(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	q, ok := parseListRequest(c, listSortFields{
+		"created_at": "created_at",
+		"event_type": "event_type",
+	}, "-created_at", "session_id", "event_type")
+	if !ok {
+		return
+	}
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+
+	filter := EventFilter{SessionID: q.Filters["session_id"], EventType: q.Filters["event_type"], From: from, To: to}
+	events, total, err := h.db.GetEvents(userID, filter, q)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to load events")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"data":       events,
+		"pagination": q.Meta(total),
+	})
+}
+
+// GetEventStatistics counts the stored events of the user's sessions by
+// type, session and day, with the same filters as ListEvents
+func (h *APIHandlers) GetEventStatistics(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+	filter := EventFilter{SessionID: c.Query("session_id"), EventType: c.Query("event_type"), From: from, To: to}
+
+	stats, err := h.db.GetEventStatistics(userID, filter)
+	if err != nil {
+		respondAPIError(c, apierr.ErrInternal, "Failed to load event statistics")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    stats,
+	})
+}
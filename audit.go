@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ============= AUDIT LOG =============
+// Every mutating call (POST, PUT, PATCH, DELETE) to the authenticated API is
+// recorded with the caller, endpoint, session, response status, client IP and
+// user agent, plus a summary of the request: route parameters, query string
+// and, for JSON bodies, the body with credentials redacted and long values
+// cut short. Media uploads and other non-JSON bodies are only described by
+// content type and size. GET /audit lists the entries.
+
+const (
+	auditMaxBody   = 64 * 1024 // larger JSON bodies are recorded by size only
+	auditMaxString = 256       // longer strings (base64 media, long texts) are cut
+	auditMaxItems  = 50        // longer arrays are cut
+)
+
+// auditRedactedFields are body fields never recorded, matched as substrings
+// of the lower-cased field name
+var auditRedactedFields = []string{"password", "secret", "token", "authorization", "credential", "api_key"}
+
+// AuditMiddleware records the mutating calls of the routes it is attached to;
+// it must run after AuthMiddleware
+func AuditMiddleware(db *DatabaseManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		body := readAuditBody(c)
+
+		c.Next()
+
+		entry := &WhatsAppAuditLog{
+			UserID:     c.GetInt("user_id"),
+			Method:     c.Request.Method,
+			Endpoint:   c.FullPath(),
+			Path:       c.Request.URL.Path,
+			StatusCode: c.Writer.Status(),
+			Request:    auditRequestSummary(c, body),
+			IPAddress:  c.ClientIP(),
+			UserAgent:  truncate(c.Request.UserAgent(), 512),
+			DurationMs: time.Since(start).Milliseconds(),
+			CreatedAt:  start,
+		}
+		entry.SessionID = auditSessionID(c, body)
+
+		if err := db.CreateAuditLog(entry); err != nil {
+			log.Printf("❌ Failed to write audit log for %s %s: %v", entry.Method, entry.Path, err)
+		}
+	}
+}
+
+// auditBody is the part of a request body the audit log looks at
+type auditBody struct {
+	contentType string
+	size        int64
+	json        interface{} // decoded JSON body, nil otherwise
+}
+
+// readAuditBody decodes a JSON body of reasonable size and puts the bytes back
+// for the handler; other bodies are left unread
+func readAuditBody(c *gin.Context) auditBody {
+	body := auditBody{
+		contentType: c.ContentType(),
+		size:        c.Request.ContentLength,
+	}
+	if body.contentType != gin.MIMEJSON || c.Request.Body == nil || body.size > auditMaxBody {
+		return body
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, auditMaxBody+1))
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), c.Request.Body))
+	if err != nil || len(data) > auditMaxBody {
+		return body
+	}
+
+	body.size = int64(len(data))
+	if len(data) > 0 {
+		var decoded interface{}
+		if json.Unmarshal(data, &decoded) == nil {
+			body.json = redactAuditValue(decoded)
+		}
+	}
+	return body
+}
+
+// auditRequestSummary builds the recorded request: params, query and body
+func auditRequestSummary(c *gin.Context, body auditBody) JSONData {
+	summary := JSONData{}
+
+	if len(c.Params) > 0 {
+		params := make(map[string]interface{}, len(c.Params))
+		for _, param := range c.Params {
+			params[param.Key] = auditString(param.Value, auditMaxString)
+		}
+		summary["params"] = params
+	}
+
+	if query := c.Request.URL.Query(); len(query) > 0 {
+		values := make(map[string]interface{}, len(query))
+		for name, value := range query {
+			if isRedactedAuditField(name) {
+				values[name] = "[redacted]"
+				continue
+			}
+			values[name] = auditString(strings.Join(value, ","), auditMaxString)
+		}
+		summary["query"] = values
+	}
+
+	switch {
+	case body.json != nil:
+		summary["body"] = body.json
+	case body.size > 0:
+		summary["body"] = map[string]interface{}{
+			"content_type": body.contentType,
+			"size":         body.size,
+		}
+	}
+
+	if len(summary) == 0 {
+		return nil
+	}
+	return summary
+}
+
+// auditSessionID is the session the call acted on: the :session_id route
+// parameter or a session_id body field, when it is a session UUID
+func auditSessionID(c *gin.Context, body auditBody) string {
+	candidate := c.Param("session_id")
+	if candidate == "" {
+		if fields, ok := body.json.(map[string]interface{}); ok {
+			candidate, _ = fields["session_id"].(string)
+		}
+	}
+	if _, err := uuid.Parse(candidate); err != nil {
+		return ""
+	}
+	return candidate
+}
+
+// redactAuditValue redacts credential fields and shortens long strings and
+// arrays of a decoded JSON value
+func redactAuditValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, field := range v {
+			if isRedactedAuditField(name) {
+				v[name] = "[redacted]"
+			} else {
+				v[name] = redactAuditValue(field)
+			}
+		}
+		return v
+	case []interface{}:
+		if len(v) > auditMaxItems {
+			more := len(v) - auditMaxItems
+			v = append(v[:auditMaxItems:auditMaxItems], fmt.Sprintf("... %d more", more))
+		}
+		for i := range v {
+			v[i] = redactAuditValue(v[i])
+		}
+		return v
+	case string:
+		return auditString(v, auditMaxString)
+	}
+	return value
+}
+
+func isRedactedAuditField(name string) bool {
+	name = strings.ToLower(name)
+	for _, redacted := range auditRedactedFields {
+		if strings.Contains(name, redacted) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditString cuts s to max bytes and notes the original length
+func auditString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return fmt.Sprintf("%s... (%d bytes)", strings.ToValidUTF8(s[:max], ""), len(s))
+}
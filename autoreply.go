@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"gorm.io/gorm"
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= AUTO-REPLY RULES =============
+// Auto-reply rules match incoming 1:1 messages against a keyword and answer
+// with a reply, tag the sender, enroll the sender in a sequence (sequences.go)
+// or any combination. A rule applies to one session or to
+// every session of the user; rules are evaluated in creation order and the
+// first match wins. Keywords are case-insensitive. Replies are rendered like
+// broadcasts (spintax.go), go through the safety engine and are sent at most
+// once per chat every autoReplyCooldown, so two bots can't loop. Opt-out
+// keywords never trigger a rule.
+
+const autoReplyCooldown = time.Minute
+
+// AutoReplyRuleRequest creates or replaces an auto-reply rule
+type AutoReplyRuleRequest struct {
+	SessionID  string             `json:"session_id"` // empty = all sessions
+	Name       string             `json:"name"`
+	Keyword    string             `json:"keyword" binding:"required"`
+	MatchType  AutoReplyMatchType `json:"match_type"` // exact, contains (default) or regex
+	Reply      string             `json:"reply"`
+	Tag        string             `json:"tag"`
+	SequenceID *int64             `json:"sequence_id"` // sequence of the rule's session to enroll the sender in
+	Enabled    *bool              `json:"enabled"`     // defaults to true
+}
+
+// applyAutoReplyRequest validates a request and copies it onto rule
+func (ws *WhatsAppService) applyAutoReplyRequest(userID int, req AutoReplyRuleRequest, rule *WhatsAppAutoReplyRule) error {
+	keyword, matchType, err := parseKeyword(req.Keyword, req.MatchType)
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(req.Reply) == "" && strings.TrimSpace(req.Tag) == "" && req.SequenceID == nil {
+		return fmt.Errorf("reply, tag or sequence_id is required")
+	}
+	if err := validateTemplate(req.Reply); err != nil {
+		return err
+	}
+
+	tag := ""
+	if strings.TrimSpace(req.Tag) != "" {
+		if tag, err = normalizeTag(req.Tag); err != nil {
+			return err
+		}
+	}
+
+	if req.SessionID != "" {
+		sessionUUID, err := uuid.Parse(req.SessionID)
+		if err != nil {
+			return apierr.ErrInvalidSessionID
+		}
+		if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+			return apierr.ErrSessionNotFound
+		}
+	}
+
+	if req.SequenceID != nil {
+		sequence, err := ws.GetSequence(userID, *req.SequenceID)
+		if err != nil {
+			return err
+		}
+		if req.SessionID != sequence.SessionID {
+			return fmt.Errorf("sequence %d belongs to session %s, the rule must be limited to it", sequence.ID, sequence.SessionID)
+		}
+	}
+
+	rule.UserID = userID
+	rule.SessionID = req.SessionID
+	rule.Name = strings.TrimSpace(req.Name)
+	rule.Keyword = keyword
+	rule.MatchType = matchType
+	rule.Reply = req.Reply
+	rule.Tag = tag
+	rule.SequenceID = req.SequenceID
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+	return nil
+}
+
+// CreateAutoReplyRule saves a new rule
+func (ws *WhatsAppService) CreateAutoReplyRule(userID int, req AutoReplyRuleRequest) (*WhatsAppAutoReplyRule, error) {
+	rule := &WhatsAppAutoReplyRule{}
+	if err := ws.applyAutoReplyRequest(userID, req, rule); err != nil {
+		return nil, err
+	}
+	if err := ws.db.CreateAutoReplyRule(rule); err != nil {
+		return nil, fmt.Errorf("failed to create auto-reply rule: %w", err)
+	}
+
+	log.Printf("🤖 Auto-reply rule %d created for keyword %q", rule.ID, rule.Keyword)
+	return rule, nil
+}
+
+// GetAutoReplyRule returns a rule of the user
+func (ws *WhatsAppService) GetAutoReplyRule(userID int, ruleID int64) (*WhatsAppAutoReplyRule, error) {
+	rule, err := ws.db.GetAutoReplyRule(ruleID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("auto-reply rule not found")
+		}
+		return nil, fmt.Errorf("failed to load auto-reply rule: %w", err)
+	}
+	return rule, nil
+}
+
+// UpdateAutoReplyRule replaces a rule
+func (ws *WhatsAppService) UpdateAutoReplyRule(userID int, ruleID int64, req AutoReplyRuleRequest) (*WhatsAppAutoReplyRule, error) {
+	rule, err := ws.GetAutoReplyRule(userID, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ws.applyAutoReplyRequest(userID, req, rule); err != nil {
+		return nil, err
+	}
+	if err := ws.db.UpdateAutoReplyRule(rule); err != nil {
+		return nil, fmt.Errorf("failed to update auto-reply rule: %w", err)
+	}
+	return rule, nil
+}
+
+// DeleteAutoReplyRule removes a rule
+func (ws *WhatsAppService) DeleteAutoReplyRule(userID int, ruleID int64) error {
+	deleted, err := ws.db.DeleteAutoReplyRule(ruleID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete auto-reply rule: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("auto-reply rule not found")
+	}
+	return nil
+}
+
+// parseKeyword trims a rule keyword and checks it against its match type,
+// contains by default. Scoring rules match the same way.
+func parseKeyword(keyword string, matchType AutoReplyMatchType) (string, AutoReplyMatchType, error) {
+	keyword = strings.TrimSpace(keyword)
+	if keyword == "" {
+		return "", "", fmt.Errorf("keyword is required")
+	}
+
+	switch matchType {
+	case "":
+		matchType = AutoReplyMatchContains
+	case AutoReplyMatchExact, AutoReplyMatchContains:
+	case AutoReplyMatchRegex:
+		if _, err := regexp.Compile(keyword); err != nil {
+			return "", "", fmt.Errorf("invalid keyword pattern: %v", err)
+		}
+	default:
+		return "", "", fmt.Errorf("match_type must be exact, contains or regex")
+	}
+	return keyword, matchType, nil
+}
+
+// keywordMatches reports whether a message matches a keyword, ignoring case
+func keywordMatches(matchType AutoReplyMatchType, keyword, text string) bool {
+	text = strings.TrimSpace(text)
+	switch matchType {
+	case AutoReplyMatchExact:
+		return strings.EqualFold(text, keyword)
+	case AutoReplyMatchContains:
+		return strings.Contains(strings.ToLower(text), strings.ToLower(keyword))
+	case AutoReplyMatchRegex:
+		pattern, err := regexp.Compile("(?i)" + keyword)
+		return err == nil && pattern.MatchString(text)
+	}
+	return false
+}
+
+// matches reports whether an incoming message triggers the rule
+func (rule *WhatsAppAutoReplyRule) matches(text string) bool {
+	return keywordMatches(rule.MatchType, rule.Keyword, text)
+}
+
+// handleAutoReply runs the first matching rule for an incoming message
+func (ws *WhatsAppService) handleAutoReply(sc *SessionClient, evt *events.Message, content string) {
+	if evt.Info.IsFromMe || evt.Info.IsGroup || strings.TrimSpace(content) == "" || ws.isStopKeyword(content) {
+		return
+	}
+	if chat := evt.Info.Chat; chat.Server != types.DefaultUserServer && chat.Server != types.HiddenUserServer {
+		return
+	}
+
+	rules, err := ws.db.GetActiveAutoReplyRules(sc.UserID, sc.SessionID)
+	if err != nil {
+		log.Printf("❌ Failed to load auto-reply rules for session %s: %v", sc.SessionID, err)
+		return
+	}
+
+	var rule *WhatsAppAutoReplyRule
+	for i := range rules {
+		if rules[i].matches(content) {
+			rule = &rules[i]
+			break
+		}
+	}
+	if rule == nil {
+		return
+	}
+
+	if rule.Tag != "" {
+		ws.tagSender(sc, evt.Info.Sender, rule.Tag)
+	}
+	if rule.SequenceID != nil {
+		ws.enrollSender(sc, evt.Info.Sender, *rule.SequenceID)
+	}
+
+	replying := rule.Reply != "" && ws.claimAutoReply(sc.SessionID, evt.Info.Chat)
+	if replying {
+		// Sends wait for the safety engine's pacing; don't block the event loop
+		go ws.sendAutoReply(sc, rule, evt.Info.Chat)
+	}
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "auto_reply_matched", map[string]interface{}{
+		"rule_id":    rule.ID,
+		"from":       evt.Info.Sender.String(),
+		"message_id": evt.Info.ID,
+		"tag":        rule.Tag,
+		"replied":    replying,
+	})
+
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "auto_reply_matched",
+		Data: map[string]interface{}{
+			"rule_id": rule.ID,
+			"from":    evt.Info.Sender.String(),
+			"keyword": rule.Keyword,
+			"tag":     rule.Tag,
+			"replied": replying,
+		},
+	})
+}
+
+// claimAutoReply reports whether a chat may get an auto-reply now and, if so,
+// starts its cooldown
+func (ws *WhatsAppService) claimAutoReply(sessionID string, chat types.JID) bool {
+	key := sessionID + "|" + chat.ToNonAD().String()
+	now := time.Now()
+	if last, ok := ws.autoReplies.Load(key); ok && now.Sub(last.(time.Time)) < autoReplyCooldown {
+		return false
+	}
+	ws.autoReplies.Store(key, now)
+	return true
+}
+
+// sendAutoReply renders and sends a rule's reply to a chat
+func (ws *WhatsAppService) sendAutoReply(sc *SessionClient, rule *WhatsAppAutoReplyRule, chat types.JID) {
+	if err := ws.checkSuppressed(sc, chat); err != nil {
+		log.Printf("⚠️  Auto-reply to %s skipped: %v", chat.String(), err)
+		return
+	}
+
+	text := rule.Reply
+	if isTemplate(text) {
+		vars := ws.recipientVariables(sc, chat, ws.templateContacts(sc, []types.JID{chat}))
+		rendered, err := renderMessage(text, vars, rand.New(rand.NewSource(time.Now().UnixNano())), ws.textLengthLimit())
+		if err != nil {
+			log.Printf("❌ Auto-reply rule %d has an invalid reply: %v", rule.ID, err)
+			return
+		}
+		if len(rendered.Warnings) > 0 {
+			log.Printf("⚠️  Auto-reply rule %d to %s: %s", rule.ID, chat.String(), strings.Join(rendered.Warnings, "; "))
+		}
+		text = rendered.Text
+	}
+
+	if _, err := ws.sendTextToJID(sc, chat, text); err != nil {
+		log.Printf("❌ Auto-reply rule %d to %s failed: %v", rule.ID, chat.String(), err)
+	}
+}
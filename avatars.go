@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"gorm.io/gorm"
+	"whatsapp-api/pkg/apierr"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= AVATAR CACHE =============
+// Profile picture URLs returned by WhatsApp point at the CDN, carry access tokens
+// and expire after a while. Pictures are downloaded once, stored on disk keyed by
+// JID and picture ID, and served from there. Cached entries older than
+// AvatarRefreshInterval are re-validated against WhatsApp (which answers with
+// "unchanged" when the picture ID is still current). Picture change
+// notifications (events.Picture) update a cached avatar right away and are
+// kept as a short history per JID.
+
+const (
+	maxAvatarSize        = 5 * 1024 * 1024
+	avatarRefreshBatch   = 50
+	avatarRefreshTick    = 10 * time.Minute
+	avatarRefreshSpacing = 500 * time.Millisecond
+	avatarURLTTL         = time.Hour // lifetime of signed avatar links
+	pictureHistoryLimit  = 10        // picture changes kept per JID
+)
+
+// ErrAvatarNotSet is returned when a contact has no picture or hides it from us
+var ErrAvatarNotSet = errors.New("profile picture not found")
+
+// GetContactAvatar returns the cached avatar of a JID, fetching or refreshing it when needed
+func (ws *WhatsAppService) GetContactAvatar(sessionID string, userID int, jid string, forceRefresh bool) (*WhatsAppAvatar, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	targetJID, err := parseAvatarJID(jid)
+	if err != nil {
+		return nil, err
+	}
+
+	avatar, err := ws.db.GetAvatar(sessionID, targetJID.String())
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load avatar: %w", err)
+	}
+
+	if avatar != nil && !forceRefresh && time.Since(avatar.FetchedAt) < ws.cfg.AvatarRefreshInterval && ws.avatarFileExists(avatar) {
+		if avatar.NotSet {
+			return nil, ErrAvatarNotSet
+		}
+		return avatar, nil
+	}
+
+	sc, err := ws.GetSessionClient(sessionID)
+	if err != nil || !sc.Client.IsConnected() {
+		// Serve the stale copy rather than nothing while the session is offline
+		if avatar != nil && !avatar.NotSet && ws.avatarFileExists(avatar) {
+			return avatar, nil
+		}
+		return nil, apierr.ErrSessionNotConnected
+	}
+
+	avatar, err = ws.refreshAvatar(sc, priorityInteractive, targetJID, avatar)
+	if err != nil {
+		return nil, err
+	}
+	if avatar.NotSet {
+		return nil, ErrAvatarNotSet
+	}
+	return avatar, nil
+}
+
+// refreshAvatar re-validates a cached avatar (or fetches a new one) and stores the result
+func (ws *WhatsAppService) refreshAvatar(sc *SessionClient, priority callPriority, jid types.JID, cached *WhatsAppAvatar) (*WhatsAppAvatar, error) {
+	params := &whatsmeow.GetProfilePictureParams{}
+	if cached != nil && !cached.NotSet && ws.avatarFileExists(cached) {
+		params.ExistingID = cached.PictureID
+	}
+
+	var info *types.ProfilePictureInfo
+	err := ws.callWhatsApp(sc, priority, "profile picture", func() (err error) {
+		info, err = sc.Client.GetProfilePictureInfo(context.Background(), jid, params)
+		return err
+	})
+	if errors.Is(err, whatsmeow.ErrProfilePictureNotSet) || errors.Is(err, whatsmeow.ErrProfilePictureUnauthorized) {
+		if cached != nil {
+			ws.removeAvatarFile(cached)
+		}
+		avatar := &WhatsAppAvatar{
+			SessionID: sc.SessionID,
+			JID:       jid.String(),
+			NotSet:    true,
+			FetchedAt: time.Now(),
+		}
+		if err := ws.db.SaveAvatar(avatar); err != nil {
+			return nil, fmt.Errorf("failed to save avatar: %w", err)
+		}
+		return avatar, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get profile picture: %w", err)
+	}
+
+	// Unchanged since the last fetch
+	if info == nil {
+		if cached == nil {
+			return nil, fmt.Errorf("failed to get profile picture: empty response")
+		}
+		cached.FetchedAt = time.Now()
+		if err := ws.db.SaveAvatar(cached); err != nil {
+			return nil, fmt.Errorf("failed to save avatar: %w", err)
+		}
+		return cached, nil
+	}
+
+	data, err := ws.downloadMediaFromURL(info.URL, maxAvatarSize)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := http.DetectContentType(data)
+	key := avatarKeyPrefix(sc.SessionID) + avatarFileName(jid, info.ID)
+	if err := ws.media.Put(context.Background(), key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		return nil, fmt.Errorf("failed to store avatar: %w", err)
+	}
+
+	if cached != nil && cached.FilePath != "" && cached.FilePath != key {
+		ws.removeAvatarFile(cached)
+	}
+
+	sum := sha256.Sum256(data)
+	avatar := &WhatsAppAvatar{
+		SessionID:   sc.SessionID,
+		JID:         jid.String(),
+		PictureID:   info.ID,
+		FilePath:    key,
+		ContentType: contentType,
+		Size:        len(data),
+		ETag:        hex.EncodeToString(sum[:16]),
+		FetchedAt:   time.Now(),
+	}
+	if err := ws.db.SaveAvatar(avatar); err != nil {
+		return nil, fmt.Errorf("failed to save avatar: %w", err)
+	}
+
+	log.Printf("🖼️  Cached avatar for %s (picture %s, %d bytes)", jid.String(), info.ID, len(data))
+	return avatar, nil
+}
+
+// handlePictureEvent records a profile picture change of a contact or group,
+// brings its cached avatar up to date and notifies the session
+func (ws *WhatsAppService) handlePictureEvent(sc *SessionClient, evt *events.Picture) {
+	jid := evt.JID.ToNonAD()
+	change := &WhatsAppPictureChange{
+		SessionID: sc.SessionID,
+		JID:       jid.String(),
+		PictureID: evt.PictureID,
+		Removed:   evt.Remove,
+		ChangedAt: evt.Timestamp,
+	}
+	if !evt.Author.IsEmpty() {
+		change.Author = evt.Author.ToNonAD().String()
+	}
+	if change.ChangedAt.IsZero() {
+		change.ChangedAt = time.Now()
+	}
+	if err := ws.db.AddPictureChange(change, pictureHistoryLimit); err != nil {
+		log.Printf("⚠️  Failed to record picture change of %s for session %s: %v", jid.String(), sc.SessionID, err)
+	}
+
+	// Only requested avatars are cached; those are kept current
+	if cached, err := ws.db.GetAvatar(sc.SessionID, jid.String()); err == nil {
+		if evt.Remove {
+			ws.removeAvatarFile(cached)
+			*cached = WhatsAppAvatar{
+				SessionID: cached.SessionID,
+				JID:       cached.JID,
+				NotSet:    true,
+				FetchedAt: time.Now(),
+			}
+			if err := ws.db.SaveAvatar(cached); err != nil {
+				log.Printf("⚠️  Failed to save avatar of %s: %v", jid.String(), err)
+			}
+		} else if cached.NotSet || cached.PictureID != evt.PictureID {
+			// Downloading can take a while; don't hold up the session's events
+			sc.enqueue("avatar refresh", func() {
+				if _, err := ws.refreshAvatar(sc, priorityBackground, jid, cached); err != nil {
+					log.Printf("⚠️  Failed to refresh avatar for %s: %v", jid.String(), err)
+				}
+			})
+		}
+	}
+
+	data := map[string]interface{}{
+		"jid":        jid.String(),
+		"is_group":   wajid.IsGroup(jid),
+		"picture_id": evt.PictureID,
+		"removed":    evt.Remove,
+		"timestamp":  change.ChangedAt,
+	}
+	if change.Author != "" {
+		data["author"] = change.Author
+	}
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "picture_changed", data)
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "picture_changed",
+		Data: data,
+	})
+}
+
+// GetPictureHistory returns the recorded picture changes of a contact or
+// group, newest first
+func (ws *WhatsAppService) GetPictureHistory(sessionID string, userID int, jid string) ([]WhatsAppPictureChange, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	targetJID, err := parseAvatarJID(jid)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := ws.db.GetPictureChanges(sessionID, targetJID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load picture changes: %w", err)
+	}
+	return changes, nil
+}
+
+// OpenAvatar opens the stored picture of a cached avatar
+func (ws *WhatsAppService) OpenAvatar(avatar *WhatsAppAvatar) (io.ReadCloser, error) {
+	return ws.media.Open(context.Background(), avatar.FilePath)
+}
+
+// AvatarURL returns a signed link to the stored picture of a cached avatar
+func (ws *WhatsAppService) AvatarURL(avatar *WhatsAppAvatar) (string, time.Time, error) {
+	expiresAt := time.Now().Add(avatarURLTTL)
+	url, err := ws.media.SignedURL(context.Background(), avatar.FilePath, avatarURLTTL)
+	return url, expiresAt, err
+}
+
+// StartAvatarRefresher periodically re-validates stale cached avatars of connected sessions
+func (ws *WhatsAppService) StartAvatarRefresher(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(avatarRefreshTick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ws.refreshStaleAvatars(ctx)
+			}
+		}
+	}()
+	log.Println("✅ Avatar refresher started")
+}
+
+func (ws *WhatsAppService) refreshStaleAvatars(ctx context.Context) {
+	avatars, err := ws.db.GetStaleAvatars(time.Now().Add(-ws.cfg.AvatarRefreshInterval), avatarRefreshBatch)
+	if err != nil {
+		log.Printf("❌ Failed to load stale avatars: %v", err)
+		return
+	}
+
+	refreshed := 0
+	for i := range avatars {
+		avatar := &avatars[i]
+
+		sc, err := ws.GetSessionClient(avatar.SessionID)
+		if err != nil || !sc.Client.IsConnected() {
+			continue
+		}
+
+		jid, err := types.ParseJID(avatar.JID)
+		if err != nil {
+			continue
+		}
+
+		if _, err := ws.refreshAvatar(sc, priorityBackground, jid, avatar); err != nil {
+			log.Printf("⚠️  Failed to refresh avatar for %s: %v", avatar.JID, err)
+			continue
+		}
+		refreshed++
+
+		// Spread profile picture queries out to stay clear of rate limits
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(avatarRefreshSpacing):
+		}
+	}
+
+	if refreshed > 0 {
+		log.Printf("🖼️  Refreshed %d cached avatar(s)", refreshed)
+	}
+}
+
+// parseAvatarJID accepts a user/group JID or a plain phone number
+func parseAvatarJID(jid string) (types.JID, error) {
+	parsed, err := wajid.Parse(jid)
+	if err != nil {
+		return types.JID{}, err
+	}
+	if !wajid.IsUser(parsed) && !wajid.IsGroup(parsed) {
+		return types.JID{}, fmt.Errorf("invalid JID: %s has no profile picture", parsed.String())
+	}
+	return parsed, nil
+}
+
+func avatarFileName(jid types.JID, pictureID string) string {
+	safe := strings.NewReplacer("@", "_", ":", "_", "/", "_", ".", "_").Replace(jid.String())
+	return fmt.Sprintf("%s_%s.jpg", safe, pictureID)
+}
+
+// avatarKeyPrefix is the storage prefix holding the avatars of a session
+func avatarKeyPrefix(sessionID string) string {
+	return "avatars/" + sessionID + "/"
+}
+
+func (ws *WhatsAppService) avatarFileExists(avatar *WhatsAppAvatar) bool {
+	if avatar.NotSet {
+		return true
+	}
+	if avatar.FilePath == "" {
+		return false
+	}
+	exists, err := ws.media.Exists(context.Background(), avatar.FilePath)
+	return err == nil && exists
+}
+
+func (ws *WhatsAppService) removeAvatarFile(avatar *WhatsAppAvatar) {
+	if avatar.FilePath == "" {
+		return
+	}
+	if err := ws.media.Delete(context.Background(), avatar.FilePath); err != nil {
+		log.Printf("⚠️  Failed to remove avatar file %s: %v", avatar.FilePath, err)
+	}
+}
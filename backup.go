@@ -0,0 +1,659 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"whatsapp-api/internal/storage"
+)
+
+// ============= WHATSAPP STORE BACKUPS =============
+// Losing the disk under ./data loses the whatsmeow store, and with it the
+// keys of every linked device: each session would have to be paired again.
+// A backup is a tar.gz holding a consistent copy of the store (VACUUM INTO,
+// safe while the API runs) and the session rows of its devices. It's written
+// to backup storage (BACKUP_STORAGE: BACKUP_DIR on the local disk, or the
+// S3 bucket of the media storage under backups/) and recorded in
+// whats_app_backups. The store is local to each instance, so each instance
+// backs up its own under BACKUP_INSTANCE. With BACKUP_INTERVAL set, the
+// instance takes a backup once its newest one is that old and keeps its
+// BACKUP_KEEP newest; `whatsapp-api backup create` and POST /admin/backups
+// take one on demand.
+//
+// `whatsapp-api backup restore` puts a backup back while the API is stopped:
+// the store file is replaced (the old one is kept next to it) and session
+// rows missing from the database are inserted again. The app database itself
+// isn't dumped; it's expected to have backups of its own. An encrypted store
+// stays encrypted in its backups, so restoring one needs the
+// STORE_ENCRYPTION_KEYS it was encrypted with.
+
+const (
+	backupPollInterval  = time.Minute
+	backupRetryDelay    = 15 * time.Minute // after a failed scheduled backup
+	backupFormatVersion = 1
+	backupManifestFile  = "manifest.json"
+	backupSessionsFile  = "sessions.json"
+	backupMaxJSONSize   = 64 << 20
+)
+
+// backupKeyUnsafe matches what can't be part of a storage key segment
+var backupKeyUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// BackupManifest describes a backup archive
+type BackupManifest struct {
+	Version   int       `json:"version"`
+	Instance  string    `json:"instance"`
+	CreatedAt time.Time `json:"created_at"`
+	Devices   int       `json:"devices"`  // devices in the store
+	Sessions  int       `json:"sessions"` // session rows of those devices
+	StoreSize int64     `json:"store_size"`
+}
+
+// BackupRestoreResult is the outcome of a restore
+type BackupRestoreResult struct {
+	Manifest         BackupManifest
+	PreviousStore    string // where the replaced store was moved, empty if there was none
+	SessionsRestored int64  // session rows that were missing
+}
+
+// BackupManager takes and restores backups of this instance's WhatsApp store
+type BackupManager struct {
+	mu          sync.Mutex // one backup or restore at a time
+	cfg         *Config
+	db          *DatabaseManager
+	storage     storage.MediaStorage
+	instance    string
+	nextAttempt time.Time // of a scheduled backup after a failure
+}
+
+func NewBackupManager(cfg *Config, db *DatabaseManager) (*BackupManager, error) {
+	store, err := storage.New(storage.Config{
+		Backend:           cfg.BackupStorage,
+		Dir:               cfg.BackupDir,
+		S3Bucket:          cfg.S3Bucket,
+		S3Region:          cfg.S3Region,
+		S3Endpoint:        cfg.S3Endpoint,
+		S3AccessKeyID:     cfg.S3AccessKeyID,
+		S3SecretAccessKey: cfg.S3SecretAccessKey,
+		S3ForcePathStyle:  cfg.S3ForcePathStyle,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize backup storage: %w", err)
+	}
+
+	instance := strings.Trim(backupKeyUnsafe.ReplaceAllString(cfg.BackupInstance, "-"), ".-")
+	if instance == "" {
+		instance = "default"
+	}
+	return &BackupManager{cfg: cfg, db: db, storage: store, instance: instance}, nil
+}
+
+// Start takes scheduled backups until the context is cancelled
+func (b *BackupManager) Start(ctx context.Context) {
+	if b.cfg.BackupInterval <= 0 {
+		log.Println("ℹ️  Scheduled backups disabled (BACKUP_INTERVAL not set)")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(backupPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				b.backupIfDue(ctx)
+			}
+		}
+	}()
+	log.Printf("✅ Backup worker started (every %s, keeping %d, instance %s)", b.cfg.BackupInterval, b.cfg.BackupKeep, b.instance)
+}
+
+func (b *BackupManager) backupIfDue(ctx context.Context) {
+	if time.Now().Before(b.nextAttempt) {
+		return
+	}
+	latest, err := b.db.GetLatestBackup(b.instance)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("❌ Failed to load the latest backup: %v", err)
+		return
+	}
+	if latest != nil && time.Since(latest.CreatedAt) < b.cfg.BackupInterval {
+		return
+	}
+
+	if _, err := b.Create(ctx, "scheduled"); err != nil {
+		b.nextAttempt = time.Now().Add(backupRetryDelay)
+		log.Printf("❌ Scheduled backup failed, retrying in %s: %v", backupRetryDelay, err)
+	}
+}
+
+// Create snapshots the store, uploads it with the session rows of its
+// devices and drops the backups beyond BACKUP_KEEP
+func (b *BackupManager) Create(ctx context.Context, trigger string) (*WhatsAppBackup, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(whatsAppStoreDir, whatsAppStoreFile)); err != nil {
+		return nil, fmt.Errorf("WhatsApp store not found: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp("", "whatsapp-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	createdAt := time.Now().UTC()
+	snapshotPath := filepath.Join(tmpDir, whatsAppStoreFile)
+	jids, err := snapshotWhatsAppStore(ctx, snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := os.Stat(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := b.db.GetSessionsByJIDs(jids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sessions: %w", err)
+	}
+
+	manifest := BackupManifest{
+		Version:   backupFormatVersion,
+		Instance:  b.instance,
+		CreatedAt: createdAt,
+		Devices:   len(jids),
+		Sessions:  len(sessions),
+		StoreSize: snapshot.Size(),
+	}
+	archivePath := filepath.Join(tmpDir, "backup.tar.gz")
+	if err := writeBackupArchive(archivePath, manifest, snapshotPath, sessions); err != nil {
+		return nil, fmt.Errorf("failed to write backup archive: %w", err)
+	}
+
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, archive)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("backups/%s/whatsapp-store-%s.tar.gz", b.instance, createdAt.Format("20060102T150405Z"))
+	if err := b.storage.Put(ctx, key, archive, size, "application/gzip"); err != nil {
+		return nil, fmt.Errorf("failed to upload backup: %w", err)
+	}
+
+	backup := &WhatsAppBackup{
+		Instance:   b.instance,
+		StorageKey: key,
+		Size:       size,
+		SHA256:     hex.EncodeToString(hash.Sum(nil)),
+		Sessions:   len(sessions),
+		Trigger:    trigger,
+		CreatedAt:  createdAt,
+	}
+	if err := b.db.CreateBackup(backup); err != nil {
+		return nil, fmt.Errorf("backup uploaded to %s but not recorded: %w", key, err)
+	}
+	log.Printf("💾 Backup %d written to %s (%d KB, %d device(s), %d session(s))", backup.ID, key, size/1024, len(jids), len(sessions))
+
+	b.prune(ctx)
+	return backup, nil
+}
+
+// prune deletes the backups of this instance beyond BACKUP_KEEP
+func (b *BackupManager) prune(ctx context.Context) {
+	backups, err := b.db.GetBackups(b.instance)
+	if err != nil {
+		log.Printf("⚠️  Failed to load backups to prune: %v", err)
+		return
+	}
+	if len(backups) <= b.cfg.BackupKeep {
+		return
+	}
+
+	for _, backup := range backups[b.cfg.BackupKeep:] {
+		if err := b.storage.Delete(ctx, backup.StorageKey); err != nil {
+			log.Printf("⚠️  Failed to delete backup %s: %v", backup.StorageKey, err)
+			continue
+		}
+		if err := b.db.DeleteBackup(backup.ID); err != nil {
+			log.Printf("⚠️  Failed to delete backup record %d: %v", backup.ID, err)
+		}
+	}
+}
+
+// Restore puts a backup back: ref is a backup ID, "latest" (this instance's
+// newest) or a storage key, for backups whose record was lost. The API must
+// be stopped, since the store file is replaced underneath it.
+func (b *BackupManager) Restore(ctx context.Context, ref string) (*BackupRestoreResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key, checksum, err := b.resolveBackup(ref)
+	if err != nil {
+		return nil, err
+	}
+	tmpDir, err := os.MkdirTemp("", "whatsapp-restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archivePath := filepath.Join(tmpDir, "backup.tar.gz")
+	if err := b.download(ctx, key, archivePath, checksum); err != nil {
+		return nil, err
+	}
+
+	// Extracted next to the store, so it can be renamed into place
+	if err := os.MkdirAll(whatsAppStoreDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	restoredPath := filepath.Join(whatsAppStoreDir, ".restore-"+whatsAppStoreFile)
+	defer os.Remove(restoredPath)
+	manifest, sessions, err := readBackupArchive(archivePath, restoredPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup %s: %w", key, err)
+	}
+	if err := checkWhatsAppStore(ctx, restoredPath); err != nil {
+		return nil, fmt.Errorf("backup %s holds a damaged store: %w", key, err)
+	}
+
+	result := &BackupRestoreResult{Manifest: manifest}
+	storePath := filepath.Join(whatsAppStoreDir, whatsAppStoreFile)
+	if _, err := os.Stat(storePath); err == nil {
+		result.PreviousStore = storePath + ".before-restore-" + time.Now().UTC().Format("20060102T150405Z")
+		// A journal belongs to its database file and moves with it
+		for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+			if err := os.Rename(storePath+suffix, result.PreviousStore+suffix); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("failed to move the current store aside: %w", err)
+			}
+		}
+	}
+	if err := os.Rename(restoredPath, storePath); err != nil {
+		return nil, fmt.Errorf("failed to put the restored store in place: %w", err)
+	}
+
+	// Projects aren't part of the backup; a restored row keeps none
+	for i := range sessions {
+		sessions[i].ProjectID = nil
+	}
+	result.SessionsRestored, err = b.db.RestoreSessions(sessions)
+	if err != nil {
+		return result, fmt.Errorf("store restored, but failed to restore session rows: %w", err)
+	}
+	return result, nil
+}
+
+// resolveBackup returns the storage key and checksum of a backup reference
+func (b *BackupManager) resolveBackup(ref string) (string, string, error) {
+	var (
+		backup *WhatsAppBackup
+		err    error
+	)
+	if ref == "latest" {
+		backup, err = b.db.GetLatestBackup(b.instance)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", "", fmt.Errorf("no backups of instance %s (see `whatsapp-api backup list`)", b.instance)
+		}
+	} else if id, parseErr := strconv.ParseInt(ref, 10, 64); parseErr == nil {
+		backup, err = b.db.GetBackup(id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", "", fmt.Errorf("backup %d not found", id)
+		}
+	} else {
+		// A storage key: no record, so no checksum
+		return ref, "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load backup: %w", err)
+	}
+	return backup.StorageKey, backup.SHA256, nil
+}
+
+// download copies a backup to path, checking its checksum when known
+func (b *BackupManager) download(ctx context.Context, key, path, checksum string) error {
+	body, err := b.storage.Open(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("backup %s not found in backup storage", key)
+	} else if err != nil {
+		return fmt.Errorf("failed to download backup: %w", err)
+	}
+	defer body.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), body); err != nil {
+		return fmt.Errorf("failed to download backup: %w", err)
+	}
+	if checksum != "" && hex.EncodeToString(hash.Sum(nil)) != checksum {
+		return fmt.Errorf("backup %s is corrupt: checksum mismatch", key)
+	}
+	return file.Close()
+}
+
+// snapshotWhatsAppStore writes a consistent copy of the store to path and
+// returns the JIDs of its devices
+func snapshotWhatsAppStore(ctx context.Context, path string) ([]string, error) {
+	db, err := sql.Open("sqlite", whatsAppStoreDSN())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return nil, fmt.Errorf("failed to snapshot WhatsApp store: %w", err)
+	}
+
+	snapshot, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Close()
+	rows, err := snapshot.QueryContext(ctx, "SELECT jid FROM whatsmeow_device")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices of the snapshot: %w", err)
+	}
+	defer rows.Close()
+
+	var jids []string
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, err
+		}
+		jids = append(jids, jid)
+	}
+	return jids, rows.Err()
+}
+
+// checkWhatsAppStore runs SQLite's quick integrity check on a store file
+func checkWhatsAppStore(ctx context.Context, path string) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRowContext(ctx, "PRAGMA quick_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return errors.New(result)
+	}
+	return nil
+}
+
+// writeBackupArchive writes the manifest, the store snapshot and the session
+// rows as a tar.gz
+func writeBackupArchive(path string, manifest BackupManifest, storePath string, sessions []WhatsAppSession) error {
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	sessionsJSON, err := json.Marshal(sessions)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{{backupManifestFile, manifestJSON}, {backupSessionsFile, sessionsJSON}} {
+		header := &tar.Header{Name: entry.name, Mode: 0600, Size: int64(len(entry.data)), ModTime: manifest.CreatedAt}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			return err
+		}
+	}
+
+	store, err := os.Open(storePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	info, err := store.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: whatsAppStoreFile, Mode: 0600, Size: info.Size(), ModTime: manifest.CreatedAt}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, store); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// readBackupArchive extracts the store of a backup archive to storePath and
+// returns its manifest and session rows
+func readBackupArchive(path, storePath string) (BackupManifest, []WhatsAppSession, error) {
+	var (
+		manifest  BackupManifest
+		sessions  []WhatsAppSession
+		seen      = make(map[string]bool)
+		storeSize int64
+	)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return manifest, nil, err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return manifest, nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, nil, err
+		}
+
+		switch header.Name {
+		case backupManifestFile, backupSessionsFile:
+			data, err := io.ReadAll(io.LimitReader(tr, backupMaxJSONSize))
+			if err != nil {
+				return manifest, nil, err
+			}
+			target := any(&manifest)
+			if header.Name == backupSessionsFile {
+				target = &sessions
+			}
+			if err := json.Unmarshal(data, target); err != nil {
+				return manifest, nil, fmt.Errorf("invalid %s: %w", header.Name, err)
+			}
+		case whatsAppStoreFile:
+			store, err := os.OpenFile(storePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+			if err != nil {
+				return manifest, nil, err
+			}
+			storeSize, err = io.Copy(store, tr)
+			if closeErr := store.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return manifest, nil, err
+			}
+		default:
+			continue
+		}
+		seen[header.Name] = true
+	}
+
+	for _, name := range []string{backupManifestFile, backupSessionsFile, whatsAppStoreFile} {
+		if !seen[name] {
+			return manifest, nil, fmt.Errorf("%s missing", name)
+		}
+	}
+	if manifest.Version < 1 || manifest.Version > backupFormatVersion {
+		return manifest, nil, fmt.Errorf("unsupported backup version %d", manifest.Version)
+	}
+	if storeSize != manifest.StoreSize {
+		return manifest, nil, fmt.Errorf("store is %d bytes, expected %d", storeSize, manifest.StoreSize)
+	}
+	return manifest, sessions, nil
+}
+
+// HandleList serves GET /api/v1/admin/backups: the backups of all instances,
+// or of ?instance=
+func (b *BackupManager) HandleList(c *gin.Context) {
+	backups, err := b.db.GetBackups(c.Query("instance"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to load backups",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"data":     backups,
+		"instance": b.instance,
+	})
+}
+
+// HandleCreate serves POST /api/v1/admin/backups: a backup of this instance
+// taken right away
+func (b *BackupManager) HandleCreate(c *gin.Context) {
+	backup, err := b.Create(c.Request.Context(), "api")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    backup,
+	})
+}
+
+// runBackupCommand runs `whatsapp-api backup create|list|restore`
+func runBackupCommand(cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected create, list or restore")
+	}
+
+	gormDB, err := openAppDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	dm := &DatabaseManager{db: gormDB}
+	defer dm.Close()
+	backups, err := NewBackupManager(cfg, dm)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		backup, err := backups.Create(context.Background(), "manual")
+		if err != nil {
+			return err
+		}
+		log.Printf("✅ Backup %d written to %s", backup.ID, backup.StorageKey)
+		return nil
+
+	case "list":
+		instance := ""
+		if len(args) > 1 {
+			instance = args[1]
+		}
+		list, err := dm.GetBackups(instance)
+		if err != nil {
+			return fmt.Errorf("failed to load backups: %w", err)
+		}
+		printBackups(list)
+		return nil
+
+	case "restore":
+		if len(args) < 2 {
+			return errors.New("expected a backup ID, latest or a storage key")
+		}
+		result, err := backups.Restore(context.Background(), args[1])
+		if err != nil {
+			return err
+		}
+		if result.PreviousStore != "" {
+			log.Printf("   Previous store moved to %s", result.PreviousStore)
+		}
+		log.Printf("✅ Restored the store of %s taken %s (%d device(s)); %d of %d session row(s) were missing and inserted again",
+			result.Manifest.Instance, result.Manifest.CreatedAt.Format(time.RFC3339), result.Manifest.Devices,
+			result.SessionsRestored, result.Manifest.Sessions)
+		return nil
+	}
+	return fmt.Errorf("unknown backup command %q (expected create, list or restore)", args[0])
+}
+
+// printBackups prints backups as a table
+func printBackups(backups []WhatsAppBackup) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tINSTANCE\tCREATED\tSIZE\tSESSIONS\tTRIGGER\tKEY")
+	for _, backup := range backups {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d KB\t%d\t%s\t%s\n",
+			backup.ID, backup.Instance, backup.CreatedAt.Format(time.RFC3339), backup.Size/1024,
+			backup.Sessions, backup.Trigger, backup.StorageKey)
+	}
+	w.Flush()
+	fmt.Printf("%d backup(s)\n", len(backups))
+}
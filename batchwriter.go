@@ -0,0 +1,240 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// ============= BATCH WRITER =============
+// Events (CreateEvent) and incoming messages (QueueMessage) are buffered and
+// written in multi-row INSERTs: when EVENT_BATCH_SIZE rows are pending or
+// every EVENT_FLUSH_INTERVAL, whichever comes first. The buffer holds
+// EVENT_BUFFER_SIZE rows of each kind; adding to a full buffer waits for the
+// writer, which pushes back on the session workers instead of growing memory
+// while the database is slow. A batch that fails is retried row by row so one
+// bad row doesn't lose the others. Code that reads a queued row right away
+// (edits and reactions of a message that just arrived) calls FlushWrites
+// first. Close flushes what is left.
+
+// BatchWriterStats are the metrics of the batch writer
+type BatchWriterStats struct {
+	BufferedEvents   int        `json:"buffered_events"`
+	BufferedMessages int        `json:"buffered_messages"`
+	EventsWritten    int64      `json:"events_written"`
+	MessagesWritten  int64      `json:"messages_written"`
+	Batches          int64      `json:"batches"`
+	FailedRows       int64      `json:"failed_rows"`
+	Waits            int64      `json:"waits"` // adds that waited for a full buffer
+	LastFlush        *time.Time `json:"last_flush,omitempty"`
+	LastError        string     `json:"last_error,omitempty"`
+}
+
+// BatchWriter buffers event and message inserts of a DatabaseManager
+type BatchWriter struct {
+	dm        *DatabaseManager
+	batchSize int
+	interval  time.Duration
+
+	events   chan *WhatsAppEvent
+	messages chan *WhatsAppMessage
+	flushes  chan chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+
+	eventsWritten   atomic.Int64
+	messagesWritten atomic.Int64
+	batches         atomic.Int64
+	failedRows      atomic.Int64
+	waits           atomic.Int64
+
+	mu        sync.Mutex
+	lastFlush time.Time
+	lastError string
+}
+
+// StartBatchWriter starts buffering event and message inserts
+func (dm *DatabaseManager) StartBatchWriter(batchSize, bufferSize int, interval time.Duration) {
+	w := &BatchWriter{
+		dm:        dm,
+		batchSize: batchSize,
+		interval:  interval,
+		events:    make(chan *WhatsAppEvent, bufferSize),
+		messages:  make(chan *WhatsAppMessage, bufferSize),
+		flushes:   make(chan chan struct{}),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	dm.writer = w
+	go w.run()
+	log.Printf("✅ Batch writer started (batches of %d, every %s)", batchSize, interval)
+}
+
+// QueueMessage stores an incoming message with the next batch, ignoring
+// duplicates like SaveMessage; without a batch writer it is saved right away
+func (dm *DatabaseManager) QueueMessage(message *WhatsAppMessage) error {
+	if dm.writer == nil {
+		return dm.SaveMessage(message)
+	}
+	select {
+	case dm.writer.messages <- message:
+	default:
+		dm.writer.waits.Add(1)
+		dm.writer.messages <- message
+	}
+	return nil
+}
+
+// FlushWrites writes the buffered events and messages and waits for it
+func (dm *DatabaseManager) FlushWrites() {
+	if dm.writer == nil {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case dm.writer.flushes <- done:
+		<-done
+	case <-dm.writer.done:
+	}
+}
+
+// BatchWriterStats returns the metrics of the batch writer, nil without one
+func (dm *DatabaseManager) BatchWriterStats() *BatchWriterStats {
+	if dm.writer == nil {
+		return nil
+	}
+	w := dm.writer
+	stats := &BatchWriterStats{
+		BufferedEvents:   len(w.events),
+		BufferedMessages: len(w.messages),
+		EventsWritten:    w.eventsWritten.Load(),
+		MessagesWritten:  w.messagesWritten.Load(),
+		Batches:          w.batches.Load(),
+		FailedRows:       w.failedRows.Load(),
+		Waits:            w.waits.Load(),
+	}
+	w.mu.Lock()
+	if !w.lastFlush.IsZero() {
+		lastFlush := w.lastFlush
+		stats.LastFlush = &lastFlush
+	}
+	stats.LastError = w.lastError
+	w.mu.Unlock()
+	return stats
+}
+
+// addEvent queues an event, waiting while the buffer is full
+func (w *BatchWriter) addEvent(event *WhatsAppEvent) {
+	select {
+	case w.events <- event:
+	default:
+		w.waits.Add(1)
+		w.events <- event
+	}
+}
+
+// close writes what is buffered and stops the writer
+func (w *BatchWriter) close() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *BatchWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	events := make([]*WhatsAppEvent, 0, w.batchSize)
+	messages := make([]*WhatsAppMessage, 0, w.batchSize)
+	flush := func() {
+		// Rows queued before a flush request are part of it
+		for len(w.events) > 0 {
+			events = append(events, <-w.events)
+		}
+		for len(w.messages) > 0 {
+			messages = append(messages, <-w.messages)
+		}
+		w.writeEvents(events)
+		w.writeMessages(messages)
+		events, messages = events[:0], messages[:0]
+	}
+
+	for {
+		select {
+		case event := <-w.events:
+			if events = append(events, event); len(events) >= w.batchSize {
+				w.writeEvents(events)
+				events = events[:0]
+			}
+		case message := <-w.messages:
+			if messages = append(messages, message); len(messages) >= w.batchSize {
+				w.writeMessages(messages)
+				messages = messages[:0]
+			}
+		case <-ticker.C:
+			flush()
+		case done := <-w.flushes:
+			flush()
+			close(done)
+		case <-w.stop:
+			flush()
+			return
+		}
+	}
+}
+
+func (w *BatchWriter) writeEvents(events []*WhatsAppEvent) {
+	if len(events) == 0 {
+		return
+	}
+	err := w.dm.db.CreateInBatches(events, w.batchSize).Error
+	if err != nil {
+		// Retry one by one so a bad row doesn't lose the batch
+		for _, event := range events {
+			if rowErr := w.dm.db.Create(event).Error; rowErr != nil {
+				w.failedRows.Add(1)
+				log.Printf("❌ Failed to write %s event of session %s: %v", event.EventType, event.SessionID, rowErr)
+				continue
+			}
+			w.eventsWritten.Add(1)
+		}
+	} else {
+		w.eventsWritten.Add(int64(len(events)))
+	}
+	w.flushed(err)
+}
+
+func (w *BatchWriter) writeMessages(messages []*WhatsAppMessage) {
+	if len(messages) == 0 {
+		return
+	}
+	err := w.dm.db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(messages, w.batchSize).Error
+	if err != nil {
+		for _, message := range messages {
+			if rowErr := w.dm.SaveMessage(message); rowErr != nil {
+				w.failedRows.Add(1)
+				log.Printf("❌ Failed to write message %s of session %s: %v", message.MessageID, message.SessionID, rowErr)
+				continue
+			}
+			w.messagesWritten.Add(1)
+		}
+	} else {
+		w.messagesWritten.Add(int64(len(messages)))
+	}
+	w.flushed(err)
+}
+
+// flushed records a written batch and the error of its multi-row insert
+func (w *BatchWriter) flushed(err error) {
+	w.batches.Add(1)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastFlush = time.Now()
+	if err != nil {
+		w.lastError = err.Error()
+	}
+}
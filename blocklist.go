@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= BLOCKLIST =============
+// The blocklist of each session's account is stored per session and rolled
+// up into contacts.is_blocked (blocked by any session of the user), so a
+// block or unblock on the phone shows up here too. WhatsApp pushes the
+// changes as events.Blocklist; a push of action "modify" carries no changes
+// and makes the whole list be fetched again. A push can be missed while the
+// session is offline, so every BLOCKLIST_SYNC_INTERVAL the stored list is
+// reconciled with GetBlocklist, tracked by the session's blocklist_synced_at
+// watermark like the contact sync. LIDs are stored as their phone JID when
+// the session knows the mapping, so they match the contacts.
+
+const blocklistSyncPollInterval = time.Minute
+
+// BlocklistSyncResult is the outcome of a blocklist reconciliation
+type BlocklistSyncResult struct {
+	Blocked  int       `json:"blocked"`   // JIDs on the blocklist
+	Added    []string  `json:"added"`     // blocked since the last sync
+	Removed  []string  `json:"removed"`   // unblocked since the last sync
+	SyncedAt time.Time `json:"synced_at"` // when the list was fetched
+}
+
+// StartBlocklistSyncWorker starts the periodic blocklist reconciliation
+func (ws *WhatsAppService) StartBlocklistSyncWorker(ctx context.Context) {
+	if ws.cfg.BlocklistSyncInterval <= 0 {
+		log.Println("ℹ️  Blocklist sync worker disabled (blocklists follow events only)")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(blocklistSyncPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ws.processBlocklistSync(ctx)
+			}
+		}
+	}()
+	log.Println("✅ Blocklist sync worker started")
+}
+
+func (ws *WhatsAppService) processBlocklistSync(ctx context.Context) {
+	sessionIDs, err := ws.db.GetBlocklistSyncDueSessions(time.Now().Add(-ws.cfg.BlocklistSyncInterval))
+	if err != nil {
+		log.Printf("❌ Failed to load sessions due for blocklist sync: %v", err)
+		return
+	}
+
+	for _, sessionID := range sessionIDs {
+		if ctx.Err() != nil {
+			return
+		}
+		sc, ok := ws.sessions.Get(sessionID)
+		if !ok {
+			continue // connected on another instance
+		}
+		if !sc.Client.IsConnected() || !sc.Client.IsLoggedIn() {
+			continue
+		}
+		if _, err := ws.syncSessionBlocklist(sc); err != nil {
+			log.Printf("❌ Blocklist sync failed for session %s: %v", sessionID, err)
+		}
+	}
+}
+
+// SyncBlocklist reconciles the stored blocklist of a connected session now
+func (ws *WhatsAppService) SyncBlocklist(sessionID string, userID int) (*BlocklistSyncResult, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+	sc, err := ws.GetSessionClient(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !sc.Client.IsConnected() || !sc.Client.IsLoggedIn() {
+		return nil, apierr.ErrSessionNotConnected
+	}
+	return ws.syncSessionBlocklist(sc)
+}
+
+// GetBlocklist returns the stored blocklist of a session, most recently
+// blocked first
+func (ws *WhatsAppService) GetBlocklist(sessionID string, userID int) ([]WhatsAppBlockedContact, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	blocked, err := ws.db.GetBlocklist(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load blocklist: %w", err)
+	}
+	return blocked, nil
+}
+
+// syncSessionBlocklist fetches the blocklist of a session, stores the
+// differences and moves the session's watermark
+func (ws *WhatsAppService) syncSessionBlocklist(sc *SessionClient) (*BlocklistSyncResult, error) {
+	startedAt := time.Now()
+	var blocklist *types.Blocklist
+	err := ws.callWhatsApp(sc, priorityBackground, "get blocklist", func() error {
+		var err error
+		blocklist, err = sc.Client.GetBlocklist(context.Background())
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blocklist: %w", err)
+	}
+
+	jids := make([]string, 0, len(blocklist.JIDs))
+	for _, jid := range blocklist.JIDs {
+		jids = append(jids, ws.blocklistJID(sc, jid))
+	}
+	added, removed, err := ws.db.ReplaceBlocklist(sc.SessionID, sc.UserID, jids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store blocklist: %w", err)
+	}
+	if err := ws.db.SetBlocklistSynced(sc.SessionID, startedAt); err != nil {
+		log.Printf("❌ Failed to update blocklist sync watermark of session %s: %v", sc.SessionID, err)
+	}
+
+	if len(added) > 0 || len(removed) > 0 {
+		log.Printf("🚫 Blocklist sync for session %s: %d blocked, %d unblocked", sc.SessionID, len(added), len(removed))
+		ws.emitBlocklistChanged(sc, added, removed, "sync")
+	}
+	return &BlocklistSyncResult{
+		Blocked:  len(jids),
+		Added:    added,
+		Removed:  removed,
+		SyncedAt: startedAt,
+	}, nil
+}
+
+// handleBlocklistEvent applies a blocklist push from the phone
+func (ws *WhatsAppService) handleBlocklistEvent(sc *SessionClient, evt *events.Blocklist) {
+	if evt.Action == events.BlocklistActionModify || len(evt.Changes) == 0 {
+		if _, err := ws.syncSessionBlocklist(sc); err != nil {
+			log.Printf("❌ Blocklist sync failed for session %s: %v", sc.SessionID, err)
+		}
+		return
+	}
+
+	var blocked, unblocked []string
+	for _, change := range evt.Changes {
+		jid := ws.blocklistJID(sc, change.JID)
+		block := change.Action == events.BlocklistChangeActionBlock
+		changed, err := ws.db.SetBlocked(sc.SessionID, sc.UserID, jid, block)
+		if err != nil {
+			log.Printf("⚠️  Failed to store blocklist change of %s for session %s: %v", jid, sc.SessionID, err)
+			continue
+		}
+		switch {
+		case !changed:
+		case block:
+			blocked = append(blocked, jid)
+		default:
+			unblocked = append(unblocked, jid)
+		}
+	}
+	if len(blocked) > 0 || len(unblocked) > 0 {
+		ws.emitBlocklistChanged(sc, blocked, unblocked, "push")
+	}
+}
+
+// blocklistJID is the stored form of a blocklist JID: the phone JID of a
+// LID when the session knows it
+func (ws *WhatsAppService) blocklistJID(sc *SessionClient, jid types.JID) string {
+	jid = jid.ToNonAD()
+	if jid.Server == types.HiddenUserServer {
+		if phone := ws.phoneForJID(sc, jid); phone != "" {
+			return types.NewJID(phone, types.DefaultUserServer).String()
+		}
+	}
+	return jid.String()
+}
+
+// emitBlocklistChanged reports the JIDs a session blocked and unblocked
+func (ws *WhatsAppService) emitBlocklistChanged(sc *SessionClient, blocked, unblocked []string, source string) {
+	data := map[string]interface{}{
+		"blocked":   blocked,
+		"unblocked": unblocked,
+		"source":    source, // push from the phone or sync
+	}
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "blocklist_changed", data)
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "blocklist_changed",
+		Data: data,
+	})
+}
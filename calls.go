@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= CALLS =============
+// Incoming call offers (1:1 CallOffer, group CallOfferNotice) are stored in
+// the calls table and emitted as incoming_call. Accepts on another device,
+// rejects and terminations update the stored call (ringing calls that end
+// become missed) and emit call_ended. With the session's call_auto_reject
+// setting every offer is rejected right away and call_reject_message, if
+// set, is sent to the caller.
+
+const callRejectMessageMaxLength = 1000
+
+// CallSettingsRequest changes the call settings of a session
+type CallSettingsRequest struct {
+	AutoReject    *bool   `json:"auto_reject"`
+	RejectMessage *string `json:"reject_message"`
+}
+
+// CallSettings are the call settings of a session
+type CallSettings struct {
+	AutoReject    bool   `json:"auto_reject"`
+	RejectMessage string `json:"reject_message"`
+}
+
+// handleCallOffer stores an incoming call and auto-rejects it if the session
+// asks for it
+func (ws *WhatsAppService) handleCallOffer(sc *SessionClient, meta types.BasicCallMeta, media string) {
+	caller := meta.CallCreator
+	if caller.IsEmpty() {
+		caller = meta.From
+	}
+	caller = caller.ToNonAD()
+
+	call := &WhatsAppCall{
+		UserID:    sc.UserID,
+		SessionID: sc.SessionID,
+		CallID:    meta.CallID,
+		From:      caller.String(),
+		Media:     media,
+		Status:    CallRinging,
+		OfferedAt: meta.Timestamp,
+	}
+	if !meta.GroupJID.IsEmpty() {
+		call.GroupJID = meta.GroupJID.String()
+	}
+	created, err := ws.db.CreateCall(call)
+	if err != nil {
+		log.Printf("⚠️  Failed to store call %s for session %s: %v", meta.CallID, sc.SessionID, err)
+	} else if !created {
+		return
+	}
+
+	log.Printf("📞 Incoming %s call %s from %s on session %s", media, meta.CallID, call.From, sc.SessionID)
+	ws.emitCallEvent(sc, "incoming_call", map[string]interface{}{
+		"call_id":    call.CallID,
+		"from":       call.From,
+		"group_jid":  call.GroupJID,
+		"media":      call.Media,
+		"is_group":   call.GroupJID != "",
+		"offered_at": call.OfferedAt,
+	})
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	session, err := ws.db.GetSession(sessionUUID, sc.UserID)
+	if err != nil || !session.CallAutoReject {
+		return
+	}
+	ws.autoRejectCall(sc, meta, caller, session.CallRejectMessage)
+}
+
+// autoRejectCall rejects a call and sends the reject message to the caller
+func (ws *WhatsAppService) autoRejectCall(sc *SessionClient, meta types.BasicCallMeta, caller types.JID, message string) {
+	err := ws.callWhatsApp(sc, priorityInteractive, "reject call", func() error {
+		return sc.Client.RejectCall(context.Background(), meta.From, meta.CallID)
+	})
+	if err != nil {
+		log.Printf("❌ Failed to reject call %s for session %s: %v", meta.CallID, sc.SessionID, err)
+		return
+	}
+
+	rejectedAt := time.Now()
+	if err := ws.db.UpdateCall(sc.SessionID, meta.CallID, map[string]interface{}{
+		"status":        CallRejected,
+		"auto_rejected": true,
+		"ended_at":      rejectedAt,
+	}); err != nil {
+		log.Printf("⚠️  Failed to store rejection of call %s: %v", meta.CallID, err)
+	}
+
+	data := map[string]interface{}{
+		"call_id":  meta.CallID,
+		"from":     caller.String(),
+		"status":   CallRejected,
+		"ended_at": rejectedAt,
+	}
+	if message != "" {
+		resp, err := ws.sendMessage(sc, caller, &waE2E.Message{
+			ExtendedTextMessage: &waE2E.ExtendedTextMessage{Text: proto.String(message)},
+		})
+		if err != nil {
+			log.Printf("❌ Failed to send call reject message to %s: %v", caller, err)
+			data["reply_error"] = err.Error()
+		} else {
+			data["reply_message_id"] = resp.ID
+		}
+	}
+
+	log.Printf("📵 Auto-rejected call %s from %s on session %s", meta.CallID, caller, sc.SessionID)
+	ws.emitCallEvent(sc, "call_rejected", data)
+}
+
+// handleCallAccept records a call answered on another device
+func (ws *WhatsAppService) handleCallAccept(sc *SessionClient, meta types.BasicCallMeta) {
+	if err := ws.db.UpdateCall(sc.SessionID, meta.CallID, map[string]interface{}{"status": CallAccepted}); err != nil {
+		log.Printf("⚠️  Failed to store accept of call %s: %v", meta.CallID, err)
+	}
+}
+
+// handleCallEnd records the end of a call
+func (ws *WhatsAppService) handleCallEnd(sc *SessionClient, meta types.BasicCallMeta, reason string) {
+	if err := ws.db.EndCall(sc.SessionID, meta.CallID, reason, meta.Timestamp); err != nil {
+		log.Printf("⚠️  Failed to store end of call %s: %v", meta.CallID, err)
+	}
+
+	ws.emitCallEvent(sc, "call_ended", map[string]interface{}{
+		"call_id":  meta.CallID,
+		"from":     meta.From.ToNonAD().String(),
+		"reason":   reason,
+		"ended_at": meta.Timestamp,
+	})
+}
+
+// emitCallEvent stores a call event and pushes it to clients
+func (ws *WhatsAppService) emitCallEvent(sc *SessionClient, eventType string, data map[string]interface{}) {
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, eventType, data)
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: eventType,
+		Data: data,
+	})
+}
+
+// handleCallEvent dispatches the call events of a session
+func (ws *WhatsAppService) handleCallEvent(sc *SessionClient, evt interface{}) {
+	switch v := evt.(type) {
+	case *events.CallOffer:
+		media := "audio"
+		if v.Data != nil && v.Data.GetChildByTag("video").Tag == "video" {
+			media = "video"
+		}
+		ws.handleCallOffer(sc, v.BasicCallMeta, media)
+	case *events.CallOfferNotice:
+		ws.handleCallOffer(sc, v.BasicCallMeta, v.Media)
+	case *events.CallAccept:
+		ws.handleCallAccept(sc, v.BasicCallMeta)
+	case *events.CallReject:
+		ws.handleCallEnd(sc, v.BasicCallMeta, "rejected")
+	case *events.CallTerminate:
+		ws.handleCallEnd(sc, v.BasicCallMeta, v.Reason)
+	}
+}
+
+// GetCallSettings returns the call settings of a session
+func (ws *WhatsAppService) GetCallSettings(sessionID string, userID int) (*CallSettings, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+	return &CallSettings{
+		AutoReject:    session.CallAutoReject,
+		RejectMessage: session.CallRejectMessage,
+	}, nil
+}
+
+// UpdateCallSettings changes the auto-reject setting or reject message of a session
+func (ws *WhatsAppService) UpdateCallSettings(sessionID string, userID int, req CallSettingsRequest) (*CallSettings, error) {
+	updates := make(map[string]interface{})
+	if req.AutoReject != nil {
+		updates["call_auto_reject"] = *req.AutoReject
+	}
+	if req.RejectMessage != nil {
+		message := strings.TrimSpace(*req.RejectMessage)
+		if len(message) > callRejectMessageMaxLength {
+			return nil, fmt.Errorf("reject_message is longer than %d characters", callRejectMessageMaxLength)
+		}
+		updates["call_reject_message"] = message
+	}
+	if len(updates) == 0 {
+		return nil, fmt.Errorf("nothing to update")
+	}
+
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+	if err := ws.db.UpdateSessionCallSettings(sessionID, updates); err != nil {
+		return nil, fmt.Errorf("failed to update call settings: %w", err)
+	}
+
+	log.Printf("📞 Call settings of session %s updated: %v", sessionID, updates)
+	return ws.GetCallSettings(sessionID, userID)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ============= CAMPAIGN RECEIPTS =============
+// Delivery and read receipts of campaign messages are counted on the
+// campaign (delivered, read) and on the recipient (delivered_at, read_at).
+// A campaign created with receipt_mode "aggregate" doesn't produce a receipt
+// event per message; instead a campaign_receipts event is emitted each time
+// the delivered or read share of its reachable recipients (total minus
+// failed and suppressed) crosses one of its thresholds, CAMPAIGN_RECEIPT_THRESHOLDS
+// unless the campaign sets its own. Receipts are handled on the session
+// worker, so the counters and milestones of a campaign are updated by one
+// goroutine at a time.
+
+// parseReceiptThresholds parses a comma separated list of ascending
+// percentages between 1 and 100
+func parseReceiptThresholds(value string) ([]int, error) {
+	var thresholds []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		threshold, err := strconv.Atoi(part)
+		if err != nil || threshold < 1 || threshold > 100 {
+			return nil, fmt.Errorf("invalid receipt threshold %q: expected a percentage between 1 and 100", part)
+		}
+		if len(thresholds) > 0 && threshold <= thresholds[len(thresholds)-1] {
+			return nil, fmt.Errorf("receipt thresholds must be ascending")
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	if len(thresholds) == 0 {
+		return nil, fmt.Errorf("at least one receipt threshold is required")
+	}
+	return thresholds, nil
+}
+
+// campaignReceiptSettings validates the receipt options of a new campaign
+// and returns its mode and thresholds
+func (ws *WhatsAppService) campaignReceiptSettings(mode string, thresholds []int) (CampaignReceiptMode, string, error) {
+	switch CampaignReceiptMode(mode) {
+	case "", CampaignReceiptsPerMessage:
+		if len(thresholds) > 0 {
+			return "", "", fmt.Errorf("receipt_thresholds requires receipt_mode aggregate")
+		}
+		return CampaignReceiptsPerMessage, "", nil
+	case CampaignReceiptsAggregate:
+	default:
+		return "", "", fmt.Errorf("invalid receipt_mode %q: expected message or aggregate", mode)
+	}
+
+	if len(thresholds) == 0 {
+		return CampaignReceiptsAggregate, ws.cfg.CampaignReceiptThresholds, nil
+	}
+	parts := make([]string, len(thresholds))
+	for i, threshold := range thresholds {
+		parts[i] = strconv.Itoa(threshold)
+	}
+	value := strings.Join(parts, ",")
+	if _, err := parseReceiptThresholds(value); err != nil {
+		return "", "", err
+	}
+	return CampaignReceiptsAggregate, value, nil
+}
+
+// campaignReceiptCount are the new receipts of one campaign
+type campaignReceiptCount struct {
+	delivered, read int
+}
+
+// recordCampaignReceipts counts the delivery or read receipts of campaign
+// messages. It reports whether every message belongs to an aggregate
+// campaign, whose per-message receipt events are left out.
+func (ws *WhatsAppService) recordCampaignReceipts(sc *SessionClient, messageIDs []string, read bool, at time.Time) bool {
+	recipients, err := ws.db.GetCampaignRecipientsByMessageIDs(sc.SessionID, messageIDs)
+	if err != nil {
+		log.Printf("⚠️  Failed to load campaign recipients of receipts for session %s: %v", sc.SessionID, err)
+		return false
+	}
+	if len(recipients) == 0 {
+		return false
+	}
+
+	counts := make(map[int64]*campaignReceiptCount)
+	matched := make(map[string]bool, len(recipients))
+	for _, recipient := range recipients {
+		matched[recipient.MessageID] = true
+		count := counts[recipient.CampaignID]
+		if count == nil {
+			count = &campaignReceiptCount{}
+			counts[recipient.CampaignID] = count
+		}
+		delivered, seen, err := ws.db.MarkCampaignRecipientReceipt(recipient.ID, read, at)
+		if err != nil {
+			log.Printf("⚠️  Failed to store receipt of campaign recipient %d: %v", recipient.ID, err)
+			continue
+		}
+		if delivered {
+			count.delivered++
+		}
+		if seen {
+			count.read++
+		}
+	}
+
+	campaignIDs := make([]int64, 0, len(counts))
+	for campaignID, count := range counts {
+		campaignIDs = append(campaignIDs, campaignID)
+		if count.delivered == 0 && count.read == 0 {
+			continue
+		}
+		err := ws.db.UpdateCampaign(campaignID, map[string]interface{}{
+			"delivered":  gorm.Expr("delivered + ?", count.delivered),
+			"read_count": gorm.Expr("read_count + ?", count.read),
+		})
+		if err != nil {
+			log.Printf("⚠️  Failed to count receipts of campaign %d: %v", campaignID, err)
+		}
+	}
+
+	campaigns, err := ws.db.GetCampaignsByID(campaignIDs)
+	if err != nil {
+		log.Printf("⚠️  Failed to load campaigns of receipts for session %s: %v", sc.SessionID, err)
+		return false
+	}
+	aggregated := len(matched) == len(messageIDs)
+	for i := range campaigns {
+		if campaigns[i].ReceiptMode != CampaignReceiptsAggregate {
+			aggregated = false
+			continue
+		}
+		ws.reportCampaignReceipts(&campaigns[i])
+	}
+	return aggregated
+}
+
+// reportCampaignReceipts emits a campaign_receipts event for each metric
+// whose share of the reachable recipients crossed a new threshold
+func (ws *WhatsAppService) reportCampaignReceipts(campaign *WhatsAppCampaign) {
+	reachable := campaign.Total - campaign.Failed - campaign.Suppressed
+	if reachable <= 0 {
+		return
+	}
+	thresholds, err := parseReceiptThresholds(campaign.ReceiptThresholds)
+	if err != nil {
+		log.Printf("⚠️  Campaign %d: %v", campaign.ID, err)
+		return
+	}
+
+	metrics := []struct {
+		name      string
+		count     int
+		milestone *int
+		column    string
+	}{
+		{"delivered", campaign.Delivered, &campaign.DeliveredMilestone, "delivered_milestone"},
+		{"read", campaign.Read, &campaign.ReadMilestone, "read_milestone"},
+	}
+	for _, metric := range metrics {
+		percent := metric.count * 100 / reachable
+		// The highest threshold reached; thresholds are ascending
+		i := sort.SearchInts(thresholds, percent+1)
+		if i == 0 || thresholds[i-1] <= *metric.milestone {
+			continue
+		}
+		threshold := thresholds[i-1]
+		if err := ws.db.UpdateCampaign(campaign.ID, map[string]interface{}{metric.column: threshold}); err != nil {
+			log.Printf("⚠️  Failed to store %s milestone of campaign %d: %v", metric.name, campaign.ID, err)
+			continue
+		}
+		*metric.milestone = threshold
+
+		data := map[string]interface{}{
+			"campaign_id": campaign.ID,
+			"name":        campaign.Name,
+			"metric":      metric.name,
+			"threshold":   threshold,
+			"percent":     percent,
+			"reachable":   reachable,
+			"total":       campaign.Total,
+			"sent":        campaign.Sent,
+			"delivered":   campaign.Delivered,
+			"read":        campaign.Read,
+			"failed":      campaign.Failed,
+			"suppressed":  campaign.Suppressed,
+		}
+		sessionUUID, _ := uuid.Parse(campaign.SessionID)
+		ws.db.CreateEvent(sessionUUID, campaign.UserID, "campaign_receipts", data)
+		ws.wsManager.SendToSession(campaign.SessionID, WebSocketMessage{
+			Type: "campaign_receipts",
+			Data: data,
+		})
+		log.Printf("📬 Campaign %d: %d%% %s", campaign.ID, threshold, metric.name)
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"gorm.io/gorm"
+	"whatsapp-api/pkg/apierr"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= CAMPAIGNS =============
+// A campaign sends one message (text, or a media handle with caption) to a
+// list of recipients in the background. Recipients come from a raw array of
+// phone numbers/JIDs, a contact list or a segment evaluated when the campaign
+// is created. The campaign worker sends small batches so several campaigns
+// progress side by side; pacing and daily caps come from the safety engine,
+// and a held-back campaign resumes once its session may send again. Messages are rendered per recipient (spintax.go).
+
+const (
+	campaignPollInterval  = 5 * time.Second
+	campaignBatchSize     = 20
+	campaignOfflineDelay  = time.Minute
+	campaignMaxRecipients = 10000
+)
+
+// CampaignRequest creates a campaign
+type CampaignRequest struct {
+	SessionID     string   `json:"session_id" binding:"required"`
+	Name          string   `json:"name" binding:"required"`
+	Message       string   `json:"message"`
+	MediaID       string   `json:"media_id"`
+	Recipients    []string `json:"recipients"`
+	ContactListID *int64   `json:"contact_list_id"`
+	SegmentID     *int64   `json:"segment_id"`
+
+	// "message" (default) or "aggregate": campaign_receipts events at the
+	// thresholds (percentages, default CAMPAIGN_RECEIPT_THRESHOLDS) instead
+	// of a receipt event per message
+	ReceiptMode       string `json:"receipt_mode"`
+	ReceiptThresholds []int  `json:"receipt_thresholds"`
+}
+
+// CreateCampaign validates a campaign, stores its recipients and queues it
+func (ws *WhatsAppService) CreateCampaign(userID int, req CampaignRequest) (*WhatsAppCampaign, error) {
+	if strings.TrimSpace(req.Message) == "" && req.MediaID == "" {
+		return nil, fmt.Errorf("message or media_id is required")
+	}
+	targets := 0
+	for _, set := range []bool{len(req.Recipients) > 0, req.ContactListID != nil, req.SegmentID != nil} {
+		if set {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return nil, fmt.Errorf("provide exactly one of recipients, contact_list_id or segment_id")
+	}
+
+	receiptMode, receiptThresholds, err := ws.campaignReceiptSettings(req.ReceiptMode, req.ReceiptThresholds)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionUUID, err := uuid.Parse(req.SessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	if req.MediaID != "" {
+		if _, err := ws.GetMediaHandle(req.SessionID, userID, req.MediaID); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		recipients []WhatsAppCampaignRecipient
+		fields     []string
+	)
+	if req.ContactListID != nil {
+		list, err := ws.GetContactList(userID, *req.ContactListID, string(ContactListMemberValid))
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range list.Members {
+			recipients = append(recipients, WhatsAppCampaignRecipient{
+				To:     member.JID,
+				Name:   member.Name,
+				Fields: member.Fields,
+			})
+		}
+		if list.Fields != "" {
+			fields = strings.Split(list.Fields, ",")
+		}
+	} else if req.SegmentID != nil {
+		contacts, err := ws.segmentMembers(userID, *req.SegmentID)
+		if err != nil {
+			return nil, err
+		}
+		for _, contact := range contacts {
+			recipients = append(recipients, WhatsAppCampaignRecipient{To: contact.JID, Name: contact.FullName})
+		}
+	} else {
+		seen := make(map[string]bool, len(req.Recipients))
+		for _, to := range req.Recipients {
+			to = strings.TrimSpace(to)
+			if to == "" || seen[to] {
+				continue
+			}
+			seen[to] = true
+			recipients = append(recipients, WhatsAppCampaignRecipient{To: to})
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("campaign has no recipients")
+	}
+	if len(recipients) > campaignMaxRecipients {
+		return nil, fmt.Errorf("campaign has more than %d recipients", campaignMaxRecipients)
+	}
+
+	if err := validateTemplate(req.Message, fields...); err != nil {
+		return nil, err
+	}
+
+	for i := range recipients {
+		recipients[i].Status = CampaignRecipientPending
+	}
+	campaign := &WhatsAppCampaign{
+		UserID:        userID,
+		SessionID:     req.SessionID,
+		Name:          strings.TrimSpace(req.Name),
+		Message:       req.Message,
+		MediaID:       req.MediaID,
+		ContactListID: req.ContactListID,
+		SegmentID:     req.SegmentID,
+		Status:        CampaignRunning,
+		Total:         len(recipients),
+		NextRunAt:     time.Now(),
+
+		ReceiptMode:       receiptMode,
+		ReceiptThresholds: receiptThresholds,
+	}
+	if err := ws.db.CreateCampaign(campaign, recipients); err != nil {
+		return nil, fmt.Errorf("failed to create campaign: %w", err)
+	}
+
+	log.Printf("📣 Campaign %d %q queued for %d recipients", campaign.ID, campaign.Name, campaign.Total)
+	return campaign, nil
+}
+
+// GetCampaign returns a campaign with its delivery counters
+func (ws *WhatsAppService) GetCampaign(userID int, campaignID int64) (*WhatsAppCampaign, error) {
+	campaign, err := ws.db.GetCampaign(campaignID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("campaign not found")
+		}
+		return nil, fmt.Errorf("failed to load campaign: %w", err)
+	}
+	return campaign, nil
+}
+
+// GetCampaignRecipients lists the recipients of a campaign, optionally by status
+func (ws *WhatsAppService) GetCampaignRecipients(userID int, campaignID int64, status string) ([]WhatsAppCampaignRecipient, error) {
+	if _, err := ws.GetCampaign(userID, campaignID); err != nil {
+		return nil, err
+	}
+	recipients, err := ws.db.GetCampaignRecipients(campaignID, CampaignRecipientStatus(status), 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load campaign recipients: %w", err)
+	}
+	return recipients, nil
+}
+
+// CancelCampaign stops a running campaign; recipients not reached stay pending
+func (ws *WhatsAppService) CancelCampaign(userID int, campaignID int64) (*WhatsAppCampaign, error) {
+	campaign, err := ws.GetCampaign(userID, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if campaign.Status != CampaignRunning {
+		return nil, fmt.Errorf("campaign is %s", campaign.Status)
+	}
+
+	if err := ws.db.UpdateCampaign(campaign.ID, map[string]interface{}{"status": CampaignCancelled}); err != nil {
+		return nil, fmt.Errorf("failed to cancel campaign: %w", err)
+	}
+	campaign.Status = CampaignCancelled
+
+	log.Printf("🛑 Campaign %d cancelled", campaign.ID)
+	return campaign, nil
+}
+
+// RetryFailedCampaign requeues the recipients of a campaign whose sends
+// failed, except permanent failures (invalid numbers, not on WhatsApp), and
+// restarts the campaign; it returns the campaign and how many were requeued
+func (ws *WhatsAppService) RetryFailedCampaign(userID int, campaignID int64) (*WhatsAppCampaign, int64, error) {
+	campaign, err := ws.GetCampaign(userID, campaignID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if campaign.Status == CampaignCancelled {
+		return nil, 0, fmt.Errorf("%w: campaign is cancelled", apierr.ErrConflict)
+	}
+
+	requeued, err := ws.db.RequeueFailedCampaignRecipients(campaign.ID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to requeue campaign recipients: %w", err)
+	}
+	if requeued == 0 {
+		return nil, 0, fmt.Errorf("%w: campaign has no retryable failed recipients", apierr.ErrConflict)
+	}
+
+	if campaign, err = ws.GetCampaign(userID, campaignID); err != nil {
+		return nil, 0, err
+	}
+	log.Printf("🔁 Campaign %d: %d failed recipient(s) requeued", campaign.ID, requeued)
+	return campaign, requeued, nil
+}
+
+// isPermanentSendError reports whether a failed send would fail again
+func isPermanentSendError(err error) bool {
+	return errors.Is(err, wajid.ErrNotOnWhatsApp) || errors.Is(err, wajid.ErrInvalidJID) || errors.Is(err, wajid.ErrInvalidPhone) ||
+		errors.Is(err, apierr.ErrRecipientNotOnWhatsApp) || errors.Is(err, apierr.ErrInvalidRecipient)
+}
+
+// StartCampaignWorker delivers running campaigns until the context is cancelled
+func (ws *WhatsAppService) StartCampaignWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(campaignPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ws.processCampaigns(ctx)
+			}
+		}
+	}()
+	log.Println("✅ Campaign worker started")
+}
+
+func (ws *WhatsAppService) processCampaigns(ctx context.Context) {
+	campaigns, err := ws.db.GetDueCampaigns(time.Now())
+	if err != nil {
+		log.Printf("❌ Failed to load campaigns: %v", err)
+		return
+	}
+
+	for i := range campaigns {
+		if ctx.Err() != nil {
+			return
+		}
+		ws.runCampaignBatch(&campaigns[i])
+	}
+}
+
+// campaignTarget is a resolved recipient of the current batch
+type campaignTarget struct {
+	recipient *WhatsAppCampaignRecipient
+	jid       types.JID
+}
+
+// runCampaignBatch sends the next batch of pending recipients of a campaign
+func (ws *WhatsAppService) runCampaignBatch(campaign *WhatsAppCampaign) {
+	sc, err := ws.GetSessionClient(campaign.SessionID)
+	if err != nil || !sc.Client.IsConnected() {
+		ws.db.UpdateCampaign(campaign.ID, map[string]interface{}{
+			"next_run_at": time.Now().Add(campaignOfflineDelay),
+			"last_error":  "session not connected",
+		})
+		return
+	}
+
+	pending, err := ws.db.GetCampaignRecipients(campaign.ID, CampaignRecipientPending, campaignBatchSize)
+	if err != nil {
+		log.Printf("❌ Failed to load recipients of campaign %d: %v", campaign.ID, err)
+		return
+	}
+	if len(pending) == 0 {
+		ws.finishCampaign(campaign, CampaignCompleted, "")
+		return
+	}
+
+	var media *MediaUpload
+	if campaign.MediaID != "" {
+		if media, err = ws.GetMediaHandle(campaign.SessionID, campaign.UserID, campaign.MediaID); err != nil {
+			ws.finishCampaign(campaign, CampaignFailed, err.Error())
+			return
+		}
+	}
+
+	// Resolve the whole batch first so contacts can be loaded in one query
+	targets := make([]campaignTarget, 0, len(pending))
+	jids := make([]types.JID, 0, len(pending))
+	for i := range pending {
+		recipient := &pending[i]
+		jid, err := ws.validateAndGetRecipient(sc, recipient.To)
+		if err != nil {
+			ws.recordCampaignResult(campaign, recipient, "", err)
+			continue
+		}
+		targets = append(targets, campaignTarget{recipient: recipient, jid: jid})
+		jids = append(jids, jid)
+	}
+
+	var contacts map[string]*WhatsAppContact
+	personalize := isTemplate(campaign.Message)
+	if personalize {
+		contacts = ws.templateContacts(sc, jids)
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for _, target := range targets {
+		// Cancellation takes effect before the next send
+		if current, err := ws.db.GetCampaign(campaign.ID, campaign.UserID); err != nil || current.Status != CampaignRunning {
+			return
+		}
+
+		text := campaign.Message
+		if personalize {
+			vars := ws.recipientVariables(sc, target.jid, contacts)
+			if target.recipient.Name != "" {
+				vars["name"] = target.recipient.Name
+			}
+			for key, value := range target.recipient.Fields {
+				vars[key] = fmt.Sprint(value)
+			}
+			rendered, err := renderMessage(campaign.Message, vars, rng, ws.textLengthLimit())
+			if err != nil {
+				ws.recordCampaignResult(campaign, target.recipient, "", err)
+				continue
+			}
+			text = rendered.Text
+			if len(rendered.Warnings) > 0 {
+				log.Printf("⚠️  Campaign %d to %s: %s", campaign.ID, target.recipient.To, strings.Join(rendered.Warnings, "; "))
+			}
+		}
+
+		var messageID string
+		if media != nil {
+			var resp *MessageResponse
+			if resp, err = ws.sendMedia(sc, target.jid, media, text, false); err == nil {
+				messageID = resp.MessageID
+			}
+		} else {
+			var resp *whatsmeow.SendResponse
+			if resp, err = ws.sendTextToJID(sc, target.jid, text); err == nil {
+				messageID = resp.ID
+			}
+		}
+
+		var limitErr *SendLimitError
+		if errors.As(err, &limitErr) {
+			// The recipient stays pending until the session may send again
+			ws.db.UpdateCampaign(campaign.ID, map[string]interface{}{
+				"next_run_at": limitErr.RetryAt,
+				"last_error":  err.Error(),
+			})
+			log.Printf("⏸️  Campaign %d held back until %s: %v", campaign.ID, limitErr.RetryAt.Format(time.RFC3339), err)
+			return
+		}
+		ws.recordCampaignResult(campaign, target.recipient, messageID, err)
+	}
+
+	ws.db.UpdateCampaign(campaign.ID, map[string]interface{}{
+		"next_run_at": time.Now(),
+		"last_error":  "",
+	})
+}
+
+// recordCampaignResult stores the outcome of one recipient and bumps the counters
+func (ws *WhatsAppService) recordCampaignResult(campaign *WhatsAppCampaign, recipient *WhatsAppCampaignRecipient, messageID string, err error) {
+	updates := map[string]interface{}{}
+	counter := "sent"
+	switch {
+	case err == nil:
+		now := time.Now()
+		updates["status"] = CampaignRecipientSent
+		updates["message_id"] = messageID
+		updates["sent_at"] = now
+		updates["attempts"] = gorm.Expr("attempts + 1")
+	case errors.Is(err, ErrSuppressed):
+		counter = "suppressed"
+		updates["status"] = CampaignRecipientSuppressed
+		updates["error"] = err.Error()
+	default:
+		counter = "failed"
+		updates["status"] = CampaignRecipientFailed
+		updates["error"] = truncate(err.Error(), 500)
+		updates["permanent"] = isPermanentSendError(err)
+		updates["attempts"] = gorm.Expr("attempts + 1")
+	}
+
+	if err := ws.db.UpdateCampaignRecipient(recipient.ID, updates); err != nil {
+		log.Printf("❌ Failed to update recipient %d of campaign %d: %v", recipient.ID, campaign.ID, err)
+		return
+	}
+	ws.db.UpdateCampaign(campaign.ID, map[string]interface{}{
+		counter: gorm.Expr(counter + " + 1"),
+	})
+}
+
+// finishCampaign marks a campaign completed or failed and notifies the session
+func (ws *WhatsAppService) finishCampaign(campaign *WhatsAppCampaign, status CampaignStatus, reason string) {
+	now := time.Now()
+	ws.db.UpdateCampaign(campaign.ID, map[string]interface{}{
+		"status":       status,
+		"last_error":   reason,
+		"completed_at": now,
+	})
+
+	// Re-read the counters updated during the last batch
+	if current, err := ws.db.GetCampaign(campaign.ID, campaign.UserID); err == nil {
+		campaign = current
+	}
+	log.Printf("📣 Campaign %d %s: %d sent, %d failed, %d suppressed", campaign.ID, status, campaign.Sent, campaign.Failed, campaign.Suppressed)
+
+	eventType := "campaign_" + string(status)
+	data := map[string]interface{}{
+		"campaign_id": campaign.ID,
+		"name":        campaign.Name,
+		"total":       campaign.Total,
+		"sent":        campaign.Sent,
+		"failed":      campaign.Failed,
+		"suppressed":  campaign.Suppressed,
+		"delivered":   campaign.Delivered,
+		"read":        campaign.Read,
+	}
+	if reason != "" {
+		data["error"] = reason
+	}
+
+	sessionUUID, _ := uuid.Parse(campaign.SessionID)
+	ws.db.CreateEvent(sessionUUID, campaign.UserID, eventType, data)
+	ws.wsManager.SendToSession(campaign.SessionID, WebSocketMessage{
+		Type: eventType,
+		Data: data,
+	})
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-api/pkg/apierr"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= HEALTH CANARY =============
+// A socket can look connected while WhatsApp no longer delivers anything
+// for it. Sessions with a canary target get a round trip through WhatsApp
+// every CANARY_INTERVAL: in message mode a short text is sent to the target
+// and must be acknowledged by the server, in presence mode the target's
+// presence is subscribed to and must be answered. The target is a test
+// number or "self", the session's own account. A failing canary marks the
+// session degraded in the health check; the first failure of a streak emits
+// session_canary_failed and the next success session_canary_restored. A
+// canary is skipped while the session backs off after a rate limit.
+
+// Canary modes
+const (
+	CanaryModeMessage  = "message"
+	CanaryModePresence = "presence"
+)
+
+const (
+	canaryPollInterval = time.Minute
+	canarySelf         = "self"
+	canaryText         = "health check"
+)
+
+// CanarySettingsRequest changes the health canary of a session
+type CanarySettingsRequest struct {
+	JID  *string `json:"jid"`  // phone number, JID or "self"; empty turns the canary off
+	Mode *string `json:"mode"` // message (default) or presence
+}
+
+// CanarySettings are the health canary settings and state of a session
+type CanarySettings struct {
+	JID             string        `json:"jid,omitempty"`
+	Mode            string        `json:"mode,omitempty"`
+	IntervalSeconds int           `json:"interval_seconds"` // 0 = canaries are off on this server
+	State           *CanaryHealth `json:"state,omitempty"`
+}
+
+// CanaryHealth is the outcome of a session's recent canaries
+type CanaryHealth struct {
+	Healthy      bool       `json:"healthy"`
+	Failures     int        `json:"failures"` // consecutive
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	LatencyMs    *int64     `json:"latency_ms,omitempty"` // of the last successful round trip
+}
+
+// canaryHealth records the canaries of a client
+type canaryHealth struct {
+	mu          sync.Mutex
+	running     bool
+	lastRun     time.Time
+	lastOK      time.Time
+	failedSince time.Time // first failure of the current streak
+	failures    int
+	lastError   string
+	latency     time.Duration
+	presenceJID types.JID     // target of a presence canary waiting for its answer
+	presence    chan struct{} // closed when the answer arrives
+}
+
+// start marks a canary running unless one is already running or the last one
+// ran within interval
+func (h *canaryHealth) start(interval time.Duration) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.running || (interval > 0 && time.Since(h.lastRun) < interval) {
+		return false
+	}
+	h.running = true
+	return true
+}
+
+// finish records the outcome of a canary and returns the failures of the
+// streak it ended (on success) or is part of (on failure)
+func (h *canaryHealth) finish(latency time.Duration, err error) (failures int, since time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = false
+	h.lastRun = time.Now()
+	if err != nil {
+		if h.failures == 0 {
+			h.failedSince = h.lastRun
+		}
+		h.failures++
+		h.lastError = err.Error()
+		return h.failures, h.failedSince
+	}
+	failures, since = h.failures, h.failedSince
+	h.failures = 0
+	h.lastError = ""
+	h.lastOK = h.lastRun
+	h.latency = latency
+	return failures, since
+}
+
+// cancel ends a canary without recording an outcome
+func (h *canaryHealth) cancel() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = false
+}
+
+// reset forgets the canaries run so far, e.g. after the target changed
+func (h *canaryHealth) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastRun, h.lastOK, h.failedSince = time.Time{}, time.Time{}, time.Time{}
+	h.failures, h.lastError, h.latency = 0, "", 0
+}
+
+// snapshot returns the canary state, nil before the first canary
+func (h *canaryHealth) snapshot() *CanaryHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lastRun.IsZero() {
+		return nil
+	}
+	lastRun := h.lastRun
+	state := &CanaryHealth{
+		Healthy:   h.failures == 0,
+		Failures:  h.failures,
+		LastRunAt: &lastRun,
+		LastError: h.lastError,
+	}
+	if !h.lastOK.IsZero() {
+		lastOK := h.lastOK
+		latency := h.latency.Milliseconds()
+		state.LastSuccess = &lastOK
+		state.LatencyMs = &latency
+	}
+	if h.failures > 0 {
+		failedSince := h.failedSince
+		state.FailingSince = &failedSince
+	}
+	return state
+}
+
+// waitPresence registers a presence canary for jid; the returned channel is
+// closed when its presence arrives
+func (h *canaryHealth) waitPresence(jid types.JID) <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.presenceJID = jid
+	h.presence = make(chan struct{})
+	return h.presence
+}
+
+// presenceReceived answers a waiting presence canary
+func (h *canaryHealth) presenceReceived(from types.JID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.presence != nil && from.ToNonAD() == h.presenceJID {
+		close(h.presence)
+		h.presence = nil
+	}
+}
+
+// normalizeCanaryTarget validates a canary target and returns the form it's
+// stored in
+func normalizeCanaryTarget(target string) (string, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return "", nil
+	}
+	if isSelfRecipient(target) {
+		return canarySelf, nil
+	}
+	jid, err := wajid.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	if !wajid.IsUser(jid) {
+		return "", fmt.Errorf("canary target must be a phone number, user JID or \"self\"")
+	}
+	return jid.String(), nil
+}
+
+// GetCanarySettings returns the health canary settings and state of a session
+func (ws *WhatsAppService) GetCanarySettings(sessionID string, userID int) (*CanarySettings, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	settings := &CanarySettings{
+		JID:             session.CanaryJID,
+		Mode:            session.CanaryMode,
+		IntervalSeconds: int(ws.cfg.CanaryInterval.Seconds()),
+	}
+	if sc, ok := ws.sessions.Get(sessionID); ok && session.CanaryJID != "" {
+		settings.State = sc.canary.snapshot()
+	}
+	return settings, nil
+}
+
+// UpdateCanarySettings sets or clears the health canary of a session
+func (ws *WhatsAppService) UpdateCanarySettings(sessionID string, userID int, req CanarySettingsRequest) (*CanarySettings, error) {
+	if req.JID == nil && req.Mode == nil {
+		return nil, fmt.Errorf("nothing to update")
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	target, mode := session.CanaryJID, session.CanaryMode
+	if req.JID != nil {
+		if target, err = normalizeCanaryTarget(*req.JID); err != nil {
+			return nil, fmt.Errorf("%w: %v", apierr.ErrInvalidRequest, err)
+		}
+	}
+	if req.Mode != nil {
+		mode = strings.ToLower(strings.TrimSpace(*req.Mode))
+	}
+	switch {
+	case target == "":
+		mode = ""
+	case mode == "":
+		mode = CanaryModeMessage
+	case mode != CanaryModeMessage && mode != CanaryModePresence:
+		return nil, fmt.Errorf("%w: canary mode must be message or presence", apierr.ErrInvalidRequest)
+	}
+
+	if err := ws.db.SetSessionCanary(sessionID, target, mode); err != nil {
+		return nil, fmt.Errorf("failed to update canary settings: %w", err)
+	}
+	if sc, ok := ws.sessions.Get(sessionID); ok {
+		sc.canary.reset()
+	}
+	log.Printf("🐤 Canary of session %s: %q (%s)", sessionID, target, mode)
+	return ws.GetCanarySettings(sessionID, userID)
+}
+
+// RunCanary runs the health canary of a connected session now
+func (ws *WhatsAppService) RunCanary(sessionID string, userID int) (*CanaryHealth, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+	if session.CanaryJID == "" {
+		return nil, fmt.Errorf("%w: the session has no canary target", apierr.ErrInvalidRequest)
+	}
+	sc, err := ws.getConnectedClient(sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !sc.canary.start(0) {
+		return nil, fmt.Errorf("%w: a canary is already running", apierr.ErrInvalidRequest)
+	}
+	if err := ws.runCanary(sc, session.CanaryJID, session.CanaryMode); err != nil {
+		return nil, err
+	}
+	return sc.canary.snapshot(), nil
+}
+
+// StartCanaryWorker starts the periodic health canaries
+func (ws *WhatsAppService) StartCanaryWorker(ctx context.Context) {
+	if ws.cfg.CanaryInterval <= 0 {
+		log.Println("ℹ️  Canary worker disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(canaryPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ws.processCanaries(ctx)
+			}
+		}
+	}()
+	log.Println("✅ Canary worker started")
+}
+
+func (ws *WhatsAppService) processCanaries(ctx context.Context) {
+	sessions, err := ws.db.GetCanarySessions()
+	if err != nil {
+		log.Printf("❌ Failed to load sessions with a canary: %v", err)
+		return
+	}
+
+	for _, session := range sessions {
+		if ctx.Err() != nil {
+			return
+		}
+		sc, ok := ws.sessions.Get(session.ID)
+		if !ok {
+			continue // connected on another instance
+		}
+		if !sc.Client.IsConnected() || !sc.Client.IsLoggedIn() {
+			continue
+		}
+		// A canary may wait up to CANARY_TIMEOUT, so each runs on its own
+		if sc.canary.start(ws.cfg.CanaryInterval) {
+			go ws.runCanary(sc, session.CanaryJID, session.CanaryMode)
+		}
+	}
+}
+
+// runCanary makes the canary round trip of a session and records it; the
+// caller has started the canary. Only a canary that couldn't be run at all
+// returns an error.
+func (ws *WhatsAppService) runCanary(sc *SessionClient, target, mode string) error {
+	var jid types.JID
+	var err error
+	if target == canarySelf {
+		jid, err = ws.ownJID(sc)
+	} else {
+		jid, err = wajid.ParseJID(target)
+	}
+	if err != nil {
+		sc.canary.cancel()
+		return fmt.Errorf("invalid canary target: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ws.cfg.CanaryTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err = ws.callWhatsApp(sc, priorityBackground, "canary", func() error {
+		if mode == CanaryModePresence {
+			answered := sc.canary.waitPresence(jid)
+			if err := sc.Client.SubscribePresence(ctx, jid); err != nil {
+				return err
+			}
+			select {
+			case <-answered:
+				return nil
+			case <-ctx.Done():
+				return fmt.Errorf("no presence answer within %s", ws.cfg.CanaryTimeout)
+			}
+		}
+		_, err := sc.Client.SendMessage(ctx, jid, &waE2E.Message{Conversation: proto.String(canaryText)})
+		return err
+	})
+	var throttleErr *ThrottleError
+	if errors.As(err, &throttleErr) {
+		// Backing off after a rate limit says nothing about the connection
+		sc.canary.cancel()
+		return err
+	}
+
+	latency := time.Since(start)
+	failures, since := sc.canary.finish(latency, err)
+	if err != nil {
+		log.Printf("⚠️  Canary of session %s failed (%d in a row): %v", sc.SessionID, failures, err)
+		if failures == 1 {
+			ws.emitSessionHealthEvent(sc, "session_canary_failed", map[string]interface{}{
+				"target": jid.String(),
+				"mode":   mode,
+				"error":  err.Error(),
+			})
+		}
+		return nil
+	}
+	if failures > 0 {
+		log.Printf("✅ Canary of session %s restored after %d failures", sc.SessionID, failures)
+		ws.emitSessionHealthEvent(sc, "session_canary_restored", map[string]interface{}{
+			"target":        jid.String(),
+			"mode":          mode,
+			"failures":      failures,
+			"failing_since": since,
+			"down_seconds":  int(time.Since(since).Seconds()),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= CANNED RESPONSES =============
+// Canned responses are a user's saved replies for support teams working
+// through the API. Each has a shortcut code (letters, digits, "-" and "_",
+// unique per user, a leading "/" is dropped) to find it by, and is sent by
+// passing canned_response_id instead of message to the send endpoint. Every
+// message sent or queued with a response counts towards its use_count and
+// last_used_at.
+
+const cannedResponseMaxLength = 4096
+
+// shortcutPattern is the shape of a normalized shortcut code
+var shortcutPattern = regexp.MustCompile(`^[a-z0-9_-]{1,50}$`)
+
+// CannedResponseRequest creates or replaces a canned response
+type CannedResponseRequest struct {
+	Shortcut string `json:"shortcut" binding:"required"`
+	Title    string `json:"title"`
+	Text     string `json:"text" binding:"required"`
+}
+
+// normalizeShortcut lowercases a shortcut code and drops a leading "/"
+func normalizeShortcut(shortcut string) (string, error) {
+	shortcut = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(shortcut), "/"))
+	if !shortcutPattern.MatchString(shortcut) {
+		return "", fmt.Errorf("%w: shortcut must be 1 to 50 letters, digits, \"-\" or \"_\"", apierr.ErrInvalidRequest)
+	}
+	return shortcut, nil
+}
+
+func normalizeCannedResponseRequest(req CannedResponseRequest) (CannedResponseRequest, error) {
+	shortcut, err := normalizeShortcut(req.Shortcut)
+	if err != nil {
+		return req, err
+	}
+	req.Shortcut = shortcut
+	req.Title = strings.TrimSpace(req.Title)
+	if len(req.Title) > 255 {
+		return req, fmt.Errorf("%w: title must be at most 255 characters", apierr.ErrInvalidRequest)
+	}
+	if strings.TrimSpace(req.Text) == "" || len(req.Text) > cannedResponseMaxLength {
+		return req, fmt.Errorf("%w: text must be 1 to %d characters", apierr.ErrInvalidRequest, cannedResponseMaxLength)
+	}
+	return req, nil
+}
+
+// CreateCannedResponse saves a canned response of the user
+func (ws *WhatsAppService) CreateCannedResponse(userID int, req CannedResponseRequest) (*WhatsAppCannedResponse, error) {
+	req, err := normalizeCannedResponseRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &WhatsAppCannedResponse{
+		UserID:   userID,
+		Shortcut: req.Shortcut,
+		Title:    req.Title,
+		Text:     req.Text,
+	}
+	if err := ws.db.CreateCannedResponse(response); err != nil {
+		if isDuplicateKeyError(err) {
+			return nil, fmt.Errorf("%w: shortcut %q already exists", apierr.ErrConflict, req.Shortcut)
+		}
+		return nil, fmt.Errorf("failed to create canned response: %w", err)
+	}
+
+	log.Printf("💬 Canned response %d /%s created", response.ID, response.Shortcut)
+	return response, nil
+}
+
+// GetCannedResponses pages through the user's canned responses
+func (ws *WhatsAppService) GetCannedResponses(userID int, q ListQuery) ([]WhatsAppCannedResponse, int64, error) {
+	if shortcut, ok := q.Filters["shortcut"]; ok {
+		normalized, err := normalizeShortcut(shortcut)
+		if err != nil {
+			return nil, 0, err
+		}
+		q.Filters["shortcut"] = normalized
+	}
+
+	responses, total, err := ws.db.GetCannedResponses(userID, q)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load canned responses: %w", err)
+	}
+	return responses, total, nil
+}
+
+// GetCannedResponse returns a canned response of the user
+func (ws *WhatsAppService) GetCannedResponse(userID int, responseID int64) (*WhatsAppCannedResponse, error) {
+	response, err := ws.db.GetCannedResponse(responseID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: canned response not found", apierr.ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to load canned response: %w", err)
+	}
+	return response, nil
+}
+
+// UpdateCannedResponse replaces the shortcut, title and text of a canned
+// response; its usage statistics are kept
+func (ws *WhatsAppService) UpdateCannedResponse(userID int, responseID int64, req CannedResponseRequest) (*WhatsAppCannedResponse, error) {
+	req, err := normalizeCannedResponseRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	response, err := ws.GetCannedResponse(userID, responseID)
+	if err != nil {
+		return nil, err
+	}
+
+	response.Shortcut = req.Shortcut
+	response.Title = req.Title
+	response.Text = req.Text
+	if err := ws.db.UpdateCannedResponse(response); err != nil {
+		if isDuplicateKeyError(err) {
+			return nil, fmt.Errorf("%w: shortcut %q already exists", apierr.ErrConflict, req.Shortcut)
+		}
+		return nil, fmt.Errorf("failed to update canned response: %w", err)
+	}
+	return response, nil
+}
+
+// DeleteCannedResponse removes a canned response
+func (ws *WhatsAppService) DeleteCannedResponse(userID int, responseID int64) error {
+	deleted, err := ws.db.DeleteCannedResponse(responseID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete canned response: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%w: canned response not found", apierr.ErrNotFound)
+	}
+	log.Printf("💬 Canned response %d deleted", responseID)
+	return nil
+}
+
+// recordCannedResponseUse counts a message sent or queued with a canned
+// response; a failure only costs the statistic
+func (ws *WhatsAppService) recordCannedResponseUse(responseID int64) {
+	if err := ws.db.RecordCannedResponseUse(responseID); err != nil {
+		log.Printf("⚠️  Failed to record use of canned response %d: %v", responseID, err)
+	}
+}
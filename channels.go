@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= CHANNELS =============
+// A channel pairs a primary session with a warm standby: two linked numbers,
+// or two devices of the same number. Sends that name a channel_id instead of
+// a session_id go through the channel's active session. The channel fails
+// over to the backup when the primary can't send (disconnected, logged out,
+// banned, refused or paused by the safety engine) while the backup can, and
+// fails back once the primary is connected again (auto_failback) or the
+// backup goes down too. Session events check their channels after
+// CHANNEL_FAILOVER_GRACE, so a quick reconnect doesn't switch; a send checks
+// right away, since it would fail on the session that's down. Every switch is
+// stored on the channel and emitted as channel_failover or channel_failback
+// on both sessions.
+
+const channelNameMaxLength = 100
+
+// ChannelRequest creates a channel
+type ChannelRequest struct {
+	Name             string `json:"name" binding:"required"`
+	PrimarySessionID string `json:"primary_session_id" binding:"required"`
+	BackupSessionID  string `json:"backup_session_id" binding:"required"`
+	AutoFailback     *bool  `json:"auto_failback"` // default true
+}
+
+// CreateChannel pairs two sessions of the user as a channel
+func (ws *WhatsAppService) CreateChannel(userID int, req ChannelRequest) (*WhatsAppChannel, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > channelNameMaxLength {
+		return nil, fmt.Errorf("%w: name must be 1 to %d characters", apierr.ErrInvalidRequest, channelNameMaxLength)
+	}
+	if req.PrimarySessionID == req.BackupSessionID {
+		return nil, fmt.Errorf("%w: the primary and backup must be different sessions", apierr.ErrInvalidRequest)
+	}
+	for _, sessionID := range []string{req.PrimarySessionID, req.BackupSessionID} {
+		sessionUUID, err := uuid.Parse(sessionID)
+		if err != nil {
+			return nil, apierr.ErrInvalidSessionID
+		}
+		if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+			return nil, fmt.Errorf("%w: %s", apierr.ErrSessionNotFound, sessionID)
+		}
+	}
+
+	channel := &WhatsAppChannel{
+		UserID:           userID,
+		Name:             name,
+		PrimarySessionID: req.PrimarySessionID,
+		BackupSessionID:  req.BackupSessionID,
+		Active:           ChannelPrimary,
+		AutoFailback:     req.AutoFailback == nil || *req.AutoFailback,
+	}
+	if err := ws.db.CreateChannel(channel); err != nil {
+		if isDuplicateKeyError(err) {
+			return nil, fmt.Errorf("%w: channel %q already exists", apierr.ErrConflict, name)
+		}
+		return nil, fmt.Errorf("failed to create channel: %w", err)
+	}
+	channel.ActiveSessionID = channel.PrimarySessionID
+
+	log.Printf("🔀 Channel %d %q created (primary %s, backup %s)", channel.ID, channel.Name, channel.PrimarySessionID, channel.BackupSessionID)
+	// The primary may be down already
+	ws.resolveChannel(channel)
+	return channel, nil
+}
+
+// GetChannel returns a channel of the user
+func (ws *WhatsAppService) GetChannel(userID int, channelID int64) (*WhatsAppChannel, error) {
+	channel, err := ws.db.GetChannel(channelID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: channel not found", apierr.ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to load channel: %w", err)
+	}
+	return channel, nil
+}
+
+// DeleteChannel removes a channel; its sessions are left alone
+func (ws *WhatsAppService) DeleteChannel(userID int, channelID int64) error {
+	deleted, err := ws.db.DeleteChannel(channelID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete channel: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%w: channel not found", apierr.ErrNotFound)
+	}
+	return nil
+}
+
+// channelSendSession returns the session a send to a channel goes through,
+// switching the channel first if its active session can't send
+func (ws *WhatsAppService) channelSendSession(userID int, channelID int64) (string, error) {
+	channel, err := ws.GetChannel(userID, channelID)
+	if err != nil {
+		return "", err
+	}
+	return ws.resolveChannel(channel), nil
+}
+
+// checkSessionChannels re-evaluates the channels of a session whose
+// connection changed, once the grace period has passed
+func (ws *WhatsAppService) checkSessionChannels(sessionID string) {
+	time.AfterFunc(ws.cfg.ChannelFailoverGrace, func() {
+		channels, err := ws.db.GetSessionChannels(sessionID)
+		if err != nil {
+			log.Printf("⚠️  Failed to load channels of session %s: %v", sessionID, err)
+			return
+		}
+		for i := range channels {
+			ws.resolveChannel(&channels[i])
+		}
+	})
+}
+
+// resolveChannel switches a channel to its other session when that one is
+// the better choice and returns the active session
+func (ws *WhatsAppService) resolveChannel(channel *WhatsAppChannel) string {
+	primaryUp, primaryState := ws.channelSessionState(channel.PrimarySessionID, channel.UserID)
+	backupUp, backupState := ws.channelSessionState(channel.BackupSessionID, channel.UserID)
+
+	switch {
+	case channel.Active == ChannelPrimary && !primaryUp && backupUp:
+		ws.switchChannel(channel, ChannelBackup, "primary "+primaryState)
+	case channel.Active == ChannelBackup && !backupUp && primaryUp:
+		ws.switchChannel(channel, ChannelPrimary, "backup "+backupState)
+	case channel.Active == ChannelBackup && primaryUp && channel.AutoFailback:
+		ws.switchChannel(channel, ChannelPrimary, "primary connected again")
+	}
+	return channel.ActiveSessionID
+}
+
+// channelSessionState reports whether a session can send and, if not, why
+func (ws *WhatsAppService) channelSessionState(sessionID string, userID int) (bool, string) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return false, "invalid"
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return false, "deleted"
+	}
+	if session.Status != StatusConnected {
+		if session.StatusReason != "" {
+			return false, fmt.Sprintf("%s (%s)", session.Status, session.StatusReason)
+		}
+		return false, string(session.Status)
+	}
+	if session.SafetyPausedUntil != nil && session.SafetyPausedUntil.After(time.Now()) {
+		return false, "paused by the safety engine"
+	}
+	// The database lags behind a connection that just dropped here; a
+	// session running on another instance is taken by its status
+	if sc, ok := ws.sessions.Get(sessionID); ok && (!sc.Client.IsConnected() || !sc.Client.IsLoggedIn()) {
+		return false, string(StatusDisconnected)
+	}
+	return true, string(StatusConnected)
+}
+
+// switchChannel makes the given session of a channel the active one and
+// reports the switch
+func (ws *WhatsAppService) switchChannel(channel *WhatsAppChannel, to ChannelRole, reason string) {
+	from := channel.Active
+	now := time.Now()
+	switched, err := ws.db.SwitchChannel(channel.ID, from, to, reason, now)
+	if err != nil {
+		log.Printf("❌ Failed to switch channel %d to its %s: %v", channel.ID, to, err)
+		return
+	}
+	if !switched {
+		// Switched by another send or event meanwhile
+		if current, err := ws.db.GetChannel(channel.ID, channel.UserID); err == nil {
+			*channel = *current
+		}
+		return
+	}
+
+	fromSessionID := channel.ActiveSessionID
+	channel.Active = to
+	channel.ActiveSessionID = channel.sessionID(to)
+	channel.SwitchedAt = &now
+	channel.SwitchReason = reason
+
+	eventType := "channel_failover"
+	if to == ChannelPrimary {
+		eventType = "channel_failback"
+	}
+	log.Printf("🔀 Channel %d %q switched to its %s %s: %s", channel.ID, channel.Name, to, channel.ActiveSessionID, reason)
+
+	data := map[string]interface{}{
+		"channel_id":      channel.ID,
+		"name":            channel.Name,
+		"active":          to,
+		"from_session_id": fromSessionID,
+		"to_session_id":   channel.ActiveSessionID,
+		"reason":          reason,
+		"switched_at":     now,
+	}
+	sessionUUID, _ := uuid.Parse(channel.ActiveSessionID)
+	ws.db.CreateEvent(sessionUUID, channel.UserID, eventType, data)
+	for _, sessionID := range []string{fromSessionID, channel.ActiveSessionID} {
+		ws.wsManager.SendToSession(sessionID, WebSocketMessage{
+			Type: eventType,
+			Data: data,
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= ADMIN COMMANDS =============
+// The server binary doubles as an admin tool, so a deployment can be managed
+// when the API itself is down. Commands talk to the databases directly; they
+// don't need the server to be running (session logout connects the session's
+// device on its own to unlink it, which kicks a running server's connection).
+
+const cliUsage = `Usage: whatsapp-api [command]
+
+Commands:
+  serve                                  run the API server (default)
+  migrate up|down [steps]|status         manage schema migrations
+  session list [user_id]                 list sessions of all users or one user
+  session logout <session_id>            unlink a session and delete its data
+  user quota show <user_id>              show a user's device limit
+  user quota set <user_id> <max_devices> override MAX_DEVICES_PER_USER for a user
+  user quota reset <user_id>             go back to MAX_DEVICES_PER_USER
+  user usage show <user_id>              show a user's messages this month and limits
+  user usage set <user_id> <soft> <hard> override USAGE_SOFT_LIMIT/USAGE_HARD_LIMIT
+  user usage reset <user_id>             go back to USAGE_SOFT_LIMIT/USAGE_HARD_LIMIT
+  store encrypt|decrypt                  encrypt or decrypt the WhatsApp store keys
+  store vacuum                           compact the WhatsApp store
+  backup create                          back up the WhatsApp store now
+  backup list [instance]                 list backups of all instances or one
+  backup restore <id|latest|key>         restore a backup (stop the API first)
+`
+
+// runCommand runs an admin command; serve, or no command at all, returns
+// false so main starts the server
+func runCommand(cfg *Config, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	switch args[0] {
+	case "serve":
+		return false, nil
+	case "help", "-h", "--help":
+		fmt.Print(cliUsage)
+		return true, nil
+	case "migrate":
+		return true, runMigrateCommand(cfg, args[1:])
+	case "session":
+		return true, runSessionCommand(cfg, args[1:])
+	case "user":
+		return true, runUserCommand(cfg, args[1:])
+	case "store":
+		if len(args) < 2 {
+			return true, errors.New("expected encrypt, decrypt or vacuum")
+		}
+		switch args[1] {
+		case "encrypt", "decrypt":
+			return true, runStoreCommand(cfg, "store-"+args[1])
+		case "vacuum":
+			return true, runStoreVacuum()
+		}
+		return true, fmt.Errorf("unknown store command %q (expected encrypt, decrypt or vacuum)", args[1])
+	case "store-encrypt", "store-decrypt":
+		return true, runStoreCommand(cfg, args[0])
+	case "backup":
+		return true, runBackupCommand(cfg, args[1:])
+	}
+	return true, fmt.Errorf("unknown command %q\n\n%s", args[0], cliUsage)
+}
+
+// runSessionCommand runs `whatsapp-api session list|logout`
+func runSessionCommand(cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected list or logout")
+	}
+
+	switch args[0] {
+	case "list":
+		gormDB, err := openAppDatabase(cfg)
+		if err != nil {
+			return err
+		}
+		dm := &DatabaseManager{db: gormDB}
+		defer dm.Close()
+
+		var sessions []WhatsAppSession
+		if len(args) > 1 {
+			userID, err := parseUserID(args[1])
+			if err != nil {
+				return err
+			}
+			sessions, err = dm.GetUserSessions(userID)
+			if err != nil {
+				return err
+			}
+		} else if sessions, err = dm.GetAllSessions(); err != nil {
+			return err
+		}
+		printSessions(sessions)
+		return nil
+
+	case "logout":
+		if len(args) < 2 {
+			return errors.New("expected a session ID")
+		}
+		return logoutSessionCommand(cfg, args[1])
+	}
+	return fmt.Errorf("unknown session command %q (expected list or logout)", args[0])
+}
+
+// printSessions prints sessions as a table
+func printSessions(sessions []WhatsAppSession) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSER\tNAME\tSTATUS\tPHONE\tLAST SEEN")
+	for _, session := range sessions {
+		phone, lastSeen := "-", "-"
+		if session.PhoneNumber != nil && *session.PhoneNumber != "" {
+			phone = *session.PhoneNumber
+		}
+		if session.LastSeen != nil {
+			lastSeen = session.LastSeen.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n",
+			session.ID, session.UserID, session.SessionName, session.Status, phone, lastSeen)
+	}
+	w.Flush()
+	fmt.Printf("%d session(s)\n", len(sessions))
+}
+
+// logoutSessionCommand unlinks a session from the phone and deletes it like
+// POST /sessions/:session_id/logout
+func logoutSessionCommand(cfg *Config, sessionID string) error {
+	db, err := NewDatabaseManager(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return apierr.ErrInvalidSessionID
+	}
+	userID, err := db.GetSessionOwner(sessionID)
+	if err != nil {
+		return apierr.ErrSessionNotFound
+	}
+	session, err := db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return apierr.ErrSessionNotFound
+	}
+
+	unlinked := false
+	if session.JID != nil && *session.JID != "" {
+		if err := unlinkDevice(db.GetWhatsAppContainer(), *session.JID); err != nil {
+			log.Printf("⚠️  Failed to unlink session %s from the phone: %v", sessionID, err)
+		} else {
+			unlinked = true
+		}
+	}
+
+	ws := NewWhatsAppService(cfg, db, NewWebSocketManager(db))
+	if _, err := ws.LogoutSession(sessionID, userID); err != nil {
+		return err
+	}
+
+	if unlinked {
+		log.Printf("✅ Session %s unlinked and deleted", sessionID)
+	} else {
+		log.Printf("✅ Session %s deleted; unlink it on the phone (Linked devices) as well", sessionID)
+	}
+	return nil
+}
+
+// unlinkDevice connects a device long enough to log it out, which removes
+// it from the phone's linked devices and from the store
+func unlinkDevice(container *sqlstore.Container, jidStr string) error {
+	jid, err := types.ParseJID(jidStr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	device, err := container.GetDevice(ctx, jid)
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return errors.New("device not in the WhatsApp store")
+	}
+
+	client := whatsmeow.NewClient(device, waLog.Noop)
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	defer client.Disconnect()
+	if !client.WaitForConnection(30 * time.Second) {
+		return errors.New("timed out connecting to WhatsApp")
+	}
+	return client.Logout(ctx)
+}
+
+// runUserCommand runs `whatsapp-api user quota|usage show|set|reset`
+func runUserCommand(cfg *Config, args []string) error {
+	if len(args) < 3 || (args[0] != "quota" && args[0] != "usage") {
+		return errors.New("expected quota|usage show|set|reset <user_id>")
+	}
+	userID, err := parseUserID(args[2])
+	if err != nil {
+		return err
+	}
+	if args[0] == "usage" {
+		return runUsageCommand(cfg, userID, args[1], args[3:])
+	}
+
+	gormDB, err := openAppDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	dm := &DatabaseManager{db: gormDB}
+	defer dm.Close()
+
+	switch args[1] {
+	case "show":
+	case "set":
+		if len(args) < 4 {
+			return errors.New("expected the maximum number of devices")
+		}
+		maxDevices, err := strconv.Atoi(args[3])
+		if err != nil || maxDevices < 0 {
+			return fmt.Errorf("invalid maximum number of devices %q", args[3])
+		}
+		if err := dm.SetUserQuota(userID, maxDevices); err != nil {
+			return fmt.Errorf("failed to set quota: %w", err)
+		}
+	case "reset":
+		if _, err := dm.DeleteUserQuota(userID); err != nil {
+			return fmt.Errorf("failed to reset quota: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown quota command %q (expected show, set or reset)", args[1])
+	}
+
+	quota, err := dm.GetUserQuota(userID)
+	if err != nil {
+		return fmt.Errorf("failed to load quota: %w", err)
+	}
+	active, err := dm.GetActiveSessionCount(userID)
+	if err != nil {
+		return fmt.Errorf("failed to count sessions: %w", err)
+	}
+	if quota == nil {
+		fmt.Printf("user %d: %d active device(s), limit %d (MAX_DEVICES_PER_USER)\n", userID, active, cfg.MaxDevicesPerUser)
+	} else {
+		fmt.Printf("user %d: %d active device(s), limit %d (quota)\n", userID, active, quota.MaxDevices)
+	}
+	return nil
+}
+
+// runUsageCommand runs `whatsapp-api user usage show|set|reset <user_id>`
+func runUsageCommand(cfg *Config, userID int, command string, args []string) error {
+	gormDB, err := openAppDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	dm := &DatabaseManager{db: gormDB}
+	defer dm.Close()
+
+	switch command {
+	case "show":
+	case "set":
+		if len(args) < 2 {
+			return errors.New("expected the soft and hard limit (0 = none)")
+		}
+		softLimit, err := strconv.Atoi(args[0])
+		if err != nil || softLimit < 0 {
+			return fmt.Errorf("invalid soft limit %q", args[0])
+		}
+		hardLimit, err := strconv.Atoi(args[1])
+		if err != nil || hardLimit < 0 {
+			return fmt.Errorf("invalid hard limit %q", args[1])
+		}
+		if err := dm.SetUsageQuota(userID, softLimit, hardLimit); err != nil {
+			return fmt.Errorf("failed to set usage quota: %w", err)
+		}
+	case "reset":
+		if _, err := dm.DeleteUsageQuota(userID); err != nil {
+			return fmt.Errorf("failed to reset usage quota: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown usage command %q (expected show, set or reset)", command)
+	}
+
+	report, err := loadUsage(dm, cfg, userID, "")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("user %d: %d message(s) sent in %s, soft limit %d, hard limit %d (%s, 0 = none)\n",
+		userID, report.MessagesSent, report.Period, report.SoftLimit, report.HardLimit, report.Source)
+	return nil
+}
+
+func parseUserID(s string) (int, error) {
+	userID, err := strconv.Atoi(s)
+	if err != nil || userID <= 0 {
+		return 0, fmt.Errorf("invalid user ID %q", s)
+	}
+	return userID, nil
+}
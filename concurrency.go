@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ============= SESSION CONCURRENCY =============
+// Sends and calls made through callWhatsApp share WHATSAPP_CONCURRENCY call
+// slots per session, so a group sync, a campaign and a burst of number checks
+// don't all hit WhatsApp at once and trip its rate limits. Calls are either
+// interactive (sends, API requests, replies to events) or background (group
+// sync, blocklist sync, avatar refreshes, canaries, group schedules, health
+// probes, inbound media downloads).
+// Background calls hold at most WHATSAPP_BACKGROUND_CONCURRENCY slots, which
+// keeps the other slots for interactive calls, and a freed slot goes to the
+// waiting interactive calls before any waiting background call. Calls wait in
+// order within their priority; one that waits WHATSAPP_QUEUE_TIMEOUT without
+// a slot fails with a SessionBusyError (sends are deferred like safety-engine
+// limits). Running calls are never interrupted. The slots are part of the
+// session health check.
+
+// callPriority decides which waiting call gets the next free slot
+type callPriority int
+
+const (
+	priorityInteractive callPriority = iota // a request or message waits for it
+	priorityBackground                      // syncs and workers
+)
+
+// sessionBusyRetryDelay is how long a call turned away by a busy session
+// should wait before trying again
+const sessionBusyRetryDelay = 5 * time.Second
+
+// SessionBusyError is returned for calls that gave up waiting for a call slot
+// of their session
+type SessionBusyError struct {
+	SessionID string
+	Waited    time.Duration
+	RetryAt   time.Time
+}
+
+func (e *SessionBusyError) Error() string {
+	return fmt.Sprintf("session busy: no free WhatsApp call slot within %s, retry after %s", e.Waited, e.RetryAt.UTC().Format(time.RFC3339))
+}
+
+// callWaiter is a call waiting for a slot
+type callWaiter struct {
+	ready   chan struct{} // closed when the slot is granted
+	granted bool
+}
+
+// sessionCalls are the call slots of one session client
+type sessionCalls struct {
+	mu      sync.Mutex
+	active  [2]int // running calls by priority
+	waiting [2][]*callWaiter
+	started int64 // calls since the client was created
+	queued  int64 // calls that had to wait for a slot
+	busy    int64 // calls that gave up waiting
+}
+
+// free reports whether a call of a priority may take a slot; c.mu must be held
+func (c *sessionCalls) free(priority callPriority, limit, backgroundLimit int) bool {
+	if c.active[priorityInteractive]+c.active[priorityBackground] >= limit {
+		return false
+	}
+	return priority == priorityInteractive || c.active[priorityBackground] < backgroundLimit
+}
+
+// acquire waits up to timeout for a slot
+func (c *sessionCalls) acquire(sessionID string, priority callPriority, limit, backgroundLimit int, timeout time.Duration) error {
+	c.mu.Lock()
+	// Waiting calls go first: interactive ones before anything, background
+	// ones before later background calls
+	ahead := len(c.waiting[priorityInteractive]) > 0 ||
+		(priority == priorityBackground && len(c.waiting[priorityBackground]) > 0)
+	if !ahead && c.free(priority, limit, backgroundLimit) {
+		c.active[priority]++
+		c.started++
+		c.mu.Unlock()
+		return nil
+	}
+	waiter := &callWaiter{ready: make(chan struct{})}
+	c.waiting[priority] = append(c.waiting[priority], waiter)
+	c.queued++
+	c.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-timer.C:
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if waiter.granted {
+		return nil // granted while timing out
+	}
+	for i, w := range c.waiting[priority] {
+		if w == waiter {
+			c.waiting[priority] = append(c.waiting[priority][:i], c.waiting[priority][i+1:]...)
+			break
+		}
+	}
+	c.busy++
+	return &SessionBusyError{SessionID: sessionID, Waited: timeout, RetryAt: time.Now().Add(sessionBusyRetryDelay)}
+}
+
+// release frees the slot of a finished call and hands it on
+func (c *sessionCalls) release(priority callPriority, limit, backgroundLimit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active[priority]--
+	for _, p := range []callPriority{priorityInteractive, priorityBackground} {
+		for len(c.waiting[p]) > 0 && c.free(p, limit, backgroundLimit) {
+			waiter := c.waiting[p][0]
+			c.waiting[p][0] = nil
+			c.waiting[p] = c.waiting[p][1:]
+			waiter.granted = true
+			c.active[p]++
+			c.started++
+			close(waiter.ready)
+		}
+	}
+}
+
+// withCallSlot runs fn while holding one of the session's call slots; fn
+// must not take another slot of the session
+func (ws *WhatsAppService) withCallSlot(sc *SessionClient, priority callPriority, fn func() error) error {
+	limit, backgroundLimit := ws.cfg.WhatsAppConcurrency, ws.cfg.WhatsAppBackgroundConcurrency
+	if err := sc.calls.acquire(sc.SessionID, priority, limit, backgroundLimit, ws.cfg.WhatsAppQueueTimeout); err != nil {
+		return err
+	}
+	defer sc.calls.release(priority, limit, backgroundLimit)
+	return fn()
+}
+
+// SessionCallsHealth is the state of a session's call slots
+type SessionCallsHealth struct {
+	Limit             int   `json:"limit"`
+	BackgroundLimit   int   `json:"background_limit"`
+	Active            int   `json:"active"`
+	ActiveBackground  int   `json:"active_background"`
+	Waiting           int   `json:"waiting"`
+	WaitingBackground int   `json:"waiting_background"`
+	Calls             int64 `json:"calls"`  // since the client was loaded
+	Queued            int64 `json:"queued"` // calls that waited for a slot
+	Busy              int64 `json:"busy"`   // calls that gave up waiting
+}
+
+func (ws *WhatsAppService) callsHealth(sc *SessionClient) SessionCallsHealth {
+	c := &sc.calls
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return SessionCallsHealth{
+		Limit:             ws.cfg.WhatsAppConcurrency,
+		BackgroundLimit:   ws.cfg.WhatsAppBackgroundConcurrency,
+		Active:            c.active[priorityInteractive] + c.active[priorityBackground],
+		ActiveBackground:  c.active[priorityBackground],
+		Waiting:           len(c.waiting[priorityInteractive]) + len(c.waiting[priorityBackground]),
+		WaitingBackground: len(c.waiting[priorityBackground]),
+		Calls:             c.started,
+		Queued:            c.queued,
+		Busy:              c.busy,
+	}
+}
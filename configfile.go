@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ============= CONFIG FILE =============
+// Settings are read from environment variables (and .env) and from an
+// optional YAML or JSON file: CONFIG_FILE, or config.yaml, config.yml or
+// config.json in the working directory. Environment variables win over the
+// file. File keys are setting names in any case, nested sections are joined
+// with "_", and lists become comma separated values:
+//
+//	rate_limit:
+//	  send_per_minute: 30   # RATE_LIMIT_SEND_PER_MINUTE=30
+//	cors_allowed_origins: [https://a.example, https://b.example]
+//
+// Malformed values and unknown keys are reported together instead of
+// silently falling back to defaults.
+
+// defaultConfigFiles are tried in order when CONFIG_FILE isn't set
+var defaultConfigFiles = []string{"config.yaml", "config.yml", "config.json"}
+
+// configSource reads settings and collects what's wrong with them
+type configSource struct {
+	path     string
+	file     map[string]string // file values by setting name
+	fileKeys map[string]string // setting name -> key as written in the file
+	used     map[string]bool
+	errors   []string
+}
+
+// newConfigSource loads the config file, if there is one
+func newConfigSource() (*configSource, error) {
+	s := &configSource{
+		file:     make(map[string]string),
+		fileKeys: make(map[string]string),
+		used:     make(map[string]bool),
+	}
+
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		for _, name := range defaultConfigFiles {
+			if _, err := os.Stat(name); err == nil {
+				path = name
+				break
+			}
+		}
+		if path == "" {
+			return s, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var tree map[string]interface{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &tree)
+	} else {
+		err = yaml.Unmarshal(data, &tree)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	s.path = path
+	if err := s.flatten("", tree); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// flatten stores the scalar values of a config file section under their
+// setting names
+func (s *configSource) flatten(prefix string, section map[string]interface{}) error {
+	for key, value := range section {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		name := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(path))
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if err := s.flatten(path, v); err != nil {
+				return err
+			}
+			continue
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			s.file[name] = strings.Join(items, ",")
+		case nil:
+			s.file[name] = ""
+		case float64:
+			s.file[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case string, bool, int, int64, uint64:
+			s.file[name] = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("unsupported value for %s", path)
+		}
+		if other, ok := s.fileKeys[name]; ok {
+			return fmt.Errorf("%s and %s are the same setting", other, path)
+		}
+		s.fileKeys[name] = path
+	}
+	return nil
+}
+
+// lookup returns the value of a setting and where it came from
+func (s *configSource) lookup(key string) (value, from string) {
+	s.used[key] = true
+	if value := os.Getenv(key); value != "" {
+		return value, "environment"
+	}
+	if value := s.file[key]; value != "" {
+		return value, s.path
+	}
+	return "", ""
+}
+
+func (s *configSource) invalid(key, from, value, expected string) {
+	s.errors = append(s.errors, fmt.Sprintf("%s (%s): %q is not %s", key, from, value, expected))
+}
+
+func (s *configSource) String(key, defaultValue string) string {
+	if value, from := s.lookup(key); from != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func (s *configSource) Int(key string, defaultValue int) int {
+	value, from := s.lookup(key)
+	if from == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		s.invalid(key, from, value, "a whole number")
+		return defaultValue
+	}
+	return n
+}
+
+func (s *configSource) Float(key string, defaultValue float64) float64 {
+	value, from := s.lookup(key)
+	if from == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		s.invalid(key, from, value, "a number")
+		return defaultValue
+	}
+	return f
+}
+
+func (s *configSource) Bool(key string, defaultValue bool) bool {
+	value, from := s.lookup(key)
+	if from == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		s.invalid(key, from, value, "true or false")
+		return defaultValue
+	}
+	return b
+}
+
+func (s *configSource) Duration(key string, defaultValue time.Duration) time.Duration {
+	value, from := s.lookup(key)
+	if from == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		s.invalid(key, from, value, "a duration (e.g. 500ms, 2s, 1h30m)")
+		return defaultValue
+	}
+	return d
+}
+
+// Err reports malformed values and config file keys that aren't settings;
+// call it after every setting has been read
+func (s *configSource) Err() error {
+	problems := append([]string(nil), s.errors...)
+
+	var unknown []string
+	for name, key := range s.fileKeys {
+		if !s.used[name] {
+			unknown = append(unknown, fmt.Sprintf("%s: unknown setting %q", s.path, key))
+		}
+	}
+	sort.Strings(unknown)
+	problems = append(problems, unknown...)
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// ============= CONFIG RELOAD =============
+// SIGHUP or POST /api/v1/admin/config/reload (X-Admin-Token: ADMIN_TOKEN)
+// re-reads the configuration. An invalid configuration changes nothing.
+// Otherwise the API rate limits, LOG_LEVEL and GROUP_SYNC_DELAY are applied
+// right away; other changed settings are reported and need a restart.
+// Environment variables are read again, but .env only at startup.
+
+// reloadableSettings are the Config fields applied by a reload
+var reloadableSettings = map[string]bool{
+	"RateLimitPerMinute":     true,
+	"RateLimitBurst":         true,
+	"RateLimitSendPerMinute": true,
+	"RateLimitSendBurst":     true,
+	"RateLimitReadPerMinute": true,
+	"RateLimitReadBurst":     true,
+	"RateLimitUserLimits":    true,
+	"LogLevel":               true,
+	"GroupSyncDelay":         true,
+}
+
+// ConfigReloader applies configuration changes to the running server
+type ConfigReloader struct {
+	mu          sync.Mutex
+	started     *Config // config the server was started with
+	current     *Config // config of the last reload
+	ws          *WhatsAppService
+	rateLimiter *RateLimiter
+}
+
+// ConfigReloadResult lists the settings a reload changed
+type ConfigReloadResult struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+func NewConfigReloader(cfg *Config, ws *WhatsAppService, rateLimiter *RateLimiter) *ConfigReloader {
+	return &ConfigReloader{started: cfg, current: cfg, ws: ws, rateLimiter: rateLimiter}
+}
+
+// Reload re-reads the configuration and applies the reloadable settings
+func (r *ConfigReloader) Reload() (*ConfigReloadResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	userLimits, err := parseUserRateLimits(cfg.RateLimitUserLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ConfigReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	for _, field := range changedSettings(r.current, cfg) {
+		if r.reloadable(field) {
+			result.Applied = append(result.Applied, field)
+		}
+	}
+	for _, field := range changedSettings(r.started, cfg) {
+		if !r.reloadable(field) {
+			result.RestartRequired = append(result.RestartRequired, field)
+		}
+	}
+
+	r.rateLimiter.SetLimits(cfg, userLimits)
+	setClientLogLevel(cfg.LogLevel)
+	r.ws.SetGroupSyncDelay(cfg.GroupSyncDelay)
+	r.current = cfg
+
+	log.Printf("🔁 Config reloaded: applied %v, restart required for %v", result.Applied, result.RestartRequired)
+	return result, nil
+}
+
+// reloadable reports whether a changed setting takes effect without a
+// restart; rate limits only do while rate limiting is enabled
+func (r *ConfigReloader) reloadable(field string) bool {
+	if r.rateLimiter == nil && field != "LogLevel" && field != "GroupSyncDelay" {
+		return false
+	}
+	return reloadableSettings[field]
+}
+
+// changedSettings returns the names of the Config fields that differ
+func changedSettings(old, new *Config) []string {
+	var changed []string
+	oldValue, newValue := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	for i := 0; i < oldValue.NumField(); i++ {
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			changed = append(changed, oldValue.Type().Field(i).Name)
+		}
+	}
+	return changed
+}
+
+// WatchSignals reloads the configuration on SIGHUP
+func (r *ConfigReloader) WatchSignals(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				log.Println("🔁 SIGHUP received, reloading config...")
+				if _, err := r.Reload(); err != nil {
+					log.Printf("❌ Config reload failed, keeping the current config: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// HandleReload reloads the configuration for POST /admin/config/reload
+func (r *ConfigReloader) HandleReload(c *gin.Context) {
+	result, err := r.Reload()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"data":        result,
+		"reloaded_at": time.Now(),
+	})
+}
+
+// ============= CLIENT LOG LEVEL =============
+
+// logLevels are the LOG_LEVEL values by severity
+var logLevels = map[string]int32{"DEBUG": 0, "INFO": 1, "WARN": 2, "ERROR": 3}
+
+var clientLogLevel atomic.Int32
+
+func setClientLogLevel(level string) {
+	clientLogLevel.Store(logLevels[level])
+}
+
+// clientLogger is the whatsmeow logger of the sessions; it drops messages
+// below the current LOG_LEVEL, so the level can change while clients run
+type clientLogger struct {
+	out waLog.Logger
+}
+
+func newClientLogger(module string) waLog.Logger {
+	return &clientLogger{out: waLog.Stdout(module, "DEBUG", true)}
+}
+
+func (l *clientLogger) Errorf(msg string, args ...interface{}) {
+	if clientLogLevel.Load() <= logLevels["ERROR"] {
+		l.out.Errorf(msg, args...)
+	}
+}
+
+func (l *clientLogger) Warnf(msg string, args ...interface{}) {
+	if clientLogLevel.Load() <= logLevels["WARN"] {
+		l.out.Warnf(msg, args...)
+	}
+}
+
+func (l *clientLogger) Infof(msg string, args ...interface{}) {
+	if clientLogLevel.Load() <= logLevels["INFO"] {
+		l.out.Infof(msg, args...)
+	}
+}
+
+func (l *clientLogger) Debugf(msg string, args ...interface{}) {
+	if clientLogLevel.Load() <= logLevels["DEBUG"] {
+		l.out.Debugf(msg, args...)
+	}
+}
+
+func (l *clientLogger) Sub(module string) waLog.Logger {
+	return &clientLogger{out: l.out.Sub(module)}
+}
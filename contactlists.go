@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= CONTACT LISTS =============
+// Contact lists are built from CSV uploads. Every row is validated as a phone
+// number and checked with IsOnWhatsApp through the cached resolver, in batches,
+// then stored with its name and any extra columns. Campaigns can target a list
+// instead of a raw recipient array; only members found on WhatsApp are
+// messaged, and their extra columns become template variables.
+
+const (
+	contactImportMaxRows   = 10000
+	contactImportMaxSize   = 5 * 1024 * 1024
+	contactImportBatchSize = 500
+)
+
+// phoneColumns and nameColumns are the accepted CSV headers for the phone
+// number and the contact name; every other column is a custom field
+var (
+	phoneColumns = map[string]bool{"phone": true, "phone_number": true, "mobile": true, "number": true, "whatsapp": true}
+	nameColumns  = map[string]bool{"name": true, "full_name": true}
+)
+
+// ContactImportResult summarizes a CSV import
+type ContactImportResult struct {
+	ContactList *WhatsAppContactList        `json:"contact_list"`
+	Duplicates  int                         `json:"duplicates"`
+	Rejected    []WhatsAppContactListMember `json:"rejected,omitempty"` // invalid or not on WhatsApp
+}
+
+// normalizeFieldName turns a CSV header into a template variable name
+func normalizeFieldName(header string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(header)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			sb.WriteRune(r)
+		case r == ' ', r == '-', r == '.':
+			sb.WriteByte('_')
+		}
+	}
+	name := strings.Trim(sb.String(), "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "field_" + name
+	}
+	return name
+}
+
+// parseContactCSV reads an import file. The first row must be a header with
+// a phone column. Returns the rows and the custom field names.
+func parseContactCSV(r io.Reader) ([]WhatsAppContactListMember, []string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("CSV file is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	phoneCol, nameCol := -1, -1
+	columns := make([]string, len(header))
+	var fields []string
+	seen := make(map[string]bool)
+	for i, h := range header {
+		if i == 0 {
+			h = strings.TrimPrefix(h, "\ufeff")
+		}
+		name := normalizeFieldName(h)
+		switch {
+		case phoneCol < 0 && phoneColumns[name]:
+			phoneCol = i
+		case nameCol < 0 && nameColumns[name]:
+			nameCol = i
+		case name != "" && !seen[name]:
+			seen[name] = true
+			columns[i] = name
+			fields = append(fields, name)
+		}
+	}
+	if phoneCol < 0 {
+		return nil, nil, fmt.Errorf("CSV header needs a phone column (phone, phone_number, mobile, number or whatsapp)")
+	}
+
+	var members []WhatsAppContactListMember
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if phoneCol >= len(record) || strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		if len(members) >= contactImportMaxRows {
+			return nil, nil, fmt.Errorf("CSV has more than %d rows", contactImportMaxRows)
+		}
+
+		line, _ := reader.FieldPos(0)
+		member := WhatsAppContactListMember{Line: line}
+		custom := JSONData{}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch {
+			case i >= len(columns):
+			case i == phoneCol:
+				member.Input = truncate(value, 50)
+			case i == nameCol:
+				member.Name = truncate(value, 255)
+			case columns[i] != "" && value != "":
+				custom[columns[i]] = value
+			}
+		}
+		if len(custom) > 0 {
+			member.Fields = custom
+		}
+		members = append(members, member)
+	}
+	return members, fields, nil
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
+
+// ImportContactList validates the numbers in a CSV file and saves them as a
+// named contact list. Duplicate numbers are dropped.
+func (ws *WhatsAppService) ImportContactList(sessionID string, userID int, name string, r io.Reader) (*ContactImportResult, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("list name is required")
+	}
+
+	sc, err := ws.getConnectedClient(sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := ws.db.ContactListExists(userID, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check contact lists: %w", err)
+	}
+	if exists {
+		return nil, fmt.Errorf("contact list %q already exists", name)
+	}
+
+	rows, fields, err := parseContactCSV(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("CSV has no rows")
+	}
+
+	result := &ContactImportResult{}
+	members := make([]WhatsAppContactListMember, 0, len(rows))
+	seen := make(map[string]bool, len(rows))
+	var phones []string
+	for _, member := range rows {
+		phone, err := wajid.NormalizePhone(member.Input)
+		if err != nil {
+			member.Status = ContactListMemberInvalid
+			member.Error = "invalid phone number"
+			members = append(members, member)
+			continue
+		}
+		if seen[phone] {
+			result.Duplicates++
+			continue
+		}
+		seen[phone] = true
+		member.Phone = phone
+		phones = append(phones, phone)
+		members = append(members, member)
+	}
+
+	// IsOnWhatsApp is queried in batches; cached numbers skip the query
+	lookups := make(map[string]wajid.Lookup, len(phones))
+	for start := 0; start < len(phones); start += contactImportBatchSize {
+		end := min(start+contactImportBatchSize, len(phones))
+		results, _, err := ws.jidResolver.LookupMany(context.Background(), sc.Client, phones[start:end], false)
+		if err != nil {
+			return nil, err
+		}
+		for phone, lookup := range results {
+			lookups[phone] = lookup
+		}
+	}
+
+	list := &WhatsAppContactList{
+		UserID:    userID,
+		SessionID: sessionID,
+		Name:      name,
+		Fields:    strings.Join(fields, ","),
+		Total:     len(members),
+	}
+	for i := range members {
+		member := &members[i]
+		if member.Status == "" {
+			lookup := lookups[member.Phone]
+			if lookup.Registered {
+				member.Status = ContactListMemberValid
+				member.JID = lookup.JID.String()
+			} else {
+				member.Status = ContactListMemberNotOnWhatsApp
+				member.Error = "not on WhatsApp"
+			}
+		}
+
+		switch member.Status {
+		case ContactListMemberValid:
+			list.Valid++
+		case ContactListMemberInvalid:
+			list.Invalid++
+			result.Rejected = append(result.Rejected, *member)
+		case ContactListMemberNotOnWhatsApp:
+			list.NotOnWhatsApp++
+			result.Rejected = append(result.Rejected, *member)
+		}
+	}
+	list.Members = members
+
+	if err := ws.db.CreateContactList(list); err != nil {
+		return nil, fmt.Errorf("failed to save contact list: %w", err)
+	}
+	list.Members = nil
+
+	log.Printf("📇 Imported contact list %q: %d valid, %d invalid, %d not on WhatsApp, %d duplicates",
+		name, list.Valid, list.Invalid, list.NotOnWhatsApp, result.Duplicates)
+
+	result.ContactList = list
+	return result, nil
+}
+
+// GetContactList returns a list with its members, optionally filtered by status
+func (ws *WhatsAppService) GetContactList(userID int, listID int64, status string) (*WhatsAppContactList, error) {
+	list, err := ws.db.GetContactList(listID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("contact list not found")
+		}
+		return nil, fmt.Errorf("failed to load contact list: %w", err)
+	}
+
+	members, err := ws.db.GetContactListMembers(list.ID, ContactListMemberStatus(status))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load contact list members: %w", err)
+	}
+	list.Members = members
+	return list, nil
+}
+
+// DeleteContactList removes a list and its members
+func (ws *WhatsAppService) DeleteContactList(userID int, listID int64) error {
+	if err := ws.db.DeleteContactList(listID, userID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("contact list not found")
+		}
+		return fmt.Errorf("failed to delete contact list: %w", err)
+	}
+	return nil
+}
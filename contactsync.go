@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= CONTACT SYNC =============
+// Contacts are kept fresh incrementally instead of re-upserting the whole
+// list. Contact changes from app state (events.Contact) and new push names
+// (events.PushName) are collected per session and written in batches. A
+// background delta sync compares each connected session's whatsmeow contact
+// store with the stored contacts every CONTACT_SYNC_INTERVAL, tracked by the
+// session's contacts_synced_at watermark, so a restart doesn't sync every
+// session again. Either way only new contacts and changed names are written.
+// The delta sync also mirrors the LIDs of the contacts (lidmap.go).
+
+const (
+	contactFlushDelay       = 5 * time.Second // collects a burst of contact events
+	contactFlushSize        = 500             // pending changes that are written right away
+	contactSyncPollInterval = time.Minute
+)
+
+// pendingContacts are the changed contacts of a session not written yet
+type pendingContacts struct {
+	mu    sync.Mutex
+	jids  map[types.JID]struct{}
+	timer *time.Timer
+}
+
+// ContactSyncResult is the outcome of a contact delta sync
+type ContactSyncResult struct {
+	Contacts int       `json:"contacts"` // contacts in the session's contact store
+	Updated  int       `json:"updated"`  // new or renamed, written to the database
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// StartContactSyncWorker starts the periodic contact delta sync
+func (ws *WhatsAppService) StartContactSyncWorker(ctx context.Context) {
+	if ws.cfg.ContactSyncInterval <= 0 {
+		log.Println("ℹ️  Contact sync worker disabled (contacts follow events only)")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(contactSyncPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ws.processContactSync(ctx)
+			}
+		}
+	}()
+	log.Println("✅ Contact sync worker started")
+}
+
+func (ws *WhatsAppService) processContactSync(ctx context.Context) {
+	sessionIDs, err := ws.db.GetContactSyncDueSessions(time.Now().Add(-ws.cfg.ContactSyncInterval))
+	if err != nil {
+		log.Printf("❌ Failed to load sessions due for contact sync: %v", err)
+		return
+	}
+
+	for _, sessionID := range sessionIDs {
+		if ctx.Err() != nil {
+			return
+		}
+		sc, ok := ws.sessions.Get(sessionID)
+		if !ok {
+			continue // connected on another instance
+		}
+		if !sc.Client.IsConnected() || !sc.Client.IsLoggedIn() {
+			continue
+		}
+		if _, err := ws.syncSessionContacts(sc); err != nil {
+			log.Printf("❌ Contact sync failed for session %s: %v", sessionID, err)
+		}
+	}
+}
+
+// SyncContacts runs a contact delta sync of a connected session now
+func (ws *WhatsAppService) SyncContacts(sessionID string, userID int) (*ContactSyncResult, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+	sc, err := ws.GetSessionClient(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !sc.Client.IsConnected() || !sc.Client.IsLoggedIn() {
+		return nil, apierr.ErrSessionNotConnected
+	}
+	return ws.syncSessionContacts(sc)
+}
+
+// syncSessionContacts writes the contacts of a session's contact store that
+// are new or renamed and moves the session's watermark
+func (ws *WhatsAppService) syncSessionContacts(sc *SessionClient) (*ContactSyncResult, error) {
+	startedAt := time.Now()
+	all, err := sc.Device.Contacts.GetAllContacts(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contact store: %w", err)
+	}
+
+	var ownJID types.JID
+	if sc.Device.ID != nil {
+		ownJID = sc.Device.ID.ToNonAD()
+	}
+	contacts := make([]WhatsAppContact, 0, len(all))
+	phones := make([]types.JID, 0, len(all))
+	for jid, info := range all {
+		if jid.Server != types.DefaultUserServer || jid.ToNonAD() == ownJID {
+			continue
+		}
+		contacts = append(contacts, *parseContact(jid.ToNonAD().String(), contactName(info), sc.UserID))
+		phones = append(phones, jid.ToNonAD())
+	}
+
+	updated, err := ws.saveContactChanges(sc.UserID, contacts)
+	if err != nil {
+		return nil, err
+	}
+	ws.syncLIDMappings(sc, phones)
+	if err := ws.db.SetContactsSynced(sc.SessionID, startedAt); err != nil {
+		log.Printf("❌ Failed to update contact sync watermark of session %s: %v", sc.SessionID, err)
+	}
+
+	result := &ContactSyncResult{Contacts: len(contacts), Updated: updated, SyncedAt: startedAt}
+	if updated > 0 {
+		log.Printf("📇 Contact sync for session %s: %d of %d contacts new or renamed", sc.SessionID, updated, len(contacts))
+
+		data := map[string]interface{}{
+			"contacts":  result.Contacts,
+			"updated":   result.Updated,
+			"synced_at": result.SyncedAt,
+		}
+		sessionUUID, _ := uuid.Parse(sc.SessionID)
+		ws.db.CreateEvent(sessionUUID, sc.UserID, "contacts_synced", data)
+		ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+			Type: "contacts_synced",
+			Data: data,
+		})
+	}
+	return result, nil
+}
+
+// queueContactChange schedules writing a contact that changed in the
+// session's contact store
+func (ws *WhatsAppService) queueContactChange(sc *SessionClient, jid types.JID) {
+	if jid.Server != types.DefaultUserServer {
+		return
+	}
+
+	value, _ := ws.contactChanges.LoadOrStore(sc.SessionID, &pendingContacts{})
+	pending := value.(*pendingContacts)
+
+	pending.mu.Lock()
+	if pending.jids == nil {
+		pending.jids = make(map[types.JID]struct{})
+	}
+	pending.jids[jid.ToNonAD()] = struct{}{}
+	full := len(pending.jids) >= contactFlushSize
+	if !full && pending.timer == nil {
+		pending.timer = time.AfterFunc(contactFlushDelay, func() {
+			sc.submit("contact flush", func() { ws.flushContactChanges(sc, pending) })
+		})
+	}
+	pending.mu.Unlock()
+
+	if full {
+		sc.submit("contact flush", func() { ws.flushContactChanges(sc, pending) })
+	}
+}
+
+// flushContactChanges writes the pending contacts of a session
+func (ws *WhatsAppService) flushContactChanges(sc *SessionClient, pending *pendingContacts) {
+	pending.mu.Lock()
+	jids := pending.jids
+	pending.jids = nil
+	if pending.timer != nil {
+		pending.timer.Stop()
+		pending.timer = nil
+	}
+	pending.mu.Unlock()
+	if len(jids) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	contacts := make([]WhatsAppContact, 0, len(jids))
+	for jid := range jids {
+		info, err := sc.Device.Contacts.GetContact(ctx, jid)
+		if err != nil {
+			log.Printf("⚠️  Failed to read contact %s of session %s: %v", jid, sc.SessionID, err)
+			continue
+		}
+		contacts = append(contacts, *parseContact(jid.String(), contactName(info), sc.UserID))
+	}
+
+	updated, err := ws.saveContactChanges(sc.UserID, contacts)
+	if err != nil {
+		log.Printf("❌ Failed to save contact changes of session %s: %v", sc.SessionID, err)
+		return
+	}
+	if updated > 0 {
+		log.Printf("📇 Saved %d changed contact(s) for session %s", updated, sc.SessionID)
+	}
+}
+
+// saveContactChanges upserts the contacts that are new or whose name changed;
+// a contact without a name never clears a stored one
+func (ws *WhatsAppService) saveContactChanges(userID int, contacts []WhatsAppContact) (int, error) {
+	if len(contacts) == 0 {
+		return 0, nil
+	}
+
+	jids := make([]string, len(contacts))
+	for i := range contacts {
+		jids[i] = contacts[i].JID
+	}
+	names, err := ws.db.GetContactNames(userID, jids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load stored contacts: %w", err)
+	}
+
+	changed := make([]WhatsAppContact, 0)
+	for _, contact := range contacts {
+		if stored, ok := names[contact.JID]; ok && (contact.FullName == "" || contact.FullName == stored) {
+			continue
+		}
+		changed = append(changed, contact)
+	}
+	if err := ws.db.BulkUpsertContacts(changed); err != nil {
+		return 0, fmt.Errorf("failed to save contacts: %w", err)
+	}
+	return len(changed), nil
+}
+
+// contactName picks the best known name of a contact: the address book name,
+// then the push name, then the business name
+func contactName(info types.ContactInfo) string {
+	switch {
+	case info.FullName != "":
+		return info.FullName
+	case info.FirstName != "":
+		return info.FirstName
+	case info.PushName != "":
+		return info.PushName
+	}
+	return info.BusinessName
+}
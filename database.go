@@ -8,8 +8,7 @@ import (
 	"fmt"
 	"gorm.io/gorm/clause"
 	"log"
-	"os"
-	"path/filepath"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -17,7 +16,6 @@ import (
 	"go.mau.fi/whatsmeow/store"
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	_ "modernc.org/sqlite" // Pure Go SQLite driver (no CGO required)
@@ -28,13 +26,26 @@ import (
 type SessionStatus string
 
 const (
-	StatusPending      SessionStatus = "pending"
-	StatusQRReady      SessionStatus = "qr_ready"
-	StatusScanning     SessionStatus = "scanning"
-	StatusConnected    SessionStatus = "connected"
-	StatusDisconnected SessionStatus = "disconnected"
-	StatusFailed       SessionStatus = "failed"
-	StatusExpired      SessionStatus = "expired"
+	StatusPending       SessionStatus = "pending"
+	StatusQRReady       SessionStatus = "qr_ready"
+	StatusScanning      SessionStatus = "scanning"
+	StatusConnected     SessionStatus = "connected"
+	StatusDisconnected  SessionStatus = "disconnected"
+	StatusFailed        SessionStatus = "failed"
+	StatusExpired       SessionStatus = "expired"
+	StatusBanned        SessionStatus = "banned"         // temporarily banned by WhatsApp until banned_until
+	StatusConnectFailed SessionStatus = "connect_failed" // WhatsApp refused the connection
+	StatusUnlinked      SessionStatus = "unlinked"       // logged out from the phone or by WhatsApp
+)
+
+type OutboxStatus string
+
+const (
+	OutboxQueued     OutboxStatus = "queued"
+	OutboxSending    OutboxStatus = "sending"
+	OutboxSent       OutboxStatus = "sent"
+	OutboxFailed     OutboxStatus = "failed"
+	OutboxSuppressed OutboxStatus = "suppressed"
 )
 
 // WhatsAppSession represents a WhatsApp session in the database
@@ -58,6 +69,28 @@ type WhatsAppSession struct {
 	Platform          *string        `gorm:"size:50" json:"platform,omitempty"`
 	IsActive          bool           `gorm:"default:true;index" json:"is_active"`
 	IsBusinessAccount bool           `gorm:"default:false" json:"is_business_account"` // NEW FIELD
+	PairedAt          *time.Time     `json:"paired_at,omitempty"`                      // start of the warm-up ramp
+	WarmupProfile     string         `gorm:"size:20" json:"warmup_profile,omitempty"`  // empty = SAFETY_WARMUP_PROFILE
+	DailySendLimit    int            `json:"daily_send_limit,omitempty"`               // 0 = SAFETY_DAILY_LIMIT
+	SafetyPausedUntil *time.Time     `json:"safety_paused_until,omitempty"`
+	StatusReason      string         `gorm:"size:255" json:"status_reason,omitempty"` // why WhatsApp banned, refused or unlinked the session
+	StatusReasonCode  int            `json:"status_reason_code,omitempty"`            // WhatsApp failure or ban code
+	BannedUntil       *time.Time     `json:"banned_until,omitempty"`
+	ContactsSyncedAt  *time.Time     `json:"contacts_synced_at,omitempty"`  // last contact delta sync
+	BlocklistSyncedAt *time.Time     `json:"blocklist_synced_at,omitempty"` // last blocklist reconciliation
+	CallAutoReject    bool           `gorm:"default:false" json:"call_auto_reject"`
+	CallRejectMessage string         `gorm:"type:text" json:"call_reject_message,omitempty"` // sent to the caller after an auto-reject
+	SendReadReceipts  bool           `gorm:"default:true" json:"send_read_receipts"`         // false: chats are marked read without telling the sender
+	ProjectID         *int64         `gorm:"index" json:"project_id,omitempty"`
+	MediaAutoStore    bool           `gorm:"default:false" json:"media_auto_store"` // copy incoming media to media storage
+	OutboxTTLSeconds  int            `gorm:"default:0" json:"outbox_ttl_seconds"`   // queued sends expire after this long, 0 = OUTBOX_DEFAULT_TTL
+	CanaryJID         string         `gorm:"size:255" json:"canary_jid,omitempty"`  // health canary target, "self" = own account
+	CanaryMode        string         `gorm:"size:20" json:"canary_mode,omitempty"`  // message or presence
+	PurgeTokenHash    string         `gorm:"size:64" json:"-"`                      // SHA-256 of the confirmation token of a pending purge
+	PurgeTokenExpiry  *time.Time     `json:"-"`
+	InboundFilter     string         `gorm:"size:20;default:'off'" json:"inbound_filter"` // off, ignore, reply or block unknown senders
+	InboundReply      string         `gorm:"type:text" json:"inbound_reply,omitempty"`    // sent to unknown senders in reply mode
+	InboundAllowSaved bool           `gorm:"default:true" json:"inbound_allow_saved"`     // address book contacts aren't unknown
 	CreatedAt         time.Time      `json:"created_at"`
 	UpdatedAt         time.Time      `json:"updated_at"`
 	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
@@ -65,32 +98,41 @@ type WhatsAppSession struct {
 
 // WhatsAppContact represents a contact
 type WhatsAppContact struct {
-	ID            int64     `gorm:"primaryKey;autoIncrement" json:"id"`
-	UserID        int       `gorm:"not null;index:idx_user_jid,unique" json:"user_id"`
-	FullName      string    `gorm:"size:255" json:"full_name"`
-	FirstName     string    `gorm:"size:100" json:"first_name"`
-	LastName      string    `gorm:"size:155" json:"last_name"`
-	JID           string    `gorm:"column:jid;size:255;not null;index:idx_user_jid,unique" json:"jid"`
-	CountryCode   string    `gorm:"size:10" json:"country_code"`
-	MobileNumber  string    `gorm:"size:50" json:"mobile_number"`
-	GroupID       *int64    `gorm:"index" json:"group_id,omitempty"`      // NEW FIELD
-	IsGroupMember bool      `gorm:"default:false" json:"is_group_member"` // NEW FIELD
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ID            int64          `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID        int            `gorm:"not null;index:idx_user_jid,unique" json:"user_id"`
+	FullName      string         `gorm:"size:255" json:"full_name"`
+	FirstName     string         `gorm:"size:100" json:"first_name"`
+	LastName      string         `gorm:"size:155" json:"last_name"`
+	JID           string         `gorm:"column:jid;size:255;not null;index:idx_user_jid,unique" json:"jid"`
+	CountryCode   string         `gorm:"size:10" json:"country_code"`
+	MobileNumber  string         `gorm:"size:50" json:"mobile_number"`
+	GroupID       *int64         `gorm:"index" json:"group_id,omitempty"`      // NEW FIELD
+	IsGroupMember bool           `gorm:"default:false" json:"is_group_member"` // NEW FIELD
+	IsBlocked     bool           `gorm:"default:false" json:"is_blocked"`      // on the blocklist of any session of the user
+	Tags          []string       `gorm:"-" json:"tags,omitempty"`              // loaded from WhatsAppContactTag
+	Score         int            `gorm:"-" json:"score"`                       // loaded from WhatsAppContactScore
+	HotLeadAt     *time.Time     `gorm:"-" json:"hot_lead_at,omitempty"`       // loaded from WhatsAppContactScore
+	Notes         []WhatsAppNote `gorm:"-" json:"notes,omitempty"`             // loaded from WhatsAppNote
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
 }
 
 type WhatsAppGroup struct {
-	ID               int64     `gorm:"primaryKey;autoIncrement" json:"id"`
-	UserID           int       `gorm:"not null;index:idx_user_group,unique" json:"user_id"`
-	SessionID        string    `gorm:"type:char(36);index" json:"session_id"`
-	GroupJID         string    `gorm:"column:group_jid;size:255;not null;index:idx_user_group,unique" json:"group_jid"`
-	GroupName        string    `gorm:"size:255" json:"group_name"`
-	GroupSubject     *string   `gorm:"type:text" json:"group_subject,omitempty"`
-	ParticipantCount int       `gorm:"default:0" json:"participant_count"`
-	IsAnnouncement   bool      `gorm:"default:false" json:"is_announcement"`
-	IsLocked         bool      `gorm:"default:false" json:"is_locked"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	ID               int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID           int        `gorm:"not null;index:idx_user_group,unique" json:"user_id"`
+	SessionID        string     `gorm:"type:char(36);index" json:"session_id"`
+	GroupJID         string     `gorm:"column:group_jid;size:255;not null;index:idx_user_group,unique" json:"group_jid"`
+	GroupName        string     `gorm:"size:255" json:"group_name"`
+	GroupSubject     *string    `gorm:"type:text" json:"group_subject,omitempty"`
+	ParticipantCount int        `gorm:"default:0" json:"participant_count"`
+	IsAnnouncement   bool       `gorm:"default:false" json:"is_announcement"`
+	IsLocked         bool       `gorm:"default:false" json:"is_locked"`
+	EphemeralTimer   uint32     `gorm:"default:0" json:"ephemeral_timer"` // disappearing messages timer in seconds, 0 = off
+	MemberAddMode    string     `gorm:"size:20" json:"member_add_mode"`   // admin_add or all_member_add
+	JoinApproval     bool       `gorm:"default:false" json:"join_approval_required"`
+	SyncedAt         *time.Time `json:"synced_at,omitempty"` // last full fetch by a group sync
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
 }
 
 // BeforeCreate hook to generate UUID
@@ -111,7 +153,850 @@ type WhatsAppEvent struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
-// JSONData type for MySQL JSON fields
+// WhatsAppChat represents a conversation known to a session
+type WhatsAppChat struct {
+	ID            int64          `gorm:"primaryKey;autoIncrement" json:"id"`
+	SessionID     string         `gorm:"type:char(36);not null;index:idx_session_chat,unique" json:"session_id"`
+	UserID        int            `gorm:"not null;index" json:"user_id"`
+	ChatJID       string         `gorm:"column:chat_jid;size:255;not null;index:idx_session_chat,unique" json:"chat_jid"`
+	Name          string         `gorm:"size:255" json:"name"`
+	IsGroup       bool           `gorm:"default:false" json:"is_group"`
+	UnreadCount   int            `gorm:"default:0" json:"unread_count"`
+	Archived      bool           `gorm:"default:false" json:"archived"`
+	Pinned        bool           `gorm:"default:false" json:"pinned"`
+	MutedUntil    *time.Time     `json:"muted_until,omitempty"`
+	LastMessageAt *time.Time     `gorm:"index" json:"last_message_at,omitempty"`
+	Notes         []WhatsAppNote `gorm:"-" json:"notes,omitempty"` // loaded from WhatsAppNote
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+// WhatsAppMessage represents a stored message (live or imported from history sync)
+type WhatsAppMessage struct {
+	ID          int64            `gorm:"primaryKey;autoIncrement" json:"id"`
+	SessionID   string           `gorm:"type:char(36);not null;index:idx_session_chat_message,unique" json:"session_id"`
+	UserID      int              `gorm:"not null;index" json:"user_id"`
+	ChatJID     string           `gorm:"column:chat_jid;size:255;not null;index:idx_session_chat_message,unique" json:"chat_jid"`
+	MessageID   string           `gorm:"size:128;not null;index:idx_session_chat_message,unique" json:"message_id"`
+	SenderJID   string           `gorm:"column:sender_jid;size:255" json:"sender_jid"`
+	PushName    string           `gorm:"size:255" json:"push_name,omitempty"`
+	FromMe      bool             `gorm:"default:false" json:"from_me"`
+	MessageType string           `gorm:"size:50" json:"message_type"`
+	Content     string           `gorm:"type:text" json:"content"`
+	Media       JSONData         `gorm:"type:json" json:"media,omitempty"` // download reference of media messages
+	IsRead      bool             `gorm:"default:false;index" json:"is_read"`
+	Source      string           `gorm:"size:20;default:'live'" json:"source"` // live, history or api (sent by this server)
+	ViewOnce    bool             `gorm:"default:false" json:"view_once"`
+	Ephemeral   bool             `gorm:"default:false" json:"ephemeral"` // sent in a chat with disappearing messages
+	Reactions   MessageReactions `gorm:"type:json" json:"reactions,omitempty"`
+	EditedAt    *time.Time       `json:"edited_at,omitempty"`
+	Revoked     bool             `gorm:"default:false" json:"revoked"` // deleted for everyone; content and media are cleared
+	RevokedAt   *time.Time       `json:"revoked_at,omitempty"`
+	DeliveredAt *time.Time       `json:"delivered_at,omitempty"` // first delivery receipt of an outgoing message
+	ReadAt      *time.Time       `json:"read_at,omitempty"`      // first read receipt of an outgoing message
+	Timestamp   time.Time        `gorm:"index" json:"timestamp"`
+	CreatedAt   time.Time        `json:"created_at"`
+
+	// Context info of received messages: the message replied to, mentions and forwarding
+	QuotedMessageID string   `gorm:"size:128" json:"quoted_message_id,omitempty"`
+	QuotedSenderJID string   `gorm:"column:quoted_sender_jid;size:255" json:"quoted_sender_jid,omitempty"`
+	MentionedJIDs   []string `gorm:"column:mentioned_jids;type:json;serializer:json" json:"mentioned_jids,omitempty"`
+	IsForwarded     bool     `gorm:"default:false" json:"is_forwarded"`
+	ForwardingScore uint32   `gorm:"default:0" json:"forwarding_score,omitempty"` // times forwarded; 5 and more is "forwarded many times"
+}
+
+// MessageReaction is one participant's reaction to a message
+type MessageReaction struct {
+	SenderJID string    `json:"sender_jid"`
+	Emoji     string    `json:"emoji"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// MessageReactions are the current reactions of a message, one per sender
+type MessageReactions []MessageReaction
+
+// WhatsAppBroadcastList represents a broadcast list managed by the API
+type WhatsAppBroadcastList struct {
+	ID           int64                        `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID       int                          `gorm:"not null;index" json:"user_id"`
+	SessionID    string                       `gorm:"type:char(36);not null;index" json:"session_id"`
+	Name         string                       `gorm:"size:255;not null" json:"name"`
+	BroadcastJID string                       `gorm:"column:broadcast_jid;size:255;not null;uniqueIndex" json:"broadcast_jid"`
+	SegmentID    *int64                       `gorm:"index" json:"segment_id,omitempty"` // members added at send time
+	Recipients   []WhatsAppBroadcastRecipient `gorm:"foreignKey:ListID;constraint:OnDelete:CASCADE" json:"recipients,omitempty"`
+	CreatedAt    time.Time                    `json:"created_at"`
+	UpdatedAt    time.Time                    `json:"updated_at"`
+}
+
+// WhatsAppBroadcastRecipient is a member of a broadcast list
+type WhatsAppBroadcastRecipient struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	ListID    int64     `gorm:"not null;index:idx_list_recipient,unique" json:"list_id"`
+	JID       string    `gorm:"column:jid;size:255;not null;index:idx_list_recipient,unique" json:"jid"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WhatsAppGroupSchedule is a recurring quiet-hours window during which a group is
+// switched to announce-only (admins only) and reverted afterwards
+type WhatsAppGroupSchedule struct {
+	ID          int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID      int        `gorm:"not null;index" json:"user_id"`
+	SessionID   string     `gorm:"type:char(36);not null;index:idx_session_group_schedule,unique" json:"session_id"`
+	GroupJID    string     `gorm:"column:group_jid;size:255;not null;index:idx_session_group_schedule,unique" json:"group_jid"`
+	StartTime   string     `gorm:"size:5;not null" json:"start_time"` // HH:MM
+	EndTime     string     `gorm:"size:5;not null" json:"end_time"`   // HH:MM, before StartTime for overnight windows
+	Timezone    string     `gorm:"size:64;default:'UTC'" json:"timezone"`
+	Days        string     `gorm:"size:64" json:"days"` // comma separated weekdays the window starts on (mon,tue,...), empty = every day
+	Enabled     bool       `gorm:"not null;index" json:"enabled"`
+	Active      bool       `gorm:"default:false" json:"active"` // window currently applied by the scheduler
+	WasAnnounce bool       `gorm:"default:false" json:"-"`      // announce state before the window started
+	LastRunAt   *time.Time `json:"last_run_at,omitempty"`
+	LastError   string     `gorm:"type:text" json:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// WhatsAppAvatar is a cached profile picture of a contact or group
+type WhatsAppAvatar struct {
+	ID          int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	SessionID   string    `gorm:"type:char(36);not null;index:idx_session_avatar,unique" json:"session_id"`
+	JID         string    `gorm:"column:jid;size:255;not null;index:idx_session_avatar,unique" json:"jid"`
+	PictureID   string    `gorm:"size:64" json:"picture_id"`
+	FilePath    string    `gorm:"size:512" json:"-"` // media storage key
+	ContentType string    `gorm:"size:50" json:"content_type"`
+	Size        int       `json:"size"`
+	ETag        string    `gorm:"column:etag;size:64" json:"etag"`
+	NotSet      bool      `gorm:"default:false" json:"not_set"` // no picture, or hidden from us
+	FetchedAt   time.Time `gorm:"index" json:"fetched_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WhatsAppPictureChange is a profile picture change of a contact or group
+// seen by a session; the latest few per JID are kept
+type WhatsAppPictureChange struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	SessionID string    `gorm:"type:char(36);not null;index:idx_picture_changes_jid" json:"session_id"`
+	JID       string    `gorm:"column:jid;size:255;not null;index:idx_picture_changes_jid" json:"jid"`
+	PictureID string    `gorm:"size:64" json:"picture_id,omitempty"` // empty when removed
+	Removed   bool      `gorm:"default:false" json:"removed"`
+	Author    string    `gorm:"size:255" json:"author,omitempty"` // who changed it (group admins)
+	ChangedAt time.Time `gorm:"index" json:"changed_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WhatsAppBlockedContact is a JID on the blocklist of a session's account
+type WhatsAppBlockedContact struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	SessionID string    `gorm:"type:char(36);not null;index:idx_blocked_session_jid,unique" json:"session_id"`
+	JID       string    `gorm:"column:jid;size:255;not null;index:idx_blocked_session_jid,unique" json:"jid"` // phone JID when known, else the LID
+	BlockedAt time.Time `json:"blocked_at"`                                                                   // when it was first seen blocked
+}
+
+// ChannelRole names a session of a channel
+type ChannelRole string
+
+const (
+	ChannelPrimary ChannelRole = "primary"
+	ChannelBackup  ChannelRole = "backup"
+)
+
+// WhatsAppChannel is a primary and a warm standby session sending under one
+// name; sends to the channel go through its active session
+type WhatsAppChannel struct {
+	ID               int64       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID           int         `gorm:"not null;uniqueIndex:idx_user_channel" json:"user_id"`
+	Name             string      `gorm:"size:100;not null;uniqueIndex:idx_user_channel" json:"name"`
+	PrimarySessionID string      `gorm:"type:char(36);not null;index" json:"primary_session_id"`
+	BackupSessionID  string      `gorm:"type:char(36);not null;index" json:"backup_session_id"`
+	Active           ChannelRole `gorm:"size:10;not null" json:"active"`
+	ActiveSessionID  string      `gorm:"-" json:"active_session_id"`
+	AutoFailback     bool        `gorm:"not null" json:"auto_failback"` // back to the primary once it's connected again
+	SwitchedAt       *time.Time  `json:"switched_at,omitempty"`
+	SwitchReason     string      `gorm:"size:255" json:"switch_reason,omitempty"`
+	CreatedAt        time.Time   `json:"created_at"`
+	UpdatedAt        time.Time   `json:"updated_at"`
+}
+
+// AfterFind fills in the session the channel currently sends through
+func (ch *WhatsAppChannel) AfterFind(tx *gorm.DB) error {
+	ch.ActiveSessionID = ch.sessionID(ch.Active)
+	return nil
+}
+
+// sessionID returns the session of a role
+func (ch *WhatsAppChannel) sessionID(role ChannelRole) string {
+	if role == ChannelBackup {
+		return ch.BackupSessionID
+	}
+	return ch.PrimarySessionID
+}
+
+// WhatsAppProject groups sessions of a user, e.g. per customer or team
+type WhatsAppProject struct {
+	ID          int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID      int       `gorm:"not null;uniqueIndex:idx_user_project" json:"user_id"`
+	Name        string    `gorm:"size:100;not null;uniqueIndex:idx_user_project" json:"name"`
+	Description string    `gorm:"size:500" json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WhatsAppBackup records a snapshot of an instance's WhatsApp store in
+// backup storage
+type WhatsAppBackup struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Instance   string    `gorm:"size:100;not null;index" json:"instance"`
+	StorageKey string    `gorm:"size:255;not null;uniqueIndex" json:"storage_key"`
+	Size       int64     `json:"size"`
+	SHA256     string    `gorm:"column:sha256;size:64" json:"sha256"`
+	Sessions   int       `json:"sessions"`               // session rows in the snapshot
+	Trigger    string    `gorm:"size:20" json:"trigger"` // scheduled, manual or api
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+// WhatsAppGroupInviteLink is an invite link of a group seen by a session,
+// with the joins through it
+type WhatsAppGroupInviteLink struct {
+	ID         int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID     int        `gorm:"not null;index" json:"user_id"`
+	SessionID  string     `gorm:"type:char(36);not null;uniqueIndex:idx_session_group_invite" json:"session_id"`
+	GroupJID   string     `gorm:"column:group_jid;size:255;not null;uniqueIndex:idx_session_group_invite" json:"group_jid"`
+	Code       string     `gorm:"size:64;not null;uniqueIndex:idx_session_group_invite" json:"code"`
+	Link       string     `gorm:"-" json:"link"`
+	Joins      int        `json:"joins"`
+	LastJoinAt *time.Time `json:"last_join_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"` // replaced by a newer link
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// WhatsAppEventFilter selects the events forwarded to a user's event streams
+type WhatsAppEventFilter struct {
+	ID        int64            `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    int              `gorm:"not null;uniqueIndex:idx_user_event_filter" json:"user_id"`
+	SessionID string           `gorm:"size:36;not null;default:'';uniqueIndex:idx_user_event_filter" json:"session_id,omitempty"` // empty = all sessions of the user
+	Rules     EventFilterRules `gorm:"type:json;serializer:json" json:"rules"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// WhatsAppSenderRule puts a sender on the allowlist or denylist of a
+// session's inbound filter
+type WhatsAppSenderRule struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	SessionID string    `gorm:"type:char(36);not null;uniqueIndex:idx_session_sender" json:"session_id"`
+	UserID    int       `gorm:"not null;index" json:"user_id"`
+	JID       string    `gorm:"column:jid;size:255;not null;uniqueIndex:idx_session_sender" json:"jid"` // phone JID when known, else the LID
+	List      string    `gorm:"size:10;not null;index" json:"list"`                                     // allow or deny
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WhatsAppReplicaHeartbeat is written to the primary by each instance with a
+// read replica; how far the replica's copy trails it is the replica's lag
+type WhatsAppReplicaHeartbeat struct {
+	Instance  string    `gorm:"primaryKey;size:255" json:"instance"`
+	Beat      int64     `gorm:"not null" json:"beat"` // UnixNano of the write
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WhatsAppJIDCache caches IsOnWhatsApp results per phone number (shared by all sessions)
+type WhatsAppJIDCache struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Phone      string    `gorm:"size:20;not null;uniqueIndex" json:"phone_number"`
+	JID        string    `gorm:"column:jid;size:255" json:"jid"`
+	Registered bool      `gorm:"default:false" json:"is_registered"`
+	CheckedAt  time.Time `gorm:"index" json:"checked_at"`
+}
+
+// WhatsAppLIDMapping pairs a LID with its phone number JID (shared by all
+// sessions, like the JID cache)
+type WhatsAppLIDMapping struct {
+	LID       string    `gorm:"column:lid;primaryKey;size:255" json:"lid"`
+	PhoneJID  string    `gorm:"column:phone_jid;size:255;not null;index" json:"phone_jid"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WhatsAppNumberInfo caches the profile of a registered number (business
+// flag, picture, devices), keyed by phone like the JID cache
+type WhatsAppNumberInfo struct {
+	ID           int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Phone        string    `gorm:"size:20;not null;uniqueIndex" json:"phone_number"`
+	IsBusiness   bool      `gorm:"default:false" json:"is_business"`
+	BusinessName string    `gorm:"size:255" json:"business_name,omitempty"`
+	HasPicture   bool      `gorm:"default:false" json:"has_profile_picture"`
+	Devices      int       `gorm:"default:0" json:"devices"`
+	CheckedAt    time.Time `gorm:"index" json:"checked_at"`
+}
+
+// WhatsAppMediaHandle is media uploaded once to WhatsApp and reusable across sends
+type WhatsAppMediaHandle struct {
+	ID            string    `gorm:"type:char(36);primaryKey" json:"id"`
+	UserID        int       `gorm:"not null;index" json:"user_id"`
+	SessionID     string    `gorm:"type:char(36);not null;index" json:"session_id"`
+	MediaType     string    `gorm:"size:20;not null" json:"media_type"`
+	Mimetype      string    `gorm:"size:255" json:"mimetype"`
+	Filename      string    `gorm:"size:255" json:"filename,omitempty"`
+	URL           string    `gorm:"type:text" json:"url"`
+	DirectPath    string    `gorm:"type:text" json:"direct_path"`
+	MediaKey      []byte    `gorm:"size:64" json:"media_key"`
+	FileSHA256    []byte    `gorm:"column:file_sha256;size:64" json:"file_sha256"`
+	FileEncSHA256 []byte    `gorm:"column:file_enc_sha256;size:64" json:"file_enc_sha256"`
+	FileLength    uint64    `json:"file_length"`
+	JPEGThumbnail []byte    `gorm:"column:jpeg_thumbnail" json:"-"`
+	Width         uint32    `json:"width,omitempty"`
+	Height        uint32    `json:"height,omitempty"`
+	ExpiresAt     time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// WhatsAppOutboxMessage is a send request queued for the outbox worker
+type WhatsAppOutboxMessage struct {
+	ID             int64        `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID         int          `gorm:"not null;uniqueIndex:idx_user_idempotency" json:"user_id"`
+	SessionID      string       `gorm:"type:char(36);not null;index" json:"session_id"`
+	IdempotencyKey string       `gorm:"size:255;not null;uniqueIndex:idx_user_idempotency" json:"idempotency_key"`
+	Recipient      string       `gorm:"size:255" json:"to"`
+	Payload        string       `json:"-"` // JSON encoded SendRequest
+	Status         OutboxStatus `gorm:"size:20;not null;index:idx_outbox_due" json:"status"`
+	Attempts       int          `json:"attempts"`
+	NextAttemptAt  time.Time    `gorm:"index:idx_outbox_due" json:"next_attempt_at"`
+	MessageID      string       `gorm:"size:255" json:"message_id,omitempty"`
+	MessageType    string       `gorm:"size:50" json:"message_type,omitempty"`
+	Error          string       `gorm:"type:text" json:"error,omitempty"`
+	SentAt         *time.Time   `json:"sent_at,omitempty"`
+	ExpiresAt      *time.Time   `json:"expires_at,omitempty"` // failed as expired when not sent by then
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+// WhatsAppSuppression is a phone number the user must not message (opt-out)
+type WhatsAppSuppression struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    int       `gorm:"not null;uniqueIndex:idx_user_suppression" json:"user_id"`
+	Phone     string    `gorm:"size:20;not null;uniqueIndex:idx_user_suppression" json:"phone_number"`
+	Reason    string    `gorm:"size:50" json:"reason"` // manual, stop_keyword
+	SessionID string    `gorm:"type:char(36)" json:"session_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WhatsAppSafetyCounter counts a session's sends per UTC day
+type WhatsAppSafetyCounter struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	SessionID string    `gorm:"type:char(36);not null;uniqueIndex:idx_session_day" json:"session_id"`
+	Day       string    `gorm:"size:10;not null;uniqueIndex:idx_session_day" json:"day"` // YYYY-MM-DD
+	Sent      int       `gorm:"not null;default:0" json:"sent"`
+	Failed    int       `gorm:"not null;default:0" json:"failed"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WhatsAppContactList is a named set of imported recipients
+type WhatsAppContactList struct {
+	ID            int64                       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID        int                         `gorm:"not null;uniqueIndex:idx_user_contact_list" json:"user_id"`
+	SessionID     string                      `gorm:"type:char(36);not null;index" json:"session_id"` // session used for the WhatsApp checks
+	Name          string                      `gorm:"size:255;not null;uniqueIndex:idx_user_contact_list" json:"name"`
+	Fields        string                      `gorm:"size:1000" json:"fields"` // custom CSV columns, comma separated
+	Total         int                         `json:"total"`
+	Valid         int                         `json:"valid"`
+	Invalid       int                         `json:"invalid"`
+	NotOnWhatsApp int                         `json:"not_on_whatsapp"`
+	Members       []WhatsAppContactListMember `gorm:"foreignKey:ListID;constraint:OnDelete:CASCADE" json:"members,omitempty"`
+	CreatedAt     time.Time                   `json:"created_at"`
+	UpdatedAt     time.Time                   `json:"updated_at"`
+}
+
+// ContactListMemberStatus is the outcome of validating an imported row
+type ContactListMemberStatus string
+
+const (
+	ContactListMemberValid         ContactListMemberStatus = "valid"
+	ContactListMemberInvalid       ContactListMemberStatus = "invalid"
+	ContactListMemberNotOnWhatsApp ContactListMemberStatus = "not_on_whatsapp"
+)
+
+// WhatsAppContactListMember is one imported CSV row
+type WhatsAppContactListMember struct {
+	ID     int64                   `gorm:"primaryKey;autoIncrement" json:"id"`
+	ListID int64                   `gorm:"not null;index" json:"list_id"`
+	Line   int                     `json:"line"` // CSV line number
+	Input  string                  `gorm:"size:50" json:"input"`
+	Phone  string                  `gorm:"size:20;index" json:"phone_number,omitempty"`
+	JID    string                  `gorm:"column:jid;size:255" json:"jid,omitempty"`
+	Name   string                  `gorm:"size:255" json:"name,omitempty"`
+	Fields JSONData                `gorm:"type:json" json:"fields,omitempty"`
+	Status ContactListMemberStatus `gorm:"size:20;not null;index" json:"status"`
+	Error  string                  `gorm:"size:255" json:"error,omitempty"`
+}
+
+// CampaignStatus is the lifecycle state of a campaign
+type CampaignStatus string
+
+const (
+	CampaignRunning   CampaignStatus = "running"
+	CampaignCompleted CampaignStatus = "completed"
+	CampaignCancelled CampaignStatus = "cancelled"
+	CampaignFailed    CampaignStatus = "failed"
+)
+
+// CampaignReceiptMode is how the receipts of a campaign's messages are reported
+type CampaignReceiptMode string
+
+const (
+	CampaignReceiptsPerMessage CampaignReceiptMode = "message"   // a receipt event per message
+	CampaignReceiptsAggregate  CampaignReceiptMode = "aggregate" // campaign_receipts events at thresholds
+)
+
+// WhatsAppCampaign is a bulk send delivered in the background by the campaign worker
+type WhatsAppCampaign struct {
+	ID            int64          `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID        int            `gorm:"not null;index" json:"user_id"`
+	SessionID     string         `gorm:"type:char(36);not null;index" json:"session_id"`
+	Name          string         `gorm:"size:255;not null" json:"name"`
+	Message       string         `gorm:"type:text" json:"message"` // text or caption, may use spintax and {{variables}}
+	MediaID       string         `gorm:"size:36" json:"media_id,omitempty"`
+	ContactListID *int64         `gorm:"index" json:"contact_list_id,omitempty"`
+	SegmentID     *int64         `gorm:"index" json:"segment_id,omitempty"`
+	Status        CampaignStatus `gorm:"size:20;not null;index" json:"status"`
+	Total         int            `json:"total"`
+	Sent          int            `json:"sent"`
+	Failed        int            `json:"failed"`
+	Suppressed    int            `json:"suppressed"`
+	Delivered     int            `json:"delivered"`
+	Read          int            `gorm:"column:read_count" json:"read"`
+	// Receipt reporting; thresholds are percentages of the reachable
+	// recipients, the milestones the highest ones reported so far
+	ReceiptMode        CampaignReceiptMode `gorm:"size:20;not null;default:'message'" json:"receipt_mode"`
+	ReceiptThresholds  string              `gorm:"size:100" json:"receipt_thresholds,omitempty"` // e.g. "25,50,100"
+	DeliveredMilestone int                 `json:"delivered_milestone"`
+	ReadMilestone      int                 `json:"read_milestone"`
+	NextRunAt          time.Time           `gorm:"index" json:"next_run_at"`
+	LastError          string              `gorm:"size:500" json:"last_error,omitempty"`
+	CompletedAt        *time.Time          `json:"completed_at,omitempty"`
+	CreatedAt          time.Time           `json:"created_at"`
+	UpdatedAt          time.Time           `json:"updated_at"`
+}
+
+// CampaignRecipientStatus is the delivery state of one campaign recipient
+type CampaignRecipientStatus string
+
+const (
+	CampaignRecipientPending    CampaignRecipientStatus = "pending"
+	CampaignRecipientSent       CampaignRecipientStatus = "sent"
+	CampaignRecipientFailed     CampaignRecipientStatus = "failed"
+	CampaignRecipientSuppressed CampaignRecipientStatus = "suppressed"
+)
+
+// WhatsAppCampaignRecipient is one recipient of a campaign
+type WhatsAppCampaignRecipient struct {
+	ID          int64                   `gorm:"primaryKey;autoIncrement" json:"id"`
+	CampaignID  int64                   `gorm:"not null;index:idx_campaign_status" json:"campaign_id"`
+	To          string                  `gorm:"column:recipient;size:255;not null" json:"to"` // JID or phone number
+	Name        string                  `gorm:"size:255" json:"name,omitempty"`
+	Fields      JSONData                `gorm:"type:json" json:"fields,omitempty"`
+	Status      CampaignRecipientStatus `gorm:"size:20;not null;index:idx_campaign_status" json:"status"`
+	MessageID   string                  `gorm:"size:255;index:idx_campaign_recipients_message" json:"message_id,omitempty"`
+	Error       string                  `gorm:"size:500" json:"error,omitempty"`
+	Permanent   bool                    `gorm:"not null;default:false" json:"permanent,omitempty"` // failure a retry can't fix
+	Attempts    int                     `gorm:"not null;default:0" json:"attempts"`
+	SentAt      *time.Time              `json:"sent_at,omitempty"`
+	DeliveredAt *time.Time              `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time              `json:"read_at,omitempty"`
+	UpdatedAt   time.Time               `json:"updated_at"`
+}
+
+// StatusPostState is the lifecycle state of a status post
+type StatusPostState string
+
+const (
+	StatusPostScheduled StatusPostState = "scheduled"
+	StatusPostPosting   StatusPostState = "posting"
+	StatusPostPosted    StatusPostState = "posted"
+	StatusPostFailed    StatusPostState = "failed"
+	StatusPostCancelled StatusPostState = "cancelled"
+	StatusPostExpired   StatusPostState = "expired"
+)
+
+// WhatsAppStatusPost is a status (story) posted or scheduled by the status worker
+type WhatsAppStatusPost struct {
+	ID          int64           `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID      int             `gorm:"not null;index" json:"user_id"`
+	SessionID   string          `gorm:"type:char(36);not null;index" json:"session_id"`
+	Text        string          `gorm:"type:text" json:"text,omitempty"` // text status, or caption of media
+	MediaType   string          `gorm:"size:20" json:"media_type,omitempty"`
+	Mimetype    string          `gorm:"size:100" json:"mimetype,omitempty"`
+	MediaKey    string          `gorm:"size:512;index" json:"-"` // media storage key, shared by the posts of a series
+	Status      StatusPostState `gorm:"size:20;not null;index:idx_status_post_due" json:"status"`
+	ScheduledAt time.Time       `gorm:"not null;index:idx_status_post_due" json:"scheduled_at"`
+	Repeat      string          `gorm:"size:10" json:"repeat,omitempty"` // daily, weekly
+	RepeatUntil *time.Time      `json:"repeat_until,omitempty"`
+	SeriesID    *int64          `gorm:"index" json:"series_id,omitempty"` // first post of a recurring series
+	MessageID   string          `gorm:"size:255" json:"message_id,omitempty"`
+	PostedAt    *time.Time      `json:"posted_at,omitempty"`
+	ExpiresAt   *time.Time      `gorm:"index" json:"expires_at,omitempty"` // 24h after posting
+	Error       string          `gorm:"size:500" json:"error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// CallStatus is the state of a call received by a session
+type CallStatus string
+
+const (
+	CallRinging  CallStatus = "ringing"
+	CallAccepted CallStatus = "accepted" // answered on another device
+	CallRejected CallStatus = "rejected"
+	CallMissed   CallStatus = "missed"
+	CallEnded    CallStatus = "ended"
+)
+
+// WhatsAppCall is a call offered to a session
+type WhatsAppCall struct {
+	ID           int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID       int        `gorm:"not null;index" json:"user_id"`
+	SessionID    string     `gorm:"type:char(36);not null;index:idx_session_call,unique" json:"session_id"`
+	CallID       string     `gorm:"size:64;not null;index:idx_session_call,unique" json:"call_id"`
+	From         string     `gorm:"column:from_jid;size:255;not null;index" json:"from"` // caller
+	GroupJID     string     `gorm:"column:group_jid;size:255" json:"group_jid,omitempty"`
+	Media        string     `gorm:"size:10" json:"media"` // audio or video
+	Status       CallStatus `gorm:"size:20;not null;index" json:"status"`
+	AutoRejected bool       `gorm:"default:false" json:"auto_rejected"`
+	Reason       string     `gorm:"size:100" json:"reason,omitempty"` // terminate reason reported by WhatsApp
+	OfferedAt    time.Time  `gorm:"index" json:"offered_at"`
+	EndedAt      *time.Time `json:"ended_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// WhatsAppContactTag labels a contact of a user
+type WhatsAppContactTag struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID     int       `gorm:"not null;uniqueIndex:idx_user_contact_tag" json:"user_id"`
+	ContactJID string    `gorm:"column:contact_jid;size:255;not null;uniqueIndex:idx_user_contact_tag" json:"contact_jid"`
+	Tag        string    `gorm:"size:50;not null;uniqueIndex:idx_user_contact_tag;index" json:"tag"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// WhatsAppNote is an internal note on a contact (per user) or on a chat (per
+// session). Notes stay on this server and are never sent to WhatsApp.
+type WhatsAppNote struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID     int       `gorm:"not null;index:idx_note_target" json:"user_id"`
+	SessionID  string    `gorm:"type:char(36);not null;default:'';index:idx_note_target" json:"session_id,omitempty"` // chat notes only
+	TargetType string    `gorm:"size:10;not null;index:idx_note_target" json:"target_type"`                           // contact or chat
+	TargetJID  string    `gorm:"column:target_jid;size:255;not null;index:idx_note_target" json:"target_jid"`
+	Author     string    `gorm:"size:255" json:"author"`
+	Text       string    `gorm:"type:text;not null" json:"text"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// WhatsAppSegment is a saved filter over the contacts table
+type WhatsAppSegment struct {
+	ID          int64         `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID      int           `gorm:"not null;uniqueIndex:idx_user_segment" json:"user_id"`
+	Name        string        `gorm:"size:255;not null;uniqueIndex:idx_user_segment" json:"name"`
+	Description string        `gorm:"size:500" json:"description,omitempty"`
+	Filter      SegmentFilter `gorm:"type:json;serializer:json" json:"filter"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// AutoReplyMatchType selects how an auto-reply keyword is compared
+type AutoReplyMatchType string
+
+const (
+	AutoReplyMatchExact    AutoReplyMatchType = "exact"
+	AutoReplyMatchContains AutoReplyMatchType = "contains"
+	AutoReplyMatchRegex    AutoReplyMatchType = "regex"
+)
+
+// WhatsAppAutoReplyRule answers and/or tags incoming messages matching a keyword
+type WhatsAppAutoReplyRule struct {
+	ID         int64              `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID     int                `gorm:"not null;index" json:"user_id"`
+	SessionID  string             `gorm:"type:char(36);index" json:"session_id,omitempty"` // empty = all sessions of the user
+	Name       string             `gorm:"size:255" json:"name"`
+	Keyword    string             `gorm:"size:255;not null" json:"keyword"`
+	MatchType  AutoReplyMatchType `gorm:"size:20;not null" json:"match_type"`
+	Reply      string             `gorm:"type:text" json:"reply,omitempty"`
+	Tag        string             `gorm:"size:50" json:"tag,omitempty"`
+	SequenceID *int64             `gorm:"index" json:"sequence_id,omitempty"` // enrolls the sender in this sequence
+	Enabled    bool               `gorm:"not null" json:"enabled"`
+	CreatedAt  time.Time          `json:"created_at"`
+	UpdatedAt  time.Time          `json:"updated_at"`
+}
+
+// WhatsAppCannedResponse is a saved reply of a user, picked by its shortcut
+// code and sent with canned_response_id
+type WhatsAppCannedResponse struct {
+	ID         int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID     int        `gorm:"not null;uniqueIndex:idx_user_shortcut" json:"user_id"`
+	Shortcut   string     `gorm:"size:50;not null;uniqueIndex:idx_user_shortcut" json:"shortcut"`
+	Title      string     `gorm:"size:255" json:"title,omitempty"`
+	Text       string     `gorm:"type:text;not null" json:"text"`
+	UseCount   int64      `gorm:"not null;default:0" json:"use_count"` // messages sent or queued with it
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// SequenceStep is one message of a sequence, sent Delay seconds after the
+// enrollment (first step) or the previous step
+type SequenceStep struct {
+	Message      string `json:"message"` // template, rendered per contact
+	DelaySeconds int64  `json:"delay_seconds"`
+}
+
+// WhatsAppSequence is a drip sequence: ordered follow-up messages a session
+// sends to the contacts enrolled in it
+type WhatsAppSequence struct {
+	ID        int64          `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    int            `gorm:"not null;uniqueIndex:idx_user_sequence" json:"user_id"`
+	SessionID string         `gorm:"type:char(36);not null;index" json:"session_id"` // sends the steps
+	Name      string         `gorm:"size:100;not null;uniqueIndex:idx_user_sequence" json:"name"`
+	Steps     []SequenceStep `gorm:"type:json;serializer:json" json:"steps"`
+	Enabled   bool           `gorm:"not null" json:"enabled"` // disabled sequences send nothing, enrollments wait
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// EnrollmentStatus is the state of a contact's enrollment in a sequence
+type EnrollmentStatus string
+
+const (
+	EnrollmentActive    EnrollmentStatus = "active"
+	EnrollmentCompleted EnrollmentStatus = "completed"
+	EnrollmentCancelled EnrollmentStatus = "cancelled"
+	EnrollmentFailed    EnrollmentStatus = "failed"
+)
+
+// WhatsAppSequenceEnrollment is the progress of a contact through a sequence
+type WhatsAppSequenceEnrollment struct {
+	ID           int64            `gorm:"primaryKey;autoIncrement" json:"id"`
+	SequenceID   int64            `gorm:"not null;uniqueIndex:idx_sequence_contact" json:"sequence_id"`
+	UserID       int              `gorm:"not null;index" json:"user_id"`
+	SessionID    string           `gorm:"type:char(36);not null;index:idx_enrollment_session_contact" json:"session_id"`
+	ContactJID   string           `gorm:"column:contact_jid;size:255;not null;uniqueIndex:idx_sequence_contact;index:idx_enrollment_session_contact" json:"contact_jid"`
+	Status       EnrollmentStatus `gorm:"size:20;not null;index:idx_enrollment_due" json:"status"`
+	Source       string           `gorm:"size:20" json:"source"`               // manual or auto_reply
+	NextStep     int              `gorm:"not null;default:0" json:"next_step"` // index of the step to send next
+	NextRunAt    *time.Time       `gorm:"index:idx_enrollment_due" json:"next_run_at,omitempty"`
+	CancelReason string           `gorm:"size:20" json:"cancel_reason,omitempty"` // replied, unenrolled, suppressed
+	LastError    string           `gorm:"size:500" json:"last_error,omitempty"`
+	EnrolledAt   time.Time        `json:"enrolled_at"`
+	EndedAt      *time.Time       `json:"ended_at,omitempty"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+}
+
+// WhatsAppScoringRule adds points to the score of contacts whose incoming
+// messages match a keyword
+type WhatsAppScoringRule struct {
+	ID        int64              `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    int                `gorm:"not null;index" json:"user_id"`
+	SessionID string             `gorm:"type:char(36);index" json:"session_id,omitempty"` // empty = all sessions of the user
+	Name      string             `gorm:"size:255" json:"name"`
+	Keyword   string             `gorm:"size:255;not null" json:"keyword"`
+	MatchType AutoReplyMatchType `gorm:"size:20;not null" json:"match_type"`
+	Points    int                `gorm:"not null" json:"points"` // negative lowers the score
+	Enabled   bool               `gorm:"not null" json:"enabled"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+// WhatsAppContactScore is the lead score of a contact of a user, keyed by
+// the contact's JID like tags
+type WhatsAppContactScore struct {
+	ID           int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID       int        `gorm:"not null;uniqueIndex:idx_user_score_jid" json:"user_id"`
+	JID          string     `gorm:"column:jid;size:255;not null;uniqueIndex:idx_user_score_jid" json:"jid"`
+	Score        int        `gorm:"not null;default:0;index" json:"score"`
+	HotLeadAt    *time.Time `json:"hot_lead_at,omitempty"` // when the score crossed the hot lead threshold
+	LastScoredAt time.Time  `json:"last_scored_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// WhatsAppLeadSettings are the hot lead threshold and webhook of a user
+type WhatsAppLeadSettings struct {
+	UserID        int       `gorm:"primaryKey;autoIncrement:false" json:"user_id"`
+	Threshold     int       `gorm:"not null;default:0" json:"threshold"` // 0 = no hot leads
+	WebhookURL    string    `gorm:"size:2048" json:"webhook_url"`
+	WebhookSecret string    `gorm:"size:255" json:"-"` // signs webhook bodies
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// ExportStatus is the state of a chat export job
+type ExportStatus string
+
+const (
+	ExportPending   ExportStatus = "pending"
+	ExportRunning   ExportStatus = "running"
+	ExportCompleted ExportStatus = "completed"
+	ExportFailed    ExportStatus = "failed"
+)
+
+// WhatsAppChatExport is a file export of a stored conversation, generated in
+// the background
+type WhatsAppChatExport struct {
+	ID          int64        `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID      int          `gorm:"not null;index" json:"user_id"`
+	SessionID   string       `gorm:"type:char(36);not null;index" json:"session_id"`
+	ChatJID     string       `gorm:"column:chat_jid;size:255;not null" json:"chat_jid"`
+	Format      string       `gorm:"size:10;not null" json:"format"` // json or csv
+	Status      ExportStatus `gorm:"size:20;not null;index" json:"status"`
+	Messages    int          `json:"messages"`
+	FilePath    string       `gorm:"type:text" json:"-"`
+	FileSize    int64        `json:"file_size,omitempty"`
+	Error       string       `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	CompletedAt *time.Time   `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time   `gorm:"index" json:"expires_at,omitempty"` // file is deleted afterwards
+}
+
+// GroupSyncStatus is the state of a group sync job
+type GroupSyncStatus string
+
+const (
+	GroupSyncPending   GroupSyncStatus = "pending" // queued, waiting out a rate limit or interrupted
+	GroupSyncRunning   GroupSyncStatus = "running"
+	GroupSyncCompleted GroupSyncStatus = "completed"
+	GroupSyncFailed    GroupSyncStatus = "failed"
+)
+
+// WhatsAppGroupSyncJob is a sync of a session's groups. Per-group progress is
+// the synced_at of each group, so an interrupted job resumes where it stopped.
+type WhatsAppGroupSyncJob struct {
+	ID          int64           `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID      int             `gorm:"not null;index" json:"user_id"`
+	SessionID   string          `gorm:"type:char(36);not null;index" json:"session_id"`
+	Status      GroupSyncStatus `gorm:"size:20;not null;index" json:"status"`
+	Trigger     string          `gorm:"size:20;not null" json:"trigger"` // connect or api
+	Force       bool            `gorm:"not null" json:"force"`           // re-fetch recently synced groups too
+	TotalGroups int             `json:"total_groups"`
+	Synced      int             `json:"synced"`
+	Skipped     int             `json:"skipped"` // synced recently, not fetched again
+	Failed      int             `json:"failed"`
+	RateLimited int             `json:"rate_limited"` // times the job waited for a WhatsApp rate limit
+	RetryAt     *time.Time      `json:"retry_at,omitempty"`
+	Error       string          `gorm:"type:text" json:"error,omitempty"`
+	StartedAt   *time.Time      `json:"started_at,omitempty"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// WhatsAppAuditLog records one mutating API call
+type WhatsAppAuditLog struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID     int       `gorm:"not null;index:idx_audit_user_created" json:"user_id"`
+	SessionID  string    `gorm:"type:char(36);index" json:"session_id,omitempty"`
+	Method     string    `gorm:"size:10;not null" json:"method"`
+	Endpoint   string    `gorm:"size:255;not null;index" json:"endpoint"` // route template, e.g. /api/v1/sessions/:session_id
+	Path       string    `gorm:"type:text" json:"path"`
+	StatusCode int       `gorm:"index" json:"status_code"`
+	Request    JSONData  `gorm:"type:json" json:"request,omitempty"` // route params, query and a redacted body summary
+	IPAddress  string    `gorm:"size:45" json:"ip_address"`
+	UserAgent  string    `gorm:"size:512" json:"user_agent"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `gorm:"index:idx_audit_user_created" json:"created_at"`
+}
+
+// WhatsAppUserQuota overrides MAX_DEVICES_PER_USER for one user; set with
+// `whatsapp-api user quota set`
+type WhatsAppUserQuota struct {
+	UserID     int       `gorm:"primaryKey;autoIncrement:false" json:"user_id"`
+	MaxDevices int       `gorm:"not null" json:"max_devices"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// SendIntentStatus is the state of a send intent
+type SendIntentStatus string
+
+const (
+	SendIntentPending     SendIntentStatus = "pending"     // being handed to WhatsApp
+	SendIntentInterrupted SendIntentStatus = "interrupted" // the server stopped mid-send; delivery unknown
+)
+
+// WhatsAppSendIntent is an outgoing message recorded before it is handed to
+// WhatsApp. It is deleted once the send is settled, so only sends in flight
+// or interrupted by a crash remain.
+type WhatsAppSendIntent struct {
+	ID          int64            `gorm:"primaryKey;autoIncrement" json:"id"`
+	SessionID   string           `gorm:"type:char(36);not null;index" json:"session_id"`
+	UserID      int              `gorm:"not null" json:"user_id"`
+	ChatJID     string           `gorm:"column:chat_jid;size:255;not null" json:"chat_jid"`
+	MessageID   string           `gorm:"size:128;not null;uniqueIndex" json:"message_id"` // generated before sending
+	MessageType string           `gorm:"size:50" json:"message_type"`
+	Status      SendIntentStatus `gorm:"size:20;not null;index" json:"status"`
+	Instance    string           `gorm:"size:100;index" json:"instance"` // JOBS_INSTANCE of the sending instance
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+// WhatsAppGroupDailyStat counts the messages one member sent to a group on
+// one day; rows are filled in by the group analytics job
+type WhatsAppGroupDailyStat struct {
+	ID            int64     `gorm:"primaryKey;autoIncrement" json:"-"`
+	SessionID     string    `gorm:"type:char(36);not null;uniqueIndex:idx_group_day_sender" json:"session_id"`
+	UserID        int       `gorm:"not null" json:"user_id"`
+	ChatJID       string    `gorm:"column:chat_jid;size:255;not null;uniqueIndex:idx_group_day_sender" json:"chat_jid"`
+	Day           time.Time `gorm:"type:date;not null;uniqueIndex:idx_group_day_sender" json:"day"`
+	SenderJID     string    `gorm:"column:sender_jid;size:255;not null;uniqueIndex:idx_group_day_sender" json:"sender_jid"`
+	Messages      int       `gorm:"not null" json:"messages"`
+	LastMessageAt time.Time `json:"last_message_at"`
+}
+
+// WhatsAppAggregationCursor is how far a background aggregation has read
+// its source table
+type WhatsAppAggregationCursor struct {
+	Name      string    `gorm:"primaryKey;size:50" json:"name"`
+	Position  int64     `gorm:"not null" json:"position"` // last aggregated row ID
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WhatsAppUsageCounter counts the messages a user sent in one billing
+// period (calendar month, UTC)
+type WhatsAppUsageCounter struct {
+	UserID    int       `gorm:"primaryKey;autoIncrement:false" json:"user_id"`
+	Period    string    `gorm:"primaryKey;size:7" json:"period"` // YYYY-MM
+	Messages  int       `gorm:"not null" json:"messages"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WhatsAppUsageQuota overrides USAGE_SOFT_LIMIT and USAGE_HARD_LIMIT for one
+// user; set with `whatsapp-api user usage set`
+type WhatsAppUsageQuota struct {
+	UserID    int       `gorm:"primaryKey;autoIncrement:false" json:"user_id"`
+	SoftLimit int       `gorm:"not null" json:"soft_limit"` // 0 = none
+	HardLimit int       `gorm:"not null" json:"hard_limit"` // 0 = none
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// QR pairing attempt outcomes
+const (
+	QRAttemptPending    = "pending"    // codes still being shown
+	QRAttemptPaired     = "paired"     // a code was scanned
+	QRAttemptExpired    = "expired"    // every code ran out unscanned
+	QRAttemptSuperseded = "superseded" // WhatsApp started another attempt
+	QRAttemptCancelled  = "cancelled"  // the session expired or was removed
+)
+
+// WhatsAppQRAttempt is one pairing attempt of a session: a batch of QR codes
+// sent by WhatsApp
+type WhatsAppQRAttempt struct {
+	ID        int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	SessionID string     `gorm:"type:char(36);not null;index" json:"session_id"`
+	UserID    int        `gorm:"not null" json:"user_id"`
+	Attempt   int        `gorm:"not null" json:"attempt"` // 1-based, per client lifetime
+	Codes     int        `gorm:"not null" json:"codes"`
+	Outcome   string     `gorm:"size:20;not null" json:"outcome"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// JSONData type for JSON fields
 type JSONData map[string]interface{}
 
 func (j JSONData) Value() (driver.Value, error) {
@@ -140,6 +1025,32 @@ func (j *JSONData) Scan(value interface{}) error {
 	return json.Unmarshal(data, j)
 }
 
+func (r MessageReactions) Value() (driver.Value, error) {
+	if len(r) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(r)
+}
+
+func (r *MessageReactions) Scan(value interface{}) error {
+	if value == nil {
+		*r = nil
+		return nil
+	}
+
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("unsupported type for MessageReactions")
+	}
+
+	return json.Unmarshal(data, r)
+}
+
 func (s *SessionStatus) Scan(value interface{}) error {
 	if value == nil {
 		*s = ""
@@ -164,66 +1075,102 @@ type DatabaseManager struct {
 	db          *gorm.DB
 	sqlDB       *sqlstore.Container
 	waContainer *sqlstore.Container
+	writer      *BatchWriter // buffers event and message inserts once started
+	replica     *readReplica // serves listings and statistics when configured
 }
 
 func (db *DatabaseManager) GetWhatsAppContainer() *sqlstore.Container {
 	return db.waContainer
 }
 
-func NewDatabaseManager(cfg *Config) (*DatabaseManager, error) {
-	// ========================================
-	// Part 1: MySQL for Application Data
-	// ========================================
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
+// openAppDatabase connects to the application database
+func openAppDatabase(cfg *Config) (*gorm.DB, error) {
+	dialector, err := openAppDialector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dbName := dialectName(cfg.DBDriver)
 
-	log.Printf("📊 Connecting to MySQL database...")
+	log.Printf("📊 Connecting to %s database...", dbName)
 	log.Printf("   Host: %s:%s", cfg.DBHost, cfg.DBPort)
 	log.Printf("   Database: %s", cfg.DBName)
 
 	// GORM connection for application data
-	gormDB, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Silent),
+	gormDB, err := gorm.Open(dialector, appGormConfig(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", dbName, err)
+	}
+	if err := configurePool(cfg, gormDB); err != nil {
+		return nil, err
+	}
+
+	log.Printf("   ✅ %s connected successfully", dbName)
+	return gormDB, nil
+}
+
+// appGormConfig is the GORM configuration of application database connections
+func appGormConfig(cfg *Config) *gorm.Config {
+	return &gorm.Config{
+		Logger: slowQueryLogger{
+			Interface: logger.Default.LogMode(logger.Silent),
+			threshold: cfg.DBSlowQueryThreshold,
+		},
 		NowFunc: func() time.Time {
 			return time.Now().UTC()
 		},
-		PrepareStmt: true,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to MySQL: %w", err)
+		PrepareStmt: cfg.DBPrepareStmt,
 	}
+}
 
-	// Configure connection pool
+// configurePool applies the DB_* pool settings to a connection
+func configurePool(cfg *Config, gormDB *gorm.DB) error {
 	sqlDB, err := gormDB.DB()
 	if err != nil {
-		return nil, err
+		return err
 	}
+	sqlDB.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	sqlDB.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	sqlDB.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
+	return nil
+}
+
+// slowQueryLogger logs the queries slower than threshold and nothing else
+type slowQueryLogger struct {
+	logger.Interface
+	threshold time.Duration
+}
+
+func (l slowQueryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return slowQueryLogger{Interface: l.Interface.LogMode(level), threshold: l.threshold}
+}
 
-	sqlDB.SetMaxIdleConns(50)
-	sqlDB.SetMaxOpenConns(200)
-	sqlDB.SetConnMaxLifetime(time.Hour)
+func (l slowQueryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.threshold <= 0 {
+		return
+	}
+	if elapsed := time.Since(begin); elapsed >= l.threshold {
+		sql, rows := fc()
+		log.Printf("🐢 Slow query (%s, %d rows): %s", elapsed.Round(time.Millisecond), rows, sql)
+	}
+}
 
-	log.Println("   ✅ MySQL connected successfully")
+func NewDatabaseManager(cfg *Config) (*DatabaseManager, error) {
+	// ========================================
+	// Part 1: MySQL or Postgres for Application Data
+	// ========================================
+	gormDB, err := openAppDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	// ========================================
 	// Part 2: SQLite for WhatsApp Store
 	// ========================================
 	log.Println("📱 Setting up WhatsApp store (SQLite)...")
 
-	// Create data directory if it doesn't exist
-	dataDir := "./data"
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create data directory: %w", err)
-	}
-
-	// SQLite database path
-	sqlitePath := filepath.Join(dataDir, "whatsapp_store.db")
-	log.Printf("   Store location: %s", sqlitePath)
-
-	// Create WhatsApp store container with SQLite
-	container, err := sqlstore.New(context.Background(), "sqlite", sqlitePath+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)", nil)
+	container, err := openWhatsAppStore(context.Background(), cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create WhatsApp store: %w", err)
+		return nil, err
 	}
 
 	log.Println("   ✅ WhatsApp store initialized")
@@ -234,110 +1181,31 @@ func NewDatabaseManager(cfg *Config) (*DatabaseManager, error) {
 		waContainer: container,
 	}
 
-	// Run migrations
-	log.Println("🔧 Running database migrations...")
-	if err := dm.Migrate(); err != nil {
+	if err := dm.migrateOnStartup(cfg); err != nil {
 		return nil, err
 	}
+	dm.UpdateDeviceLimitTrigger(cfg.MaxDevicesPerUser)
 
-	return dm, nil
-}
-
-// Migrate creates all necessary tables and constraints
-// Replace the existing Migrate() function with this updated version:
-func (dm *DatabaseManager) Migrate() error {
-	// Auto migrate models - ADD WhatsAppGroup to the list
-	if err := dm.db.AutoMigrate(&WhatsAppSession{}, &WhatsAppEvent{}, &WhatsAppContact{}, &WhatsAppGroup{}); err != nil {
-		return err
+	if cfg.DBReplicaEnabled {
+		if err := dm.openReplica(cfg); err != nil {
+			return nil, err
+		}
 	}
 
-	// Add new columns to existing tables
-	// Check if is_business_account exists, if not add it
-	if !dm.db.Migrator().HasColumn(&WhatsAppSession{}, "is_business_account") {
-		if err := dm.db.Migrator().AddColumn(&WhatsAppSession{}, "is_business_account"); err != nil {
-			log.Printf("Warning: Failed to add is_business_account column: %v", err)
-		}
-	}
-
-	// Check if group_id exists in contacts, if not add it
-	if !dm.db.Migrator().HasColumn(&WhatsAppContact{}, "group_id") {
-		if err := dm.db.Migrator().AddColumn(&WhatsAppContact{}, "group_id"); err != nil {
-			log.Printf("Warning: Failed to add group_id column: %v", err)
-		}
-	}
-
-	// Check if is_group_member exists, if not add it
-	if !dm.db.Migrator().HasColumn(&WhatsAppContact{}, "is_group_member") {
-		if err := dm.db.Migrator().AddColumn(&WhatsAppContact{}, "is_group_member"); err != nil {
-			log.Printf("Warning: Failed to add is_group_member column: %v", err)
-		}
-	}
-
-	// Create stored procedure for device limit check
-	dm.db.Exec(`DROP PROCEDURE IF EXISTS check_device_limit;`)
-
-	dm.db.Exec(`
-		CREATE PROCEDURE check_device_limit(IN p_user_id INT, IN p_session_id CHAR(36))
-		BEGIN
-			DECLARE active_count INT;
-			DECLARE max_allowed INT DEFAULT 5;
-			
-			SELECT COUNT(*) INTO active_count
-			FROM whats_app_sessions
-			WHERE user_id = p_user_id
-				AND is_active = true
-				AND status IN ('connected', 'pending', 'qr_ready', 'scanning')
-				AND id != p_session_id
-				AND deleted_at IS NULL;
-			
-			IF active_count >= max_allowed THEN
-				SIGNAL SQLSTATE '45000' 
-				SET MESSAGE_TEXT = 'Device limit exceeded. Maximum 5 devices allowed per user.';
-			END IF;
-		END;
-	`)
-
-	// Create trigger for INSERT
-	dm.db.Exec(`DROP TRIGGER IF EXISTS enforce_device_limit_insert;`)
-
-	dm.db.Exec(`
-		CREATE TRIGGER enforce_device_limit_insert
-		BEFORE INSERT ON whats_app_sessions
-		FOR EACH ROW
-		BEGIN
-			IF NEW.status IN ('pending', 'qr_ready', 'scanning', 'connected') AND NEW.is_active = true THEN
-				CALL check_device_limit(NEW.user_id, NEW.id);
-			END IF;
-		END;
-	`)
-
-	// Create trigger for UPDATE
-	dm.db.Exec(`DROP TRIGGER IF EXISTS enforce_device_limit_update;`)
-
-	dm.db.Exec(`
-		CREATE TRIGGER enforce_device_limit_update
-		BEFORE UPDATE ON whats_app_sessions
-		FOR EACH ROW
-		BEGIN
-			IF NEW.status IN ('pending', 'qr_ready', 'scanning', 'connected') AND NEW.is_active = true THEN
-				CALL check_device_limit(NEW.user_id, NEW.id);
-			END IF;
-		END;
-	`)
-
-	// Create indexes
-	dm.db.Exec("CREATE INDEX IF NOT EXISTS idx_sessions_user_status ON whats_app_sessions(user_id, status)")
-	dm.db.Exec("CREATE INDEX IF NOT EXISTS idx_events_session_created ON whats_app_events(session_id, created_at DESC)")
-	dm.db.Exec("CREATE INDEX IF NOT EXISTS idx_groups_session ON whats_app_groups(session_id)")
-	dm.db.Exec("CREATE INDEX IF NOT EXISTS idx_contacts_group ON whats_app_contacts(group_id)")
-
-	log.Println("   ✅ Migrations completed")
-	return nil
+	return dm, nil
 }
 
 // ============= SESSION REPOSITORY =============
 
 func (dm *DatabaseManager) CreateSession(userID int, sessionName string) (*WhatsAppSession, error) {
+	// Deleted sessions keep their row, which would still hold the name in
+	// idx_user_session
+	if err := dm.db.Unscoped().
+		Where("user_id = ? AND session_name = ? AND deleted_at IS NOT NULL", userID, sessionName).
+		Delete(&WhatsAppSession{}).Error; err != nil {
+		return nil, err
+	}
+
 	sessionID := uuid.New()
 	session := &WhatsAppSession{
 		ID:          sessionID.String(),
@@ -363,16 +1231,86 @@ func (dm *DatabaseManager) GetSession(sessionID uuid.UUID, userID int) (*WhatsAp
 	return &session, nil
 }
 
-func (dm *DatabaseManager) GetUserSessions(userID int) ([]WhatsAppSession, error) {
-	var sessions []WhatsAppSession
-	err := dm.db.Where("user_id = ? AND deleted_at IS NULL", userID).
-		Order("created_at DESC").
-		Find(&sessions).Error
-	return sessions, err
-}
-
-func (dm *DatabaseManager) UpdateSession(session *WhatsAppSession) error {
-	return dm.db.Save(session).Error
+// GetSessionByName returns a session of a user by its name
+func (dm *DatabaseManager) GetSessionByName(userID int, sessionName string) (*WhatsAppSession, error) {
+	var session WhatsAppSession
+	err := dm.db.Where("user_id = ? AND session_name = ?", userID, sessionName).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetSessionOwner returns the user a session belongs to
+func (dm *DatabaseManager) GetSessionOwner(sessionID string) (int, error) {
+	var session WhatsAppSession
+	err := dm.db.Select("user_id").Where("id = ?", sessionID).First(&session).Error
+	return session.UserID, err
+}
+
+// GetAllSessions returns the sessions of all users
+func (dm *DatabaseManager) GetAllSessions() ([]WhatsAppSession, error) {
+	var sessions []WhatsAppSession
+	err := dm.db.Where("deleted_at IS NULL").
+		Order("user_id, created_at").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// GetPairedSessions returns the sessions that have a device JID
+func (dm *DatabaseManager) GetPairedSessions() ([]WhatsAppSession, error) {
+	var sessions []WhatsAppSession
+	err := dm.db.Where("deleted_at IS NULL AND j_id IS NOT NULL AND j_id <> ''").
+		Order("user_id, created_at").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// GetSessionsByJIDs returns the sessions paired as the given devices
+func (dm *DatabaseManager) GetSessionsByJIDs(jids []string) ([]WhatsAppSession, error) {
+	var sessions []WhatsAppSession
+	if len(jids) == 0 {
+		return sessions, nil
+	}
+	err := dm.db.Where("j_id IN ? AND deleted_at IS NULL", jids).
+		Order("user_id, created_at").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (dm *DatabaseManager) GetUserSessions(userID int) ([]WhatsAppSession, error) {
+	var sessions []WhatsAppSession
+	err := dm.db.Where("user_id = ? AND deleted_at IS NULL", userID).
+		Order("created_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// ListUserSessions pages through the sessions of a user
+// (filters: status, project_id or "none"; search: name, phone number, push name)
+func (dm *DatabaseManager) ListUserSessions(userID int, q ListQuery) ([]WhatsAppSession, int64, error) {
+	query := dm.db.Model(&WhatsAppSession{}).Where("user_id = ?", userID)
+	if status, ok := q.Filters["status"]; ok {
+		query = query.Where("status = ?", status)
+	}
+	if projectID, ok := q.Filters["project_id"]; ok {
+		if projectID == "none" {
+			query = query.Where("project_id IS NULL")
+		} else {
+			query = query.Where("project_id = ?", projectID)
+		}
+	}
+	if q.Search != "" {
+		query = dm.searchWhere(query, q.like(), "session_name", "phone_number", "push_name")
+	}
+
+	var sessions []WhatsAppSession
+	total, err := findPage(query, q, &sessions)
+	return sessions, total, err
+}
+
+func (dm *DatabaseManager) UpdateSession(session *WhatsAppSession) error {
+	return dm.db.Save(session).Error
 }
 
 func (dm *DatabaseManager) UpdateSessionStatus(sessionID uuid.UUID, status SessionStatus) error {
@@ -389,20 +1327,81 @@ func (dm *DatabaseManager) DeleteSession(sessionID uuid.UUID, userID int) error
 		Delete(&WhatsAppSession{}).Error
 }
 
+// LogoutSession deletes a logged-out session with the data synced from its
+// device. The JID and number are cleared first so the soft-deleted row
+// doesn't hold them in their unique index.
+func (dm *DatabaseManager) LogoutSession(sessionID string, userID int) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&WhatsAppSession{}).
+			Where("id = ? AND user_id = ?", sessionID, userID).
+			Updates(map[string]interface{}{
+				"status":          StatusDisconnected,
+				"j_id":            nil,
+				"phone_number":    nil,
+				"qr_code":         nil,
+				"qr_code_base64":  nil,
+				"is_active":       false,
+				"disconnected_at": time.Now(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		var blocked []string
+		if err := tx.Model(&WhatsAppBlockedContact{}).Where("session_id = ?", sessionID).Pluck("jid", &blocked).Error; err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{
+			&WhatsAppChat{},
+			&WhatsAppMessage{},
+			&WhatsAppGroup{},
+			&WhatsAppGroupSchedule{},
+			&WhatsAppAvatar{},
+			&WhatsAppPictureChange{},
+			&WhatsAppBlockedContact{},
+			&WhatsAppMediaHandle{},
+			&WhatsAppStatusPost{},
+			&WhatsAppCall{},
+			&WhatsAppGroupDailyStat{},
+			&WhatsAppGroupInviteLink{},
+			&WhatsAppEventFilter{},
+			&WhatsAppSenderRule{},
+			&WhatsAppNote{},
+			&WhatsAppSequence{},
+			&WhatsAppSequenceEnrollment{},
+		} {
+			if err := tx.Where("session_id = ?", sessionID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("id = ? AND user_id = ?", sessionID, userID).Delete(&WhatsAppSession{}).Error; err != nil {
+			return err
+		}
+		return refreshContactsBlocked(tx, userID, blocked)
+	})
+}
+
 func (dm *DatabaseManager) SetSessionConnected(sessionID uuid.UUID, jid, phoneNumber, pushName, platform string) error {
 	now := time.Now()
 
 	updates := map[string]interface{}{
-		"status":          StatusConnected,
-		"j_id":            jid,
-		"phone_number":    phoneNumber,
-		"push_name":       pushName,
-		"platform":        platform,
-		"connected_at":    now,
-		"last_seen":       now,
-		"disconnected_at": nil,
-		"qr_code":         nil,
-		"qr_code_base64":  nil,
+		"status":             StatusConnected,
+		"j_id":               jid,
+		"phone_number":       phoneNumber,
+		"push_name":          pushName,
+		"platform":           platform,
+		"connected_at":       now,
+		"last_seen":          now,
+		"disconnected_at":    nil,
+		"qr_code":            nil,
+		"qr_code_base64":     nil,
+		"status_reason":      "",
+		"status_reason_code": 0,
+		"banned_until":       nil,
 	}
 
 	result := dm.db.Model(&WhatsAppSession{}).
@@ -435,9 +1434,23 @@ func (dm *DatabaseManager) SetSessionDisconnected(sessionID uuid.UUID) error {
 		}).Error
 }
 
-func (dm *DatabaseManager) UpdateSessionQR(sessionID uuid.UUID, qrCode, base64QR string, timeout time.Duration) error {
+// SetSessionFailure records why WhatsApp banned, refused or unlinked a session
+func (dm *DatabaseManager) SetSessionFailure(sessionID uuid.UUID, status SessionStatus, code int, reason string, bannedUntil *time.Time) error {
+	now := time.Now()
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID.String()).
+		Updates(map[string]interface{}{
+			"status":             status,
+			"status_reason":      reason,
+			"status_reason_code": code,
+			"banned_until":       bannedUntil,
+			"disconnected_at":    now,
+			"last_seen":          now,
+		}).Error
+}
+
+func (dm *DatabaseManager) UpdateSessionQR(sessionID uuid.UUID, qrCode, base64QR string, expiresAt time.Time) error {
 	now := time.Now()
-	expiresAt := now.Add(timeout)
 
 	return dm.db.Model(&WhatsAppSession{}).
 		Where("id = ?", sessionID.String()).
@@ -469,9 +1482,53 @@ func (dm *DatabaseManager) CreateEvent(sessionID uuid.UUID, userID int, eventTyp
 		EventData: data,
 		CreatedAt: time.Now(),
 	}
+	if dm.writer != nil {
+		dm.writer.addEvent(event)
+		return nil
+	}
 	return dm.db.Create(event).Error
 }
 
+// GetSessionEventsSince returns the events of a session after an event ID, oldest first
+func (dm *DatabaseManager) GetSessionEventsSince(sessionID string, afterID int64, limit int) ([]WhatsAppEvent, error) {
+	var events []WhatsAppEvent
+	err := dm.db.Where("session_id = ? AND id > ?", sessionID, afterID).
+		Order("id ASC").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
+
+// GetLatestEventID returns the ID of the newest event of a session, 0 if none
+func (dm *DatabaseManager) GetLatestEventID(sessionID string) (int64, error) {
+	var id int64
+	err := dm.db.Model(&WhatsAppEvent{}).
+		Select("COALESCE(MAX(id), 0)").
+		Where("session_id = ?", sessionID).
+		Scan(&id).Error
+	return id, err
+}
+
+// GetUserEventsSince returns the events of all sessions of a user after an event ID, oldest first
+func (dm *DatabaseManager) GetUserEventsSince(userID int, afterID int64, limit int) ([]WhatsAppEvent, error) {
+	var events []WhatsAppEvent
+	err := dm.db.Where("user_id = ? AND id > ?", userID, afterID).
+		Order("id ASC").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
+
+// GetLatestUserEventID returns the ID of the newest event of a user, 0 if none
+func (dm *DatabaseManager) GetLatestUserEventID(userID int) (int64, error) {
+	var id int64
+	err := dm.db.Model(&WhatsAppEvent{}).
+		Select("COALESCE(MAX(id), 0)").
+		Where("user_id = ?", userID).
+		Scan(&id).Error
+	return id, err
+}
+
 func (dm *DatabaseManager) GetSessionEvents(sessionID uuid.UUID, limit int) ([]WhatsAppEvent, error) {
 	var events []WhatsAppEvent
 	query := dm.db.Where("session_id = ?", sessionID.String()).Order("created_at DESC")
@@ -482,10 +1539,128 @@ func (dm *DatabaseManager) GetSessionEvents(sessionID uuid.UUID, limit int) ([]W
 	return events, err
 }
 
+// EventFilter selects stored events; empty fields match everything
+type EventFilter struct {
+	SessionID string
+	EventType string
+	From      *time.Time // inclusive
+	To        *time.Time // exclusive
+}
+
+// apply adds the filter to a query of a user's events
+func (f EventFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.SessionID != "" {
+		query = query.Where("session_id = ?", f.SessionID)
+	}
+	if f.EventType != "" {
+		query = query.Where("event_type = ?", f.EventType)
+	}
+	if f.From != nil {
+		query = query.Where("created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		query = query.Where("created_at < ?", *f.To)
+	}
+	return query
+}
+
+// GetEvents returns a page of the stored events of a user
+func (dm *DatabaseManager) GetEvents(userID int, filter EventFilter, q ListQuery) ([]WhatsAppEvent, int64, error) {
+	var events []WhatsAppEvent
+	total, err := dm.readPage(q, &events, func(db *gorm.DB) *gorm.DB {
+		query := filter.apply(db.Model(&WhatsAppEvent{}).Where("user_id = ?", userID))
+		if q.Search != "" {
+			query = dm.searchWhere(query, q.like(), "event_type")
+		}
+		return query
+	})
+	return events, total, err
+}
+
+// EventDayCount is the number of events on one day
+type EventDayCount struct {
+	Day   time.Time `json:"-"`
+	Date  string    `gorm:"-" json:"date"` // YYYY-MM-DD
+	Count int64     `json:"count"`
+}
+
+// EventStatistics summarizes the stored events matching a filter
+type EventStatistics struct {
+	Total     int64            `json:"total"`
+	ByType    map[string]int64 `json:"by_type"`
+	BySession map[string]int64 `json:"by_session"`
+	ByDay     []EventDayCount  `json:"by_day"`
+	FirstAt   *time.Time       `json:"first_at"`
+	LastAt    *time.Time       `json:"last_at"`
+}
+
+// GetEventStatistics counts the stored events of a user by type, session and
+// day
+func (dm *DatabaseManager) GetEventStatistics(userID int, filter EventFilter) (*EventStatistics, error) {
+	var stats *EventStatistics
+	err := dm.read(func(db *gorm.DB) (err error) {
+		stats, err = dm.eventStatistics(db, userID, filter)
+		return err
+	})
+	return stats, err
+}
+
+func (dm *DatabaseManager) eventStatistics(db *gorm.DB, userID int, filter EventFilter) (*EventStatistics, error) {
+	base := func() *gorm.DB {
+		return filter.apply(db.Model(&WhatsAppEvent{}).Where("user_id = ?", userID))
+	}
+	stats := &EventStatistics{
+		ByType:    make(map[string]int64),
+		BySession: make(map[string]int64),
+	}
+
+	var groups []struct {
+		Name  string
+		Count int64
+	}
+	if err := base().Select("event_type AS name, COUNT(*) AS count").Group("event_type").Scan(&groups).Error; err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		stats.ByType[group.Name] = group.Count
+		stats.Total += group.Count
+	}
+	groups = nil
+	if err := base().Select("session_id AS name, COUNT(*) AS count").Group("session_id").Scan(&groups).Error; err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		stats.BySession[group.Name] = group.Count
+	}
+
+	if err := base().Select("DATE(created_at) AS day, COUNT(*) AS count").
+		Group("DATE(created_at)").
+		Order("day ASC").
+		Scan(&stats.ByDay).Error; err != nil {
+		return nil, err
+	}
+	for i := range stats.ByDay {
+		stats.ByDay[i].Date = stats.ByDay[i].Day.Format("2006-01-02")
+	}
+
+	if stats.Total > 0 {
+		var bounds struct {
+			FirstAt time.Time
+			LastAt  time.Time
+		}
+		if err := base().Select("MIN(created_at) AS first_at, MAX(created_at) AS last_at").Scan(&bounds).Error; err != nil {
+			return nil, err
+		}
+		stats.FirstAt, stats.LastAt = &bounds.FirstAt, &bounds.LastAt
+	}
+	return stats, nil
+}
+
 // ============= DEVICE SUMMARY =============
 
 type DeviceSummary struct {
 	UserID           int              `json:"user_id"`
+	ProjectID        *int64           `json:"project_id,omitempty"` // summary of one project; the slots stay the user's
 	MaxDevices       int              `json:"max_devices"`
 	UsedDevices      int              `json:"used_devices"`
 	AvailableSlots   int              `json:"available_slots"`
@@ -498,11 +1673,19 @@ type SessionSummary struct {
 	SessionName string        `json:"session_name"`
 	Status      SessionStatus `json:"status"`
 	PhoneNumber *string       `json:"phone_number,omitempty"`
+	ProjectID   *int64        `json:"project_id,omitempty"`
 	ConnectedAt *time.Time    `json:"connected_at,omitempty"`
 	LastSeen    *time.Time    `json:"last_seen,omitempty"`
 }
 
-func (dm *DatabaseManager) GetUserDeviceSummary(userID int) (*DeviceSummary, error) {
+func (dm *DatabaseManager) GetUserDeviceSummary(userID, maxDevices int) (*DeviceSummary, error) {
+	return dm.GetProjectDeviceSummary(userID, maxDevices, nil)
+}
+
+// GetProjectDeviceSummary summarizes the sessions of one project of a user,
+// or all of them when projectID is nil. Used devices and the available slots
+// always count every session of the user, since the device limit is theirs.
+func (dm *DatabaseManager) GetProjectDeviceSummary(userID, maxDevices int, projectID *int64) (*DeviceSummary, error) {
 	sessions, err := dm.GetUserSessions(userID)
 	if err != nil {
 		return nil, err
@@ -510,11 +1693,19 @@ func (dm *DatabaseManager) GetUserDeviceSummary(userID int) (*DeviceSummary, err
 
 	summary := &DeviceSummary{
 		UserID:     userID,
-		MaxDevices: 5,
+		ProjectID:  projectID,
+		MaxDevices: maxDevices,
 		Sessions:   make([]SessionSummary, 0),
 	}
 
+	usedDevices := 0
 	for _, session := range sessions {
+		if session.IsActive {
+			usedDevices++
+		}
+		if projectID != nil && (session.ProjectID == nil || *session.ProjectID != *projectID) {
+			continue
+		}
 		if session.IsActive {
 			summary.UsedDevices++
 			if session.Status == StatusConnected {
@@ -529,12 +1720,13 @@ func (dm *DatabaseManager) GetUserDeviceSummary(userID int) (*DeviceSummary, err
 			SessionName: session.SessionName,
 			Status:      session.Status,
 			PhoneNumber: session.PhoneNumber,
+			ProjectID:   session.ProjectID,
 			ConnectedAt: session.ConnectedAt,
 			LastSeen:    session.LastSeen,
 		})
 	}
 
-	summary.AvailableSlots = summary.MaxDevices - summary.UsedDevices
+	summary.AvailableSlots = summary.MaxDevices - usedDevices
 	return summary, nil
 }
 
@@ -572,11 +1764,64 @@ func (dm *DatabaseManager) DeleteDevice(device *store.Device) error {
 	return dm.sqlDB.DeleteDevice(context.Background(), device)
 }
 
+// Ping checks that the database answers
+func (dm *DatabaseManager) Ping(ctx context.Context) error {
+	sqlDB, err := dm.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// DBPoolStats is the utilization of the application database pool
+type DBPoolStats struct {
+	MaxOpen           int     `json:"max_open"` // 0 = unlimited
+	Open              int     `json:"open"`
+	InUse             int     `json:"in_use"`
+	Idle              int     `json:"idle"`
+	Utilization       float64 `json:"utilization"` // in_use / max_open, 0 when unlimited
+	WaitCount         int64   `json:"wait_count"`  // requests that waited for a connection
+	WaitDurationMs    int64   `json:"wait_duration_ms"`
+	MaxIdleClosed     int64   `json:"max_idle_closed"`
+	MaxLifetimeClosed int64   `json:"max_lifetime_closed"`
+}
+
+// PoolStats returns the utilization of the application database pool
+func (dm *DatabaseManager) PoolStats() (*DBPoolStats, error) {
+	sqlDB, err := dm.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	s := sqlDB.Stats()
+	stats := &DBPoolStats{
+		MaxOpen:           s.MaxOpenConnections,
+		Open:              s.OpenConnections,
+		InUse:             s.InUse,
+		Idle:              s.Idle,
+		WaitCount:         s.WaitCount,
+		WaitDurationMs:    s.WaitDuration.Milliseconds(),
+		MaxIdleClosed:     s.MaxIdleClosed,
+		MaxLifetimeClosed: s.MaxLifetimeClosed,
+	}
+	if s.MaxOpenConnections > 0 {
+		stats.Utilization = float64(s.InUse) / float64(s.MaxOpenConnections)
+	}
+	return stats, nil
+}
+
 func (dm *DatabaseManager) Close() error {
+	if dm.writer != nil {
+		dm.writer.close()
+	}
 	sqlDB, _ := dm.db.DB()
 	if sqlDB != nil {
 		sqlDB.Close()
 	}
+	if dm.replica != nil {
+		if replicaDB, _ := dm.replica.db.DB(); replicaDB != nil {
+			replicaDB.Close()
+		}
+	}
 	return nil
 }
 
@@ -604,15 +1849,126 @@ func (dm *DatabaseManager) BulkUpsertContacts(contacts []WhatsAppContact) error
 			"country_code", "mobile_number",
 			"group_id", "is_group_member", "updated_at",
 		}),
-	}).Create(&contacts).Error
+	}).CreateInBatches(&contacts, contactLookupBatch).Error
+}
+
+// contactLookupBatch is the number of JIDs per IN query
+const contactLookupBatch = 1000
+
+// GetContactNames returns the stored full names of the given contacts of a
+// user, keyed by JID
+func (dm *DatabaseManager) GetContactNames(userID int, jids []string) (map[string]string, error) {
+	names := make(map[string]string, len(jids))
+	for start := 0; start < len(jids); start += contactLookupBatch {
+		end := start + contactLookupBatch
+		if end > len(jids) {
+			end = len(jids)
+		}
+
+		var rows []struct {
+			JID      string `gorm:"column:jid"`
+			FullName string
+		}
+		err := dm.db.Model(&WhatsAppContact{}).
+			Select("jid, full_name").
+			Where("user_id = ? AND jid IN ?", userID, jids[start:end]).
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			names[row.JID] = row.FullName
+		}
+	}
+	return names, nil
+}
+
+// GetContactSyncDueSessions returns the connected sessions whose contacts
+// were last delta-synced before the cutoff (or never)
+func (dm *DatabaseManager) GetContactSyncDueSessions(cutoff time.Time) ([]string, error) {
+	var ids []string
+	err := dm.db.Model(&WhatsAppSession{}).
+		Where("status = ? AND (contacts_synced_at IS NULL OR contacts_synced_at < ?)", StatusConnected, cutoff).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+// SetContactsSynced moves the contact sync watermark of a session
+func (dm *DatabaseManager) SetContactsSynced(sessionID string, at time.Time) error {
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID).
+		Update("contacts_synced_at", at).Error
 }
 
-func (dm *DatabaseManager) GetUserContacts(userID int) ([]WhatsAppContact, error) {
+// GetUserContacts pages through the contacts of a user
+// (search: name, number, JID)
+func (dm *DatabaseManager) GetUserContacts(userID int, q ListQuery) ([]WhatsAppContact, int64, error) {
 	var contacts []WhatsAppContact
-	err := dm.db.Where("user_id = ?", userID).
-		Order("full_name ASC").
-		Find(&contacts).Error
-	return contacts, err
+	total, err := dm.readPage(q, &contacts, func(db *gorm.DB) *gorm.DB {
+		query := db.Model(&WhatsAppContact{}).Where("user_id = ?", userID)
+		if q.Search != "" {
+			query = dm.searchWhere(query, q.like(), "full_name", "mobile_number", "jid")
+		}
+		return query
+	})
+	return contacts, total, err
+}
+
+// GetLIDContactJIDs returns the JIDs of the contacts of a user stored under
+// a LID
+func (dm *DatabaseManager) GetLIDContactJIDs(userID int) ([]string, error) {
+	var jids []string
+	err := dm.db.Model(&WhatsAppContact{}).
+		Where("user_id = ? AND jid LIKE ?", userID, "%@"+types.HiddenUserServer).
+		Pluck("jid", &jids).Error
+	return jids, err
+}
+
+// GetContactsByJIDs returns the contacts of a user with the given JIDs. A
+// contact stored under the other identifier of a requested LID or phone
+// number JID is returned under the requested JID.
+func (dm *DatabaseManager) GetContactsByJIDs(userID int, jids []string) ([]WhatsAppContact, error) {
+	var contacts []WhatsAppContact
+	if len(jids) == 0 {
+		return contacts, nil
+	}
+	mappings, err := dm.GetLIDMappings(jids)
+	if err != nil {
+		return nil, err
+	}
+	alias := make(map[string]string, 2*len(mappings))
+	lookup := append([]string{}, jids...)
+	for _, mapping := range mappings {
+		alias[mapping.LID] = mapping.PhoneJID
+		alias[mapping.PhoneJID] = mapping.LID
+		lookup = append(lookup, mapping.LID, mapping.PhoneJID)
+	}
+	if err := dm.db.Where("user_id = ? AND jid IN ?", userID, lookup).Find(&contacts).Error; err != nil {
+		return nil, err
+	}
+	if len(mappings) == 0 {
+		return contacts, nil
+	}
+
+	byJID := make(map[string]WhatsAppContact, len(contacts))
+	for _, contact := range contacts {
+		byJID[contact.JID] = contact
+	}
+	found := make([]WhatsAppContact, 0, len(jids))
+	seen := make(map[string]bool, len(jids))
+	for _, jid := range jids {
+		if seen[jid] {
+			continue
+		}
+		seen[jid] = true
+		if contact, ok := byJID[jid]; ok {
+			found = append(found, contact)
+		} else if contact, ok := byJID[alias[jid]]; ok {
+			contact.JID = jid
+			found = append(found, contact)
+		}
+	}
+	return found, nil
 }
 
 // ============= GROUP REPOSITORY (Add at the end of database.go) =============
@@ -627,17 +1983,37 @@ func (dm *DatabaseManager) UpsertGroup(group *WhatsAppGroup) error {
 			"participant_count",
 			"is_announcement",
 			"is_locked",
+			"ephemeral_timer",
+			"member_add_mode",
+			"join_approval",
+			"synced_at",
 			"updated_at",
 		}),
 	}).Create(group).Error // ✅ CORRECT - updates on conflict
 }
 
-func (dm *DatabaseManager) GetUserGroups(userID int) ([]WhatsAppGroup, error) {
+// UpdateGroupSettings updates the stored settings of a group, if it has been synced
+func (dm *DatabaseManager) UpdateGroupSettings(userID int, groupJID string, updates map[string]interface{}) error {
+	return dm.db.Model(&WhatsAppGroup{}).
+		Where("user_id = ? AND group_jid = ?", userID, groupJID).
+		Updates(updates).Error
+}
+
+// GetUserGroups pages through the stored groups of a user
+// (filter: session_id; search: name, JID)
+func (dm *DatabaseManager) GetUserGroups(userID int, q ListQuery) ([]WhatsAppGroup, int64, error) {
 	var groups []WhatsAppGroup
-	err := dm.db.Where("user_id = ?", userID).
-		Order("group_name ASC").
-		Find(&groups).Error
-	return groups, err
+	total, err := dm.readPage(q, &groups, func(db *gorm.DB) *gorm.DB {
+		query := db.Model(&WhatsAppGroup{}).Where("user_id = ?", userID)
+		if sessionID, ok := q.Filters["session_id"]; ok {
+			query = query.Where("session_id = ?", sessionID)
+		}
+		if q.Search != "" {
+			query = dm.searchWhere(query, q.like(), "group_name", "group_jid")
+		}
+		return query
+	})
+	return groups, total, err
 }
 
 func (dm *DatabaseManager) GetGroupByJID(userID int, groupJID string) (*WhatsAppGroup, error) {
@@ -650,8 +2026,3364 @@ func (dm *DatabaseManager) GetGroupByJID(userID int, groupJID string) (*WhatsApp
 	return &group, nil
 }
 
+// SetSessionPaired records when a number was paired, restarting its warm-up
+func (dm *DatabaseManager) SetSessionPaired(sessionID uuid.UUID) error {
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID.String()).
+		Update("paired_at", time.Now()).Error
+}
+
+func (dm *DatabaseManager) UpdateSessionSafety(sessionID string, updates map[string]interface{}) error {
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID).
+		Updates(updates).Error
+}
+
+func (dm *DatabaseManager) UpdateSessionCallSettings(sessionID string, updates map[string]interface{}) error {
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID).
+		Updates(updates).Error
+}
+
+// SetSessionMediaAutoStore changes whether a session copies incoming media
+// to media storage
+func (dm *DatabaseManager) SetSessionMediaAutoStore(sessionID string, autoStore bool) error {
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID).
+		Update("media_auto_store", autoStore).Error
+}
+
+// SetSessionDeviceInfo stores the linked device metadata and platform of a
+// session
+func (dm *DatabaseManager) SetSessionDeviceInfo(sessionID string, info JSONData, platform string) error {
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID).
+		Updates(map[string]interface{}{
+			"device_info": info,
+			"platform":    platform,
+		}).Error
+}
+
+// SetSessionCanary sets the health canary target and mode of a session; an
+// empty target turns the canary off
+func (dm *DatabaseManager) SetSessionCanary(sessionID, target, mode string) error {
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID).
+		Updates(map[string]interface{}{
+			"canary_jid":  target,
+			"canary_mode": mode,
+		}).Error
+}
+
+// GetCanarySessions returns the connected sessions with a health canary
+func (dm *DatabaseManager) GetCanarySessions() ([]WhatsAppSession, error) {
+	var sessions []WhatsAppSession
+	err := dm.db.Select("id", "user_id", "canary_jid", "canary_mode").
+		Where("status = ? AND canary_jid <> ''", StatusConnected).
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// GetSessionMediaAutoStore reports whether a session copies incoming media
+// to media storage
+func (dm *DatabaseManager) GetSessionMediaAutoStore(sessionID string) (bool, error) {
+	var autoStore []bool
+	err := dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID).
+		Pluck("media_auto_store", &autoStore).Error
+	return len(autoStore) > 0 && autoStore[0], err
+}
+
+// SetSessionOutboxTTL changes how long the queued sends of a session may
+// wait before they expire
+func (dm *DatabaseManager) SetSessionOutboxTTL(sessionID string, seconds int) error {
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID).
+		Update("outbox_ttl_seconds", seconds).Error
+}
+
+// SetSessionReadReceipts changes whether a session sends read receipts
+func (dm *DatabaseManager) SetSessionReadReceipts(sessionID string, send bool) error {
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID).
+		Update("send_read_receipts", send).Error
+}
+
 func (dm *DatabaseManager) UpdateSessionBusinessAccount(sessionID uuid.UUID, isBusiness bool) error {
 	return dm.db.Model(&WhatsAppSession{}).
 		Where("id = ?", sessionID.String()).
 		Update("is_business_account", isBusiness).Error
 }
+
+// ============= CHAT & MESSAGE REPOSITORY =============
+// Messages are looked up by the chat's LID and phone number JID alike, since
+// a chat can be addressed by either (see GetJIDAliases).
+
+// BulkUpsertChats stores the chats of a history sync chunk. Chunks arrive out
+// of order and after live messages, so the unread count, archived and pinned
+// state of a stored chat are only replaced by a chat state that is at least
+// as new, and its last message time never moves back.
+func (dm *DatabaseManager) BulkUpsertChats(chats []WhatsAppChat) error {
+	if len(chats) == 0 {
+		return nil
+	}
+
+	newer := fmt.Sprintf("whats_app_chats.last_message_at IS NULL OR %s >= whats_app_chats.last_message_at",
+		dm.upsertValue("last_message_at"))
+	ifNewer := func(column string) clause.Assignment {
+		return clause.Assignment{
+			Column: clause.Column{Name: column},
+			Value: gorm.Expr(fmt.Sprintf("CASE WHEN %s THEN %s ELSE whats_app_chats.%s END",
+				newer, dm.upsertValue(column), column)),
+		}
+	}
+	return dm.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "session_id"}, {Name: "chat_jid"}},
+		// MySQL assigns in order, so last_message_at goes after the columns
+		// comparing against it
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "name"}, Value: gorm.Expr(dm.upsertValue("name"))},
+			{Column: clause.Column{Name: "is_group"}, Value: gorm.Expr(dm.upsertValue("is_group"))},
+			ifNewer("unread_count"),
+			ifNewer("archived"),
+			ifNewer("pinned"),
+			ifNewer("last_message_at"),
+			{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr(dm.upsertValue("updated_at"))},
+		},
+	}).CreateInBatches(&chats, 200).Error
+}
+
+// TouchChat creates the chat if needed and bumps its last message time
+func (dm *DatabaseManager) TouchChat(sessionID string, userID int, chatJID string, isGroup bool, messageAt time.Time) error {
+	chat := &WhatsAppChat{
+		SessionID:     sessionID,
+		UserID:        userID,
+		ChatJID:       chatJID,
+		IsGroup:       isGroup,
+		LastMessageAt: &messageAt,
+	}
+	return dm.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "session_id"}, {Name: "chat_jid"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"last_message_at": messageAt,
+			"updated_at":      time.Now(),
+		}),
+	}).Create(chat).Error
+}
+
+// IncrementChatUnread bumps the unread counter of a chat by one
+func (dm *DatabaseManager) IncrementChatUnread(sessionID, chatJID string) error {
+	return dm.db.Model(&WhatsAppChat{}).
+		Where("session_id = ? AND chat_jid = ?", sessionID, chatJID).
+		Update("unread_count", gorm.Expr("unread_count + 1")).Error
+}
+
+// UpdateChatState updates chat flags such as archived, pinned, muted_until or unread_count
+func (dm *DatabaseManager) UpdateChatState(sessionID, chatJID string, updates map[string]interface{}) error {
+	updates["updated_at"] = time.Now()
+	return dm.db.Model(&WhatsAppChat{}).
+		Where("session_id = ? AND chat_jid = ?", sessionID, chatJID).
+		Updates(updates).Error
+}
+
+// GetLastChatActivity returns the time of the latest message of each of the
+// given chats over all sessions of a user
+func (dm *DatabaseManager) GetLastChatActivity(userID int, chatJIDs []string) (map[string]time.Time, error) {
+	activity := make(map[string]time.Time, len(chatJIDs))
+	if len(chatJIDs) == 0 {
+		return activity, nil
+	}
+
+	var rows []struct {
+		ChatJID       string
+		LastMessageAt time.Time
+	}
+	err := dm.db.Model(&WhatsAppChat{}).
+		Select("chat_jid, MAX(last_message_at) AS last_message_at").
+		Where("user_id = ? AND chat_jid IN ? AND last_message_at IS NOT NULL", userID, chatJIDs).
+		Group("chat_jid").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		activity[row.ChatJID] = row.LastMessageAt
+	}
+	return activity, nil
+}
+
+// GetSessionChats pages through the chats of a session
+// (filters: archived, pinned, is_group, unread; search: name, JID)
+func (dm *DatabaseManager) GetSessionChats(sessionID string, userID int, q ListQuery) ([]WhatsAppChat, int64, error) {
+	var chats []WhatsAppChat
+	total, err := dm.readPage(q, &chats, func(db *gorm.DB) *gorm.DB {
+		query := db.Model(&WhatsAppChat{}).Where("session_id = ? AND user_id = ?", sessionID, userID)
+		for _, column := range []string{"archived", "pinned", "is_group"} {
+			if value, ok := q.boolFilter(column); ok {
+				query = query.Where(column+" = ?", value)
+			}
+		}
+		if unread, ok := q.boolFilter("unread"); ok {
+			if unread {
+				query = query.Where("unread_count > 0")
+			} else {
+				query = query.Where("unread_count = 0")
+			}
+		}
+		if q.Search != "" {
+			query = dm.searchWhere(query, q.like(), "name", "chat_jid")
+		}
+		return query
+	})
+	return chats, total, err
+}
+
+// SaveMessage stores a message, ignoring duplicates of the same chat/message ID
+func (dm *DatabaseManager) SaveMessage(message *WhatsAppMessage) error {
+	return dm.db.Clauses(clause.OnConflict{DoNothing: true}).Create(message).Error
+}
+
+// GetMessage returns a stored message of a chat
+func (dm *DatabaseManager) GetMessage(sessionID, chatJID, messageID string) (*WhatsAppMessage, error) {
+	chatJIDs := dm.jidAliases(chatJID)
+	var message WhatsAppMessage
+	err := dm.db.Where("session_id = ? AND chat_jid IN ? AND message_id = ?", sessionID, chatJIDs, messageID).
+		First(&message).Error
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// UpdateMessage updates a stored message; it returns the number of messages
+// updated (0 when the message isn't stored)
+func (dm *DatabaseManager) UpdateMessage(sessionID, chatJID, messageID string, updates map[string]interface{}) (int64, error) {
+	chatJIDs := dm.jidAliases(chatJID)
+	result := dm.db.Model(&WhatsAppMessage{}).
+		Where("session_id = ? AND chat_jid IN ? AND message_id = ?", sessionID, chatJIDs, messageID).
+		Updates(updates)
+	return result.RowsAffected, result.Error
+}
+
+// MarkMessagesReceipt records the first delivery (and with read, the first
+// read) receipt of outgoing messages
+func (dm *DatabaseManager) MarkMessagesReceipt(sessionID string, messageIDs []string, read bool, at time.Time) error {
+	updates := map[string]interface{}{
+		"delivered_at": gorm.Expr("COALESCE(delivered_at, ?)", at),
+	}
+	if read {
+		updates["read_at"] = gorm.Expr("COALESCE(read_at, ?)", at)
+	}
+	return dm.db.Model(&WhatsAppMessage{}).
+		Where("session_id = ? AND message_id IN ? AND from_me = ?", sessionID, messageIDs, true).
+		Updates(updates).Error
+}
+
+func (dm *DatabaseManager) BulkSaveMessages(messages []WhatsAppMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	return dm.db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&messages, 200).Error
+}
+
+// GetChatMessages pages through the stored messages of a chat older than
+// before (filters: type, from_me; search: content)
+func (dm *DatabaseManager) GetChatMessages(sessionID, chatJID string, before *time.Time, q ListQuery) ([]WhatsAppMessage, int64, error) {
+	chatJIDs := dm.jidAliases(chatJID)
+	var messages []WhatsAppMessage
+	total, err := dm.readPage(q, &messages, func(db *gorm.DB) *gorm.DB {
+		query := db.Model(&WhatsAppMessage{}).Where("session_id = ? AND chat_jid IN ?", sessionID, chatJIDs)
+		if before != nil {
+			query = query.Where("timestamp < ?", *before)
+		}
+		if messageType, ok := q.Filters["type"]; ok {
+			query = query.Where("message_type = ?", messageType)
+		}
+		if fromMe, ok := q.boolFilter("from_me"); ok {
+			query = query.Where("from_me = ?", fromMe)
+		}
+		if q.Search != "" {
+			query = dm.searchWhere(query, q.like(), "content")
+		}
+		return query
+	})
+	return messages, total, err
+}
+
+func (dm *DatabaseManager) CountChatMessages(sessionID, chatJID string) (int64, error) {
+	chatJIDs := dm.jidAliases(chatJID)
+	var count int64
+	err := dm.read(func(db *gorm.DB) error {
+		return db.Model(&WhatsAppMessage{}).
+			Where("session_id = ? AND chat_jid IN ?", sessionID, chatJIDs).
+			Count(&count).Error
+	})
+	return count, err
+}
+
+// EachChatMessage walks the messages of a chat oldest first, batchSize at a time
+// (keyset paging on timestamp + id; history imports don't arrive in order)
+func (dm *DatabaseManager) EachChatMessage(sessionID, chatJID string, batchSize int, fn func([]WhatsAppMessage) error) error {
+	chatJIDs := dm.jidAliases(chatJID)
+	var last *WhatsAppMessage
+	for {
+		var batch []WhatsAppMessage
+		query := dm.db.Where("session_id = ? AND chat_jid IN ?", sessionID, chatJIDs)
+		if last != nil {
+			query = query.Where("timestamp > ? OR (timestamp = ? AND id > ?)", last.Timestamp, last.Timestamp, last.ID)
+		}
+		if err := query.Order("timestamp ASC, id ASC").Limit(batchSize).Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+		if len(batch) < batchSize {
+			return nil
+		}
+		last = &batch[len(batch)-1]
+	}
+}
+
+// GetUnreadChatMessages returns incoming messages of a chat that have not been marked read yet
+func (dm *DatabaseManager) GetUnreadChatMessages(sessionID, chatJID string) ([]WhatsAppMessage, error) {
+	chatJIDs := dm.jidAliases(chatJID)
+	var messages []WhatsAppMessage
+	err := dm.db.Where("session_id = ? AND chat_jid IN ? AND from_me = ? AND is_read = ?", sessionID, chatJIDs, false, false).
+		Order("timestamp ASC").
+		Find(&messages).Error
+	return messages, err
+}
+
+// MarkChatMessagesRead flags all messages of a chat as read and resets its unread counter
+func (dm *DatabaseManager) MarkChatMessagesRead(sessionID, chatJID string) error {
+	chatJIDs := dm.jidAliases(chatJID)
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&WhatsAppMessage{}).
+			Where("session_id = ? AND chat_jid IN ? AND is_read = ?", sessionID, chatJIDs, false).
+			Update("is_read", true).Error; err != nil {
+			return err
+		}
+		return tx.Model(&WhatsAppChat{}).
+			Where("session_id = ? AND chat_jid IN ?", sessionID, chatJIDs).
+			Updates(map[string]interface{}{
+				"unread_count": 0,
+				"updated_at":   time.Now(),
+			}).Error
+	})
+}
+
+// GetLatestChatMessage returns the newest stored message of a chat
+func (dm *DatabaseManager) GetLatestChatMessage(sessionID, chatJID string) (*WhatsAppMessage, error) {
+	chatJIDs := dm.jidAliases(chatJID)
+	var message WhatsAppMessage
+	err := dm.db.Where("session_id = ? AND chat_jid IN ?", sessionID, chatJIDs).
+		Order("timestamp DESC").
+		First(&message).Error
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// ============= BROADCAST LIST REPOSITORY =============
+
+func (dm *DatabaseManager) CreateBroadcastList(list *WhatsAppBroadcastList) error {
+	return dm.db.Create(list).Error
+}
+
+func (dm *DatabaseManager) GetBroadcastLists(sessionID string, userID int) ([]WhatsAppBroadcastList, error) {
+	var lists []WhatsAppBroadcastList
+	err := dm.db.Preload("Recipients").
+		Where("session_id = ? AND user_id = ?", sessionID, userID).
+		Order("name ASC").
+		Find(&lists).Error
+	return lists, err
+}
+
+func (dm *DatabaseManager) GetBroadcastList(sessionID string, userID int, listID int64) (*WhatsAppBroadcastList, error) {
+	var list WhatsAppBroadcastList
+	err := dm.db.Preload("Recipients").
+		Where("id = ? AND session_id = ? AND user_id = ?", listID, sessionID, userID).
+		First(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (dm *DatabaseManager) GetBroadcastListByJID(sessionID, broadcastJID string) (*WhatsAppBroadcastList, error) {
+	var list WhatsAppBroadcastList
+	err := dm.db.Preload("Recipients").
+		Where("session_id = ? AND broadcast_jid = ?", sessionID, broadcastJID).
+		First(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (dm *DatabaseManager) AddBroadcastRecipients(listID int64, jids []string) error {
+	if len(jids) == 0 {
+		return nil
+	}
+	recipients := make([]WhatsAppBroadcastRecipient, 0, len(jids))
+	for _, jid := range jids {
+		recipients = append(recipients, WhatsAppBroadcastRecipient{ListID: listID, JID: jid})
+	}
+	return dm.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&recipients).Error
+}
+
+func (dm *DatabaseManager) RemoveBroadcastRecipients(listID int64, jids []string) error {
+	if len(jids) == 0 {
+		return nil
+	}
+	return dm.db.Where("list_id = ? AND jid IN ?", listID, jids).
+		Delete(&WhatsAppBroadcastRecipient{}).Error
+}
+
+func (dm *DatabaseManager) DeleteBroadcastList(sessionID string, userID int, listID int64) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND session_id = ? AND user_id = ?", listID, sessionID, userID).
+			Delete(&WhatsAppBroadcastList{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("list_id = ?", listID).Delete(&WhatsAppBroadcastRecipient{}).Error
+	})
+}
+
+// ============= GROUP SCHEDULE REPOSITORY =============
+
+func (dm *DatabaseManager) UpsertGroupSchedule(schedule *WhatsAppGroupSchedule) error {
+	return dm.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "session_id"}, {Name: "group_jid"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"start_time",
+			"end_time",
+			"timezone",
+			"days",
+			"enabled",
+			"updated_at",
+		}),
+	}).Create(schedule).Error
+}
+
+func (dm *DatabaseManager) GetGroupSchedule(sessionID string, userID int, groupJID string) (*WhatsAppGroupSchedule, error) {
+	var schedule WhatsAppGroupSchedule
+	err := dm.db.Where("session_id = ? AND user_id = ? AND group_jid = ?", sessionID, userID, groupJID).
+		First(&schedule).Error
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+func (dm *DatabaseManager) GetSchedulableGroupSchedules() ([]WhatsAppGroupSchedule, error) {
+	var schedules []WhatsAppGroupSchedule
+	// Disabled schedules that are still applied need one more run to be reverted
+	err := dm.db.Where("enabled = ? OR active = ?", true, true).
+		Find(&schedules).Error
+	return schedules, err
+}
+
+func (dm *DatabaseManager) UpdateGroupSchedule(id int64, updates map[string]interface{}) error {
+	return dm.db.Model(&WhatsAppGroupSchedule{}).
+		Where("id = ?", id).
+		Updates(updates).Error
+}
+
+func (dm *DatabaseManager) DeleteGroupSchedule(sessionID string, userID int, groupJID string) error {
+	result := dm.db.Where("session_id = ? AND user_id = ? AND group_jid = ?", sessionID, userID, groupJID).
+		Delete(&WhatsAppGroupSchedule{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ============= AVATAR REPOSITORY =============
+
+func (dm *DatabaseManager) GetAvatar(sessionID, jid string) (*WhatsAppAvatar, error) {
+	var avatar WhatsAppAvatar
+	err := dm.db.Where("session_id = ? AND jid = ?", sessionID, jid).
+		First(&avatar).Error
+	if err != nil {
+		return nil, err
+	}
+	return &avatar, nil
+}
+
+func (dm *DatabaseManager) SaveAvatar(avatar *WhatsAppAvatar) error {
+	return dm.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "session_id"}, {Name: "jid"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"picture_id",
+			"file_path",
+			"content_type",
+			"size",
+			"etag",
+			"not_set",
+			"fetched_at",
+			"updated_at",
+		}),
+	}).Create(avatar).Error
+}
+
+func (dm *DatabaseManager) GetStaleAvatars(fetchedBefore time.Time, limit int) ([]WhatsAppAvatar, error) {
+	var avatars []WhatsAppAvatar
+	err := dm.db.Where("fetched_at < ?", fetchedBefore).
+		Order("fetched_at ASC").
+		Limit(limit).
+		Find(&avatars).Error
+	return avatars, err
+}
+
+// AddPictureChange records a picture change and drops the changes of the JID
+// beyond the latest keep
+func (dm *DatabaseManager) AddPictureChange(change *WhatsAppPictureChange, keep int) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(change).Error; err != nil {
+			return err
+		}
+		var ids []int64
+		err := tx.Model(&WhatsAppPictureChange{}).
+			Where("session_id = ? AND jid = ?", change.SessionID, change.JID).
+			Order("changed_at DESC, id DESC").
+			Pluck("id", &ids).Error
+		if err != nil || len(ids) <= keep {
+			return err
+		}
+		return tx.Where("id IN ?", ids[keep:]).Delete(&WhatsAppPictureChange{}).Error
+	})
+}
+
+// GetPictureChanges returns the recorded picture changes of a JID, newest first
+func (dm *DatabaseManager) GetPictureChanges(sessionID, jid string) ([]WhatsAppPictureChange, error) {
+	var changes []WhatsAppPictureChange
+	err := dm.db.Where("session_id = ? AND jid = ?", sessionID, jid).
+		Order("changed_at DESC, id DESC").
+		Find(&changes).Error
+	return changes, err
+}
+
+// ============= BLOCKLIST REPOSITORY =============
+
+// GetBlocklist returns the stored blocklist of a session
+func (dm *DatabaseManager) GetBlocklist(sessionID string) ([]WhatsAppBlockedContact, error) {
+	var blocked []WhatsAppBlockedContact
+	err := dm.db.Where("session_id = ?", sessionID).
+		Order("blocked_at DESC, id DESC").
+		Find(&blocked).Error
+	return blocked, err
+}
+
+// SetBlocked adds a JID to or removes it from the blocklist of a session and
+// updates the user's contact; it reports whether the stored list changed
+func (dm *DatabaseManager) SetBlocked(sessionID string, userID int, jid string, blocked bool) (bool, error) {
+	changed := false
+	err := dm.db.Transaction(func(tx *gorm.DB) error {
+		if blocked {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&WhatsAppBlockedContact{SessionID: sessionID, JID: jid, BlockedAt: time.Now()})
+			if result.Error != nil {
+				return result.Error
+			}
+			changed = result.RowsAffected > 0
+		} else {
+			result := tx.Where("session_id = ? AND jid = ?", sessionID, jid).Delete(&WhatsAppBlockedContact{})
+			if result.Error != nil {
+				return result.Error
+			}
+			changed = result.RowsAffected > 0
+		}
+		if !changed {
+			return nil
+		}
+		return refreshContactsBlocked(tx, userID, []string{jid})
+	})
+	return changed, err
+}
+
+// ReplaceBlocklist stores the full blocklist of a session and updates the
+// user's contacts; it returns the JIDs added to and removed from the list
+func (dm *DatabaseManager) ReplaceBlocklist(sessionID string, userID int, jids []string) (added, removed []string, err error) {
+	err = dm.db.Transaction(func(tx *gorm.DB) error {
+		var stored []string
+		if err := tx.Model(&WhatsAppBlockedContact{}).Where("session_id = ?", sessionID).Pluck("jid", &stored).Error; err != nil {
+			return err
+		}
+		current := make(map[string]bool, len(jids))
+		for _, jid := range jids {
+			current[jid] = true
+		}
+		known := make(map[string]bool, len(stored))
+		for _, jid := range stored {
+			known[jid] = true
+			if !current[jid] {
+				removed = append(removed, jid)
+			}
+		}
+		now := time.Now()
+		var rows []WhatsAppBlockedContact
+		for jid := range current {
+			if !known[jid] {
+				added = append(added, jid)
+				rows = append(rows, WhatsAppBlockedContact{SessionID: sessionID, JID: jid, BlockedAt: now})
+			}
+		}
+
+		if len(removed) > 0 {
+			if err := tx.Where("session_id = ? AND jid IN ?", sessionID, removed).Delete(&WhatsAppBlockedContact{}).Error; err != nil {
+				return err
+			}
+		}
+		if len(rows) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, 500).Error; err != nil {
+				return err
+			}
+		}
+		// Every listed JID, so contacts saved after they were blocked are
+		// flagged too
+		return refreshContactsBlocked(tx, userID, append(append([]string{}, jids...), removed...))
+	})
+	return added, removed, err
+}
+
+// refreshContactsBlocked recomputes is_blocked of the given contacts of a
+// user: blocked while any of the user's sessions blocks them
+func refreshContactsBlocked(tx *gorm.DB, userID int, jids []string) error {
+	if len(jids) == 0 {
+		return nil
+	}
+	var blocked []string
+	err := tx.Model(&WhatsAppBlockedContact{}).
+		Where("jid IN ?", jids).
+		Where("session_id IN (?)", tx.Model(&WhatsAppSession{}).Select("id").Where("user_id = ?", userID)).
+		Distinct().
+		Pluck("jid", &blocked).Error
+	if err != nil {
+		return err
+	}
+
+	isBlocked := make(map[string]bool, len(blocked))
+	for _, jid := range blocked {
+		isBlocked[jid] = true
+	}
+	var unblocked []string
+	for _, jid := range jids {
+		if !isBlocked[jid] {
+			unblocked = append(unblocked, jid)
+		}
+	}
+
+	if len(blocked) > 0 {
+		if err := tx.Model(&WhatsAppContact{}).
+			Where("user_id = ? AND jid IN ?", userID, blocked).
+			Update("is_blocked", true).Error; err != nil {
+			return err
+		}
+	}
+	if len(unblocked) > 0 {
+		return tx.Model(&WhatsAppContact{}).
+			Where("user_id = ? AND jid IN ?", userID, unblocked).
+			Update("is_blocked", false).Error
+	}
+	return nil
+}
+
+// GetBlocklistSyncDueSessions returns the connected sessions whose blocklist
+// wasn't reconciled since cutoff
+func (dm *DatabaseManager) GetBlocklistSyncDueSessions(cutoff time.Time) ([]string, error) {
+	var ids []string
+	err := dm.db.Model(&WhatsAppSession{}).
+		Where("status = ? AND (blocklist_synced_at IS NULL OR blocklist_synced_at < ?)", StatusConnected, cutoff).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+// SetBlocklistSynced moves the blocklist reconciliation watermark of a session
+func (dm *DatabaseManager) SetBlocklistSynced(sessionID string, at time.Time) error {
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID).
+		Update("blocklist_synced_at", at).Error
+}
+
+// ============= CHANNEL REPOSITORY =============
+
+func (dm *DatabaseManager) CreateChannel(channel *WhatsAppChannel) error {
+	return dm.db.Create(channel).Error
+}
+
+func (dm *DatabaseManager) GetChannels(userID int) ([]WhatsAppChannel, error) {
+	var channels []WhatsAppChannel
+	err := dm.db.Where("user_id = ?", userID).
+		Order("name ASC").
+		Find(&channels).Error
+	return channels, err
+}
+
+func (dm *DatabaseManager) GetChannel(channelID int64, userID int) (*WhatsAppChannel, error) {
+	var channel WhatsAppChannel
+	err := dm.db.Where("id = ? AND user_id = ?", channelID, userID).
+		First(&channel).Error
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// GetSessionChannels returns the channels a session is the primary or
+// backup of
+func (dm *DatabaseManager) GetSessionChannels(sessionID string) ([]WhatsAppChannel, error) {
+	var channels []WhatsAppChannel
+	err := dm.db.Where("primary_session_id = ? OR backup_session_id = ?", sessionID, sessionID).
+		Find(&channels).Error
+	return channels, err
+}
+
+// SwitchChannel makes another session of a channel the active one, unless
+// the channel switched meanwhile; it reports whether it switched
+func (dm *DatabaseManager) SwitchChannel(channelID int64, from, to ChannelRole, reason string, at time.Time) (bool, error) {
+	result := dm.db.Model(&WhatsAppChannel{}).
+		Where("id = ? AND active = ?", channelID, from).
+		Updates(map[string]interface{}{
+			"active":        to,
+			"switched_at":   at,
+			"switch_reason": reason,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (dm *DatabaseManager) DeleteChannel(channelID int64, userID int) (int64, error) {
+	result := dm.db.Where("id = ? AND user_id = ?", channelID, userID).Delete(&WhatsAppChannel{})
+	return result.RowsAffected, result.Error
+}
+
+// ============= PROJECT REPOSITORY =============
+
+// ProjectCounts are the session counts of a project
+type ProjectCounts struct {
+	ProjectID int64
+	Sessions  int
+	Connected int
+}
+
+func (dm *DatabaseManager) CreateProject(project *WhatsAppProject) error {
+	return dm.db.Create(project).Error
+}
+
+func (dm *DatabaseManager) GetProjects(userID int) ([]WhatsAppProject, error) {
+	var projects []WhatsAppProject
+	err := dm.db.Where("user_id = ?", userID).
+		Order("name ASC").
+		Find(&projects).Error
+	return projects, err
+}
+
+func (dm *DatabaseManager) GetProject(projectID int64, userID int) (*WhatsAppProject, error) {
+	var project WhatsAppProject
+	err := dm.db.Where("id = ? AND user_id = ?", projectID, userID).
+		First(&project).Error
+	if err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+func (dm *DatabaseManager) UpdateProject(project *WhatsAppProject) error {
+	return dm.db.Save(project).Error
+}
+
+// DeleteProject removes a project; its sessions are left without one
+func (dm *DatabaseManager) DeleteProject(projectID int64, userID int) (int64, error) {
+	var deleted int64
+	err := dm.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", projectID, userID).Delete(&WhatsAppProject{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		return tx.Model(&WhatsAppSession{}).
+			Where("user_id = ? AND project_id = ?", userID, projectID).
+			Update("project_id", nil).Error
+	})
+	return deleted, err
+}
+
+// GetProjectCounts counts the active sessions of each project of a user,
+// keyed by project
+func (dm *DatabaseManager) GetProjectCounts(userID int) (map[int64]ProjectCounts, error) {
+	var rows []ProjectCounts
+	err := dm.db.Model(&WhatsAppSession{}).
+		Select("project_id, COUNT(*) AS sessions, SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) AS connected", StatusConnected).
+		Where("user_id = ? AND project_id IS NOT NULL AND is_active = ?", userID, true).
+		Group("project_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[int64]ProjectCounts, len(rows))
+	for _, row := range rows {
+		counts[row.ProjectID] = row
+	}
+	return counts, nil
+}
+
+// SetSessionProject moves a session to a project, or out of its project
+// when projectID is nil
+func (dm *DatabaseManager) SetSessionProject(sessionID string, projectID *int64) error {
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID).
+		Update("project_id", projectID).Error
+}
+
+// ============= BACKUP REPOSITORY =============
+
+func (dm *DatabaseManager) CreateBackup(backup *WhatsAppBackup) error {
+	return dm.db.Create(backup).Error
+}
+
+// GetBackups lists the backups of an instance, or of all instances when
+// instance is empty, newest first
+func (dm *DatabaseManager) GetBackups(instance string) ([]WhatsAppBackup, error) {
+	query := dm.db.Order("created_at DESC, id DESC")
+	if instance != "" {
+		query = query.Where("instance = ?", instance)
+	}
+	var backups []WhatsAppBackup
+	err := query.Find(&backups).Error
+	return backups, err
+}
+
+func (dm *DatabaseManager) GetBackup(backupID int64) (*WhatsAppBackup, error) {
+	var backup WhatsAppBackup
+	if err := dm.db.First(&backup, backupID).Error; err != nil {
+		return nil, err
+	}
+	return &backup, nil
+}
+
+// GetLatestBackup returns the newest backup of an instance
+func (dm *DatabaseManager) GetLatestBackup(instance string) (*WhatsAppBackup, error) {
+	var backup WhatsAppBackup
+	err := dm.db.Where("instance = ?", instance).
+		Order("created_at DESC, id DESC").
+		First(&backup).Error
+	if err != nil {
+		return nil, err
+	}
+	return &backup, nil
+}
+
+func (dm *DatabaseManager) DeleteBackup(backupID int64) error {
+	return dm.db.Delete(&WhatsAppBackup{}, backupID).Error
+}
+
+// RestoreSessions inserts the given session rows that don't exist anymore
+// and returns how many it inserted
+func (dm *DatabaseManager) RestoreSessions(sessions []WhatsAppSession) (int64, error) {
+	if len(sessions) == 0 {
+		return 0, nil
+	}
+	result := dm.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&sessions)
+	return result.RowsAffected, result.Error
+}
+
+// ============= GROUP INVITE LINK REPOSITORY =============
+
+// RecordGroupInviteLink stores the current invite link of a group, marking
+// the group's other links revoked, and returns the stored link
+func (dm *DatabaseManager) RecordGroupInviteLink(sessionID string, userID int, groupJID, code string) (*WhatsAppGroupInviteLink, error) {
+	link := WhatsAppGroupInviteLink{
+		UserID:    userID,
+		SessionID: sessionID,
+		GroupJID:  groupJID,
+		Code:      code,
+	}
+	err := dm.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("session_id = ? AND group_jid = ? AND code = ?", sessionID, groupJID, code).
+			FirstOrCreate(&link).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&WhatsAppGroupInviteLink{}).
+			Where("session_id = ? AND group_jid = ? AND code <> ? AND revoked_at IS NULL", sessionID, groupJID, code).
+			Update("revoked_at", time.Now()).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// GetCurrentGroupInviteLink returns the newest link of a group not revoked
+func (dm *DatabaseManager) GetCurrentGroupInviteLink(sessionID, groupJID string) (*WhatsAppGroupInviteLink, error) {
+	var link WhatsAppGroupInviteLink
+	err := dm.db.Where("session_id = ? AND group_jid = ? AND revoked_at IS NULL", sessionID, groupJID).
+		Order("id DESC").
+		First(&link).Error
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// GetGroupInviteLinks lists the links of a group, newest first
+func (dm *DatabaseManager) GetGroupInviteLinks(sessionID, groupJID string) ([]WhatsAppGroupInviteLink, error) {
+	var links []WhatsAppGroupInviteLink
+	err := dm.db.Where("session_id = ? AND group_jid = ?", sessionID, groupJID).
+		Order("id DESC").
+		Find(&links).Error
+	return links, err
+}
+
+// AddGroupInviteJoins counts joins through a link
+func (dm *DatabaseManager) AddGroupInviteJoins(linkID int64, joins int, at time.Time) error {
+	return dm.db.Model(&WhatsAppGroupInviteLink{}).
+		Where("id = ?", linkID).
+		Updates(map[string]interface{}{
+			"joins":        gorm.Expr("joins + ?", joins),
+			"last_join_at": at,
+		}).Error
+}
+
+// ============= EVENT FILTER REPOSITORY =============
+
+// GetEventFilters lists the event filters of a user: the default one and
+// those of single sessions
+func (dm *DatabaseManager) GetEventFilters(userID int) ([]WhatsAppEventFilter, error) {
+	var filters []WhatsAppEventFilter
+	err := dm.db.Where("user_id = ?", userID).Order("session_id").Find(&filters).Error
+	return filters, err
+}
+
+// GetEventFilter returns the filter of a session, or the user's default
+// filter for an empty sessionID
+func (dm *DatabaseManager) GetEventFilter(userID int, sessionID string) (*WhatsAppEventFilter, error) {
+	var filter WhatsAppEventFilter
+	if err := dm.db.Where("user_id = ? AND session_id = ?", userID, sessionID).First(&filter).Error; err != nil {
+		return nil, err
+	}
+	return &filter, nil
+}
+
+// SaveEventFilter creates or replaces the rules of a filter
+func (dm *DatabaseManager) SaveEventFilter(filter *WhatsAppEventFilter) error {
+	return dm.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "session_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rules", "updated_at"}),
+	}).Create(filter).Error
+}
+
+// DeleteEventFilter removes a filter, returning the number of rows deleted
+func (dm *DatabaseManager) DeleteEventFilter(userID int, sessionID string) (int64, error) {
+	result := dm.db.Where("user_id = ? AND session_id = ?", userID, sessionID).Delete(&WhatsAppEventFilter{})
+	return result.RowsAffected, result.Error
+}
+
+// ============= INBOUND FILTER REPOSITORY =============
+
+// GetSessionInboundFilter returns the inbound filter settings of a session
+// (InboundFilter, InboundReply and InboundAllowSaved are set)
+func (dm *DatabaseManager) GetSessionInboundFilter(sessionID string) (*WhatsAppSession, error) {
+	var session WhatsAppSession
+	err := dm.db.Select("id", "inbound_filter", "inbound_reply", "inbound_allow_saved").
+		Where("id = ?", sessionID).
+		First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// UpdateSessionInboundFilter changes the inbound filter settings of a session
+func (dm *DatabaseManager) UpdateSessionInboundFilter(sessionID string, updates map[string]interface{}) error {
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID).
+		Updates(updates).Error
+}
+
+// SaveSenderRules puts senders on a list of a session, moving them from the
+// other list
+func (dm *DatabaseManager) SaveSenderRules(rules []WhatsAppSenderRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	return dm.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "session_id"}, {Name: "jid"}},
+		DoUpdates: clause.AssignmentColumns([]string{"list", "updated_at"}),
+	}).CreateInBatches(&rules, 200).Error
+}
+
+// GetSenderRules pages through the sender rules of a session
+// (filter: list; search: JID)
+func (dm *DatabaseManager) GetSenderRules(sessionID string, q ListQuery) ([]WhatsAppSenderRule, int64, error) {
+	var rules []WhatsAppSenderRule
+	total, err := dm.readPage(q, &rules, func(db *gorm.DB) *gorm.DB {
+		query := db.Model(&WhatsAppSenderRule{}).Where("session_id = ?", sessionID)
+		if list, ok := q.Filters["list"]; ok {
+			query = query.Where("list = ?", list)
+		}
+		if q.Search != "" {
+			query = dm.searchWhere(query, q.like(), "jid")
+		}
+		return query
+	})
+	return rules, total, err
+}
+
+// GetSenderList returns the list ("allow" or "deny") a sender given by any of
+// its JIDs is on, or "" when it isn't on either; deny wins
+func (dm *DatabaseManager) GetSenderList(sessionID string, jids []string) (string, error) {
+	var lists []string
+	err := dm.db.Model(&WhatsAppSenderRule{}).
+		Where("session_id = ? AND jid IN ?", sessionID, jids).
+		Pluck("list", &lists).Error
+	if err != nil {
+		return "", err
+	}
+	list := ""
+	for _, l := range lists {
+		if l == SenderListDeny {
+			return SenderListDeny, nil
+		}
+		list = l
+	}
+	return list, nil
+}
+
+// DeleteSenderRule takes a sender off the lists of a session, returning the
+// number of rows deleted
+func (dm *DatabaseManager) DeleteSenderRule(sessionID, jid string) (int64, error) {
+	result := dm.db.Where("session_id = ? AND jid = ?", sessionID, jid).Delete(&WhatsAppSenderRule{})
+	return result.RowsAffected, result.Error
+}
+
+// ============= SESSION PURGE REPOSITORY =============
+
+// sessionPurgeTables are the tables holding data of a single session, by the
+// name purge results report them under
+var sessionPurgeTables = []struct {
+	Name  string
+	Model interface{}
+}{
+	{"messages", &WhatsAppMessage{}},
+	{"chats", &WhatsAppChat{}},
+	{"events", &WhatsAppEvent{}},
+	{"calls", &WhatsAppCall{}},
+	{"groups", &WhatsAppGroup{}},
+	{"group_schedules", &WhatsAppGroupSchedule{}},
+	{"group_daily_stats", &WhatsAppGroupDailyStat{}},
+	{"group_invite_links", &WhatsAppGroupInviteLink{}},
+	{"group_sync_jobs", &WhatsAppGroupSyncJob{}},
+	{"avatars", &WhatsAppAvatar{}},
+	{"picture_changes", &WhatsAppPictureChange{}},
+	{"blocked_contacts", &WhatsAppBlockedContact{}},
+	{"media_handles", &WhatsAppMediaHandle{}},
+	{"status_posts", &WhatsAppStatusPost{}},
+	{"outbox_messages", &WhatsAppOutboxMessage{}},
+	{"send_intents", &WhatsAppSendIntent{}},
+	{"safety_counters", &WhatsAppSafetyCounter{}},
+	{"qr_attempts", &WhatsAppQRAttempt{}},
+	{"chat_exports", &WhatsAppChatExport{}},
+	{"event_filters", &WhatsAppEventFilter{}},
+	{"auto_reply_rules", &WhatsAppAutoReplyRule{}},
+	{"scoring_rules", &WhatsAppScoringRule{}},
+	{"sender_rules", &WhatsAppSenderRule{}},
+	{"notes", &WhatsAppNote{}},
+	{"sequences", &WhatsAppSequence{}},
+	{"sequence_enrollments", &WhatsAppSequenceEnrollment{}},
+}
+
+// GetDeletedSession returns a deleted session of a user that hasn't been
+// purged yet
+func (dm *DatabaseManager) GetDeletedSession(sessionID string, userID int) (*WhatsAppSession, error) {
+	var session WhatsAppSession
+	err := dm.db.Unscoped().
+		Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", sessionID, userID).
+		First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetPurgeDueSessions returns sessions deleted before a time, oldest first
+func (dm *DatabaseManager) GetPurgeDueSessions(deletedBefore time.Time, limit int) ([]WhatsAppSession, error) {
+	var sessions []WhatsAppSession
+	err := dm.db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", deletedBefore).
+		Order("deleted_at ASC").
+		Limit(limit).
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// SetSessionPurgeToken stores the hash of a deleted session's purge
+// confirmation token
+func (dm *DatabaseManager) SetSessionPurgeToken(sessionID, tokenHash string, expiresAt time.Time) error {
+	return dm.db.Unscoped().Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID).
+		Updates(map[string]interface{}{
+			"purge_token_hash":   tokenHash,
+			"purge_token_expiry": expiresAt,
+		}).Error
+}
+
+// CountSessionData counts the rows a purge of a session removes, by table
+func (dm *DatabaseManager) CountSessionData(sessionID string) (map[string]int64, error) {
+	counts := make(map[string]int64, len(sessionPurgeTables)+4)
+	for _, table := range sessionPurgeTables {
+		var count int64
+		if err := dm.db.Model(table.Model).Where("session_id = ?", sessionID).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		counts[table.Name] = count
+	}
+
+	var count int64
+	if err := dm.db.Model(&WhatsAppBroadcastList{}).Where("session_id = ?", sessionID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	counts["broadcast_lists"] = count
+	if err := dm.db.Model(&WhatsAppCampaign{}).Where("session_id = ?", sessionID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	counts["campaigns"] = count
+	if err := dm.db.Model(&WhatsAppChannel{}).
+		Where("primary_session_id = ? OR backup_session_id = ?", sessionID, sessionID).
+		Count(&count).Error; err != nil {
+		return nil, err
+	}
+	counts["channels"] = count
+	return counts, nil
+}
+
+// SessionPurgeCounts are the rows a session purge removed and anonymized, by
+// table
+type SessionPurgeCounts struct {
+	Deleted    map[string]int64 `json:"deleted"`
+	Anonymized map[string]int64 `json:"anonymized"`
+}
+
+// PurgeSession removes the data of a deleted session and the session row.
+// The tables are purged one statement at a time rather than in one long
+// transaction; the session row goes last, so an interrupted purge is
+// finished by the next one. The user's contacts go with their last session,
+// since they're shared by all of them.
+func (dm *DatabaseManager) PurgeSession(sessionID string, userID int) (*SessionPurgeCounts, error) {
+	counts := &SessionPurgeCounts{
+		Deleted:    make(map[string]int64, len(sessionPurgeTables)+6),
+		Anonymized: make(map[string]int64, 1),
+	}
+
+	var blocked []string
+	if err := dm.db.Model(&WhatsAppBlockedContact{}).Where("session_id = ?", sessionID).Pluck("jid", &blocked).Error; err != nil {
+		return nil, err
+	}
+
+	for _, table := range sessionPurgeTables {
+		result := dm.db.Where("session_id = ?", sessionID).Delete(table.Model)
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to purge %s: %w", table.Name, result.Error)
+		}
+		counts.Deleted[table.Name] = result.RowsAffected
+	}
+	if err := refreshContactsBlocked(dm.db, userID, blocked); err != nil {
+		return nil, err
+	}
+
+	lists := dm.db.Model(&WhatsAppBroadcastList{}).Select("id").Where("session_id = ?", sessionID)
+	result := dm.db.Where("list_id IN (?)", lists).Delete(&WhatsAppBroadcastRecipient{})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to purge broadcast_recipients: %w", result.Error)
+	}
+	counts.Deleted["broadcast_recipients"] = result.RowsAffected
+	if result = dm.db.Where("session_id = ?", sessionID).Delete(&WhatsAppBroadcastList{}); result.Error != nil {
+		return nil, fmt.Errorf("failed to purge broadcast_lists: %w", result.Error)
+	}
+	counts.Deleted["broadcast_lists"] = result.RowsAffected
+
+	campaigns := dm.db.Model(&WhatsAppCampaign{}).Select("id").Where("session_id = ?", sessionID)
+	if result = dm.db.Where("campaign_id IN (?)", campaigns).Delete(&WhatsAppCampaignRecipient{}); result.Error != nil {
+		return nil, fmt.Errorf("failed to purge campaign_recipients: %w", result.Error)
+	}
+	counts.Deleted["campaign_recipients"] = result.RowsAffected
+	if result = dm.db.Where("session_id = ?", sessionID).Delete(&WhatsAppCampaign{}); result.Error != nil {
+		return nil, fmt.Errorf("failed to purge campaigns: %w", result.Error)
+	}
+	counts.Deleted["campaigns"] = result.RowsAffected
+
+	if result = dm.db.Where("primary_session_id = ? OR backup_session_id = ?", sessionID, sessionID).
+		Delete(&WhatsAppChannel{}); result.Error != nil {
+		return nil, fmt.Errorf("failed to purge channels: %w", result.Error)
+	}
+	counts.Deleted["channels"] = result.RowsAffected
+
+	// The audit trail is kept without what identifies the caller or the
+	// people addressed
+	result = dm.db.Model(&WhatsAppAuditLog{}).
+		Where("session_id = ?", sessionID).
+		Updates(map[string]interface{}{
+			"path":       gorm.Expr("endpoint"),
+			"request":    nil,
+			"ip_address": "",
+			"user_agent": "",
+		})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to anonymize audit_logs: %w", result.Error)
+	}
+	counts.Anonymized["audit_logs"] = result.RowsAffected
+
+	var others int64
+	if err := dm.db.Unscoped().Model(&WhatsAppSession{}).
+		Where("user_id = ? AND id <> ?", userID, sessionID).
+		Count(&others).Error; err != nil {
+		return nil, err
+	}
+	if others == 0 {
+		if result = dm.db.Where("user_id = ?", userID).Delete(&WhatsAppContact{}); result.Error != nil {
+			return nil, fmt.Errorf("failed to purge contacts: %w", result.Error)
+		}
+		counts.Deleted["contacts"] = result.RowsAffected
+	}
+
+	if err := dm.db.Unscoped().Where("id = ? AND user_id = ?", sessionID, userID).Delete(&WhatsAppSession{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to purge session: %w", err)
+	}
+	return counts, nil
+}
+
+// ============= JID CACHE REPOSITORY =============
+
+func (dm *DatabaseManager) GetJIDCache(phone string, checkedAfter time.Time) (*WhatsAppJIDCache, error) {
+	var entry WhatsAppJIDCache
+	err := dm.db.Where("phone = ? AND checked_at > ?", phone, checkedAfter).
+		First(&entry).Error
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (dm *DatabaseManager) SaveJIDCache(entry *WhatsAppJIDCache) error {
+	return dm.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "phone"}},
+		DoUpdates: clause.AssignmentColumns([]string{"jid", "registered", "checked_at"}),
+	}).Create(entry).Error
+}
+
+func (dm *DatabaseManager) PurgeJIDCache(checkedBefore time.Time) (int64, error) {
+	result := dm.db.Where("checked_at < ?", checkedBefore).Delete(&WhatsAppJIDCache{})
+	return result.RowsAffected, result.Error
+}
+
+// GetNumberInfos returns the cached profiles of the given phones checked
+// after checkedAfter, keyed by phone
+func (dm *DatabaseManager) GetNumberInfos(phones []string, checkedAfter time.Time) (map[string]WhatsAppNumberInfo, error) {
+	infos := make(map[string]WhatsAppNumberInfo, len(phones))
+	if len(phones) == 0 {
+		return infos, nil
+	}
+
+	var entries []WhatsAppNumberInfo
+	err := dm.db.Where("phone IN ? AND checked_at > ?", phones, checkedAfter).
+		Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		infos[entry.Phone] = entry
+	}
+	return infos, nil
+}
+
+func (dm *DatabaseManager) SaveNumberInfos(entries []WhatsAppNumberInfo) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return dm.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "phone"}},
+		DoUpdates: clause.AssignmentColumns([]string{"is_business", "business_name", "has_picture", "devices", "checked_at"}),
+	}).Create(&entries).Error
+}
+
+// ============= LID MAPPING REPOSITORY =============
+
+// SaveLIDMappings stores LID/phone number pairs; a LID seen with another
+// number is moved to it
+func (dm *DatabaseManager) SaveLIDMappings(mappings []WhatsAppLIDMapping) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+	return dm.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "lid"}},
+		DoUpdates: clause.AssignmentColumns([]string{"phone_jid", "updated_at"}),
+	}).CreateInBatches(&mappings, contactLookupBatch).Error
+}
+
+// GetLIDMappings returns the mappings of the given LIDs and phone number JIDs
+func (dm *DatabaseManager) GetLIDMappings(jids []string) ([]WhatsAppLIDMapping, error) {
+	users := make([]string, 0, len(jids))
+	for _, jid := range jids {
+		if strings.HasSuffix(jid, "@"+types.HiddenUserServer) || strings.HasSuffix(jid, "@"+types.DefaultUserServer) {
+			users = append(users, jid)
+		}
+	}
+
+	var mappings []WhatsAppLIDMapping
+	for start := 0; start < len(users); start += contactLookupBatch {
+		end := start + contactLookupBatch
+		if end > len(users) {
+			end = len(users)
+		}
+
+		var batch []WhatsAppLIDMapping
+		err := dm.db.Where("lid IN ? OR phone_jid IN ?", users[start:end], users[start:end]).
+			Find(&batch).Error
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, batch...)
+	}
+	return mappings, nil
+}
+
+// GetJIDAliases returns the given JIDs followed by the other identifier of
+// each mapped LID or phone number JID
+func (dm *DatabaseManager) GetJIDAliases(jids []string) ([]string, error) {
+	mappings, err := dm.GetLIDMappings(jids)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := make([]string, 0, len(jids)+len(mappings))
+	seen := make(map[string]bool, len(jids)+len(mappings))
+	for _, jid := range jids {
+		if !seen[jid] {
+			seen[jid] = true
+			aliases = append(aliases, jid)
+		}
+	}
+	for _, mapping := range mappings {
+		for _, jid := range []string{mapping.LID, mapping.PhoneJID} {
+			if !seen[jid] {
+				seen[jid] = true
+				aliases = append(aliases, jid)
+			}
+		}
+	}
+	return aliases, nil
+}
+
+// jidAliases is GetJIDAliases for one JID; if the mappings can't be loaded
+// only the JID itself is matched
+func (dm *DatabaseManager) jidAliases(jid string) []string {
+	aliases, err := dm.GetJIDAliases([]string{jid})
+	if err != nil {
+		log.Printf("⚠️  Failed to load the LID mapping of %s: %v", jid, err)
+		return []string{jid}
+	}
+	return aliases
+}
+
+// CanonicalContactJID returns the phone number JID of a mapped LID; other
+// JIDs are returned as they are
+func (dm *DatabaseManager) CanonicalContactJID(jid string) string {
+	if !strings.HasSuffix(jid, "@"+types.HiddenUserServer) {
+		return jid
+	}
+	var mapping WhatsAppLIDMapping
+	if err := dm.db.Where("lid = ?", jid).First(&mapping).Error; err != nil {
+		return jid
+	}
+	return mapping.PhoneJID
+}
+
+// MergeLIDContacts moves the contacts, contact tags, contact notes and
+// scores stored under a mapped LID to its phone number JID. A user who already has the phone number
+// contact keeps that row, completed with the name, group and blocked state of
+// the LID row, which is removed. Returns the number of LID contacts merged.
+func (dm *DatabaseManager) MergeLIDContacts(mappings []WhatsAppLIDMapping) (int, error) {
+	phones := make(map[string]string, len(mappings))
+	lids := make([]string, 0, len(mappings))
+	for _, mapping := range mappings {
+		phones[mapping.LID] = mapping.PhoneJID
+		lids = append(lids, mapping.LID)
+	}
+
+	merged := 0
+	for start := 0; start < len(lids); start += contactLookupBatch {
+		end := start + contactLookupBatch
+		if end > len(lids) {
+			end = len(lids)
+		}
+		batch := lids[start:end]
+
+		var contacts []WhatsAppContact
+		if err := dm.db.Where("jid IN ?", batch).Find(&contacts).Error; err != nil {
+			return merged, err
+		}
+		var tags []WhatsAppContactTag
+		if err := dm.db.Where("contact_jid IN ?", batch).Find(&tags).Error; err != nil {
+			return merged, err
+		}
+		var notes []WhatsAppNote
+		if err := dm.db.Where("target_type = ? AND target_jid IN ?", NoteTargetContact, batch).Find(&notes).Error; err != nil {
+			return merged, err
+		}
+		var scores []WhatsAppContactScore
+		if err := dm.db.Where("jid IN ?", batch).Find(&scores).Error; err != nil {
+			return merged, err
+		}
+		if len(contacts) == 0 && len(tags) == 0 && len(notes) == 0 && len(scores) == 0 {
+			continue
+		}
+
+		err := dm.db.Transaction(func(tx *gorm.DB) error {
+			for _, contact := range contacts {
+				if err := mergeLIDContact(tx, contact, phones[contact.JID]); err != nil {
+					return err
+				}
+			}
+			for _, note := range notes {
+				if err := tx.Model(&WhatsAppNote{}).Where("id = ?", note.ID).
+					Update("target_jid", phones[note.TargetJID]).Error; err != nil {
+					return err
+				}
+			}
+			for _, score := range scores {
+				if err := mergeLIDScore(tx, score, phones[score.JID]); err != nil {
+					return err
+				}
+			}
+			for _, tag := range tags {
+				row := WhatsAppContactTag{UserID: tag.UserID, ContactJID: phones[tag.ContactJID], Tag: tag.Tag, CreatedAt: tag.CreatedAt}
+				if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+					return err
+				}
+			}
+			if len(tags) == 0 {
+				return nil
+			}
+			return tx.Where("contact_jid IN ?", batch).Delete(&WhatsAppContactTag{}).Error
+		})
+		if err != nil {
+			return merged, err
+		}
+		merged += len(contacts)
+	}
+	return merged, nil
+}
+
+// mergeLIDScore moves the score of a LID to its phone number JID, adding it
+// to the phone number's score if there is one
+func mergeLIDScore(tx *gorm.DB, score WhatsAppContactScore, phoneJID string) error {
+	result := tx.Model(&WhatsAppContactScore{}).
+		Where("user_id = ? AND jid = ?", score.UserID, phoneJID).
+		Update("score", gorm.Expr("score + ?", score.Score))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return tx.Model(&WhatsAppContactScore{}).Where("id = ?", score.ID).Update("jid", phoneJID).Error
+	}
+	if score.HotLeadAt != nil {
+		err := tx.Model(&WhatsAppContactScore{}).
+			Where("user_id = ? AND jid = ? AND hot_lead_at IS NULL", score.UserID, phoneJID).
+			Update("hot_lead_at", score.HotLeadAt).Error
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Where("id = ?", score.ID).Delete(&WhatsAppContactScore{}).Error
+}
+
+// mergeLIDContact moves one LID contact row to its phone number JID
+func mergeLIDContact(tx *gorm.DB, contact WhatsAppContact, phoneJID string) error {
+	var existing WhatsAppContact
+	err := tx.Where("user_id = ? AND jid = ?", contact.UserID, phoneJID).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		number := parseContact(phoneJID, contact.FullName, contact.UserID)
+		return tx.Model(&WhatsAppContact{}).Where("id = ?", contact.ID).Updates(map[string]interface{}{
+			"jid":           phoneJID,
+			"country_code":  number.CountryCode,
+			"mobile_number": number.MobileNumber,
+			"updated_at":    time.Now(),
+		}).Error
+	} else if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{}
+	if existing.FullName == "" && contact.FullName != "" {
+		updates["full_name"] = contact.FullName
+		updates["first_name"] = contact.FirstName
+		updates["last_name"] = contact.LastName
+	}
+	if existing.GroupID == nil && contact.GroupID != nil {
+		updates["group_id"] = contact.GroupID
+		updates["is_group_member"] = contact.IsGroupMember
+	}
+	if contact.IsBlocked && !existing.IsBlocked {
+		updates["is_blocked"] = true
+	}
+	if len(updates) > 0 {
+		updates["updated_at"] = time.Now()
+		if err := tx.Model(&WhatsAppContact{}).Where("id = ?", existing.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Delete(&WhatsAppContact{}, contact.ID).Error
+}
+
+// ============= MEDIA HANDLE REPOSITORY =============
+
+func (dm *DatabaseManager) CreateMediaHandle(handle *WhatsAppMediaHandle) error {
+	return dm.db.Create(handle).Error
+}
+
+func (dm *DatabaseManager) GetMediaHandle(id string, userID int) (*WhatsAppMediaHandle, error) {
+	var handle WhatsAppMediaHandle
+	err := dm.db.Where("id = ? AND user_id = ?", id, userID).
+		First(&handle).Error
+	if err != nil {
+		return nil, err
+	}
+	return &handle, nil
+}
+
+func (dm *DatabaseManager) DeleteExpiredMediaHandles(before time.Time) (int64, error) {
+	result := dm.db.Where("expires_at < ?", before).Delete(&WhatsAppMediaHandle{})
+	return result.RowsAffected, result.Error
+}
+
+// ============= OUTBOX REPOSITORY =============
+
+// CreateOutboxMessage queues a message. created is false when a message with
+// the same idempotency key already exists; existing is then returned instead.
+func (dm *DatabaseManager) CreateOutboxMessage(msg *WhatsAppOutboxMessage) (existing *WhatsAppOutboxMessage, created bool, err error) {
+	result := dm.db.Clauses(clause.OnConflict{DoNothing: true}).Create(msg)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return msg, true, nil
+	}
+
+	existing, err = dm.GetOutboxMessageByKey(msg.UserID, msg.IdempotencyKey)
+	return existing, false, err
+}
+
+func (dm *DatabaseManager) GetOutboxMessage(id int64, userID int) (*WhatsAppOutboxMessage, error) {
+	var msg WhatsAppOutboxMessage
+	err := dm.db.Where("id = ? AND user_id = ?", id, userID).
+		First(&msg).Error
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (dm *DatabaseManager) GetOutboxMessageByKey(userID int, idempotencyKey string) (*WhatsAppOutboxMessage, error) {
+	var msg WhatsAppOutboxMessage
+	err := dm.db.Where("user_id = ? AND idempotency_key = ?", userID, idempotencyKey).
+		First(&msg).Error
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// ClaimOutboxMessages marks up to limit due messages as sending and returns
+// them. Messages stuck in sending since before staleBefore (e.g. after a
+// crash) are claimed again. The attempts counter doubles as an optimistic
+// lock so concurrent workers never claim the same message.
+func (dm *DatabaseManager) ClaimOutboxMessages(limit int, staleBefore time.Time) ([]WhatsAppOutboxMessage, error) {
+	var candidates []WhatsAppOutboxMessage
+	err := dm.db.Where("(status = ? AND next_attempt_at <= ?) OR (status = ? AND updated_at < ?)",
+		OutboxQueued, time.Now(), OutboxSending, staleBefore).
+		Order("id ASC").
+		Limit(limit).
+		Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := make([]WhatsAppOutboxMessage, 0, len(candidates))
+	for _, msg := range candidates {
+		result := dm.db.Model(&WhatsAppOutboxMessage{}).
+			Where("id = ? AND status = ? AND attempts = ?", msg.ID, msg.Status, msg.Attempts).
+			Updates(map[string]interface{}{
+				"status":   OutboxSending,
+				"attempts": msg.Attempts + 1,
+			})
+		if result.Error != nil {
+			return claimed, result.Error
+		}
+		if result.RowsAffected == 1 {
+			msg.Status = OutboxSending
+			msg.Attempts++
+			claimed = append(claimed, msg)
+		}
+	}
+	return claimed, nil
+}
+
+// ExpireOutboxMessages fails the queued messages whose expiry has passed and
+// returns them
+func (dm *DatabaseManager) ExpireOutboxMessages(now time.Time, reason string) ([]WhatsAppOutboxMessage, error) {
+	var candidates []WhatsAppOutboxMessage
+	err := dm.db.Where("status = ? AND expires_at <= ?", OutboxQueued, now).
+		Order("id ASC").
+		Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	expired := make([]WhatsAppOutboxMessage, 0, len(candidates))
+	for _, msg := range candidates {
+		// A worker may have claimed it meanwhile
+		result := dm.db.Model(&WhatsAppOutboxMessage{}).
+			Where("id = ? AND status = ?", msg.ID, OutboxQueued).
+			Updates(map[string]interface{}{
+				"status": OutboxFailed,
+				"error":  reason,
+			})
+		if result.Error != nil {
+			return expired, result.Error
+		}
+		if result.RowsAffected == 1 {
+			msg.Status = OutboxFailed
+			msg.Error = reason
+			expired = append(expired, msg)
+		}
+	}
+	return expired, nil
+}
+
+// CountPendingOutbox counts the queued and in-flight outbox messages of a session
+func (dm *DatabaseManager) CountPendingOutbox(sessionID string) (int64, error) {
+	var count int64
+	err := dm.db.Model(&WhatsAppOutboxMessage{}).
+		Where("session_id = ? AND status IN ?", sessionID, []OutboxStatus{OutboxQueued, OutboxSending}).
+		Count(&count).Error
+	return count, err
+}
+
+func (dm *DatabaseManager) UpdateOutboxMessage(id int64, updates map[string]interface{}) error {
+	return dm.db.Model(&WhatsAppOutboxMessage{}).
+		Where("id = ?", id).
+		Updates(updates).Error
+}
+
+// ============= SUPPRESSION REPOSITORY =============
+
+// AddSuppression stores an opt-out, keeping the original entry if one exists;
+// a number that was already suppressed is reported as not added
+func (dm *DatabaseManager) AddSuppression(suppression *WhatsAppSuppression) (bool, error) {
+	result := dm.db.Clauses(clause.OnConflict{DoNothing: true}).Create(suppression)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (dm *DatabaseManager) RemoveSuppression(userID int, phone string) (int64, error) {
+	result := dm.db.Where("user_id = ? AND phone = ?", userID, phone).Delete(&WhatsAppSuppression{})
+	return result.RowsAffected, result.Error
+}
+
+// GetSuppressions pages through the suppression list of a user
+// (filter: reason; search: phone number)
+func (dm *DatabaseManager) GetSuppressions(userID int, q ListQuery) ([]WhatsAppSuppression, int64, error) {
+	query := dm.db.Model(&WhatsAppSuppression{}).Where("user_id = ?", userID)
+	if reason, ok := q.Filters["reason"]; ok {
+		query = query.Where("reason = ?", reason)
+	}
+	if q.Search != "" {
+		query = dm.searchWhere(query, q.like(), "phone")
+	}
+
+	var suppressions []WhatsAppSuppression
+	total, err := findPage(query, q, &suppressions)
+	return suppressions, total, err
+}
+
+func (dm *DatabaseManager) IsSuppressed(userID int, phone string) (bool, error) {
+	var count int64
+	err := dm.db.Model(&WhatsAppSuppression{}).
+		Where("user_id = ? AND phone = ?", userID, phone).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// ============= SAFETY COUNTER REPOSITORY =============
+
+func (dm *DatabaseManager) GetSafetyCounter(sessionID, day string) (*WhatsAppSafetyCounter, error) {
+	counter := WhatsAppSafetyCounter{SessionID: sessionID, Day: day}
+	err := dm.db.Where("session_id = ? AND day = ?", sessionID, day).
+		Limit(1).
+		Find(&counter).Error
+	return &counter, err
+}
+
+// IncrementSafetyCounter adds to the day's sent and failed counts
+func (dm *DatabaseManager) IncrementSafetyCounter(sessionID, day string, sent, failed int) error {
+	counter := WhatsAppSafetyCounter{SessionID: sessionID, Day: day, Sent: sent, Failed: failed}
+	return dm.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "session_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"sent":       gorm.Expr("sent + ?", sent),
+			"failed":     gorm.Expr("failed + ?", failed),
+			"updated_at": time.Now(),
+		}),
+	}).Create(&counter).Error
+}
+
+// ============= CONTACT LIST REPOSITORY =============
+
+// CreateContactList stores a list together with its members
+func (dm *DatabaseManager) CreateContactList(list *WhatsAppContactList) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		members := list.Members
+		list.Members = nil
+		if err := tx.Create(list).Error; err != nil {
+			return err
+		}
+		for i := range members {
+			members[i].ListID = list.ID
+		}
+		if len(members) > 0 {
+			if err := tx.CreateInBatches(members, 500).Error; err != nil {
+				return err
+			}
+		}
+		list.Members = members
+		return nil
+	})
+}
+
+func (dm *DatabaseManager) ContactListExists(userID int, name string) (bool, error) {
+	var count int64
+	err := dm.db.Model(&WhatsAppContactList{}).
+		Where("user_id = ? AND name = ?", userID, name).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (dm *DatabaseManager) GetContactLists(userID int) ([]WhatsAppContactList, error) {
+	var lists []WhatsAppContactList
+	err := dm.db.Where("user_id = ?", userID).
+		Order("name ASC").
+		Find(&lists).Error
+	return lists, err
+}
+
+func (dm *DatabaseManager) GetContactList(listID int64, userID int) (*WhatsAppContactList, error) {
+	var list WhatsAppContactList
+	err := dm.db.Where("id = ? AND user_id = ?", listID, userID).
+		First(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// GetContactListMembers returns the members of a list, optionally filtered by status
+func (dm *DatabaseManager) GetContactListMembers(listID int64, status ContactListMemberStatus) ([]WhatsAppContactListMember, error) {
+	var members []WhatsAppContactListMember
+	query := dm.db.Where("list_id = ?", listID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err := query.Order("line ASC").Find(&members).Error
+	return members, err
+}
+
+func (dm *DatabaseManager) DeleteContactList(listID int64, userID int) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", listID, userID).
+			Delete(&WhatsAppContactList{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("list_id = ?", listID).Delete(&WhatsAppContactListMember{}).Error
+	})
+}
+
+// ============= CAMPAIGN REPOSITORY =============
+
+// CreateCampaign stores a campaign together with its recipients
+func (dm *DatabaseManager) CreateCampaign(campaign *WhatsAppCampaign, recipients []WhatsAppCampaignRecipient) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(campaign).Error; err != nil {
+			return err
+		}
+		for i := range recipients {
+			recipients[i].CampaignID = campaign.ID
+		}
+		return tx.CreateInBatches(recipients, 500).Error
+	})
+}
+
+// GetCampaigns pages through the campaigns of a user
+// (filters: session_id, status; search: name)
+func (dm *DatabaseManager) GetCampaigns(userID int, q ListQuery) ([]WhatsAppCampaign, int64, error) {
+	query := dm.db.Model(&WhatsAppCampaign{}).Where("user_id = ?", userID)
+	if sessionID, ok := q.Filters["session_id"]; ok {
+		query = query.Where("session_id = ?", sessionID)
+	}
+	if status, ok := q.Filters["status"]; ok {
+		query = query.Where("status = ?", status)
+	}
+	if q.Search != "" {
+		query = dm.searchWhere(query, q.like(), "name")
+	}
+
+	var campaigns []WhatsAppCampaign
+	total, err := findPage(query, q, &campaigns)
+	return campaigns, total, err
+}
+
+func (dm *DatabaseManager) GetCampaign(campaignID int64, userID int) (*WhatsAppCampaign, error) {
+	var campaign WhatsAppCampaign
+	err := dm.db.Where("id = ? AND user_id = ?", campaignID, userID).
+		First(&campaign).Error
+	if err != nil {
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+// GetDueCampaigns returns running campaigns whose next batch may be sent
+func (dm *DatabaseManager) GetDueCampaigns(now time.Time) ([]WhatsAppCampaign, error) {
+	var campaigns []WhatsAppCampaign
+	err := dm.db.Where("status = ? AND next_run_at <= ?", CampaignRunning, now).
+		Order("next_run_at ASC").
+		Find(&campaigns).Error
+	return campaigns, err
+}
+
+func (dm *DatabaseManager) UpdateCampaign(campaignID int64, updates map[string]interface{}) error {
+	return dm.db.Model(&WhatsAppCampaign{}).
+		Where("id = ?", campaignID).
+		Updates(updates).Error
+}
+
+// GetCampaignRecipients returns recipients of a campaign, optionally filtered
+// by status. limit <= 0 returns all of them.
+func (dm *DatabaseManager) GetCampaignRecipients(campaignID int64, status CampaignRecipientStatus, limit int) ([]WhatsAppCampaignRecipient, error) {
+	var recipients []WhatsAppCampaignRecipient
+	query := dm.db.Where("campaign_id = ?", campaignID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Order("id ASC").Find(&recipients).Error
+	return recipients, err
+}
+
+func (dm *DatabaseManager) UpdateCampaignRecipient(recipientID int64, updates map[string]interface{}) error {
+	return dm.db.Model(&WhatsAppCampaignRecipient{}).
+		Where("id = ?", recipientID).
+		Updates(updates).Error
+}
+
+// RequeueFailedCampaignRecipients moves the recipients of a campaign that
+// failed for a retryable reason back to pending and restarts the campaign;
+// it returns how many were requeued
+func (dm *DatabaseManager) RequeueFailedCampaignRecipients(campaignID int64) (int64, error) {
+	var requeued int64
+	err := dm.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&WhatsAppCampaignRecipient{}).
+			Where("campaign_id = ? AND status = ? AND permanent = ?", campaignID, CampaignRecipientFailed, false).
+			Updates(map[string]interface{}{"status": CampaignRecipientPending, "error": ""})
+		if result.Error != nil {
+			return result.Error
+		}
+		requeued = result.RowsAffected
+		if requeued == 0 {
+			return nil
+		}
+		return tx.Model(&WhatsAppCampaign{}).
+			Where("id = ?", campaignID).
+			Updates(map[string]interface{}{
+				"failed":       gorm.Expr("failed - ?", requeued),
+				"status":       CampaignRunning,
+				"next_run_at":  time.Now(),
+				"last_error":   "",
+				"completed_at": nil,
+			}).Error
+	})
+	return requeued, err
+}
+
+// GetCampaignsByID returns campaigns by ID regardless of their user
+func (dm *DatabaseManager) GetCampaignsByID(campaignIDs []int64) ([]WhatsAppCampaign, error) {
+	var campaigns []WhatsAppCampaign
+	err := dm.db.Where("id IN ?", campaignIDs).Find(&campaigns).Error
+	return campaigns, err
+}
+
+// GetCampaignRecipientsByMessageIDs returns the recipients of a session's
+// campaigns that were sent one of the messages
+func (dm *DatabaseManager) GetCampaignRecipientsByMessageIDs(sessionID string, messageIDs []string) ([]WhatsAppCampaignRecipient, error) {
+	var recipients []WhatsAppCampaignRecipient
+	err := dm.db.Model(&WhatsAppCampaignRecipient{}).
+		Joins("JOIN whats_app_campaigns ON whats_app_campaigns.id = whats_app_campaign_recipients.campaign_id").
+		Where("whats_app_campaigns.session_id = ? AND whats_app_campaign_recipients.message_id IN ?", sessionID, messageIDs).
+		Find(&recipients).Error
+	return recipients, err
+}
+
+// MarkCampaignRecipientReceipt stores a delivery or read receipt of a
+// recipient (a read implies delivery); it reports which of the two were new
+func (dm *DatabaseManager) MarkCampaignRecipientReceipt(recipientID int64, read bool, at time.Time) (delivered, seen bool, err error) {
+	result := dm.db.Model(&WhatsAppCampaignRecipient{}).
+		Where("id = ? AND delivered_at IS NULL", recipientID).
+		Update("delivered_at", at)
+	if result.Error != nil {
+		return false, false, result.Error
+	}
+	delivered = result.RowsAffected > 0
+	if !read {
+		return delivered, false, nil
+	}
+
+	result = dm.db.Model(&WhatsAppCampaignRecipient{}).
+		Where("id = ? AND read_at IS NULL", recipientID).
+		Update("read_at", at)
+	if result.Error != nil {
+		return delivered, false, result.Error
+	}
+	return delivered, result.RowsAffected > 0, nil
+}
+
+// ============= STATUS POST REPOSITORY =============
+
+func (dm *DatabaseManager) CreateStatusPost(post *WhatsAppStatusPost) error {
+	return dm.db.Create(post).Error
+}
+
+// GetStatusPosts pages through the status posts of a session
+// (filters: status)
+func (dm *DatabaseManager) GetStatusPosts(sessionID string, userID int, q ListQuery) ([]WhatsAppStatusPost, int64, error) {
+	query := dm.db.Model(&WhatsAppStatusPost{}).Where("session_id = ? AND user_id = ?", sessionID, userID)
+	if status, ok := q.Filters["status"]; ok {
+		query = query.Where("status = ?", status)
+	}
+	if q.Search != "" {
+		query = dm.searchWhere(query, q.like(), "text")
+	}
+
+	var posts []WhatsAppStatusPost
+	total, err := findPage(query, q, &posts)
+	return posts, total, err
+}
+
+func (dm *DatabaseManager) GetStatusPost(postID int64, sessionID string, userID int) (*WhatsAppStatusPost, error) {
+	var post WhatsAppStatusPost
+	err := dm.db.Where("id = ? AND session_id = ? AND user_id = ?", postID, sessionID, userID).
+		First(&post).Error
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// GetDueStatusPosts returns scheduled status posts whose time has come
+func (dm *DatabaseManager) GetDueStatusPosts(now time.Time, limit int) ([]WhatsAppStatusPost, error) {
+	var posts []WhatsAppStatusPost
+	err := dm.db.Where("status = ? AND scheduled_at <= ?", StatusPostScheduled, now).
+		Order("scheduled_at").
+		Limit(limit).
+		Find(&posts).Error
+	return posts, err
+}
+
+// GetExpiredStatusPosts returns posted statuses past their expiry
+func (dm *DatabaseManager) GetExpiredStatusPosts(now time.Time) ([]WhatsAppStatusPost, error) {
+	var posts []WhatsAppStatusPost
+	err := dm.db.Where("status = ? AND expires_at <= ?", StatusPostPosted, now).
+		Find(&posts).Error
+	return posts, err
+}
+
+// TransitionStatusPost moves a status post from one state to another and
+// reports whether it was still in the expected state
+func (dm *DatabaseManager) TransitionStatusPost(postID int64, from StatusPostState, updates map[string]interface{}) (bool, error) {
+	result := dm.db.Model(&WhatsAppStatusPost{}).
+		Where("id = ? AND status = ?", postID, from).
+		Updates(updates)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ResetInterruptedStatusPosts puts posts left posting by a restart back on
+// the schedule
+func (dm *DatabaseManager) ResetInterruptedStatusPosts() (int64, error) {
+	result := dm.db.Model(&WhatsAppStatusPost{}).
+		Where("status = ?", StatusPostPosting).
+		Update("status", StatusPostScheduled)
+	return result.RowsAffected, result.Error
+}
+
+// StatusMediaInUse reports whether a scheduled or live status post still
+// uses a stored media file
+func (dm *DatabaseManager) StatusMediaInUse(mediaKey string) (bool, error) {
+	var count int64
+	err := dm.db.Model(&WhatsAppStatusPost{}).
+		Where("media_key = ? AND status IN ?", mediaKey,
+			[]StatusPostState{StatusPostScheduled, StatusPostPosting, StatusPostPosted}).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// DeleteOldStatusPosts removes finished status posts last updated before the cutoff
+func (dm *DatabaseManager) DeleteOldStatusPosts(before time.Time) (int64, error) {
+	result := dm.db.Where("status IN ? AND updated_at < ?",
+		[]StatusPostState{StatusPostExpired, StatusPostFailed, StatusPostCancelled}, before).
+		Delete(&WhatsAppStatusPost{})
+	return result.RowsAffected, result.Error
+}
+
+// ============= CALL REPOSITORY =============
+
+// CreateCall stores an offered call; a redelivered offer is ignored and
+// reported as not created
+func (dm *DatabaseManager) CreateCall(call *WhatsAppCall) (bool, error) {
+	result := dm.db.Clauses(clause.OnConflict{DoNothing: true}).Create(call)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (dm *DatabaseManager) UpdateCall(sessionID, callID string, updates map[string]interface{}) error {
+	return dm.db.Model(&WhatsAppCall{}).
+		Where("session_id = ? AND call_id = ?", sessionID, callID).
+		Updates(updates).Error
+}
+
+// EndCall records the end of a call; a call still ringing becomes missed
+func (dm *DatabaseManager) EndCall(sessionID, callID, reason string, endedAt time.Time) error {
+	return dm.db.Model(&WhatsAppCall{}).
+		Where("session_id = ? AND call_id = ? AND ended_at IS NULL", sessionID, callID).
+		Updates(map[string]interface{}{
+			"status":   gorm.Expr("CASE WHEN status = ? THEN ? WHEN status = ? THEN ? ELSE status END", CallRinging, CallMissed, CallAccepted, CallEnded),
+			"reason":   reason,
+			"ended_at": endedAt,
+		}).Error
+}
+
+// GetCalls pages through the calls of a session
+// (filters: status, from; search: from)
+func (dm *DatabaseManager) GetCalls(sessionID string, userID int, q ListQuery) ([]WhatsAppCall, int64, error) {
+	var calls []WhatsAppCall
+	total, err := dm.readPage(q, &calls, func(db *gorm.DB) *gorm.DB {
+		query := db.Model(&WhatsAppCall{}).Where("session_id = ? AND user_id = ?", sessionID, userID)
+		if status, ok := q.Filters["status"]; ok {
+			query = query.Where("status = ?", status)
+		}
+		if from, ok := q.Filters["from"]; ok {
+			query = query.Where("from_jid = ?", from)
+		}
+		if q.Search != "" {
+			query = dm.searchWhere(query, q.like(), "from_jid")
+		}
+		return query
+	})
+	return calls, total, err
+}
+
+// ============= SEGMENT REPOSITORY =============
+
+// isDuplicateKeyError reports whether an insert or update hit a unique index
+// (MySQL error 1062 or Postgres SQLSTATE 23505)
+func isDuplicateKeyError(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "Duplicate entry") ||
+		strings.Contains(err.Error(), "SQLSTATE 23505"))
+}
+
+func (dm *DatabaseManager) CreateSegment(segment *WhatsAppSegment) error {
+	return dm.db.Create(segment).Error
+}
+
+func (dm *DatabaseManager) GetSegments(userID int) ([]WhatsAppSegment, error) {
+	var segments []WhatsAppSegment
+	err := dm.db.Where("user_id = ?", userID).
+		Order("name ASC").
+		Find(&segments).Error
+	return segments, err
+}
+
+func (dm *DatabaseManager) GetSegment(segmentID int64, userID int) (*WhatsAppSegment, error) {
+	var segment WhatsAppSegment
+	err := dm.db.Where("id = ? AND user_id = ?", segmentID, userID).
+		First(&segment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &segment, nil
+}
+
+func (dm *DatabaseManager) UpdateSegment(segment *WhatsAppSegment) error {
+	return dm.db.Save(segment).Error
+}
+
+func (dm *DatabaseManager) DeleteSegment(segmentID int64, userID int) (int64, error) {
+	result := dm.db.Where("id = ? AND user_id = ?", segmentID, userID).Delete(&WhatsAppSegment{})
+	return result.RowsAffected, result.Error
+}
+
+// segmentQuery builds the contacts query for a segment filter
+func (dm *DatabaseManager) segmentQuery(userID int, filter SegmentFilter) *gorm.DB {
+	query := dm.db.Model(&WhatsAppContact{}).Where("user_id = ?", userID)
+
+	if len(filter.CountryCodes) > 0 {
+		query = query.Where("country_code IN ?", filter.CountryCodes)
+	}
+	if filter.GroupMember != nil {
+		query = query.Where("is_group_member = ?", *filter.GroupMember)
+	}
+	if len(filter.GroupJIDs) > 0 {
+		groups := dm.db.Model(&WhatsAppGroup{}).Select("id").
+			Where("user_id = ? AND group_jid IN ?", userID, filter.GroupJIDs)
+		query = query.Where("group_id IN (?)", groups)
+	}
+	if len(filter.Tags) > 0 {
+		tagged := dm.db.Model(&WhatsAppContactTag{}).Select("contact_jid").
+			Where("user_id = ? AND tag IN ?", userID, filter.Tags)
+		query = query.Where("jid IN (?)", tagged)
+	}
+	if len(filter.ExcludeTags) > 0 {
+		tagged := dm.db.Model(&WhatsAppContactTag{}).Select("contact_jid").
+			Where("user_id = ? AND tag IN ?", userID, filter.ExcludeTags)
+		query = query.Where("jid NOT IN (?)", tagged)
+	}
+	if filter.LastMessageWithinDays > 0 {
+		since := time.Now().AddDate(0, 0, -filter.LastMessageWithinDays)
+		active := dm.db.Model(&WhatsAppChat{}).Select("chat_jid").
+			Where("user_id = ? AND last_message_at >= ?", userID, since)
+		query = query.Where("jid IN (?)", active)
+	}
+	if filter.NoMessageForDays > 0 {
+		since := time.Now().AddDate(0, 0, -filter.NoMessageForDays)
+		active := dm.db.Model(&WhatsAppChat{}).Select("chat_jid").
+			Where("user_id = ? AND last_message_at >= ?", userID, since)
+		query = query.Where("jid NOT IN (?)", active)
+	}
+	return query
+}
+
+// GetSegmentContacts returns the contacts currently matching a segment filter.
+// limit <= 0 returns all of them.
+func (dm *DatabaseManager) GetSegmentContacts(userID int, filter SegmentFilter, limit int) ([]WhatsAppContact, error) {
+	var contacts []WhatsAppContact
+	query := dm.segmentQuery(userID, filter).Order("full_name ASC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&contacts).Error
+	return contacts, err
+}
+
+func (dm *DatabaseManager) CountSegmentContacts(userID int, filter SegmentFilter) (int64, error) {
+	var count int64
+	err := dm.segmentQuery(userID, filter).Count(&count).Error
+	return count, err
+}
+
+// ============= NOTE REPOSITORY =============
+
+func (dm *DatabaseManager) CreateNote(note *WhatsAppNote) error {
+	return dm.db.Create(note).Error
+}
+
+func (dm *DatabaseManager) GetNote(id int64, userID int) (*WhatsAppNote, error) {
+	var note WhatsAppNote
+	err := dm.db.Where("id = ? AND user_id = ?", id, userID).First(&note).Error
+	if err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+func (dm *DatabaseManager) UpdateNote(note *WhatsAppNote) error {
+	return dm.db.Model(note).Updates(map[string]interface{}{
+		"author": note.Author,
+		"text":   note.Text,
+	}).Error
+}
+
+func (dm *DatabaseManager) DeleteNote(id int64, userID int) (int64, error) {
+	result := dm.db.Where("id = ? AND user_id = ?", id, userID).Delete(&WhatsAppNote{})
+	return result.RowsAffected, result.Error
+}
+
+// GetNotes returns the notes on the given contacts (sessionID "") or chats of
+// a session keyed by JID, oldest first
+func (dm *DatabaseManager) GetNotes(userID int, sessionID, targetType string, jids []string) (map[string][]WhatsAppNote, error) {
+	notes := make(map[string][]WhatsAppNote)
+	if len(jids) == 0 {
+		return notes, nil
+	}
+	var rows []WhatsAppNote
+	err := dm.db.Where("user_id = ? AND session_id = ? AND target_type = ? AND target_jid IN ?", userID, sessionID, targetType, jids).
+		Order("created_at ASC, id ASC").
+		Find(&rows).Error
+	for _, row := range rows {
+		notes[row.TargetJID] = append(notes[row.TargetJID], row)
+	}
+	return notes, err
+}
+
+// ============= CONTACT TAG REPOSITORY =============
+
+// AddContactTags attaches tags to a contact; tags it already has are kept
+func (dm *DatabaseManager) AddContactTags(userID int, contactJID string, tags []string) error {
+	rows := make([]WhatsAppContactTag, 0, len(tags))
+	for _, tag := range tags {
+		rows = append(rows, WhatsAppContactTag{UserID: userID, ContactJID: contactJID, Tag: tag})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return dm.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
+
+func (dm *DatabaseManager) RemoveContactTag(userID int, contactJID, tag string) (int64, error) {
+	result := dm.db.Where("user_id = ? AND contact_jid = ? AND tag = ?", userID, contactJID, tag).
+		Delete(&WhatsAppContactTag{})
+	return result.RowsAffected, result.Error
+}
+
+// GetContactTags returns the tags of the given contacts keyed by JID
+func (dm *DatabaseManager) GetContactTags(userID int, contactJIDs []string) (map[string][]string, error) {
+	tags := make(map[string][]string)
+	if len(contactJIDs) == 0 {
+		return tags, nil
+	}
+	var rows []WhatsAppContactTag
+	err := dm.db.Where("user_id = ? AND contact_jid IN ?", userID, contactJIDs).
+		Order("tag ASC").
+		Find(&rows).Error
+	for _, row := range rows {
+		tags[row.ContactJID] = append(tags[row.ContactJID], row.Tag)
+	}
+	return tags, err
+}
+
+// PageTaggedJIDs pages through the JIDs carrying a tag. Sorting and search use
+// the matching contacts row, so tagged numbers that aren't contacts sort as
+// empty values.
+func (dm *DatabaseManager) PageTaggedJIDs(userID int, tag string, q ListQuery) ([]string, int64, error) {
+	query := dm.db.Table("whats_app_contact_tags AS t").
+		Joins("LEFT JOIN whats_app_contacts AS c ON c.user_id = t.user_id AND c.jid = t.contact_jid").
+		Where("t.user_id = ? AND t.tag = ?", userID, tag)
+	if q.Search != "" {
+		query = dm.searchWhere(query, q.like(), "c.full_name", "c.mobile_number", "t.contact_jid")
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	column := "c." + q.Sort
+	if q.Sort == "jid" {
+		column = "t.contact_jid"
+	}
+	direction := "ASC"
+	if q.Desc {
+		direction = "DESC"
+	}
+	var jids []string
+	err := query.Session(&gorm.Session{}).
+		Order(fmt.Sprintf("%s %s, t.id %s", column, direction, direction)).
+		Limit(q.Limit).
+		Offset(q.Offset).
+		Pluck("t.contact_jid", &jids).Error
+	return jids, total, err
+}
+
+// TagCount is a tag with the number of contacts carrying it
+type TagCount struct {
+	Tag      string `json:"tag"`
+	Contacts int64  `json:"contacts"`
+}
+
+func (dm *DatabaseManager) GetTagCounts(userID int) ([]TagCount, error) {
+	var counts []TagCount
+	err := dm.db.Model(&WhatsAppContactTag{}).
+		Select("tag, COUNT(*) AS contacts").
+		Where("user_id = ?", userID).
+		Group("tag").
+		Order("tag ASC").
+		Scan(&counts).Error
+	return counts, err
+}
+
+// ============= AUTO-REPLY REPOSITORY =============
+
+func (dm *DatabaseManager) CreateAutoReplyRule(rule *WhatsAppAutoReplyRule) error {
+	return dm.db.Create(rule).Error
+}
+
+func (dm *DatabaseManager) GetAutoReplyRules(userID int) ([]WhatsAppAutoReplyRule, error) {
+	var rules []WhatsAppAutoReplyRule
+	err := dm.db.Where("user_id = ?", userID).
+		Order("id ASC").
+		Find(&rules).Error
+	return rules, err
+}
+
+func (dm *DatabaseManager) GetAutoReplyRule(ruleID int64, userID int) (*WhatsAppAutoReplyRule, error) {
+	var rule WhatsAppAutoReplyRule
+	err := dm.db.Where("id = ? AND user_id = ?", ruleID, userID).
+		First(&rule).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// GetActiveAutoReplyRules returns the enabled rules that apply to a session,
+// in evaluation order
+func (dm *DatabaseManager) GetActiveAutoReplyRules(userID int, sessionID string) ([]WhatsAppAutoReplyRule, error) {
+	var rules []WhatsAppAutoReplyRule
+	err := dm.db.Where("user_id = ? AND enabled = ? AND (session_id = '' OR session_id IS NULL OR session_id = ?)", userID, true, sessionID).
+		Order("id ASC").
+		Find(&rules).Error
+	return rules, err
+}
+
+func (dm *DatabaseManager) UpdateAutoReplyRule(rule *WhatsAppAutoReplyRule) error {
+	return dm.db.Save(rule).Error
+}
+
+func (dm *DatabaseManager) DeleteAutoReplyRule(ruleID int64, userID int) (int64, error) {
+	result := dm.db.Where("id = ? AND user_id = ?", ruleID, userID).Delete(&WhatsAppAutoReplyRule{})
+	return result.RowsAffected, result.Error
+}
+
+// ============= CANNED RESPONSE REPOSITORY =============
+
+func (dm *DatabaseManager) CreateCannedResponse(response *WhatsAppCannedResponse) error {
+	return dm.db.Create(response).Error
+}
+
+// GetCannedResponses pages through the user's canned responses
+// (filter: shortcut; search: shortcut, title and text)
+func (dm *DatabaseManager) GetCannedResponses(userID int, q ListQuery) ([]WhatsAppCannedResponse, int64, error) {
+	query := dm.db.Model(&WhatsAppCannedResponse{}).Where("user_id = ?", userID)
+	if shortcut, ok := q.Filters["shortcut"]; ok {
+		query = query.Where("shortcut = ?", shortcut)
+	}
+	if q.Search != "" {
+		query = dm.searchWhere(query, q.like(), "shortcut", "title", "text")
+	}
+
+	var responses []WhatsAppCannedResponse
+	total, err := findPage(query, q, &responses)
+	return responses, total, err
+}
+
+func (dm *DatabaseManager) GetCannedResponse(id int64, userID int) (*WhatsAppCannedResponse, error) {
+	var response WhatsAppCannedResponse
+	err := dm.db.Where("id = ? AND user_id = ?", id, userID).First(&response).Error
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (dm *DatabaseManager) UpdateCannedResponse(response *WhatsAppCannedResponse) error {
+	return dm.db.Model(response).Updates(map[string]interface{}{
+		"shortcut": response.Shortcut,
+		"title":    response.Title,
+		"text":     response.Text,
+	}).Error
+}
+
+func (dm *DatabaseManager) DeleteCannedResponse(id int64, userID int) (int64, error) {
+	result := dm.db.Where("id = ? AND user_id = ?", id, userID).Delete(&WhatsAppCannedResponse{})
+	return result.RowsAffected, result.Error
+}
+
+// RecordCannedResponseUse counts a message sent or queued with a canned
+// response
+func (dm *DatabaseManager) RecordCannedResponseUse(id int64) error {
+	return dm.db.Model(&WhatsAppCannedResponse{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"use_count":    gorm.Expr("use_count + 1"),
+			"last_used_at": time.Now(),
+		}).Error
+}
+
+// ============= SEQUENCE REPOSITORY =============
+
+func (dm *DatabaseManager) CreateSequence(sequence *WhatsAppSequence) error {
+	return dm.db.Create(sequence).Error
+}
+
+func (dm *DatabaseManager) GetSequences(userID int) ([]WhatsAppSequence, error) {
+	var sequences []WhatsAppSequence
+	err := dm.db.Where("user_id = ?", userID).
+		Order("name ASC").
+		Find(&sequences).Error
+	return sequences, err
+}
+
+func (dm *DatabaseManager) GetSequence(sequenceID int64, userID int) (*WhatsAppSequence, error) {
+	var sequence WhatsAppSequence
+	err := dm.db.Where("id = ? AND user_id = ?", sequenceID, userID).First(&sequence).Error
+	if err != nil {
+		return nil, err
+	}
+	return &sequence, nil
+}
+
+func (dm *DatabaseManager) GetSequencesByID(ids []int64) (map[int64]*WhatsAppSequence, error) {
+	sequences := make(map[int64]*WhatsAppSequence, len(ids))
+	if len(ids) == 0 {
+		return sequences, nil
+	}
+	var rows []WhatsAppSequence
+	if err := dm.db.Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		sequences[rows[i].ID] = &rows[i]
+	}
+	return sequences, nil
+}
+
+func (dm *DatabaseManager) UpdateSequence(sequence *WhatsAppSequence) error {
+	return dm.db.Save(sequence).Error
+}
+
+// DeleteSequence removes a sequence with its enrollments and unsets it on
+// the auto-reply rules enrolling into it
+func (dm *DatabaseManager) DeleteSequence(sequenceID int64, userID int) (int64, error) {
+	var deleted int64
+	err := dm.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", sequenceID, userID).Delete(&WhatsAppSequence{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		if deleted == 0 {
+			return nil
+		}
+		if err := tx.Where("sequence_id = ?", sequenceID).Delete(&WhatsAppSequenceEnrollment{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&WhatsAppAutoReplyRule{}).Where("sequence_id = ?", sequenceID).Update("sequence_id", nil).Error
+	})
+	return deleted, err
+}
+
+// GetEnrollment returns the enrollment of a contact in a sequence
+func (dm *DatabaseManager) GetEnrollment(sequenceID int64, contactJID string) (*WhatsAppSequenceEnrollment, error) {
+	var enrollment WhatsAppSequenceEnrollment
+	err := dm.db.Where("sequence_id = ? AND contact_jid = ?", sequenceID, contactJID).First(&enrollment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+func (dm *DatabaseManager) CreateEnrollment(enrollment *WhatsAppSequenceEnrollment) error {
+	return dm.db.Create(enrollment).Error
+}
+
+// RestartEnrollment starts a finished enrollment over; an active one is left
+// alone. Reports whether it was restarted.
+func (dm *DatabaseManager) RestartEnrollment(enrollment *WhatsAppSequenceEnrollment) (bool, error) {
+	result := dm.db.Model(&WhatsAppSequenceEnrollment{}).
+		Where("id = ? AND status <> ?", enrollment.ID, EnrollmentActive).
+		Updates(map[string]interface{}{
+			"status":        EnrollmentActive,
+			"source":        enrollment.Source,
+			"next_step":     0,
+			"next_run_at":   enrollment.NextRunAt,
+			"cancel_reason": "",
+			"last_error":    "",
+			"enrolled_at":   enrollment.EnrolledAt,
+			"ended_at":      nil,
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// GetEnrollments pages through the enrollments of a sequence
+// (filter: status; search: contact JID)
+func (dm *DatabaseManager) GetEnrollments(sequenceID int64, q ListQuery) ([]WhatsAppSequenceEnrollment, int64, error) {
+	query := dm.db.Model(&WhatsAppSequenceEnrollment{}).Where("sequence_id = ?", sequenceID)
+	if status, ok := q.Filters["status"]; ok {
+		query = query.Where("status = ?", status)
+	}
+	if q.Search != "" {
+		query = dm.searchWhere(query, q.like(), "contact_jid")
+	}
+
+	var enrollments []WhatsAppSequenceEnrollment
+	total, err := findPage(query, q, &enrollments)
+	return enrollments, total, err
+}
+
+// GetDueEnrollments returns active enrollments whose next step is due
+func (dm *DatabaseManager) GetDueEnrollments(now time.Time, limit int) ([]WhatsAppSequenceEnrollment, error) {
+	var enrollments []WhatsAppSequenceEnrollment
+	err := dm.db.Where("status = ? AND next_run_at <= ?", EnrollmentActive, now).
+		Order("next_run_at ASC").
+		Limit(limit).
+		Find(&enrollments).Error
+	return enrollments, err
+}
+
+// ClaimEnrollmentStep moves the next run of an enrollment's step out by a
+// lease so other pollers skip it while it's sent. Reports whether the step
+// was still due and is now claimed.
+func (dm *DatabaseManager) ClaimEnrollmentStep(enrollment *WhatsAppSequenceEnrollment, now, leaseUntil time.Time) (bool, error) {
+	result := dm.db.Model(&WhatsAppSequenceEnrollment{}).
+		Where("id = ? AND status = ? AND next_step = ? AND next_run_at <= ?",
+			enrollment.ID, EnrollmentActive, enrollment.NextStep, now).
+		Update("next_run_at", leaseUntil)
+	return result.RowsAffected == 1, result.Error
+}
+
+// UpdateActiveEnrollment changes an enrollment that is still active,
+// reporting whether it was
+func (dm *DatabaseManager) UpdateActiveEnrollment(id int64, updates map[string]interface{}) (bool, error) {
+	result := dm.db.Model(&WhatsAppSequenceEnrollment{}).
+		Where("id = ? AND status = ?", id, EnrollmentActive).
+		Updates(updates)
+	return result.RowsAffected == 1, result.Error
+}
+
+// CancelEnrollments cancels the active enrollments of a contact (any of
+// contactJIDs) in the sequences sent by a session, returning their sequence IDs
+func (dm *DatabaseManager) CancelEnrollments(sessionID string, contactJIDs []string, reason string) ([]int64, error) {
+	var sequenceIDs []int64
+	err := dm.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&WhatsAppSequenceEnrollment{}).
+			Where("session_id = ? AND contact_jid IN ? AND status = ?", sessionID, contactJIDs, EnrollmentActive)
+		if err := query.Session(&gorm.Session{}).Pluck("sequence_id", &sequenceIDs).Error; err != nil {
+			return err
+		}
+		if len(sequenceIDs) == 0 {
+			return nil
+		}
+		return query.Session(&gorm.Session{}).Updates(map[string]interface{}{
+			"status":        EnrollmentCancelled,
+			"cancel_reason": reason,
+			"next_run_at":   nil,
+			"ended_at":      time.Now(),
+		}).Error
+	})
+	return sequenceIDs, err
+}
+
+// ============= LEAD SCORING REPOSITORY =============
+
+func (dm *DatabaseManager) CreateScoringRule(rule *WhatsAppScoringRule) error {
+	return dm.db.Create(rule).Error
+}
+
+func (dm *DatabaseManager) GetScoringRules(userID int) ([]WhatsAppScoringRule, error) {
+	var rules []WhatsAppScoringRule
+	err := dm.db.Where("user_id = ?", userID).
+		Order("id ASC").
+		Find(&rules).Error
+	return rules, err
+}
+
+func (dm *DatabaseManager) GetScoringRule(ruleID int64, userID int) (*WhatsAppScoringRule, error) {
+	var rule WhatsAppScoringRule
+	err := dm.db.Where("id = ? AND user_id = ?", ruleID, userID).
+		First(&rule).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// GetActiveScoringRules returns the enabled scoring rules that apply to a
+// session
+func (dm *DatabaseManager) GetActiveScoringRules(userID int, sessionID string) ([]WhatsAppScoringRule, error) {
+	var rules []WhatsAppScoringRule
+	err := dm.db.Where("user_id = ? AND enabled = ? AND (session_id = '' OR session_id IS NULL OR session_id = ?)", userID, true, sessionID).
+		Order("id ASC").
+		Find(&rules).Error
+	return rules, err
+}
+
+func (dm *DatabaseManager) UpdateScoringRule(rule *WhatsAppScoringRule) error {
+	return dm.db.Save(rule).Error
+}
+
+func (dm *DatabaseManager) DeleteScoringRule(ruleID int64, userID int) (int64, error) {
+	result := dm.db.Where("id = ? AND user_id = ?", ruleID, userID).Delete(&WhatsAppScoringRule{})
+	return result.RowsAffected, result.Error
+}
+
+// AddContactScore adds points to the score of a contact, starting it at 0,
+// and returns the new score
+func (dm *DatabaseManager) AddContactScore(userID int, jid string, points int) (*WhatsAppContactScore, error) {
+	now := time.Now()
+	add := func() (int64, error) {
+		result := dm.db.Model(&WhatsAppContactScore{}).
+			Where("user_id = ? AND jid = ?", userID, jid).
+			Updates(map[string]interface{}{
+				"score":          gorm.Expr("score + ?", points),
+				"last_scored_at": now,
+			})
+		return result.RowsAffected, result.Error
+	}
+
+	updated, err := add()
+	if err != nil {
+		return nil, err
+	}
+	if updated == 0 {
+		err = dm.db.Create(&WhatsAppContactScore{UserID: userID, JID: jid, Score: points, LastScoredAt: now}).Error
+		if isDuplicateKeyError(err) {
+			// Scored concurrently
+			_, err = add()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dm.GetContactScore(userID, jid)
+}
+
+func (dm *DatabaseManager) GetContactScore(userID int, jid string) (*WhatsAppContactScore, error) {
+	var score WhatsAppContactScore
+	err := dm.db.Where("user_id = ? AND jid = ?", userID, jid).First(&score).Error
+	if err != nil {
+		return nil, err
+	}
+	return &score, nil
+}
+
+// GetContactScores returns the scores of a user's contacts by JID
+func (dm *DatabaseManager) GetContactScores(userID int, jids []string) (map[string]WhatsAppContactScore, error) {
+	scores := make(map[string]WhatsAppContactScore, len(jids))
+	if len(jids) == 0 {
+		return scores, nil
+	}
+	var rows []WhatsAppContactScore
+	err := dm.db.Where("user_id = ? AND jid IN ?", userID, jids).Find(&rows).Error
+	for _, row := range rows {
+		scores[row.JID] = row
+	}
+	return scores, err
+}
+
+// PageContactScores pages through the scored contacts of a user
+// (filter: hot; search: JID)
+func (dm *DatabaseManager) PageContactScores(userID int, q ListQuery) ([]WhatsAppContactScore, int64, error) {
+	query := dm.db.Model(&WhatsAppContactScore{}).Where("user_id = ?", userID)
+	switch q.Filters["hot"] {
+	case "true":
+		query = query.Where("hot_lead_at IS NOT NULL")
+	case "false":
+		query = query.Where("hot_lead_at IS NULL")
+	}
+	if q.Search != "" {
+		query = dm.searchWhere(query, q.like(), "jid")
+	}
+
+	var scores []WhatsAppContactScore
+	total, err := findPage(query, q, &scores)
+	return scores, total, err
+}
+
+// MarkHotLead records that a contact's score reached the threshold. Only
+// the first call after the score got there (or since a reset) reports true.
+func (dm *DatabaseManager) MarkHotLead(userID int, jid string, threshold int) (bool, error) {
+	result := dm.db.Model(&WhatsAppContactScore{}).
+		Where("user_id = ? AND jid = ? AND score >= ? AND hot_lead_at IS NULL", userID, jid, threshold).
+		Update("hot_lead_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteContactScore resets the score of a contact
+func (dm *DatabaseManager) DeleteContactScore(userID int, jid string) (int64, error) {
+	result := dm.db.Where("user_id = ? AND jid = ?", userID, jid).Delete(&WhatsAppContactScore{})
+	return result.RowsAffected, result.Error
+}
+
+// GetLeadSettings returns the lead settings of a user, nil without any
+func (dm *DatabaseManager) GetLeadSettings(userID int) (*WhatsAppLeadSettings, error) {
+	var settings WhatsAppLeadSettings
+	err := dm.db.Where("user_id = ?", userID).First(&settings).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+func (dm *DatabaseManager) SaveLeadSettings(settings *WhatsAppLeadSettings) error {
+	return dm.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"threshold", "webhook_url", "webhook_secret", "updated_at"}),
+	}).Create(settings).Error
+}
+
+// ============= CHAT EXPORT REPOSITORY =============
+
+func (dm *DatabaseManager) CreateChatExport(export *WhatsAppChatExport) error {
+	return dm.db.Create(export).Error
+}
+
+func (dm *DatabaseManager) GetChatExport(exportID int64, userID int) (*WhatsAppChatExport, error) {
+	var export WhatsAppChatExport
+	err := dm.db.Where("id = ? AND user_id = ?", exportID, userID).
+		First(&export).Error
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+func (dm *DatabaseManager) UpdateChatExport(exportID int64, updates map[string]interface{}) error {
+	return dm.db.Model(&WhatsAppChatExport{}).
+		Where("id = ?", exportID).
+		Updates(updates).Error
+}
+
+// FailInterruptedChatExports marks exports left unfinished by a restart as failed
+func (dm *DatabaseManager) FailInterruptedChatExports(expiresAt time.Time) (int64, error) {
+	result := dm.db.Model(&WhatsAppChatExport{}).
+		Where("status IN ?", []ExportStatus{ExportPending, ExportRunning}).
+		Updates(map[string]interface{}{
+			"status":     ExportFailed,
+			"error":      "interrupted by a server restart",
+			"expires_at": expiresAt,
+		})
+	return result.RowsAffected, result.Error
+}
+
+func (dm *DatabaseManager) GetExpiredChatExports(now time.Time) ([]WhatsAppChatExport, error) {
+	var exports []WhatsAppChatExport
+	err := dm.db.Where("expires_at IS NOT NULL AND expires_at < ?", now).
+		Find(&exports).Error
+	return exports, err
+}
+
+func (dm *DatabaseManager) DeleteChatExport(exportID int64) error {
+	return dm.db.Where("id = ?", exportID).Delete(&WhatsAppChatExport{}).Error
+}
+
+// ============= GROUP SYNC REPOSITORY =============
+
+func (dm *DatabaseManager) CreateGroupSyncJob(job *WhatsAppGroupSyncJob) error {
+	return dm.db.Create(job).Error
+}
+
+func (dm *DatabaseManager) UpdateGroupSyncJob(jobID int64, updates map[string]interface{}) error {
+	return dm.db.Model(&WhatsAppGroupSyncJob{}).
+		Where("id = ?", jobID).
+		Updates(updates).Error
+}
+
+func (dm *DatabaseManager) GetGroupSyncJob(jobID int64, userID int) (*WhatsAppGroupSyncJob, error) {
+	var job WhatsAppGroupSyncJob
+	err := dm.db.Where("id = ? AND user_id = ?", jobID, userID).
+		First(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetLatestGroupSyncJob returns the newest sync job of a session
+func (dm *DatabaseManager) GetLatestGroupSyncJob(sessionID string, userID int) (*WhatsAppGroupSyncJob, error) {
+	var job WhatsAppGroupSyncJob
+	err := dm.db.Where("session_id = ? AND user_id = ?", sessionID, userID).
+		Order("id DESC").
+		First(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetUnfinishedGroupSyncJob returns the pending or running job of a session,
+// e.g. one interrupted by a restart
+func (dm *DatabaseManager) GetUnfinishedGroupSyncJob(sessionID string) (*WhatsAppGroupSyncJob, error) {
+	var job WhatsAppGroupSyncJob
+	err := dm.db.Where("session_id = ? AND status IN ?", sessionID, []GroupSyncStatus{GroupSyncPending, GroupSyncRunning}).
+		Order("id DESC").
+		First(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetGroupSyncTimes returns when each synced group of a user was last fetched
+func (dm *DatabaseManager) GetGroupSyncTimes(userID int) (map[string]time.Time, error) {
+	var rows []struct {
+		GroupJID string
+		SyncedAt time.Time
+	}
+	err := dm.db.Model(&WhatsAppGroup{}).
+		Select("group_jid, synced_at").
+		Where("user_id = ? AND synced_at IS NOT NULL", userID).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	times := make(map[string]time.Time, len(rows))
+	for _, row := range rows {
+		times[row.GroupJID] = row.SyncedAt
+	}
+	return times, nil
+}
+
+// ============= AUDIT LOG REPOSITORY =============
+
+func (dm *DatabaseManager) CreateAuditLog(entry *WhatsAppAuditLog) error {
+	return dm.db.Create(entry).Error
+}
+
+// GetAuditLogs lists a user's audit entries, optionally limited to a time range
+func (dm *DatabaseManager) GetAuditLogs(userID int, q ListQuery, from, to *time.Time) ([]WhatsAppAuditLog, int64, error) {
+	var entries []WhatsAppAuditLog
+	total, err := dm.readPage(q, &entries, func(db *gorm.DB) *gorm.DB {
+		query := db.Model(&WhatsAppAuditLog{}).Where("user_id = ?", userID)
+		for filter, column := range map[string]string{
+			"session_id":  "session_id",
+			"method":      "method",
+			"endpoint":    "endpoint",
+			"status_code": "status_code",
+		} {
+			if value, ok := q.Filters[filter]; ok {
+				query = query.Where(column+" = ?", value)
+			}
+		}
+		if from != nil {
+			query = query.Where("created_at >= ?", *from)
+		}
+		if to != nil {
+			query = query.Where("created_at < ?", *to)
+		}
+		if q.Search != "" {
+			query = dm.searchWhere(query, q.like(), "path")
+		}
+		return query
+	})
+	return entries, total, err
+}
+
+// ============= USER QUOTA REPOSITORY =============
+
+// GetUserQuota returns the quota of a user, nil without one
+func (dm *DatabaseManager) GetUserQuota(userID int) (*WhatsAppUserQuota, error) {
+	var quota WhatsAppUserQuota
+	err := dm.db.Where("user_id = ?", userID).First(&quota).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+func (dm *DatabaseManager) SetUserQuota(userID, maxDevices int) error {
+	return dm.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"max_devices", "updated_at"}),
+	}).Create(&WhatsAppUserQuota{UserID: userID, MaxDevices: maxDevices}).Error
+}
+
+func (dm *DatabaseManager) DeleteUserQuota(userID int) (int64, error) {
+	result := dm.db.Where("user_id = ?", userID).Delete(&WhatsAppUserQuota{})
+	return result.RowsAffected, result.Error
+}
+
+// ============= SEND INTENT REPOSITORY =============
+
+func (dm *DatabaseManager) CreateSendIntent(intent *WhatsAppSendIntent) error {
+	return dm.db.Create(intent).Error
+}
+
+// CompleteSendIntent settles a send WhatsApp accepted: the intent is deleted
+// and the sent message, if any, stored in the same transaction
+func (dm *DatabaseManager) CompleteSendIntent(id int64, message *WhatsAppMessage) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&WhatsAppSendIntent{}, id).Error; err != nil {
+			return err
+		}
+		if message == nil {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(message).Error
+	})
+}
+
+func (dm *DatabaseManager) DeleteSendIntent(id int64) error {
+	return dm.db.Delete(&WhatsAppSendIntent{}, id).Error
+}
+
+// GetPendingSendIntents returns the intents still pending that an instance
+// created before a time, plus those of any instance created before staleBefore
+func (dm *DatabaseManager) GetPendingSendIntents(instance string, before, staleBefore time.Time) ([]WhatsAppSendIntent, error) {
+	var intents []WhatsAppSendIntent
+	err := dm.db.Where("status = ?", SendIntentPending).
+		Where("(instance = ? AND created_at < ?) OR created_at < ?", instance, before, staleBefore).
+		Order("id ASC").
+		Find(&intents).Error
+	return intents, err
+}
+
+// InterruptSendIntent marks a pending intent interrupted; reports false if it
+// was settled or marked by another instance in the meantime
+func (dm *DatabaseManager) InterruptSendIntent(id int64) (bool, error) {
+	result := dm.db.Model(&WhatsAppSendIntent{}).
+		Where("id = ? AND status = ?", id, SendIntentPending).
+		Update("status", SendIntentInterrupted)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ============= GROUP ANALYTICS REPOSITORY =============
+
+// GroupDayCount is the number of messages and senders of a group on a day
+type GroupDayCount struct {
+	Day      time.Time `json:"day"`
+	Messages int       `json:"messages"`
+	Senders  int       `json:"senders"`
+}
+
+// GroupSenderCount is the number of messages a member sent to a group
+type GroupSenderCount struct {
+	SenderJID     string    `json:"sender_jid"`
+	Name          string    `gorm:"-" json:"name,omitempty"`
+	Messages      int       `json:"messages"`
+	LastMessageAt time.Time `json:"last_message_at"`
+}
+
+// groupStatsCursor is the aggregation cursor of the group analytics job
+const groupStatsCursor = "group_daily_stats"
+
+// AggregateGroupMessages adds up to limit stored messages after the cursor to
+// the daily group stats and returns how many were read. Counting and moving
+// the cursor happen in one transaction, so each message is counted once.
+func (dm *DatabaseManager) AggregateGroupMessages(limit int) (int, error) {
+	read := 0
+	err := dm.db.Transaction(func(tx *gorm.DB) error {
+		cursor := WhatsAppAggregationCursor{Name: groupStatsCursor}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&cursor).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cursor, "name = ?", groupStatsCursor).Error; err != nil {
+			return err
+		}
+
+		var window struct {
+			Count int
+			MaxID int64
+		}
+		err := tx.Raw(`SELECT COUNT(*) AS count, COALESCE(MAX(id), 0) AS max_id FROM (
+			SELECT id FROM whats_app_messages WHERE id > ? ORDER BY id LIMIT ?) AS batch`, cursor.Position, limit).
+			Scan(&window).Error
+		if err != nil || window.Count == 0 {
+			return err
+		}
+
+		var rows []WhatsAppGroupDailyStat
+		err = tx.Model(&WhatsAppMessage{}).
+			Select("session_id, user_id, chat_jid, DATE(timestamp) AS day, sender_jid, COUNT(*) AS messages, MAX(timestamp) AS last_message_at").
+			Where("id > ? AND id <= ? AND from_me = ? AND chat_jid LIKE ?", cursor.Position, window.MaxID, false, "%@g.us").
+			Group("session_id, user_id, chat_jid, DATE(timestamp), sender_jid").
+			Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range rows {
+			row := &rows[i]
+			err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "session_id"}, {Name: "chat_jid"}, {Name: "day"}, {Name: "sender_jid"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"messages":        gorm.Expr("whats_app_group_daily_stats.messages + ?", row.Messages),
+					"last_message_at": gorm.Expr("GREATEST(whats_app_group_daily_stats.last_message_at, ?)", row.LastMessageAt),
+				}),
+			}).Create(row).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		read = window.Count
+		return tx.Model(&cursor).Updates(map[string]interface{}{"position": window.MaxID, "updated_at": time.Now()}).Error
+	})
+	return read, err
+}
+
+// GetGroupDayCounts returns the messages and senders per day of a group
+// since a day, oldest first
+func (dm *DatabaseManager) GetGroupDayCounts(sessionID, chatJID string, since time.Time) ([]GroupDayCount, error) {
+	var days []GroupDayCount
+	err := dm.read(func(db *gorm.DB) error {
+		return db.Model(&WhatsAppGroupDailyStat{}).
+			Select("day, SUM(messages) AS messages, COUNT(*) AS senders").
+			Where("session_id = ? AND chat_jid = ? AND day >= ?", sessionID, chatJID, since).
+			Group("day").
+			Order("day ASC").
+			Scan(&days).Error
+	})
+	return days, err
+}
+
+// GetGroupTopSenders returns the members who sent the most messages to a
+// group since a day
+func (dm *DatabaseManager) GetGroupTopSenders(sessionID, chatJID string, since time.Time, limit int) ([]GroupSenderCount, error) {
+	var senders []GroupSenderCount
+	err := dm.read(func(db *gorm.DB) error {
+		return db.Model(&WhatsAppGroupDailyStat{}).
+			Select("sender_jid, SUM(messages) AS messages, MAX(last_message_at) AS last_message_at").
+			Where("session_id = ? AND chat_jid = ? AND day >= ?", sessionID, chatJID, since).
+			Group("sender_jid").
+			Order("messages DESC, last_message_at DESC").
+			Limit(limit).
+			Scan(&senders).Error
+	})
+	return senders, err
+}
+
+// CountGroupSenders counts the distinct members who wrote to a group since a day
+func (dm *DatabaseManager) CountGroupSenders(sessionID, chatJID string, since time.Time) (int64, error) {
+	var count int64
+	err := dm.read(func(db *gorm.DB) error {
+		return db.Model(&WhatsAppGroupDailyStat{}).
+			Where("session_id = ? AND chat_jid = ? AND day >= ?", sessionID, chatJID, since).
+			Distinct("sender_jid").
+			Count(&count).Error
+	})
+	return count, err
+}
+
+// GetGroupLastActivity returns when a member last wrote to a group, nil if
+// no message was aggregated
+func (dm *DatabaseManager) GetGroupLastActivity(sessionID, chatJID string) (*time.Time, error) {
+	var stat WhatsAppGroupDailyStat
+	err := dm.db.Where("session_id = ? AND chat_jid = ?", sessionID, chatJID).
+		Order("last_message_at DESC").
+		First(&stat).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stat.LastMessageAt, nil
+}
+
+// GetAggregationCursor returns an aggregation cursor, nil before the first run
+func (dm *DatabaseManager) GetAggregationCursor(name string) (*WhatsAppAggregationCursor, error) {
+	var cursor WhatsAppAggregationCursor
+	err := dm.db.Where("name = ?", name).First(&cursor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// ============= EVENT EXPORT REPOSITORY =============
+
+// Cursors of the event sink export
+const (
+	eventSinkEventsCursor   = "event_sink_events"
+	eventSinkMessagesCursor = "event_sink_messages"
+)
+
+// lockExportCursor locks an export cursor for the rest of the transaction.
+// A new cursor starts at the newest row of the table, so enabling a sink
+// doesn't replay the history.
+func lockExportCursor(tx *gorm.DB, name string, model interface{}) (*WhatsAppAggregationCursor, error) {
+	var newest int64
+	if err := tx.Model(model).Select("COALESCE(MAX(id), 0)").Scan(&newest).Error; err != nil {
+		return nil, err
+	}
+	cursor := WhatsAppAggregationCursor{Name: name, Position: newest, UpdatedAt: time.Now()}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&cursor).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cursor, "name = ?", name).Error; err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// ExportEvents passes up to limit events after the export cursor that were
+// created before a time to publish, oldest first, and moves the cursor past
+// them once publish succeeds. The cursor stays locked while publishing, so
+// one instance exports at a time and each event is published in order.
+func (dm *DatabaseManager) ExportEvents(limit int, before time.Time, publish func([]WhatsAppEvent) error) (int, error) {
+	exported := 0
+	err := dm.db.Transaction(func(tx *gorm.DB) error {
+		cursor, err := lockExportCursor(tx, eventSinkEventsCursor, &WhatsAppEvent{})
+		if err != nil {
+			return err
+		}
+
+		var events []WhatsAppEvent
+		if err := tx.Where("id > ?", cursor.Position).Order("id ASC").Limit(limit).Find(&events).Error; err != nil {
+			return err
+		}
+		// Newer rows wait, so rows of slower concurrent inserts aren't skipped
+		for i := range events {
+			if !events[i].CreatedAt.Before(before) {
+				events = events[:i]
+				break
+			}
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		if err := publish(events); err != nil {
+			return err
+		}
+		exported = len(events)
+		return tx.Model(cursor).Updates(map[string]interface{}{"position": events[len(events)-1].ID, "updated_at": time.Now()}).Error
+	})
+	return exported, err
+}
+
+// ExportMessages is ExportEvents for stored messages
+func (dm *DatabaseManager) ExportMessages(limit int, before time.Time, publish func([]WhatsAppMessage) error) (int, error) {
+	exported := 0
+	err := dm.db.Transaction(func(tx *gorm.DB) error {
+		cursor, err := lockExportCursor(tx, eventSinkMessagesCursor, &WhatsAppMessage{})
+		if err != nil {
+			return err
+		}
+
+		var messages []WhatsAppMessage
+		if err := tx.Where("id > ?", cursor.Position).Order("id ASC").Limit(limit).Find(&messages).Error; err != nil {
+			return err
+		}
+		for i := range messages {
+			if !messages[i].CreatedAt.Before(before) {
+				messages = messages[:i]
+				break
+			}
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		if err := publish(messages); err != nil {
+			return err
+		}
+		exported = len(messages)
+		return tx.Model(cursor).Updates(map[string]interface{}{"position": messages[len(messages)-1].ID, "updated_at": time.Now()}).Error
+	})
+	return exported, err
+}
+
+// ============= SESSION STATS REPOSITORY =============
+
+// SessionDayStats are the outgoing messages of a session on one day
+type SessionDayStats struct {
+	Day                time.Time `json:"-"`
+	Sent               int       `json:"sent"`
+	Delivered          int       `json:"delivered"`
+	Read               int       `gorm:"column:read_count" json:"read"`
+	Failed             int       `gorm:"-" json:"failed"`
+	AvgDeliverySeconds *float64  `json:"avg_delivery_seconds"`
+}
+
+// GetSessionDayStats counts the stored outgoing messages of a session per
+// day since a time, with their receipts and average delivery latency
+func (dm *DatabaseManager) GetSessionDayStats(sessionID string, since time.Time) ([]SessionDayStats, error) {
+	var days []SessionDayStats
+	err := dm.read(func(db *gorm.DB) error {
+		return db.Model(&WhatsAppMessage{}).
+			Select("DATE(timestamp) AS day, COUNT(*) AS sent, COUNT(delivered_at) AS delivered, COUNT(read_at) AS read_count, "+
+				"AVG("+dm.secondsBetween("timestamp", "delivered_at")+") AS avg_delivery_seconds").
+			Where("session_id = ? AND from_me = ? AND timestamp >= ?", sessionID, true, since).
+			Group("DATE(timestamp)").
+			Order("day ASC").
+			Scan(&days).Error
+	})
+	return days, err
+}
+
+// GetSessionTypeCounts counts the stored outgoing messages of a session by
+// message type since a time
+func (dm *DatabaseManager) GetSessionTypeCounts(sessionID string, since time.Time) (map[string]int, error) {
+	var rows []struct {
+		MessageType string
+		Count       int
+	}
+	err := dm.read(func(db *gorm.DB) error {
+		return db.Model(&WhatsAppMessage{}).
+			Select("message_type, COUNT(*) AS count").
+			Where("session_id = ? AND from_me = ? AND timestamp >= ?", sessionID, true, since).
+			Group("message_type").
+			Scan(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.MessageType] = row.Count
+	}
+	return counts, nil
+}
+
+// GetSafetyCounters returns a session's daily send counters since a day
+// (YYYY-MM-DD)
+func (dm *DatabaseManager) GetSafetyCounters(sessionID, sinceDay string) ([]WhatsAppSafetyCounter, error) {
+	var counters []WhatsAppSafetyCounter
+	err := dm.db.Where("session_id = ? AND day >= ?", sessionID, sinceDay).
+		Order("day ASC").
+		Find(&counters).Error
+	return counters, err
+}
+
+// ============= USAGE REPOSITORY =============
+
+// IncrementUsage adds sent messages to a user's counter of a period
+func (dm *DatabaseManager) IncrementUsage(userID int, period string, messages int) error {
+	counter := WhatsAppUsageCounter{UserID: userID, Period: period, Messages: messages}
+	return dm.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "period"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"messages":   gorm.Expr("messages + ?", messages),
+			"updated_at": time.Now(),
+		}),
+	}).Create(&counter).Error
+}
+
+// ReserveUsage counts a message against a user's period unless the counter
+// already reached limit; reports false when it did. The check and the count
+// are one UPDATE, so concurrent sends can't overshoot the limit.
+func (dm *DatabaseManager) ReserveUsage(userID int, period string, limit int) (bool, error) {
+	counter := WhatsAppUsageCounter{UserID: userID, Period: period}
+	if err := dm.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&counter).Error; err != nil {
+		return false, err
+	}
+	result := dm.db.Model(&WhatsAppUsageCounter{}).
+		Where("user_id = ? AND period = ? AND messages < ?", userID, period, limit).
+		Updates(map[string]interface{}{
+			"messages":   gorm.Expr("messages + 1"),
+			"updated_at": time.Now(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetUsage returns the messages a user sent in a period
+func (dm *DatabaseManager) GetUsage(userID int, period string) (int, error) {
+	var counter WhatsAppUsageCounter
+	err := dm.db.Where("user_id = ? AND period = ?", userID, period).First(&counter).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return counter.Messages, err
+}
+
+// GetUsageQuota returns the usage quota of a user, nil without one
+func (dm *DatabaseManager) GetUsageQuota(userID int) (*WhatsAppUsageQuota, error) {
+	var quota WhatsAppUsageQuota
+	err := dm.db.Where("user_id = ?", userID).First(&quota).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+func (dm *DatabaseManager) SetUsageQuota(userID, softLimit, hardLimit int) error {
+	return dm.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"soft_limit", "hard_limit", "updated_at"}),
+	}).Create(&WhatsAppUsageQuota{UserID: userID, SoftLimit: softLimit, HardLimit: hardLimit}).Error
+}
+
+func (dm *DatabaseManager) DeleteUsageQuota(userID int) (int64, error) {
+	result := dm.db.Where("user_id = ?", userID).Delete(&WhatsAppUsageQuota{})
+	return result.RowsAffected, result.Error
+}
+
+// ============= QR ATTEMPT REPOSITORY =============
+
+// CreateQRAttempt records the start of a pairing attempt
+func (dm *DatabaseManager) CreateQRAttempt(attempt *WhatsAppQRAttempt) error {
+	return dm.db.Create(attempt).Error
+}
+
+// FinishQRAttempt records the outcome of a pending pairing attempt
+func (dm *DatabaseManager) FinishQRAttempt(id int64, outcome string) error {
+	return dm.db.Model(&WhatsAppQRAttempt{}).
+		Where("id = ? AND outcome = ?", id, QRAttemptPending).
+		Updates(map[string]interface{}{
+			"outcome":  outcome,
+			"ended_at": time.Now(),
+		}).Error
+}
+
+// GetQRAttempts returns a page of a session's pairing attempts
+func (dm *DatabaseManager) GetQRAttempts(sessionID string, q ListQuery) ([]WhatsAppQRAttempt, int64, error) {
+	query := dm.db.Model(&WhatsAppQRAttempt{}).Where("session_id = ?", sessionID)
+	if outcome, ok := q.Filters["outcome"]; ok {
+		query = query.Where("outcome = ?", outcome)
+	}
+
+	var attempts []WhatsAppQRAttempt
+	total, err := findPage(query, q, &attempts)
+	return attempts, total, err
+}
+
+// ExpireSession marks a session that never paired expired and clears its QR
+// code; expired sessions don't count against the device limit
+func (dm *DatabaseManager) ExpireSession(sessionID uuid.UUID, reason string) error {
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID.String()).
+		Updates(map[string]interface{}{
+			"status":         StatusExpired,
+			"status_reason":  reason,
+			"qr_code":        nil,
+			"qr_code_base64": nil,
+			"qr_expires_at":  nil,
+		}).Error
+}
+
+// ReactivateSession moves an expired session back to pending with a fresh
+// QR count
+func (dm *DatabaseManager) ReactivateSession(sessionID uuid.UUID) error {
+	return dm.db.Model(&WhatsAppSession{}).
+		Where("id = ?", sessionID.String()).
+		Updates(map[string]interface{}{
+			"status":         StatusPending,
+			"status_reason":  "",
+			"qr_retry_count": 0,
+		}).Error
+}
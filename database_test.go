@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestBulkUpsertChatsKeepsNewerState(t *testing.T) {
+	dm := newTestDatabase(t, t.TempDir())
+	sessionID := uuid.NewString()
+	chatJID := "15550000002@s.whatsapp.net"
+	older := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	newest := newer.Add(time.Hour)
+
+	chat := func() WhatsAppChat {
+		t.Helper()
+		var chat WhatsAppChat
+		if err := dm.db.Where("session_id = ? AND chat_jid = ?", sessionID, chatJID).First(&chat).Error; err != nil {
+			t.Fatal(err)
+		}
+		return chat
+	}
+	upsert := func(lastMessageAt *time.Time, unread int, pinned bool) {
+		t.Helper()
+		err := dm.BulkUpsertChats([]WhatsAppChat{{
+			SessionID: sessionID, UserID: testUserID, ChatJID: chatJID, Name: "Bob",
+			UnreadCount: unread, Pinned: pinned, LastMessageAt: lastMessageAt,
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A live message arrives first
+	if err := dm.TouchChat(sessionID, testUserID, chatJID, false, newer); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.IncrementChatUnread(sessionID, chatJID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then a history chunk with an older state of the chat
+	upsert(&older, 0, true)
+	got := chat()
+	if got.LastMessageAt == nil || !got.LastMessageAt.Equal(newer) {
+		t.Errorf("last_message_at = %v after an older chunk, want %v", got.LastMessageAt, newer)
+	}
+	if got.UnreadCount != 1 || got.Pinned {
+		t.Errorf("older chunk replaced the chat state: unread %d, pinned %v", got.UnreadCount, got.Pinned)
+	}
+	if got.Name != "Bob" {
+		t.Errorf("name = %q, want the chunk's", got.Name)
+	}
+
+	// A chunk without a timestamp doesn't clear it
+	upsert(nil, 0, false)
+	if got := chat(); got.LastMessageAt == nil || !got.LastMessageAt.Equal(newer) || got.UnreadCount != 1 {
+		t.Errorf("chunk without timestamp: last_message_at %v, unread %d", got.LastMessageAt, got.UnreadCount)
+	}
+
+	// A newer chunk wins
+	upsert(&newest, 3, true)
+	got = chat()
+	if got.LastMessageAt == nil || !got.LastMessageAt.Equal(newest) || got.UnreadCount != 3 || !got.Pinned {
+		t.Errorf("newer chunk: last_message_at %v, unread %d, pinned %v", got.LastMessageAt, got.UnreadCount, got.Pinned)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// ============= DATABASE DIALECTS =============
+// Application data is stored in MySQL (default) or Postgres, picked with
+// DB_DRIVER. Models and repositories are shared; the dialect-specific parts
+// are the connection, the device limit trigger, case-insensitive search
+// (LIKE is case-sensitive on Postgres, so searches use ILIKE there), time
+// differences, the inserted row in upserts and duplicate key errors.
+
+const (
+	DBDriverMySQL    = "mysql"
+	DBDriverPostgres = "postgres"
+)
+
+// deviceLimitStatuses are the session statuses counted by the device limit
+// trigger
+const deviceLimitStatuses = "'pending', 'qr_ready', 'scanning', 'connected'"
+
+// defaultMaxDevicesPerUser is the default of MAX_DEVICES_PER_USER
+const defaultMaxDevicesPerUser = 5
+
+// openAppDialector returns the GORM dialector of the configured database
+func openAppDialector(cfg *Config) (gorm.Dialector, error) {
+	switch cfg.DBDriver {
+	case DBDriverMySQL:
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+			cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
+		return mysql.Open(dsn), nil
+	case DBDriverPostgres:
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(cfg.DBUser, cfg.DBPassword),
+			Host:     cfg.DBHost + ":" + cfg.DBPort,
+			Path:     "/" + cfg.DBName,
+			RawQuery: url.Values{"sslmode": {cfg.DBSSLMode}, "TimeZone": {"UTC"}}.Encode(),
+		}
+		return postgres.Open(dsn.String()), nil
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q (expected mysql or postgres)", cfg.DBDriver)
+	}
+}
+
+// dialectName returns the display name of a DB_DRIVER
+func dialectName(driver string) string {
+	if driver == DBDriverPostgres {
+		return "Postgres"
+	}
+	return "MySQL"
+}
+
+// isPostgres reports whether application data is stored in Postgres
+func (dm *DatabaseManager) isPostgres() bool {
+	return isPostgresDB(dm.db)
+}
+
+func isPostgresDB(db *gorm.DB) bool {
+	return db.Dialector.Name() == DBDriverPostgres
+}
+
+// searchWhere matches a LIKE pattern against any of the columns,
+// case-insensitively on both dialects
+func (dm *DatabaseManager) searchWhere(query *gorm.DB, pattern string, columns ...string) *gorm.DB {
+	op := "LIKE"
+	if dm.isPostgres() {
+		op = "ILIKE"
+	}
+	conditions := make([]string, len(columns))
+	args := make([]interface{}, len(columns))
+	for i, column := range columns {
+		conditions[i] = column + " " + op + " ?"
+		args[i] = pattern
+	}
+	return query.Where(strings.Join(conditions, " OR "), args...)
+}
+
+// upsertValue returns the SQL reference to a column of the row an upsert
+// tried to insert, for ON CONFLICT / ON DUPLICATE KEY assignments
+func (dm *DatabaseManager) upsertValue(column string) string {
+	if dm.db.Dialector.Name() == DBDriverMySQL {
+		return "VALUES(" + column + ")"
+	}
+	return "excluded." + column
+}
+
+// secondsBetween returns the SQL expression of the seconds from one
+// timestamp column to another
+func (dm *DatabaseManager) secondsBetween(from, to string) string {
+	if dm.isPostgres() {
+		return fmt.Sprintf("EXTRACT(EPOCH FROM (%s - %s))", to, from)
+	}
+	return fmt.Sprintf("TIMESTAMPDIFF(MICROSECOND, %s, %s) / 1000000", from, to)
+}
+
+// createDeviceLimitTrigger installs the trigger that refuses more active
+// sessions than a user's quota, falling back to the default of
+// MAX_DEVICES_PER_USER until UpdateDeviceLimitTrigger sets the configured one
+func createDeviceLimitTrigger(tx *gorm.DB) error {
+	installDeviceLimitTrigger(tx, defaultMaxDevicesPerUser)
+	return nil
+}
+
+// installDeviceLimitTrigger (re)creates the device limit trigger with the
+// limit of users without a quota. CreateSession checks the limit as well, so
+// a database user without the privilege to create triggers only gets a
+// warning.
+func installDeviceLimitTrigger(tx *gorm.DB, maxDevices int) bool {
+	statements := mysqlDeviceLimitTrigger(maxDevices)
+	if isPostgresDB(tx) {
+		statements = postgresDeviceLimitTrigger(maxDevices)
+	}
+
+	// A nested transaction is a savepoint, so a failure doesn't abort the
+	// migration's transaction on Postgres
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to create device limit trigger: %v", err)
+		return false
+	}
+	return true
+}
+
+// UpdateDeviceLimitTrigger brings the trigger's limit for users without a
+// quota in line with MAX_DEVICES_PER_USER, once the trigger's migration is
+// applied
+func (dm *DatabaseManager) UpdateDeviceLimitTrigger(maxDevices int) {
+	applied, err := dm.appliedMigrations()
+	if err != nil {
+		log.Printf("Warning: Failed to update device limit trigger: %v", err)
+		return
+	}
+	if _, ok := applied[deviceLimitTriggerMigration]; !ok {
+		return
+	}
+	if installDeviceLimitTrigger(dm.db, maxDevices) {
+		log.Printf("   ✅ Device limit trigger: user quotas, else %d devices", maxDevices)
+	}
+}
+
+// dropDeviceLimitTrigger removes the device limit trigger
+func dropDeviceLimitTrigger(tx *gorm.DB) error {
+	statements := []string{
+		`DROP TRIGGER IF EXISTS enforce_device_limit_insert`,
+		`DROP TRIGGER IF EXISTS enforce_device_limit_update`,
+		`DROP PROCEDURE IF EXISTS check_device_limit`,
+	}
+	if isPostgresDB(tx) {
+		statements = []string{
+			`DROP TRIGGER IF EXISTS enforce_device_limit ON whats_app_sessions`,
+			`DROP FUNCTION IF EXISTS check_device_limit()`,
+		}
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deviceLimitQuery is the device limit of a user: their quota, else
+// maxDevices
+func deviceLimitQuery(userID string, maxDevices int) string {
+	return fmt.Sprintf(`COALESCE((SELECT max_devices FROM whats_app_user_quota WHERE user_id = %s), %d)`, userID, maxDevices)
+}
+
+// mysqlDeviceLimitTrigger is a stored procedure called by insert and update
+// triggers
+func mysqlDeviceLimitTrigger(maxDevices int) []string {
+	return []string{
+		`DROP PROCEDURE IF EXISTS check_device_limit`,
+		`CREATE PROCEDURE check_device_limit(IN p_user_id INT, IN p_session_id CHAR(36))
+		BEGIN
+			DECLARE active_count INT;
+			DECLARE max_allowed INT;
+			DECLARE message VARCHAR(128);
+
+			SELECT ` + deviceLimitQuery("p_user_id", maxDevices) + ` INTO max_allowed;
+
+			SELECT COUNT(*) INTO active_count
+			FROM whats_app_sessions
+			WHERE user_id = p_user_id
+				AND is_active = true
+				AND status IN (` + deviceLimitStatuses + `)
+				AND id != p_session_id
+				AND deleted_at IS NULL;
+
+			IF active_count >= max_allowed THEN
+				SET message = CONCAT('Device limit exceeded. Maximum ', max_allowed, ' devices allowed per user.');
+				SIGNAL SQLSTATE '45000'
+				SET MESSAGE_TEXT = message;
+			END IF;
+		END`,
+		`DROP TRIGGER IF EXISTS enforce_device_limit_insert`,
+		`CREATE TRIGGER enforce_device_limit_insert
+		BEFORE INSERT ON whats_app_sessions
+		FOR EACH ROW
+		BEGIN
+			IF NEW.status IN (` + deviceLimitStatuses + `) AND NEW.is_active = true THEN
+				CALL check_device_limit(NEW.user_id, NEW.id);
+			END IF;
+		END`,
+		`DROP TRIGGER IF EXISTS enforce_device_limit_update`,
+		`CREATE TRIGGER enforce_device_limit_update
+		BEFORE UPDATE ON whats_app_sessions
+		FOR EACH ROW
+		BEGIN
+			IF NEW.status IN (` + deviceLimitStatuses + `) AND NEW.is_active = true THEN
+				CALL check_device_limit(NEW.user_id, NEW.id);
+			END IF;
+		END`,
+	}
+}
+
+// postgresDeviceLimitTrigger is one trigger function for inserts and updates
+func postgresDeviceLimitTrigger(maxDevices int) []string {
+	return []string{
+		`CREATE OR REPLACE FUNCTION check_device_limit() RETURNS trigger AS $$
+		DECLARE
+			active_count INT;
+			max_allowed INT;
+		BEGIN
+			IF NEW.status IN (` + deviceLimitStatuses + `) AND NEW.is_active = true THEN
+				SELECT ` + deviceLimitQuery("NEW.user_id", maxDevices) + ` INTO max_allowed;
+
+				SELECT COUNT(*) INTO active_count
+				FROM whats_app_sessions
+				WHERE user_id = NEW.user_id
+					AND is_active = true
+					AND status IN (` + deviceLimitStatuses + `)
+					AND id != NEW.id
+					AND deleted_at IS NULL;
+
+				IF active_count >= max_allowed THEN
+					RAISE EXCEPTION 'Device limit exceeded. Maximum % devices allowed per user.', max_allowed;
+				END IF;
+			END IF;
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS enforce_device_limit ON whats_app_sessions`,
+		`CREATE TRIGGER enforce_device_limit
+		BEFORE INSERT OR UPDATE ON whats_app_sessions
+		FOR EACH ROW EXECUTE FUNCTION check_device_limit()`,
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// The triggers only run on MySQL and Postgres; this checks they enforce the
+// user's quota before the default
+func TestDeviceLimitTrigger(t *testing.T) {
+	for name, statements := range map[string][]string{
+		"mysql":    mysqlDeviceLimitTrigger(7),
+		"postgres": postgresDeviceLimitTrigger(7),
+	} {
+		sql := strings.Join(statements, "\n")
+		if !strings.Contains(sql, "SELECT max_devices FROM whats_app_user_quota WHERE user_id =") {
+			t.Errorf("%s trigger doesn't read the user's quota", name)
+		}
+		if !strings.Contains(sql, "whats_app_user_quota WHERE user_id = p_user_id), 7)") &&
+			!strings.Contains(sql, "whats_app_user_quota WHERE user_id = NEW.user_id), 7)") {
+			t.Errorf("%s trigger doesn't fall back to MAX_DEVICES_PER_USER", name)
+		}
+		if strings.Contains(sql, ">= 5") || strings.Contains(sql, "Maximum 5") {
+			t.Errorf("%s trigger still has a fixed limit of 5", name)
+		}
+	}
+}
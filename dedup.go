@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============= MESSAGE DEDUPLICATION =============
+// whatsmeow can hand the same message to the event handler again after a
+// reconnect (offline messages are redelivered until WhatsApp sees the ack).
+// Incoming messages, including reactions, edits and revokes, are remembered by
+// session, chat and message ID for MESSAGE_DEDUP_WINDOW; a message seen again
+// within the window is dropped before it is stored or pushed to the event
+// streams. The window also holds at most MESSAGE_DEDUP_SIZE messages, the
+// oldest are forgotten first. It lives in memory, so a restart starts empty
+// and relies on the database ignoring duplicate rows.
+
+// MessageDedupStats are the metrics of the message deduplication window
+type MessageDedupStats struct {
+	Size       int   `json:"size"`
+	Window     int64 `json:"window_seconds"`
+	Duplicates int64 `json:"duplicates"`
+}
+
+type dedupEntry struct {
+	key  string
+	seen time.Time
+}
+
+// messageDedup remembers recently handled messages for a sliding window
+type messageDedup struct {
+	window  time.Duration
+	maxSize int
+
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	order []dedupEntry // oldest first
+
+	duplicates atomic.Int64
+}
+
+func newMessageDedup(window time.Duration, maxSize int) *messageDedup {
+	return &messageDedup{
+		window:  window,
+		maxSize: maxSize,
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// duplicate records a message and reports whether it was already seen within
+// the window; a zero window turns deduplication off
+func (d *messageDedup) duplicate(sessionID, chat, messageID string) bool {
+	if d.window <= 0 {
+		return false
+	}
+	key := sessionID + "|" + chat + "|" + messageID
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.evict(now)
+	if _, ok := d.seen[key]; ok {
+		d.duplicates.Add(1)
+		return true
+	}
+	d.seen[key] = now
+	d.order = append(d.order, dedupEntry{key: key, seen: now})
+	return false
+}
+
+// evict forgets messages that left the window or exceed the size limit
+func (d *messageDedup) evict(now time.Time) {
+	cutoff := now.Add(-d.window)
+	drop := 0
+	for drop < len(d.order) && (d.order[drop].seen.Before(cutoff) || len(d.order)-drop >= d.maxSize) {
+		delete(d.seen, d.order[drop].key)
+		drop++
+	}
+	if drop > 0 {
+		d.order = d.order[drop:]
+	}
+}
+
+// stats returns the metrics of the window
+func (d *messageDedup) stats() MessageDedupStats {
+	d.mu.Lock()
+	size := len(d.seen)
+	d.mu.Unlock()
+	return MessageDedupStats{
+		Size:       size,
+		Window:     int64(d.window.Seconds()),
+		Duplicates: d.duplicates.Load(),
+	}
+}
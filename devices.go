@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/proto/waAdv"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= LINKED DEVICE METADATA =============
+// The metadata of a session's linked device is kept in the session's
+// device_info: the platform of the primary phone (android, iphone, smba for
+// WhatsApp Business on Android, ...) reported at pairing, the WhatsApp Web
+// version this client speaks, the device's JID, LID and key index, when it was
+// linked and the last offline sync. It is refreshed on every connect and when
+// the offline sync after a connect completes. A different platform (the number
+// was re-paired from another phone), app version or business name than the
+// stored one emits device_changed with the previous and current values.
+
+// deviceChangeFields are the device_info fields whose changes are reported
+var deviceChangeFields = []string{"platform", "app_version", "business_name"}
+
+// SessionDevice is the linked device metadata of a session
+type SessionDevice struct {
+	SessionID string        `json:"session_id"`
+	Status    SessionStatus `json:"status"`
+	Connected bool          `json:"connected"`
+	Device    JSONData      `json:"device"`
+}
+
+// deviceInfo collects the metadata of a session's linked device
+func deviceInfo(sc *SessionClient) JSONData {
+	device := sc.Device
+	info := JSONData{
+		"platform":        device.Platform,
+		"business_name":   device.BusinessName,
+		"push_name":       device.PushName,
+		"app_version":     store.GetWAVersion().String(),
+		"client_name":     ClientName,
+		"client_platform": ClientPlatformType,
+	}
+	if device.ID != nil {
+		info["jid"] = device.ID.String()
+		info["device_id"] = device.ID.Device
+	}
+	if !device.LID.IsEmpty() {
+		info["lid"] = device.LID.String()
+	}
+
+	if details := device.Account.GetDetails(); len(details) > 0 {
+		var identity waAdv.ADVDeviceIdentity
+		if err := proto.Unmarshal(details, &identity); err == nil {
+			info["key_index"] = identity.GetKeyIndex()
+			if ts := identity.GetTimestamp(); ts > 0 {
+				info["linked_at"] = time.Unix(int64(ts), 0).UTC()
+			}
+		}
+	}
+	return info
+}
+
+// refreshDeviceInfo stores the current metadata of a session's linked device,
+// reporting platform and version changes; offlineSync is the completed
+// offline sync, if that's what triggered the refresh
+func (ws *WhatsAppService) refreshDeviceInfo(sc *SessionClient, offlineSync *events.OfflineSyncCompleted) {
+	if sc.Device == nil || sc.Device.ID == nil {
+		return
+	}
+	sessionUUID, err := uuid.Parse(sc.SessionID)
+	if err != nil {
+		return
+	}
+	session, err := ws.db.GetSession(sessionUUID, sc.UserID)
+	if err != nil {
+		log.Printf("⚠️  Failed to load device info of session %s: %v", sc.SessionID, err)
+		return
+	}
+
+	info := deviceInfo(sc)
+	previous := session.DeviceInfo
+	if offlineSync != nil {
+		info["offline_sync_at"] = time.Now().UTC()
+		info["offline_sync_count"] = offlineSync.Count
+	} else {
+		// Keep the last offline sync until the next one completes
+		for _, key := range []string{"offline_sync_at", "offline_sync_count"} {
+			if value, ok := previous[key]; ok {
+				info[key] = value
+			}
+		}
+	}
+	info["updated_at"] = time.Now().UTC()
+
+	if err := ws.db.SetSessionDeviceInfo(sc.SessionID, info, sc.Device.Platform); err != nil {
+		log.Printf("⚠️  Failed to store device info of session %s: %v", sc.SessionID, err)
+		return
+	}
+
+	changes := map[string]interface{}{}
+	for _, key := range deviceChangeFields {
+		before, ok := previous[key]
+		if !ok || before == "" || fmt.Sprint(before) == fmt.Sprint(info[key]) {
+			continue
+		}
+		changes[key] = map[string]interface{}{"previous": before, "current": info[key]}
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	log.Printf("📱 Linked device of session %s changed: %v", sc.SessionID, changes)
+	data := map[string]interface{}{
+		"changes": changes,
+		"device":  info,
+	}
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "device_changed", data)
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "device_changed",
+		Data: data,
+	})
+}
+
+// GetSessionDevice returns the linked device metadata of a session
+func (ws *WhatsAppService) GetSessionDevice(sessionID string, userID int) (*SessionDevice, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	device := session.DeviceInfo
+	if device == nil {
+		device = JSONData{}
+	}
+	return &SessionDevice{
+		SessionID: sessionID,
+		Status:    session.Status,
+		Connected: ws.IsSessionConnected(sessionID),
+		Device:    device,
+	}, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-api/pkg/apierr"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= EVENT FILTERS =============
+// High-traffic accounts can cut their event streams (WebSocket, SSE and
+// gRPC, live and replayed) down to the events they act on. A filter selects
+// events by type and by the chat they belong to; it is evaluated on the
+// server before an event is numbered and handed to the connections, so
+// dropped events cost no egress and leave no session_seq gaps. Events are
+// still stored and listed by GET /events either way.
+//
+// A user has a default filter for all of their sessions and may override it
+// per session; a session filter replaces the default rather than adding to
+// it. The chat of an event is its "chat", "chat_jid" or "group_jid" field;
+// events without one (connection changes, QR codes, campaign progress) pass
+// the chat rules and are only filtered by type.
+
+// Chat types an event filter can select
+const (
+	EventChatDirect     = "direct"
+	EventChatGroup      = "group"
+	EventChatStatus     = "status"
+	EventChatBroadcast  = "broadcast"
+	EventChatNewsletter = "newsletter"
+)
+
+var eventChatTypes = map[string]bool{
+	EventChatDirect:     true,
+	EventChatGroup:      true,
+	EventChatStatus:     true,
+	EventChatBroadcast:  true,
+	EventChatNewsletter: true,
+}
+
+// eventDataChatKeys are the event data fields naming the chat of an event,
+// most specific first
+var eventDataChatKeys = []string{"chat", "chat_jid", "group_jid"}
+
+// eventTypePattern is an event type, or a prefix of event types ending in *
+var eventTypePattern = regexp.MustCompile(`^[a-z0-9_]+\*?$|^\*$`)
+
+// EventFilterRules select events. Empty fields don't restrict the result;
+// list values match any entry and exclusions win over inclusions.
+type EventFilterRules struct {
+	EventTypes        []string `json:"event_types,omitempty"`         // e.g. "message", "group_*"
+	ExcludeEventTypes []string `json:"exclude_event_types,omitempty"` // e.g. "presence", "receipt"
+	ChatTypes         []string `json:"chat_types,omitempty"`          // direct, group, status, broadcast, newsletter
+	ExcludeChatTypes  []string `json:"exclude_chat_types,omitempty"`
+	ChatJIDs          []string `json:"chat_jids,omitempty"` // JIDs or phone numbers
+	ExcludeChatJIDs   []string `json:"exclude_chat_jids,omitempty"`
+}
+
+// normalizeEventFilterRules validates rules and brings event types and chats
+// into the form events carry them in
+func normalizeEventFilterRules(rules EventFilterRules) (EventFilterRules, error) {
+	for _, patterns := range []*[]string{&rules.EventTypes, &rules.ExcludeEventTypes} {
+		normalized := make([]string, 0, len(*patterns))
+		for _, pattern := range *patterns {
+			pattern = strings.ToLower(strings.TrimSpace(pattern))
+			if !eventTypePattern.MatchString(pattern) {
+				return rules, fmt.Errorf("invalid event type %q", pattern)
+			}
+			normalized = append(normalized, pattern)
+		}
+		*patterns = uniqueStrings(normalized)
+	}
+
+	for _, chatTypes := range []*[]string{&rules.ChatTypes, &rules.ExcludeChatTypes} {
+		normalized := make([]string, 0, len(*chatTypes))
+		for _, chatType := range *chatTypes {
+			chatType = strings.ToLower(strings.TrimSpace(chatType))
+			if !eventChatTypes[chatType] {
+				return rules, fmt.Errorf("invalid chat type %q: use direct, group, status, broadcast or newsletter", chatType)
+			}
+			normalized = append(normalized, chatType)
+		}
+		*chatTypes = uniqueStrings(normalized)
+	}
+
+	for _, chats := range []*[]string{&rules.ChatJIDs, &rules.ExcludeChatJIDs} {
+		normalized := make([]string, 0, len(*chats))
+		for _, chat := range *chats {
+			jid, err := wajid.Parse(chat)
+			if err != nil {
+				return rules, fmt.Errorf("invalid chat %q: %w", chat, err)
+			}
+			normalized = append(normalized, jid.String())
+		}
+		*chats = uniqueStrings(normalized)
+	}
+
+	return rules, nil
+}
+
+// allows reports whether an event passes the rules
+func (rules EventFilterRules) allows(eventType string, data map[string]interface{}) bool {
+	if len(rules.EventTypes) > 0 && !matchEventType(rules.EventTypes, eventType) {
+		return false
+	}
+	if matchEventType(rules.ExcludeEventTypes, eventType) {
+		return false
+	}
+
+	chat, ok := eventChat(data)
+	if !ok {
+		return true
+	}
+	chatType := eventChatType(chat)
+	if len(rules.ChatTypes) > 0 && !containsString(rules.ChatTypes, chatType) {
+		return false
+	}
+	if containsString(rules.ExcludeChatTypes, chatType) {
+		return false
+	}
+	if len(rules.ChatJIDs) > 0 && !containsString(rules.ChatJIDs, chat.String()) {
+		return false
+	}
+	return !containsString(rules.ExcludeChatJIDs, chat.String())
+}
+
+// matchEventType reports whether an event type matches any of the patterns
+func matchEventType(patterns []string, eventType string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(eventType, prefix) {
+				return true
+			}
+		} else if pattern == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// eventChat returns the chat an event belongs to, if it names one
+func eventChat(data map[string]interface{}) (types.JID, bool) {
+	for _, key := range eventDataChatKeys {
+		value, _ := data[key].(string)
+		if value == "" {
+			continue
+		}
+		jid, err := types.ParseJID(value)
+		if err != nil {
+			continue
+		}
+		if wajid.IsUser(jid) {
+			jid = jid.ToNonAD()
+		}
+		return jid, true
+	}
+	return types.JID{}, false
+}
+
+// eventChatType classifies a chat for the chat type rules
+func eventChatType(chat types.JID) string {
+	switch {
+	case wajid.IsGroup(chat):
+		return EventChatGroup
+	case chat == types.StatusBroadcastJID:
+		return EventChatStatus
+	case chat.Server == types.BroadcastServer:
+		return EventChatBroadcast
+	case chat.Server == types.NewsletterServer:
+		return EventChatNewsletter
+	default:
+		return EventChatDirect
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// eventAllowed reports whether an event of a session passes the filter of the
+// session's owner. Filters are loaded once per user and cached until changed.
+func (wsm *WebSocketManager) eventAllowed(sessionID string, userID int, eventType string, data map[string]interface{}) bool {
+	var filters map[string]EventFilterRules
+	if cached, ok := wsm.eventFilters.Load(userID); ok {
+		filters = cached.(map[string]EventFilterRules)
+	} else {
+		rows, err := wsm.db.GetEventFilters(userID)
+		if err != nil {
+			// Forward everything rather than lose events
+			log.Printf("⚠️  Failed to load event filters of user %d: %v", userID, err)
+			return true
+		}
+		filters = make(map[string]EventFilterRules, len(rows))
+		for _, row := range rows {
+			filters[row.SessionID] = row.Rules
+		}
+		wsm.eventFilters.Store(userID, filters)
+	}
+
+	rules, ok := filters[sessionID]
+	if !ok {
+		if rules, ok = filters[""]; !ok {
+			return true
+		}
+	}
+	return rules.allows(eventType, data)
+}
+
+// invalidateEventFilters drops the cached filters of a user
+func (wsm *WebSocketManager) invalidateEventFilters(userID int) {
+	wsm.eventFilters.Delete(userID)
+}
+
+// GetEventFilters lists the default event filter of a user and those of
+// single sessions
+func (ws *WhatsAppService) GetEventFilters(userID int) ([]WhatsAppEventFilter, error) {
+	filters, err := ws.db.GetEventFilters(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load event filters: %w", err)
+	}
+	return filters, nil
+}
+
+// eventFilterSession checks the session of a filter; an empty sessionID is
+// the user's default filter
+func (ws *WhatsAppService) eventFilterSession(sessionID string, userID int) error {
+	if sessionID == "" {
+		return nil
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return apierr.ErrSessionNotFound
+	}
+	return nil
+}
+
+// SetEventFilter creates or replaces the event filter of a session, or the
+// user's default filter for an empty sessionID
+func (ws *WhatsAppService) SetEventFilter(userID int, sessionID string, rules EventFilterRules) (*WhatsAppEventFilter, error) {
+	if err := ws.eventFilterSession(sessionID, userID); err != nil {
+		return nil, err
+	}
+	rules, err := normalizeEventFilterRules(rules)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", apierr.ErrInvalidRequest, err)
+	}
+
+	filter := &WhatsAppEventFilter{
+		UserID:    userID,
+		SessionID: sessionID,
+		Rules:     rules,
+	}
+	if err := ws.db.SaveEventFilter(filter); err != nil {
+		return nil, fmt.Errorf("failed to save event filter: %w", err)
+	}
+	ws.wsManager.invalidateEventFilters(userID)
+
+	saved, err := ws.db.GetEventFilter(userID, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load event filter: %w", err)
+	}
+	return saved, nil
+}
+
+// DeleteEventFilter removes the event filter of a session, so the user's
+// default applies again, or the default filter for an empty sessionID
+func (ws *WhatsAppService) DeleteEventFilter(userID int, sessionID string) error {
+	if err := ws.eventFilterSession(sessionID, userID); err != nil {
+		return err
+	}
+	deleted, err := ws.db.DeleteEventFilter(userID, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to delete event filter: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("event filter not found")
+	}
+	ws.wsManager.invalidateEventFilters(userID)
+	return nil
+}
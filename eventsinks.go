@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"whatsapp-api/internal/eventsink"
+)
+
+// ============= EVENT SINKS =============
+// With EVENT_SINK set, stored events (whats_app_events) and, unless
+// EVENT_SINK_MESSAGES=false, stored messages are streamed to Kafka, NATS
+// JetStream or SQS for analytics pipelines, on top of being kept in the
+// database. The exporter tails both tables in ID order from cursors in
+// whats_app_aggregation_cursors: a batch is published and the cursor moved
+// past it while the cursor row is locked, so with several instances one
+// exports at a time and the order is kept. A batch that fails is published
+// again on the next run, making delivery at least once; every record has a
+// stable ID ("event:<id>", "message:<id>") to deduplicate on. Rows are
+// exported once they are eventSinkSettle old, so rows of inserts that commit
+// late aren't passed by the cursor. A new cursor starts at the newest row;
+// enabling a sink doesn't replay the history.
+
+const (
+	eventSinkInterval       = 2 * time.Second
+	eventSinkSettle         = 5 * time.Second
+	eventSinkBatchSize      = 500
+	eventSinkPublishTimeout = 30 * time.Second
+	eventSinkRetryDelay     = 30 * time.Second // after a failed publish
+	eventSinkMessageType    = "message"
+)
+
+// EventExporter streams stored events and messages to the event sink
+type EventExporter struct {
+	db        *DatabaseManager
+	sink      eventsink.Sink
+	name      string
+	messages  bool
+	failedAt  time.Time // of the last failed publish
+	lastError string
+}
+
+// NewEventExporter creates the exporter of the configured sink, nil when
+// EVENT_SINK isn't set
+func NewEventExporter(cfg *Config, db *DatabaseManager) (*EventExporter, error) {
+	if cfg.EventSink == "" {
+		return nil, nil
+	}
+	sink, err := eventsink.New(eventsink.Config{
+		Sink:               cfg.EventSink,
+		KafkaBrokers:       splitList(cfg.KafkaBrokers),
+		KafkaTopic:         cfg.KafkaTopic,
+		KafkaTLS:           cfg.KafkaTLS,
+		KafkaUsername:      cfg.KafkaUsername,
+		KafkaPassword:      cfg.KafkaPassword,
+		NATSURL:            cfg.NATSURL,
+		NATSSubject:        cfg.NATSSubject,
+		SQSQueueURL:        cfg.SQSQueueURL,
+		SQSRegion:          cfg.SQSRegion,
+		SQSAccessKeyID:     cfg.SQSAccessKeyID,
+		SQSSecretAccessKey: cfg.SQSSecretAccessKey,
+		SQSSessionToken:    cfg.SQSSessionToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &EventExporter{db: db, sink: sink, name: cfg.EventSink, messages: cfg.EventSinkMessages}, nil
+}
+
+// Start exports new events and messages until the context is cancelled
+func (e *EventExporter) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(eventSinkInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if time.Since(e.failedAt) >= eventSinkRetryDelay {
+					e.export(ctx)
+				}
+			}
+		}
+	}()
+	log.Printf("✅ Event sink worker started (%s, messages: %t)", e.name, e.messages)
+}
+
+// Close closes the connection to the sink
+func (e *EventExporter) Close() {
+	if err := e.sink.Close(); err != nil {
+		log.Printf("⚠️  Failed to close event sink: %v", err)
+	}
+}
+
+// export publishes everything stored since the last run, one batch per
+// transaction
+func (e *EventExporter) export(ctx context.Context) {
+	before := time.Now().Add(-eventSinkSettle)
+
+	err := e.drain(ctx, func() (int, error) {
+		return e.db.ExportEvents(eventSinkBatchSize, before, func(rows []WhatsAppEvent) error {
+			events := make([]eventsink.Event, len(rows))
+			for i, row := range rows {
+				events[i] = eventsink.Event{
+					ID:        fmt.Sprintf("event:%d", row.ID),
+					Type:      row.EventType,
+					SessionID: row.SessionID,
+					UserID:    row.UserID,
+					Data:      row.EventData,
+					CreatedAt: row.CreatedAt,
+				}
+			}
+			return e.publish(ctx, events)
+		})
+	})
+	if err == nil && e.messages {
+		err = e.drain(ctx, func() (int, error) {
+			return e.db.ExportMessages(eventSinkBatchSize, before, func(rows []WhatsAppMessage) error {
+				events := make([]eventsink.Event, len(rows))
+				for i := range rows {
+					events[i] = eventsink.Event{
+						ID:        fmt.Sprintf("message:%d", rows[i].ID),
+						Type:      eventSinkMessageType,
+						SessionID: rows[i].SessionID,
+						UserID:    rows[i].UserID,
+						Data:      &rows[i],
+						CreatedAt: rows[i].CreatedAt,
+					}
+				}
+				return e.publish(ctx, events)
+			})
+		})
+	}
+
+	if err != nil {
+		e.failedAt = time.Now()
+		// Repeats of the same failure are logged once
+		if err.Error() != e.lastError {
+			log.Printf("❌ Failed to export events to %s (retrying every %s): %v", e.name, eventSinkRetryDelay, err)
+		}
+		e.lastError = err.Error()
+		return
+	}
+	if e.lastError != "" {
+		log.Printf("✅ Exporting events to %s again", e.name)
+		e.lastError = ""
+	}
+}
+
+// drain exports batches until one isn't full
+func (e *EventExporter) drain(ctx context.Context, exportBatch func() (int, error)) error {
+	for ctx.Err() == nil {
+		exported, err := exportBatch()
+		if err != nil {
+			return err
+		}
+		if exported < eventSinkBatchSize {
+			break
+		}
+	}
+	return nil
+}
+
+func (e *EventExporter) publish(ctx context.Context, events []eventsink.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, eventSinkPublishTimeout)
+	defer cancel()
+	return e.sink.Publish(ctx, events)
+}
+
+// splitList splits a comma-separated setting, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"whatsapp-api/pkg/apierr"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= CHAT EXPORTS =============
+// A conversation stored in the messages table can be exported as JSON or CSV.
+// Exports run in two steps: the request creates a job that is written to
+// EXPORT_DIR in the background, and the file is downloaded once the job is
+// completed. Messages are streamed from the database in batches, so large
+// chats don't have to fit in memory. Files (finished or not) are deleted
+// EXPORT_TTL after the job ends.
+
+const (
+	chatExportBatchSize       = 500
+	chatExportCleanupInterval = time.Hour
+)
+
+// chatExportFormats are the supported export file formats
+var chatExportFormats = map[string]string{
+	"json": "application/json",
+	"csv":  "text/csv",
+}
+
+// chatExportMessage is one message in an export file
+type chatExportMessage struct {
+	MessageID string    `json:"message_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Direction string    `json:"direction"` // incoming or outgoing
+	SenderJID string    `json:"sender_jid"`
+	PushName  string    `json:"push_name,omitempty"`
+	Type      string    `json:"type"`
+	Content   string    `json:"content"`
+	Media     JSONData  `json:"media,omitempty"`
+}
+
+func newChatExportMessage(msg *WhatsAppMessage) chatExportMessage {
+	direction := "incoming"
+	if msg.FromMe {
+		direction = "outgoing"
+	}
+	return chatExportMessage{
+		MessageID: msg.MessageID,
+		Timestamp: msg.Timestamp.UTC(),
+		Direction: direction,
+		SenderJID: msg.SenderJID,
+		PushName:  msg.PushName,
+		Type:      msg.MessageType,
+		Content:   msg.Content,
+		Media:     msg.Media,
+	}
+}
+
+// ExportChat starts exporting a stored conversation. The chat JID may be a
+// phone number.
+func (ws *WhatsAppService) ExportChat(sessionID string, userID int, chat, format string) (*WhatsAppChatExport, error) {
+	format = strings.ToLower(format)
+	if format == "" {
+		format = "json"
+	}
+	if _, ok := chatExportFormats[format]; !ok {
+		return nil, fmt.Errorf("format must be json or csv")
+	}
+
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	chatJID, err := wajid.Parse(chat)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := ws.db.CountChatMessages(sessionID, chatJID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to count messages: %w", err)
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("chat not found or has no stored messages")
+	}
+
+	export := &WhatsAppChatExport{
+		UserID:    userID,
+		SessionID: sessionID,
+		ChatJID:   chatJID.String(),
+		Format:    format,
+		Status:    ExportPending,
+	}
+	if err := ws.db.CreateChatExport(export); err != nil {
+		return nil, fmt.Errorf("failed to create export: %w", err)
+	}
+
+	go ws.runChatExport(*export)
+
+	log.Printf("📦 Export %d of chat %s queued (%d messages, %s)", export.ID, export.ChatJID, count, format)
+	return export, nil
+}
+
+// GetChatExport returns an export job of the user
+func (ws *WhatsAppService) GetChatExport(userID int, exportID int64) (*WhatsAppChatExport, error) {
+	export, err := ws.db.GetChatExport(exportID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("export not found")
+		}
+		return nil, fmt.Errorf("failed to load export: %w", err)
+	}
+	return export, nil
+}
+
+// chatExportFileName is the download name of an export
+func chatExportFileName(export *WhatsAppChatExport) string {
+	name := strings.NewReplacer("@", "_", ":", "_", ".", "_").Replace(export.ChatJID)
+	return fmt.Sprintf("chat-%s-%s.%s", name, export.CreatedAt.Format("20060102-150405"), export.Format)
+}
+
+// runChatExport writes the export file and records the outcome
+func (ws *WhatsAppService) runChatExport(export WhatsAppChatExport) {
+	ws.db.UpdateChatExport(export.ID, map[string]interface{}{"status": ExportRunning})
+
+	filePath, size, messages, err := ws.writeChatExport(&export)
+	now := time.Now()
+	updates := map[string]interface{}{
+		"completed_at": now,
+		"expires_at":   now.Add(ws.cfg.ExportTTL),
+	}
+	eventType := "chat_export_ready"
+	if err != nil {
+		log.Printf("❌ Export %d of chat %s failed: %v", export.ID, export.ChatJID, err)
+		updates["status"] = ExportFailed
+		updates["error"] = err.Error()
+		eventType = "chat_export_failed"
+	} else {
+		log.Printf("📦 Export %d of chat %s completed (%d messages, %d bytes)", export.ID, export.ChatJID, messages, size)
+		updates["status"] = ExportCompleted
+		updates["file_path"] = filePath
+		updates["file_size"] = size
+		updates["messages"] = messages
+	}
+	if err := ws.db.UpdateChatExport(export.ID, updates); err != nil {
+		log.Printf("❌ Failed to update export %d: %v", export.ID, err)
+	}
+
+	data := map[string]interface{}{
+		"export_id": export.ID,
+		"chat_jid":  export.ChatJID,
+		"format":    export.Format,
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	} else {
+		data["messages"] = messages
+	}
+
+	sessionUUID, _ := uuid.Parse(export.SessionID)
+	ws.db.CreateEvent(sessionUUID, export.UserID, eventType, data)
+	ws.wsManager.SendToSession(export.SessionID, WebSocketMessage{
+		Type: eventType,
+		Data: data,
+	})
+}
+
+// writeChatExport streams the chat into a file under EXPORT_DIR. The file is
+// removed again if anything fails.
+func (ws *WhatsAppService) writeChatExport(export *WhatsAppChatExport) (string, int64, int, error) {
+	dir := filepath.Join(ws.cfg.ExportDir, export.SessionID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, 0, fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	filePath := filepath.Join(dir, fmt.Sprintf("%d.%s", export.ID, export.Format))
+	file, err := os.Create(filePath)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("failed to create export file: %w", err)
+	}
+
+	w := bufio.NewWriter(file)
+	var messages int
+	if export.Format == "csv" {
+		messages, err = ws.writeChatExportCSV(w, export)
+	} else {
+		messages, err = ws.writeChatExportJSON(w, export)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filePath)
+		return "", 0, 0, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("failed to stat export file: %w", err)
+	}
+	return filePath, info.Size(), messages, nil
+}
+
+// writeChatExportJSON writes {"session_id", "chat_jid", "exported_at", "messages": [...]}
+func (ws *WhatsAppService) writeChatExportJSON(w io.Writer, export *WhatsAppChatExport) (int, error) {
+	header, err := json.Marshal(map[string]interface{}{
+		"session_id":  export.SessionID,
+		"chat_jid":    export.ChatJID,
+		"exported_at": time.Now().UTC(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	// Reopen the header object to append the messages array
+	if _, err := fmt.Fprintf(w, "%s,\"messages\":[", header[:len(header)-1]); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = ws.db.EachChatMessage(export.SessionID, export.ChatJID, chatExportBatchSize, func(batch []WhatsAppMessage) error {
+		for i := range batch {
+			line, err := json.Marshal(newChatExportMessage(&batch[i]))
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to export messages: %w", err)
+	}
+
+	if _, err := io.WriteString(w, "]}\n"); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// writeChatExportCSV writes one row per message. Media references are split
+// into columns so the file opens cleanly in spreadsheets.
+func (ws *WhatsAppService) writeChatExportCSV(w io.Writer, export *WhatsAppChatExport) (int, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{
+		"timestamp", "direction", "sender_jid", "push_name", "message_id", "type", "content",
+		"media_mimetype", "media_file_name", "media_caption", "media_direct_path",
+	}); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err := ws.db.EachChatMessage(export.SessionID, export.ChatJID, chatExportBatchSize, func(batch []WhatsAppMessage) error {
+		for i := range batch {
+			msg := newChatExportMessage(&batch[i])
+			if err := cw.Write([]string{
+				msg.Timestamp.Format(time.RFC3339),
+				msg.Direction,
+				msg.SenderJID,
+				msg.PushName,
+				msg.MessageID,
+				msg.Type,
+				msg.Content,
+				mediaField(msg.Media, "mimetype"),
+				mediaField(msg.Media, "file_name"),
+				mediaField(msg.Media, "caption"),
+				mediaField(msg.Media, "direct_path"),
+			}); err != nil {
+				return err
+			}
+			count++
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to export messages: %w", err)
+	}
+	return count, nil
+}
+
+// mediaField reads a string value of a media reference
+func mediaField(media JSONData, key string) string {
+	value, _ := media[key].(string)
+	return value
+}
+
+// StartExportCleaner fails exports interrupted by a restart and deletes
+// expired export files until the context is cancelled
+func (ws *WhatsAppService) StartExportCleaner(ctx context.Context) {
+	if failed, err := ws.db.FailInterruptedChatExports(time.Now().Add(ws.cfg.ExportTTL)); err != nil {
+		log.Printf("❌ Failed to reset interrupted exports: %v", err)
+	} else if failed > 0 {
+		log.Printf("⚠️  Marked %d interrupted chat exports as failed", failed)
+	}
+
+	go func() {
+		ticker := time.NewTicker(chatExportCleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ws.deleteExpiredExports()
+			}
+		}
+	}()
+	log.Println("✅ Export cleaner started")
+}
+
+func (ws *WhatsAppService) deleteExpiredExports() {
+	exports, err := ws.db.GetExpiredChatExports(time.Now())
+	if err != nil {
+		log.Printf("❌ Failed to load expired exports: %v", err)
+		return
+	}
+
+	for _, export := range exports {
+		filePath := export.FilePath
+		if filePath == "" {
+			// Interrupted exports never recorded their file
+			filePath = filepath.Join(ws.cfg.ExportDir, export.SessionID, fmt.Sprintf("%d.%s", export.ID, export.Format))
+		}
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("⚠️  Failed to delete export file %s: %v", filePath, err)
+			continue
+		}
+		if err := ws.db.DeleteChatExport(export.ID); err != nil {
+			log.Printf("⚠️  Failed to delete export %d: %v", export.ID, err)
+		}
+	}
+}
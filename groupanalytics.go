@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= GROUP ANALYTICS =============
+// Incoming group messages stored in the messages table are rolled up into
+// per day, per sender counts (WhatsAppGroupDailyStat) by a background job,
+// so analytics only read the small stats table. The job reads the messages
+// table in ID order from a cursor; history sync imports are counted on the
+// day they were sent, whenever they arrive. Stats lag behind by up to
+// groupStatsInterval.
+
+const (
+	groupStatsInterval  = 5 * time.Minute
+	groupStatsBatchSize = 5000
+	groupStatsMaxDays   = 365
+	groupStatsMaxTop    = 100
+)
+
+// GroupAnalytics is the activity of a group over the last days
+type GroupAnalytics struct {
+	GroupJID        string             `json:"group_jid"`
+	Since           time.Time          `json:"since"`
+	TotalMessages   int                `json:"total_messages"`
+	ActiveMembers   int64              `json:"active_members"`
+	MessagesPerDay  []GroupDayCount    `json:"messages_per_day"`
+	TopSenders      []GroupSenderCount `json:"top_senders"`
+	LastActivityAt  *time.Time         `json:"last_activity_at"`
+	AggregatedUntil *time.Time         `json:"aggregated_until"` // last run of the aggregation job
+}
+
+// GetGroupAnalytics returns message counts per day, top senders and the last
+// activity of a group from the aggregated stats
+func (ws *WhatsAppService) GetGroupAnalytics(sessionID string, userID int, group string, days, top int) (*GroupAnalytics, error) {
+	if days < 1 || days > groupStatsMaxDays {
+		return nil, fmt.Errorf("days must be between 1 and %d", groupStatsMaxDays)
+	}
+	if top < 1 || top > groupStatsMaxTop {
+		return nil, fmt.Errorf("top must be between 1 and %d", groupStatsMaxTop)
+	}
+
+	groupJID, err := parseGroupJID(group)
+	if err != nil {
+		return nil, err
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	chatJID := groupJID.String()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	analytics := &GroupAnalytics{
+		GroupJID: chatJID,
+		Since:    today.AddDate(0, 0, -(days - 1)),
+	}
+
+	if analytics.MessagesPerDay, err = ws.db.GetGroupDayCounts(sessionID, chatJID, analytics.Since); err != nil {
+		return nil, fmt.Errorf("failed to load group stats: %w", err)
+	}
+	for _, day := range analytics.MessagesPerDay {
+		analytics.TotalMessages += day.Messages
+	}
+	if analytics.ActiveMembers, err = ws.db.CountGroupSenders(sessionID, chatJID, analytics.Since); err != nil {
+		return nil, fmt.Errorf("failed to load group stats: %w", err)
+	}
+	if analytics.TopSenders, err = ws.db.GetGroupTopSenders(sessionID, chatJID, analytics.Since, top); err != nil {
+		return nil, fmt.Errorf("failed to load group stats: %w", err)
+	}
+	if analytics.LastActivityAt, err = ws.db.GetGroupLastActivity(sessionID, chatJID); err != nil {
+		return nil, fmt.Errorf("failed to load group stats: %w", err)
+	}
+	if cursor, err := ws.db.GetAggregationCursor(groupStatsCursor); err == nil && cursor != nil {
+		analytics.AggregatedUntil = &cursor.UpdatedAt
+	}
+
+	ws.nameSenders(userID, analytics.TopSenders)
+	return analytics, nil
+}
+
+// nameSenders fills in the stored contact names of top senders
+func (ws *WhatsAppService) nameSenders(userID int, senders []GroupSenderCount) {
+	jids := make([]string, len(senders))
+	for i, sender := range senders {
+		jids[i] = sender.SenderJID
+	}
+	contacts, err := ws.db.GetContactsByJIDs(userID, jids)
+	if err != nil {
+		log.Printf("⚠️  Failed to load sender names: %v", err)
+		return
+	}
+	names := make(map[string]string, len(contacts))
+	for _, contact := range contacts {
+		names[contact.JID] = contact.FullName
+	}
+	for i := range senders {
+		senders[i].Name = names[senders[i].SenderJID]
+	}
+}
+
+// StartGroupStatsWorker aggregates new group messages until the context is
+// cancelled
+func (ws *WhatsAppService) StartGroupStatsWorker(ctx context.Context) {
+	go func() {
+		ws.aggregateGroupStats(ctx)
+
+		ticker := time.NewTicker(groupStatsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ws.aggregateGroupStats(ctx)
+			}
+		}
+	}()
+	log.Println("✅ Group stats worker started")
+}
+
+// aggregateGroupStats rolls up all messages stored since the last run, one
+// batch per transaction
+func (ws *WhatsAppService) aggregateGroupStats(ctx context.Context) {
+	total := 0
+	for ctx.Err() == nil {
+		read, err := ws.db.AggregateGroupMessages(groupStatsBatchSize)
+		if err != nil {
+			log.Printf("❌ Failed to aggregate group stats: %v", err)
+			return
+		}
+		total += read
+		if read < groupStatsBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("📊 Aggregated %d stored message(s) into group stats", total)
+	}
+}
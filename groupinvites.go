@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"gorm.io/gorm"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= GROUP INVITE LINK JOINS =============
+// Participants joining a group through its invite link emit
+// group_invite_join and are counted on the link, so growth can be attributed
+// to the links handed out. WhatsApp's join notification only says a user
+// joined "via invite", not with which code, so joins are counted on the
+// group's current link: the one last fetched, revoked or changed through
+// this session. A link reset by an admin on their phone is recorded from the
+// group change notification; with no link recorded yet the current one is
+// fetched, which needs the session to be a group admin. Each recorded code
+// keeps its own counter, so a revoked link keeps the joins it brought in.
+
+const inviteLinkPrefix = "https://chat.whatsapp.com/"
+
+// groupJoinReasonInvite is the join reason of a participant who used the
+// group's invite link
+const groupJoinReasonInvite = "invite"
+
+// handleGroupInfoEvent records invite link changes and joins through the link
+func (ws *WhatsAppService) handleGroupInfoEvent(sc *SessionClient, evt *events.GroupInfo) {
+	if evt.NewInviteLink != nil {
+		if code, err := normalizeInviteCode(*evt.NewInviteLink); err == nil {
+			ws.recordInviteLink(sc, evt.JID, code)
+		}
+	}
+	if len(evt.Join) > 0 && evt.JoinReason == groupJoinReasonInvite {
+		ws.recordInviteJoins(sc, evt)
+	}
+}
+
+// recordInviteLink stores the current invite link of a group
+func (ws *WhatsAppService) recordInviteLink(sc *SessionClient, groupJID types.JID, code string) *WhatsAppGroupInviteLink {
+	link, err := ws.db.RecordGroupInviteLink(sc.SessionID, sc.UserID, groupJID.String(), code)
+	if err != nil {
+		log.Printf("⚠️  Failed to record invite link of group %s for session %s: %v", groupJID.String(), sc.SessionID, err)
+		return nil
+	}
+	link.Link = inviteLinkPrefix + link.Code
+	return link
+}
+
+// currentInviteLink returns the recorded current link of a group, fetching it
+// from WhatsApp when none is recorded
+func (ws *WhatsAppService) currentInviteLink(sc *SessionClient, groupJID types.JID) *WhatsAppGroupInviteLink {
+	link, err := ws.db.GetCurrentGroupInviteLink(sc.SessionID, groupJID.String())
+	if err == nil {
+		link.Link = inviteLinkPrefix + link.Code
+		return link
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("⚠️  Failed to load invite link of group %s for session %s: %v", groupJID.String(), sc.SessionID, err)
+		return nil
+	}
+
+	code, err := ws.fetchInviteCode(sc, groupJID)
+	if err != nil {
+		// Only admins may see the link
+		log.Printf("ℹ️  No invite link to attribute joins of group %s to: %v", groupJID.String(), err)
+		return nil
+	}
+	return ws.recordInviteLink(sc, groupJID, code)
+}
+
+// fetchInviteCode gets the current invite code of a group from WhatsApp
+func (ws *WhatsAppService) fetchInviteCode(sc *SessionClient, groupJID types.JID) (string, error) {
+	var url string
+	err := ws.callWhatsApp(sc, priorityInteractive, "get invite link", func() error {
+		var err error
+		url, err = sc.Client.GetGroupInviteLink(context.Background(), groupJID, false)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return normalizeInviteCode(url)
+}
+
+// recordInviteJoins counts joins through the invite link on the group's
+// current link and reports them
+func (ws *WhatsAppService) recordInviteJoins(sc *SessionClient, evt *events.GroupInfo) {
+	participants := make([]map[string]string, 0, len(evt.Join))
+	for _, jid := range evt.Join {
+		participant := map[string]string{"jid": jid.String()}
+		if phone := ws.phoneForJID(sc, jid); phone != "" {
+			participant["phone_number"] = phone
+		}
+		participants = append(participants, participant)
+	}
+
+	data := map[string]interface{}{
+		"group_jid":    evt.JID.String(),
+		"participants": participants,
+		"timestamp":    evt.Timestamp,
+	}
+	if link := ws.currentInviteLink(sc, evt.JID); link != nil {
+		if err := ws.db.AddGroupInviteJoins(link.ID, len(evt.Join), evt.Timestamp); err != nil {
+			log.Printf("⚠️  Failed to count joins of invite link %s: %v", link.Code, err)
+		}
+		data["invite_code"] = link.Code
+		data["invite_link"] = link.Link
+		data["link_joins"] = link.Joins + len(evt.Join)
+	}
+	log.Printf("🔗 %d joined group %s via invite link for session %s", len(evt.Join), evt.JID.String(), sc.SessionID)
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "group_invite_join", data)
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "group_invite_join",
+		Data: data,
+	})
+}
+
+// GetGroupInviteLink returns the current invite link of a group and records
+// it for join counting
+func (ws *WhatsAppService) GetGroupInviteLink(sessionID string, userID int, group string) (*WhatsAppGroupInviteLink, error) {
+	sc, groupJID, err := ws.getGroupTarget(sessionID, userID, group)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := ws.fetchInviteCode(sc, groupJID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invite link: %w", err)
+	}
+	link := ws.recordInviteLink(sc, groupJID, code)
+	if link == nil {
+		return nil, fmt.Errorf("failed to record invite link")
+	}
+	return link, nil
+}
+
+// GetGroupInviteLinks lists the recorded invite links of a group with their
+// join counts, newest first
+func (ws *WhatsAppService) GetGroupInviteLinks(sessionID string, userID int, group string) ([]WhatsAppGroupInviteLink, error) {
+	groupJID, err := parseGroupJID(group)
+	if err != nil {
+		return nil, err
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	links, err := ws.db.GetGroupInviteLinks(sessionID, groupJID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invite links: %w", err)
+	}
+	for i := range links {
+		links[i].Link = inviteLinkPrefix + links[i].Code
+	}
+	return links, nil
+}
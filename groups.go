@@ -0,0 +1,882 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= GROUP MANAGEMENT =============
+
+// GroupJoinRequest is a pending request to join a group
+type GroupJoinRequest struct {
+	JID         string    `json:"jid"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// GroupParticipantResult is the outcome of a participant change for one JID
+type GroupParticipantResult struct {
+	JID       string `json:"jid"`
+	Success   bool   `json:"success"`
+	ErrorCode int    `json:"error_code,omitempty"`
+}
+
+// parseGroupJID parses a group given as a full JID or just the part before @g.us
+func parseGroupJID(group string) (types.JID, error) {
+	if !strings.Contains(group, "@") {
+		group = group + "@" + types.GroupServer
+	}
+
+	groupJID, err := wajid.ParseJID(group)
+	if err != nil {
+		return types.JID{}, fmt.Errorf("invalid group JID: %w", err)
+	}
+	if !wajid.IsGroup(groupJID) {
+		return types.JID{}, fmt.Errorf("invalid group JID: %s is not a group", groupJID.String())
+	}
+	return groupJID, nil
+}
+
+// getGroupTarget resolves a connected client and group JID
+func (ws *WhatsAppService) getGroupTarget(sessionID string, userID int, group string) (*SessionClient, types.JID, error) {
+	groupJID, err := parseGroupJID(group)
+	if err != nil {
+		return nil, types.JID{}, err
+	}
+
+	sc, err := ws.getConnectedClient(sessionID, userID)
+	if err != nil {
+		return nil, types.JID{}, err
+	}
+
+	return sc, groupJID, nil
+}
+
+// parseParticipantJIDs converts JIDs or phone numbers into user JIDs
+func parseParticipantJIDs(participants []string) ([]types.JID, error) {
+	jids := make([]types.JID, 0, len(participants))
+	for _, participant := range participants {
+		jid, err := wajid.Parse(participant)
+		if err != nil {
+			return nil, fmt.Errorf("invalid participant %q: %w", participant, err)
+		}
+		if !wajid.IsUser(jid) {
+			return nil, fmt.Errorf("invalid participant %q: not a user", participant)
+		}
+		jids = append(jids, jid)
+	}
+	return jids, nil
+}
+
+// GetGroupJoinRequests lists pending join requests of a group
+func (ws *WhatsAppService) GetGroupJoinRequests(sessionID string, userID int, group string) ([]GroupJoinRequest, error) {
+	sc, groupJID, err := ws.getGroupTarget(sessionID, userID, group)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []types.GroupParticipantRequest
+	err = ws.callWhatsApp(sc, priorityInteractive, "get join requests", func() error {
+		var err error
+		pending, err = sc.Client.GetGroupRequestParticipants(context.Background(), groupJID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get join requests: %w", err)
+	}
+
+	requests := make([]GroupJoinRequest, 0, len(pending))
+	for _, req := range pending {
+		requests = append(requests, GroupJoinRequest{
+			JID:         req.JID.String(),
+			RequestedAt: req.RequestedAt,
+		})
+	}
+	return requests, nil
+}
+
+// UpdateGroupJoinRequests approves or rejects pending join requests
+func (ws *WhatsAppService) UpdateGroupJoinRequests(sessionID string, userID int, group string, participants []string, approve bool) ([]GroupParticipantResult, error) {
+	jids, err := parseParticipantJIDs(participants)
+	if err != nil {
+		return nil, err
+	}
+	if len(jids) == 0 {
+		return nil, fmt.Errorf("at least one participant is required")
+	}
+
+	sc, groupJID, err := ws.getGroupTarget(sessionID, userID, group)
+	if err != nil {
+		return nil, err
+	}
+
+	action, verb := whatsmeow.ParticipantChangeReject, "rejected"
+	if approve {
+		action, verb = whatsmeow.ParticipantChangeApprove, "approved"
+	}
+
+	var changed []types.GroupParticipant
+	err = ws.callWhatsApp(sc, priorityInteractive, "update join requests", func() error {
+		var err error
+		changed, err = sc.Client.UpdateGroupRequestParticipants(context.Background(), groupJID, jids, action)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s join requests: %w", action, err)
+	}
+
+	results := make([]GroupParticipantResult, 0, len(changed))
+	for _, participant := range changed {
+		results = append(results, GroupParticipantResult{
+			JID:       participant.JID.String(),
+			Success:   participant.Error == 0,
+			ErrorCode: participant.Error,
+		})
+	}
+
+	log.Printf("👥 %d join request(s) %s for group %s", len(jids), verb, groupJID.String())
+
+	sessionUUID, _ := uuid.Parse(sessionID)
+	ws.db.CreateEvent(sessionUUID, userID, "group_join_requests_"+verb, map[string]interface{}{
+		"group_jid":    groupJID.String(),
+		"participants": participants,
+	})
+
+	return results, nil
+}
+
+// Adding participants: WhatsApp answers per participant. 403 means the
+// user's privacy settings don't let the session add them; with the invite
+// fallback they are sent the group's invite link by DM instead. Adds are
+// sent in batches and invites one by one, with a pause in between (on top of
+// the safety engine's pacing of the DMs).
+const (
+	maxParticipantAdd       = 256
+	participantAddBatchSize = 20
+	participantAddDelay     = 3 * time.Second
+	defaultGroupInviteText  = "You're invited to join our WhatsApp group: {link}"
+)
+
+// Participant add statuses
+const (
+	ParticipantAdded         = "added"
+	ParticipantAlreadyMember = "already_member"
+	ParticipantNotAllowed    = "not_allowed" // privacy settings refused the add
+	ParticipantInvited       = "invited"
+	ParticipantInviteFailed  = "invite_failed"
+	ParticipantSuppressed    = "suppressed" // not allowed, and opted out of DMs
+	ParticipantAddFailed     = "failed"
+)
+
+// GroupParticipantAddResult is the outcome of adding one participant
+type GroupParticipantAddResult struct {
+	Participant     string `json:"participant"`
+	JID             string `json:"jid"`
+	Status          string `json:"status"`
+	ErrorCode       int    `json:"error_code,omitempty"`
+	Error           string `json:"error,omitempty"`
+	InviteMessageID string `json:"invite_message_id,omitempty"`
+}
+
+// GroupParticipantAdd is a request to add participants to a group
+type GroupParticipantAdd struct {
+	Participants   []string `json:"participants" binding:"required,min=1"`
+	InviteFallback bool     `json:"invite_fallback"` // DM the invite link to users who can't be added
+	InviteMessage  string   `json:"invite_message"`  // {link} is replaced by the invite link, otherwise it is appended
+}
+
+// AddGroupParticipants adds participants to a group in throttled batches and,
+// with the invite fallback, DMs the invite link to those who can't be added
+func (ws *WhatsAppService) AddGroupParticipants(sessionID string, userID int, group string, req GroupParticipantAdd) ([]GroupParticipantAddResult, error) {
+	if len(req.Participants) > maxParticipantAdd {
+		return nil, fmt.Errorf("at most %d participants can be added at once", maxParticipantAdd)
+	}
+	jids, err := parseParticipantJIDs(req.Participants)
+	if err != nil {
+		return nil, err
+	}
+	if len(jids) == 0 {
+		return nil, fmt.Errorf("at least one participant is required")
+	}
+
+	sc, groupJID, err := ws.getGroupTarget(sessionID, userID, group)
+	if err != nil {
+		return nil, err
+	}
+
+	results := ws.addParticipants(sc, groupJID, req.Participants, jids)
+
+	if req.InviteFallback {
+		ws.inviteParticipants(sc, groupJID, jids, results, req.InviteMessage)
+	}
+
+	counts := make(map[string]int)
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	log.Printf("👥 Added participants to group %s: %v", groupJID.String(), counts)
+
+	sessionUUID, _ := uuid.Parse(sessionID)
+	ws.db.CreateEvent(sessionUUID, userID, "group_participants_added", map[string]interface{}{
+		"group_jid": groupJID.String(),
+		"counts":    counts,
+	})
+
+	return results, nil
+}
+
+// maxGroupNameLength is WhatsApp's limit on group names (longer ones are refused)
+const maxGroupNameLength = 25
+
+// GroupCreateInvite is a request to create a group and bring in its members:
+// those who can't be added are sent the invite link by DM
+type GroupCreateInvite struct {
+	SessionID     string   `json:"session_id" binding:"required"`
+	Name          string   `json:"name" binding:"required"`
+	Participants  []string `json:"participants" binding:"required,min=1"`
+	InviteMessage string   `json:"invite_message"` // {link} is replaced by the invite link, otherwise it is appended
+}
+
+// GroupCreateInviteResult is the created group and the outcome per participant
+type GroupCreateInviteResult struct {
+	GroupJID string                      `json:"group_jid"`
+	Name     string                      `json:"name"`
+	Results  []GroupParticipantAddResult `json:"results"`
+	Summary  map[string]int              `json:"summary"`
+}
+
+// CreateGroupAndInvite creates a group, adds the participants in throttled
+// batches and DMs the invite link to those whose privacy settings refuse the add
+func (ws *WhatsAppService) CreateGroupAndInvite(userID int, req GroupCreateInvite) (*GroupCreateInviteResult, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, fmt.Errorf("group name cannot be empty")
+	}
+	if utf8.RuneCountInString(name) > maxGroupNameLength {
+		return nil, fmt.Errorf("group name is limited to %d characters", maxGroupNameLength)
+	}
+	if len(req.Participants) > maxParticipantAdd {
+		return nil, fmt.Errorf("at most %d participants can be added at once", maxParticipantAdd)
+	}
+	jids, err := parseParticipantJIDs(req.Participants)
+	if err != nil {
+		return nil, err
+	}
+
+	sc, err := ws.getConnectedClient(req.SessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var info *types.GroupInfo
+	err = ws.callWhatsApp(sc, priorityInteractive, "create group", func() error {
+		var err error
+		info, err = sc.Client.CreateGroup(context.Background(), whatsmeow.ReqCreateGroup{Name: name})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create group: %w", err)
+	}
+	log.Printf("👥 Created group %s (%s) for session %s", name, info.JID.String(), sc.SessionID)
+
+	results := ws.addParticipants(sc, info.JID, req.Participants, jids)
+	ws.inviteParticipants(sc, info.JID, jids, results, req.InviteMessage)
+
+	summary := make(map[string]int)
+	added := 0
+	for _, result := range results {
+		summary[result.Status]++
+		if result.Status == ParticipantAdded {
+			added++
+		}
+	}
+
+	now := time.Now()
+	group := &WhatsAppGroup{
+		UserID:           userID,
+		SessionID:        sc.SessionID,
+		GroupJID:         info.JID.String(),
+		GroupName:        name,
+		GroupSubject:     &info.Topic,
+		ParticipantCount: added + 1, // the session's own account
+		MemberAddMode:    string(info.MemberAddMode),
+		SyncedAt:         &now,
+	}
+	if err := ws.db.UpsertGroup(group); err != nil {
+		log.Printf("⚠️  Failed to save created group %s: %v", info.JID.String(), err)
+	}
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, userID, "group_created", map[string]interface{}{
+		"group_jid": info.JID.String(),
+		"name":      name,
+		"counts":    summary,
+	})
+
+	return &GroupCreateInviteResult{
+		GroupJID: info.JID.String(),
+		Name:     name,
+		Results:  results,
+		Summary:  summary,
+	}, nil
+}
+
+// addParticipants adds users to a group in throttled batches and reports
+// the outcome per participant
+func (ws *WhatsAppService) addParticipants(sc *SessionClient, groupJID types.JID, participants []string, jids []types.JID) []GroupParticipantAddResult {
+	results := make([]GroupParticipantAddResult, len(jids))
+	for i, jid := range jids {
+		results[i] = GroupParticipantAddResult{Participant: participants[i], JID: jid.String()}
+	}
+
+	ctx := context.Background()
+	for start := 0; start < len(jids); start += participantAddBatchSize {
+		if start > 0 {
+			time.Sleep(participantAddDelay)
+		}
+		end := min(start+participantAddBatchSize, len(jids))
+
+		var changed []types.GroupParticipant
+		err := ws.callWhatsApp(sc, priorityInteractive, "add group participants", func() error {
+			var err error
+			changed, err = sc.Client.UpdateGroupParticipants(ctx, groupJID, jids[start:end], whatsmeow.ParticipantChangeAdd)
+			return err
+		})
+		for i := start; i < end; i++ {
+			result := &results[i]
+			if err != nil {
+				result.Status, result.Error = ParticipantAddFailed, err.Error()
+				continue
+			}
+			participant, ok := findParticipant(changed, jids[i])
+			if !ok {
+				result.Status, result.Error = ParticipantAddFailed, "no result from WhatsApp"
+				continue
+			}
+			result.ErrorCode = participant.Error
+			switch participant.Error {
+			case 0, 200:
+				result.Status, result.ErrorCode = ParticipantAdded, 0
+			case 409:
+				result.Status = ParticipantAlreadyMember
+			case 403:
+				result.Status = ParticipantNotAllowed
+			default:
+				result.Status = ParticipantAddFailed
+			}
+		}
+	}
+	return results
+}
+
+// inviteParticipants DMs the group's invite link to the participants whose
+// add was refused by their privacy settings
+func (ws *WhatsAppService) inviteParticipants(sc *SessionClient, groupJID types.JID, jids []types.JID, results []GroupParticipantAddResult, text string) {
+	var link string
+	sent := 0
+	for i := range results {
+		result := &results[i]
+		if result.Status != ParticipantNotAllowed {
+			continue
+		}
+
+		if link == "" {
+			err := ws.callWhatsApp(sc, priorityInteractive, "get invite link", func() error {
+				var err error
+				link, err = sc.Client.GetGroupInviteLink(context.Background(), groupJID, false)
+				return err
+			})
+			if err != nil {
+				for j := i; j < len(results); j++ {
+					if results[j].Status == ParticipantNotAllowed {
+						results[j].Status, results[j].Error = ParticipantInviteFailed, "failed to get invite link: "+err.Error()
+					}
+				}
+				return
+			}
+			if code, err := normalizeInviteCode(link); err == nil {
+				ws.recordInviteLink(sc, groupJID, code)
+			}
+		}
+
+		if err := ws.checkSuppressed(sc, jids[i]); err != nil {
+			if errors.Is(err, ErrSuppressed) {
+				result.Status = ParticipantSuppressed
+			} else {
+				result.Status = ParticipantInviteFailed
+			}
+			result.Error = err.Error()
+			continue
+		}
+
+		if sent > 0 {
+			time.Sleep(participantAddDelay)
+		}
+		sent++
+		resp, err := ws.sendTextToJID(sc, jids[i], groupInviteText(text, link))
+		if err != nil {
+			result.Status, result.Error = ParticipantInviteFailed, err.Error()
+			continue
+		}
+		result.Status, result.Error = ParticipantInvited, ""
+		result.InviteMessageID = resp.ID
+	}
+}
+
+// groupInviteText renders the invite DM
+func groupInviteText(text, link string) string {
+	if strings.TrimSpace(text) == "" {
+		text = defaultGroupInviteText
+	}
+	if strings.Contains(text, "{link}") {
+		return strings.ReplaceAll(text, "{link}", link)
+	}
+	return text + "\n\n" + link
+}
+
+// findParticipant returns the result of a participant change for a JID,
+// which WhatsApp may answer with the user's LID or phone number JID
+func findParticipant(changed []types.GroupParticipant, jid types.JID) (types.GroupParticipant, bool) {
+	for _, participant := range changed {
+		if participant.JID == jid || participant.PhoneNumber == jid || participant.LID == jid {
+			return participant, true
+		}
+	}
+	return types.GroupParticipant{}, false
+}
+
+// SetGroupJoinApproval toggles whether joining the group requires admin approval
+func (ws *WhatsAppService) SetGroupJoinApproval(sessionID string, userID int, group string, enabled bool) error {
+	sc, groupJID, err := ws.getGroupTarget(sessionID, userID, group)
+	if err != nil {
+		return err
+	}
+
+	err = ws.callWhatsApp(sc, priorityInteractive, "set group join approval", func() error {
+		return sc.Client.SetGroupJoinApprovalMode(context.Background(), groupJID, enabled)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set join approval mode: %w", err)
+	}
+
+	if err := ws.db.UpdateGroupSettings(userID, groupJID.String(), map[string]interface{}{"join_approval": enabled}); err != nil {
+		log.Printf("⚠️  Failed to store join approval mode for group %s: %v", groupJID.String(), err)
+	}
+
+	log.Printf("👥 Join approval mode for group %s set to %v", groupJID.String(), enabled)
+	return nil
+}
+
+// GroupSettingsUpdate holds the group settings to change; nil fields are left untouched
+type GroupSettingsUpdate struct {
+	Name           *string `json:"name"`
+	Description    *string `json:"description"`
+	Announce       *bool   `json:"announce"`
+	Locked         *bool   `json:"locked"`
+	EphemeralTimer *string `json:"ephemeral_timer"` // off, 24h, 7d or 90d
+	MemberAddMode  *string `json:"member_add_mode"` // admins or all
+	JoinApproval   *bool   `json:"join_approval_required"`
+}
+
+// parseMemberAddMode accepts the whatsmeow mode names as well as the short forms admins/all
+func parseMemberAddMode(mode string) (types.GroupMemberAddMode, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "admins", "admin", string(types.GroupMemberAddModeAdmin):
+		return types.GroupMemberAddModeAdmin, nil
+	case "all", "all_members", string(types.GroupMemberAddModeAllMember):
+		return types.GroupMemberAddModeAllMember, nil
+	}
+	return "", fmt.Errorf("invalid member_add_mode %q, must be admins or all", mode)
+}
+
+// UpdateGroupSettings applies the given settings to a group and stores them. Settings
+// are applied one by one; the returned map lists those that were changed before any error.
+func (ws *WhatsAppService) UpdateGroupSettings(sessionID string, userID int, group string, update GroupSettingsUpdate) (map[string]interface{}, error) {
+	if update == (GroupSettingsUpdate{}) {
+		return nil, fmt.Errorf("no settings provided")
+	}
+
+	// Validate everything up front so a bad value doesn't leave the group half updated
+	var ephemeralTimer time.Duration
+	if update.EphemeralTimer != nil {
+		timer, ok := whatsmeow.ParseDisappearingTimerString(*update.EphemeralTimer)
+		if !ok {
+			return nil, fmt.Errorf("invalid ephemeral_timer %q, must be off, 24h, 7d or 90d", *update.EphemeralTimer)
+		}
+		ephemeralTimer = timer
+	}
+
+	var memberAddMode types.GroupMemberAddMode
+	if update.MemberAddMode != nil {
+		mode, err := parseMemberAddMode(*update.MemberAddMode)
+		if err != nil {
+			return nil, err
+		}
+		memberAddMode = mode
+	}
+
+	if update.Name != nil && strings.TrimSpace(*update.Name) == "" {
+		return nil, fmt.Errorf("group name cannot be empty")
+	}
+
+	sc, groupJID, err := ws.getGroupTarget(sessionID, userID, group)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	applied := make(map[string]interface{})
+	stored := make(map[string]interface{})
+
+	if update.Name != nil {
+		err := ws.callWhatsApp(sc, priorityInteractive, "set group name", func() error {
+			return sc.Client.SetGroupName(ctx, groupJID, *update.Name)
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to set group name: %w", err)
+		}
+		applied["name"] = *update.Name
+		stored["group_name"] = *update.Name
+	}
+
+	if update.Description != nil {
+		err := ws.callWhatsApp(sc, priorityInteractive, "set group description", func() error {
+			return sc.Client.SetGroupTopic(ctx, groupJID, "", "", *update.Description)
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to set group description: %w", err)
+		}
+		applied["description"] = *update.Description
+		stored["group_subject"] = *update.Description
+	}
+
+	if update.Announce != nil {
+		err := ws.callWhatsApp(sc, priorityInteractive, "set group announce", func() error {
+			return sc.Client.SetGroupAnnounce(ctx, groupJID, *update.Announce)
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to set announce mode: %w", err)
+		}
+		applied["announce"] = *update.Announce
+		stored["is_announcement"] = *update.Announce
+	}
+
+	if update.Locked != nil {
+		err := ws.callWhatsApp(sc, priorityInteractive, "set group locked", func() error {
+			return sc.Client.SetGroupLocked(ctx, groupJID, *update.Locked)
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to set locked mode: %w", err)
+		}
+		applied["locked"] = *update.Locked
+		stored["is_locked"] = *update.Locked
+	}
+
+	if update.EphemeralTimer != nil {
+		err := ws.callWhatsApp(sc, priorityInteractive, "set group ephemeral timer", func() error {
+			return sc.Client.SetDisappearingTimer(ctx, groupJID, ephemeralTimer, time.Time{})
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to set ephemeral timer: %w", err)
+		}
+		applied["ephemeral_timer"] = uint32(ephemeralTimer.Seconds())
+		stored["ephemeral_timer"] = uint32(ephemeralTimer.Seconds())
+	}
+
+	if update.MemberAddMode != nil {
+		err := ws.callWhatsApp(sc, priorityInteractive, "set group member add mode", func() error {
+			return sc.Client.SetGroupMemberAddMode(ctx, groupJID, memberAddMode)
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to set member add mode: %w", err)
+		}
+		applied["member_add_mode"] = string(memberAddMode)
+		stored["member_add_mode"] = string(memberAddMode)
+	}
+
+	if update.JoinApproval != nil {
+		err := ws.callWhatsApp(sc, priorityInteractive, "set group join approval", func() error {
+			return sc.Client.SetGroupJoinApprovalMode(ctx, groupJID, *update.JoinApproval)
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to set join approval mode: %w", err)
+		}
+		applied["join_approval_required"] = *update.JoinApproval
+		stored["join_approval"] = *update.JoinApproval
+	}
+
+	if len(stored) > 0 {
+		if err := ws.db.UpdateGroupSettings(userID, groupJID.String(), stored); err != nil {
+			log.Printf("⚠️  Failed to store settings for group %s: %v", groupJID.String(), err)
+		}
+	}
+
+	log.Printf("👥 Updated %d setting(s) for group %s", len(applied), groupJID.String())
+
+	sessionUUID, _ := uuid.Parse(sessionID)
+	ws.db.CreateEvent(sessionUUID, userID, "group_settings_updated", map[string]interface{}{
+		"group_jid": groupJID.String(),
+		"settings":  applied,
+	})
+
+	return applied, nil
+}
+
+// GroupInvitePreview is the public info of a group resolved from an invite link
+type GroupInvitePreview struct {
+	GroupJID             string    `json:"group_jid"`
+	Name                 string    `json:"name"`
+	Description          string    `json:"description,omitempty"`
+	Owner                string    `json:"owner,omitempty"`
+	Size                 int       `json:"size"`
+	CreatedAt            time.Time `json:"created_at"`
+	IsAnnouncement       bool      `json:"is_announcement"`
+	JoinApprovalRequired bool      `json:"join_approval_required"`
+	IsCommunity          bool      `json:"is_community"`
+}
+
+// normalizeInviteCode extracts the invite code from a chat.whatsapp.com link or bare code
+func normalizeInviteCode(link string) (string, error) {
+	code := strings.TrimSpace(link)
+	code = strings.TrimPrefix(code, "https://")
+	code = strings.TrimPrefix(code, "http://")
+	code = strings.TrimPrefix(code, "chat.whatsapp.com/")
+	if idx := strings.IndexAny(code, "?#"); idx >= 0 {
+		code = code[:idx]
+	}
+	code = strings.TrimSuffix(code, "/")
+
+	if code == "" || strings.ContainsAny(code, "/ ") {
+		return "", fmt.Errorf("invalid invite link")
+	}
+	return code, nil
+}
+
+// RevokeGroupInviteLink revokes the current invite link of a group and returns the new one
+func (ws *WhatsAppService) RevokeGroupInviteLink(sessionID string, userID int, group string) (string, error) {
+	sc, groupJID, err := ws.getGroupTarget(sessionID, userID, group)
+	if err != nil {
+		return "", err
+	}
+
+	var link string
+	err = ws.callWhatsApp(sc, priorityInteractive, "revoke invite link", func() error {
+		var err error
+		link, err = sc.Client.GetGroupInviteLink(context.Background(), groupJID, true)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to revoke invite link: %w", err)
+	}
+
+	log.Printf("🔗 Invite link revoked for group %s", groupJID.String())
+	if code, err := normalizeInviteCode(link); err == nil {
+		ws.recordInviteLink(sc, groupJID, code)
+	}
+
+	sessionUUID, _ := uuid.Parse(sessionID)
+	ws.db.CreateEvent(sessionUUID, userID, "group_invite_link_revoked", map[string]interface{}{
+		"group_jid": groupJID.String(),
+	})
+
+	return link, nil
+}
+
+// GetGroupInviteInfo resolves an invite link without joining the group
+func (ws *WhatsAppService) GetGroupInviteInfo(sessionID string, userID int, link string) (*GroupInvitePreview, error) {
+	code, err := normalizeInviteCode(link)
+	if err != nil {
+		return nil, err
+	}
+
+	sc, err := ws.getConnectedClient(sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var info *types.GroupInfo
+	err = ws.callWhatsApp(sc, priorityInteractive, "get invite info", func() error {
+		var err error
+		info, err = sc.Client.GetGroupInfoFromLink(context.Background(), code)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, whatsmeow.ErrInviteLinkRevoked) || errors.Is(err, whatsmeow.ErrInviteLinkInvalid) {
+			return nil, fmt.Errorf("invite link not found: %w", err)
+		}
+		return nil, fmt.Errorf("failed to get invite info: %w", err)
+	}
+
+	preview := &GroupInvitePreview{
+		GroupJID:             info.JID.String(),
+		Name:                 info.Name,
+		Description:          info.Topic,
+		Size:                 len(info.Participants),
+		CreatedAt:            info.GroupCreated,
+		IsAnnouncement:       info.IsAnnounce,
+		JoinApprovalRequired: info.IsJoinApprovalRequired,
+		IsCommunity:          info.IsParent,
+	}
+	if !info.OwnerPN.IsEmpty() {
+		preview.Owner = info.OwnerPN.String()
+	} else if !info.OwnerJID.IsEmpty() {
+		preview.Owner = info.OwnerJID.String()
+	}
+
+	return preview, nil
+}
+
+// GroupParticipantInfo is a group participant as exported
+type GroupParticipantInfo struct {
+	JID          string `json:"jid"`
+	PhoneNumber  string `json:"phone_number,omitempty"`
+	LID          string `json:"lid,omitempty"`
+	Name         string `json:"name,omitempty"`
+	IsAdmin      bool   `json:"is_admin"`
+	IsSuperAdmin bool   `json:"is_super_admin"`
+}
+
+// groupParticipantCSVHeader are the columns of a participant CSV export
+var groupParticipantCSVHeader = []string{"jid", "phone_number", "lid", "name", "is_admin", "is_super_admin"}
+
+func (p GroupParticipantInfo) csvRecord() []string {
+	return []string{p.JID, p.PhoneNumber, p.LID, p.Name, strconv.FormatBool(p.IsAdmin), strconv.FormatBool(p.IsSuperAdmin)}
+}
+
+// role is the participant's role as a contact list field
+func (p GroupParticipantInfo) role() string {
+	switch {
+	case p.IsSuperAdmin:
+		return "superadmin"
+	case p.IsAdmin:
+		return "admin"
+	}
+	return "member"
+}
+
+// GetGroupParticipants fetches the current participants of a group with
+// their phone numbers and names; admins come first
+func (ws *WhatsAppService) GetGroupParticipants(sessionID string, userID int, group string) (*types.GroupInfo, []GroupParticipantInfo, error) {
+	sc, groupJID, err := ws.getGroupTarget(sessionID, userID, group)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx := context.Background()
+	var info *types.GroupInfo
+	err = ws.callWhatsApp(sc, priorityInteractive, "get group info", func() error {
+		var err error
+		info, err = sc.Client.GetGroupInfo(ctx, groupJID)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, whatsmeow.ErrGroupNotFound) || errors.Is(err, whatsmeow.ErrNotInGroup) {
+			return nil, nil, fmt.Errorf("group not found: %w", err)
+		}
+		return nil, nil, fmt.Errorf("failed to get group info: %w", err)
+	}
+
+	participants := make([]GroupParticipantInfo, 0, len(info.Participants))
+	for _, participant := range info.Participants {
+		p := GroupParticipantInfo{
+			JID:          participant.JID.String(),
+			Name:         participant.DisplayName,
+			IsAdmin:      participant.IsAdmin || participant.IsSuperAdmin,
+			IsSuperAdmin: participant.IsSuperAdmin,
+		}
+		if !participant.PhoneNumber.IsEmpty() {
+			p.PhoneNumber = participant.PhoneNumber.User
+		} else if phone := ws.phoneForJID(sc, participant.JID); phone != "" {
+			p.PhoneNumber = phone
+		}
+		if !participant.LID.IsEmpty() {
+			p.LID = participant.LID.String()
+		}
+		if contact, err := sc.Device.Contacts.GetContact(ctx, participant.JID); err == nil && contactName(contact) != "" {
+			p.Name = contactName(contact)
+		}
+		participants = append(participants, p)
+	}
+
+	sort.SliceStable(participants, func(i, j int) bool {
+		if participants[i].IsSuperAdmin != participants[j].IsSuperAdmin {
+			return participants[i].IsSuperAdmin
+		}
+		return participants[i].IsAdmin && !participants[j].IsAdmin
+	})
+	return info, participants, nil
+}
+
+// ImportGroupParticipants saves the current participants of a group as a
+// named contact list for campaigns. Participants are valid members by
+// definition; their role (member, admin, superadmin) and the group name are
+// custom fields.
+func (ws *WhatsAppService) ImportGroupParticipants(sessionID string, userID int, group, name string) (*WhatsAppContactList, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("list name is required")
+	}
+
+	exists, err := ws.db.ContactListExists(userID, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check contact lists: %w", err)
+	}
+	if exists {
+		return nil, fmt.Errorf("contact list %q already exists", name)
+	}
+
+	info, participants, err := ws.GetGroupParticipants(sessionID, userID, group)
+	if err != nil {
+		return nil, err
+	}
+
+	ownJID := types.EmptyJID
+	if sc, err := ws.getConnectedClient(sessionID, userID); err == nil && sc.Device.ID != nil {
+		ownJID = sc.Device.ID.ToNonAD()
+	}
+
+	list := &WhatsAppContactList{
+		UserID:    userID,
+		SessionID: sessionID,
+		Name:      name,
+		Fields:    "role,group_name",
+	}
+	for i, p := range participants {
+		if p.PhoneNumber == ownJID.User || p.JID == ownJID.String() {
+			continue
+		}
+		input := p.PhoneNumber
+		if input == "" {
+			input = p.JID
+		}
+		list.Members = append(list.Members, WhatsAppContactListMember{
+			Line:   i + 1,
+			Input:  truncate(input, 50),
+			Phone:  p.PhoneNumber,
+			JID:    p.JID,
+			Name:   truncate(p.Name, 255),
+			Fields: JSONData{"role": p.role(), "group_name": info.Name},
+			Status: ContactListMemberValid,
+		})
+	}
+	list.Total, list.Valid = len(list.Members), len(list.Members)
+
+	if err := ws.db.CreateContactList(list); err != nil {
+		return nil, fmt.Errorf("failed to save contact list: %w", err)
+	}
+	list.Members = nil
+
+	log.Printf("📇 Imported %d participants of group %s into contact list %q", list.Total, info.JID.String(), name)
+	return list, nil
+}
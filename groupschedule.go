@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/types"
+	"gorm.io/gorm"
+)
+
+// ============= GROUP SCHEDULER =============
+// Quiet-hours windows switch a group to announce-only while the window is open
+// and restore the previous announce setting once it closes. The scheduler runs
+// once a minute; state is kept in the WhatsAppGroupSchedule table so windows
+// survive restarts.
+
+const groupSchedulerInterval = time.Minute
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// GroupScheduleRequest configures a quiet-hours window
+type GroupScheduleRequest struct {
+	StartTime string   `json:"start_time" binding:"required"` // HH:MM
+	EndTime   string   `json:"end_time" binding:"required"`   // HH:MM
+	Timezone  string   `json:"timezone"`                      // IANA name, default UTC
+	Days      []string `json:"days"`                          // weekdays the window starts on, empty = every day
+	Enabled   *bool    `json:"enabled"`                       // default true
+}
+
+// parseClock parses HH:MM into minutes since midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// normalizeScheduleDays validates weekday names and returns them comma separated
+func normalizeScheduleDays(days []string) (string, error) {
+	normalized := make([]string, 0, len(days))
+	seen := make(map[string]bool)
+	for _, day := range days {
+		key := strings.ToLower(strings.TrimSpace(day))
+		if len(key) > 3 {
+			key = key[:3]
+		}
+		if _, ok := weekdayNames[key]; !ok {
+			return "", fmt.Errorf("invalid day %q", day)
+		}
+		if !seen[key] {
+			seen[key] = true
+			normalized = append(normalized, key)
+		}
+	}
+	return strings.Join(normalized, ","), nil
+}
+
+// scheduleRunsOn reports whether a window may start on the given weekday
+func scheduleRunsOn(days string, weekday time.Weekday) bool {
+	if days == "" {
+		return true
+	}
+	for _, day := range strings.Split(days, ",") {
+		if weekdayNames[day] == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleWindowOpen reports whether the quiet-hours window is open at the given time
+func scheduleWindowOpen(schedule *WhatsAppGroupSchedule, now time.Time) (bool, error) {
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return false, fmt.Errorf("invalid timezone %q", schedule.Timezone)
+	}
+	start, err := parseClock(schedule.StartTime)
+	if err != nil {
+		return false, err
+	}
+	end, err := parseClock(schedule.EndTime)
+	if err != nil {
+		return false, err
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+
+	if start < end {
+		return minute >= start && minute < end && scheduleRunsOn(schedule.Days, local.Weekday()), nil
+	}
+
+	// Overnight window: the part after midnight belongs to the previous day's window
+	if minute >= start {
+		return scheduleRunsOn(schedule.Days, local.Weekday()), nil
+	}
+	if minute < end {
+		return scheduleRunsOn(schedule.Days, local.AddDate(0, 0, -1).Weekday()), nil
+	}
+	return false, nil
+}
+
+// SetGroupSchedule creates or replaces the quiet-hours window of a group
+func (ws *WhatsAppService) SetGroupSchedule(sessionID string, userID int, group string, req GroupScheduleRequest) (*WhatsAppGroupSchedule, error) {
+	start, err := parseClock(req.StartTime)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(req.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("start_time and end_time must differ")
+	}
+
+	timezone := req.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return nil, fmt.Errorf("invalid timezone %q", timezone)
+	}
+
+	days, err := normalizeScheduleDays(req.Days)
+	if err != nil {
+		return nil, err
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	// Resolving the group also checks session ownership and connectivity
+	_, groupJID, err := ws.getGroupTarget(sessionID, userID, group)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := &WhatsAppGroupSchedule{
+		UserID:    userID,
+		SessionID: sessionID,
+		GroupJID:  groupJID.String(),
+		StartTime: fmt.Sprintf("%02d:%02d", start/60, start%60),
+		EndTime:   fmt.Sprintf("%02d:%02d", end/60, end%60),
+		Timezone:  timezone,
+		Days:      days,
+		Enabled:   enabled,
+	}
+	if err := ws.db.UpsertGroupSchedule(schedule); err != nil {
+		return nil, fmt.Errorf("failed to save schedule: %w", err)
+	}
+
+	saved, err := ws.db.GetGroupSchedule(sessionID, userID, groupJID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schedule: %w", err)
+	}
+
+	log.Printf("🕒 Quiet hours for group %s set to %s-%s %s", groupJID.String(), saved.StartTime, saved.EndTime, saved.Timezone)
+
+	// Apply right away instead of waiting for the next tick
+	ws.runGroupSchedule(saved, time.Now())
+
+	return saved, nil
+}
+
+// GetGroupSchedule returns the quiet-hours window of a group
+func (ws *WhatsAppService) GetGroupSchedule(sessionID string, userID int, group string) (*WhatsAppGroupSchedule, error) {
+	groupJID, err := parseGroupJID(group)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule, err := ws.db.GetGroupSchedule(sessionID, userID, groupJID.String())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("schedule not found")
+		}
+		return nil, fmt.Errorf("failed to load schedule: %w", err)
+	}
+	return schedule, nil
+}
+
+// SetGroupScheduleEnabled enables or disables the quiet-hours window of a group.
+// Disabling an open window reverts the group immediately.
+func (ws *WhatsAppService) SetGroupScheduleEnabled(sessionID string, userID int, group string, enabled bool) (*WhatsAppGroupSchedule, error) {
+	schedule, err := ws.GetGroupSchedule(sessionID, userID, group)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ws.db.UpdateGroupSchedule(schedule.ID, map[string]interface{}{"enabled": enabled}); err != nil {
+		return nil, fmt.Errorf("failed to update schedule: %w", err)
+	}
+	schedule.Enabled = enabled
+
+	ws.runGroupSchedule(schedule, time.Now())
+
+	return schedule, nil
+}
+
+// DeleteGroupSchedule removes the quiet-hours window of a group, reverting it if open
+func (ws *WhatsAppService) DeleteGroupSchedule(sessionID string, userID int, group string) error {
+	schedule, err := ws.GetGroupSchedule(sessionID, userID, group)
+	if err != nil {
+		return err
+	}
+
+	if schedule.Active {
+		schedule.Enabled = false
+		ws.runGroupSchedule(schedule, time.Now())
+	}
+
+	if err := ws.db.DeleteGroupSchedule(sessionID, userID, schedule.GroupJID); err != nil {
+		return fmt.Errorf("failed to delete schedule: %w", err)
+	}
+	return nil
+}
+
+// StartGroupScheduler runs quiet-hours windows until the context is cancelled
+func (ws *WhatsAppService) StartGroupScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(groupSchedulerInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				ws.runGroupSchedules(now)
+			}
+		}
+	}()
+	log.Println("✅ Group scheduler started")
+}
+
+func (ws *WhatsAppService) runGroupSchedules(now time.Time) {
+	schedules, err := ws.db.GetSchedulableGroupSchedules()
+	if err != nil {
+		log.Printf("❌ Failed to load group schedules: %v", err)
+		return
+	}
+
+	for i := range schedules {
+		ws.runGroupSchedule(&schedules[i], now)
+	}
+}
+
+// runGroupSchedule opens or closes a single window depending on the current time
+func (ws *WhatsAppService) runGroupSchedule(schedule *WhatsAppGroupSchedule, now time.Time) {
+	open := false
+	if schedule.Enabled {
+		var err error
+		open, err = scheduleWindowOpen(schedule, now)
+		if err != nil {
+			ws.db.UpdateGroupSchedule(schedule.ID, map[string]interface{}{"last_error": err.Error()})
+			return
+		}
+	}
+
+	if open == schedule.Active {
+		return
+	}
+
+	sc, err := ws.GetSessionClient(schedule.SessionID)
+	if err != nil || !sc.Client.IsConnected() {
+		// Retried on the next tick once the session is back
+		return
+	}
+
+	groupJID, err := parseGroupJID(schedule.GroupJID)
+	if err != nil {
+		return
+	}
+
+	ctx := context.Background()
+	updates := map[string]interface{}{"last_run_at": now, "last_error": ""}
+
+	if open {
+		var info *types.GroupInfo
+		err := ws.callWhatsApp(sc, priorityBackground, "group schedule", func() (err error) {
+			info, err = sc.Client.GetGroupInfo(ctx, groupJID)
+			return err
+		})
+		if err != nil {
+			ws.recordGroupScheduleError(schedule, fmt.Errorf("failed to get group info: %w", err))
+			return
+		}
+		if !info.IsAnnounce {
+			err := ws.callWhatsApp(sc, priorityBackground, "group schedule", func() error {
+				return sc.Client.SetGroupAnnounce(ctx, groupJID, true)
+			})
+			if err != nil {
+				ws.recordGroupScheduleError(schedule, fmt.Errorf("failed to enable announce mode: %w", err))
+				return
+			}
+		}
+		updates["active"] = true
+		updates["was_announce"] = info.IsAnnounce
+		schedule.WasAnnounce = info.IsAnnounce
+	} else {
+		// Leave groups that were already announce-only before the window untouched
+		if !schedule.WasAnnounce {
+			err := ws.callWhatsApp(sc, priorityBackground, "group schedule", func() error {
+				return sc.Client.SetGroupAnnounce(ctx, groupJID, false)
+			})
+			if err != nil {
+				ws.recordGroupScheduleError(schedule, fmt.Errorf("failed to disable announce mode: %w", err))
+				return
+			}
+		}
+		updates["active"] = false
+	}
+
+	if err := ws.db.UpdateGroupSchedule(schedule.ID, updates); err != nil {
+		log.Printf("❌ Failed to update schedule %d: %v", schedule.ID, err)
+	}
+	schedule.Active = open
+
+	ws.db.UpdateGroupSettings(schedule.UserID, schedule.GroupJID, map[string]interface{}{
+		"is_announcement": open || schedule.WasAnnounce,
+	})
+
+	eventType := "group_quiet_hours_ended"
+	if open {
+		eventType = "group_quiet_hours_started"
+	}
+	log.Printf("🕒 %s for group %s", eventType, schedule.GroupJID)
+
+	sessionUUID, _ := uuid.Parse(schedule.SessionID)
+	ws.db.CreateEvent(sessionUUID, schedule.UserID, eventType, map[string]interface{}{
+		"group_jid": schedule.GroupJID,
+	})
+
+	ws.wsManager.SendToSession(schedule.SessionID, WebSocketMessage{
+		Type: eventType,
+		Data: map[string]interface{}{
+			"group_jid": schedule.GroupJID,
+		},
+	})
+}
+
+func (ws *WhatsAppService) recordGroupScheduleError(schedule *WhatsAppGroupSchedule, err error) {
+	log.Printf("⚠️  Group schedule for %s: %v", schedule.GroupJID, err)
+	ws.db.UpdateGroupSchedule(schedule.ID, map[string]interface{}{
+		"last_error":  err.Error(),
+		"last_run_at": time.Now(),
+	})
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/types"
+	"gorm.io/gorm"
+
+	"whatsapp-api/internal/jobs"
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= GROUP SYNC JOBS =============
+// A session's groups are synced by a background job, started when the session
+// connects or through POST /groups/:session_id/sync. The job fetches the list
+// of joined groups and then each group's full info and participants, with
+// GROUP_SYNC_DELAY between fetches. Every synced group records its synced_at,
+// so a job interrupted by a disconnect or restart resumes with the groups it
+// hasn't fetched yet, and groups synced within GROUP_SYNC_MAX_AGE are skipped
+// unless the sync is forced. Jobs run on the job queue (jobs.go). When
+// WhatsApp rate limits the session, the job goes back to the queue and
+// continues after the backoff; it fails after GROUP_SYNC_RETRY_ATTEMPTS rate
+// limits without progress in between.
+
+const groupSyncProgressEvery = 10 // groups between progress updates
+
+// ErrGroupSyncRunning is returned when a session already has an active sync
+var ErrGroupSyncRunning = errors.New("a group sync is already running for this session")
+
+// errGroupSyncInterrupted stops a job whose session disconnected; it stays
+// pending and resumes on the next connect
+var errGroupSyncInterrupted = errors.New("session disconnected")
+
+// groupSyncRun is the active job of a session client
+type groupSyncRun struct {
+	sc        *SessionClient
+	job       *WhatsAppGroupSyncJob
+	runID     string // matches the queued job to this run
+	throttled int    // rate limits since the last synced group
+}
+
+// StartGroupSync starts (or resumes) syncing the groups of a connected session
+func (ws *WhatsAppService) StartGroupSync(sessionID string, userID int, force bool) (*WhatsAppGroupSyncJob, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+	sc, err := ws.GetSessionClient(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !sc.Client.IsConnected() || !sc.Client.IsLoggedIn() {
+		return nil, apierr.ErrSessionNotConnected
+	}
+	return ws.startGroupSync(sc, "api", force)
+}
+
+// GetGroupSync returns the latest sync job of a session
+func (ws *WhatsAppService) GetGroupSync(sessionID string, userID int) (*WhatsAppGroupSyncJob, error) {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	job, err := ws.db.GetLatestGroupSyncJob(sessionID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("group sync not found for this session")
+		}
+		return nil, fmt.Errorf("failed to load group sync: %w", err)
+	}
+	return job, nil
+}
+
+// activeGroupSync returns the job running (or waiting out a rate limit) for
+// the session's current client
+func (ws *WhatsAppService) activeGroupSync(sessionID string) (*groupSyncRun, bool) {
+	value, ok := ws.groupSyncs.Load(sessionID)
+	if !ok {
+		return nil, false
+	}
+	run := value.(*groupSyncRun)
+	if !ws.sessions.Current(run.sc) {
+		return nil, false // left behind by a replaced client
+	}
+	return run, true
+}
+
+// startGroupSync resumes the session's unfinished job or creates a new one and
+// queues it. A running job is returned with
+// ErrGroupSyncRunning.
+func (ws *WhatsAppService) startGroupSync(sc *SessionClient, trigger string, force bool) (*WhatsAppGroupSyncJob, error) {
+	ws.groupSyncMu.Lock()
+	defer ws.groupSyncMu.Unlock()
+
+	if run, ok := ws.activeGroupSync(sc.SessionID); ok {
+		job, err := ws.db.GetGroupSyncJob(run.job.ID, sc.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load group sync: %w", err)
+		}
+		return job, ErrGroupSyncRunning
+	}
+
+	job, err := ws.db.GetUnfinishedGroupSyncJob(sc.SessionID)
+	switch {
+	case err == nil:
+		if force && !job.Force {
+			job.Force = true
+			ws.db.UpdateGroupSyncJob(job.ID, map[string]interface{}{"force": true})
+		}
+		log.Printf("🔁 Resuming group sync %d for session %s", job.ID, sc.SessionID)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		job = &WhatsAppGroupSyncJob{
+			UserID:    sc.UserID,
+			SessionID: sc.SessionID,
+			Status:    GroupSyncPending,
+			Trigger:   trigger,
+			Force:     force,
+		}
+		if err := ws.db.CreateGroupSyncJob(job); err != nil {
+			return nil, fmt.Errorf("failed to create group sync: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("failed to load group sync: %w", err)
+	}
+
+	snapshot := *job
+	run := &groupSyncRun{sc: sc, job: job, runID: uuid.NewString()}
+	ws.groupSyncs.Store(sc.SessionID, run)
+
+	payload := groupSyncJob{SessionID: sc.SessionID, RunID: run.runID}
+	if _, err := ws.jobs.Enqueue(context.Background(), jobGroupSync, payload); err != nil {
+		ws.groupSyncs.CompareAndDelete(sc.SessionID, run)
+		return nil, fmt.Errorf("failed to queue group sync: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// groupSyncJob is the payload of a queued group sync
+type groupSyncJob struct {
+	SessionID string `json:"session_id"`
+	RunID     string `json:"run_id"`
+}
+
+// runGroupSyncJob runs a queued group sync. A rate limit puts the job back in
+// the queue until the backoff ends; any other outcome is recorded on the sync
+// job itself.
+func (ws *WhatsAppService) runGroupSyncJob(ctx context.Context, job *jobs.Job) error {
+	var payload groupSyncJob
+	if err := job.Decode(&payload); err != nil {
+		return err
+	}
+	value, ok := ws.groupSyncs.Load(payload.SessionID)
+	if !ok || value.(*groupSyncRun).runID != payload.RunID {
+		// Left over from before a restart or replaced by a newer run; the
+		// sync resumes with the session's next connect
+		return nil
+	}
+
+	err := ws.resumeGroupSync(value.(*groupSyncRun))
+	var throttleErr *ThrottleError
+	if errors.As(err, &throttleErr) {
+		return jobs.RetryAt(throttleErr.RetryAt, err)
+	}
+	return nil
+}
+
+// resumeGroupSync runs a job until it ends or has to wait for a rate limit,
+// which is returned as a ThrottleError
+func (ws *WhatsAppService) resumeGroupSync(run *groupSyncRun) error {
+	err := ws.syncGroups(run)
+
+	var throttleErr *ThrottleError
+	if errors.As(err, &throttleErr) {
+		run.throttled++
+		run.job.RateLimited++
+		if run.throttled <= ws.cfg.GroupSyncRetryAttempts {
+			run.job.Status = GroupSyncPending
+			run.job.RetryAt = &throttleErr.RetryAt
+			ws.saveGroupSyncProgress(run.job, "status", "retry_at")
+			log.Printf("⏸️  Group sync %d of session %s waits until %s: %v", run.job.ID, run.sc.SessionID, throttleErr.RetryAt.Format(time.RFC3339), err)
+			return err
+		}
+		// Not wrapped, so the job isn't queued again
+		err = fmt.Errorf("gave up after %d rate limits in a row: %v", run.throttled, err)
+	}
+
+	ws.finishGroupSync(run, err)
+	return err
+}
+
+// syncGroups fetches the groups the job hasn't synced yet
+func (ws *WhatsAppService) syncGroups(run *groupSyncRun) error {
+	sc, job := run.sc, run.job
+	if !ws.groupSyncActive(sc) {
+		return errGroupSyncInterrupted
+	}
+
+	now := time.Now()
+	job.Status = GroupSyncRunning
+	job.RetryAt = nil
+	if job.StartedAt == nil {
+		job.StartedAt = &now
+	}
+	ws.saveGroupSyncProgress(job, "status", "retry_at", "started_at")
+
+	var groups []*types.GroupInfo
+	err := ws.callWhatsApp(sc, priorityBackground, "group sync", func() (err error) {
+		groups, err = sc.Client.GetJoinedGroups(context.Background())
+		return err
+	})
+	var throttleErr *ThrottleError
+	if errors.As(err, &throttleErr) {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("failed to fetch groups: %w", err)
+	}
+
+	syncTimes, err := ws.db.GetGroupSyncTimes(sc.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to load group sync times: %w", err)
+	}
+	log.Printf("📊 Group sync %d: %d groups for session %s (will use %v delay between requests)",
+		job.ID, len(groups), sc.SessionID, ws.GroupSyncDelay())
+
+	// Counters are recomputed on every run, so resumed jobs count correctly
+	freshAfter := now.Add(-ws.cfg.GroupSyncMaxAge)
+	job.TotalGroups = len(groups)
+	job.Synced, job.Skipped, job.Failed = 0, 0, 0
+	fetched := 0
+
+	for i, groupInfo := range groups {
+		if syncedAt, ok := syncTimes[groupInfo.JID.String()]; ok {
+			if !syncedAt.Before(job.CreatedAt) {
+				job.Synced++ // done by this job before it was interrupted
+				continue
+			}
+			if !job.Force && syncedAt.After(freshAfter) {
+				job.Skipped++
+				continue
+			}
+		}
+
+		if !ws.groupSyncActive(sc) {
+			ws.saveGroupSyncProgress(job)
+			return errGroupSyncInterrupted
+		}
+		if fetched > 0 {
+			time.Sleep(ws.GroupSyncDelay())
+		}
+		fetched++
+
+		err := ws.processGroup(sc, groupInfo)
+		if errors.As(err, &throttleErr) {
+			ws.saveGroupSyncProgress(job)
+			return err
+		} else if err != nil {
+			job.Failed++
+			log.Printf("❌ Failed to process group %s: %v", groupInfo.JID.String(), err)
+		} else {
+			job.Synced++
+			run.throttled = 0
+		}
+
+		if fetched%groupSyncProgressEvery == 0 {
+			ws.saveGroupSyncProgress(job)
+			log.Printf("📊 Group sync %d progress: %d/%d groups processed", job.ID, i+1, len(groups))
+		}
+	}
+	return nil
+}
+
+// groupSyncActive reports whether the job's client is still the session's
+// connected client
+func (ws *WhatsAppService) groupSyncActive(sc *SessionClient) bool {
+	return ws.sessions.Current(sc) && sc.Client.IsConnected()
+}
+
+// saveGroupSyncProgress stores the counters of a job plus the given columns
+func (ws *WhatsAppService) saveGroupSyncProgress(job *WhatsAppGroupSyncJob, columns ...string) {
+	updates := map[string]interface{}{
+		"total_groups": job.TotalGroups,
+		"synced":       job.Synced,
+		"skipped":      job.Skipped,
+		"failed":       job.Failed,
+		"rate_limited": job.RateLimited,
+	}
+	for _, column := range columns {
+		switch column {
+		case "status":
+			updates["status"] = job.Status
+		case "retry_at":
+			updates["retry_at"] = job.RetryAt
+		case "started_at":
+			updates["started_at"] = job.StartedAt
+		}
+	}
+	if err := ws.db.UpdateGroupSyncJob(job.ID, updates); err != nil {
+		log.Printf("❌ Failed to update group sync %d: %v", job.ID, err)
+	}
+}
+
+// finishGroupSync records the end of a run: completed, failed, or pending
+// again when the session disconnected
+func (ws *WhatsAppService) finishGroupSync(run *groupSyncRun, err error) {
+	sc, job := run.sc, run.job
+	ws.groupSyncs.CompareAndDelete(sc.SessionID, run)
+
+	if errors.Is(err, errGroupSyncInterrupted) {
+		job.Status = GroupSyncPending
+		ws.saveGroupSyncProgress(job, "status")
+		log.Printf("⏸️  Group sync %d of session %s interrupted (%d/%d groups done), resumes on the next connect",
+			job.ID, sc.SessionID, job.Synced+job.Skipped, job.TotalGroups)
+		return
+	}
+
+	now := time.Now()
+	job.CompletedAt = &now
+	eventType := "groups_synced"
+	if err != nil {
+		job.Status = GroupSyncFailed
+		job.Error = err.Error()
+		eventType = "group_sync_failed"
+		log.Printf("❌ Group sync %d of session %s failed: %v", job.ID, sc.SessionID, err)
+	} else {
+		job.Status = GroupSyncCompleted
+		log.Printf("✅ Group sync %d completed for session %s: %d synced, %d skipped, %d failed (%d rate limits)",
+			job.ID, sc.SessionID, job.Synced, job.Skipped, job.Failed, job.RateLimited)
+	}
+
+	updates := map[string]interface{}{
+		"status":       job.Status,
+		"error":        job.Error,
+		"completed_at": now,
+		"retry_at":     nil,
+		"total_groups": job.TotalGroups,
+		"synced":       job.Synced,
+		"skipped":      job.Skipped,
+		"failed":       job.Failed,
+		"rate_limited": job.RateLimited,
+	}
+	if err := ws.db.UpdateGroupSyncJob(job.ID, updates); err != nil {
+		log.Printf("❌ Failed to update group sync %d: %v", job.ID, err)
+	}
+
+	data := map[string]interface{}{
+		"job_id":       job.ID,
+		"total_groups": job.TotalGroups,
+		"successful":   job.Synced,
+		"skipped":      job.Skipped,
+		"failed":       job.Failed,
+		"rate_limited": job.RateLimited,
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, eventType, data)
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: eventType,
+		Data: data,
+	})
+}
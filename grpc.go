@@ -0,0 +1,367 @@
+package main
+
+//go:generate protoc -I proto --go_out=. --go_opt=module=whatsapp-api --go-grpc_out=. --go-grpc_opt=module=whatsapp-api whatsapp/v1/whatsapp.proto
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"whatsapp-api/pkg/apierr"
+	"whatsapp-api/pkg/whatsapppb"
+)
+
+// ============= GRPC API =============
+// A gRPC server on GRPC_PORT exposes the core operations for internal
+// consumers: session lifecycle, sending and the event stream. The service is
+// defined in proto/whatsapp/v1/whatsapp.proto; run go generate after changing
+// it. RPCs call the same WhatsAppService methods as the REST handlers, and
+// StreamEvents is a regular streamClient, so it shares topics, replay and
+// heartbeats with the WebSocket and SSE streams. Authentication uses the
+// "authorization: Bearer <jwt>" metadata entry. The server is disabled while
+// GRPC_PORT is empty.
+
+type grpcUserIDKey struct{}
+
+// GRPCServer implements whatsapppb.WhatsAppServer
+type GRPCServer struct {
+	whatsapppb.UnimplementedWhatsAppServer
+
+	whatsappService *WhatsAppService
+	db              *DatabaseManager
+	wsManager       *WebSocketManager
+	handlers        *APIHandlers
+}
+
+// StartGRPCServer listens on GRPC_PORT and serves in the background. Returns
+// nil without starting when GRPC_PORT is empty.
+func StartGRPCServer(cfg *Config, handlers *APIHandlers) (*grpc.Server, error) {
+	if cfg.GRPCPort == "" {
+		return nil, nil
+	}
+
+	lis, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on gRPC port: %w", err)
+	}
+
+	server := &GRPCServer{
+		whatsappService: handlers.whatsappService,
+		db:              handlers.db,
+		wsManager:       handlers.wsManager,
+		handlers:        handlers,
+	}
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(server.unaryAuth),
+		grpc.ChainStreamInterceptor(server.streamAuth),
+	)
+	whatsapppb.RegisterWhatsAppServer(srv, server)
+	reflection.Register(srv)
+
+	go func() {
+		log.Printf("Starting gRPC server on port %s", cfg.GRPCPort)
+		if err := srv.Serve(lis); err != nil {
+			log.Printf("❌ gRPC server stopped: %v", err)
+		}
+	}()
+	return srv, nil
+}
+
+// authenticate validates the bearer token of a call
+func (s *GRPCServer) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, grpcAPIError(ctx, apierr.ErrUnauthorized, "authorization metadata required")
+	}
+	token := strings.TrimPrefix(values[0], "Bearer ")
+
+	userID, err := s.handlers.validateWebSocketToken(token)
+	if err != nil {
+		return nil, grpcAPIError(ctx, apierr.ErrUnauthorized, "invalid token")
+	}
+	return context.WithValue(ctx, grpcUserIDKey{}, userID), nil
+}
+
+func (s *GRPCServer) unaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *GRPCServer) streamAuth(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &grpcAuthStream{ServerStream: stream, ctx: ctx})
+}
+
+// grpcAuthStream carries the authenticated context into stream handlers
+type grpcAuthStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcAuthStream) Context() context.Context {
+	return s.ctx
+}
+
+func grpcUserID(ctx context.Context) int {
+	userID, _ := ctx.Value(grpcUserIDKey{}).(int)
+	return userID
+}
+
+// grpcCodes map the HTTP status of API errors to gRPC codes
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusPaymentRequired:       codes.ResourceExhausted,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusConflict:              codes.FailedPrecondition,
+	http.StatusGone:                  codes.NotFound,
+	http.StatusRequestEntityTooLarge: codes.InvalidArgument,
+	http.StatusUnprocessableEntity:   codes.FailedPrecondition,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+}
+
+// grpcError maps service errors to status codes through the same API error
+// model as the REST handlers. The apierr code is sent as the
+// "x-error-code" trailer.
+func grpcError(ctx context.Context, err error) error {
+	return grpcAPIError(ctx, apiError(err, errorStatus(err)), err.Error())
+}
+
+// grpcAPIError is the gRPC status of an API error with a specific message
+func grpcAPIError(ctx context.Context, apiErr *apierr.Error, message string) error {
+	grpc.SetTrailer(ctx, metadata.Pairs("x-error-code", apiErr.Code))
+
+	code, ok := grpcCodes[apiErr.Status]
+	if !ok {
+		code = codes.Internal
+	}
+	return status.Error(code, message)
+}
+
+func grpcTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func newGRPCSession(session *WhatsAppSession) *whatsapppb.Session {
+	pb := &whatsapppb.Session{
+		Id:                session.ID,
+		Name:              session.SessionName,
+		Status:            string(session.Status),
+		IsBusinessAccount: session.IsBusinessAccount,
+		ConnectedAt:       grpcTimestamp(session.ConnectedAt),
+		LastSeen:          grpcTimestamp(session.LastSeen),
+		CreatedAt:         grpcTimestamp(&session.CreatedAt),
+	}
+	if session.PhoneNumber != nil {
+		pb.PhoneNumber = *session.PhoneNumber
+	}
+	if session.JID != nil {
+		pb.Jid = *session.JID
+	}
+	if session.PushName != nil {
+		pb.PushName = *session.PushName
+	}
+	if session.Platform != nil {
+		pb.Platform = *session.Platform
+	}
+	return pb
+}
+
+// CreateSession creates a session and starts pairing
+func (s *GRPCServer) CreateSession(ctx context.Context, req *whatsapppb.CreateSessionRequest) (*whatsapppb.Session, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, grpcAPIError(ctx, apierr.ErrInvalidRequest, "name is required")
+	}
+	session, err := s.whatsappService.CreateSession(grpcUserID(ctx), req.Name)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return newGRPCSession(session), nil
+}
+
+// ListSessions lists the sessions of the user
+func (s *GRPCServer) ListSessions(ctx context.Context, req *whatsapppb.ListSessionsRequest) (*whatsapppb.ListSessionsResponse, error) {
+	sessions, err := s.whatsappService.GetUserSessions(grpcUserID(ctx))
+	if err != nil {
+		return nil, grpcAPIError(ctx, apierr.ErrInternal, "failed to load sessions")
+	}
+
+	resp := &whatsapppb.ListSessionsResponse{Sessions: make([]*whatsapppb.Session, 0, len(sessions))}
+	for i := range sessions {
+		resp.Sessions = append(resp.Sessions, newGRPCSession(&sessions[i]))
+	}
+	return resp, nil
+}
+
+// GetSession returns the status of a session
+func (s *GRPCServer) GetSession(ctx context.Context, req *whatsapppb.GetSessionRequest) (*whatsapppb.Session, error) {
+	session, err := s.whatsappService.GetSessionStatus(req.SessionId, grpcUserID(ctx))
+	if err != nil {
+		if errors.Is(err, apierr.ErrInvalidSessionID) {
+			return nil, grpcError(ctx, err)
+		}
+		return nil, grpcError(ctx, apierr.ErrSessionNotFound)
+	}
+	return newGRPCSession(session), nil
+}
+
+// GetQRCode returns the pairing QR code of a session
+func (s *GRPCServer) GetQRCode(ctx context.Context, req *whatsapppb.GetQRCodeRequest) (*whatsapppb.QRCode, error) {
+	session, err := s.GetSession(ctx, &whatsapppb.GetSessionRequest{SessionId: req.SessionId})
+	if err != nil {
+		return nil, err
+	}
+	if session.Status != string(StatusPending) && session.Status != string(StatusQRReady) {
+		return nil, grpcAPIError(ctx, apierr.ErrQRNotAvailable, "QR code not available for status: "+session.Status)
+	}
+
+	qrCode, err := s.whatsappService.GetQRCode(req.SessionId, grpcUserID(ctx))
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &whatsapppb.QRCode{QrCode: qrCode}, nil
+}
+
+// RefreshSession reconnects a session
+func (s *GRPCServer) RefreshSession(ctx context.Context, req *whatsapppb.RefreshSessionRequest) (*whatsapppb.Session, error) {
+	if err := s.whatsappService.RefreshSession(req.SessionId, grpcUserID(ctx)); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return s.GetSession(ctx, &whatsapppb.GetSessionRequest{SessionId: req.SessionId})
+}
+
+// DeleteSession logs out and deletes a session
+func (s *GRPCServer) DeleteSession(ctx context.Context, req *whatsapppb.DeleteSessionRequest) (*whatsapppb.DeleteSessionResponse, error) {
+	if _, err := uuid.Parse(req.SessionId); err != nil {
+		return nil, grpcError(ctx, apierr.ErrInvalidSessionID)
+	}
+	if err := s.whatsappService.DeleteSession(req.SessionId, grpcUserID(ctx)); err != nil {
+		return nil, grpcError(ctx, fmt.Errorf("failed to delete session: %w", err))
+	}
+	return &whatsapppb.DeleteSessionResponse{}, nil
+}
+
+// SendMessage sends one message through DispatchSend
+func (s *GRPCServer) SendMessage(ctx context.Context, req *whatsapppb.SendMessageRequest) (*whatsapppb.SendMessageResponse, error) {
+	if req.SessionId == "" || req.To == "" {
+		return nil, grpcAPIError(ctx, apierr.ErrInvalidRequest, "session_id and to are required")
+	}
+
+	sendReq := SendRequest{
+		SessionID: req.SessionId,
+		To:        req.To,
+		Text:      req.Text,
+		MediaType: req.MediaType,
+		MediaID:   req.MediaId,
+		MediaURL:  req.MediaUrl,
+		Filename:  req.Filename,
+		Mimetype:  req.Mimetype,
+		IsVoice:   req.IsVoice,
+		Buttons:   req.Buttons,
+	}
+	if len(req.MediaData) > 0 {
+		sendReq.MediaBase64 = base64.StdEncoding.EncodeToString(req.MediaData)
+	}
+	if req.Location != nil {
+		sendReq.Location = &LocationPayload{
+			Latitude:  req.Location.Latitude,
+			Longitude: req.Location.Longitude,
+			Name:      req.Location.Name,
+			Address:   req.Location.Address,
+		}
+	}
+	for _, vcard := range req.Vcards {
+		sendReq.Contacts = append(sendReq.Contacts, ContactCard{VCard: vcard})
+	}
+
+	resp, err := s.whatsappService.DispatchSend(grpcUserID(ctx), sendReq)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &whatsapppb.SendMessageResponse{
+		MessageId: resp.MessageID,
+		To:        resp.To,
+		Type:      resp.Type,
+		Timestamp: grpcTimestamp(&resp.Timestamp),
+	}, nil
+}
+
+// StreamEvents streams the events of a session until the client cancels
+func (s *GRPCServer) StreamEvents(req *whatsapppb.StreamEventsRequest, stream whatsapppb.WhatsApp_StreamEventsServer) error {
+	ctx := stream.Context()
+	userID := grpcUserID(ctx)
+
+	sessionID, err := uuid.Parse(req.SessionId)
+	if err != nil {
+		return grpcError(ctx, apierr.ErrInvalidSessionID)
+	}
+	session, err := s.db.GetSession(sessionID, userID)
+	if err != nil {
+		return grpcError(ctx, apierr.ErrSessionNotFound)
+	}
+
+	var topics []string
+	if len(req.Topics) > 0 {
+		if topics, err = parseWSTopics(req.Topics); err != nil {
+			return grpcAPIError(ctx, apierr.ErrInvalidRequest, err.Error())
+		}
+	}
+	if req.Since != nil && *req.Since < 0 {
+		return grpcAPIError(ctx, apierr.ErrInvalidRequest, "since must not be negative")
+	}
+
+	client := newStreamClient(req.SessionId, userID, topics, func(message WebSocketMessage) error {
+		data, err := json.Marshal(message.Data)
+		if err != nil {
+			return err
+		}
+		return stream.Send(&whatsapppb.Event{
+			Type:      message.Type,
+			Seq:       message.Seq,
+			SessionId: req.SessionId,
+			Timestamp: timestamppb.New(message.Timestamp),
+			Data:      string(data),
+		})
+	})
+	s.wsManager.AddConnection(req.SessionId, client)
+	defer s.wsManager.RemoveConnection(req.SessionId, client)
+
+	client.send(WebSocketMessage{
+		Type: "status",
+		Data: map[string]interface{}{
+			"session_id": session.ID,
+			"status":     session.Status,
+			"connected":  session.Status == StatusConnected,
+			"topics":     client.subscriptions(),
+		},
+	})
+
+	s.wsManager.serveStream(client, req.GetSince(), req.Since != nil, ctx.Done())
+	return nil
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= SESSION HEALTH =============
+// IsConnected only says the websocket is open. The health check also looks at
+// whether the device is logged in, whether WhatsApp still answers keepalive
+// pings, how sends have been going and how much is waiting in the outbox.
+// With probe set it additionally makes a round trip to WhatsApp and reports
+// its latency. The readiness probe (GET /ready) summarizes the same checks
+// over all loaded sessions.
+//
+// Keepalive streaks, repeated disconnects (flapping) and stream replacements
+// are stored as session events and pushed to clients as they happen, so
+// operators see an unstable connection instead of a silent stall.
+
+// Health states
+const (
+	HealthHealthy   = "healthy"
+	HealthDegraded  = "degraded"
+	HealthUnhealthy = "unhealthy"
+)
+
+const (
+	healthOutboxBacklog       = 100 // pending outbox messages that mark a session degraded
+	healthProbeTimeout        = 10 * time.Second
+	healthFlapWindow          = 10 * time.Minute
+	healthFlapThreshold       = 3  // disconnects within healthFlapWindow that mark a session flapping
+	healthQueueBacklogPercent = 80 // worker queue fill that marks a session degraded
+)
+
+// connHealth records the send and keepalive outcomes of a client
+type connHealth struct {
+	mu                sync.Mutex
+	lastSendOK        time.Time
+	lastSendFailed    time.Time
+	lastSendError     string
+	keepAliveFailures int         // consecutive keepalive timeouts, 0 = answering
+	keepAliveOK       time.Time   // last answered ping reported by whatsmeow
+	keepAliveFailed   time.Time   // first timeout of the current streak
+	disconnects       []time.Time // within healthFlapWindow
+	streamReplaced    time.Time   // another client took over the device, zero once connected again
+}
+
+func (h *connHealth) recordSend(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.lastSendFailed = time.Now()
+		h.lastSendError = err.Error()
+		return
+	}
+	h.lastSendOK = time.Now()
+}
+
+// keepAliveTimeout records an unanswered ping and reports whether it started
+// a streak
+func (h *connHealth) keepAliveTimeout(evt *events.KeepAliveTimeout) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	first := h.keepAliveFailures == 0
+	if first {
+		h.keepAliveFailed = time.Now()
+	}
+	h.keepAliveFailures = evt.ErrorCount
+	h.keepAliveOK = evt.LastSuccess
+	return first
+}
+
+// keepAliveRestored ends a streak and returns its length and start
+func (h *connHealth) keepAliveRestored() (int, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	failures, since := h.keepAliveFailures, h.keepAliveFailed
+	h.keepAliveFailures = 0
+	h.keepAliveOK = time.Now()
+	return failures, since
+}
+
+// recordDisconnect returns the number of disconnects within healthFlapWindow
+func (h *connHealth) recordDisconnect() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	h.disconnects = append(h.recentDisconnects(now), now)
+	return len(h.disconnects)
+}
+
+// recentDisconnects drops disconnects older than healthFlapWindow; callers
+// hold mu
+func (h *connHealth) recentDisconnects(now time.Time) []time.Time {
+	recent := h.disconnects[:0]
+	for _, at := range h.disconnects {
+		if now.Sub(at) < healthFlapWindow {
+			recent = append(recent, at)
+		}
+	}
+	h.disconnects = recent
+	return recent
+}
+
+func (h *connHealth) recordStreamReplaced() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.streamReplaced = time.Now()
+}
+
+func (h *connHealth) recordConnected() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.streamReplaced = time.Time{}
+}
+
+// KeepAliveHealth is the keepalive state of a session
+type KeepAliveHealth struct {
+	Healthy      bool       `json:"healthy"`
+	Failures     int        `json:"failures"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+}
+
+// SessionHealth is the result of a session health check
+type SessionHealth struct {
+	SessionID          string              `json:"session_id"`
+	Status             string              `json:"status"`
+	Problems           []string            `json:"problems,omitempty"`
+	SessionStatus      SessionStatus       `json:"session_status"`
+	ClientLoaded       bool                `json:"client_loaded"`
+	Connected          bool                `json:"connected"`
+	LoggedIn           bool                `json:"logged_in"`
+	KeepAlive          KeepAliveHealth     `json:"keepalive"`
+	RecentDisconnects  int                 `json:"recent_disconnects"` // within the last 10 minutes
+	StreamReplacedAt   *time.Time          `json:"stream_replaced_at,omitempty"`
+	Throttle           *ThrottleHealth     `json:"throttle,omitempty"`
+	Queue              *SessionQueueHealth `json:"queue,omitempty"`
+	Calls              *SessionCallsHealth `json:"calls,omitempty"`
+	LastSuccessfulSend *time.Time          `json:"last_successful_send,omitempty"`
+	LastFailedSend     *time.Time          `json:"last_failed_send,omitempty"`
+	LastSendError      string              `json:"last_send_error,omitempty"`
+	PendingOutbox      int64               `json:"pending_outbox"`
+	SafetyPausedUntil  *time.Time          `json:"safety_paused_until,omitempty"`
+	ProbeLatencyMs     *int64              `json:"probe_latency_ms,omitempty"`
+	ProbeError         string              `json:"probe_error,omitempty"`
+	Canary             *CanaryHealth       `json:"canary,omitempty"`
+	CheckedAt          time.Time           `json:"checked_at"`
+}
+
+// unhealthy and degraded record a problem and lower the status
+func (h *SessionHealth) unhealthy(problem string) {
+	h.Status = HealthUnhealthy
+	h.Problems = append(h.Problems, problem)
+}
+
+func (h *SessionHealth) degraded(problem string) {
+	if h.Status == HealthHealthy {
+		h.Status = HealthDegraded
+	}
+	h.Problems = append(h.Problems, problem)
+}
+
+// GetSessionHealth runs the health check of a session; probe adds a round
+// trip to WhatsApp
+func (ws *WhatsAppService) GetSessionHealth(sessionID string, userID int, probe bool) (*SessionHealth, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	health := ws.checkSessionHealth(session, probe)
+
+	pending, err := ws.db.CountPendingOutbox(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	health.PendingOutbox = pending
+	if pending > healthOutboxBacklog {
+		health.degraded("outbox backlog")
+	}
+	return health, nil
+}
+
+// checkSessionHealth checks the in-memory client of a session
+func (ws *WhatsAppService) checkSessionHealth(session *WhatsAppSession, probe bool) *SessionHealth {
+	now := time.Now()
+	health := &SessionHealth{
+		SessionID:     session.ID,
+		Status:        HealthHealthy,
+		SessionStatus: session.Status,
+		CheckedAt:     now,
+	}
+	if session.SafetyPausedUntil != nil && session.SafetyPausedUntil.After(now) {
+		health.SafetyPausedUntil = session.SafetyPausedUntil
+		health.degraded("sending paused by the safety engine")
+	}
+
+	sc, ok := ws.sessions.Get(session.ID)
+	if !ok {
+		health.unhealthy("client not loaded")
+		return health
+	}
+	health.ClientLoaded = true
+	health.Connected = sc.Client.IsConnected()
+	health.LoggedIn = sc.Client.IsLoggedIn()
+
+	sc.health.mu.Lock()
+	health.KeepAlive = KeepAliveHealth{
+		Healthy:  sc.health.keepAliveFailures == 0,
+		Failures: sc.health.keepAliveFailures,
+	}
+	if !sc.health.keepAliveOK.IsZero() {
+		lastSuccess := sc.health.keepAliveOK
+		health.KeepAlive.LastSuccess = &lastSuccess
+	}
+	if sc.health.keepAliveFailures > 0 {
+		failingSince := sc.health.keepAliveFailed
+		health.KeepAlive.FailingSince = &failingSince
+	}
+	health.RecentDisconnects = len(sc.health.recentDisconnects(now))
+	if !sc.health.streamReplaced.IsZero() {
+		streamReplaced := sc.health.streamReplaced
+		health.StreamReplacedAt = &streamReplaced
+	}
+	if !sc.health.lastSendOK.IsZero() {
+		lastSendOK := sc.health.lastSendOK
+		health.LastSuccessfulSend = &lastSendOK
+	}
+	if !sc.health.lastSendFailed.IsZero() {
+		lastSendFailed := sc.health.lastSendFailed
+		health.LastFailedSend = &lastSendFailed
+		health.LastSendError = sc.health.lastSendError
+	}
+	sc.health.mu.Unlock()
+
+	throttle := sc.throttle.health()
+	health.Throttle = &throttle
+	if throttle.Throttled {
+		health.degraded("rate limited by WhatsApp")
+	}
+
+	queue := sc.queueHealth()
+	health.Queue = &queue
+	if queue.Depth*100 >= queue.Capacity*healthQueueBacklogPercent {
+		health.degraded("event queue backlog")
+	}
+
+	calls := ws.callsHealth(sc)
+	health.Calls = &calls
+
+	if health.RecentDisconnects >= healthFlapThreshold {
+		health.degraded("connection flapping")
+	}
+
+	switch {
+	case health.StreamReplacedAt != nil:
+		health.unhealthy("replaced by another client")
+	case !health.Connected:
+		health.unhealthy("not connected")
+	case !health.LoggedIn:
+		health.unhealthy("not logged in")
+	case !health.KeepAlive.Healthy:
+		health.degraded("keepalive pings unanswered")
+	}
+
+	// The socket may look fine while WhatsApp doesn't answer the canary
+	health.Canary = sc.canary.snapshot()
+	if health.Canary != nil && !health.Canary.Healthy {
+		health.degraded("canary failing")
+	}
+
+	if probe && health.Connected && health.LoggedIn {
+		ctx, cancel := context.WithTimeout(context.Background(), healthProbeTimeout)
+		defer cancel()
+
+		start := time.Now()
+		err := ws.callWhatsApp(sc, priorityBackground, "health probe", func() error {
+			_, err := sc.Client.TryFetchPrivacySettings(ctx, true)
+			return err
+		})
+		if err != nil {
+			health.ProbeError = err.Error()
+			health.unhealthy("probe failed")
+		} else {
+			latency := time.Since(start).Milliseconds()
+			health.ProbeLatencyMs = &latency
+		}
+	}
+	return health
+}
+
+// handleKeepAliveTimeout records an unanswered keepalive ping; the first of
+// a streak is reported
+func (ws *WhatsAppService) handleKeepAliveTimeout(sc *SessionClient, evt *events.KeepAliveTimeout) {
+	log.Printf("⚠️  Keepalive timeout for session %s (%d failures)", sc.SessionID, evt.ErrorCount)
+	if !sc.health.keepAliveTimeout(evt) {
+		return
+	}
+	ws.emitSessionHealthEvent(sc, "session_keepalive_timeout", map[string]interface{}{
+		"error_count":  evt.ErrorCount,
+		"last_success": evt.LastSuccess,
+	})
+}
+
+// handleKeepAliveRestored reports the end of a keepalive streak
+func (ws *WhatsAppService) handleKeepAliveRestored(sc *SessionClient) {
+	log.Printf("✅ Keepalive restored for session %s", sc.SessionID)
+	failures, since := sc.health.keepAliveRestored()
+	if failures == 0 {
+		return
+	}
+	ws.emitSessionHealthEvent(sc, "session_keepalive_restored", map[string]interface{}{
+		"failures":      failures,
+		"failing_since": since,
+		"down_seconds":  int(time.Since(since).Seconds()),
+	})
+}
+
+// recordSessionDisconnect counts a disconnect and reports the session as
+// flapping when it crosses healthFlapThreshold
+func (ws *WhatsAppService) recordSessionDisconnect(sc *SessionClient) {
+	if disconnects := sc.health.recordDisconnect(); disconnects == healthFlapThreshold {
+		log.Printf("⚠️  Session %s is flapping (%d disconnects in %s)", sc.SessionID, disconnects, healthFlapWindow)
+		ws.emitSessionHealthEvent(sc, "session_connection_flapping", map[string]interface{}{
+			"disconnects":    disconnects,
+			"window_seconds": int(healthFlapWindow.Seconds()),
+		})
+	}
+}
+
+// handleStreamReplaced marks a session disconnected after another client
+// connected with the same device. whatsmeow doesn't reconnect, and neither
+// does the session monitor, so the two clients don't keep kicking each other
+// out.
+func (ws *WhatsAppService) handleStreamReplaced(sc *SessionClient) {
+	log.Printf("⚠️  Session %s was replaced by another client using the same device", sc.SessionID)
+	sc.health.recordStreamReplaced()
+	ws.sessions.SetState(sc, SessionStateDisconnected)
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.SetSessionDisconnected(sessionUUID)
+
+	ws.emitSessionHealthEvent(sc, "session_stream_replaced", map[string]interface{}{
+		"replaced_at": time.Now(),
+	})
+}
+
+// emitSessionHealthEvent stores a connection health event and pushes it to
+// clients
+func (ws *WhatsAppService) emitSessionHealthEvent(sc *SessionClient, eventType string, data map[string]interface{}) {
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, eventType, data)
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: eventType,
+		Data: data,
+	})
+}
+
+// configureKeepAlive applies the KEEPALIVE_* settings to whatsmeow, which
+// keeps them process-wide
+func configureKeepAlive(cfg *Config) error {
+	if cfg.KeepAliveIntervalMin <= 0 || cfg.KeepAliveIntervalMax <= cfg.KeepAliveIntervalMin {
+		return fmt.Errorf("KEEPALIVE_INTERVAL_MAX (%s) must be greater than KEEPALIVE_INTERVAL_MIN (%s)",
+			cfg.KeepAliveIntervalMax, cfg.KeepAliveIntervalMin)
+	}
+	if cfg.KeepAliveResponseDeadline <= 0 || cfg.KeepAliveMaxFailTime <= 0 {
+		return fmt.Errorf("KEEPALIVE_RESPONSE_DEADLINE and KEEPALIVE_MAX_FAIL_TIME must be positive")
+	}
+	whatsmeow.KeepAliveIntervalMin = cfg.KeepAliveIntervalMin
+	whatsmeow.KeepAliveIntervalMax = cfg.KeepAliveIntervalMax
+	whatsmeow.KeepAliveResponseDeadline = cfg.KeepAliveResponseDeadline
+	whatsmeow.KeepAliveMaxFailTime = cfg.KeepAliveMaxFailTime
+	return nil
+}
+
+// ReadinessSummary counts the loaded sessions by health, plus how many of
+// them are backing off after WhatsApp rate limits
+func (ws *WhatsAppService) ReadinessSummary() map[string]int {
+	summary := map[string]int{
+		HealthHealthy:   0,
+		HealthDegraded:  0,
+		HealthUnhealthy: 0,
+		"throttled":     0,
+	}
+	ws.sessions.Range(func(sc *SessionClient) bool {
+		if sc.Device.ID == nil {
+			return true // still pairing
+		}
+		health := ws.checkSessionHealth(&WhatsAppSession{ID: sc.SessionID}, false)
+		summary[health.Status]++
+		if health.Throttle != nil && health.Throttle.Throttled {
+			summary["throttled"]++
+		}
+		return true
+	})
+	return summary
+}
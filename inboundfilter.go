@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-api/pkg/apierr"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= INBOUND FILTER =============
+// A session's inbound filter decides what happens to 1:1 messages from
+// unknown senders: with mode "ignore" they are dropped before they are
+// stored or emitted, "reply" also answers them with the session's canned
+// reply (at most once per chat every autoReplyCooldown) and "block" also
+// blocks the sender. Senders on the session's allowlist are never unknown,
+// neither are address book contacts unless allow_saved_contacts is turned
+// off; senders on the denylist always are, even in the address book. A sender
+// is matched by its phone JID and its LID. Groups, broadcasts and our own
+// messages aren't filtered.
+
+// Inbound filter modes
+const (
+	InboundFilterOff    = "off"
+	InboundFilterIgnore = "ignore"
+	InboundFilterReply  = "reply"
+	InboundFilterBlock  = "block"
+)
+
+// Sender lists
+const (
+	SenderListAllow = "allow"
+	SenderListDeny  = "deny"
+)
+
+const inboundReplyMaxLength = 1000
+
+// InboundFilterRequest changes the inbound filter of a session
+type InboundFilterRequest struct {
+	Mode               *string `json:"mode"`
+	ReplyMessage       *string `json:"reply_message"`
+	AllowSavedContacts *bool   `json:"allow_saved_contacts"`
+}
+
+// InboundFilterSettings are the inbound filter settings of a session
+type InboundFilterSettings struct {
+	Mode               string `json:"mode"`
+	ReplyMessage       string `json:"reply_message"`
+	AllowSavedContacts bool   `json:"allow_saved_contacts"`
+}
+
+// SenderRulesRequest puts senders on the allowlist or denylist
+type SenderRulesRequest struct {
+	List    string   `json:"list" binding:"required"`
+	Senders []string `json:"senders" binding:"required,min=1,max=1000"` // phone numbers or JIDs
+}
+
+// filterInbound applies the session's inbound filter to an incoming message
+// and reports whether the message was filtered out
+func (ws *WhatsAppService) filterInbound(sc *SessionClient, evt *events.Message) bool {
+	if evt.Info.IsFromMe || !wajid.IsUser(evt.Info.Chat) {
+		return false
+	}
+
+	settings, err := ws.db.GetSessionInboundFilter(sc.SessionID)
+	if err != nil {
+		log.Printf("⚠️  Failed to load the inbound filter of session %s: %v", sc.SessionID, err)
+		return false
+	}
+	if settings.InboundFilter == "" || settings.InboundFilter == InboundFilterOff {
+		return false
+	}
+
+	sender := evt.Info.Sender.ToNonAD()
+	jids := []string{sender.String()}
+	if !evt.Info.SenderAlt.IsEmpty() {
+		jids = append(jids, evt.Info.SenderAlt.ToNonAD().String())
+	}
+	if aliases, err := ws.db.GetJIDAliases(jids); err == nil {
+		jids = aliases
+	}
+
+	list, err := ws.db.GetSenderList(sc.SessionID, jids)
+	if err != nil {
+		log.Printf("⚠️  Failed to load the sender lists of session %s: %v", sc.SessionID, err)
+		return false
+	}
+	switch {
+	case list == SenderListAllow:
+		return false
+	case list != SenderListDeny && settings.InboundAllowSaved && ws.isSavedContact(sc, jids):
+		return false
+	}
+
+	log.Printf("🚧 Session %s: inbound filter (%s) dropped message %s from %s",
+		sc.SessionID, settings.InboundFilter, evt.Info.ID, sender)
+	switch settings.InboundFilter {
+	case InboundFilterReply:
+		if settings.InboundReply != "" && ws.claimAutoReply(sc.SessionID, evt.Info.Chat) {
+			// Sends wait for the safety engine's pacing; don't block the event loop
+			go ws.sendInboundReply(sc, evt.Info.Chat, settings.InboundReply)
+		}
+	case InboundFilterBlock:
+		go ws.blockSender(sc, sender)
+	}
+	return true
+}
+
+// isSavedContact reports whether any of the JIDs is in the session's address
+// book
+func (ws *WhatsAppService) isSavedContact(sc *SessionClient, jids []string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, input := range jids {
+		jid, err := types.ParseJID(input)
+		if err != nil {
+			continue
+		}
+		info, err := sc.Device.Contacts.GetContact(ctx, jid)
+		if err == nil && (info.FullName != "" || info.FirstName != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// sendInboundReply answers an unknown sender with the canned reply
+func (ws *WhatsAppService) sendInboundReply(sc *SessionClient, chat types.JID, text string) {
+	if err := ws.checkSuppressed(sc, chat); err != nil {
+		log.Printf("⚠️  Inbound filter reply to %s skipped: %v", chat.String(), err)
+		return
+	}
+	if _, err := ws.sendTextToJID(sc, chat, text); err != nil {
+		log.Printf("❌ Inbound filter reply to %s failed: %v", chat.String(), err)
+	}
+}
+
+// blockSender blocks an unknown sender and records the block like one made
+// on the phone
+func (ws *WhatsAppService) blockSender(sc *SessionClient, sender types.JID) {
+	err := ws.callWhatsApp(sc, priorityInteractive, "block sender", func() error {
+		_, err := sc.Client.UpdateBlocklist(context.Background(), sender, events.BlocklistChangeActionBlock)
+		return err
+	})
+	if err != nil {
+		log.Printf("❌ Failed to block %s on session %s: %v", sender, sc.SessionID, err)
+		return
+	}
+
+	jid := ws.blocklistJID(sc, sender)
+	if _, err := ws.db.SetBlocked(sc.SessionID, sc.UserID, jid, true); err != nil {
+		log.Printf("⚠️  Failed to store block of %s on session %s: %v", jid, sc.SessionID, err)
+	}
+	log.Printf("⛔ Session %s: inbound filter blocked %s", sc.SessionID, jid)
+	ws.emitBlocklistChanged(sc, []string{jid}, nil, "inbound_filter")
+}
+
+// GetInboundFilter returns the inbound filter settings of a session
+func (ws *WhatsAppService) GetInboundFilter(sessionID string, userID int) (*InboundFilterSettings, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+	mode := session.InboundFilter
+	if mode == "" {
+		mode = InboundFilterOff
+	}
+	return &InboundFilterSettings{
+		Mode:               mode,
+		ReplyMessage:       session.InboundReply,
+		AllowSavedContacts: session.InboundAllowSaved,
+	}, nil
+}
+
+// UpdateInboundFilter changes the mode, canned reply or address book
+// exemption of a session's inbound filter
+func (ws *WhatsAppService) UpdateInboundFilter(sessionID string, userID int, req InboundFilterRequest) (*InboundFilterSettings, error) {
+	updates := make(map[string]interface{})
+	if req.Mode != nil {
+		switch *req.Mode {
+		case InboundFilterOff, InboundFilterIgnore, InboundFilterReply, InboundFilterBlock:
+			updates["inbound_filter"] = *req.Mode
+		default:
+			return nil, fmt.Errorf("mode must be off, ignore, reply or block")
+		}
+	}
+	if req.ReplyMessage != nil {
+		message := strings.TrimSpace(*req.ReplyMessage)
+		if len(message) > inboundReplyMaxLength {
+			return nil, fmt.Errorf("reply_message is longer than %d characters", inboundReplyMaxLength)
+		}
+		updates["inbound_reply"] = message
+	}
+	if req.AllowSavedContacts != nil {
+		updates["inbound_allow_saved"] = *req.AllowSavedContacts
+	}
+	if len(updates) == 0 {
+		return nil, fmt.Errorf("nothing to update")
+	}
+
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+	if updates["inbound_filter"] == InboundFilterReply {
+		reply, ok := updates["inbound_reply"].(string)
+		if !ok {
+			reply = session.InboundReply
+		}
+		if reply == "" {
+			return nil, fmt.Errorf("reply mode needs a reply_message")
+		}
+	}
+	if err := ws.db.UpdateSessionInboundFilter(sessionID, updates); err != nil {
+		return nil, fmt.Errorf("failed to update inbound filter: %w", err)
+	}
+
+	log.Printf("🚧 Inbound filter of session %s updated: %v", sessionID, updates)
+	return ws.GetInboundFilter(sessionID, userID)
+}
+
+// senderRuleJID parses a sender given as a phone number or user JID; LIDs
+// with a known phone number are stored as the phone JID
+func (ws *WhatsAppService) senderRuleJID(input string) (string, error) {
+	jid, err := wajid.Parse(input)
+	if err != nil {
+		return "", err
+	}
+	if !wajid.IsUser(jid) {
+		return "", fmt.Errorf("not a user JID")
+	}
+	return ws.db.CanonicalContactJID(jid.String()), nil
+}
+
+// AddSenderRules puts senders on the allowlist or denylist of a session,
+// taking them off the other list. Inputs that aren't phone numbers or user
+// JIDs are returned in invalid.
+func (ws *WhatsAppService) AddSenderRules(sessionID string, userID int, req SenderRulesRequest) ([]WhatsAppSenderRule, map[string]string, error) {
+	if req.List != SenderListAllow && req.List != SenderListDeny {
+		return nil, nil, fmt.Errorf("list must be allow or deny")
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, nil, apierr.ErrSessionNotFound
+	}
+
+	rules := make([]WhatsAppSenderRule, 0, len(req.Senders))
+	invalid := make(map[string]string)
+	seen := make(map[string]bool, len(req.Senders))
+	for _, input := range req.Senders {
+		jid, err := ws.senderRuleJID(input)
+		if err != nil {
+			invalid[input] = err.Error()
+			continue
+		}
+		if seen[jid] {
+			continue
+		}
+		seen[jid] = true
+		rules = append(rules, WhatsAppSenderRule{
+			SessionID: sessionID,
+			UserID:    userID,
+			JID:       jid,
+			List:      req.List,
+		})
+	}
+
+	if err := ws.db.SaveSenderRules(rules); err != nil {
+		return nil, invalid, fmt.Errorf("failed to save sender rules: %w", err)
+	}
+	return rules, invalid, nil
+}
+
+// GetSenderRules lists the allowlist and denylist of a session
+func (ws *WhatsAppService) GetSenderRules(sessionID string, userID int, q ListQuery) ([]WhatsAppSenderRule, int64, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, 0, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, 0, apierr.ErrSessionNotFound
+	}
+	rules, total, err := ws.db.GetSenderRules(sessionID, q)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load sender rules: %w", err)
+	}
+	return rules, total, nil
+}
+
+// RemoveSenderRule takes a sender off the lists of a session
+func (ws *WhatsAppService) RemoveSenderRule(sessionID string, userID int, input string) error {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return apierr.ErrSessionNotFound
+	}
+	jid, err := ws.senderRuleJID(input)
+	if err != nil {
+		return err
+	}
+
+	removed, err := ws.db.DeleteSenderRule(sessionID, jid)
+	if err != nil {
+		return fmt.Errorf("failed to remove sender rule: %w", err)
+	}
+	if removed == 0 {
+		return fmt.Errorf("sender rule not found")
+	}
+	return nil
+}
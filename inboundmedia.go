@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= INBOUND MEDIA AUTO-STORE =============
+// With a session's media_auto_store on, the media of every incoming message
+// (image, video, audio, document, sticker) is downloaded when it arrives and
+// copied to media storage (the S3 bucket with MEDIA_STORAGE=s3, otherwise
+// MEDIA_STORAGE_DIR) under inbound/<session_id>/. The copy is recorded as the
+// message's media.stored_key like a view-once copy, and message_media_saved
+// carries its key, a signed url and the file's SHA-256, so consumers don't
+// need a second round trip to download the media. Downloads run on the
+// session's worker, so large media delay that session's later events; media
+// above the size limit of its type (MAX_*_SIZE) is skipped.
+
+// MediaSettingsRequest changes the media settings of a session
+type MediaSettingsRequest struct {
+	AutoStore *bool `json:"auto_store"`
+}
+
+// MediaSettings are the media settings of a session
+type MediaSettings struct {
+	AutoStore bool   `json:"auto_store"`
+	Storage   string `json:"storage"` // media storage the copies go to: local or s3
+}
+
+func inboundMediaPrefix(sessionID string) string {
+	return "inbound/" + sessionID + "/"
+}
+
+// GetMediaSettings returns the media settings of a session
+func (ws *WhatsAppService) GetMediaSettings(sessionID string, userID int) (*MediaSettings, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+	return &MediaSettings{
+		AutoStore: session.MediaAutoStore,
+		Storage:   ws.cfg.MediaStorage,
+	}, nil
+}
+
+// UpdateMediaSettings turns the media auto-store of a session on or off
+func (ws *WhatsAppService) UpdateMediaSettings(sessionID string, userID int, req MediaSettingsRequest) (*MediaSettings, error) {
+	if req.AutoStore == nil {
+		return nil, fmt.Errorf("nothing to update")
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	if err := ws.db.SetSessionMediaAutoStore(sessionID, *req.AutoStore); err != nil {
+		return nil, fmt.Errorf("failed to update media settings: %w", err)
+	}
+	log.Printf("💾 Media auto-store of session %s: %t", sessionID, *req.AutoStore)
+	return ws.GetMediaSettings(sessionID, userID)
+}
+
+// messageMediaCopy returns where the media of a received message is copied
+// to, if anywhere: view-once media with VIEW_ONCE_AUTO_DOWNLOAD, and any
+// media of sessions with media_auto_store
+func (ws *WhatsAppService) messageMediaCopy(sc *SessionClient, stored *WhatsAppMessage) (keyPrefix, reason string) {
+	if stored.Media == nil || stored.FromMe {
+		return "", ""
+	}
+	if stored.ViewOnce && ws.cfg.ViewOnceAutoDownload {
+		return "view-once/" + sc.SessionID + "/", "view_once"
+	}
+	autoStore, err := ws.db.GetSessionMediaAutoStore(sc.SessionID)
+	if err != nil {
+		log.Printf("⚠️  Failed to load media settings of session %s: %v", sc.SessionID, err)
+		return "", ""
+	}
+	if autoStore {
+		return inboundMediaPrefix(sc.SessionID), "auto_store"
+	}
+	return "", ""
+}
+
+// saveMessageMedia copies the media of a received message to media storage
+// under keyPrefix and records the key on the stored message
+func (ws *WhatsAppService) saveMessageMedia(sc *SessionClient, stored WhatsAppMessage, msg *waE2E.Message, keyPrefix, reason string) {
+	inner, _, _ := unwrapMessage(msg)
+
+	var (
+		media     whatsmeow.DownloadableMessage
+		mimetype  string
+		mediaType string
+		length    uint64
+	)
+	switch {
+	case inner.GetImageMessage() != nil:
+		m := inner.GetImageMessage()
+		media, mimetype, mediaType, length = m, m.GetMimetype(), "image", m.GetFileLength()
+	case inner.GetVideoMessage() != nil:
+		m := inner.GetVideoMessage()
+		media, mimetype, mediaType, length = m, m.GetMimetype(), "video", m.GetFileLength()
+	case inner.GetAudioMessage() != nil:
+		m := inner.GetAudioMessage()
+		media, mimetype, mediaType, length = m, m.GetMimetype(), "audio", m.GetFileLength()
+	case inner.GetDocumentMessage() != nil:
+		m := inner.GetDocumentMessage()
+		media, mimetype, mediaType, length = m, m.GetMimetype(), "document", m.GetFileLength()
+	case inner.GetStickerMessage() != nil:
+		m := inner.GetStickerMessage()
+		media, mimetype, mediaType, length = m, m.GetMimetype(), "image", m.GetFileLength()
+	default:
+		return
+	}
+	if maxSize := ws.cfg.maxMediaSize(mediaType); length > uint64(maxSize) {
+		log.Printf("ℹ️  Not storing media of message %s for session %s: %d bytes, max %d for %s", stored.MessageID, sc.SessionID, length, maxSize, mediaType)
+		return
+	}
+
+	var content []byte
+	err := ws.callWhatsApp(sc, priorityBackground, "download media", func() error {
+		var err error
+		content, err = sc.Client.Download(context.Background(), media)
+		return err
+	})
+	if err != nil {
+		log.Printf("❌ Failed to download media of message %s for session %s: %v", stored.MessageID, sc.SessionID, err)
+		return
+	}
+
+	key := keyPrefix + stored.MessageID + mediaExtension(mimetype)
+	if err := ws.media.Put(context.Background(), key, bytes.NewReader(content), int64(len(content)), mimetype); err != nil {
+		log.Printf("❌ Failed to store media of message %s for session %s: %v", stored.MessageID, sc.SessionID, err)
+		return
+	}
+
+	ref := stored.Media
+	if ref == nil {
+		ref = JSONData{}
+	}
+	ref["stored_key"] = key
+	if _, err := ws.db.UpdateMessage(sc.SessionID, stored.ChatJID, stored.MessageID, map[string]interface{}{"media": ref}); err != nil {
+		log.Printf("❌ Failed to record media of message %s for session %s: %v", stored.MessageID, sc.SessionID, err)
+		return
+	}
+	log.Printf("💾 Saved media of message %s (%d bytes) for session %s", stored.MessageID, len(content), sc.SessionID)
+
+	sum := sha256.Sum256(content)
+	data := map[string]interface{}{
+		"message_id": stored.MessageID,
+		"chat":       stored.ChatJID,
+		"mimetype":   mimetype,
+		"size":       len(content),
+		"key":        key,
+		"sha256":     hex.EncodeToString(sum[:]),
+		"reason":     reason, // view_once or auto_store
+	}
+	expiresAt := time.Now().Add(messageMediaURLTTL)
+	if url, err := ws.media.SignedURL(context.Background(), key, messageMediaURLTTL); err != nil {
+		log.Printf("⚠️  Failed to sign media URL of message %s for session %s: %v", stored.MessageID, sc.SessionID, err)
+	} else {
+		data["url"] = url
+		data["url_expires_at"] = expiresAt
+	}
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "message_media_saved", data)
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "message_media_saved",
+		Data: data,
+	})
+}
@@ -0,0 +1,100 @@
+package eventsink
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// Kafka produces events to a topic with kafka-go, acknowledged by all
+// in-sync replicas. The session ID is the record key, partitioned with the
+// Java client's murmur2 partitioner, so the events of a session keep their
+// order. The topic has to exist. Connections are plain TCP or TLS, optionally
+// with SASL/PLAIN.
+type Kafka struct {
+	writer *kafka.Writer
+}
+
+const kafkaTimeout = 30 * time.Second
+
+// NewKafka creates a Kafka sink; brokers are contacted on the first publish
+func NewKafka(cfg Config) (*Kafka, error) {
+	if len(cfg.KafkaBrokers) == 0 || cfg.KafkaTopic == "" {
+		return nil, fmt.Errorf("eventsink: Kafka brokers and topic are required")
+	}
+	for _, broker := range cfg.KafkaBrokers {
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			return nil, fmt.Errorf("eventsink: invalid Kafka broker %q (expected host:port)", broker)
+		}
+	}
+
+	transport := &kafka.Transport{
+		ClientID:    "whatsapp-api",
+		DialTimeout: 10 * time.Second,
+	}
+	if cfg.KafkaTLS {
+		transport.TLS = &tls.Config{}
+	}
+	if cfg.KafkaUsername != "" {
+		transport.SASL = plain.Mechanism{Username: cfg.KafkaUsername, Password: cfg.KafkaPassword}
+	}
+
+	return &Kafka{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.KafkaBrokers...),
+			Topic:        cfg.KafkaTopic,
+			Balancer:     kafka.Murmur2Balancer{Consistent: true},
+			RequiredAcks: kafka.RequireAll,
+			// A failed batch is published again as a whole by the exporter
+			MaxAttempts:  1,
+			BatchSize:    maxKafkaBatch,
+			BatchTimeout: 10 * time.Millisecond,
+			WriteTimeout: kafkaTimeout,
+			ReadTimeout:  kafkaTimeout,
+			Transport:    transport,
+		},
+	}, nil
+}
+
+// maxKafkaBatch is the most records written in one produce request
+const maxKafkaBatch = 1000
+
+// kafkaMessages converts events to records keyed by session
+func kafkaMessages(events []Event) ([]kafka.Message, error) {
+	messages := make([]kafka.Message, len(events))
+	for i, event := range events {
+		body, err := encode(event)
+		if err != nil {
+			return nil, err
+		}
+		messages[i] = kafka.Message{
+			Key:   []byte(event.SessionID),
+			Value: body,
+			Headers: []kafka.Header{
+				{Key: "type", Value: []byte(event.Type)},
+				{Key: "id", Value: []byte(event.ID)},
+			},
+		}
+	}
+	return messages, nil
+}
+
+func (k *Kafka) Publish(ctx context.Context, events []Event) error {
+	messages, err := kafkaMessages(events)
+	if err != nil {
+		return err
+	}
+	if err := k.writer.WriteMessages(ctx, messages...); err != nil {
+		return fmt.Errorf("eventsink: Kafka: %w", err)
+	}
+	return nil
+}
+
+func (k *Kafka) Close() error {
+	return k.writer.Close()
+}
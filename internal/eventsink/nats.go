@@ -0,0 +1,118 @@
+package eventsink
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATS publishes events to a JetStream stream with nats.go: each event goes
+// to <subject>.<event type> and is acknowledged by the stream, which drops
+// redelivered events by their Nats-Msg-Id header. The stream has to exist
+// and capture the subjects.
+type NATS struct {
+	url     string
+	subject string
+
+	mu   sync.Mutex
+	conn *nats.Conn
+	js   jetstream.JetStream
+}
+
+const natsTimeout = 30 * time.Second
+
+// NewNATS creates a NATS JetStream sink; the connection is opened on the
+// first publish
+func NewNATS(cfg Config) (*NATS, error) {
+	if cfg.NATSURL == "" || cfg.NATSSubject == "" {
+		return nil, fmt.Errorf("eventsink: NATS URL and subject are required")
+	}
+	parsed, err := url.Parse(cfg.NATSURL)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("eventsink: invalid NATS URL %q", cfg.NATSURL)
+	}
+	if parsed.Scheme != "nats" && parsed.Scheme != "tls" {
+		return nil, fmt.Errorf("eventsink: NATS URL must start with nats:// or tls://")
+	}
+	return &NATS{
+		url:     cfg.NATSURL,
+		subject: strings.TrimSuffix(cfg.NATSSubject, "."),
+	}, nil
+}
+
+// natsMsg is the JetStream message of an event
+func natsMsg(subject string, event Event) (*nats.Msg, error) {
+	body, err := encode(event)
+	if err != nil {
+		return nil, err
+	}
+	msg := nats.NewMsg(subject + "." + subjectToken(event.Type))
+	msg.Header.Set(jetstream.MsgIDHeader, event.ID)
+	msg.Data = body
+	return msg, nil
+}
+
+func (n *NATS) Publish(ctx context.Context, events []Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.js == nil {
+		if err := n.connect(); err != nil {
+			return err
+		}
+	}
+
+	// Publish everything, then wait for the stream's acknowledgements
+	futures := make([]jetstream.PubAckFuture, len(events))
+	for i, event := range events {
+		msg, err := natsMsg(n.subject, event)
+		if err != nil {
+			return err
+		}
+		if futures[i], err = n.js.PublishMsgAsync(msg); err != nil {
+			return fmt.Errorf("eventsink: NATS publish of event %s: %w", event.ID, err)
+		}
+	}
+	for i, future := range futures {
+		select {
+		case <-future.Ok():
+		case err := <-future.Err():
+			return fmt.Errorf("eventsink: NATS publish of event %s: %w", events[i].ID, err)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// connect opens the connection; nats.go reconnects it by itself from then on
+func (n *NATS) connect() error {
+	conn, err := nats.Connect(n.url, nats.Name("whatsapp-api"), nats.Timeout(10*time.Second))
+	if err != nil {
+		return fmt.Errorf("eventsink: NATS: %w", err)
+	}
+	js, err := jetstream.New(conn, jetstream.WithPublishAsyncTimeout(natsTimeout))
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("eventsink: NATS: %w", err)
+	}
+	n.conn, n.js = conn, js
+	return nil
+}
+
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		return nil
+	}
+	n.conn.Close()
+	n.conn, n.js = nil, nil
+	return nil
+}
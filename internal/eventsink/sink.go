@@ -0,0 +1,96 @@
+// Package eventsink streams events to an external system for analytics
+// pipelines: a Kafka topic (kafka-go), a NATS JetStream stream (nats.go) or
+// an SQS queue (the AWS SDK).
+// Publishing is at least once: a batch that fails is published again as a
+// whole, and every event carries an ID the consumer (or JetStream and SQS
+// FIFO queues, which drop duplicates by it) can deduplicate on.
+package eventsink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sinks
+const (
+	SinkKafka = "kafka"
+	SinkNATS  = "nats"
+	SinkSQS   = "sqs"
+)
+
+// Event is one record sent to a sink
+type Event struct {
+	ID        string      `json:"id"` // unique per record, e.g. "event:42"
+	Type      string      `json:"type"`
+	SessionID string      `json:"session_id"`
+	UserID    int         `json:"user_id"`
+	Data      interface{} `json:"data"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// Sink publishes events to an external system
+type Sink interface {
+	// Publish sends a batch of events in order and returns once the system
+	// has accepted all of them
+	Publish(ctx context.Context, events []Event) error
+	Close() error
+}
+
+// Config selects and configures a sink
+type Config struct {
+	Sink string // kafka, nats or sqs
+
+	// Kafka
+	KafkaBrokers  []string // bootstrap host:port addresses
+	KafkaTopic    string
+	KafkaTLS      bool
+	KafkaUsername string // SASL/PLAIN, empty = no authentication
+	KafkaPassword string
+
+	// NATS JetStream
+	NATSURL     string // nats://[user:pass@]host:port, or tls:// for TLS
+	NATSSubject string // events go to <subject>.<event type>
+
+	// SQS
+	SQSQueueURL        string
+	SQSRegion          string // default: taken from the queue URL
+	SQSAccessKeyID     string
+	SQSSecretAccessKey string
+	SQSSessionToken    string
+}
+
+// New creates the configured sink
+func New(cfg Config) (Sink, error) {
+	switch cfg.Sink {
+	case SinkKafka:
+		return NewKafka(cfg)
+	case SinkNATS:
+		return NewNATS(cfg)
+	case SinkSQS:
+		return NewSQS(cfg)
+	}
+	return nil, fmt.Errorf("eventsink: unknown sink %q (expected kafka, nats or sqs)", cfg.Sink)
+}
+
+// encode is the JSON form of an event all sinks send
+func encode(event Event) ([]byte, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("eventsink: failed to encode event %s: %w", event.ID, err)
+	}
+	return body, nil
+}
+
+// subjectToken makes an event type safe to use in a NATS subject
+func subjectToken(eventType string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, eventType)
+}
@@ -0,0 +1,148 @@
+package eventsink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// SQS sends events to an SQS queue with SendMessageBatch through the AWS
+// SDK. On a FIFO queue the events of a session keep their order (the session
+// is the message group) and the event ID deduplicates retried batches.
+type SQS struct {
+	client   *sqs.Client
+	queueURL string
+	fifo     bool
+}
+
+const (
+	sqsMaxBatchEntries = 10
+	sqsMaxBatchBytes   = 256 * 1024
+)
+
+// NewSQS creates an SQS sink
+func NewSQS(cfg Config) (*SQS, error) {
+	if cfg.SQSQueueURL == "" {
+		return nil, fmt.Errorf("eventsink: SQS queue URL is required")
+	}
+	if cfg.SQSAccessKeyID == "" || cfg.SQSSecretAccessKey == "" {
+		return nil, fmt.Errorf("eventsink: SQS access key ID and secret are required")
+	}
+	parsed, err := url.Parse(cfg.SQSQueueURL)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("eventsink: invalid SQS queue URL %q", cfg.SQSQueueURL)
+	}
+
+	region := cfg.SQSRegion
+	if region == "" {
+		// sqs.<region>.amazonaws.com
+		parts := strings.Split(parsed.Hostname(), ".")
+		if len(parts) < 3 || parts[0] != "sqs" {
+			return nil, fmt.Errorf("eventsink: SQS region is required for queue URL %q", cfg.SQSQueueURL)
+		}
+		region = parts[1]
+	}
+
+	client := sqs.New(sqs.Options{
+		Region: region,
+		Credentials: credentials.NewStaticCredentialsProvider(
+			cfg.SQSAccessKeyID, cfg.SQSSecretAccessKey, cfg.SQSSessionToken),
+		// The queue URL's host, so SQS-compatible services work too
+		BaseEndpoint: aws.String(parsed.Scheme + "://" + parsed.Host),
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+	})
+	return &SQS{
+		client:   client,
+		queueURL: cfg.SQSQueueURL,
+		fifo:     strings.HasSuffix(parsed.Path, ".fifo"),
+	}, nil
+}
+
+// sqsBatches converts events to SendMessageBatch entries, split into batches
+// within SQS's limits of ten entries and 256 KiB
+func sqsBatches(events []Event, fifo bool) ([][]types.SendMessageBatchRequestEntry, error) {
+	var (
+		batches [][]types.SendMessageBatchRequestEntry
+		batch   []types.SendMessageBatchRequestEntry
+		size    int
+	)
+	for _, event := range events {
+		body, err := encode(event)
+		if err != nil {
+			return nil, err
+		}
+		entrySize := len(body) + len(event.Type) + 64
+		if entrySize > sqsMaxBatchBytes {
+			return nil, fmt.Errorf("eventsink: event %s is larger than SQS allows", event.ID)
+		}
+		if len(batch) == sqsMaxBatchEntries || size+entrySize > sqsMaxBatchBytes {
+			batches = append(batches, batch)
+			batch, size = nil, 0
+		}
+
+		entry := types.SendMessageBatchRequestEntry{
+			Id:          aws.String(strconv.Itoa(len(batch))),
+			MessageBody: aws.String(string(body)),
+			MessageAttributes: map[string]types.MessageAttributeValue{
+				"type": {DataType: aws.String("String"), StringValue: aws.String(event.Type)},
+			},
+		}
+		if fifo {
+			group := event.SessionID
+			if group == "" {
+				group = "none"
+			}
+			entry.MessageGroupId = aws.String(group)
+			entry.MessageDeduplicationId = aws.String(event.ID)
+		}
+		batch = append(batch, entry)
+		size += entrySize
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches, nil
+}
+
+func (s *SQS) Publish(ctx context.Context, events []Event) error {
+	batches, err := sqsBatches(events, s.fifo)
+	if err != nil {
+		return err
+	}
+	for _, entries := range batches {
+		if err := s.sendBatch(ctx, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendBatch sends up to ten messages; any failed entry fails the batch
+func (s *SQS) sendBatch(ctx context.Context, entries []types.SendMessageBatchRequestEntry) error {
+	out, err := s.client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(s.queueURL),
+		Entries:  entries,
+	})
+	if err != nil {
+		return fmt.Errorf("eventsink: SQS SendMessageBatch: %w", err)
+	}
+	if len(out.Failed) > 0 {
+		failed := out.Failed[0]
+		return fmt.Errorf("eventsink: SQS rejected %d of %d messages: %s: %s",
+			len(out.Failed), len(entries), aws.ToString(failed.Code), aws.ToString(failed.Message))
+	}
+	return nil
+}
+
+func (s *SQS) Close() error {
+	return nil
+}
@@ -0,0 +1,245 @@
+// Package jobs runs background jobs on a pool of workers. A job is a type
+// with a JSON payload, run by the handler registered for its type; failed
+// runs are retried with a growing delay up to the job's max attempts.
+//
+// The memory backend keeps the queue in process memory: simple, but jobs
+// still queued at a restart are lost. The redis backend runs the queue on
+// asynq, so queued jobs survive restarts and jobs interrupted by one run
+// again while they have attempts left. Each instance has its own asynq
+// queue, since jobs may need the instance's WhatsApp connections.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// Backends
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+const (
+	defaultWorkers     = 10
+	defaultMaxAttempts = 3
+	retryBaseDelay     = 10 * time.Second
+	retryMaxDelay      = 10 * time.Minute
+)
+
+// Job is one unit of background work
+type Job struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempt     int             `json:"attempt"` // runs started, including the current one
+	MaxAttempts int             `json:"max_attempts"`
+	EnqueuedAt  time.Time       `json:"enqueued_at"`
+}
+
+// Decode unmarshals the payload of a job
+func (j *Job) Decode(v interface{}) error {
+	if err := json.Unmarshal(j.Payload, v); err != nil {
+		return SkipRetry(fmt.Errorf("jobs: invalid payload of %s job %s: %w", j.Type, j.ID, err))
+	}
+	return nil
+}
+
+// Handler runs a job. A returned error makes the job run again later unless
+// it was the last attempt or the error is wrapped by SkipRetry; RetryAt
+// schedules the next run without using up an attempt.
+type Handler func(ctx context.Context, job *Job) error
+
+// Option changes a job being enqueued
+type Option func(*enqueueOptions)
+
+type enqueueOptions struct {
+	delay       time.Duration
+	maxAttempts int
+}
+
+// WithDelay runs the job no earlier than d from now
+func WithDelay(d time.Duration) Option {
+	return func(o *enqueueOptions) { o.delay = d }
+}
+
+// WithMaxAttempts limits how often the job runs; 1 means it never runs
+// again, not even after being interrupted by a restart
+func WithMaxAttempts(n int) Option {
+	return func(o *enqueueOptions) { o.maxAttempts = n }
+}
+
+// Queue enqueues jobs and runs them on its workers
+type Queue interface {
+	// Handle registers the handler of a job type; call it before Start
+	Handle(jobType string, handler Handler)
+	// Enqueue adds a job with a payload marshalled to JSON
+	Enqueue(ctx context.Context, jobType string, payload interface{}, opts ...Option) (*Job, error)
+	// Start runs the workers until the context is cancelled
+	Start(ctx context.Context)
+	// Shutdown waits, once the Start context is cancelled, for the running
+	// jobs until the context is done, then cancels their context
+	Shutdown(ctx context.Context) error
+}
+
+// Config selects and configures a backend
+type Config struct {
+	Backend string // memory (default) or redis
+	Workers int    // jobs run at once, default 10
+
+	// OnError is called for each failed run (job.Attempt of job.MaxAttempts)
+	// and, with a nil job, for errors of the backend itself
+	OnError func(job *Job, err error)
+
+	// Redis
+	RedisAddr     string // host:port
+	RedisPassword string
+	RedisDB       int
+	RedisQueue    string // name of this instance's asynq queue
+}
+
+// New creates the configured queue
+func New(cfg Config) (Queue, error) {
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultWorkers
+	}
+	if cfg.OnError == nil {
+		cfg.OnError = func(*Job, error) {}
+	}
+	switch cfg.Backend {
+	case "", BackendMemory:
+		return NewMemory(cfg), nil
+	case BackendRedis:
+		return NewRedis(cfg)
+	}
+	return nil, fmt.Errorf("jobs: unknown backend %q (expected %s or %s)", cfg.Backend, BackendMemory, BackendRedis)
+}
+
+// skipRetryError ends a job without further attempts
+type skipRetryError struct {
+	err error
+}
+
+func (e *skipRetryError) Error() string { return e.err.Error() }
+func (e *skipRetryError) Unwrap() error { return e.err }
+
+// SkipRetry marks a handler error as final
+func SkipRetry(err error) error {
+	return &skipRetryError{err: err}
+}
+
+// retryAtError schedules the next run of a job
+type retryAtError struct {
+	at  time.Time
+	err error
+}
+
+func (e *retryAtError) Error() string { return e.err.Error() }
+func (e *retryAtError) Unwrap() error { return e.err }
+
+// RetryAt makes a job run again at a time (e.g. when a rate limit ends)
+// without counting the run as an attempt
+func RetryAt(at time.Time, err error) error {
+	return &retryAtError{at: at, err: err}
+}
+
+// newJob builds a job to enqueue
+func newJob(jobType string, payload interface{}, opts []Option) (*Job, time.Duration, error) {
+	o := enqueueOptions{maxAttempts: defaultMaxAttempts}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.maxAttempts < 1 {
+		o.maxAttempts = 1
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, 0, fmt.Errorf("jobs: failed to encode %s payload: %w", jobType, err)
+	}
+	id, err := newJobID()
+	if err != nil {
+		return nil, 0, err
+	}
+	return &Job{
+		ID:          id,
+		Type:        jobType,
+		Payload:     data,
+		MaxAttempts: o.maxAttempts,
+		EnqueuedAt:  time.Now(),
+	}, o.delay, nil
+}
+
+// newJobID returns a random job ID
+func newJobID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("jobs: failed to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// execute runs one attempt of a job and returns the handler's error. Failed
+// runs are reported to OnError; runs put off with RetryAt aren't failures.
+func execute(ctx context.Context, cfg *Config, handlers map[string]Handler, job *Job) error {
+	handler, ok := handlers[job.Type]
+	if !ok {
+		err := fmt.Errorf("jobs: no handler for job type %q", job.Type)
+		cfg.OnError(job, err)
+		return SkipRetry(err)
+	}
+
+	err := safeCall(ctx, handler, job)
+	var retryAt *retryAtError
+	if err != nil && !errors.As(err, &retryAt) {
+		cfg.OnError(job, err)
+	}
+	return err
+}
+
+// run runs one attempt of a job. It returns when the job has to run again,
+// zero when it's done (succeeded or given up).
+func run(ctx context.Context, cfg *Config, handlers map[string]Handler, job *Job) time.Time {
+	err := execute(ctx, cfg, handlers, job)
+	if err == nil {
+		return time.Time{}
+	}
+
+	var retryAt *retryAtError
+	if errors.As(err, &retryAt) {
+		job.Attempt--
+		return retryAt.at
+	}
+	var skip *skipRetryError
+	if errors.As(err, &skip) || job.Attempt >= job.MaxAttempts {
+		return time.Time{}
+	}
+	return time.Now().Add(retryDelay(job.Attempt))
+}
+
+// safeCall turns a panicking handler into a failed run
+func safeCall(ctx context.Context, handler Handler, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("jobs: handler panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// retryDelay doubles from retryBaseDelay after each failed attempt
+func retryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempt && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,165 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Memory keeps the queue in process memory; queued and delayed jobs are lost
+// when the process stops
+type Memory struct {
+	cfg      Config
+	handlers map[string]Handler
+
+	mu      sync.Mutex
+	pending []*Job
+	delayed map[string]*time.Timer
+	stopped bool
+	notify  chan struct{}
+
+	// Running jobs get runCtx, cancelled when Shutdown gives up waiting
+	runCtx    context.Context
+	cancelRun context.CancelFunc
+	running   sync.WaitGroup
+}
+
+// NewMemory creates an empty in-memory queue
+func NewMemory(cfg Config) *Memory {
+	runCtx, cancelRun := context.WithCancel(context.Background())
+	return &Memory{
+		cfg:       cfg,
+		handlers:  make(map[string]Handler),
+		delayed:   make(map[string]*time.Timer),
+		notify:    make(chan struct{}, 1),
+		runCtx:    runCtx,
+		cancelRun: cancelRun,
+	}
+}
+
+func (m *Memory) Handle(jobType string, handler Handler) {
+	m.handlers[jobType] = handler
+}
+
+func (m *Memory) Enqueue(ctx context.Context, jobType string, payload interface{}, opts ...Option) (*Job, error) {
+	job, delay, err := newJob(jobType, payload, opts)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return nil, fmt.Errorf("jobs: queue is shut down")
+	}
+	m.schedule(job, time.Now().Add(delay))
+	return job, nil
+}
+
+// schedule queues a job to run at a time; m.mu must be held
+func (m *Memory) schedule(job *Job, at time.Time) {
+	wait := time.Until(at)
+	if wait <= 0 {
+		m.push(job)
+		return
+	}
+	m.delayed[job.ID] = time.AfterFunc(wait, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.delayed[job.ID]; !ok {
+			return // dropped by Shutdown
+		}
+		delete(m.delayed, job.ID)
+		m.push(job)
+	})
+}
+
+// push makes a job ready to run; m.mu must be held
+func (m *Memory) push(job *Job) {
+	m.pending = append(m.pending, job)
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Memory) Start(ctx context.Context) {
+	for i := 0; i < m.cfg.Workers; i++ {
+		m.running.Add(1)
+		go m.work(ctx)
+	}
+}
+
+// work runs ready jobs until the context is cancelled
+func (m *Memory) work(ctx context.Context) {
+	defer m.running.Done()
+	for {
+		m.mu.Lock()
+		var job *Job
+		if len(m.pending) > 0 {
+			job = m.pending[0]
+			m.pending[0] = nil
+			m.pending = m.pending[1:]
+		}
+		more := len(m.pending) > 0
+		m.mu.Unlock()
+
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.notify:
+			}
+			continue
+		}
+		if more {
+			// Wake another worker for the rest
+			select {
+			case m.notify <- struct{}{}:
+			default:
+			}
+		}
+
+		job.Attempt++
+		if next := run(m.runCtx, &m.cfg, m.handlers, job); !next.IsZero() {
+			m.mu.Lock()
+			if !m.stopped {
+				m.schedule(job, next)
+			}
+			m.mu.Unlock()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Shutdown stops accepting jobs, drops the queued ones and waits for the
+// running ones
+func (m *Memory) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.stopped = true
+	dropped := len(m.pending) + len(m.delayed)
+	for id, timer := range m.delayed {
+		timer.Stop()
+		delete(m.delayed, id)
+	}
+	m.pending = nil
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		m.cancelRun()
+		return fmt.Errorf("jobs: running jobs didn't finish: %w", ctx.Err())
+	}
+	if dropped > 0 {
+		return fmt.Errorf("jobs: dropped %d queued job(s)", dropped)
+	}
+	return nil
+}
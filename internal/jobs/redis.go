@@ -0,0 +1,190 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRedisQueue = "jobs"
+	// redisShutdownTimeout is how long Shutdown lets running jobs finish
+	// before asynq cancels them and puts them back in the queue
+	redisShutdownTimeout = 25 * time.Second
+	enqueuedAtHeader     = "enqueued_at"
+	attemptsHeader       = "attempts" // attempts of the job before the task
+)
+
+// Redis runs the queue on asynq. Job IDs are asynq task IDs and a job's
+// attempts are counted by the task's retries. asynq leases running tasks, so
+// jobs interrupted by a restart run again once the lease expires (or are
+// archived on their last attempt). Failed and given-up jobs are archived by
+// asynq, where they can be inspected with its tools.
+type Redis struct {
+	cfg      Config
+	queue    string
+	handlers map[string]Handler
+
+	redis  *redis.Client
+	client *asynq.Client
+	server *asynq.Server
+}
+
+// NewRedis creates a queue on the Redis server of the config; connections
+// are opened when needed
+func NewRedis(cfg Config) (*Redis, error) {
+	if cfg.RedisAddr == "" {
+		return nil, fmt.Errorf("jobs: redis address is required")
+	}
+	queue := cfg.RedisQueue
+	if queue == "" {
+		queue = defaultRedisQueue
+	}
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+	r := &Redis{
+		cfg:      cfg,
+		queue:    queue,
+		handlers: make(map[string]Handler),
+		redis:    rdb,
+		client:   asynq.NewClientFromRedisClient(rdb),
+	}
+	r.server = asynq.NewServerFromRedisClient(rdb, asynq.Config{
+		Concurrency:     cfg.Workers,
+		Queues:          map[string]int{queue: 1},
+		RetryDelayFunc:  retryDelayFunc,
+		IsFailure:       isFailure,
+		ShutdownTimeout: redisShutdownTimeout,
+		LogLevel:        asynq.WarnLevel,
+		HealthCheckFunc: func(err error) {
+			if err != nil {
+				cfg.OnError(nil, fmt.Errorf("jobs: redis: %w", err))
+			}
+		},
+	})
+	return r, nil
+}
+
+// isFailure tells asynq which errors use up an attempt: all but RetryAt
+func isFailure(err error) bool {
+	var retryAt *retryAtError
+	return !errors.As(err, &retryAt)
+}
+
+// retryDelayFunc is when asynq runs a failed task again; n is the number of
+// retries so far
+func retryDelayFunc(n int, err error, task *asynq.Task) time.Duration {
+	var retryAt *retryAtError
+	if errors.As(err, &retryAt) {
+		return max(time.Until(retryAt.at), 0)
+	}
+	return retryDelay(n + 1)
+}
+
+func (r *Redis) Handle(jobType string, handler Handler) {
+	r.handlers[jobType] = handler
+}
+
+func (r *Redis) Enqueue(ctx context.Context, jobType string, payload interface{}, opts ...Option) (*Job, error) {
+	job, delay, err := newJob(jobType, payload, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.enqueue(ctx, job, time.Now().Add(delay)); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// enqueue adds a job as a task running at a time, with the attempts it has
+// left
+func (r *Redis) enqueue(ctx context.Context, job *Job, at time.Time) error {
+	task := asynq.NewTaskWithHeaders(job.Type, job.Payload, map[string]string{
+		enqueuedAtHeader: job.EnqueuedAt.Format(time.RFC3339Nano),
+		attemptsHeader:   strconv.Itoa(job.Attempt),
+	})
+	_, err := r.client.EnqueueContext(ctx, task,
+		asynq.Queue(r.queue),
+		asynq.TaskID(job.ID),
+		asynq.MaxRetry(job.MaxAttempts-job.Attempt-1),
+		asynq.ProcessAt(at))
+	if err != nil {
+		return fmt.Errorf("jobs: failed to enqueue %s job: %w", job.Type, err)
+	}
+	return nil
+}
+
+func (r *Redis) Start(ctx context.Context) {
+	if err := r.server.Start(asynq.HandlerFunc(r.process)); err != nil {
+		r.cfg.OnError(nil, fmt.Errorf("jobs: failed to start workers: %w", err))
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		r.server.Stop() // no new jobs; Shutdown waits for the running ones
+	}()
+}
+
+// process runs a task as an attempt of its job
+func (r *Redis) process(ctx context.Context, task *asynq.Task) error {
+	headers := task.Headers()
+	before, _ := strconv.Atoi(headers[attemptsHeader])
+	retried, _ := asynq.GetRetryCount(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+	job := &Job{
+		Type:        task.Type(),
+		Payload:     task.Payload(),
+		Attempt:     before + retried + 1,
+		MaxAttempts: before + maxRetry + 1,
+	}
+	job.ID, _ = asynq.GetTaskID(ctx)
+	job.EnqueuedAt, _ = time.Parse(time.RFC3339Nano, headers[enqueuedAtHeader])
+
+	err := execute(ctx, &r.cfg, r.handlers, job)
+	var (
+		skip    *skipRetryError
+		retryAt *retryAtError
+	)
+	switch {
+	case errors.As(err, &skip):
+		return fmt.Errorf("%w: %w", err, asynq.SkipRetry)
+	case errors.As(err, &retryAt) && retried >= maxRetry:
+		// asynq archives a task without retries left whatever the error, so
+		// the job goes on as a new task; this run doesn't count
+		next := *job
+		next.Attempt--
+		if next.ID, err = newJobID(); err == nil {
+			err = r.enqueue(ctx, &next, retryAt.at)
+		}
+		if err != nil {
+			r.cfg.OnError(job, err)
+		}
+		return err
+	}
+	return err
+}
+
+// Shutdown waits for the running jobs; jobs still queued stay in Redis
+func (r *Redis) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		r.server.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("jobs: running jobs didn't finish: %w", ctx.Err())
+	}
+	r.client.Close()
+	return r.redis.Close()
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memorySweepInterval is how often keys whose limits have fully recovered are
+// dropped
+const memorySweepInterval = time.Minute
+
+// Memory keeps the limits in process memory; each API instance then enforces
+// its own limits
+type Memory struct {
+	mu        sync.Mutex
+	tats      map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{tats: make(map[string]time.Time), now: time.Now}
+}
+
+func (m *Memory) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) >= memorySweepInterval {
+		for k, tat := range m.tats {
+			if !tat.After(now) {
+				delete(m.tats, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	tat, res := gcra(m.tats[key], now, limit)
+	m.tats[key] = tat
+	return res, nil
+}
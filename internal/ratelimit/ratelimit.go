@@ -0,0 +1,118 @@
+// Package ratelimit implements request rate limits with the generic cell rate
+// algorithm (GCRA): a limit of N requests per minute with a burst of B lets B
+// requests through at once and then one every minute/N. Each key only needs
+// its "theoretical arrival time", so the state fits in one value per key,
+// kept in memory or in Redis when several API instances share the limits.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Stores
+const (
+	StoreMemory = "memory"
+	StoreRedis  = "redis"
+)
+
+// Limit is a rate: PerMinute requests per minute with bursts of up to Burst
+type Limit struct {
+	PerMinute int
+	Burst     int
+}
+
+// Enabled reports whether the limit restricts anything
+func (l Limit) Enabled() bool {
+	return l.PerMinute > 0
+}
+
+// interval is the time one request "costs"
+func (l Limit) interval() time.Duration {
+	return time.Minute / time.Duration(l.PerMinute)
+}
+
+// capacity is the number of requests allowed at once
+func (l Limit) capacity() int {
+	if l.Burst < 1 {
+		return 1
+	}
+	return l.Burst
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/min (burst %d)", l.PerMinute, l.capacity())
+}
+
+// Result is the outcome of taking one request from a limit
+type Result struct {
+	Allowed    bool
+	Limit      int           // burst capacity
+	Remaining  int           // requests that would be allowed right now
+	Reset      time.Duration // until the full burst is available again
+	RetryAfter time.Duration // until the next request is allowed (denied requests)
+}
+
+// Store keeps the state of the limits
+type Store interface {
+	// Take counts one request against key and reports whether it is allowed
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// Config selects and configures a store
+type Config struct {
+	Store string // memory (default) or redis
+
+	// Redis
+	RedisAddr     string // host:port
+	RedisPassword string
+	RedisDB       int
+}
+
+// New creates the configured store
+func New(cfg Config) (Store, error) {
+	switch cfg.Store {
+	case "", StoreMemory:
+		return NewMemory(), nil
+	case StoreRedis:
+		return NewRedis(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB), nil
+	}
+	return nil, fmt.Errorf("ratelimit: unknown store %q (expected %s or %s)", cfg.Store, StoreMemory, StoreRedis)
+}
+
+// gcra applies a request at now to a key whose arrival time is tat (zero for
+// unseen keys). It returns the new arrival time to store, which is tat itself
+// when the request is denied.
+func gcra(tat, now time.Time, limit Limit) (time.Time, Result) {
+	interval := limit.interval()
+	tolerance := interval * time.Duration(limit.capacity())
+
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(interval)
+	ahead := next.Sub(now)
+
+	if ahead > tolerance {
+		return tat, result(limit, false, tat.Sub(now), ahead-tolerance)
+	}
+	return next, result(limit, true, ahead, 0)
+}
+
+// result builds the Result of a limit whose arrival time is ahead of now
+func result(limit Limit, allowed bool, ahead, retryAfter time.Duration) Result {
+	interval := limit.interval()
+	capacity := limit.capacity()
+	remaining := int((interval*time.Duration(capacity) - ahead) / interval)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return Result{
+		Allowed:    allowed,
+		Limit:      capacity,
+		Remaining:  remaining,
+		Reset:      ahead,
+		RetryAfter: retryAfter,
+	}
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// gcraScript applies one request to the arrival time stored at KEYS[1]
+// (milliseconds). ARGV: now, interval, tolerance (ms). Returns {allowed,
+// arrival time - now} with the arrival time after the request.
+const gcraScript = `
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local tolerance = tonumber(ARGV[3])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then tat = now end
+local nxt = tat + interval
+if nxt - now > tolerance then
+	return {0, tat - now}
+end
+redis.call('SET', KEYS[1], nxt, 'PX', nxt - now)
+return {1, nxt - now}
+`
+
+const redisTimeout = 2 * time.Second
+
+var gcraRedisScript = redis.NewScript(gcraScript)
+
+// Redis keeps the limits in Redis so API instances share them, running the
+// rate-limit script through go-redis
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis creates a store on the Redis server at addr; connections are
+// opened when needed
+func NewRedis(addr, password string, db int) *Redis {
+	return &Redis{client: redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     password,
+		DB:           db,
+		DialTimeout:  redisTimeout,
+		ReadTimeout:  redisTimeout,
+		WriteTimeout: redisTimeout,
+	})}
+}
+
+func (r *Redis) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	interval := limit.interval()
+	if interval < time.Millisecond {
+		interval = time.Millisecond // the script works in milliseconds
+	}
+	tolerance := interval * time.Duration(limit.capacity())
+
+	values, err := gcraRedisScript.Run(ctx, r.client, []string{"ratelimit:" + key},
+		time.Now().UnixMilli(), interval.Milliseconds(), tolerance.Milliseconds()).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("ratelimit: redis: %w", err)
+	}
+	if len(values) != 2 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected redis reply %v", values)
+	}
+	ahead := time.Duration(values[1]) * time.Millisecond
+
+	if values[0] == 1 {
+		return result(limit, true, ahead, 0), nil
+	}
+	return result(limit, false, ahead, ahead+interval-tolerance), nil
+}
@@ -0,0 +1,294 @@
+package storecrypt
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ============= DRIVER WRAPPER =============
+// Inserts into an encrypted table get their sensitive arguments encrypted;
+// rows read from one get their sensitive columns decrypted. Queries are
+// matched by shape, which covers every write whatsmeow makes to these
+// columns: INSERT INTO <table> (<columns>) VALUES ($n, ...), possibly with
+// ON CONFLICT. INSERT ... SELECT copies values within a table and is left
+// alone.
+
+var (
+	insertPattern = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+(\w+)\s*\(([^)]*)\)\s*VALUES\s*\(([^)]*)\)`)
+	fromPattern   = regexp.MustCompile(`(?is)\bFROM\s+(\w+)`)
+)
+
+// insertPlan maps argument ordinals to the encrypted columns they fill
+type insertPlan struct {
+	table   string
+	columns map[int]string
+}
+
+// NewConnector wraps a driver so connections opened with dsn encrypt and
+// decrypt with c
+func NewConnector(inner driver.Driver, dsn string, c *Cipher) driver.Connector {
+	return &connector{driver: &Driver{inner: inner, cipher: c}, dsn: dsn}
+}
+
+// Driver is an encrypting wrapper around another driver
+type Driver struct {
+	inner  driver.Driver
+	cipher *Cipher
+
+	plans sync.Map // query -> *insertPlan (nil for other statements)
+}
+
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	inner, err := d.inner.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: inner, driver: d}, nil
+}
+
+type connector struct {
+	driver *Driver
+	dsn    string
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// plan returns the insert plan of a query, or nil when it writes nothing encrypted
+func (d *Driver) plan(query string) *insertPlan {
+	if cached, ok := d.plans.Load(query); ok {
+		return cached.(*insertPlan)
+	}
+
+	var plan *insertPlan
+	if match := insertPattern.FindStringSubmatch(query); match != nil {
+		if encrypted, ok := columns[match[1]]; ok {
+			names := strings.Split(match[2], ",")
+			values := strings.Split(match[3], ",")
+			plan = &insertPlan{table: match[1], columns: make(map[int]string)}
+			for i, name := range names {
+				name = strings.TrimSpace(name)
+				if encrypted[name] == 0 || i >= len(values) {
+					continue
+				}
+				value := strings.TrimSpace(values[i])
+				if len(value) < 2 || (value[0] != '$' && value[0] != '?') {
+					continue
+				}
+				if ordinal, err := strconv.Atoi(value[1:]); err == nil {
+					plan.columns[ordinal] = name
+				}
+			}
+			if len(plan.columns) == 0 {
+				plan = nil
+			}
+		}
+	}
+	d.plans.Store(query, plan)
+	return plan
+}
+
+// encryptArgs encrypts the arguments filling encrypted columns
+func (d *Driver) encryptArgs(query string, args []driver.NamedValue) ([]driver.NamedValue, error) {
+	plan := d.plan(query)
+	if plan == nil {
+		return args, nil
+	}
+
+	out := make([]driver.NamedValue, len(args))
+	copy(out, args)
+	for i, arg := range out {
+		column, ok := plan.columns[arg.Ordinal]
+		if !ok {
+			continue
+		}
+		value, ok := arg.Value.([]byte)
+		if !ok || value == nil {
+			continue
+		}
+		encrypted, err := d.cipher.encrypt(plan.table, column, value)
+		if err != nil {
+			return nil, err
+		}
+		out[i].Value = encrypted
+	}
+	return out, nil
+}
+
+// wrapRows decrypts the encrypted columns of rows read from an encrypted table
+func (d *Driver) wrapRows(query string, inner driver.Rows) driver.Rows {
+	match := fromPattern.FindStringSubmatch(query)
+	if match == nil {
+		return inner
+	}
+	encrypted, ok := columns[match[1]]
+	if !ok {
+		return inner
+	}
+
+	decrypt := make(map[int]string)
+	for i, name := range inner.Columns() {
+		if encrypted[name] != 0 {
+			decrypt[i] = name
+		}
+	}
+	if len(decrypt) == 0 {
+		return inner
+	}
+	return &rows{Rows: inner, driver: d, table: match[1], decrypt: decrypt}
+}
+
+type conn struct {
+	driver.Conn
+	driver *Driver
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var inner driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		inner, err = preparer.PrepareContext(ctx, query)
+	} else {
+		inner, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: inner, driver: c.driver, query: query}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	args, err := c.driver.encryptArgs(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	args, err := c.driver.encryptArgs(query, args)
+	if err != nil {
+		return nil, err
+	}
+	inner, err := queryer.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return c.driver.wrapRows(query, inner), nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+type stmt struct {
+	driver.Stmt
+	driver *Driver
+	query  string
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	args, err := s.driver.encryptArgs(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return execer.ExecContext(ctx, args)
+	}
+	return s.Stmt.Exec(values(args))
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	args, err := s.driver.encryptArgs(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	var inner driver.Rows
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		inner, err = queryer.QueryContext(ctx, args)
+	} else {
+		inner, err = s.Stmt.Query(values(args))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.driver.wrapRows(s.query, inner), nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	out := make([]driver.Value, len(args))
+	for i, arg := range args {
+		out[i] = arg.Value
+	}
+	return out
+}
+
+type rows struct {
+	driver.Rows
+	driver  *Driver
+	table   string
+	decrypt map[int]string // column index -> name
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+	for i, column := range r.decrypt {
+		value, ok := dest[i].([]byte)
+		if !ok || value == nil {
+			continue
+		}
+		decrypted, err := r.driver.cipher.decrypt(r.table, column, value)
+		if err != nil {
+			return err
+		}
+		dest[i] = decrypted
+	}
+	return nil
+}
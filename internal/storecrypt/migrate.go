@@ -0,0 +1,213 @@
+package storecrypt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ============= STORE STATE AND MIGRATION =============
+// The store_encryption table holds a single row with the ID of the key the
+// fixed-length columns are encrypted with. No row means a plaintext store.
+// These functions take the unwrapped database, so they see raw column values.
+
+const createMarkerTable = `
+	CREATE TABLE IF NOT EXISTS store_encryption (
+		id         INTEGER PRIMARY KEY CHECK ( id = 1 ),
+		key_id     TEXT   NOT NULL,
+		updated_at BIGINT NOT NULL
+	)
+`
+
+// queryer is what the state helpers need from *sql.DB and *sql.Tx
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// Load reads the encryption state of a store and returns the cipher to wrap
+// its driver with, or nil when the store is plaintext and no keys are
+// configured. A store without devices is marked as encrypted with the primary
+// key right away; one holding plaintext devices returns ErrNotMigrated.
+func Load(ctx context.Context, db *sql.DB, keyring *Keyring) (*Cipher, error) {
+	keyID, err := storeKeyID(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case keyID == "" && keyring == nil:
+		return nil, nil
+	case keyring == nil:
+		return nil, ErrKeyRequired
+	case keyID == "":
+		devices, err := countRows(ctx, db, "whatsmeow_device")
+		if err != nil {
+			return nil, err
+		}
+		if devices > 0 {
+			return nil, ErrNotMigrated
+		}
+		if err := setStoreKeyID(ctx, db, keyring.primary); err != nil {
+			return nil, err
+		}
+		keyID = keyring.primary
+	case keyring.keys[keyID] == nil:
+		return nil, fmt.Errorf("%w: the store is encrypted with key %q", ErrUnknownKey, keyID)
+	}
+	return &Cipher{keyring: keyring, storeKeyID: keyID}, nil
+}
+
+// Migrate rewrites every encrypted column of a store in one transaction:
+// plaintext and values under older keys are re-encrypted with the primary key,
+// or, with decrypt set, everything is decrypted and the store marked
+// plaintext. It returns the number of values rewritten. The API must not be
+// running against the store meanwhile.
+func Migrate(ctx context.Context, db *sql.DB, keyring *Keyring, decrypt bool) (int, error) {
+	if keyring == nil {
+		return 0, ErrKeyRequired
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	keyID, err := storeKeyID(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	if keyID != "" && keyring.keys[keyID] == nil {
+		return 0, fmt.Errorf("%w: the store is encrypted with key %q", ErrUnknownKey, keyID)
+	}
+
+	from := &Cipher{keyring: keyring, storeKeyID: keyID}
+	to := &Cipher{keyring: keyring, storeKeyID: keyring.primary}
+
+	tables := make([]string, 0, len(columns))
+	for table := range columns {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	rewritten := 0
+	for _, table := range tables {
+		exists, err := tableExists(ctx, tx, table)
+		if err != nil {
+			return 0, err
+		} else if !exists {
+			continue
+		}
+		for column := range columns[table] {
+			n, err := migrateColumn(ctx, tx, table, column, from, to, decrypt)
+			if err != nil {
+				return 0, err
+			}
+			rewritten += n
+		}
+	}
+
+	if decrypt {
+		_, err = tx.ExecContext(ctx, "DELETE FROM store_encryption")
+	} else {
+		err = setStoreKeyID(ctx, tx, keyring.primary)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return rewritten, tx.Commit()
+}
+
+// migrateColumn decrypts a column with from and stores it encrypted with to
+// (or in plaintext)
+func migrateColumn(ctx context.Context, tx *sql.Tx, table, column string, from, to *Cipher, decrypt bool) (int, error) {
+	type row struct {
+		rowID int64
+		value []byte
+	}
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT rowid, "%s" FROM %s WHERE "%s" IS NOT NULL`, column, table, column))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s.%s: %w", table, column, err)
+	}
+	var values []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.rowID, &r.value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		values = append(values, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// A plaintext store has nothing to decrypt in its fixed-length columns
+	plaintextFixed := from.storeKeyID == "" && columns[table][column] == fixed
+
+	for _, r := range values {
+		value := r.value
+		if !plaintextFixed {
+			if value, err = from.decrypt(table, column, value); err != nil {
+				return 0, fmt.Errorf("row %d: %w", r.rowID, err)
+			}
+		}
+		if !decrypt {
+			if value, err = to.encrypt(table, column, value); err != nil {
+				return 0, fmt.Errorf("row %d: %w", r.rowID, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET "%s" = ? WHERE rowid = ?`, table, column), value, r.rowID); err != nil {
+			return 0, fmt.Errorf("failed to update %s.%s: %w", table, column, err)
+		}
+	}
+	return len(values), nil
+}
+
+// storeKeyID returns the key recorded in the marker table ("" = plaintext)
+func storeKeyID(ctx context.Context, db queryer) (string, error) {
+	exists, err := tableExists(ctx, db, "store_encryption")
+	if err != nil || !exists {
+		return "", err
+	}
+	var keyID string
+	err = db.QueryRowContext(ctx, "SELECT key_id FROM store_encryption WHERE id = 1").Scan(&keyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return keyID, err
+}
+
+func setStoreKeyID(ctx context.Context, db queryer, keyID string) error {
+	if _, err := db.ExecContext(ctx, createMarkerTable); err != nil {
+		return fmt.Errorf("failed to create store_encryption: %w", err)
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO store_encryption (id, key_id, updated_at) VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET key_id=excluded.key_id, updated_at=excluded.updated_at
+	`, keyID, time.Now().Unix())
+	return err
+}
+
+func tableExists(ctx context.Context, db queryer, table string) (bool, error) {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count)
+	return count > 0, err
+}
+
+func countRows(ctx context.Context, db queryer, table string) (int, error) {
+	exists, err := tableExists(ctx, db, table)
+	if err != nil || !exists {
+		return 0, err
+	}
+	var count int
+	err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,259 @@
+// Package storecrypt encrypts the secret key material whatsmeow keeps in its
+// SQL store (identity, noise and pre-keys, Signal sessions, sender keys,
+// app state keys, message secrets). It wraps the database/sql driver, so
+// whatsmeow's queries are unchanged: sensitive values are encrypted as they
+// are inserted and decrypted as they are read back.
+//
+// Two formats are used. Most columns get an AES-256-GCM envelope that names
+// the key it was sealed with. The schema pins the device and pre-key columns
+// to exactly 32 bytes, so those are encrypted length-preserving (AES-CBC
+// without padding and a fixed per-column IV); they hold uniformly random keys,
+// which makes the deterministic encryption safe, but they carry no
+// authentication tag. Which key the fixed-length columns use is recorded in
+// the store_encryption table; Migrate re-encrypts a whole store with the
+// primary key (or decrypts it) in one transaction.
+package storecrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrKeyRequired is returned when the store is encrypted but no keys are configured
+	ErrKeyRequired = errors.New("storecrypt: the store is encrypted but no encryption keys are configured")
+	// ErrNotMigrated is returned when keys are configured for a store that still holds plaintext keys
+	ErrNotMigrated = errors.New("storecrypt: the store holds unencrypted keys and must be migrated first")
+	// ErrUnknownKey is returned for data sealed with a key that isn't configured
+	ErrUnknownKey = errors.New("storecrypt: unknown encryption key")
+)
+
+// kind is how a column is encrypted
+type kind int
+
+const (
+	envelope kind = iota + 1 // AES-GCM, self-describing
+	fixed                    // length-preserving, key from the store marker
+)
+
+// columns lists the encrypted columns of each whatsmeow table
+var columns = map[string]map[string]kind{
+	"whatsmeow_device": {
+		"noise_key":      fixed,
+		"identity_key":   fixed,
+		"signed_pre_key": fixed,
+		"adv_key":        envelope,
+	},
+	"whatsmeow_pre_keys":            {"key": fixed},
+	"whatsmeow_sessions":            {"session": envelope},
+	"whatsmeow_sender_keys":         {"sender_key": envelope},
+	"whatsmeow_app_state_sync_keys": {"key_data": envelope},
+	"whatsmeow_message_secrets":     {"key": envelope},
+}
+
+// envelopeMagic starts every envelope: magic, key ID length, key ID, nonce, sealed data
+var envelopeMagic = []byte("\x00waenc\x01")
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Keyring holds the master keys by ID. The primary key encrypts new data; the
+// others only decrypt, so a store can be read while it is being rotated.
+type Keyring struct {
+	primary string
+	keys    map[string]*masterKey
+}
+
+type masterKey struct {
+	envelope cipher.AEAD
+	fixed    cipher.Block
+	ivKey    []byte
+}
+
+// ParseKeyring parses "id:base64key[,id:base64key...]"; the first key is the
+// primary one. Keys are 32 random bytes (openssl rand -base64 32). An empty
+// spec returns nil: encryption is off.
+func ParseKeyring(spec string) (*Keyring, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	keyring := &Keyring{keys: make(map[string]*masterKey)}
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("storecrypt: invalid key entry %q (expected id:base64key)", entry)
+		}
+		if _, exists := keyring.keys[id]; exists {
+			return nil, fmt.Errorf("storecrypt: duplicate key ID %q", id)
+		}
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(raw) != 32 {
+			return nil, fmt.Errorf("storecrypt: key %q must be 32 bytes, base64 encoded", id)
+		}
+		key, err := newMasterKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		keyring.keys[id] = key
+		if keyring.primary == "" {
+			keyring.primary = id
+		}
+	}
+	return keyring, nil
+}
+
+// Primary is the ID of the key new data is encrypted with
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// newMasterKey derives the envelope, fixed-length and IV keys of a master key
+func newMasterKey(raw []byte) (*masterKey, error) {
+	block, err := aes.NewCipher(derive(raw, "envelope"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	fixedBlock, err := aes.NewCipher(derive(raw, "fixed"))
+	if err != nil {
+		return nil, err
+	}
+	return &masterKey{envelope: aead, fixed: fixedBlock, ivKey: derive(raw, "iv")}, nil
+}
+
+func derive(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("whatsapp-api storecrypt " + purpose))
+	return mac.Sum(nil)
+}
+
+// seal wraps plaintext in an envelope under the primary key; the column is
+// authenticated so values can't be moved between columns
+func (k *Keyring) seal(column string, plaintext []byte) ([]byte, error) {
+	key := k.keys[k.primary]
+	nonce := make([]byte, key.envelope.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(envelopeMagic)+1+len(k.primary)+len(nonce)+len(plaintext)+key.envelope.Overhead())
+	out = append(out, envelopeMagic...)
+	out = append(out, byte(len(k.primary)))
+	out = append(out, k.primary...)
+	out = append(out, nonce...)
+	return key.envelope.Seal(out, nonce, plaintext, []byte(column)), nil
+}
+
+// open unwraps an envelope; values without the envelope header are returned
+// as they are (written before encryption was enabled)
+func (k *Keyring) open(column string, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, envelopeMagic) {
+		return value, nil
+	}
+	rest := value[len(envelopeMagic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+		return nil, fmt.Errorf("storecrypt: truncated envelope in %s", column)
+	}
+	id := string(rest[1 : 1+rest[0]])
+	rest = rest[1+rest[0]:]
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q in %s", ErrUnknownKey, id, column)
+	}
+	if len(rest) < key.envelope.NonceSize() {
+		return nil, fmt.Errorf("storecrypt: truncated envelope in %s", column)
+	}
+	plaintext, err := key.envelope.Open(nil, rest[:key.envelope.NonceSize()], rest[key.envelope.NonceSize():], []byte(column))
+	if err != nil {
+		return nil, fmt.Errorf("storecrypt: failed to decrypt %s: %w", column, err)
+	}
+	return plaintext, nil
+}
+
+// encryptFixed and decryptFixed apply the length-preserving cipher of a key
+func (k *Keyring) encryptFixed(keyID, column string, value []byte) ([]byte, error) {
+	key, iv, err := k.fixedKey(keyID, column, value)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(value))
+	cipher.NewCBCEncrypter(key.fixed, iv).CryptBlocks(out, value)
+	return out, nil
+}
+
+func (k *Keyring) decryptFixed(keyID, column string, value []byte) ([]byte, error) {
+	key, iv, err := k.fixedKey(keyID, column, value)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(value))
+	cipher.NewCBCDecrypter(key.fixed, iv).CryptBlocks(out, value)
+	return out, nil
+}
+
+func (k *Keyring) fixedKey(keyID, column string, value []byte) (*masterKey, []byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	if len(value)%aes.BlockSize != 0 {
+		return nil, nil, fmt.Errorf("storecrypt: %s is %d bytes, not a multiple of %d", column, len(value), aes.BlockSize)
+	}
+	mac := hmac.New(sha256.New, key.ivKey)
+	mac.Write([]byte(column))
+	return key, mac.Sum(nil)[:aes.BlockSize], nil
+}
+
+// Cipher encrypts and decrypts the values of one store
+type Cipher struct {
+	keyring    *Keyring
+	storeKeyID string // key of the fixed-length columns, from the store marker
+}
+
+// RotationPending reports whether the store still uses a key other than the
+// primary one for its fixed-length columns
+func (c *Cipher) RotationPending() bool {
+	return c.storeKeyID != c.keyring.primary
+}
+
+// StoreKeyID is the key the store's fixed-length columns are encrypted with
+func (c *Cipher) StoreKeyID() string {
+	return c.storeKeyID
+}
+
+// encrypt and decrypt convert one value of table.column
+func (c *Cipher) encrypt(table, column string, value []byte) ([]byte, error) {
+	name := table + "." + column
+	switch columns[table][column] {
+	case envelope:
+		return c.keyring.seal(name, value)
+	case fixed:
+		return c.keyring.encryptFixed(c.storeKeyID, name, value)
+	}
+	return value, nil
+}
+
+func (c *Cipher) decrypt(table, column string, value []byte) ([]byte, error) {
+	name := table + "." + column
+	switch columns[table][column] {
+	case envelope:
+		return c.keyring.open(name, value)
+	case fixed:
+		return c.keyring.decryptFixed(c.storeKeyID, name, value)
+	}
+	return value, nil
+}
@@ -0,0 +1,633 @@
+// Package wafake is an in-memory stand-in for the WhatsApp connection of a
+// session, for tests that run the services without WhatsApp. A Client keeps
+// the state the services read back (connection, groups, registered numbers),
+// records every call and sent message, and returns the error set in Errors
+// for a method instead of doing the call. Events are delivered to the
+// registered handlers with Dispatch.
+package wafake
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/proto/waCommon"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/proto/waWeb"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// SentMessage is a message passed to SendMessage
+type SentMessage struct {
+	To      types.JID
+	ID      types.MessageID
+	Message *waE2E.Message
+}
+
+// Client is a fake WhatsApp connection; the zero value is disconnected and
+// knows no numbers or groups. Set the exported fields before handing the
+// client to the services and don't touch them afterwards.
+type Client struct {
+	OwnJID     types.JID                                     // sender of sent messages
+	LoggedIn   bool                                          // IsLoggedIn; Logout clears it
+	OnWhatsApp map[string]types.JID                          // registered numbers (digits only) and their JID
+	Groups     map[types.JID]*types.GroupInfo                // joined groups
+	Invites    map[string]types.JID                          // invite codes and their group
+	Media      map[string][]byte                             // downloadable media by direct path
+	Errors     map[string]error                              // method name -> error it returns
+	Users      map[types.JID]types.UserInfo                  // GetUserInfo answers
+	Requests   map[types.JID][]types.GroupParticipantRequest // pending join requests
+	Blocklist  []types.JID                                   // GetBlocklist answer
+	Privacy    types.PrivacySettings                         // initial privacy settings
+
+	mu        sync.Mutex
+	connected bool
+	handlers  []whatsmeow.EventHandler
+	nextID    int
+	calls     []string
+	sent      []SentMessage
+	privacy   *types.PrivacySettings // changed by SetPrivacySetting
+}
+
+// call records a method call and returns the error set for it
+func (c *Client) call(method string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, method)
+	return c.Errors[method]
+}
+
+// Calls returns the names of the methods called so far, in order
+func (c *Client) Calls() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+// Sent returns the messages sent so far, in order
+func (c *Client) Sent() []SentMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]SentMessage(nil), c.sent...)
+}
+
+// Dispatch delivers an event to the registered handlers, like whatsmeow does
+// for events from the server
+func (c *Client) Dispatch(evt interface{}) {
+	c.mu.Lock()
+	handlers := append([]whatsmeow.EventHandler(nil), c.handlers...)
+	c.mu.Unlock()
+	for _, handler := range handlers {
+		handler(evt)
+	}
+}
+
+// ============= CONNECTION =============
+
+func (c *Client) Connect() error {
+	if err := c.call("Connect"); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.connected = true
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) Disconnect() {
+	c.call("Disconnect")
+	c.mu.Lock()
+	c.connected = false
+	c.mu.Unlock()
+}
+
+func (c *Client) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+func (c *Client) IsLoggedIn() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected && c.LoggedIn
+}
+
+func (c *Client) Logout(ctx context.Context) error {
+	if err := c.call("Logout"); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.connected, c.LoggedIn = false, false
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) AddEventHandler(handler whatsmeow.EventHandler) uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers = append(c.handlers, handler)
+	return uint32(len(c.handlers))
+}
+
+// ============= MESSAGES =============
+
+func (c *Client) GenerateMessageID() types.MessageID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	return types.MessageID(fmt.Sprintf("FAKE%016X", c.nextID))
+}
+
+func (c *Client) SendMessage(ctx context.Context, to types.JID, message *waE2E.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error) {
+	if err := c.call("SendMessage"); err != nil {
+		return whatsmeow.SendResponse{}, err
+	}
+	if !c.IsConnected() {
+		return whatsmeow.SendResponse{}, whatsmeow.ErrNotConnected
+	}
+
+	var id types.MessageID
+	if len(extra) > 0 {
+		id = extra[0].ID
+	}
+	if id == "" {
+		id = c.GenerateMessageID()
+	}
+
+	c.mu.Lock()
+	c.sent = append(c.sent, SentMessage{To: to, ID: id, Message: message})
+	c.mu.Unlock()
+	return whatsmeow.SendResponse{ID: id, Timestamp: time.Now(), Sender: c.OwnJID}, nil
+}
+
+func (c *Client) BuildEdit(chat types.JID, id types.MessageID, newContent *waE2E.Message) *waE2E.Message {
+	return &waE2E.Message{
+		EditedMessage: &waE2E.FutureProofMessage{
+			Message: &waE2E.Message{
+				ProtocolMessage: &waE2E.ProtocolMessage{
+					Key:           c.BuildMessageKey(chat, types.EmptyJID, id),
+					Type:          waE2E.ProtocolMessage_MESSAGE_EDIT.Enum(),
+					EditedMessage: newContent,
+					TimestampMS:   proto.Int64(time.Now().UnixMilli()),
+				},
+			},
+		},
+	}
+}
+
+func (c *Client) BuildMessageKey(chat, sender types.JID, id types.MessageID) *waCommon.MessageKey {
+	key := &waCommon.MessageKey{
+		FromMe:    proto.Bool(true),
+		ID:        proto.String(id),
+		RemoteJID: proto.String(chat.String()),
+	}
+	if !sender.IsEmpty() && sender.User != c.OwnJID.User {
+		key.FromMe = proto.Bool(false)
+		if chat.Server == types.GroupServer {
+			key.Participant = proto.String(sender.ToNonAD().String())
+		}
+	}
+	return key
+}
+
+func (c *Client) ParseWebMessage(chatJID types.JID, webMsg *waWeb.WebMessageInfo) (*events.Message, error) {
+	if err := c.call("ParseWebMessage"); err != nil {
+		return nil, err
+	}
+	info := types.MessageInfo{
+		MessageSource: types.MessageSource{Chat: chatJID, IsFromMe: webMsg.GetKey().GetFromMe(), IsGroup: chatJID.Server == types.GroupServer},
+		ID:            webMsg.GetKey().GetID(),
+		Timestamp:     time.Unix(int64(webMsg.GetMessageTimestamp()), 0),
+	}
+	if info.IsFromMe {
+		info.Sender = c.OwnJID
+	} else if participant := webMsg.GetKey().GetParticipant(); participant != "" {
+		info.Sender, _ = types.ParseJID(participant)
+	} else {
+		info.Sender = chatJID
+	}
+	return &events.Message{Info: info, Message: webMsg.GetMessage()}, nil
+}
+
+func (c *Client) MarkRead(ctx context.Context, ids []types.MessageID, timestamp time.Time, chat, sender types.JID, receiptTypeExtra ...types.ReceiptType) error {
+	return c.call("MarkRead")
+}
+
+func (c *Client) SendPresence(ctx context.Context, state types.Presence) error {
+	return c.call("SendPresence")
+}
+
+func (c *Client) SubscribePresence(ctx context.Context, jid types.JID) error {
+	return c.call("SubscribePresence")
+}
+
+func (c *Client) SetDisappearingTimer(ctx context.Context, chat types.JID, timer time.Duration, settingTS time.Time) error {
+	return c.call("SetDisappearingTimer")
+}
+
+func (c *Client) SendAppState(ctx context.Context, patch appstate.PatchInfo) error {
+	return c.call("SendAppState")
+}
+
+func (c *Client) RejectCall(ctx context.Context, callFrom types.JID, callID string) error {
+	return c.call("RejectCall")
+}
+
+// ============= MEDIA =============
+
+func (c *Client) Upload(ctx context.Context, plaintext []byte, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	if err := c.call("Upload"); err != nil {
+		return whatsmeow.UploadResponse{}, err
+	}
+	hash := sha256.Sum256(plaintext)
+	directPath := fmt.Sprintf("/fake/%x", hash[:8])
+
+	c.mu.Lock()
+	if c.Media == nil {
+		c.Media = make(map[string][]byte)
+	}
+	c.Media[directPath] = plaintext
+	c.mu.Unlock()
+
+	return whatsmeow.UploadResponse{
+		URL:           "https://mmg.whatsapp.net" + directPath,
+		DirectPath:    directPath,
+		MediaKey:      hash[:],
+		FileEncSHA256: hash[:],
+		FileSHA256:    hash[:],
+		FileLength:    uint64(len(plaintext)),
+	}, nil
+}
+
+func (c *Client) UploadReader(ctx context.Context, plaintext io.Reader, tempFile io.ReadWriteSeeker, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	data, err := io.ReadAll(plaintext)
+	if err != nil {
+		return whatsmeow.UploadResponse{}, err
+	}
+	return c.Upload(ctx, data, appInfo)
+}
+
+func (c *Client) Download(ctx context.Context, msg whatsmeow.DownloadableMessage) ([]byte, error) {
+	if err := c.call("Download"); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.Media[msg.GetDirectPath()]
+	if !ok {
+		return nil, whatsmeow.ErrMediaDownloadFailedWith404
+	}
+	return data, nil
+}
+
+// ============= USERS =============
+
+func (c *Client) IsOnWhatsApp(ctx context.Context, phones []string) ([]types.IsOnWhatsAppResponse, error) {
+	if err := c.call("IsOnWhatsApp"); err != nil {
+		return nil, err
+	}
+	results := make([]types.IsOnWhatsAppResponse, len(phones))
+	for i, phone := range phones {
+		results[i].Query = phone
+		results[i].JID, results[i].IsIn = c.OnWhatsApp[strings.TrimPrefix(phone, "+")]
+	}
+	return results, nil
+}
+
+func (c *Client) GetUserInfo(ctx context.Context, jids []types.JID) (map[types.JID]types.UserInfo, error) {
+	if err := c.call("GetUserInfo"); err != nil {
+		return nil, err
+	}
+	infos := make(map[types.JID]types.UserInfo, len(jids))
+	for _, jid := range jids {
+		if info, ok := c.Users[jid]; ok {
+			infos[jid] = info
+		}
+	}
+	return infos, nil
+}
+
+func (c *Client) GetProfilePictureInfo(ctx context.Context, jid types.JID, params *whatsmeow.GetProfilePictureParams) (*types.ProfilePictureInfo, error) {
+	if err := c.call("GetProfilePictureInfo"); err != nil {
+		return nil, err
+	}
+	return nil, whatsmeow.ErrProfilePictureNotSet
+}
+
+func (c *Client) TryFetchPrivacySettings(ctx context.Context, ignoreCache bool) (*types.PrivacySettings, error) {
+	if err := c.call("TryFetchPrivacySettings"); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	settings := c.Privacy
+	if c.privacy != nil {
+		settings = *c.privacy
+	}
+	return &settings, nil
+}
+
+func (c *Client) SetPrivacySetting(ctx context.Context, name types.PrivacySettingType, value types.PrivacySetting) (types.PrivacySettings, error) {
+	if err := c.call("SetPrivacySetting"); err != nil {
+		return types.PrivacySettings{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.privacy == nil {
+		settings := c.Privacy
+		c.privacy = &settings
+	}
+	switch name {
+	case types.PrivacySettingTypeGroupAdd:
+		c.privacy.GroupAdd = value
+	case types.PrivacySettingTypeLastSeen:
+		c.privacy.LastSeen = value
+	case types.PrivacySettingTypeStatus:
+		c.privacy.Status = value
+	case types.PrivacySettingTypeProfile:
+		c.privacy.Profile = value
+	case types.PrivacySettingTypeReadReceipts:
+		c.privacy.ReadReceipts = value
+	case types.PrivacySettingTypeOnline:
+		c.privacy.Online = value
+	case types.PrivacySettingTypeCallAdd:
+		c.privacy.CallAdd = value
+	default:
+		return types.PrivacySettings{}, fmt.Errorf("unknown privacy setting %q", name)
+	}
+	return *c.privacy, nil
+}
+
+func (c *Client) GetBlocklist(ctx context.Context) (*types.Blocklist, error) {
+	if err := c.call("GetBlocklist"); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &types.Blocklist{JIDs: append([]types.JID(nil), c.Blocklist...)}, nil
+}
+
+func (c *Client) UpdateBlocklist(ctx context.Context, jid types.JID, action events.BlocklistChangeAction) (*types.Blocklist, error) {
+	if err := c.call("UpdateBlocklist"); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	blocklist := c.Blocklist[:0:0]
+	for _, blocked := range c.Blocklist {
+		if blocked != jid {
+			blocklist = append(blocklist, blocked)
+		}
+	}
+	if action == events.BlocklistChangeActionBlock {
+		blocklist = append(blocklist, jid)
+	}
+	c.Blocklist = blocklist
+	return &types.Blocklist{JIDs: append([]types.JID(nil), blocklist...)}, nil
+}
+
+// ============= GROUPS =============
+
+// group returns a joined group; the caller holds c.mu
+func (c *Client) group(jid types.JID) (*types.GroupInfo, error) {
+	group, ok := c.Groups[jid]
+	if !ok {
+		return nil, whatsmeow.ErrGroupNotFound
+	}
+	return group, nil
+}
+
+// updateGroup applies a change to a joined group
+func (c *Client) updateGroup(method string, jid types.JID, update func(group *types.GroupInfo)) error {
+	if err := c.call(method); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	group, err := c.group(jid)
+	if err != nil {
+		return err
+	}
+	update(group)
+	return nil
+}
+
+// CreateGroup creates a group with the own account as its super admin and
+// the requested participants
+func (c *Client) CreateGroup(ctx context.Context, req whatsmeow.ReqCreateGroup) (*types.GroupInfo, error) {
+	if err := c.call("CreateGroup"); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Groups == nil {
+		c.Groups = make(map[types.JID]*types.GroupInfo)
+	}
+	c.nextID++
+	group := &types.GroupInfo{
+		JID:          types.NewJID(fmt.Sprintf("1203630%08d", c.nextID), types.GroupServer),
+		OwnerJID:     c.OwnJID,
+		GroupName:    types.GroupName{Name: req.Name},
+		Participants: []types.GroupParticipant{{JID: c.OwnJID, IsAdmin: true, IsSuperAdmin: true}},
+	}
+	for _, participant := range req.Participants {
+		group.Participants = append(group.Participants, types.GroupParticipant{JID: participant})
+	}
+	c.Groups[group.JID] = group
+	return group, nil
+}
+
+func (c *Client) GetJoinedGroups(ctx context.Context) ([]*types.GroupInfo, error) {
+	if err := c.call("GetJoinedGroups"); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	groups := make([]*types.GroupInfo, 0, len(c.Groups))
+	for _, group := range c.Groups {
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func (c *Client) GetGroupInfo(ctx context.Context, jid types.JID) (*types.GroupInfo, error) {
+	if err := c.call("GetGroupInfo"); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.group(jid)
+}
+
+func (c *Client) GetGroupInfoFromLink(ctx context.Context, code string) (*types.GroupInfo, error) {
+	if err := c.call("GetGroupInfoFromLink"); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	jid, ok := c.Invites[strings.TrimPrefix(code, whatsmeow.InviteLinkPrefix)]
+	if !ok {
+		return nil, whatsmeow.ErrInviteLinkInvalid
+	}
+	return c.group(jid)
+}
+
+func (c *Client) GetGroupInviteLink(ctx context.Context, jid types.JID, reset bool) (string, error) {
+	if err := c.call("GetGroupInviteLink"); err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.group(jid); err != nil {
+		return "", err
+	}
+	for code, group := range c.Invites {
+		if group == jid {
+			if !reset {
+				return whatsmeow.InviteLinkPrefix + code, nil
+			}
+			delete(c.Invites, code)
+		}
+	}
+	if c.Invites == nil {
+		c.Invites = make(map[string]types.JID)
+	}
+	c.nextID++
+	code := fmt.Sprintf("FAKEINVITE%08X", c.nextID)
+	c.Invites[code] = jid
+	return whatsmeow.InviteLinkPrefix + code, nil
+}
+
+func (c *Client) GetGroupRequestParticipants(ctx context.Context, jid types.JID) ([]types.GroupParticipantRequest, error) {
+	if err := c.call("GetGroupRequestParticipants"); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.group(jid); err != nil {
+		return nil, err
+	}
+	return c.Requests[jid], nil
+}
+
+// UpdateGroupParticipants adds, removes, promotes and demotes members;
+// adding an existing member reports error 409 and changing an unknown one
+// 404, like WhatsApp does
+func (c *Client) UpdateGroupParticipants(ctx context.Context, jid types.JID, participantChanges []types.JID, action whatsmeow.ParticipantChange) ([]types.GroupParticipant, error) {
+	if err := c.call("UpdateGroupParticipants"); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	group, err := c.group(jid)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]types.GroupParticipant, len(participantChanges))
+	for i, participant := range participantChanges {
+		results[i] = types.GroupParticipant{JID: participant}
+		index := -1
+		for j, member := range group.Participants {
+			if member.JID.ToNonAD() == participant.ToNonAD() {
+				index = j
+				break
+			}
+		}
+
+		switch {
+		case action == whatsmeow.ParticipantChangeAdd && index >= 0:
+			results[i].Error = 409
+		case action == whatsmeow.ParticipantChangeAdd:
+			group.Participants = append(group.Participants, types.GroupParticipant{JID: participant})
+		case index < 0:
+			results[i].Error = 404
+		case action == whatsmeow.ParticipantChangeRemove:
+			group.Participants = append(group.Participants[:index], group.Participants[index+1:]...)
+		case action == whatsmeow.ParticipantChangePromote:
+			group.Participants[index].IsAdmin = true
+			results[i].IsAdmin = true
+		case action == whatsmeow.ParticipantChangeDemote:
+			group.Participants[index].IsAdmin = false
+		}
+	}
+	return results, nil
+}
+
+// UpdateGroupRequestParticipants approves or rejects pending join requests;
+// approved users become members
+func (c *Client) UpdateGroupRequestParticipants(ctx context.Context, jid types.JID, participantChanges []types.JID, action whatsmeow.ParticipantRequestChange) ([]types.GroupParticipant, error) {
+	if err := c.call("UpdateGroupRequestParticipants"); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	group, err := c.group(jid)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]types.GroupParticipant, len(participantChanges))
+	for i, participant := range participantChanges {
+		results[i] = types.GroupParticipant{JID: participant, Error: 404}
+		requests := c.Requests[jid]
+		for j, request := range requests {
+			if request.JID.ToNonAD() != participant.ToNonAD() {
+				continue
+			}
+			c.Requests[jid] = append(requests[:j], requests[j+1:]...)
+			results[i].Error = 0
+			if action == whatsmeow.ParticipantChangeApprove {
+				group.Participants = append(group.Participants, types.GroupParticipant{JID: participant})
+			}
+			break
+		}
+	}
+	return results, nil
+}
+
+func (c *Client) SetGroupName(ctx context.Context, jid types.JID, name string) error {
+	return c.updateGroup("SetGroupName", jid, func(group *types.GroupInfo) {
+		group.Name, group.NameSetAt, group.NameSetBy = name, time.Now(), c.OwnJID
+	})
+}
+
+func (c *Client) SetGroupTopic(ctx context.Context, jid types.JID, previousID, newID, topic string) error {
+	return c.updateGroup("SetGroupTopic", jid, func(group *types.GroupInfo) {
+		group.Topic, group.TopicID, group.TopicSetAt, group.TopicSetBy = topic, newID, time.Now(), c.OwnJID
+		group.TopicDeleted = topic == ""
+	})
+}
+
+func (c *Client) SetGroupAnnounce(ctx context.Context, jid types.JID, announce bool) error {
+	return c.updateGroup("SetGroupAnnounce", jid, func(group *types.GroupInfo) {
+		group.IsAnnounce = announce
+	})
+}
+
+func (c *Client) SetGroupLocked(ctx context.Context, jid types.JID, locked bool) error {
+	return c.updateGroup("SetGroupLocked", jid, func(group *types.GroupInfo) {
+		group.IsLocked = locked
+	})
+}
+
+func (c *Client) SetGroupMemberAddMode(ctx context.Context, jid types.JID, mode types.GroupMemberAddMode) error {
+	return c.updateGroup("SetGroupMemberAddMode", jid, func(group *types.GroupInfo) {
+		group.MemberAddMode = mode
+	})
+}
+
+func (c *Client) SetGroupJoinApprovalMode(ctx context.Context, jid types.JID, mode bool) error {
+	return c.updateGroup("SetGroupJoinApprovalMode", jid, func(group *types.GroupInfo) {
+		group.IsJoinApprovalRequired = mode
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= JID RESOLUTION CACHE =============
+// IsOnWhatsApp results are cached in memory (LRU) and in the database, so
+// repeated sends and broadcasts to the same numbers don't each cost a
+// round-trip to WhatsApp, and the cache survives restarts.
+
+// dbJIDCache is the database tier of the JID resolution cache
+type dbJIDCache struct {
+	db  *DatabaseManager
+	ttl time.Duration
+}
+
+func (c *dbJIDCache) Get(phone string) (wajid.Lookup, bool) {
+	entry, err := c.db.GetJIDCache(phone, time.Now().Add(-c.ttl))
+	if err != nil {
+		return wajid.Lookup{}, false
+	}
+
+	jid, err := types.ParseJID(entry.JID)
+	if err != nil {
+		return wajid.Lookup{}, false
+	}
+
+	return wajid.Lookup{
+		Phone:      entry.Phone,
+		JID:        jid,
+		Registered: entry.Registered,
+		CheckedAt:  entry.CheckedAt,
+	}, true
+}
+
+func (c *dbJIDCache) Set(phone string, lookup wajid.Lookup) {
+	entry := &WhatsAppJIDCache{
+		Phone:      phone,
+		JID:        lookup.JID.String(),
+		Registered: lookup.Registered,
+		CheckedAt:  lookup.CheckedAt,
+	}
+	if err := c.db.SaveJIDCache(entry); err != nil {
+		log.Printf("⚠️  Failed to cache JID lookup for %s: %v", phone, err)
+	}
+}
+
+// newJIDResolver builds the recipient resolver with a memory + database cache
+func newJIDResolver(cfg *Config, db *DatabaseManager) *wajid.Resolver {
+	if cfg.JIDCacheTTL <= 0 {
+		return wajid.NewResolver(nil)
+	}
+
+	return wajid.NewResolver(wajid.ChainCache{
+		wajid.NewLRUCache(cfg.JIDCacheSize, cfg.JIDCacheTTL),
+		&dbJIDCache{db: db, ttl: cfg.JIDCacheTTL},
+	})
+}
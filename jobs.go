@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"whatsapp-api/internal/jobs"
+)
+
+// ============= BACKGROUND JOBS =============
+// Background work started by a request or an event runs as jobs
+// (internal/jobs) on JOBS_WORKERS workers. JOBS_BACKEND picks durability or
+// simplicity: memory keeps the queue in the process, so queued jobs are lost
+// on a restart; redis keeps it in Redis (the REDIS_* server) as the asynq queue of
+// JOBS_INSTANCE, so queued jobs survive restarts and jobs cut off by one run
+// again while they have attempts left. Jobs act on this instance's WhatsApp
+// connections, which is why each instance has a queue of its own.
+//
+// Group syncs and async broadcast list sends run as jobs. A group sync that
+// hits a rate limit goes back to the queue until the backoff ends. A
+// broadcast list send runs once at most, so a restart never sends it twice.
+
+// Job types
+const (
+	jobGroupSync         = "group_sync"
+	jobBroadcastListSend = "broadcast_list_send"
+)
+
+// newJobQueue creates the job queue of the config
+func newJobQueue(cfg *Config) (jobs.Queue, error) {
+	return jobs.New(jobs.Config{
+		Backend:       cfg.JobsBackend,
+		Workers:       cfg.JobsWorkers,
+		RedisAddr:     cfg.RedisAddr,
+		RedisPassword: cfg.RedisPassword,
+		RedisDB:       cfg.RedisDB,
+		RedisQueue:    "whatsapp-api:jobs:" + cfg.JobsInstance,
+		OnError: func(job *jobs.Job, err error) {
+			if job == nil {
+				log.Printf("❌ Job queue: %v", err)
+				return
+			}
+			log.Printf("❌ %s job %s failed (attempt %d of %d): %v", job.Type, job.ID, job.Attempt, job.MaxAttempts, err)
+		},
+	})
+}
+
+// registerJobs sets up the handlers of all job types
+func (ws *WhatsAppService) registerJobs() {
+	ws.jobs.Handle(jobGroupSync, ws.runGroupSyncJob)
+	ws.jobs.Handle(jobBroadcastListSend, ws.runBroadcastListJob)
+}
+
+// StartJobs runs queued jobs until the context is cancelled
+func (ws *WhatsAppService) StartJobs(ctx context.Context) {
+	ws.jobs.Start(ctx)
+	log.Printf("✅ Job workers started (%s backend, %d workers)", ws.cfg.JobsBackend, ws.cfg.JobsWorkers)
+}
+
+// StopJobs waits for running jobs after the job context was cancelled
+func (ws *WhatsAppService) StopJobs(ctx context.Context) {
+	if err := ws.jobs.Shutdown(ctx); err != nil {
+		log.Printf("⚠️  Job queue shutdown: %v", err)
+	}
+}
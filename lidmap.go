@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// ============= LID MAPPING =============
+// WhatsApp addresses more and more users by LID instead of their phone number
+// JID, so the same person can show up under both. The LID/phone number pairs
+// a session learns (its whatsmeow LID store, message senders, push names and
+// group participants) are mirrored into the LID mapping table, shared by all
+// sessions like the JID cache. Contacts stored under a LID are merged into
+// the phone number contact once its pair is known, and contact and message
+// lookups match either identifier.
+
+// recordLIDPair stores the pair of a LID and a phone number JID given in any
+// order; JIDs that aren't one of each are ignored
+func (ws *WhatsAppService) recordLIDPair(sc *SessionClient, a, b types.JID) {
+	if a.Server == types.DefaultUserServer {
+		a, b = b, a
+	}
+	if a.Server != types.HiddenUserServer || b.Server != types.DefaultUserServer {
+		return
+	}
+	ws.recordLIDMappings(sc, []store.LIDMapping{{LID: a, PN: b}})
+}
+
+// recordLIDMappings stores the pairs not stored yet and merges the contacts
+// stored under their LIDs
+func (ws *WhatsAppService) recordLIDMappings(sc *SessionClient, pairs []store.LIDMapping) {
+	mappings := make([]WhatsAppLIDMapping, 0, len(pairs))
+	for _, pair := range pairs {
+		lid, phone := pair.LID.ToNonAD().String(), pair.PN.ToNonAD().String()
+		if known, ok := ws.lidMappings.Load(lid); ok && known.(string) == phone {
+			continue
+		}
+		mappings = append(mappings, WhatsAppLIDMapping{LID: lid, PhoneJID: phone})
+	}
+	if len(mappings) == 0 {
+		return
+	}
+
+	if err := ws.db.SaveLIDMappings(mappings); err != nil {
+		log.Printf("❌ Failed to save %d LID mapping(s) of session %s: %v", len(mappings), sc.SessionID, err)
+		return
+	}
+	for _, mapping := range mappings {
+		ws.lidMappings.Store(mapping.LID, mapping.PhoneJID)
+	}
+
+	merged, err := ws.db.MergeLIDContacts(mappings)
+	if err != nil {
+		log.Printf("❌ Failed to merge LID contacts of session %s: %v", sc.SessionID, err)
+		return
+	}
+	if merged > 0 {
+		log.Printf("🔗 Merged %d LID contact(s) into their phone number contacts", merged)
+	}
+}
+
+// syncLIDMappings reads the LIDs of the given phone number JIDs and the phone
+// numbers of the user's LID contacts from the session's LID store
+func (ws *WhatsAppService) syncLIDMappings(sc *SessionClient, phones []types.JID) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var pairs []store.LIDMapping
+	if len(phones) > 0 {
+		lids, err := sc.Device.LIDs.GetManyLIDsForPNs(ctx, phones)
+		if err != nil {
+			log.Printf("⚠️  Failed to read LIDs of session %s: %v", sc.SessionID, err)
+		}
+		for phone, lid := range lids {
+			pairs = append(pairs, store.LIDMapping{LID: lid, PN: phone})
+		}
+	}
+
+	lidContacts, err := ws.db.GetLIDContactJIDs(sc.UserID)
+	if err != nil {
+		log.Printf("⚠️  Failed to load LID contacts of user %d: %v", sc.UserID, err)
+	}
+	for _, contact := range lidContacts {
+		lid, err := types.ParseJID(contact)
+		if err != nil {
+			continue
+		}
+		phone, err := sc.Device.LIDs.GetPNForLID(ctx, lid)
+		if err != nil || phone.IsEmpty() {
+			continue
+		}
+		pairs = append(pairs, store.LIDMapping{LID: lid, PN: phone})
+	}
+
+	ws.recordLIDMappings(sc, pairs)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= LIVE LOCATION SHARING =============
+// A live location share starts with a LiveLocationMessage whose context
+// carries the share's duration (expiration, in seconds). It is kept alive by
+// sending a new LiveLocationMessage with the latest known coordinates and the
+// next sequence number every interval; edits of the first message would stop
+// working once WhatsApp's 20 minute edit window closes. Stopping sends a last
+// message whose duration ends at that moment. Every message goes through
+// sendMessage, so updates are paced and counted like any other send, and the
+// sequence only moves on once a message was sent.
+
+const (
+	DefaultLiveLocationDuration = 15 * time.Minute
+	MaxLiveLocationDuration     = 8 * time.Hour
+	DefaultLiveLocationInterval = 60 * time.Second
+	MinLiveLocationInterval     = 10 * time.Second
+)
+
+// LiveLocationShare is an active live location share
+type LiveLocationShare struct {
+	ShareID        string    `json:"share_id"`
+	SessionID      string    `json:"session_id"`
+	UserID         int       `json:"-"`
+	To             string    `json:"to"`
+	Latitude       float64   `json:"latitude"`
+	Longitude      float64   `json:"longitude"`
+	AccuracyMeters uint32    `json:"accuracy_meters,omitempty"`
+	Caption        string    `json:"caption,omitempty"`
+	Sequence       int64     `json:"sequence"`
+	StartedAt      time.Time `json:"started_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	UpdateInterval string    `json:"update_interval"`
+
+	recipient types.JID
+	interval  time.Duration
+	stopChan  chan struct{}
+	stopOnce  sync.Once
+	mu        sync.Mutex
+	sendMu    sync.Mutex // one message of the share at a time
+}
+
+// buildMessage builds the live location payload for the current position
+// with a sequence number; live is how long the share lasts from its start
+func (share *LiveLocationShare) buildMessage(sequence int64, live time.Duration) *waE2E.Message {
+	share.mu.Lock()
+	defer share.mu.Unlock()
+
+	liveMsg := &waE2E.LiveLocationMessage{
+		DegreesLatitude:  proto.Float64(share.Latitude),
+		DegreesLongitude: proto.Float64(share.Longitude),
+		SequenceNumber:   proto.Int64(sequence),
+		TimeOffset:       proto.Uint32(uint32(time.Since(share.StartedAt).Seconds())),
+		ContextInfo: &waE2E.ContextInfo{
+			Expiration: proto.Uint32(uint32(live.Seconds())),
+		},
+	}
+	if share.AccuracyMeters > 0 {
+		liveMsg.AccuracyInMeters = proto.Uint32(share.AccuracyMeters)
+	}
+	if share.Caption != "" {
+		liveMsg.Caption = proto.String(share.Caption)
+	}
+
+	return &waE2E.Message{
+		LiveLocationMessage: liveMsg,
+	}
+}
+
+// StartLiveLocation starts sharing a live location with a recipient
+func (ws *WhatsAppService) StartLiveLocation(sessionID string, userID int, to string, latitude, longitude float64, accuracy uint32, caption string, duration, interval time.Duration) (*LiveLocationShare, error) {
+	if err := validateCoordinates(latitude, longitude); err != nil {
+		return nil, err
+	}
+
+	if duration <= 0 {
+		duration = DefaultLiveLocationDuration
+	}
+	if duration > MaxLiveLocationDuration {
+		return nil, fmt.Errorf("duration exceeds maximum of %v", MaxLiveLocationDuration)
+	}
+	if interval <= 0 {
+		interval = DefaultLiveLocationInterval
+	}
+	if interval < MinLiveLocationInterval {
+		interval = MinLiveLocationInterval
+	}
+
+	sc, err := ws.getConnectedClient(sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient, err := ws.validateAndGetRecipient(sc, to)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	share := &LiveLocationShare{
+		SessionID:      sessionID,
+		UserID:         userID,
+		To:             recipient.String(),
+		Latitude:       latitude,
+		Longitude:      longitude,
+		AccuracyMeters: accuracy,
+		Caption:        caption,
+		StartedAt:      now,
+		ExpiresAt:      now.Add(duration),
+		UpdateInterval: interval.String(),
+		recipient:      recipient,
+		interval:       interval,
+		stopChan:       make(chan struct{}),
+	}
+
+	resp, err := ws.sendMessage(sc, recipient, share.buildMessage(1, duration))
+	if err != nil {
+		return nil, fmt.Errorf("failed to send live location: %w", err)
+	}
+	share.ShareID = resp.ID
+	share.Sequence = 1
+
+	ws.liveLocations.Store(share.ShareID, share)
+	go ws.runLiveLocationShare(share)
+
+	log.Printf("📍 Live location share %s started to %s for %v", share.ShareID, recipient.String(), duration)
+
+	ws.wsManager.SendToSession(sessionID, WebSocketMessage{
+		Type: "message_sent",
+		Data: map[string]interface{}{
+			"message_id": resp.ID,
+			"to":         recipient.String(),
+			"type":       "live_location",
+			"expires_at": share.ExpiresAt,
+			"timestamp":  resp.Timestamp,
+		},
+	})
+
+	sessionUUID, _ := uuid.Parse(sessionID)
+	ws.db.CreateEvent(sessionUUID, userID, "live_location_started", map[string]interface{}{
+		"share_id":   share.ShareID,
+		"to":         recipient.String(),
+		"expires_at": share.ExpiresAt,
+	})
+
+	return share, nil
+}
+
+// UpdateLiveLocation records new coordinates for a share and pushes them immediately
+func (ws *WhatsAppService) UpdateLiveLocation(sessionID string, userID int, shareID string, latitude, longitude float64, accuracy uint32) (*LiveLocationShare, error) {
+	if err := validateCoordinates(latitude, longitude); err != nil {
+		return nil, err
+	}
+
+	share, err := ws.getLiveLocationShare(sessionID, userID, shareID)
+	if err != nil {
+		return nil, err
+	}
+
+	share.mu.Lock()
+	share.Latitude = latitude
+	share.Longitude = longitude
+	if accuracy > 0 {
+		share.AccuracyMeters = accuracy
+	}
+	share.mu.Unlock()
+
+	if err := ws.pushLiveLocationUpdate(share, false); err != nil {
+		return nil, err
+	}
+
+	return share, nil
+}
+
+// StopLiveLocation ends a live location share
+func (ws *WhatsAppService) StopLiveLocation(sessionID string, userID int, shareID string) error {
+	share, err := ws.getLiveLocationShare(sessionID, userID, shareID)
+	if err != nil {
+		return err
+	}
+
+	ws.finishLiveLocationShare(share, "stopped")
+	return nil
+}
+
+// GetLiveLocationShares lists active shares of a session
+func (ws *WhatsAppService) GetLiveLocationShares(sessionID string, userID int) []*LiveLocationShare {
+	shares := make([]*LiveLocationShare, 0)
+	ws.liveLocations.Range(func(key, value interface{}) bool {
+		share := value.(*LiveLocationShare)
+		if share.SessionID == sessionID && share.UserID == userID {
+			shares = append(shares, share)
+		}
+		return true
+	})
+	return shares
+}
+
+func (ws *WhatsAppService) getLiveLocationShare(sessionID string, userID int, shareID string) (*LiveLocationShare, error) {
+	value, ok := ws.liveLocations.Load(shareID)
+	if !ok {
+		return nil, fmt.Errorf("live location share not found")
+	}
+
+	share := value.(*LiveLocationShare)
+	if share.SessionID != sessionID || share.UserID != userID {
+		return nil, fmt.Errorf("live location share not found")
+	}
+	return share, nil
+}
+
+// runLiveLocationShare periodically re-sends the latest position until the share ends
+func (ws *WhatsAppService) runLiveLocationShare(share *LiveLocationShare) {
+	ticker := time.NewTicker(share.interval)
+	defer ticker.Stop()
+
+	expiry := time.NewTimer(time.Until(share.ExpiresAt))
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-share.stopChan:
+			return
+		case <-expiry.C:
+			ws.finishLiveLocationShare(share, "expired")
+			return
+		case <-ticker.C:
+			if err := ws.pushLiveLocationUpdate(share, false); err != nil {
+				log.Printf("⚠️  Live location update failed for share %s: %v", share.ShareID, err)
+			}
+		}
+	}
+}
+
+// pushLiveLocationUpdate sends the current position as the next message of
+// the share; end sends the message that ends the share now
+func (ws *WhatsAppService) pushLiveLocationUpdate(share *LiveLocationShare, end bool) error {
+	sc, err := ws.GetSessionClient(share.SessionID)
+	if err != nil {
+		return err
+	}
+
+	if !sc.Client.IsConnected() {
+		return apierr.ErrSessionNotConnected
+	}
+
+	share.sendMu.Lock()
+	defer share.sendMu.Unlock()
+
+	share.mu.Lock()
+	sequence := share.Sequence + 1
+	share.mu.Unlock()
+
+	live := share.ExpiresAt.Sub(share.StartedAt)
+	if end {
+		live = time.Since(share.StartedAt)
+	}
+	if _, err := ws.sendMessage(sc, share.recipient, share.buildMessage(sequence, live)); err != nil {
+		return fmt.Errorf("failed to send live location update: %w", err)
+	}
+
+	share.mu.Lock()
+	share.Sequence = sequence
+	share.mu.Unlock()
+	return nil
+}
+
+// finishLiveLocationShare sends the end of the share and removes it
+func (ws *WhatsAppService) finishLiveLocationShare(share *LiveLocationShare, reason string) {
+	share.stopOnce.Do(func() {
+		close(share.stopChan)
+		ws.liveLocations.Delete(share.ShareID)
+
+		if err := ws.pushLiveLocationUpdate(share, true); err != nil {
+			log.Printf("⚠️  Failed to send the end of live location share %s: %v", share.ShareID, err)
+		}
+
+		log.Printf("📍 Live location share %s %s", share.ShareID, reason)
+
+		ws.wsManager.SendToSession(share.SessionID, WebSocketMessage{
+			Type: "live_location_ended",
+			Data: map[string]interface{}{
+				"share_id": share.ShareID,
+				"to":       share.To,
+				"reason":   reason,
+			},
+		})
+
+		sessionUUID, _ := uuid.Parse(share.SessionID)
+		ws.db.CreateEvent(sessionUUID, share.UserID, "live_location_ended", map[string]interface{}{
+			"share_id": share.ShareID,
+			"reason":   reason,
+		})
+	})
+}
+
+// stopSessionLiveLocations drops the live location shares of a session (or of all
+// sessions when sessionID is empty) without sending a final update
+func (ws *WhatsAppService) stopSessionLiveLocations(sessionID string) {
+	ws.liveLocations.Range(func(key, value interface{}) bool {
+		share := value.(*LiveLocationShare)
+		if sessionID == "" || share.SessionID == sessionID {
+			share.stopOnce.Do(func() {
+				close(share.stopChan)
+				ws.liveLocations.Delete(share.ShareID)
+			})
+		}
+		return true
+	})
+}
+
+func validateCoordinates(latitude, longitude float64) error {
+	if latitude < -90 || latitude > 90 {
+		return fmt.Errorf("latitude must be between -90 and 90")
+	}
+	if longitude < -180 || longitude > 180 {
+		return fmt.Errorf("longitude must be between -180 and 180")
+	}
+	return nil
+}
@@ -7,42 +7,246 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+
+	"whatsapp-api/internal/eventsink"
+	"whatsapp-api/internal/jobs"
+	"whatsapp-api/internal/ratelimit"
+	"whatsapp-api/internal/storage"
+	"whatsapp-api/internal/storecrypt"
 )
 
 // ============= CONFIGURATION =============
 
 type Config struct {
 	// App
-	AppPort string
-	AppEnv  string
+	AppPort  string
+	AppEnv   string
+	GRPCPort string // empty disables the gRPC server
+
+	// API versions (RFC 3339 or YYYY-MM-DD, empty = not scheduled)
+	APIV1DeprecatedAt string
+	APIV1SunsetAt     string
 
 	// Database
+	DBDriver   string // mysql or postgres
 	DBHost     string
 	DBPort     string
 	DBName     string
 	DBUser     string
 	DBPassword string
+	DBSSLMode  string // Postgres sslmode
+	// DBAutoMigrate applies pending schema migrations on startup; when false
+	// the server refuses to start until `migrate up` has been run
+	DBAutoMigrate bool
+
+	// Application database connection pool (0 open = unlimited)
+	DBMaxOpenConns       int
+	DBMaxIdleConns       int
+	DBConnMaxLifetime    time.Duration // 0 = connections are reused forever
+	DBPrepareStmt        bool          // cache prepared statements per connection
+	DBSlowQueryThreshold time.Duration // queries slower than this are logged, 0 = off
+
+	// Read replica for listings and statistics, same DB_DRIVER as the primary
+	DBReplicaEnabled bool
+	DBReplicaDSN     string        // DSN in the driver's format
+	DBReplicaMaxLag  time.Duration // reads go to the primary while the replica trails more, 0 = no lag check
 
 	// JWT
 	JWTSecret string
 	JWTIssuer string
 
+	// WhatsApp store encryption ("id:base64key,...", first = primary; empty = off)
+	StoreEncryptionKeys string
+
 	// WhatsApp
 	AutoReconnect     bool
-	QRTimeout         time.Duration
 	MaxDevicesPerUser int
 
+	// Messages a user may send per calendar month (0 = no limit): past the
+	// soft limit sends carry a warning, at the hard limit they are refused
+	UsageSoftLimit int
+	UsageHardLimit int
+
 	// CORS
 	CORSAllowedOrigins string
 
+	// Minimum level of the WhatsApp client logs (DEBUG, INFO, WARN, ERROR)
+	LogLevel string
+
+	// Token of the admin endpoints (X-Admin-Token), empty disables them
+	AdminToken string
+
+	// API rate limits per user and endpoint class (0 per minute = unlimited)
+	RateLimitEnabled       bool
+	RateLimitStore         string // memory or redis
+	RateLimitPerMinute     int    // other writes
+	RateLimitBurst         int
+	RateLimitSendPerMinute int
+	RateLimitSendBurst     int
+	RateLimitReadPerMinute int
+	RateLimitReadBurst     int
+	RateLimitUserLimits    string // "user_id:class=per_minute/burst,..."
+
+	// Redis (shared rate limits, redis job queue)
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+
+	// Background jobs (group syncs, async broadcast list sends)
+	JobsBackend  string // memory or redis
+	JobsWorkers  int    // jobs run at once
+	JobsInstance string // names this instance (its redis queue, its send intents), default the hostname
+
 	// Group sync settings
 	GroupSyncDelay         time.Duration
-	GroupSyncRetryAttempts int
+	GroupSyncRetryAttempts int           // rate limits in a row before a sync gives up
+	GroupSyncMaxAge        time.Duration // groups synced more recently are skipped unless forced
+
+	// Largest group a mention_all send may mention everyone in (0 = disabled)
+	MentionAllMaxParticipants int
+
+	// Default thresholds (percent delivered/read) of aggregate campaign receipts
+	CampaignReceiptThresholds string
+
+	// Replies that put the sender on the suppression list, comma separated
+	// (empty = the built-in English and localized keywords)
+	OptOutKeywords string
+
+	// Pairing: a session that isn't paired after this many QR attempts (batches
+	// of codes) or this long after its first QR code expires (0 = no limit)
+	QRMaxAttempts    int
+	QRPairingTimeout time.Duration
+
+	// Contact sync settings
+	ContactSyncInterval   time.Duration // delta sync of each session's contact store, 0 = events only
+	BlocklistSyncInterval time.Duration // reconciliation of each session's blocklist, 0 = events only
+
+	// How long a channel's session must stay down (or up again) before the
+	// channel switches to its other session; sends switch right away
+	ChannelFailoverGrace time.Duration
+
+	// Batched event and incoming message inserts
+	EventBatchSize     int           // rows per INSERT
+	EventBufferSize    int           // rows of each kind buffered before adds wait
+	EventFlushInterval time.Duration // max time a row waits for its batch
+
+	// Streaming of stored events (and messages) to kafka, nats or sqs,
+	// empty = off
+	EventSink          string
+	EventSinkMessages  bool
+	KafkaBrokers       string // "host:port,..."
+	KafkaTopic         string
+	KafkaTLS           bool
+	KafkaUsername      string // SASL/PLAIN, empty = no authentication
+	KafkaPassword      string
+	NATSURL            string // nats://[user:pass@]host:port or tls://...
+	NATSSubject        string // events go to <subject>.<event type>
+	SQSQueueURL        string
+	SQSRegion          string // empty = from the queue URL
+	SQSAccessKeyID     string
+	SQSSecretAccessKey string
+	SQSSessionToken    string
+
+	// Mismatches between sessions and the WhatsApp store checked at startup:
+	// off, report or repair. Sessions without a device in this instance's
+	// store are only a mismatch with StoreReconcileMissing (single instance).
+	StoreReconcile        string
+	StoreReconcileMissing bool
+
+	// Event tasks a session's worker queues before whatsmeow's event loop waits
+	SessionQueueSize int
+
+	// WhatsApp calls (sends and requests) a session makes at once; background
+	// calls such as group sync use at most WhatsAppBackgroundConcurrency of
+	// them. Calls without a slot within WhatsAppQueueTimeout fail.
+	WhatsAppConcurrency           int
+	WhatsAppBackgroundConcurrency int
+	WhatsAppQueueTimeout          time.Duration
+
+	// Incoming messages seen again within the window are dropped (0 = off);
+	// the window holds at most MessageDedupSize messages
+	MessageDedupWindow time.Duration
+	MessageDedupSize   int
+
+	// Connection keepalive (whatsmeow websocket pings)
+	KeepAliveIntervalMin      time.Duration
+	KeepAliveIntervalMax      time.Duration
+	KeepAliveResponseDeadline time.Duration // wait for a ping answer
+	KeepAliveMaxFailTime      time.Duration // unanswered pings for this long force a reconnect
+
+	// Health canaries of sessions with a canary target: how often one runs
+	// (0 = off) and how long it may take
+	CanaryInterval time.Duration
+	CanaryTimeout  time.Duration
+
+	// Deleted sessions are purged with their data this long after the
+	// deletion, 0 = only by hand (POST /sessions/:session_id/purge)
+	SessionPurgeGrace time.Duration
+
+	// History sync settings
+	HistorySyncDepth int // max messages stored per conversation, 0 disables message import
+
+	// Copy incoming view-once media to media storage before it expires
+	ViewOnceAutoDownload bool
+
+	// Longer texts are sent in parts (0 = never split), optionally numbered "1/3"
+	TextChunkMaxLength int
+	TextChunkNumbering bool
+
+	// Queued async sends expire unsent after this long unless the request or
+	// session sets its own (0 = never)
+	OutboxDefaultTTL time.Duration
+
+	// Media storage (cached avatars)
+	MediaStorage       string // local or s3
+	MediaStorageDir    string // root of the local backend
+	MediaPublicURL     string // base URL of signed links to local files
+	MediaURLSigningKey string
+	S3Bucket           string
+	S3Region           string
+	S3Endpoint         string // empty = AWS; set for MinIO, R2 or GCS
+	S3AccessKeyID      string
+	S3SecretAccessKey  string
+	S3ForcePathStyle   bool
+
+	// Avatar cache
+	AvatarRefreshInterval time.Duration
+
+	// Chat exports
+	ExportDir string
+	ExportTTL time.Duration // exports are deleted this long after they finish
+
+	// WhatsApp store backups
+	BackupInterval time.Duration // 0 disables scheduled backups
+	BackupStorage  string        // local or s3 (with the S3_* settings)
+	BackupDir      string        // root of local backups
+	BackupKeep     int           // newest backups kept per instance
+	BackupInstance string        // names this instance's backups, default the hostname
+
+	// IsOnWhatsApp result cache
+	JIDCacheTTL  time.Duration // 0 disables caching
+	JIDCacheSize int           // max entries kept in memory
+
+	// Media size limits in bytes
+	MaxImageSize    int64
+	MaxVideoSize    int64
+	MaxAudioSize    int64
+	MaxDocumentSize int64
+
+	// Anti-ban safety engine
+	SafetyEnabled          bool
+	SafetyDailyLimit       int           // default per-session daily cap, 0 = unlimited
+	SafetyMinDelay         time.Duration // random gap between sends of one session
+	SafetyMaxDelay         time.Duration
+	SafetyWarmupProfile    string  // ramp-up schedule for newly paired numbers
+	SafetyFailureThreshold float64 // failure rate that pauses a session
+	SafetyPauseDuration    time.Duration
 }
 
 func LoadConfig() (*Config, error) {
@@ -50,33 +254,279 @@ func LoadConfig() (*Config, error) {
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables")
 	}
+	return readConfig()
+}
+
+// readConfig builds the config from the environment and the config file;
+// config reloads call it again
+func readConfig() (*Config, error) {
+	env, err := newConfigSource()
+	if err != nil {
+		return nil, err
+	}
 
 	cfg := &Config{
 		// App
-		AppPort: getEnv("APP_PORT", "8080"),
-		AppEnv:  getEnv("APP_ENV", "development"),
+		AppPort:  env.String("APP_PORT", "8080"),
+		AppEnv:   env.String("APP_ENV", "development"),
+		GRPCPort: env.String("GRPC_PORT", ""),
+
+		APIV1DeprecatedAt: env.String("API_V1_DEPRECATED_AT", ""),
+		APIV1SunsetAt:     env.String("API_V1_SUNSET_AT", ""),
 
 		// Database
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBPort:     getEnv("DB_PORT", "3306"),
-		DBName:     getEnv("DB_NAME", "whatsapp_api"),
-		DBUser:     getEnv("DB_USER", "root"),
-		DBPassword: getEnv("DB_PASSWORD", ""),
+		DBDriver:   strings.ToLower(env.String("DB_DRIVER", DBDriverMySQL)),
+		DBHost:     env.String("DB_HOST", "localhost"),
+		DBPort:     env.String("DB_PORT", ""),
+		DBName:     env.String("DB_NAME", "whatsapp_api"),
+		DBUser:     env.String("DB_USER", "root"),
+		DBPassword: env.String("DB_PASSWORD", ""),
+		DBSSLMode:  env.String("DB_SSL_MODE", "disable"),
+
+		DBAutoMigrate: env.Bool("DB_AUTO_MIGRATE", true),
+
+		DBMaxOpenConns:       env.Int("DB_MAX_OPEN_CONNS", 200),
+		DBMaxIdleConns:       env.Int("DB_MAX_IDLE_CONNS", 50),
+		DBConnMaxLifetime:    env.Duration("DB_CONN_MAX_LIFETIME", time.Hour),
+		DBPrepareStmt:        env.Bool("DB_PREPARE_STMT", true),
+		DBSlowQueryThreshold: env.Duration("DB_SLOW_QUERY_THRESHOLD", 0),
+
+		DBReplicaEnabled: env.Bool("DB_REPLICA_ENABLED", false),
+		DBReplicaDSN:     env.String("DB_REPLICA_DSN", ""),
+		DBReplicaMaxLag:  env.Duration("DB_REPLICA_MAX_LAG", 30*time.Second),
 
 		// JWT
-		JWTSecret: getEnv("JWT_SECRET", ""),
-		JWTIssuer: getEnv("JWT_ISSUER", ""),
+		JWTSecret: env.String("JWT_SECRET", ""),
+		JWTIssuer: env.String("JWT_ISSUER", ""),
+
+		StoreEncryptionKeys: env.String("STORE_ENCRYPTION_KEYS", ""),
 
 		// WhatsApp
-		AutoReconnect:     getEnv("WA_AUTO_RECONNECT", "true") == "true",
-		QRTimeout:         parseDuration(getEnv("WA_QR_TIMEOUT", "30s"), 30*time.Second),
-		MaxDevicesPerUser: parseInt(getEnv("MAX_DEVICES_PER_USER", "5"), 5),
+		AutoReconnect:     env.Bool("WA_AUTO_RECONNECT", true),
+		MaxDevicesPerUser: env.Int("MAX_DEVICES_PER_USER", defaultMaxDevicesPerUser),
+
+		UsageSoftLimit: env.Int("USAGE_SOFT_LIMIT", 0),
+		UsageHardLimit: env.Int("USAGE_HARD_LIMIT", 0),
 
 		// CORS
-		CORSAllowedOrigins: getEnv("CORS_ALLOWED_ORIGINS", "*"),
+		CORSAllowedOrigins: env.String("CORS_ALLOWED_ORIGINS", "*"),
 
-		GroupSyncDelay:         parseDuration(getEnv("GROUP_SYNC_DELAY", "2s"), 2*time.Second),
-		GroupSyncRetryAttempts: parseInt(getEnv("GROUP_SYNC_RETRY_ATTEMPTS", "3"), 3),
+		LogLevel:   strings.ToUpper(env.String("LOG_LEVEL", "INFO")),
+		AdminToken: env.String("ADMIN_TOKEN", ""),
+
+		RateLimitEnabled:       env.Bool("RATE_LIMIT_ENABLED", true),
+		RateLimitStore:         env.String("RATE_LIMIT_STORE", ratelimit.StoreMemory),
+		RateLimitPerMinute:     env.Int("RATE_LIMIT_REQUESTS_PER_MINUTE", 60),
+		RateLimitBurst:         env.Int("RATE_LIMIT_BURST", 10),
+		RateLimitSendPerMinute: env.Int("RATE_LIMIT_SEND_PER_MINUTE", 60),
+		RateLimitSendBurst:     env.Int("RATE_LIMIT_SEND_BURST", 20),
+		RateLimitReadPerMinute: env.Int("RATE_LIMIT_READ_PER_MINUTE", 300),
+		RateLimitReadBurst:     env.Int("RATE_LIMIT_READ_BURST", 50),
+		RateLimitUserLimits:    env.String("RATE_LIMIT_USER_LIMITS", ""),
+
+		RedisAddr:     env.String("REDIS_HOST", "localhost") + ":" + env.String("REDIS_PORT", "6379"),
+		RedisPassword: env.String("REDIS_PASSWORD", ""),
+		RedisDB:       env.Int("REDIS_DB", 0),
+
+		JobsBackend:  strings.ToLower(env.String("JOBS_BACKEND", jobs.BackendMemory)),
+		JobsWorkers:  env.Int("JOBS_WORKERS", 10),
+		JobsInstance: env.String("JOBS_INSTANCE", ""),
+
+		GroupSyncDelay:         env.Duration("GROUP_SYNC_DELAY", 2*time.Second),
+		GroupSyncRetryAttempts: env.Int("GROUP_SYNC_RETRY_ATTEMPTS", 3),
+		GroupSyncMaxAge:        env.Duration("GROUP_SYNC_MAX_AGE", 6*time.Hour),
+
+		MentionAllMaxParticipants: env.Int("MENTION_ALL_MAX_PARTICIPANTS", 256),
+		CampaignReceiptThresholds: env.String("CAMPAIGN_RECEIPT_THRESHOLDS", "25,50,100"),
+		OptOutKeywords:            env.String("OPT_OUT_KEYWORDS", ""),
+
+		QRMaxAttempts:    env.Int("QR_MAX_ATTEMPTS", 3),
+		QRPairingTimeout: env.Duration("QR_PAIRING_TIMEOUT", 10*time.Minute),
+
+		ContactSyncInterval:   env.Duration("CONTACT_SYNC_INTERVAL", time.Hour),
+		BlocklistSyncInterval: env.Duration("BLOCKLIST_SYNC_INTERVAL", 6*time.Hour),
+
+		ChannelFailoverGrace: env.Duration("CHANNEL_FAILOVER_GRACE", 30*time.Second),
+
+		EventBatchSize:     env.Int("EVENT_BATCH_SIZE", 100),
+		EventBufferSize:    env.Int("EVENT_BUFFER_SIZE", 5000),
+		EventFlushInterval: env.Duration("EVENT_FLUSH_INTERVAL", 500*time.Millisecond),
+
+		EventSink:          strings.ToLower(env.String("EVENT_SINK", "")),
+		EventSinkMessages:  env.Bool("EVENT_SINK_MESSAGES", true),
+		KafkaBrokers:       env.String("KAFKA_BROKERS", ""),
+		KafkaTopic:         env.String("KAFKA_TOPIC", "whatsapp-events"),
+		KafkaTLS:           env.Bool("KAFKA_TLS", false),
+		KafkaUsername:      env.String("KAFKA_USERNAME", ""),
+		KafkaPassword:      env.String("KAFKA_PASSWORD", ""),
+		NATSURL:            env.String("NATS_URL", "nats://localhost:4222"),
+		NATSSubject:        env.String("NATS_SUBJECT", "whatsapp.events"),
+		SQSQueueURL:        env.String("SQS_QUEUE_URL", ""),
+		SQSRegion:          env.String("SQS_REGION", ""),
+		SQSAccessKeyID:     env.String("SQS_ACCESS_KEY_ID", ""),
+		SQSSecretAccessKey: env.String("SQS_SECRET_ACCESS_KEY", ""),
+		SQSSessionToken:    env.String("SQS_SESSION_TOKEN", ""),
+
+		StoreReconcile:        strings.ToLower(env.String("STORE_RECONCILE", StoreReconcileReport)),
+		StoreReconcileMissing: env.Bool("STORE_RECONCILE_MISSING", true),
+		SessionQueueSize:      env.Int("SESSION_QUEUE_SIZE", 256),
+
+		WhatsAppConcurrency:           env.Int("WHATSAPP_CONCURRENCY", 4),
+		WhatsAppBackgroundConcurrency: env.Int("WHATSAPP_BACKGROUND_CONCURRENCY", 1),
+		WhatsAppQueueTimeout:          env.Duration("WHATSAPP_QUEUE_TIMEOUT", 30*time.Second),
+
+		MessageDedupWindow: env.Duration("MESSAGE_DEDUP_WINDOW", 10*time.Minute),
+		MessageDedupSize:   env.Int("MESSAGE_DEDUP_SIZE", 100000),
+
+		KeepAliveIntervalMin:      env.Duration("KEEPALIVE_INTERVAL_MIN", 20*time.Second),
+		KeepAliveIntervalMax:      env.Duration("KEEPALIVE_INTERVAL_MAX", 30*time.Second),
+		KeepAliveResponseDeadline: env.Duration("KEEPALIVE_RESPONSE_DEADLINE", 10*time.Second),
+		KeepAliveMaxFailTime:      env.Duration("KEEPALIVE_MAX_FAIL_TIME", 3*time.Minute),
+
+		CanaryInterval: env.Duration("CANARY_INTERVAL", 5*time.Minute),
+		CanaryTimeout:  env.Duration("CANARY_TIMEOUT", 30*time.Second),
+
+		SessionPurgeGrace: env.Duration("SESSION_PURGE_GRACE", 30*24*time.Hour),
+
+		HistorySyncDepth: env.Int("HISTORY_SYNC_DEPTH", 50),
+
+		ViewOnceAutoDownload: env.Bool("VIEW_ONCE_AUTO_DOWNLOAD", false),
+
+		TextChunkMaxLength: env.Int("TEXT_CHUNK_MAX_LENGTH", 4096),
+		TextChunkNumbering: env.Bool("TEXT_CHUNK_NUMBERING", false),
+
+		OutboxDefaultTTL: env.Duration("OUTBOX_DEFAULT_TTL", 0),
+
+		MediaStorage:       env.String("MEDIA_STORAGE", storage.BackendLocal),
+		MediaStorageDir:    env.String("MEDIA_STORAGE_DIR", "./data"),
+		MediaPublicURL:     env.String("MEDIA_PUBLIC_URL", ""),
+		MediaURLSigningKey: env.String("MEDIA_URL_SIGNING_KEY", ""),
+		S3Bucket:           env.String("S3_BUCKET", ""),
+		S3Region:           env.String("S3_REGION", "us-east-1"),
+		S3Endpoint:         env.String("S3_ENDPOINT", ""),
+		S3AccessKeyID:      env.String("S3_ACCESS_KEY_ID", ""),
+		S3SecretAccessKey:  env.String("S3_SECRET_ACCESS_KEY", ""),
+		S3ForcePathStyle:   env.Bool("S3_FORCE_PATH_STYLE", false),
+
+		AvatarRefreshInterval: env.Duration("AVATAR_REFRESH_INTERVAL", 24*time.Hour),
+
+		ExportDir: env.String("EXPORT_DIR", "./data/exports"),
+		ExportTTL: env.Duration("EXPORT_TTL", 24*time.Hour),
+
+		BackupInterval: env.Duration("BACKUP_INTERVAL", 0),
+		BackupStorage:  env.String("BACKUP_STORAGE", storage.BackendLocal),
+		BackupDir:      env.String("BACKUP_DIR", "./backups"),
+		BackupKeep:     env.Int("BACKUP_KEEP", 7),
+		BackupInstance: env.String("BACKUP_INSTANCE", ""),
+
+		JIDCacheTTL:  env.Duration("JID_CACHE_TTL", 24*time.Hour),
+		JIDCacheSize: env.Int("JID_CACHE_SIZE", 10000),
+
+		MaxImageSize:    int64(env.Int("MAX_IMAGE_SIZE", 16*1024*1024)),
+		MaxVideoSize:    int64(env.Int("MAX_VIDEO_SIZE", 100*1024*1024)),
+		MaxAudioSize:    int64(env.Int("MAX_AUDIO_SIZE", 16*1024*1024)),
+		MaxDocumentSize: int64(env.Int("MAX_DOCUMENT_SIZE", 100*1024*1024)),
+
+		SafetyEnabled:          env.Bool("SAFETY_ENABLED", true),
+		SafetyDailyLimit:       env.Int("SAFETY_DAILY_LIMIT", 1000),
+		SafetyMinDelay:         env.Duration("SAFETY_MIN_DELAY", time.Second),
+		SafetyMaxDelay:         env.Duration("SAFETY_MAX_DELAY", 4*time.Second),
+		SafetyWarmupProfile:    env.String("SAFETY_WARMUP_PROFILE", "standard"),
+		SafetyFailureThreshold: env.Float("SAFETY_FAILURE_THRESHOLD", 0.3),
+		SafetyPauseDuration:    env.Duration("SAFETY_PAUSE_DURATION", 30*time.Minute),
+	}
+	keysFile := env.String("STORE_ENCRYPTION_KEYS_FILE", "")
+
+	// Malformed values and unknown settings in the config file
+	if err := env.Err(); err != nil {
+		return nil, err
+	}
+	// v1 is the only API version; retiring it would take the whole API down
+	if strings.TrimSpace(cfg.APIV1SunsetAt) != "" {
+		return nil, fmt.Errorf("API_V1_SUNSET_AT can't be set while v1 is the newest API version")
+	}
+	if _, ok := logLevels[cfg.LogLevel]; !ok {
+		return nil, fmt.Errorf("unknown LOG_LEVEL %q (expected DEBUG, INFO, WARN or ERROR)", cfg.LogLevel)
+	}
+
+	if cfg.SafetyMaxDelay < cfg.SafetyMinDelay {
+		cfg.SafetyMaxDelay = cfg.SafetyMinDelay
+	}
+	if _, ok := warmupProfiles[cfg.SafetyWarmupProfile]; !ok {
+		return nil, fmt.Errorf("unknown SAFETY_WARMUP_PROFILE %q", cfg.SafetyWarmupProfile)
+	}
+	if cfg.UsageSoftLimit < 0 || cfg.UsageHardLimit < 0 {
+		return nil, fmt.Errorf("USAGE_SOFT_LIMIT and USAGE_HARD_LIMIT can't be negative")
+	}
+	if cfg.DBMaxOpenConns < 0 || cfg.DBMaxIdleConns < 0 || cfg.DBConnMaxLifetime < 0 || cfg.DBSlowQueryThreshold < 0 {
+		return nil, fmt.Errorf("DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME and DB_SLOW_QUERY_THRESHOLD can't be negative")
+	}
+	if cfg.DBReplicaEnabled && cfg.DBReplicaDSN == "" {
+		return nil, fmt.Errorf("DB_REPLICA_DSN is required with DB_REPLICA_ENABLED=true")
+	}
+	if cfg.DBReplicaMaxLag < 0 {
+		return nil, fmt.Errorf("DB_REPLICA_MAX_LAG can't be negative")
+	}
+	if cfg.QRMaxAttempts < 0 || cfg.QRPairingTimeout < 0 {
+		return nil, fmt.Errorf("QR_MAX_ATTEMPTS and QR_PAIRING_TIMEOUT can't be negative")
+	}
+	if cfg.MentionAllMaxParticipants < 0 {
+		return nil, fmt.Errorf("MENTION_ALL_MAX_PARTICIPANTS can't be negative")
+	}
+	if _, err := parseReceiptThresholds(cfg.CampaignReceiptThresholds); err != nil {
+		return nil, fmt.Errorf("CAMPAIGN_RECEIPT_THRESHOLDS: %w", err)
+	}
+	if _, err := parseOptOutKeywords(cfg.OptOutKeywords); err != nil {
+		return nil, fmt.Errorf("OPT_OUT_KEYWORDS: %w", err)
+	}
+	if cfg.EventBatchSize <= 0 || cfg.EventBufferSize <= 0 || cfg.EventFlushInterval <= 0 {
+		return nil, fmt.Errorf("EVENT_BATCH_SIZE, EVENT_BUFFER_SIZE and EVENT_FLUSH_INTERVAL must be positive")
+	}
+	if cfg.JobsBackend != jobs.BackendMemory && cfg.JobsBackend != jobs.BackendRedis {
+		return nil, fmt.Errorf("unknown JOBS_BACKEND %q (expected memory or redis)", cfg.JobsBackend)
+	}
+	if cfg.JobsWorkers <= 0 {
+		return nil, fmt.Errorf("JOBS_WORKERS must be positive")
+	}
+	switch cfg.EventSink {
+	case "":
+	case eventsink.SinkKafka:
+		if cfg.KafkaBrokers == "" || cfg.KafkaTopic == "" {
+			return nil, fmt.Errorf("KAFKA_BROKERS and KAFKA_TOPIC are required with EVENT_SINK=kafka")
+		}
+	case eventsink.SinkNATS:
+		if cfg.NATSURL == "" || cfg.NATSSubject == "" {
+			return nil, fmt.Errorf("NATS_URL and NATS_SUBJECT are required with EVENT_SINK=nats")
+		}
+	case eventsink.SinkSQS:
+		if cfg.SQSQueueURL == "" || cfg.SQSAccessKeyID == "" || cfg.SQSSecretAccessKey == "" {
+			return nil, fmt.Errorf("SQS_QUEUE_URL, SQS_ACCESS_KEY_ID and SQS_SECRET_ACCESS_KEY are required with EVENT_SINK=sqs")
+		}
+	default:
+		return nil, fmt.Errorf("unknown EVENT_SINK %q (expected kafka, nats or sqs)", cfg.EventSink)
+	}
+	switch cfg.StoreReconcile {
+	case StoreReconcileOff, StoreReconcileReport, StoreReconcileRepair:
+	default:
+		return nil, fmt.Errorf("unknown STORE_RECONCILE %q (expected off, report or repair)", cfg.StoreReconcile)
+	}
+	if cfg.SessionQueueSize <= 0 {
+		return nil, fmt.Errorf("SESSION_QUEUE_SIZE must be positive")
+	}
+	if cfg.WhatsAppConcurrency <= 0 {
+		return nil, fmt.Errorf("WHATSAPP_CONCURRENCY must be positive")
+	}
+	if cfg.WhatsAppBackgroundConcurrency <= 0 || cfg.WhatsAppBackgroundConcurrency > cfg.WhatsAppConcurrency {
+		return nil, fmt.Errorf("WHATSAPP_BACKGROUND_CONCURRENCY must be between 1 and WHATSAPP_CONCURRENCY")
+	}
+	if cfg.WhatsAppQueueTimeout <= 0 {
+		return nil, fmt.Errorf("WHATSAPP_QUEUE_TIMEOUT must be positive")
+	}
+	if cfg.ChannelFailoverGrace < 0 {
+		return nil, fmt.Errorf("CHANNEL_FAILOVER_GRACE can't be negative")
+	}
+	if cfg.MessageDedupWindow < 0 || cfg.MessageDedupSize <= 0 {
+		return nil, fmt.Errorf("MESSAGE_DEDUP_WINDOW can't be negative and MESSAGE_DEDUP_SIZE must be positive")
 	}
 
 	// Validate required fields
@@ -84,34 +534,94 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("JWT_SECRET is required")
 	}
 
-	if cfg.DBPassword == "" && cfg.AppEnv == "production" {
-		return nil, fmt.Errorf("DB_PASSWORD is required in production")
+	// Keys mounted as a file (secret manager / KMS agent) take precedence
+	if keysFile != "" {
+		data, err := os.ReadFile(keysFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read STORE_ENCRYPTION_KEYS_FILE: %w", err)
+		}
+		cfg.StoreEncryptionKeys = strings.TrimSpace(string(data))
+	}
+	if _, err := storecrypt.ParseKeyring(cfg.StoreEncryptionKeys); err != nil {
+		return nil, err
 	}
 
-	return cfg, nil
-}
+	if cfg.MediaPublicURL == "" {
+		cfg.MediaPublicURL = "http://localhost:" + cfg.AppPort
+	}
+	if cfg.MediaURLSigningKey == "" {
+		cfg.MediaURLSigningKey = cfg.JWTSecret
+	}
+	switch cfg.MediaStorage {
+	case storage.BackendLocal:
+	case storage.BackendS3:
+		if cfg.S3Bucket == "" || cfg.S3AccessKeyID == "" || cfg.S3SecretAccessKey == "" {
+			return nil, fmt.Errorf("S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required with MEDIA_STORAGE=s3")
+		}
+	default:
+		return nil, fmt.Errorf("unknown MEDIA_STORAGE %q (expected local or s3)", cfg.MediaStorage)
+	}
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	switch cfg.BackupStorage {
+	case storage.BackendLocal:
+	case storage.BackendS3:
+		if cfg.S3Bucket == "" || cfg.S3AccessKeyID == "" || cfg.S3SecretAccessKey == "" {
+			return nil, fmt.Errorf("S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required with BACKUP_STORAGE=s3")
+		}
+	default:
+		return nil, fmt.Errorf("unknown BACKUP_STORAGE %q (expected local or s3)", cfg.BackupStorage)
+	}
+	if cfg.BackupInterval < 0 {
+		return nil, fmt.Errorf("BACKUP_INTERVAL must not be negative")
+	}
+	if cfg.BackupKeep < 1 {
+		return nil, fmt.Errorf("BACKUP_KEEP must be at least 1")
+	}
+	if cfg.TextChunkMaxLength != 0 && cfg.TextChunkMaxLength < 100 {
+		return nil, fmt.Errorf("TEXT_CHUNK_MAX_LENGTH must be 0 or at least 100")
+	}
+	if cfg.OutboxDefaultTTL < 0 {
+		return nil, fmt.Errorf("OUTBOX_DEFAULT_TTL must not be negative")
+	}
+	if cfg.CanaryInterval < 0 {
+		return nil, fmt.Errorf("CANARY_INTERVAL must not be negative")
+	}
+	if cfg.CanaryTimeout <= 0 {
+		return nil, fmt.Errorf("CANARY_TIMEOUT must be positive")
+	}
+	if cfg.SessionPurgeGrace < 0 {
+		return nil, fmt.Errorf("SESSION_PURGE_GRACE must not be negative")
 	}
-	return defaultValue
-}
 
-func parseDuration(s string, defaultValue time.Duration) time.Duration {
-	d, err := time.ParseDuration(s)
-	if err != nil {
-		return defaultValue
+	if cfg.BackupInstance == "" {
+		if cfg.BackupInstance, err = os.Hostname(); err != nil || cfg.BackupInstance == "" {
+			cfg.BackupInstance = "default"
+		}
+	}
+	if cfg.JobsInstance == "" {
+		if cfg.JobsInstance, err = os.Hostname(); err != nil || cfg.JobsInstance == "" {
+			cfg.JobsInstance = "default"
+		}
 	}
-	return d
-}
 
-func parseInt(s string, defaultValue int) int {
-	var value int
-	if _, err := fmt.Sscanf(s, "%d", &value); err != nil {
-		return defaultValue
+	switch cfg.DBDriver {
+	case DBDriverMySQL:
+		if cfg.DBPort == "" {
+			cfg.DBPort = "3306"
+		}
+	case DBDriverPostgres:
+		if cfg.DBPort == "" {
+			cfg.DBPort = "5432"
+		}
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q (expected mysql or postgres)", cfg.DBDriver)
 	}
-	return value
+
+	if cfg.DBPassword == "" && cfg.AppEnv == "production" {
+		return nil, fmt.Errorf("DB_PASSWORD is required in production")
+	}
+
+	return cfg, nil
 }
 
 // ============= MAIN =============
@@ -125,9 +635,16 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Step 1: Test connection to MySQL server
-	fmt.Println("\n🔍 Step 1: Testing connection to MySQL server...")
-	fmt.Println("   Connecting to MySQL database...")
+	// Admin commands
+	if handled, err := runCommand(cfg, os.Args[1:]); err != nil {
+		log.Fatalf("%v", err)
+	} else if handled {
+		return
+	}
+
+	// Step 1: Test connection to the application database
+	fmt.Printf("\n🔍 Step 1: Testing connection to %s server...\n", dialectName(cfg.DBDriver))
+	fmt.Printf("   Connecting to %s database...\n", dialectName(cfg.DBDriver))
 
 	// Initialize database
 	log.Println("Initializing database...")
@@ -136,23 +653,65 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.Close()
+	db.StartBatchWriter(cfg.EventBatchSize, cfg.EventBufferSize, cfg.EventFlushInterval)
 
 	// Initialize WebSocket manager
-	wsManager := NewWebSocketManager()
+	wsManager := NewWebSocketManager(db)
 
 	// Initialize WhatsApp service
 	log.Println("Initializing WhatsApp service...")
 	whatsappService := NewWhatsAppService(cfg, db, wsManager)
+	whatsappService.ReconcileSendIntents()
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Start read replica lag checks
+	db.StartReplicaMonitor(ctx)
+
 	// Start session health monitor
 	whatsappService.StartSessionMonitor(ctx)
 	defer whatsappService.StopSessionMonitor()
 
-	// Restore active sessions
+	// Start group quiet-hours scheduler
+	whatsappService.StartGroupScheduler(ctx)
+
+	// Start avatar cache refresher
+	whatsappService.StartAvatarRefresher(ctx)
+	whatsappService.StartOutboxWorker(ctx)
+	whatsappService.StartCampaignWorker(ctx)
+	whatsappService.StartStatusWorker(ctx)
+	whatsappService.StartSequenceWorker(ctx)
+	whatsappService.StartContactSyncWorker(ctx)
+	whatsappService.StartBlocklistSyncWorker(ctx)
+	whatsappService.StartCanaryWorker(ctx)
+	whatsappService.StartPurgeWorker(ctx)
+	whatsappService.StartExportCleaner(ctx)
+	whatsappService.StartGroupStatsWorker(ctx)
+
+	// Start WhatsApp store backups
+	backups, err := NewBackupManager(cfg, db)
+	if err != nil {
+		log.Fatalf("Failed to initialize backups: %v", err)
+	}
+	backups.Start(ctx)
+
+	// Start streaming events to the event sink
+	exporter, err := NewEventExporter(cfg, db)
+	if err != nil {
+		log.Fatalf("Failed to initialize event sink: %v", err)
+	}
+	if exporter != nil {
+		exporter.Start(ctx)
+		defer exporter.Close()
+	}
+
+	// Start background jobs (group syncs, async broadcast sends)
+	whatsappService.StartJobs(ctx)
+
+	// Check the sessions against the WhatsApp store, then restore them
+	whatsappService.RunStoreReconcile()
 	if err := whatsappService.RestoreActiveSessions(); err != nil {
 		log.Printf("Failed to restore active sessions: %v", err)
 	}
@@ -160,6 +719,15 @@ func main() {
 	// Initialize API handlers
 	handlers := NewAPIHandlers(whatsappService, db, wsManager, cfg)
 
+	rateLimiter, err := NewRateLimiter(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize rate limiter: %v", err)
+	}
+
+	// SIGHUP and POST /admin/config/reload apply config changes
+	reloader := NewConfigReloader(cfg, whatsappService, rateLimiter)
+	reloader.WatchSignals(ctx)
+
 	// Setup Gin router
 	if cfg.AppEnv == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -174,35 +742,279 @@ func main() {
 
 	// Health check (no auth required)
 	router.GET("/health", handlers.HealthCheck)
+	router.GET("/ready", handlers.Readiness)
+
+	// API versions
+	versions := NewVersionRegistry(cfg)
+	router.GET("/api/versions", versions.HandleVersions)
 
-	v1 := router.Group("/api/v1")
+	v1 := versions.Mount(router, "v1")
 	{
+		// Admin routes (require ADMIN_TOKEN, disabled without one)
+		if cfg.AdminToken != "" {
+			admin := v1.Group("/admin", AdminMiddleware(cfg.AdminToken), AuditMiddleware(db))
+			admin.POST("/config/reload", reloader.HandleReload)
+			admin.GET("/backups", backups.HandleList)
+			admin.POST("/backups", backups.HandleCreate)
+			admin.GET("/store/reconcile", handlers.ReconcileStore)
+			admin.POST("/store/reconcile", handlers.ReconcileStore)
+		}
+
 		// Protected routes (require JWT auth)
-		protected := v1.Group("/", AuthMiddleware(cfg.JWTSecret))
+		// Audited before the rate and usage limits, so calls they reject are
+		// recorded too
+		protected := v1.Group("/", AuthMiddleware(cfg.JWTSecret), AuditMiddleware(db), rateLimiter.Middleware(), UsageMiddleware(whatsappService))
 		{
 			// Session management
 			protected.POST("/sessions", handlers.CreateSession)
 			protected.GET("/sessions", handlers.GetSessions)
 			protected.GET("/sessions/:session_id/qr", handlers.GetSessionQR)
 			protected.GET("/sessions/:session_id/status", handlers.GetSessionStatus)
+			protected.GET("/sessions/:session_id/health", handlers.GetSessionHealth)
+			protected.GET("/sessions/:session_id/stats", handlers.GetSessionStats)
+			protected.GET("/sessions/:session_id/device", handlers.GetSessionDevice)
 			protected.DELETE("/sessions/:session_id", handlers.DeleteSession)
+			protected.POST("/sessions/:session_id/logout", handlers.LogoutSession)
+			protected.POST("/sessions/:session_id/purge", handlers.PurgeSession)
+			protected.PUT("/sessions/:session_id", handlers.UpsertSession) // :session_id is the session name here
 
 			// NEW: Manual session refresh
 			protected.POST("/sessions/:session_id/refresh", handlers.RefreshSession)
+			protected.POST("/sessions/:session_id/reactivate", handlers.ReactivateSession)
+			protected.GET("/sessions/:session_id/qr-attempts", handlers.GetQRAttempts)
+			protected.PUT("/sessions/:session_id/project", handlers.SetSessionProject)
+
+			// Anti-ban safety
+			protected.GET("/sessions/:session_id/safety", handlers.GetSessionSafety)
+			protected.PUT("/sessions/:session_id/safety", handlers.UpdateSessionSafety)
+			protected.POST("/sessions/:session_id/safety/resume", handlers.ResumeSessionSafety)
+
+			// Calls
+			protected.GET("/sessions/:session_id/call-settings", handlers.GetCallSettings)
+			protected.PUT("/sessions/:session_id/call-settings", handlers.UpdateCallSettings)
+
+			// Inbound filter (unknown senders)
+			protected.GET("/sessions/:session_id/inbound-filter", handlers.GetInboundFilter)
+			protected.PUT("/sessions/:session_id/inbound-filter", handlers.UpdateInboundFilter)
+			protected.GET("/sessions/:session_id/inbound-filter/senders", handlers.GetSenderRules)
+			protected.POST("/sessions/:session_id/inbound-filter/senders", handlers.AddSenderRules)
+			protected.DELETE("/sessions/:session_id/inbound-filter/senders/:sender", handlers.RemoveSenderRule)
+
+			protected.GET("/sessions/:session_id/read-receipts", handlers.GetReadReceiptSettings)
+			protected.PUT("/sessions/:session_id/read-receipts", handlers.UpdateReadReceiptSettings)
+			protected.GET("/sessions/:session_id/media-settings", handlers.GetMediaSettings)
+			protected.PUT("/sessions/:session_id/media-settings", handlers.UpdateMediaSettings)
+			protected.GET("/sessions/:session_id/outbox-settings", handlers.GetOutboxSettings)
+			protected.PUT("/sessions/:session_id/outbox-settings", handlers.UpdateOutboxSettings)
+			protected.GET("/sessions/:session_id/canary", handlers.GetCanarySettings)
+			protected.PUT("/sessions/:session_id/canary", handlers.UpdateCanarySettings)
+			protected.POST("/sessions/:session_id/canary/run", handlers.RunCanary)
+			protected.GET("/calls/:session_id", handlers.GetCalls)
 
 			// Messaging
 			protected.POST("/sessions/:session_id/send", handlers.SendMessage)
 			protected.POST("/sessions/:session_id/send-advanced", handlers.SendMessageAdvanced)
+			protected.POST("/sessions/:session_id/notes", handlers.SendNoteToSelf)
+			protected.GET("/sessions/:session_id/live-locations", handlers.GetLiveLocations)
+			protected.POST("/messages/send/contact", handlers.SendContact)
+			protected.POST("/messages/send/auto", handlers.SendAuto)
+			protected.POST("/messages/send/image", handlers.SendImage)
+			protected.POST("/messages/send/video", handlers.SendVideo)
+			protected.POST("/messages/send/audio", handlers.SendAudio)
+			protected.POST("/messages/send/document", handlers.SendDocument)
+			protected.POST("/media/upload", handlers.UploadMedia)
+			protected.GET("/outbox/:message_id", handlers.GetOutboxMessage)
+			protected.POST("/messages/send/live-location", handlers.StartLiveLocation)
+			protected.POST("/messages/send/live-location/update", handlers.UpdateLiveLocation)
+			protected.POST("/messages/send/live-location/stop", handlers.StopLiveLocation)
+
+			// Broadcast lists
+			protected.GET("/broadcast-lists/:session_id", handlers.GetBroadcastLists)
+			protected.POST("/broadcast-lists/:session_id", handlers.CreateBroadcastList)
+			protected.DELETE("/broadcast-lists/:session_id/:list_id", handlers.DeleteBroadcastList)
+			protected.POST("/broadcast-lists/:session_id/:list_id/recipients", handlers.AddBroadcastListRecipients)
+			protected.DELETE("/broadcast-lists/:session_id/:list_id/recipients", handlers.RemoveBroadcastListRecipients)
+			protected.POST("/broadcast-lists/:session_id/:list_id/send", handlers.SendBroadcastList)
+
+			// Contact lists (CSV imports)
+			protected.GET("/contact-lists", handlers.GetContactLists)
+			protected.GET("/contact-lists/:list_id", handlers.GetContactList)
+			protected.DELETE("/contact-lists/:list_id", handlers.DeleteContactList)
+
+			// Contact tags
+			protected.GET("/contact-tags", handlers.GetTags)
+			protected.GET("/contact-tags/:jid", handlers.GetContactTags)
+			protected.POST("/contact-tags/:jid", handlers.AddContactTags)
+			protected.DELETE("/contact-tags/:jid/:tag", handlers.RemoveContactTag)
+
+			// Internal notes on contacts and chats
+			protected.GET("/contact-notes/:jid", handlers.GetContactNotes)
+			protected.POST("/contact-notes/:jid", handlers.AddContactNote)
+			protected.PUT("/notes/:note_id", handlers.UpdateNote)
+			protected.DELETE("/notes/:note_id", handlers.DeleteNote)
+
+			// Segments (saved contact filters)
+			protected.POST("/segments", handlers.CreateSegment)
+			protected.GET("/segments", handlers.GetSegments)
+			protected.GET("/segments/:segment_id", handlers.GetSegment)
+			protected.PUT("/segments/:segment_id", handlers.UpdateSegment)
+			protected.DELETE("/segments/:segment_id", handlers.DeleteSegment)
+			protected.GET("/segments/:segment_id/contacts", handlers.GetSegmentContacts)
+
+			// Channels (primary session with a warm standby)
+			protected.POST("/channels", handlers.CreateChannel)
+			protected.GET("/channels", handlers.GetChannels)
+			protected.GET("/channels/:channel_id", handlers.GetChannel)
+			protected.DELETE("/channels/:channel_id", handlers.DeleteChannel)
+
+			// Canned responses
+			protected.POST("/canned-responses", handlers.CreateCannedResponse)
+			protected.GET("/canned-responses", handlers.GetCannedResponses)
+			protected.GET("/canned-responses/:response_id", handlers.GetCannedResponse)
+			protected.PUT("/canned-responses/:response_id", handlers.UpdateCannedResponse)
+			protected.DELETE("/canned-responses/:response_id", handlers.DeleteCannedResponse)
+
+			// Sequences (timed follow-up messages)
+			protected.POST("/sequences", handlers.CreateSequence)
+			protected.GET("/sequences", handlers.GetSequences)
+			protected.GET("/sequences/:sequence_id", handlers.GetSequence)
+			protected.PUT("/sequences/:sequence_id", handlers.UpdateSequence)
+			protected.DELETE("/sequences/:sequence_id", handlers.DeleteSequence)
+			protected.POST("/sequences/:sequence_id/enroll", handlers.EnrollInSequence)
+			protected.GET("/sequences/:sequence_id/enrollments", handlers.GetSequenceEnrollments)
+			protected.DELETE("/sequences/:sequence_id/enrollments/:contact", handlers.UnenrollFromSequence)
+
+			// Projects (groups of sessions)
+			protected.POST("/projects", handlers.CreateProject)
+			protected.GET("/projects", handlers.GetProjects)
+			protected.GET("/projects/:project_id", handlers.GetProject)
+			protected.PUT("/projects/:project_id", handlers.UpdateProject)
+			protected.DELETE("/projects/:project_id", handlers.DeleteProject)
+			protected.GET("/projects/:project_id/summary", handlers.GetProjectSummary)
+
+			// Message templates
+			protected.POST("/templates/preview", handlers.PreviewTemplate)
+
+			// Campaigns
+			protected.POST("/campaigns", handlers.CreateCampaign)
+			protected.GET("/campaigns", handlers.GetCampaigns)
+			protected.GET("/campaigns/:campaign_id", handlers.GetCampaign)
+			protected.GET("/campaigns/:campaign_id/recipients", handlers.GetCampaignRecipients)
+			protected.POST("/campaigns/:campaign_id/cancel", handlers.CancelCampaign)
+			protected.POST("/campaigns/:campaign_id/retry-failed", handlers.RetryFailedCampaign)
+
+			// Status posts (stories)
+			protected.POST("/status/:session_id", handlers.CreateStatusPost)
+			protected.GET("/status/:session_id", handlers.GetStatusPosts)
+			protected.GET("/status/:session_id/:status_id", handlers.GetStatusPost)
+			protected.DELETE("/status/:session_id/:status_id", handlers.CancelStatusPost)
+
+			// Auto-reply rules
+			protected.POST("/auto-replies", handlers.CreateAutoReplyRule)
+			protected.GET("/auto-replies", handlers.GetAutoReplyRules)
+			protected.GET("/auto-replies/:rule_id", handlers.GetAutoReplyRule)
+			protected.PUT("/auto-replies/:rule_id", handlers.UpdateAutoReplyRule)
+			protected.DELETE("/auto-replies/:rule_id", handlers.DeleteAutoReplyRule)
+
+			// Lead scoring
+			protected.POST("/scoring-rules", handlers.CreateScoringRule)
+			protected.GET("/scoring-rules", handlers.GetScoringRules)
+			protected.GET("/scoring-rules/:rule_id", handlers.GetScoringRule)
+			protected.PUT("/scoring-rules/:rule_id", handlers.UpdateScoringRule)
+			protected.DELETE("/scoring-rules/:rule_id", handlers.DeleteScoringRule)
+			protected.GET("/lead-settings", handlers.GetLeadSettings)
+			protected.PUT("/lead-settings", handlers.UpdateLeadSettings)
+			protected.GET("/contact-scores", handlers.GetContactScores)
+			protected.GET("/contact-scores/:jid", handlers.GetContactScore)
+			protected.DELETE("/contact-scores/:jid", handlers.ResetContactScore)
+
+			// Suppression list (opt-outs)
+			protected.GET("/suppressions", handlers.GetSuppressions)
+			protected.POST("/suppressions", handlers.AddSuppressions)
+			protected.DELETE("/suppressions/:phone", handlers.RemoveSuppression)
+
+			// Contacts
+			protected.GET("/contacts", handlers.GetContacts)
+			protected.POST("/contacts/:session_id/check", handlers.CheckContactsExist)
+			protected.POST("/contacts/:session_id/import", handlers.ImportContacts)
+			protected.POST("/contacts/:session_id/sync", handlers.SyncContacts)
+			protected.GET("/contacts/:session_id/blocklist", handlers.GetBlocklist)
+			protected.POST("/contacts/:session_id/blocklist/sync", handlers.SyncBlocklist)
+			protected.GET("/contacts/:session_id/:jid/picture.png", handlers.GetContactPicture)
+			protected.GET("/contacts/:session_id/:jid/picture/history", handlers.GetContactPictureHistory)
+
+			// Groups
+			protected.GET("/groups", handlers.GetGroups)
+			protected.GET("/groups/invite-info", handlers.GetGroupInviteInfo)
+			protected.POST("/groups/create-and-invite", handlers.CreateGroupAndInvite)
+			protected.POST("/groups/:session_id/sync", handlers.StartGroupSync)
+			protected.GET("/groups/:session_id/sync", handlers.GetGroupSync)
+			protected.GET("/groups/:session_id/:group_id/invite-link", handlers.GetGroupInviteLink)
+			protected.POST("/groups/:session_id/:group_id/invite-link/revoke", handlers.RevokeGroupInviteLink)
+			protected.GET("/groups/:session_id/:group_id/invite-links", handlers.GetGroupInviteLinks)
+			protected.PATCH("/groups/:session_id/:group_id/settings", handlers.UpdateGroupSettings)
+			protected.GET("/groups/:session_id/:group_id/schedule", handlers.GetGroupSchedule)
+			protected.PUT("/groups/:session_id/:group_id/schedule", handlers.SetGroupSchedule)
+			protected.DELETE("/groups/:session_id/:group_id/schedule", handlers.DeleteGroupSchedule)
+			protected.POST("/groups/:session_id/:group_id/schedule/enable", handlers.EnableGroupSchedule)
+			protected.POST("/groups/:session_id/:group_id/schedule/disable", handlers.DisableGroupSchedule)
+			protected.POST("/groups/:session_id/:group_id/participants", handlers.AddGroupParticipants)
+			protected.GET("/groups/:session_id/:group_id/participants/export", handlers.ExportGroupParticipants)
+			protected.POST("/groups/:session_id/:group_id/participants/contact-list", handlers.ImportGroupParticipants)
+			protected.GET("/groups/:session_id/:group_id/analytics", handlers.GetGroupAnalytics)
+			protected.GET("/groups/:session_id/:group_id/requests", handlers.GetGroupJoinRequests)
+			protected.POST("/groups/:session_id/:group_id/requests/approve", handlers.ApproveGroupJoinRequests)
+			protected.POST("/groups/:session_id/:group_id/requests/reject", handlers.RejectGroupJoinRequests)
+			protected.PUT("/groups/:session_id/:group_id/requests/mode", handlers.SetGroupJoinApproval)
+
+			// Chats and message history
+			protected.GET("/chats/:session_id", handlers.GetChats)
+			protected.GET("/chats/:session_id/:jid/messages", handlers.GetChatMessages)
+			protected.GET("/chats/:session_id/:jid/messages/:message_id/media", handlers.GetMessageMedia)
+			protected.GET("/chats/:session_id/:jid/export", handlers.ExportChat)
+			protected.POST("/chats/:session_id/:jid/read", handlers.MarkChatRead)
+			protected.POST("/chats/:session_id/:jid/unread", handlers.MarkChatUnread)
+			protected.POST("/chats/:session_id/:jid/archive", handlers.ArchiveChat)
+			protected.POST("/chats/:session_id/:jid/pin", handlers.PinChat)
+			protected.POST("/chats/:session_id/:jid/mute", handlers.MuteChat)
+			protected.GET("/chats/:session_id/:jid/notes", handlers.GetChatNotes)
+			protected.POST("/chats/:session_id/:jid/notes", handlers.AddChatNote)
+			protected.GET("/exports/:export_id", handlers.GetChatExport)
+			protected.GET("/exports/:export_id/download", handlers.DownloadChatExport)
 
 			// Device summary
 			protected.GET("/devices/summary", handlers.GetDeviceSummary)
 
+			// Monthly message usage and quota
+			protected.GET("/usage", handlers.GetUsage)
+
 			// Account validation
 			protected.POST("/validate-account", handlers.ValidateAccount)
+			protected.POST("/utils/parse-numbers", handlers.ParseNumbers)
+
+			// Audit log of mutating calls
+			protected.GET("/audit", handlers.GetAuditLogs)
+
+			// Stored events
+			protected.GET("/events", handlers.ListEvents)
+			protected.GET("/events/statistics", handlers.GetEventStatistics)
+
+			// Event stream filters
+			protected.GET("/event-filters", handlers.GetEventFilters)
+			protected.PUT("/event-filter", handlers.SetEventFilter)
+			protected.DELETE("/event-filter", handlers.DeleteEventFilter)
+			protected.PUT("/sessions/:session_id/event-filter", handlers.SetEventFilter)
+			protected.DELETE("/sessions/:session_id/event-filter", handlers.DeleteEventFilter)
 		}
 
-		// WebSocket endpoint (uses token query param)
+		// WebSocket and SSE endpoints (use token query param)
 		v1.GET("/sessions/:session_id/events", handlers.HandleWebSocket)
+		v1.GET("/ws", handlers.HandleUserWebSocket)
+		v1.GET("/sessions/:session_id/events/sse", handlers.HandleSSE)
+		v1.GET("/sessions/:session_id/qr/stream", handlers.HandleQRStream)
+
+		// Signed links to locally stored media (authenticated by the signature)
+		v1.GET("/media/files/*key", handlers.ServeMediaFile)
 	}
 
 	// Start server
@@ -214,6 +1026,12 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Start gRPC server (only when GRPC_PORT is set)
+	grpcServer, err := StartGRPCServer(cfg, handlers)
+	if err != nil {
+		log.Fatalf("Failed to start gRPC server: %v", err)
+	}
+
 	// Graceful shutdown
 	go func() {
 		log.Printf("Starting server on port %s", cfg.AppPort)
@@ -239,6 +1057,9 @@ func main() {
 	// Stop session monitor
 	whatsappService.StopSessionMonitor()
 
+	// Let running jobs finish
+	whatsappService.StopJobs(shutdownCtx)
+
 	// Cleanup WhatsApp resources
 	whatsappService.Cleanup()
 
@@ -246,5 +1067,10 @@ func main() {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	// Event streams never finish on their own, so gRPC calls are cut off
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
+
 	log.Println("Server shutdown complete")
 }
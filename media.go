@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+// ============= MEDIA =============
+// Media is uploaded (encrypted) to WhatsApp servers first and the resulting
+// keys/paths are attached to the message. Streamed uploads read the body
+// straight into whatsmeow, which only keeps the encrypted copy in a temp file.
+
+// Uploaded media only stays on WhatsApp's CDN for a limited time, so handles
+// are not handed out for longer than this
+const mediaHandleTTL = 7 * 24 * time.Hour
+
+// ErrMediaTooLarge is returned when media exceeds the configured size limit
+var ErrMediaTooLarge = errors.New("media file too large")
+
+// MediaUpload is an attachment already uploaded to WhatsApp servers
+type MediaUpload struct {
+	whatsmeow.UploadResponse
+	MediaType string          `json:"media_type"` // image, video, audio or document
+	Mimetype  string          `json:"mimetype"`
+	Filename  string          `json:"filename,omitempty"`
+	Thumbnail *MediaThumbnail `json:"-"`
+}
+
+// maxMediaSize returns the configured size limit of a media type
+func (cfg *Config) maxMediaSize(mediaType string) int64 {
+	switch mediaType {
+	case "image":
+		return cfg.MaxImageSize
+	case "video":
+		return cfg.MaxVideoSize
+	case "audio":
+		return cfg.MaxAudioSize
+	default:
+		return cfg.MaxDocumentSize
+	}
+}
+
+func whatsmeowMediaType(mediaType string) (whatsmeow.MediaType, error) {
+	switch mediaType {
+	case "image":
+		return whatsmeow.MediaImage, nil
+	case "video":
+		return whatsmeow.MediaVideo, nil
+	case "audio":
+		return whatsmeow.MediaAudio, nil
+	case "document":
+		return whatsmeow.MediaDocument, nil
+	default:
+		return "", fmt.Errorf("invalid media type %q", mediaType)
+	}
+}
+
+// sniffMimetype picks the MIME type of an attachment: the given one, else the
+// sniffed content type, else one guessed from the filename
+func sniffMimetype(mediaType, mimetype, filename string, head []byte) string {
+	if mimetype != "" && mimetype != "application/octet-stream" {
+		return mimetype
+	}
+
+	mimetype = http.DetectContentType(head)
+	if mimetype != "application/octet-stream" {
+		return mimetype
+	}
+	if byExt := mime.TypeByExtension(filepath.Ext(filename)); byExt != "" {
+		return byExt
+	}
+
+	switch mediaType {
+	case "video":
+		return "video/mp4"
+	case "audio":
+		return "audio/ogg; codecs=opus" // Default for voice notes
+	default:
+		return mimetype
+	}
+}
+
+// detectMediaType maps a MIME type (given, derived from the filename or sniffed
+// from the content) to image, video, audio or document
+func detectMediaType(mimetype, filename string, data []byte) string {
+	if mimetype == "" && filename != "" {
+		mimetype = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	if mimetype == "" {
+		mimetype = http.DetectContentType(data)
+	}
+
+	switch {
+	case strings.HasPrefix(mimetype, "image/"):
+		return "image"
+	case strings.HasPrefix(mimetype, "video/"):
+		return "video"
+	case strings.HasPrefix(mimetype, "audio/"):
+		return "audio"
+	default:
+		return "document"
+	}
+}
+
+// limitedReader fails with ErrMediaTooLarge instead of silently truncating
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrMediaTooLarge
+	}
+	return n, err
+}
+
+// UploadMediaStream uploads media from a reader without buffering it in memory
+func (ws *WhatsAppService) UploadMediaStream(sessionID string, userID int, mediaType string, r io.Reader, mimetype, filename string) (*MediaUpload, error) {
+	sc, err := ws.getConnectedClient(sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return ws.uploadMediaStream(sc, mediaType, r, mimetype, filename, ws.cfg.maxMediaSize(mediaType))
+}
+
+func (ws *WhatsAppService) uploadMediaStream(sc *SessionClient, mediaType string, r io.Reader, mimetype, filename string, maxSize int64) (*MediaUpload, error) {
+	appInfo, err := whatsmeowMediaType(mediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(512)
+	if len(head) == 0 {
+		return nil, fmt.Errorf("media file is empty")
+	}
+	mimetype = sniffMimetype(mediaType, mimetype, filename, head)
+
+	tempFile, err := os.CreateTemp("", "wa-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer func() {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+	}()
+
+	var plaintext io.Reader = &limitedReader{r: br, remaining: maxSize}
+
+	// Keep a plaintext copy on disk to build the thumbnail from
+	var plainFile *os.File
+	if wantsThumbnail(mediaType, mimetype) {
+		if plainFile, err = os.CreateTemp("", "wa-plain-*"); err == nil {
+			defer func() {
+				plainFile.Close()
+				os.Remove(plainFile.Name())
+			}()
+			plaintext = io.TeeReader(plaintext, plainFile)
+		} else {
+			log.Printf("⚠️  No thumbnail for %s: %v", mimetype, err)
+		}
+	}
+
+	log.Printf("📤 Streaming upload of %s media", mediaType)
+
+	var resp whatsmeow.UploadResponse
+	err = ws.callWhatsApp(sc, priorityInteractive, "upload media", func() error {
+		var err error
+		resp, err = sc.Client.UploadReader(context.Background(), plaintext, tempFile, appInfo)
+		return err
+	})
+	var limitErr *SendLimitError
+	if errors.As(throttledSend(err), &limitErr) {
+		return nil, limitErr
+	} else if errors.Is(err, ErrMediaTooLarge) {
+		return nil, fmt.Errorf("%w: max %d bytes for %s", ErrMediaTooLarge, maxSize, mediaType)
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %w", errMediaUploadFailed, err)
+	}
+
+	log.Printf("✅ Media uploaded successfully - URL: %s (%d bytes)", resp.URL, resp.FileLength)
+
+	media := &MediaUpload{
+		UploadResponse: resp,
+		MediaType:      mediaType,
+		Mimetype:       mimetype,
+		Filename:       filename,
+	}
+	if plainFile != nil {
+		media.Thumbnail = generateThumbnailFromFile(mimetype, plainFile.Name())
+	}
+	return media, nil
+}
+
+// uploadMediaBytes uploads in-memory media
+func (ws *WhatsAppService) uploadMediaBytes(sc *SessionClient, mediaType string, data []byte, mimetype, filename string) (*MediaUpload, error) {
+	appInfo, err := whatsmeowMediaType(mediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded, err := ws.uploadMedia(sc, data, appInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	media := &MediaUpload{
+		UploadResponse: *uploaded,
+		MediaType:      mediaType,
+		Mimetype:       sniffMimetype(mediaType, mimetype, filename, head),
+		Filename:       filename,
+	}
+	if wantsThumbnail(mediaType, media.Mimetype) {
+		media.Thumbnail = generateThumbnail(media.Mimetype, data)
+	}
+	return media, nil
+}
+
+// SendMediaUpload sends an already uploaded attachment
+func (ws *WhatsAppService) SendMediaUpload(sessionID string, userID int, to string, media *MediaUpload, caption string, isVoice bool) (*MessageResponse, error) {
+	sc, err := ws.getConnectedClient(sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient, err := ws.validateAndGetRecipient(sc, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return ws.sendMedia(sc, recipient, media, caption, isVoice)
+}
+
+// buildMediaMessage attaches an upload to the message type matching its media type
+func buildMediaMessage(media *MediaUpload, caption string, isVoice bool) *waE2E.Message {
+	thumb := media.Thumbnail
+	if thumb == nil {
+		thumb = &MediaThumbnail{}
+	}
+
+	switch media.MediaType {
+	case "image":
+		return &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+			Caption:       proto.String(caption),
+			Mimetype:      proto.String(media.Mimetype),
+			URL:           proto.String(media.URL),
+			DirectPath:    proto.String(media.DirectPath),
+			MediaKey:      media.MediaKey,
+			FileEncSHA256: media.FileEncSHA256,
+			FileSHA256:    media.FileSHA256,
+			FileLength:    proto.Uint64(media.FileLength),
+			JPEGThumbnail: thumb.JPEG,
+			Width:         optionalUint32(thumb.Width),
+			Height:        optionalUint32(thumb.Height),
+		}}
+	case "video":
+		return &waE2E.Message{VideoMessage: &waE2E.VideoMessage{
+			Caption:       proto.String(caption),
+			Mimetype:      proto.String(media.Mimetype),
+			URL:           proto.String(media.URL),
+			DirectPath:    proto.String(media.DirectPath),
+			MediaKey:      media.MediaKey,
+			FileEncSHA256: media.FileEncSHA256,
+			FileSHA256:    media.FileSHA256,
+			FileLength:    proto.Uint64(media.FileLength),
+			JPEGThumbnail: thumb.JPEG,
+			Width:         optionalUint32(thumb.Width),
+			Height:        optionalUint32(thumb.Height),
+		}}
+	case "audio":
+		return &waE2E.Message{AudioMessage: &waE2E.AudioMessage{
+			Mimetype:      proto.String(media.Mimetype),
+			URL:           proto.String(media.URL),
+			DirectPath:    proto.String(media.DirectPath),
+			MediaKey:      media.MediaKey,
+			FileEncSHA256: media.FileEncSHA256,
+			FileSHA256:    media.FileSHA256,
+			FileLength:    proto.Uint64(media.FileLength),
+			PTT:           proto.Bool(isVoice), // PTT = Push To Talk (voice note)
+		}}
+	default:
+		filename := media.Filename
+		if filename == "" {
+			filename = "document"
+		}
+		docMsg := &waE2E.DocumentMessage{
+			FileName:      proto.String(filename),
+			Mimetype:      proto.String(media.Mimetype),
+			URL:           proto.String(media.URL),
+			DirectPath:    proto.String(media.DirectPath),
+			MediaKey:      media.MediaKey,
+			FileEncSHA256: media.FileEncSHA256,
+			FileSHA256:    media.FileSHA256,
+			FileLength:    proto.Uint64(media.FileLength),
+			JPEGThumbnail: thumb.JPEG,
+		}
+		if caption != "" {
+			docMsg.Caption = proto.String(caption)
+		}
+		return &waE2E.Message{DocumentMessage: docMsg}
+	}
+}
+
+func optionalUint32(value uint32) *uint32 {
+	if value == 0 {
+		return nil
+	}
+	return proto.Uint32(value)
+}
+
+// downloadableMedia is implemented by the media message types
+type downloadableMedia interface {
+	GetMimetype() string
+	GetDirectPath() string
+	GetFileLength() uint64
+	GetFileSHA256() []byte
+	GetMediaKey() []byte
+}
+
+// mediaReference returns what is needed to download the attachment of a
+// received media message later (whatsmeow's DownloadMediaWithPath), or nil
+// for messages without one
+func mediaReference(msg *waE2E.Message) JSONData {
+	msg, _, _ = unwrapMessage(msg)
+	var (
+		media    downloadableMedia
+		caption  string
+		fileName string
+	)
+	switch {
+	case msg.GetImageMessage() != nil:
+		media, caption = msg.GetImageMessage(), msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage() != nil:
+		media, caption = msg.GetVideoMessage(), msg.GetVideoMessage().GetCaption()
+	case msg.GetAudioMessage() != nil:
+		media = msg.GetAudioMessage()
+	case msg.GetDocumentMessage() != nil:
+		doc := msg.GetDocumentMessage()
+		media, caption, fileName = doc, doc.GetCaption(), doc.GetFileName()
+	case msg.GetStickerMessage() != nil:
+		media = msg.GetStickerMessage()
+	default:
+		return nil
+	}
+
+	ref := JSONData{
+		"mimetype":    media.GetMimetype(),
+		"file_length": media.GetFileLength(),
+		"direct_path": media.GetDirectPath(),
+		"file_sha256": base64.StdEncoding.EncodeToString(media.GetFileSHA256()),
+		"media_key":   base64.StdEncoding.EncodeToString(media.GetMediaKey()),
+	}
+	if caption != "" {
+		ref["caption"] = caption
+	}
+	if fileName != "" {
+		ref["file_name"] = fileName
+	}
+	return ref
+}
+
+// sendMedia sends an uploaded attachment to a resolved recipient
+func (ws *WhatsAppService) sendMedia(sc *SessionClient, recipient types.JID, media *MediaUpload, caption string, isVoice bool) (*MessageResponse, error) {
+	messageType := media.MediaType
+	if messageType == "audio" && isVoice {
+		messageType = "voice"
+	}
+
+	resp, err := ws.sendMessage(sc, recipient, buildMediaMessage(media, caption, isVoice))
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s message: %w", media.MediaType, err)
+	}
+
+	log.Printf("✅ %s message sent to %s (ID: %s)", messageType, recipient.String(), resp.ID)
+
+	data := map[string]interface{}{
+		"message_id": resp.ID,
+		"to":         recipient.String(),
+		"type":       messageType,
+		"timestamp":  resp.Timestamp,
+	}
+	if media.MediaType == "document" {
+		data["filename"] = media.Filename
+	}
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "message_sent",
+		Data: data,
+	})
+
+	return newMessageResponse(resp, recipient, messageType), nil
+}
+
+// ============= MEDIA HANDLES =============
+
+// UploadMediaBytes uploads in-memory media without sending it
+func (ws *WhatsAppService) UploadMediaBytes(sessionID string, userID int, mediaType string, data []byte, mimetype, filename string) (*MediaUpload, error) {
+	sc, err := ws.getConnectedClient(sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize := ws.cfg.maxMediaSize(mediaType); int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: max %d bytes for %s", ErrMediaTooLarge, maxSize, mediaType)
+	}
+	return ws.uploadMediaBytes(sc, mediaType, data, mimetype, filename)
+}
+
+// CreateMediaHandle stores an upload so later sends can reference it by ID
+// instead of uploading the same file again
+func (ws *WhatsAppService) CreateMediaHandle(sessionID string, userID int, media *MediaUpload) (*WhatsAppMediaHandle, error) {
+	handle := &WhatsAppMediaHandle{
+		ID:            uuid.New().String(),
+		UserID:        userID,
+		SessionID:     sessionID,
+		MediaType:     media.MediaType,
+		Mimetype:      media.Mimetype,
+		Filename:      media.Filename,
+		URL:           media.URL,
+		DirectPath:    media.DirectPath,
+		MediaKey:      media.MediaKey,
+		FileSHA256:    media.FileSHA256,
+		FileEncSHA256: media.FileEncSHA256,
+		FileLength:    media.FileLength,
+		ExpiresAt:     time.Now().Add(mediaHandleTTL),
+	}
+	if media.Thumbnail != nil {
+		handle.JPEGThumbnail = media.Thumbnail.JPEG
+		handle.Width = media.Thumbnail.Width
+		handle.Height = media.Thumbnail.Height
+	}
+	if err := ws.db.CreateMediaHandle(handle); err != nil {
+		return nil, fmt.Errorf("failed to save media handle: %w", err)
+	}
+
+	if purged, err := ws.db.DeleteExpiredMediaHandles(time.Now()); err != nil {
+		log.Printf("⚠️  Failed to purge expired media handles: %v", err)
+	} else if purged > 0 {
+		log.Printf("🧹 Purged %d expired media handle(s)", purged)
+	}
+
+	log.Printf("📎 Media handle %s created (%s, %d bytes)", handle.ID, handle.MediaType, handle.FileLength)
+	return handle, nil
+}
+
+// GetMediaHandle loads a media handle for reuse. Handles belong to the session
+// that uploaded them.
+func (ws *WhatsAppService) GetMediaHandle(sessionID string, userID int, mediaID string) (*MediaUpload, error) {
+	handle, err := ws.db.GetMediaHandle(mediaID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("media handle not found")
+		}
+		return nil, fmt.Errorf("failed to load media handle: %w", err)
+	}
+	if handle.SessionID != sessionID {
+		return nil, fmt.Errorf("media handle not found for this session")
+	}
+	if time.Now().After(handle.ExpiresAt) {
+		return nil, fmt.Errorf("media handle has expired, upload the file again")
+	}
+
+	media := &MediaUpload{
+		UploadResponse: whatsmeow.UploadResponse{
+			URL:           handle.URL,
+			DirectPath:    handle.DirectPath,
+			MediaKey:      handle.MediaKey,
+			FileEncSHA256: handle.FileEncSHA256,
+			FileSHA256:    handle.FileSHA256,
+			FileLength:    handle.FileLength,
+		},
+		MediaType: handle.MediaType,
+		Mimetype:  handle.Mimetype,
+		Filename:  handle.Filename,
+	}
+	if len(handle.JPEGThumbnail) > 0 {
+		media.Thumbnail = &MediaThumbnail{
+			JPEG:   handle.JPEGThumbnail,
+			Width:  handle.Width,
+			Height: handle.Height,
+		}
+	}
+	return media, nil
+}
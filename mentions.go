@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= MENTION ALL =============
+// A text to a group with mention_all mentions every participant without
+// listing them in the text (a hidden mention): the participants are fetched
+// from WhatsApp and put in the message's MentionedJID, so each of them gets a
+// mention notification even when the group is muted. Groups with more than
+// MENTION_ALL_MAX_PARTICIPANTS participants are refused; 0 disables it.
+
+// SendMentionAll sends a text to a group mentioning all of its participants
+func (ws *WhatsAppService) SendMentionAll(sessionID string, userID int, group, content string) (*MessageResponse, error) {
+	maxParticipants := ws.cfg.MentionAllMaxParticipants
+	if maxParticipants == 0 {
+		return nil, fmt.Errorf("%w: mention_all is disabled", apierr.ErrForbidden)
+	}
+
+	sc, groupJID, err := ws.getGroupTarget(sessionID, userID, group)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	var info *types.GroupInfo
+	err = ws.callWhatsApp(sc, priorityInteractive, "get group info", func() error {
+		var err error
+		info, err = sc.Client.GetGroupInfo(ctx, groupJID)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, whatsmeow.ErrGroupNotFound) || errors.Is(err, whatsmeow.ErrNotInGroup) {
+			return nil, fmt.Errorf("group not found: %w", err)
+		}
+		return nil, fmt.Errorf("failed to get group info: %w", err)
+	}
+	if len(info.Participants) > maxParticipants {
+		return nil, fmt.Errorf("%w: the group has %d participants, mention_all is limited to %d",
+			apierr.ErrInvalidRequest, len(info.Participants), maxParticipants)
+	}
+
+	mentions := make([]string, 0, len(info.Participants))
+	for _, participant := range info.Participants {
+		if sc.Device.ID != nil && participant.JID.User == sc.Device.ID.User {
+			continue
+		}
+		if !sc.Device.LID.IsEmpty() && participant.JID.User == sc.Device.LID.User {
+			continue
+		}
+		mentions = append(mentions, participant.JID.String())
+	}
+
+	resp, err := ws.sendMentionTextToJID(sc, groupJID, content, mentions)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("📣 Mentioned %d participant(s) of %s", len(mentions), groupJID.String())
+	return newMessageResponse(*resp, groupJID, "text"), nil
+}
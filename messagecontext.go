@@ -0,0 +1,87 @@
+package main
+
+import (
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// ============= MESSAGE CONTEXT INFO =============
+// Replies, mentions and forwards are described by the ContextInfo of the
+// content message. For received messages the ID and sender of the message
+// replied to, the mentioned JIDs and the forwarding flag and score are stored
+// on the message and sent with the message events, so clients can thread
+// conversations.
+
+// contextInfoCarrier is a content message that may carry a ContextInfo
+type contextInfoCarrier interface {
+	GetContextInfo() *waE2E.ContextInfo
+}
+
+// messageContextInfo returns the ContextInfo of a message, if any
+func messageContextInfo(msg *waE2E.Message) *waE2E.ContextInfo {
+	inner, _, _ := unwrapMessage(msg)
+	if inner == nil {
+		return nil
+	}
+	carriers := []contextInfoCarrier{
+		inner.GetExtendedTextMessage(),
+		inner.GetImageMessage(),
+		inner.GetVideoMessage(),
+		inner.GetAudioMessage(),
+		inner.GetDocumentMessage(),
+		inner.GetStickerMessage(),
+		inner.GetLocationMessage(),
+		inner.GetLiveLocationMessage(),
+		inner.GetContactMessage(),
+		inner.GetContactsArrayMessage(),
+		inner.GetPollCreationMessage(),
+		inner.GetPollCreationMessageV3(),
+		inner.GetButtonsResponseMessage(),
+		inner.GetListResponseMessage(),
+		inner.GetTemplateButtonReplyMessage(),
+	}
+	for _, carrier := range carriers {
+		if info := carrier.GetContextInfo(); info != nil {
+			return info
+		}
+	}
+	return nil
+}
+
+// applyMessageContext copies the context info of a received message to its
+// stored form
+func applyMessageContext(stored *WhatsAppMessage, msg *waE2E.Message) {
+	info := messageContextInfo(msg)
+	if info == nil {
+		return
+	}
+
+	stored.QuotedMessageID = info.GetStanzaID()
+	if participant := info.GetParticipant(); participant != "" {
+		if jid, err := types.ParseJID(participant); err == nil {
+			participant = jid.ToNonAD().String()
+		}
+		stored.QuotedSenderJID = participant
+	}
+	if mentions := info.GetMentionedJID(); len(mentions) > 0 {
+		stored.MentionedJIDs = mentions
+	}
+	stored.IsForwarded = info.GetIsForwarded()
+	stored.ForwardingScore = info.GetForwardingScore()
+}
+
+// addMessageContext adds the context info of a stored message to event data;
+// messages without one get no extra fields
+func addMessageContext(data map[string]interface{}, stored *WhatsAppMessage) {
+	if stored.QuotedMessageID != "" {
+		data["quoted_message_id"] = stored.QuotedMessageID
+		data["quoted_sender_jid"] = stored.QuotedSenderJID
+	}
+	if len(stored.MentionedJIDs) > 0 {
+		data["mentioned_jids"] = stored.MentionedJIDs
+	}
+	if stored.IsForwarded {
+		data["is_forwarded"] = true
+		data["forwarding_score"] = stored.ForwardingScore
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"gorm.io/gorm"
+)
+
+// ============= REACTIONS, EDITS AND REVOKES =============
+// Reactions, edits and "delete for everyone" arrive as messages that refer
+// to an earlier message. They aren't stored as messages of their own: the
+// referenced stored message is updated (reactions array, edited content,
+// revoked flag) and message_reaction, message_edited or message_revoked is
+// emitted, also when the referenced message isn't stored.
+
+// handleMessageUpdate applies a reaction, edit or revoke message and reports
+// whether the message was one
+func (ws *WhatsAppService) handleMessageUpdate(sc *SessionClient, evt *events.Message) bool {
+	if reaction := evt.Message.GetReactionMessage(); reaction != nil {
+		ws.db.FlushWrites() // the message may still be in the batch writer
+		ws.applyReaction(sc, evt, reaction)
+		return true
+	}
+
+	protocol := evt.Message.GetProtocolMessage()
+	if protocol == nil || protocol.Type == nil || protocol.GetKey().GetID() == "" {
+		return false
+	}
+	switch protocol.GetType() {
+	case waE2E.ProtocolMessage_MESSAGE_EDIT:
+		ws.db.FlushWrites()
+		ws.applyEdit(sc, evt, protocol)
+		return true
+	case waE2E.ProtocolMessage_REVOKE:
+		ws.db.FlushWrites()
+		ws.applyRevoke(sc, evt, protocol)
+		return true
+	}
+	return false
+}
+
+// applyReaction replaces the sender's reaction to a message; an empty
+// reaction removes it
+func (ws *WhatsAppService) applyReaction(sc *SessionClient, evt *events.Message, reaction *waE2E.ReactionMessage) {
+	chatJID := evt.Info.Chat.String()
+	targetID := reaction.GetKey().GetID()
+	sender := evt.Info.Sender.ToNonAD().String()
+	emoji := reaction.GetText()
+
+	reactedAt := evt.Info.Timestamp
+	if ms := reaction.GetSenderTimestampMS(); ms > 0 {
+		reactedAt = time.UnixMilli(ms)
+	}
+
+	message, err := ws.db.GetMessage(sc.SessionID, chatJID, targetID)
+	switch {
+	case err == nil:
+		reactions := make(MessageReactions, 0, len(message.Reactions)+1)
+		for _, existing := range message.Reactions {
+			if existing.SenderJID != sender {
+				reactions = append(reactions, existing)
+			}
+		}
+		if emoji != "" {
+			reactions = append(reactions, MessageReaction{SenderJID: sender, Emoji: emoji, Timestamp: reactedAt})
+		}
+		if _, err := ws.db.UpdateMessage(sc.SessionID, chatJID, targetID, map[string]interface{}{"reactions": reactions}); err != nil {
+			log.Printf("⚠️  Failed to store reaction to message %s for session %s: %v", targetID, sc.SessionID, err)
+		}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		log.Printf("⚠️  Failed to load message %s for session %s: %v", targetID, sc.SessionID, err)
+	}
+
+	ws.emitMessageUpdate(sc, "message_reaction", map[string]interface{}{
+		"message_id": targetID,
+		"chat":       chatJID,
+		"from":       sender,
+		"emoji":      emoji,
+		"removed":    emoji == "",
+		"timestamp":  reactedAt,
+	})
+}
+
+// applyEdit replaces the content of an edited message
+func (ws *WhatsAppService) applyEdit(sc *SessionClient, evt *events.Message, protocol *waE2E.ProtocolMessage) {
+	chatJID := evt.Info.Chat.String()
+	targetID := protocol.GetKey().GetID()
+	content := ws.extractMessageContent(protocol.GetEditedMessage())
+
+	_, err := ws.db.UpdateMessage(sc.SessionID, chatJID, targetID, map[string]interface{}{
+		"content":   content,
+		"edited_at": evt.Info.Timestamp,
+	})
+	if err != nil {
+		log.Printf("⚠️  Failed to store edit of message %s for session %s: %v", targetID, sc.SessionID, err)
+	}
+
+	ws.emitMessageUpdate(sc, "message_edited", map[string]interface{}{
+		"message_id": targetID,
+		"chat":       chatJID,
+		"from":       evt.Info.Sender.ToNonAD().String(),
+		"content":    content,
+		"timestamp":  evt.Info.Timestamp,
+	})
+}
+
+// applyRevoke marks a message deleted for everyone and drops its content
+func (ws *WhatsAppService) applyRevoke(sc *SessionClient, evt *events.Message, protocol *waE2E.ProtocolMessage) {
+	chatJID := evt.Info.Chat.String()
+	targetID := protocol.GetKey().GetID()
+
+	// A saved copy of the media goes with the message
+	if message, err := ws.db.GetMessage(sc.SessionID, chatJID, targetID); err == nil {
+		if key := mediaField(message.Media, "stored_key"); key != "" {
+			if err := ws.media.Delete(context.Background(), key); err != nil {
+				log.Printf("⚠️  Failed to delete saved media of revoked message %s: %v", targetID, err)
+			}
+		}
+	}
+
+	_, err := ws.db.UpdateMessage(sc.SessionID, chatJID, targetID, map[string]interface{}{
+		"revoked":    true,
+		"revoked_at": evt.Info.Timestamp,
+		"content":    "",
+		"media":      nil,
+		"reactions":  nil,
+	})
+	if err != nil {
+		log.Printf("⚠️  Failed to store revoke of message %s for session %s: %v", targetID, sc.SessionID, err)
+	}
+
+	ws.emitMessageUpdate(sc, "message_revoked", map[string]interface{}{
+		"message_id": targetID,
+		"chat":       chatJID,
+		"from":       evt.Info.Sender.ToNonAD().String(),
+		"by_admin":   evt.Info.Edit == types.EditAttributeAdminRevoke,
+		"timestamp":  evt.Info.Timestamp,
+	})
+}
+
+// emitMessageUpdate stores a message update event and pushes it to clients
+func (ws *WhatsAppService) emitMessageUpdate(sc *SessionClient, eventType string, data map[string]interface{}) {
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: eventType,
+		Data: data,
+	})
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, eventType, data)
+}
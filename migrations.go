@@ -0,0 +1,793 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ============= SCHEMA MIGRATIONS =============
+// The application schema is changed by numbered migrations compiled into the
+// binary. Applied versions are recorded in schema_migrations; on startup the
+// pending ones are applied (DB_AUTO_MIGRATE=false refuses to start instead),
+// and `whatsapp-api migrate up|down|status` runs them by hand. Each
+// migration runs in a transaction (MySQL commits DDL statements right away,
+// so there a failed migration can be half applied).
+//
+// Migration 1 is the schema as AutoMigrate creates it from the models, also
+// on databases created before versioned migrations. Changes to models after
+// that need a new migration at the end of the list; applied migrations are
+// never edited.
+
+// SchemaMigration is an applied migration
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false" json:"version"`
+	Name      string    `gorm:"size:255;not null" json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// migration is one schema change; Down is nil for irreversible ones
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// MigrationStatus is a known migration and when it was applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// appModels are the models of the baseline schema
+func appModels() []interface{} {
+	return []interface{}{
+		&WhatsAppSession{}, &WhatsAppEvent{}, &WhatsAppContact{}, &WhatsAppGroup{},
+		&WhatsAppChat{}, &WhatsAppMessage{},
+		&WhatsAppBroadcastList{}, &WhatsAppBroadcastRecipient{},
+		&WhatsAppGroupSchedule{}, &WhatsAppAvatar{}, &WhatsAppJIDCache{}, &WhatsAppNumberInfo{},
+		&WhatsAppMediaHandle{}, &WhatsAppOutboxMessage{}, &WhatsAppSuppression{},
+		&WhatsAppSafetyCounter{}, &WhatsAppContactList{}, &WhatsAppContactListMember{},
+		&WhatsAppCampaign{}, &WhatsAppCampaignRecipient{}, &WhatsAppStatusPost{},
+		&WhatsAppCall{}, &WhatsAppContactTag{}, &WhatsAppSegment{}, &WhatsAppAutoReplyRule{},
+		&WhatsAppChatExport{}, &WhatsAppAuditLog{}, &WhatsAppGroupSyncJob{},
+		&WhatsAppUserQuota{}, &WhatsAppSendIntent{}, &WhatsAppGroupDailyStat{}, &WhatsAppAggregationCursor{},
+		&WhatsAppUsageCounter{}, &WhatsAppUsageQuota{}, &WhatsAppQRAttempt{}, &WhatsAppPictureChange{},
+		&WhatsAppBlockedContact{}, &WhatsAppChannel{}, &WhatsAppProject{},
+		&WhatsAppBackup{}, &WhatsAppGroupInviteLink{}, &WhatsAppEventFilter{},
+		&WhatsAppReplicaHeartbeat{}, &WhatsAppLIDMapping{}, &WhatsAppSenderRule{}, &WhatsAppNote{},
+		&WhatsAppCannedResponse{}, &WhatsAppSequence{}, &WhatsAppSequenceEnrollment{},
+		&WhatsAppScoringRule{}, &WhatsAppContactScore{}, &WhatsAppLeadSettings{},
+	}
+}
+
+// schemaIndexes are the indexes not declared on the models
+var schemaIndexes = []struct {
+	Table, Name, Columns string
+}{
+	{"whats_app_sessions", "idx_sessions_user_status", "user_id, status"},
+	{"whats_app_events", "idx_events_session_created", "session_id, created_at DESC"},
+	{"whats_app_groups", "idx_groups_session", "session_id"},
+	{"whats_app_contacts", "idx_contacts_group", "group_id"},
+	{"whats_app_messages", "idx_messages_chat_time", "session_id, chat_jid, timestamp DESC"},
+}
+
+// campaignReceiptColumns are the receipt counters and settings of campaigns
+// added by migration 10
+var campaignReceiptColumns = []string{"Delivered", "Read", "ReceiptMode", "ReceiptThresholds", "DeliveredMilestone", "ReadMilestone"}
+
+// deviceLimitTriggerMigration installs the device limit trigger
+const deviceLimitTriggerMigration = 2
+
+// migrations are all schema migrations in version order
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(appModels()...)
+		},
+	},
+	{
+		Version: deviceLimitTriggerMigration,
+		Name:    "device_limit_trigger",
+		Up:      createDeviceLimitTrigger,
+		Down:    dropDeviceLimitTrigger,
+	},
+	{
+		Version: 3,
+		Name:    "query_indexes",
+		Up: func(tx *gorm.DB) error {
+			for _, index := range schemaIndexes {
+				if tx.Migrator().HasIndex(index.Table, index.Name) {
+					continue
+				}
+				if err := tx.Exec(fmt.Sprintf("CREATE INDEX %s ON %s(%s)", index.Name, index.Table, index.Columns)).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, index := range schemaIndexes {
+				if !tx.Migrator().HasIndex(index.Table, index.Name) {
+					continue
+				}
+				if err := tx.Migrator().DropIndex(index.Table, index.Name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 4,
+		Name:    "user_quotas",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppUserQuota{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppUserQuota{})
+		},
+	},
+	{
+		Version: 5,
+		Name:    "send_intents",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppSendIntent{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppSendIntent{})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "group_daily_stats",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppGroupDailyStat{}, &WhatsAppAggregationCursor{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppGroupDailyStat{}, &WhatsAppAggregationCursor{})
+		},
+	},
+	{
+		Version: 7,
+		Name:    "message_receipts",
+		Up: func(tx *gorm.DB) error {
+			for _, column := range []string{"DeliveredAt", "ReadAt"} {
+				if tx.Migrator().HasColumn(&WhatsAppMessage{}, column) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&WhatsAppMessage{}, column); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasIndex("whats_app_messages", "idx_messages_session_message") {
+				return nil
+			}
+			return tx.Exec("CREATE INDEX idx_messages_session_message ON whats_app_messages(session_id, message_id)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex("whats_app_messages", "idx_messages_session_message") {
+				if err := tx.Migrator().DropIndex("whats_app_messages", "idx_messages_session_message"); err != nil {
+					return err
+				}
+			}
+			for _, column := range []string{"DeliveredAt", "ReadAt"} {
+				if err := tx.Migrator().DropColumn(&WhatsAppMessage{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 8,
+		Name:    "usage_counters",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppUsageCounter{}, &WhatsAppUsageQuota{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppUsageCounter{}, &WhatsAppUsageQuota{})
+		},
+	},
+	{
+		Version: 9,
+		Name:    "qr_attempts",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppQRAttempt{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppQRAttempt{})
+		},
+	},
+	{
+		Version: 10,
+		Name:    "campaign_receipts",
+		Up: func(tx *gorm.DB) error {
+			for _, column := range campaignReceiptColumns {
+				if tx.Migrator().HasColumn(&WhatsAppCampaign{}, column) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&WhatsAppCampaign{}, column); err != nil {
+					return err
+				}
+			}
+			for _, column := range []string{"DeliveredAt", "ReadAt"} {
+				if tx.Migrator().HasColumn(&WhatsAppCampaignRecipient{}, column) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&WhatsAppCampaignRecipient{}, column); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasIndex(&WhatsAppCampaignRecipient{}, "idx_campaign_recipients_message") {
+				return nil
+			}
+			return tx.Migrator().CreateIndex(&WhatsAppCampaignRecipient{}, "idx_campaign_recipients_message")
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&WhatsAppCampaignRecipient{}, "idx_campaign_recipients_message") {
+				if err := tx.Migrator().DropIndex(&WhatsAppCampaignRecipient{}, "idx_campaign_recipients_message"); err != nil {
+					return err
+				}
+			}
+			for _, column := range []string{"DeliveredAt", "ReadAt"} {
+				if err := tx.Migrator().DropColumn(&WhatsAppCampaignRecipient{}, column); err != nil {
+					return err
+				}
+			}
+			for _, column := range campaignReceiptColumns {
+				if err := tx.Migrator().DropColumn(&WhatsAppCampaign{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 11,
+		Name:    "campaign_retries",
+		Up: func(tx *gorm.DB) error {
+			for _, column := range []string{"Permanent", "Attempts"} {
+				if tx.Migrator().HasColumn(&WhatsAppCampaignRecipient{}, column) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&WhatsAppCampaignRecipient{}, column); err != nil {
+					return err
+				}
+			}
+			// Recipients sent or failed before attempts were counted had one
+			return tx.Model(&WhatsAppCampaignRecipient{}).
+				Where("status IN ? AND attempts = 0", []CampaignRecipientStatus{CampaignRecipientSent, CampaignRecipientFailed}).
+				Update("attempts", 1).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"Permanent", "Attempts"} {
+				if err := tx.Migrator().DropColumn(&WhatsAppCampaignRecipient{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 12,
+		Name:    "picture_changes",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppPictureChange{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppPictureChange{})
+		},
+	},
+	{
+		Version: 13,
+		Name:    "blocklist",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&WhatsAppBlockedContact{}); err != nil {
+				return err
+			}
+			if !tx.Migrator().HasColumn(&WhatsAppContact{}, "IsBlocked") {
+				if err := tx.Migrator().AddColumn(&WhatsAppContact{}, "IsBlocked"); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasColumn(&WhatsAppSession{}, "BlocklistSyncedAt") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&WhatsAppSession{}, "BlocklistSyncedAt")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropColumn(&WhatsAppSession{}, "BlocklistSyncedAt"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropColumn(&WhatsAppContact{}, "IsBlocked"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable(&WhatsAppBlockedContact{})
+		},
+	},
+	{
+		Version: 14,
+		Name:    "session_read_receipts",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&WhatsAppSession{}, "SendReadReceipts") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&WhatsAppSession{}, "SendReadReceipts")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&WhatsAppSession{}, "SendReadReceipts")
+		},
+	},
+	{
+		Version: 15,
+		Name:    "channels",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppChannel{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppChannel{})
+		},
+	},
+	{
+		Version: 16,
+		Name:    "projects",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&WhatsAppProject{}); err != nil {
+				return err
+			}
+			if tx.Migrator().HasColumn(&WhatsAppSession{}, "ProjectID") {
+				return nil
+			}
+			if err := tx.Migrator().AddColumn(&WhatsAppSession{}, "ProjectID"); err != nil {
+				return err
+			}
+			return tx.Migrator().CreateIndex(&WhatsAppSession{}, "ProjectID")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropColumn(&WhatsAppSession{}, "ProjectID"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable(&WhatsAppProject{})
+		},
+	},
+	{
+		Version: 17,
+		Name:    "backups",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppBackup{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppBackup{})
+		},
+	},
+	{
+		Version: 18,
+		Name:    "session_media_auto_store",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&WhatsAppSession{}, "MediaAutoStore") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&WhatsAppSession{}, "MediaAutoStore")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&WhatsAppSession{}, "MediaAutoStore")
+		},
+	},
+	{
+		Version: 19,
+		Name:    "group_invite_links",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppGroupInviteLink{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppGroupInviteLink{})
+		},
+	},
+	{
+		Version: 20,
+		Name:    "outbox_ttl",
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&WhatsAppSession{}, "OutboxTTLSeconds") {
+				if err := tx.Migrator().AddColumn(&WhatsAppSession{}, "OutboxTTLSeconds"); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasColumn(&WhatsAppOutboxMessage{}, "ExpiresAt") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&WhatsAppOutboxMessage{}, "ExpiresAt")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropColumn(&WhatsAppOutboxMessage{}, "ExpiresAt"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&WhatsAppSession{}, "OutboxTTLSeconds")
+		},
+	},
+	{
+		Version: 21,
+		Name:    "event_filters",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppEventFilter{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppEventFilter{})
+		},
+	},
+	{
+		Version: 22,
+		Name:    "session_canary",
+		Up: func(tx *gorm.DB) error {
+			for _, column := range []string{"CanaryJID", "CanaryMode"} {
+				if tx.Migrator().HasColumn(&WhatsAppSession{}, column) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&WhatsAppSession{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropColumn(&WhatsAppSession{}, "CanaryMode"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&WhatsAppSession{}, "CanaryJID")
+		},
+	},
+	{
+		Version: 23,
+		Name:    "replica_heartbeats",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppReplicaHeartbeat{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppReplicaHeartbeat{})
+		},
+	},
+	{
+		Version: 24,
+		Name:    "session_purge_token",
+		Up: func(tx *gorm.DB) error {
+			for _, column := range []string{"PurgeTokenHash", "PurgeTokenExpiry"} {
+				if tx.Migrator().HasColumn(&WhatsAppSession{}, column) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&WhatsAppSession{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropColumn(&WhatsAppSession{}, "PurgeTokenExpiry"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&WhatsAppSession{}, "PurgeTokenHash")
+		},
+	},
+	{
+		Version: 25,
+		Name:    "lid_mappings",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppLIDMapping{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppLIDMapping{})
+		},
+	},
+	{
+		Version: 26,
+		Name:    "message_context_info",
+		Up: func(tx *gorm.DB) error {
+			for _, column := range []string{"QuotedMessageID", "QuotedSenderJID", "MentionedJIDs", "IsForwarded", "ForwardingScore"} {
+				if tx.Migrator().HasColumn(&WhatsAppMessage{}, column) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&WhatsAppMessage{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"ForwardingScore", "IsForwarded", "MentionedJIDs", "QuotedSenderJID", "QuotedMessageID"} {
+				if err := tx.Migrator().DropColumn(&WhatsAppMessage{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 27,
+		Name:    "inbound_filter",
+		Up: func(tx *gorm.DB) error {
+			for _, column := range []string{"InboundFilter", "InboundReply", "InboundAllowSaved"} {
+				if tx.Migrator().HasColumn(&WhatsAppSession{}, column) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&WhatsAppSession{}, column); err != nil {
+					return err
+				}
+			}
+			return tx.AutoMigrate(&WhatsAppSenderRule{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&WhatsAppSenderRule{}); err != nil {
+				return err
+			}
+			for _, column := range []string{"InboundAllowSaved", "InboundReply", "InboundFilter"} {
+				if err := tx.Migrator().DropColumn(&WhatsAppSession{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 28,
+		Name:    "notes",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppNote{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppNote{})
+		},
+	},
+	{
+		Version: 29,
+		Name:    "canned_responses",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppCannedResponse{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppCannedResponse{})
+		},
+	},
+	{
+		Version: 30,
+		Name:    "sequences",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&WhatsAppSequence{}, &WhatsAppSequenceEnrollment{}); err != nil {
+				return err
+			}
+			if tx.Migrator().HasColumn(&WhatsAppAutoReplyRule{}, "SequenceID") {
+				return nil
+			}
+			if err := tx.Migrator().AddColumn(&WhatsAppAutoReplyRule{}, "SequenceID"); err != nil {
+				return err
+			}
+			return tx.Migrator().CreateIndex(&WhatsAppAutoReplyRule{}, "SequenceID")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropColumn(&WhatsAppAutoReplyRule{}, "SequenceID"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable(&WhatsAppSequenceEnrollment{}, &WhatsAppSequence{})
+		},
+	},
+	{
+		Version: 31,
+		Name:    "lead_scoring",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&WhatsAppScoringRule{}, &WhatsAppContactScore{}, &WhatsAppLeadSettings{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&WhatsAppLeadSettings{}, &WhatsAppContactScore{}, &WhatsAppScoringRule{})
+		},
+	},
+	{
+		Version: 32,
+		Name:    "send_intent_instance",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&WhatsAppSendIntent{}, "Instance") {
+				return nil
+			}
+			if err := tx.Migrator().AddColumn(&WhatsAppSendIntent{}, "Instance"); err != nil {
+				return err
+			}
+			return tx.Migrator().CreateIndex(&WhatsAppSendIntent{}, "Instance")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&WhatsAppSendIntent{}, "Instance")
+		},
+	},
+}
+
+// appliedMigrations returns the applied migrations by version
+func (dm *DatabaseManager) appliedMigrations() (map[int]SchemaMigration, error) {
+	if err := dm.db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var rows []SchemaMigration
+	if err := dm.db.Order("version").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load applied migrations: %w", err)
+	}
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// MigrationStatuses lists every known migration with its applied time
+func (dm *DatabaseManager) MigrationStatuses() ([]MigrationStatus, error) {
+	applied, err := dm.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		statuses[i] = MigrationStatus{Version: m.Version, Name: m.Name}
+		if row, ok := applied[m.Version]; ok {
+			appliedAt := row.AppliedAt
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// PendingMigrations returns the number of migrations not applied yet
+func (dm *DatabaseManager) PendingMigrations() (int, error) {
+	statuses, err := dm.MigrationStatuses()
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// MigrateUp applies the pending migrations in order and returns how many
+// were applied
+func (dm *DatabaseManager) MigrateUp() (int, error) {
+	applied, err := dm.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := dm.db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return count, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		log.Printf("   ⬆️  Applied migration %d (%s)", m.Version, m.Name)
+		count++
+	}
+	return count, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first
+func (dm *DatabaseManager) MigrateDown(steps int) (int, error) {
+	applied, err := dm.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == nil {
+			return count, fmt.Errorf("migration %d (%s) can't be reverted", m.Version, m.Name)
+		}
+		err := dm.db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return count, fmt.Errorf("reverting migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		log.Printf("   ⬇️  Reverted migration %d (%s)", m.Version, m.Name)
+		count++
+	}
+	return count, nil
+}
+
+// migrateOnStartup applies the pending migrations, or with
+// DB_AUTO_MIGRATE=false refuses to start while any are pending
+func (dm *DatabaseManager) migrateOnStartup(cfg *Config) error {
+	if !cfg.DBAutoMigrate {
+		pending, err := dm.PendingMigrations()
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d database migration(s) pending; run `whatsapp-api migrate up` first", pending)
+		}
+		log.Println("   ✅ Database schema is up to date")
+		return nil
+	}
+
+	log.Println("🔧 Running database migrations...")
+	count, err := dm.MigrateUp()
+	if err != nil {
+		return err
+	}
+	log.Printf("   ✅ Migrations completed (%d applied)", count)
+	return nil
+}
+
+// runMigrateCommand runs `whatsapp-api migrate up|down [steps]|status`
+func runMigrateCommand(cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected up, down or status")
+	}
+
+	gormDB, err := openAppDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	dm := &DatabaseManager{db: gormDB}
+	defer dm.Close()
+
+	switch args[0] {
+	case "up":
+		count, err := dm.MigrateUp()
+		if err != nil {
+			return err
+		}
+		log.Printf("✅ Applied %d migration(s)", count)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		count, err := dm.MigrateDown(steps)
+		if err != nil {
+			return err
+		}
+		log.Printf("✅ Reverted %d migration(s)", count)
+	case "status":
+		statuses, err := dm.MigrationStatuses()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-24s %s\n", status.Version, status.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q (expected up, down or status)", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"whatsapp-api/pkg/apierr"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= NOTES =============
+// Notes are internal comments a user's team leaves on contacts and chats,
+// e.g. "asked for a refund on 3/4". They are stored only on this server and
+// never sent to WhatsApp. Contact notes belong to the user and are keyed by
+// the contact's JID like tags (a mapped LID is stored as its phone number),
+// chat notes belong to one session's chat. Contact and chat listings return
+// the notes with each row. The author is free text, so the agents sharing
+// one API user can sign their notes.
+
+// Note targets
+const (
+	NoteTargetContact = "contact"
+	NoteTargetChat    = "chat"
+)
+
+const noteMaxLength = 5000
+
+// NoteRequest creates or changes a note
+type NoteRequest struct {
+	Text   string `json:"text" binding:"required"`
+	Author string `json:"author"`
+}
+
+// validate trims a note request and checks its lengths
+func (req *NoteRequest) validate() error {
+	req.Text = strings.TrimSpace(req.Text)
+	req.Author = strings.TrimSpace(req.Author)
+	if req.Text == "" {
+		return fmt.Errorf("text must not be empty")
+	}
+	if len(req.Text) > noteMaxLength {
+		return fmt.Errorf("text is longer than %d characters", noteMaxLength)
+	}
+	if len(req.Author) > 255 {
+		return fmt.Errorf("author is longer than 255 characters")
+	}
+	return nil
+}
+
+// AddContactNote leaves a note on a contact
+func (ws *WhatsAppService) AddContactNote(userID int, contact string, req NoteRequest) (*WhatsAppNote, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	jid, err := parseContactJID(contact)
+	if err != nil {
+		return nil, err
+	}
+
+	note := &WhatsAppNote{
+		UserID:     userID,
+		TargetType: NoteTargetContact,
+		TargetJID:  ws.db.CanonicalContactJID(jid.String()),
+		Author:     req.Author,
+		Text:       req.Text,
+	}
+	if err := ws.db.CreateNote(note); err != nil {
+		return nil, fmt.Errorf("failed to save note: %w", err)
+	}
+	return note, nil
+}
+
+// GetContactNotes lists the notes on a contact, oldest first
+func (ws *WhatsAppService) GetContactNotes(userID int, contact string) ([]WhatsAppNote, error) {
+	jid, err := parseContactJID(contact)
+	if err != nil {
+		return nil, err
+	}
+
+	contactJID := ws.db.CanonicalContactJID(jid.String())
+	notes, err := ws.db.GetNotes(userID, "", NoteTargetContact, []string{contactJID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notes: %w", err)
+	}
+	if notes[contactJID] == nil {
+		return []WhatsAppNote{}, nil
+	}
+	return notes[contactJID], nil
+}
+
+// parseNoteChat checks that the session belongs to the user and parses a
+// chat JID (a phone number is taken as its user JID)
+func (ws *WhatsAppService) parseNoteChat(sessionID string, userID int, chat string) (string, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return "", apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return "", apierr.ErrSessionNotFound
+	}
+
+	chatJID, err := wajid.Parse(chat)
+	if err != nil {
+		return "", fmt.Errorf("invalid chat JID: %w", err)
+	}
+	return chatJID.String(), nil
+}
+
+// AddChatNote leaves a note on a chat of a session
+func (ws *WhatsAppService) AddChatNote(sessionID string, userID int, chat string, req NoteRequest) (*WhatsAppNote, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	chatJID, err := ws.parseNoteChat(sessionID, userID, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	note := &WhatsAppNote{
+		UserID:     userID,
+		SessionID:  sessionID,
+		TargetType: NoteTargetChat,
+		TargetJID:  chatJID,
+		Author:     req.Author,
+		Text:       req.Text,
+	}
+	if err := ws.db.CreateNote(note); err != nil {
+		return nil, fmt.Errorf("failed to save note: %w", err)
+	}
+	return note, nil
+}
+
+// GetChatNotes lists the notes on a chat of a session, oldest first
+func (ws *WhatsAppService) GetChatNotes(sessionID string, userID int, chat string) ([]WhatsAppNote, error) {
+	chatJID, err := ws.parseNoteChat(sessionID, userID, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := ws.db.GetNotes(userID, sessionID, NoteTargetChat, []string{chatJID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notes: %w", err)
+	}
+	if notes[chatJID] == nil {
+		return []WhatsAppNote{}, nil
+	}
+	return notes[chatJID], nil
+}
+
+// UpdateNote replaces the text and author of a note
+func (ws *WhatsAppService) UpdateNote(userID int, noteID int64, req NoteRequest) (*WhatsAppNote, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	note, err := ws.db.GetNote(noteID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("note not found")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load note: %w", err)
+	}
+
+	note.Text = req.Text
+	note.Author = req.Author
+	if err := ws.db.UpdateNote(note); err != nil {
+		return nil, fmt.Errorf("failed to update note: %w", err)
+	}
+	return note, nil
+}
+
+// DeleteNote removes a note
+func (ws *WhatsAppService) DeleteNote(userID int, noteID int64) error {
+	removed, err := ws.db.DeleteNote(noteID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete note: %w", err)
+	}
+	if removed == 0 {
+		return fmt.Errorf("note not found")
+	}
+	return nil
+}
+
+// attachChatNotes loads the notes of a page of chats
+func (ws *WhatsAppService) attachChatNotes(sessionID string, userID int, chats []WhatsAppChat) error {
+	jids := make([]string, 0, len(chats))
+	for _, chat := range chats {
+		jids = append(jids, chat.ChatJID)
+	}
+	notes, err := ws.db.GetNotes(userID, sessionID, NoteTargetChat, jids)
+	if err != nil {
+		return err
+	}
+	for i := range chats {
+		chats[i].Notes = notes[chats[i].ChatJID]
+	}
+	return nil
+}
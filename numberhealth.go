@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-api/pkg/apierr"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= NUMBER HEALTH =============
+// POST /validate-account checks up to 500 numbers at once: registration
+// comes from the JID resolver (one IsOnWhatsApp query for the uncached
+// numbers), business flag, profile picture and device count from usync user
+// info queries in batches, and last activity from the stored chats. Profiles
+// are cached in the database for JID_CACHE_TTL like registrations.
+
+// numberInfoBatch is the number of JIDs per user info query
+const numberInfoBatch = 100
+
+// NumberHealth is the check result of one phone number
+type NumberHealth struct {
+	Input          string     `json:"input"`
+	Phone          string     `json:"phone_number,omitempty"`
+	Valid          bool       `json:"is_valid"`
+	Registered     bool       `json:"is_registered"`
+	JID            *string    `json:"jid"`
+	IsBusiness     bool       `json:"is_business"`
+	BusinessName   string     `json:"business_name,omitempty"`
+	HasPicture     bool       `json:"has_profile_picture"` // false also when hidden by privacy settings
+	Devices        int        `json:"devices"`
+	LastActivityAt *time.Time `json:"last_activity_at"` // latest message in any chat of the user
+	Cached         bool       `json:"cached"`
+	CheckedAt      *time.Time `json:"checked_at,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// CheckNumberHealth checks phone numbers through a session of the user; an
+// empty session ID picks any connected session
+func (ws *WhatsAppService) CheckNumberHealth(sessionID string, userID int, phones []string, forceRefresh bool) ([]NumberHealth, error) {
+	var sc *SessionClient
+	var err error
+	if sessionID == "" {
+		sc, err = ws.anyConnectedClient(userID)
+	} else {
+		sc, err = ws.getConnectedClient(sessionID, userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lookups map[string]wajid.Lookup
+	var invalid map[string]error
+	err = ws.callWhatsApp(sc, priorityInteractive, "number check", func() (err error) {
+		lookups, invalid, err = ws.jidResolver.LookupMany(context.Background(), sc.Client, phones, forceRefresh)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// One profile per registered number, however often it was given
+	registered := make(map[string]types.JID)
+	for _, lookup := range lookups {
+		if lookup.Registered {
+			registered[lookup.Phone] = lookup.JID
+		}
+	}
+	infos, err := ws.numberInfos(sc, registered, forceRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	chatJIDs := make([]string, 0, len(registered)*2)
+	for phone, jid := range registered {
+		chatJIDs = append(chatJIDs, jid.String(), types.NewJID(phone, types.DefaultUserServer).String())
+	}
+	activity, err := ws.db.GetLastChatActivity(userID, chatJIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chat activity: %w", err)
+	}
+
+	results := make([]NumberHealth, 0, len(phones))
+	for _, phone := range phones {
+		if err, ok := invalid[phone]; ok {
+			results = append(results, NumberHealth{Input: phone, Error: err.Error()})
+			continue
+		}
+
+		lookup := lookups[phone]
+		checkedAt := lookup.CheckedAt
+		health := NumberHealth{
+			Input:      phone,
+			Phone:      lookup.Phone,
+			Valid:      true,
+			Registered: lookup.Registered,
+			Cached:     lookup.Cached,
+			CheckedAt:  &checkedAt,
+		}
+		if lookup.Registered {
+			jid := lookup.JID.String()
+			health.JID = &jid
+
+			info := infos[lookup.Phone]
+			health.IsBusiness = info.IsBusiness
+			health.BusinessName = info.BusinessName
+			health.HasPicture = info.HasPicture
+			health.Devices = info.Devices
+
+			for _, chatJID := range []string{jid, types.NewJID(lookup.Phone, types.DefaultUserServer).String()} {
+				if at, ok := activity[chatJID]; ok && (health.LastActivityAt == nil || at.After(*health.LastActivityAt)) {
+					at := at
+					health.LastActivityAt = &at
+				}
+			}
+		}
+		results = append(results, health)
+	}
+	return results, nil
+}
+
+// numberInfos returns the profiles of registered numbers, querying WhatsApp
+// for the ones not cached
+func (ws *WhatsAppService) numberInfos(sc *SessionClient, registered map[string]types.JID, forceRefresh bool) (map[string]WhatsAppNumberInfo, error) {
+	phones := make([]string, 0, len(registered))
+	for phone := range registered {
+		phones = append(phones, phone)
+	}
+
+	infos := make(map[string]WhatsAppNumberInfo, len(phones))
+	caching := ws.cfg.JIDCacheTTL > 0
+	if caching && !forceRefresh {
+		cached, err := ws.db.GetNumberInfos(phones, time.Now().Add(-ws.cfg.JIDCacheTTL))
+		if err != nil {
+			log.Printf("⚠️  Failed to load cached number profiles: %v", err)
+		} else {
+			infos = cached
+		}
+	}
+
+	missing := make([]string, 0, len(phones))
+	for _, phone := range phones {
+		if _, ok := infos[phone]; !ok {
+			missing = append(missing, phone)
+		}
+	}
+
+	for start := 0; start < len(missing); start += numberInfoBatch {
+		end := start + numberInfoBatch
+		if end > len(missing) {
+			end = len(missing)
+		}
+		batch := missing[start:end]
+
+		jids := make([]types.JID, len(batch))
+		for i, phone := range batch {
+			jids[i] = registered[phone]
+		}
+		var userInfos map[types.JID]types.UserInfo
+		err := ws.callWhatsApp(sc, priorityInteractive, "number check", func() (err error) {
+			userInfos, err = sc.Client.GetUserInfo(context.Background(), jids)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user info: %w", err)
+		}
+
+		now := time.Now()
+		fetched := make([]WhatsAppNumberInfo, 0, len(batch))
+		for i, phone := range batch {
+			userInfo := userInfos[jids[i]]
+			info := WhatsAppNumberInfo{
+				Phone:      phone,
+				IsBusiness: userInfo.VerifiedName != nil,
+				HasPicture: userInfo.PictureID != "",
+				Devices:    len(userInfo.Devices),
+				CheckedAt:  now,
+			}
+			if userInfo.VerifiedName != nil && userInfo.VerifiedName.Details != nil {
+				info.BusinessName = userInfo.VerifiedName.Details.GetVerifiedName()
+			}
+			infos[phone] = info
+			fetched = append(fetched, info)
+		}
+		if caching {
+			if err := ws.db.SaveNumberInfos(fetched); err != nil {
+				log.Printf("⚠️  Failed to cache number profiles: %v", err)
+			}
+		}
+	}
+	return infos, nil
+}
+
+// anyConnectedClient returns a connected session client of the user
+func (ws *WhatsAppService) anyConnectedClient(userID int) (*SessionClient, error) {
+	sessions, err := ws.db.GetUserSessions(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve sessions: %w", err)
+	}
+
+	for _, session := range sessions {
+		if session.Status != StatusConnected {
+			continue
+		}
+		if sc, err := ws.GetSessionClient(session.ID); err == nil && sc.Client.IsConnected() {
+			return sc, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no connected WhatsApp session found, connect at least one session first", apierr.ErrSessionNotConnected)
+}
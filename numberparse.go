@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"whatsapp-api/pkg/apierr"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= NUMBER PARSING =============
+// POST /utils/parse-numbers normalizes raw phone numbers before they are used
+// as recipients, e.g. while building a contact list: E.164, country code and
+// region, line type and whether the number matches its numbering plan. Inputs
+// without + or 00 are read in the national format of default_region when one
+// is given. With check_whatsapp the parsed numbers are also looked up through
+// the JID resolver (cached like every other registration check).
+
+const (
+	parseNumbersMax      = 1000 // inputs per request
+	parseNumbersCheckMax = 500  // inputs per request with check_whatsapp
+)
+
+// ParseNumbersRequest is a batch of raw phone numbers to parse
+type ParseNumbersRequest struct {
+	Numbers       []string `json:"numbers" binding:"required,min=1"`
+	DefaultRegion string   `json:"default_region"` // ISO 3166-1 alpha-2 of national-format inputs
+	CheckWhatsApp bool     `json:"check_whatsapp"`
+	SessionID     string   `json:"session_id"` // session that checks registration, default any connected one
+}
+
+// ParsedNumber is the result of one input
+type ParsedNumber struct {
+	Input string `json:"input"`
+	*wajid.PhoneDetails
+	Possible   bool    `json:"is_possible"`           // usable as a recipient
+	OnWhatsApp *bool   `json:"on_whatsapp,omitempty"` // with check_whatsapp
+	JID        *string `json:"jid,omitempty"`         // WhatsApp JID of registered numbers
+	Error      string  `json:"error,omitempty"`
+}
+
+// ParseNumbers parses phone numbers and, when asked, checks their WhatsApp
+// registration through a session of the user
+func (ws *WhatsAppService) ParseNumbers(userID int, req ParseNumbersRequest) ([]ParsedNumber, error) {
+	if len(req.Numbers) > parseNumbersMax {
+		return nil, fmt.Errorf("%w: at most %d numbers per request", apierr.ErrInvalidRequest, parseNumbersMax)
+	}
+	if req.CheckWhatsApp && len(req.Numbers) > parseNumbersCheckMax {
+		return nil, fmt.Errorf("%w: at most %d numbers per request with check_whatsapp", apierr.ErrInvalidRequest, parseNumbersCheckMax)
+	}
+	if req.DefaultRegion != "" && !wajid.IsRegion(req.DefaultRegion) {
+		return nil, fmt.Errorf("%w: unknown default_region %q", apierr.ErrInvalidRequest, req.DefaultRegion)
+	}
+
+	results := make([]ParsedNumber, len(req.Numbers))
+	digits := make([]string, 0, len(req.Numbers))
+	for i, input := range req.Numbers {
+		results[i].Input = input
+		details, err := wajid.ParsePhone(input, req.DefaultRegion)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].PhoneDetails = &details
+		results[i].Possible = true
+		digits = append(digits, details.Digits)
+	}
+
+	if !req.CheckWhatsApp || len(digits) == 0 {
+		return results, nil
+	}
+
+	var sc *SessionClient
+	var err error
+	if req.SessionID == "" {
+		sc, err = ws.anyConnectedClient(userID)
+	} else {
+		sc, err = ws.getConnectedClient(req.SessionID, userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lookups map[string]wajid.Lookup
+	err = ws.callWhatsApp(sc, priorityInteractive, "number check", func() (err error) {
+		lookups, _, err = ws.jidResolver.LookupMany(context.Background(), sc.Client, digits, false)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check numbers on WhatsApp: %w", err)
+	}
+	for i := range results {
+		if results[i].PhoneDetails == nil {
+			continue
+		}
+		lookup, ok := lookups[results[i].Digits]
+		if !ok {
+			continue
+		}
+		registered := lookup.Registered
+		results[i].OnWhatsApp = &registered
+		if registered {
+			jid := lookup.JID.String()
+			results[i].JID = &jid
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
+	"gorm.io/gorm"
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= OUTBOX =============
+// Async sends are stored as WhatsAppOutboxMessage rows and delivered by a
+// background worker. Each row is keyed by the client's Idempotency-Key, so
+// retried API calls return the original record instead of sending twice.
+// Transient failures (session offline, timeouts, upload and server errors)
+// are retried with a growing delay; anything else fails the message
+// immediately. Sends held back by the safety engine wait until the session
+// may send again.
+//
+// A queued message may carry an expiry, so a send held back by a session that
+// stays offline doesn't go out days later: the ?ttl= of the request (seconds),
+// else the session's outbox_ttl_seconds, else OUTBOX_DEFAULT_TTL. Expired
+// messages are failed with the error "expired" and reported like any failed
+// message, with reason "expired".
+
+const (
+	outboxPollInterval = 2 * time.Second
+	outboxBatchSize    = 20
+	outboxMaxAttempts  = 5
+	outboxRetryDelay   = 30 * time.Second
+	outboxStaleAfter   = 5 * time.Minute
+	maxIdempotencyKey  = 255
+)
+
+// outboxExpired is the error of a message that wasn't sent before it expired
+const outboxExpired = "expired"
+
+var errOutboxExpired = errors.New(outboxExpired)
+
+// OutboxSettingsRequest changes the outbox settings of a session
+type OutboxSettingsRequest struct {
+	TTLSeconds *int `json:"ttl_seconds"` // 0 uses OUTBOX_DEFAULT_TTL
+}
+
+// OutboxSettings are the outbox settings of a session
+type OutboxSettings struct {
+	TTLSeconds          int `json:"ttl_seconds"`
+	EffectiveTTLSeconds int `json:"effective_ttl_seconds"` // 0 = queued sends don't expire
+}
+
+// EnqueueSend queues a send request for the outbox worker. created is false
+// when the idempotency key was used before; the original record is returned.
+// A ttl of 0 uses the session's default.
+func (ws *WhatsAppService) EnqueueSend(userID int, idempotencyKey string, req SendRequest, ttl time.Duration) (*WhatsAppOutboxMessage, bool, error) {
+	idempotencyKey = strings.TrimSpace(idempotencyKey)
+	if idempotencyKey == "" {
+		return nil, false, fmt.Errorf("Idempotency-Key header is required for async sends")
+	}
+	if len(idempotencyKey) > maxIdempotencyKey {
+		return nil, false, fmt.Errorf("Idempotency-Key must be at most %d characters", maxIdempotencyKey)
+	}
+
+	// A retried call must not fail on validation that passed the first time
+	if existing, err := ws.db.GetOutboxMessageByKey(userID, idempotencyKey); err == nil {
+		return existing, false, nil
+	}
+
+	// A channel's session is picked when the message is delivered; the
+	// queued message shows the one active now
+	sessionID := req.SessionID
+	if req.ChannelID != 0 {
+		if req.SessionID != "" {
+			return nil, false, fmt.Errorf("%w: send to either a session_id or a channel_id", apierr.ErrInvalidRequest)
+		}
+		channel, err := ws.GetChannel(userID, req.ChannelID)
+		if err != nil {
+			return nil, false, err
+		}
+		sessionID = channel.ActiveSessionID
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, false, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return nil, false, apierr.ErrSessionNotFound
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	msg := &WhatsAppOutboxMessage{
+		UserID:         userID,
+		SessionID:      sessionID,
+		IdempotencyKey: idempotencyKey,
+		Recipient:      req.To,
+		Payload:        string(payload),
+		Status:         OutboxQueued,
+		NextAttemptAt:  time.Now(),
+	}
+	if ttl <= 0 {
+		ttl = ws.outboxTTL(session)
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		msg.ExpiresAt = &expiresAt
+	}
+
+	saved, created, err := ws.db.CreateOutboxMessage(msg)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to queue message: %w", err)
+	}
+	if created {
+		log.Printf("📥 Queued outbox message %d to %s (key %s)", saved.ID, saved.Recipient, idempotencyKey)
+	}
+	return saved, created, nil
+}
+
+// outboxTTL is how long the queued sends of a session may wait, 0 for ever
+func (ws *WhatsAppService) outboxTTL(session *WhatsAppSession) time.Duration {
+	if session.OutboxTTLSeconds > 0 {
+		return time.Duration(session.OutboxTTLSeconds) * time.Second
+	}
+	return ws.cfg.OutboxDefaultTTL
+}
+
+// GetOutboxSettings returns the outbox settings of a session
+func (ws *WhatsAppService) GetOutboxSettings(sessionID string, userID int) (*OutboxSettings, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+	return &OutboxSettings{
+		TTLSeconds:          session.OutboxTTLSeconds,
+		EffectiveTTLSeconds: int(ws.outboxTTL(session) / time.Second),
+	}, nil
+}
+
+// UpdateOutboxSettings changes how long the queued sends of a session may
+// wait; messages already queued keep their expiry
+func (ws *WhatsAppService) UpdateOutboxSettings(sessionID string, userID int, req OutboxSettingsRequest) (*OutboxSettings, error) {
+	if req.TTLSeconds == nil {
+		return nil, fmt.Errorf("%w: nothing to update", apierr.ErrInvalidRequest)
+	}
+	if *req.TTLSeconds < 0 {
+		return nil, fmt.Errorf("%w: ttl_seconds must not be negative", apierr.ErrInvalidRequest)
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	if err := ws.db.SetSessionOutboxTTL(sessionID, *req.TTLSeconds); err != nil {
+		return nil, fmt.Errorf("failed to update outbox settings: %w", err)
+	}
+	return ws.GetOutboxSettings(sessionID, userID)
+}
+
+// GetOutboxMessage returns a queued message and its delivery status
+func (ws *WhatsAppService) GetOutboxMessage(userID int, id int64) (*WhatsAppOutboxMessage, error) {
+	msg, err := ws.db.GetOutboxMessage(id, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("outbox message not found")
+		}
+		return nil, fmt.Errorf("failed to load outbox message: %w", err)
+	}
+	return msg, nil
+}
+
+// StartOutboxWorker delivers queued messages until the context is cancelled
+func (ws *WhatsAppService) StartOutboxWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(outboxPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ws.processOutbox(ctx)
+			}
+		}
+	}()
+	log.Println("✅ Outbox worker started")
+}
+
+func (ws *WhatsAppService) processOutbox(ctx context.Context) {
+	expired, err := ws.db.ExpireOutboxMessages(time.Now(), outboxExpired)
+	if err != nil {
+		log.Printf("❌ Failed to expire outbox messages: %v", err)
+	}
+	for i := range expired {
+		ws.reportOutboxFailure(&expired[i], errOutboxExpired)
+	}
+
+	messages, err := ws.db.ClaimOutboxMessages(outboxBatchSize, time.Now().Add(-outboxStaleAfter))
+	if err != nil {
+		log.Printf("❌ Failed to claim outbox messages: %v", err)
+	}
+
+	for i := range messages {
+		if ctx.Err() != nil {
+			// Unsent claims are picked up again once they go stale
+			return
+		}
+		ws.deliverOutboxMessage(&messages[i])
+	}
+}
+
+// deliverOutboxMessage sends a claimed message and records the outcome
+func (ws *WhatsAppService) deliverOutboxMessage(msg *WhatsAppOutboxMessage) {
+	// Claimed again after going stale, or claimed as it expired
+	if msg.ExpiresAt != nil && !time.Now().Before(*msg.ExpiresAt) {
+		ws.failOutboxMessage(msg, errOutboxExpired)
+		return
+	}
+
+	var req SendRequest
+	if err := json.Unmarshal([]byte(msg.Payload), &req); err != nil {
+		ws.failOutboxMessage(msg, fmt.Errorf("invalid payload: %w", err))
+		return
+	}
+
+	resp, err := ws.DispatchSend(msg.UserID, req)
+	if errors.Is(err, ErrSuppressed) {
+		ws.db.UpdateOutboxMessage(msg.ID, map[string]interface{}{
+			"status": OutboxSuppressed,
+			"error":  err.Error(),
+		})
+		log.Printf("🚫 Outbox message %d skipped: %v", msg.ID, err)
+		return
+	}
+	var limitErr *SendLimitError
+	if errors.As(err, &limitErr) {
+		// Held back by the safety engine; this doesn't count as an attempt
+		ws.db.UpdateOutboxMessage(msg.ID, map[string]interface{}{
+			"status":          OutboxQueued,
+			"attempts":        msg.Attempts - 1,
+			"error":           err.Error(),
+			"next_attempt_at": limitErr.RetryAt,
+		})
+		log.Printf("⏸️  Outbox message %d deferred until %s: %v", msg.ID, limitErr.RetryAt.Format(time.RFC3339), err)
+		return
+	}
+	if err != nil {
+		if isRetryableSendError(err) && msg.Attempts < outboxMaxAttempts {
+			delay := time.Duration(msg.Attempts) * outboxRetryDelay
+			ws.db.UpdateOutboxMessage(msg.ID, map[string]interface{}{
+				"status":          OutboxQueued,
+				"error":           err.Error(),
+				"next_attempt_at": time.Now().Add(delay),
+			})
+			log.Printf("⚠️  Outbox message %d failed (attempt %d/%d), retrying in %v: %v", msg.ID, msg.Attempts, outboxMaxAttempts, delay, err)
+			return
+		}
+		ws.failOutboxMessage(msg, err)
+		return
+	}
+
+	now := time.Now()
+	ws.db.UpdateOutboxMessage(msg.ID, map[string]interface{}{
+		"status":       OutboxSent,
+		"message_id":   resp.MessageID,
+		"message_type": resp.Type,
+		"error":        "",
+		"sent_at":      now,
+	})
+	log.Printf("📤 Outbox message %d sent (ID: %s)", msg.ID, resp.MessageID)
+}
+
+func (ws *WhatsAppService) failOutboxMessage(msg *WhatsAppOutboxMessage, err error) {
+	ws.db.UpdateOutboxMessage(msg.ID, map[string]interface{}{
+		"status": OutboxFailed,
+		"error":  err.Error(),
+	})
+	ws.reportOutboxFailure(msg, err)
+}
+
+// reportOutboxFailure emits outbox_message_failed for a failed message
+func (ws *WhatsAppService) reportOutboxFailure(msg *WhatsAppOutboxMessage, err error) {
+	reason := "send_failed"
+	if errors.Is(err, errOutboxExpired) {
+		reason = outboxExpired
+		log.Printf("⌛ Outbox message %d expired unsent (queued %s)", msg.ID, msg.CreatedAt.Format(time.RFC3339))
+	} else {
+		log.Printf("❌ Outbox message %d failed: %v", msg.ID, err)
+	}
+
+	data := map[string]interface{}{
+		"outbox_id":       msg.ID,
+		"idempotency_key": msg.IdempotencyKey,
+		"to":              msg.Recipient,
+		"error":           err.Error(),
+		"reason":          reason, // send_failed or expired
+	}
+	if msg.ExpiresAt != nil {
+		data["expires_at"] = msg.ExpiresAt
+	}
+	sessionUUID, _ := uuid.Parse(msg.SessionID)
+	ws.db.CreateEvent(sessionUUID, msg.UserID, "outbox_message_failed", data)
+	ws.wsManager.SendToSession(msg.SessionID, WebSocketMessage{
+		Type: "outbox_message_failed",
+		Data: data,
+	})
+}
+
+// isRetryableSendError reports whether a send may succeed when tried again
+// later, as opposed to errors caused by the request itself: the session or
+// websocket being offline, timeouts, network errors and server-side failures
+// of WhatsApp or the media host
+func isRetryableSendError(err error) bool {
+	switch {
+	case errors.Is(err, apierr.ErrSessionNotConnected),
+		errors.Is(err, apierr.ErrUnavailable),
+		errors.Is(err, whatsmeow.ErrNotConnected),
+		errors.Is(err, whatsmeow.ErrIQTimedOut),
+		errors.Is(err, whatsmeow.ErrMessageTimedOut),
+		errors.Is(err, errMediaUploadFailed),
+		errors.Is(err, context.DeadlineExceeded):
+		return true
+	}
+	var disconnected *whatsmeow.DisconnectedError
+	if errors.As(err, &disconnected) {
+		return true
+	}
+	var iqErr *whatsmeow.IQError
+	if errors.As(err, &iqErr) {
+		return iqErr.Code >= 500 || iqErr.Code == 429
+	}
+	var httpErr *mediaHTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 500 || httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode == http.StatusRequestTimeout
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
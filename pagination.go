@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ============= PAGINATION =============
+// List endpoints share one set of query parameters: ?limit= (default 50, max
+// 500) and ?offset= page through the results, ?sort=<field> orders them
+// (prefix the field with "-" for descending), ?q= searches the endpoint's text
+// columns and each endpoint accepts a few equality filters. Responses carry a
+// "pagination" object next to "data" with the total number of matches.
+
+const (
+	listDefaultLimit = 50
+	listMaxLimit     = 500
+)
+
+// PaginationMeta describes the page returned by a list endpoint
+type PaginationMeta struct {
+	Total   int64  `json:"total"`
+	Limit   int    `json:"limit"`
+	Offset  int    `json:"offset"`
+	HasMore bool   `json:"has_more"`
+	Sort    string `json:"sort"`
+}
+
+// ListQuery is the paging, sorting and filtering of a list request
+type ListQuery struct {
+	Limit   int
+	Offset  int
+	Sort    string // column to order by
+	Desc    bool
+	Search  string            // ?q=
+	Filters map[string]string // filter name -> value, only the endpoint's filters
+	sortKey string            // sort as requested, echoed in PaginationMeta
+}
+
+// listSortFields maps the sort names an endpoint accepts to columns
+type listSortFields map[string]string
+
+// parseListQuery reads the list parameters of a request. defaultSort uses the
+// same syntax as ?sort=, and filters are the query parameters the endpoint
+// accepts as filters; a ":bool" suffix marks true/false filters.
+func parseListQuery(c *gin.Context, fields listSortFields, defaultSort string, filters ...string) (ListQuery, error) {
+	q := ListQuery{
+		Limit:   listDefaultLimit,
+		Search:  strings.TrimSpace(c.Query("q")),
+		Filters: make(map[string]string),
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return q, fmt.Errorf("limit must be a positive number")
+		}
+		q.Limit = min(limit, listMaxLimit)
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return q, fmt.Errorf("offset must not be negative")
+		}
+		q.Offset = offset
+	}
+
+	q.sortKey = c.DefaultQuery("sort", defaultSort)
+	name, desc := strings.CutPrefix(q.sortKey, "-")
+	column, ok := fields[name]
+	if !ok {
+		names := make([]string, 0, len(fields))
+		for field := range fields {
+			names = append(names, field)
+		}
+		sort.Strings(names)
+		return q, fmt.Errorf("sort must be one of: %s", strings.Join(names, ", "))
+	}
+	q.Sort = column
+	q.Desc = desc
+
+	for _, filter := range filters {
+		filter, isBool := strings.CutSuffix(filter, ":bool")
+		value := c.Query(filter)
+		if value == "" {
+			continue
+		}
+		if isBool {
+			if _, err := strconv.ParseBool(value); err != nil {
+				return q, fmt.Errorf("%s must be true or false", filter)
+			}
+		}
+		q.Filters[filter] = value
+	}
+	return q, nil
+}
+
+// boolFilter reads a true/false filter
+func (q ListQuery) boolFilter(name string) (value, ok bool) {
+	raw, ok := q.Filters[name]
+	if !ok {
+		return false, false
+	}
+	value, _ = strconv.ParseBool(raw) // validated by parseListQuery
+	return value, true
+}
+
+// order is the ORDER BY clause; id breaks ties so pages don't overlap
+func (q ListQuery) order() string {
+	direction := "ASC"
+	if q.Desc {
+		direction = "DESC"
+	}
+	return fmt.Sprintf("%s %s, id %s", q.Sort, direction, direction)
+}
+
+// like is the LIKE pattern of the search term
+func (q ListQuery) like() string {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q.Search)
+	return "%" + escaped + "%"
+}
+
+// Meta describes the page for a total number of matches
+func (q ListQuery) Meta(total int64) PaginationMeta {
+	return PaginationMeta{
+		Total:   total,
+		Limit:   q.Limit,
+		Offset:  q.Offset,
+		HasMore: int64(q.Offset+q.Limit) < total,
+		Sort:    q.sortKey,
+	}
+}
+
+// findPage counts the rows matching query and loads the requested page into
+// dest
+func findPage(query *gorm.DB, q ListQuery, dest interface{}) (int64, error) {
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return 0, err
+	}
+	err := query.Session(&gorm.Session{}).
+		Order(q.order()).
+		Limit(q.Limit).
+		Offset(q.Offset).
+		Find(dest).Error
+	return total, err
+}
+
+// parseListRequest parses the list parameters and answers 400 when they are
+// invalid
+func parseListRequest(c *gin.Context, fields listSortFields, defaultSort string, filters ...string) (ListQuery, bool) {
+	q, err := parseListQuery(c, fields, defaultSort, filters...)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return q, false
+	}
+	return q, true
+}
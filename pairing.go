@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= PAIRING EXPIRY =============
+// A session that is never paired keeps asking WhatsApp for QR codes and
+// holds a device slot. Each batch of codes WhatsApp sends is a pairing attempt
+// recorded in WhatsAppQRAttempt. After QR_MAX_ATTEMPTS attempts, or
+// QR_PAIRING_TIMEOUT after its first code, the session expires: its client is
+// disconnected and unloaded, the status becomes expired (which doesn't count
+// against the device limit) and session_expired is emitted. POST
+// /sessions/:id/reactivate starts pairing an expired session again.
+
+// qrExpiryReason says why a session that made attempts pairing attempts since
+// startedAt expires, "" while it may keep trying
+func (ws *WhatsAppService) qrExpiryReason(attempts int, startedAt time.Time) string {
+	switch {
+	case ws.cfg.QRMaxAttempts > 0 && attempts >= ws.cfg.QRMaxAttempts:
+		return fmt.Sprintf("not paired after %d QR attempts", attempts)
+	case ws.cfg.QRPairingTimeout > 0 && time.Since(startedAt) >= ws.cfg.QRPairingTimeout:
+		return ws.qrTimeoutReason()
+	}
+	return ""
+}
+
+func (ws *WhatsAppService) qrTimeoutReason() string {
+	return fmt.Sprintf("not paired within %s", ws.cfg.QRPairingTimeout)
+}
+
+// finishQRAttempt records the outcome of the client's running pairing attempt
+func (ws *WhatsAppService) finishQRAttempt(sc *SessionClient, outcome string) {
+	sc.qrMu.Lock()
+	defer sc.qrMu.Unlock()
+	if sc.qrAttemptID == 0 {
+		return
+	}
+	if err := ws.db.FinishQRAttempt(sc.qrAttemptID, outcome); err != nil {
+		log.Printf("⚠️  Failed to record QR attempt outcome of session %s: %v", sc.SessionID, err)
+	}
+	sc.qrAttemptID = 0
+}
+
+// expireSession unloads a client that didn't pair in time and marks its
+// session expired
+func (ws *WhatsAppService) expireSession(sc *SessionClient, reason string) {
+	unlock := ws.sessions.Lock(sc.SessionID)
+	defer unlock()
+
+	// Paired, deleted or replaced meanwhile
+	if sc.Device.ID != nil || !ws.sessions.Drain(sc) {
+		return
+	}
+	log.Printf("⌛ Session %s expired: %s", sc.SessionID, reason)
+
+	sc.stopQRRotation()
+	ws.finishQRAttempt(sc, QRAttemptCancelled)
+	sc.Client.Disconnect()
+	close(sc.stopChan)
+	ws.sessions.Remove(sc)
+
+	sc.qrMu.Lock()
+	attempts := sc.qrAttempts
+	sc.qrMu.Unlock()
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	if err := ws.db.ExpireSession(sessionUUID, reason); err != nil {
+		log.Printf("⚠️  Failed to mark session %s expired: %v", sc.SessionID, err)
+	}
+	data := map[string]interface{}{
+		"reason":      reason,
+		"qr_attempts": attempts,
+	}
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "session_expired",
+		Data: data,
+	})
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "session_expired", data)
+}
+
+// ReactivateSession starts pairing an expired session again; it needs a free
+// device slot like a new session
+func (ws *WhatsAppService) ReactivateSession(sessionID string, userID int) (*WhatsAppSession, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+	if session.Status != StatusExpired {
+		return nil, fmt.Errorf("%w: session is %s, only expired sessions can be reactivated", apierr.ErrConflict, session.Status)
+	}
+
+	count, err := ws.db.GetActiveSessionCount(userID)
+	if err != nil {
+		return nil, err
+	}
+	if maxDevices := ws.MaxDevices(userID); int(count) >= maxDevices {
+		return nil, fmt.Errorf("%w: %d/%d", apierr.ErrDeviceLimit, count, maxDevices)
+	}
+
+	// A session whose codes ran out before it used up its attempts is still
+	// loaded; start over with a new client
+	unlock := ws.sessions.Lock(sessionID)
+	if sc, ok := ws.sessions.Get(sessionID); ok && ws.sessions.Drain(sc) {
+		sc.stopQRRotation()
+		ws.finishQRAttempt(sc, QRAttemptCancelled)
+		sc.Client.Disconnect()
+		close(sc.stopChan)
+		ws.sessions.Remove(sc)
+	}
+	unlock()
+
+	if err := ws.db.ReactivateSession(sessionUUID); err != nil {
+		return nil, fmt.Errorf("failed to reactivate session: %w", err)
+	}
+	session.Status = StatusPending
+	if err := ws.InitializeClient(session); err != nil {
+		ws.db.UpdateSessionStatus(sessionUUID, StatusFailed)
+		return nil, err
+	}
+
+	ws.db.CreateEvent(sessionUUID, userID, "session_reactivated", nil)
+	return ws.db.GetSession(sessionUUID, userID)
+}
+
+// GetQRAttempts returns a page of a session's pairing attempts
+func (ws *WhatsAppService) GetQRAttempts(sessionID string, userID int, q ListQuery) ([]WhatsAppQRAttempt, int64, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, 0, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, 0, apierr.ErrSessionNotFound
+	}
+	return ws.db.GetQRAttempts(sessionID, q)
+}
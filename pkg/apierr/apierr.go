@@ -0,0 +1,95 @@
+// Package apierr defines the machine-readable error codes of the API. Every
+// error response carries a code next to its message, so clients can branch on
+// the code instead of parsing messages, and the same failure always gets the
+// same HTTP status.
+package apierr
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Error is an API error with a stable code and HTTP status. Services return
+// these (or wrap them with fmt.Errorf("%w: ...")) for failures a client can act
+// on; errors.Is and errors.As work through the wrapping.
+type Error struct {
+	Code    string
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// New creates an error; codes are snake_case and must never change once
+// released
+func New(status int, code, message string) *Error {
+	return &Error{Code: code, Status: status, Message: message}
+}
+
+var (
+	// Request errors
+	ErrInvalidRequest   = New(http.StatusBadRequest, "invalid_request", "invalid request")
+	ErrInvalidSessionID = New(http.StatusBadRequest, "invalid_session_id", "invalid session ID")
+	ErrInvalidRecipient = New(http.StatusBadRequest, "invalid_recipient", "invalid recipient")
+	ErrUnauthorized     = New(http.StatusUnauthorized, "unauthorized", "unauthorized")
+	ErrForbidden        = New(http.StatusForbidden, "forbidden", "forbidden")
+	ErrNotFound         = New(http.StatusNotFound, "not_found", "not found")
+	ErrConflict         = New(http.StatusConflict, "conflict", "conflict")
+	ErrGone             = New(http.StatusGone, "gone", "no longer available")
+	ErrPayloadTooLarge  = New(http.StatusRequestEntityTooLarge, "payload_too_large", "payload too large")
+
+	// Session errors
+	ErrSessionNotFound     = New(http.StatusNotFound, "session_not_found", "session not found or unauthorized")
+	ErrSessionNotConnected = New(http.StatusConflict, "session_not_connected", "session not connected")
+	ErrSessionExists       = New(http.StatusConflict, "session_exists", "session already exists")
+	ErrQRNotAvailable      = New(http.StatusConflict, "qr_not_available", "QR code not available")
+	ErrDeviceLimit         = New(http.StatusForbidden, "device_limit_reached", "device limit reached")
+	ErrQuotaExceeded       = New(http.StatusPaymentRequired, "quota_exceeded", "monthly message quota exceeded")
+
+	// Sending errors
+	ErrRecipientNotOnWhatsApp = New(http.StatusUnprocessableEntity, "recipient_not_on_whatsapp", "recipient is not registered on WhatsApp")
+	ErrRecipientOptedOut      = New(http.StatusUnprocessableEntity, "recipient_opted_out", "recipient has opted out")
+	ErrMediaTooLarge          = New(http.StatusRequestEntityTooLarge, "media_too_large", "media file too large")
+	ErrRateLimited            = New(http.StatusTooManyRequests, "rate_limited", "rate limited")
+
+	// Server errors
+	ErrInternal    = New(http.StatusInternalServerError, "internal_error", "internal error")
+	ErrUnavailable = New(http.StatusServiceUnavailable, "service_unavailable", "service unavailable")
+)
+
+// byStatus are the generic errors used for failures without a code
+var byStatus = map[int]*Error{
+	http.StatusBadRequest:            ErrInvalidRequest,
+	http.StatusUnauthorized:          ErrUnauthorized,
+	http.StatusForbidden:             ErrForbidden,
+	http.StatusNotFound:              ErrNotFound,
+	http.StatusConflict:              ErrConflict,
+	http.StatusGone:                  ErrGone,
+	http.StatusRequestEntityTooLarge: ErrPayloadTooLarge,
+	http.StatusTooManyRequests:       ErrRateLimited,
+	http.StatusInternalServerError:   ErrInternal,
+	http.StatusServiceUnavailable:    ErrUnavailable,
+}
+
+// As returns the API error in err's chain
+func As(err error) (*Error, bool) {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	return nil, false
+}
+
+// ForStatus returns the generic error of an HTTP status
+func ForStatus(status int) *Error {
+	if apiErr, ok := byStatus[status]; ok {
+		return apiErr
+	}
+	if status >= http.StatusInternalServerError {
+		return New(status, ErrInternal.Code, http.StatusText(status))
+	}
+	return New(status, strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"), http.StatusText(status))
+}
@@ -0,0 +1,95 @@
+package wajid
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRUCache is a bounded in-memory Cache; entries expire after ttl and the
+// least recently used entry is evicted once size is reached
+type LRUCache struct {
+	ttl   time.Duration
+	size  int
+	mu    sync.Mutex
+	order *list.List // front = most recently used
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	phone  string
+	lookup Lookup
+}
+
+// NewLRUCache creates an in-memory cache holding at most size entries
+func NewLRUCache(size int, ttl time.Duration) *LRUCache {
+	if size <= 0 {
+		size = 1
+	}
+	return &LRUCache{
+		ttl:   ttl,
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+func (c *LRUCache) Get(phone string) (Lookup, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[phone]
+	if !ok {
+		return Lookup{}, false
+	}
+
+	entry := elem.Value.(*lruEntry)
+	if time.Since(entry.lookup.CheckedAt) > c.ttl {
+		c.order.Remove(elem)
+		delete(c.items, phone)
+		return Lookup{}, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.lookup, true
+}
+
+func (c *LRUCache) Set(phone string, lookup Lookup) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[phone]; ok {
+		elem.Value.(*lruEntry).lookup = lookup
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[phone] = c.order.PushFront(&lruEntry{phone: phone, lookup: lookup})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).phone)
+	}
+}
+
+// ChainCache checks several caches in order (e.g. memory, then database).
+// A hit in a later cache is copied into the earlier ones; Set writes to all.
+type ChainCache []Cache
+
+func (c ChainCache) Get(phone string) (Lookup, bool) {
+	for i, cache := range c {
+		if lookup, ok := cache.Get(phone); ok {
+			for _, earlier := range c[:i] {
+				earlier.Set(phone, lookup)
+			}
+			return lookup, true
+		}
+	}
+	return Lookup{}, false
+}
+
+func (c ChainCache) Set(phone string, lookup Lookup) {
+	for _, cache := range c {
+		cache.Set(phone, lookup)
+	}
+}
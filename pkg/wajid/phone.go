@@ -0,0 +1,86 @@
+package wajid
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+// phoneTypes are the names of the phonenumbers line types
+var phoneTypes = map[phonenumbers.PhoneNumberType]string{
+	phonenumbers.FIXED_LINE:           "fixed_line",
+	phonenumbers.MOBILE:               "mobile",
+	phonenumbers.FIXED_LINE_OR_MOBILE: "fixed_line_or_mobile",
+	phonenumbers.TOLL_FREE:            "toll_free",
+	phonenumbers.PREMIUM_RATE:         "premium_rate",
+	phonenumbers.SHARED_COST:          "shared_cost",
+	phonenumbers.VOIP:                 "voip",
+	phonenumbers.PERSONAL_NUMBER:      "personal_number",
+	phonenumbers.PAGER:                "pager",
+	phonenumbers.UAN:                  "uan",
+	phonenumbers.VOICEMAIL:            "voicemail",
+}
+
+// PhoneDetails is a parsed phone number
+type PhoneDetails struct {
+	E164           string `json:"e164"`   // with the leading +
+	Digits         string `json:"digits"` // E.164 without the +, the user part of its JID
+	CountryCode    int    `json:"country_code"`
+	Region         string `json:"region,omitempty"` // ISO 3166-1 alpha-2, empty when the code is shared and the number doesn't tell
+	NationalNumber string `json:"national_number"`
+	International  string `json:"international"` // formatted for display
+	Type           string `json:"type"`          // mobile, fixed_line, ... or unknown
+	Valid          bool   `json:"is_valid"`      // matches the numbering plan, not just its length
+}
+
+// IsRegion reports whether region is a region code the phone metadata knows
+func IsRegion(region string) bool {
+	return phonenumbers.GetSupportedRegions()[strings.ToUpper(region)]
+}
+
+// ParsePhone parses a phone number in international format or, with a
+// default region (ISO 3166-1 alpha-2), in that region's national format.
+// Like NormalizePhone it only requires the number to be possible; Valid
+// reports whether it also matches the numbering plan.
+func ParsePhone(input, defaultRegion string) (PhoneDetails, error) {
+	trimmed := strings.TrimSpace(input)
+	international := defaultRegion == "" || strings.HasPrefix(trimmed, "+") || strings.HasPrefix(trimmed, "00")
+
+	var (
+		parsed *phonenumbers.PhoneNumber
+		err    error
+	)
+	if international {
+		number, normErr := NormalizePhone(trimmed)
+		if normErr != nil {
+			return PhoneDetails{}, normErr
+		}
+		parsed, err = phonenumbers.Parse("+"+number, "")
+	} else {
+		parsed, err = phonenumbers.Parse(trimmed, strings.ToUpper(defaultRegion))
+	}
+	if err != nil {
+		return PhoneDetails{}, fmt.Errorf("%w: %v", ErrInvalidPhone, err)
+	}
+	if !phonenumbers.IsPossibleNumber(parsed) {
+		return PhoneDetails{}, fmt.Errorf("%w: %s", ErrInvalidPhone, trimmed)
+	}
+
+	e164 := phonenumbers.Format(parsed, phonenumbers.E164)
+	numberType, ok := phoneTypes[phonenumbers.GetNumberType(parsed)]
+	if !ok {
+		numberType = "unknown"
+	}
+	return PhoneDetails{
+		E164:           e164,
+		Digits:         strings.TrimPrefix(e164, "+"),
+		CountryCode:    int(parsed.GetCountryCode()),
+		Region:         phonenumbers.GetRegionCodeForNumber(parsed),
+		NationalNumber: strconv.FormatUint(parsed.GetNationalNumber(), 10),
+		International:  phonenumbers.Format(parsed, phonenumbers.INTERNATIONAL),
+		Type:           numberType,
+		Valid:          phonenumbers.IsValidNumber(parsed),
+	}, nil
+}
@@ -0,0 +1,172 @@
+package wajid
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Checker is the part of the whatsmeow client used to verify phone numbers
+type Checker interface {
+	IsOnWhatsApp(ctx context.Context, phones []string) ([]types.IsOnWhatsAppResponse, error)
+}
+
+// Cache stores IsOnWhatsApp lookups keyed by E.164 digits. Implementations
+// are responsible for expiring entries.
+type Cache interface {
+	Get(phone string) (Lookup, bool)
+	Set(phone string, lookup Lookup)
+}
+
+// Lookup is a cached IsOnWhatsApp result
+type Lookup struct {
+	Phone      string    `json:"phone_number"`
+	JID        types.JID `json:"jid"`
+	Registered bool      `json:"is_registered"`
+	CheckedAt  time.Time `json:"checked_at"`
+	Cached     bool      `json:"cached"`
+}
+
+// Resolver turns user input into recipient JIDs, verifying phone numbers
+// with IsOnWhatsApp and caching the results
+type Resolver struct {
+	cache Cache
+}
+
+// NewResolver creates a resolver. A nil cache disables caching.
+func NewResolver(cache Cache) *Resolver {
+	return &Resolver{cache: cache}
+}
+
+// Resolve parses a JID or phone number. Phone numbers are verified with
+// IsOnWhatsApp and resolved to the JID returned by WhatsApp (which may be a LID).
+func (r *Resolver) Resolve(ctx context.Context, checker Checker, input string) (types.JID, error) {
+	if IsJID(input) {
+		return ParseJID(input)
+	}
+
+	lookup, err := r.Lookup(ctx, checker, input, false)
+	if err != nil {
+		return types.JID{}, err
+	}
+	if !lookup.Registered {
+		return types.JID{}, fmt.Errorf("phone number %s is %w", lookup.Phone, ErrNotOnWhatsApp)
+	}
+	return lookup.JID, nil
+}
+
+// Lookup verifies a phone number with IsOnWhatsApp. Cached results are used
+// unless forceRefresh is set.
+func (r *Resolver) Lookup(ctx context.Context, checker Checker, phone string, forceRefresh bool) (Lookup, error) {
+	number, err := NormalizePhone(phone)
+	if err != nil {
+		return Lookup{}, err
+	}
+
+	lookups, err := r.lookupNormalized(ctx, checker, []string{number}, forceRefresh)
+	if err != nil {
+		return Lookup{}, err
+	}
+	return lookups[0], nil
+}
+
+// LookupMany verifies several phone numbers with a single IsOnWhatsApp query
+// for the ones that aren't cached. Results are keyed by the input string;
+// inputs that aren't valid phone numbers are returned in invalid.
+func (r *Resolver) LookupMany(ctx context.Context, checker Checker, phones []string, forceRefresh bool) (map[string]Lookup, map[string]error, error) {
+	invalid := make(map[string]error)
+	inputsByNumber := make(map[string][]string)
+	numbers := make([]string, 0, len(phones))
+
+	for _, phone := range phones {
+		number, err := NormalizePhone(phone)
+		if err != nil {
+			invalid[phone] = err
+			continue
+		}
+		if _, seen := inputsByNumber[number]; !seen {
+			numbers = append(numbers, number)
+		}
+		inputsByNumber[number] = append(inputsByNumber[number], phone)
+	}
+
+	results := make(map[string]Lookup, len(phones))
+	if len(numbers) == 0 {
+		return results, invalid, nil
+	}
+
+	lookups, err := r.lookupNormalized(ctx, checker, numbers, forceRefresh)
+	if err != nil {
+		return nil, invalid, err
+	}
+	for _, lookup := range lookups {
+		for _, input := range inputsByNumber[lookup.Phone] {
+			results[input] = lookup
+		}
+	}
+	return results, invalid, nil
+}
+
+// lookupNormalized resolves E.164 digit strings, returning results in input order
+func (r *Resolver) lookupNormalized(ctx context.Context, checker Checker, numbers []string, forceRefresh bool) ([]Lookup, error) {
+	lookups := make([]Lookup, len(numbers))
+	missing := make([]string, 0, len(numbers))
+	missingIdx := make(map[string][]int)
+
+	for i, number := range numbers {
+		if r.cache != nil && !forceRefresh {
+			if lookup, ok := r.cache.Get(number); ok {
+				lookup.Phone = number
+				lookup.Cached = true
+				lookups[i] = lookup
+				continue
+			}
+		}
+		if _, seen := missingIdx[number]; !seen {
+			missing = append(missing, "+"+number)
+		}
+		missingIdx[number] = append(missingIdx[number], i)
+	}
+
+	if len(missing) == 0 {
+		return lookups, nil
+	}
+
+	resp, err := checker.IsOnWhatsApp(ctx, missing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify WhatsApp number: %w", err)
+	}
+
+	byQuery := make(map[string]types.IsOnWhatsAppResponse, len(resp))
+	for _, item := range resp {
+		byQuery[item.Query] = item
+	}
+
+	now := time.Now()
+	for number, indexes := range missingIdx {
+		lookup := Lookup{
+			Phone:     number,
+			JID:       types.NewJID(number, types.DefaultUserServer),
+			CheckedAt: now,
+		}
+		if item, ok := byQuery["+"+number]; ok && item.IsIn {
+			lookup.JID = item.JID
+			lookup.Registered = true
+		} else if len(missing) == 1 && len(resp) == 1 && resp[0].IsIn {
+			// Some server responses don't echo the query back
+			lookup.JID = resp[0].JID
+			lookup.Registered = true
+		}
+
+		if r.cache != nil {
+			r.cache.Set(number, lookup)
+		}
+		for _, i := range indexes {
+			lookups[i] = lookup
+		}
+	}
+
+	return lookups, nil
+}
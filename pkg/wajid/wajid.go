@@ -0,0 +1,129 @@
+// Package wajid parses and validates WhatsApp recipients: JIDs (users, LIDs,
+// groups, broadcast lists, newsletters) and phone numbers.
+package wajid
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+	"go.mau.fi/whatsmeow/types"
+)
+
+var (
+	// ErrInvalidJID is returned for strings that contain @ but are not a valid JID
+	ErrInvalidJID = errors.New("invalid JID format")
+	// ErrInvalidPhone is returned for phone numbers that can't be a real number
+	ErrInvalidPhone = errors.New("invalid phone number format")
+	// ErrNotOnWhatsApp is returned when a phone number is not registered on WhatsApp
+	ErrNotOnWhatsApp = errors.New("not registered on WhatsApp")
+)
+
+// knownServers are the JID servers accepted as recipients
+var knownServers = map[string]bool{
+	types.DefaultUserServer: true,
+	types.HiddenUserServer:  true,
+	types.GroupServer:       true,
+	types.BroadcastServer:   true,
+	types.NewsletterServer:  true,
+	types.LegacyUserServer:  true,
+}
+
+// IsJID reports whether the input looks like a JID rather than a phone number
+func IsJID(input string) bool {
+	return strings.Contains(input, "@")
+}
+
+// ParseJID parses a JID string. Legacy c.us user JIDs are converted to
+// s.whatsapp.net and the device part of user JIDs is dropped.
+func ParseJID(input string) (types.JID, error) {
+	jid, err := types.ParseJID(strings.TrimSpace(input))
+	if err != nil {
+		return types.JID{}, fmt.Errorf("%w: %v", ErrInvalidJID, err)
+	}
+	if jid.User == "" && jid.Server != types.BroadcastServer {
+		return types.JID{}, fmt.Errorf("%w: missing user part", ErrInvalidJID)
+	}
+	if !knownServers[jid.Server] {
+		return types.JID{}, fmt.Errorf("%w: unknown server %q", ErrInvalidJID, jid.Server)
+	}
+
+	if jid.Server == types.LegacyUserServer {
+		jid.Server = types.DefaultUserServer
+	}
+	if IsUser(jid) {
+		jid = jid.ToNonAD()
+	}
+	return jid, nil
+}
+
+// NormalizePhone validates a phone number in international format (with or
+// without the leading +, 00 prefix and separators) and returns its E.164
+// digits without the +.
+func NormalizePhone(input string) (string, error) {
+	digits := make([]byte, 0, len(input))
+	for i := 0; i < len(input); i++ {
+		if input[i] >= '0' && input[i] <= '9' {
+			digits = append(digits, input[i])
+		}
+	}
+
+	number := string(digits)
+	if strings.HasPrefix(strings.TrimSpace(input), "00") {
+		number = strings.TrimPrefix(number, "00")
+	}
+	if number == "" {
+		return "", ErrInvalidPhone
+	}
+
+	parsed, err := phonenumbers.Parse("+"+number, "")
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPhone, err)
+	}
+	// Possible rather than valid: the metadata lags behind newly allocated ranges
+	if !phonenumbers.IsPossibleNumber(parsed) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidPhone, number)
+	}
+
+	return strings.TrimPrefix(phonenumbers.Format(parsed, phonenumbers.E164), "+"), nil
+}
+
+// PhoneJID builds an s.whatsapp.net JID from a phone number without checking
+// whether it's registered on WhatsApp
+func PhoneJID(input string) (types.JID, error) {
+	number, err := NormalizePhone(input)
+	if err != nil {
+		return types.JID{}, err
+	}
+	return types.NewJID(number, types.DefaultUserServer), nil
+}
+
+// Parse accepts either a JID or a phone number and returns the JID without
+// verifying registration. Use a Resolver to verify phone numbers.
+func Parse(input string) (types.JID, error) {
+	if IsJID(input) {
+		return ParseJID(input)
+	}
+	return PhoneJID(input)
+}
+
+// IsUser reports whether the JID is an individual account (phone number or LID)
+func IsUser(jid types.JID) bool {
+	return jid.Server == types.DefaultUserServer || jid.Server == types.HiddenUserServer
+}
+
+// IsLID reports whether the JID is a hidden-user LID
+func IsLID(jid types.JID) bool {
+	return jid.Server == types.HiddenUserServer
+}
+
+// IsGroup reports whether the JID is a group
+func IsGroup(jid types.JID) bool {
+	return jid.Server == types.GroupServer
+}
+
+// IsBroadcastList reports whether the JID is a broadcast list (not the status broadcast)
+func IsBroadcastList(jid types.JID) bool {
+	return jid.Server == types.BroadcastServer && jid.User != "status"
+}
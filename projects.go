@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= PROJECTS =============
+// A project groups the sessions of a user, e.g. per customer or team, for
+// users running many devices. A session belongs to at most one project:
+// it's assigned on creation or with PUT /sessions/:session_id/project, and
+// deleting a project leaves its sessions without one. GET /sessions filters
+// on ?project_id= ("none" for unassigned sessions), the project list carries
+// the session counts of each project and the device summary can be narrowed
+// to one project. The device limit stays per user, so a project summary still
+// reports the user's available slots.
+
+const (
+	projectNameMaxLength        = 100
+	projectDescriptionMaxLength = 500
+)
+
+// ProjectRequest creates or updates a project
+type ProjectRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+// ProjectWithCounts is a project with the counts of its active sessions
+type ProjectWithCounts struct {
+	WhatsAppProject
+	Sessions  int `json:"sessions"`
+	Connected int `json:"connected"`
+}
+
+func normalizeProjectRequest(req ProjectRequest) (ProjectRequest, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > projectNameMaxLength {
+		return req, fmt.Errorf("%w: name must be 1 to %d characters", apierr.ErrInvalidRequest, projectNameMaxLength)
+	}
+	if len(req.Description) > projectDescriptionMaxLength {
+		return req, fmt.Errorf("%w: description must be at most %d characters", apierr.ErrInvalidRequest, projectDescriptionMaxLength)
+	}
+	return req, nil
+}
+
+// CreateProject creates a project of the user
+func (ws *WhatsAppService) CreateProject(userID int, req ProjectRequest) (*WhatsAppProject, error) {
+	req, err := normalizeProjectRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	project := &WhatsAppProject{
+		UserID:      userID,
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	if err := ws.db.CreateProject(project); err != nil {
+		if isDuplicateKeyError(err) {
+			return nil, fmt.Errorf("%w: project %q already exists", apierr.ErrConflict, req.Name)
+		}
+		return nil, fmt.Errorf("failed to create project: %w", err)
+	}
+
+	log.Printf("📁 Project %d %q created", project.ID, project.Name)
+	return project, nil
+}
+
+// GetProjects lists the user's projects with their session counts
+func (ws *WhatsAppService) GetProjects(userID int) ([]ProjectWithCounts, error) {
+	projects, err := ws.db.GetProjects(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load projects: %w", err)
+	}
+	counts, err := ws.db.GetProjectCounts(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count project sessions: %w", err)
+	}
+
+	result := make([]ProjectWithCounts, 0, len(projects))
+	for _, project := range projects {
+		count := counts[project.ID]
+		result = append(result, ProjectWithCounts{
+			WhatsAppProject: project,
+			Sessions:        count.Sessions,
+			Connected:       count.Connected,
+		})
+	}
+	return result, nil
+}
+
+// GetProject returns a project of the user
+func (ws *WhatsAppService) GetProject(userID int, projectID int64) (*WhatsAppProject, error) {
+	project, err := ws.db.GetProject(projectID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: project not found", apierr.ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to load project: %w", err)
+	}
+	return project, nil
+}
+
+// UpdateProject replaces the name and description of a project
+func (ws *WhatsAppService) UpdateProject(userID int, projectID int64, req ProjectRequest) (*WhatsAppProject, error) {
+	req, err := normalizeProjectRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	project, err := ws.GetProject(userID, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	project.Name = req.Name
+	project.Description = req.Description
+	if err := ws.db.UpdateProject(project); err != nil {
+		if isDuplicateKeyError(err) {
+			return nil, fmt.Errorf("%w: project %q already exists", apierr.ErrConflict, req.Name)
+		}
+		return nil, fmt.Errorf("failed to update project: %w", err)
+	}
+	return project, nil
+}
+
+// DeleteProject removes a project; its sessions are kept without a project
+func (ws *WhatsAppService) DeleteProject(userID int, projectID int64) error {
+	deleted, err := ws.db.DeleteProject(projectID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete project: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%w: project not found", apierr.ErrNotFound)
+	}
+	log.Printf("📁 Project %d deleted", projectID)
+	return nil
+}
+
+// SetSessionProject moves a session of the user to a project, or out of its
+// project when projectID is nil
+func (ws *WhatsAppService) SetSessionProject(sessionID string, userID int, projectID *int64) error {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return apierr.ErrSessionNotFound
+	}
+	if projectID != nil {
+		if _, err := ws.GetProject(userID, *projectID); err != nil {
+			return err
+		}
+	}
+
+	if err := ws.db.SetSessionProject(sessionID, projectID); err != nil {
+		return fmt.Errorf("failed to update session project: %w", err)
+	}
+	return nil
+}
+
+// GetProjectDeviceSummary returns the device summary of one project
+func (ws *WhatsAppService) GetProjectDeviceSummary(userID int, projectID int64) (*DeviceSummary, error) {
+	if _, err := ws.GetProject(userID, projectID); err != nil {
+		return nil, err
+	}
+	summary, err := ws.db.GetProjectDeviceSummary(userID, ws.MaxDevices(userID), &projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize project devices: %w", err)
+	}
+	return summary, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= SESSION PURGE =============
+// Deleting a session only soft-deletes its row; its data stays so a mistaken
+// delete can be investigated. A deleted session is purged SESSION_PURGE_GRACE
+// after its deletion by the purge worker, or earlier with
+// POST /sessions/:session_id/purge: the first call returns a confirmation
+// token and what would be removed, a second call with the token purges.
+//
+// A purge removes the session row, its device from the whatsmeow store
+// (unless a live session uses the same account), everything stored per
+// session (messages, chats, events, calls, groups, outbox, broadcast lists,
+// campaigns, channels, ...) and its files: avatars, status and incoming media
+// and chat exports. The user's contacts go with their last session. Audit
+// log entries of the session are kept for the user's audit trail but lose
+// the request, IP address, user agent and concrete path.
+
+const (
+	purgePollInterval = time.Hour
+	purgeBatchSize    = 20
+	purgeTokenTTL     = 15 * time.Minute
+)
+
+// SessionPurgeRequest confirms a session purge
+type SessionPurgeRequest struct {
+	ConfirmToken string `json:"confirm_token"`
+}
+
+// SessionPurgeConfirmation is what a purge of a session would remove and the
+// token confirming it
+type SessionPurgeConfirmation struct {
+	SessionID    string           `json:"session_id"`
+	DeletedAt    time.Time        `json:"deleted_at"`
+	PurgeAt      *time.Time       `json:"purge_at,omitempty"` // scheduled purge, none while SESSION_PURGE_GRACE is 0
+	ConfirmToken string           `json:"confirm_token"`
+	ExpiresAt    time.Time        `json:"expires_at"`
+	Data         map[string]int64 `json:"data"` // rows by table
+}
+
+// SessionPurgeResult is the outcome of a session purge
+type SessionPurgeResult struct {
+	SessionID     string           `json:"session_id"`
+	Deleted       map[string]int64 `json:"deleted"`
+	Anonymized    map[string]int64 `json:"anonymized"`
+	DeviceRemoved bool             `json:"device_removed"`
+	PurgedAt      time.Time        `json:"purged_at"`
+}
+
+// purgeTokenHash is the stored form of a purge confirmation token
+func purgeTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// PurgeSession purges a deleted session of a user. Without a confirmation
+// token it issues one, valid for 15 minutes, with what the purge would
+// remove; with the token it purges.
+func (ws *WhatsAppService) PurgeSession(sessionID string, userID int, req SessionPurgeRequest) (*SessionPurgeConfirmation, *SessionPurgeResult, error) {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return nil, nil, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetDeletedSession(sessionID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if _, err := ws.db.GetSession(uuid.MustParse(sessionID), userID); err == nil {
+			return nil, nil, fmt.Errorf("%w: delete the session before purging it", apierr.ErrConflict)
+		}
+		return nil, nil, apierr.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load session: %w", err)
+	}
+
+	if req.ConfirmToken == "" {
+		confirmation, err := ws.issuePurgeToken(session)
+		return confirmation, nil, err
+	}
+
+	if session.PurgeTokenHash == "" || session.PurgeTokenExpiry == nil || time.Now().After(*session.PurgeTokenExpiry) ||
+		subtle.ConstantTimeCompare([]byte(purgeTokenHash(req.ConfirmToken)), []byte(session.PurgeTokenHash)) != 1 {
+		return nil, nil, fmt.Errorf("%w: invalid or expired confirm_token", apierr.ErrInvalidRequest)
+	}
+	result, err := ws.purgeSession(session)
+	return nil, result, err
+}
+
+// issuePurgeToken stores a new purge confirmation token of a deleted session
+func (ws *WhatsAppService) issuePurgeToken(session *WhatsAppSession) (*SessionPurgeConfirmation, error) {
+	data, err := ws.db.CountSessionData(session.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count session data: %w", err)
+	}
+
+	token := uuid.NewString()
+	expiresAt := time.Now().Add(purgeTokenTTL)
+	if err := ws.db.SetSessionPurgeToken(session.ID, purgeTokenHash(token), expiresAt); err != nil {
+		return nil, fmt.Errorf("failed to store purge token: %w", err)
+	}
+
+	confirmation := &SessionPurgeConfirmation{
+		SessionID:    session.ID,
+		DeletedAt:    session.DeletedAt.Time,
+		ConfirmToken: token,
+		ExpiresAt:    expiresAt,
+		Data:         data,
+	}
+	if ws.cfg.SessionPurgeGrace > 0 {
+		purgeAt := session.DeletedAt.Time.Add(ws.cfg.SessionPurgeGrace)
+		confirmation.PurgeAt = &purgeAt
+	}
+	return confirmation, nil
+}
+
+// StartPurgeWorker starts purging the sessions deleted longer than
+// SESSION_PURGE_GRACE ago
+func (ws *WhatsAppService) StartPurgeWorker(ctx context.Context) {
+	if ws.cfg.SessionPurgeGrace <= 0 {
+		log.Println("ℹ️  Session purge worker disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(purgePollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ws.processPurges(ctx)
+			}
+		}
+	}()
+	log.Println("✅ Session purge worker started")
+}
+
+func (ws *WhatsAppService) processPurges(ctx context.Context) {
+	sessions, err := ws.db.GetPurgeDueSessions(time.Now().Add(-ws.cfg.SessionPurgeGrace), purgeBatchSize)
+	if err != nil {
+		log.Printf("❌ Failed to load sessions due for purge: %v", err)
+		return
+	}
+
+	for i := range sessions {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := ws.purgeSession(&sessions[i]); err != nil {
+			log.Printf("❌ Failed to purge session %s: %v", sessions[i].ID, err)
+		}
+	}
+}
+
+// purgeSession removes a deleted session with its data and files
+func (ws *WhatsAppService) purgeSession(session *WhatsAppSession) (*SessionPurgeResult, error) {
+	unlock := ws.sessions.Lock(session.ID)
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	result := &SessionPurgeResult{SessionID: session.ID}
+	if session.JID != nil && *session.JID != "" {
+		// The account may have been paired again by a live session
+		live, err := ws.db.GetSessionsByJIDs([]string{*session.JID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to check the session's device: %w", err)
+		}
+		if len(live) == 0 {
+			result.DeviceRemoved = ws.deleteStoredDevice(ctx, session.ID, *session.JID)
+		}
+	}
+
+	for what, prefix := range map[string]string{
+		"avatar cache":          avatarKeyPrefix(session.ID),
+		"status media":          statusMediaPrefix(session.ID),
+		"stored incoming media": inboundMediaPrefix(session.ID),
+	} {
+		if err := ws.media.DeletePrefix(ctx, prefix); err != nil {
+			return nil, fmt.Errorf("failed to remove %s: %w", what, err)
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(ws.cfg.ExportDir, session.ID)); err != nil {
+		return nil, fmt.Errorf("failed to remove chat exports: %w", err)
+	}
+
+	counts, err := ws.db.PurgeSession(session.ID, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	ws.safety.Delete(session.ID)
+	ws.wsManager.invalidateEventFilters(session.UserID)
+
+	result.Deleted = counts.Deleted
+	result.Anonymized = counts.Anonymized
+	result.PurgedAt = time.Now()
+	log.Printf("🧹 Purged session %s of user %d (device removed: %v)", session.ID, session.UserID, result.DeviceRemoved)
+	return result, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"whatsapp-api/internal/ratelimit"
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= API RATE LIMITS =============
+// Authenticated REST calls are limited per user and endpoint class: sends
+// (anything that sends WhatsApp messages), reads (GET) and other writes each
+// have their own requests-per-minute and burst, and single users can get
+// their own limits (RATE_LIMIT_USER_LIMITS). Every limited response carries
+// X-RateLimit-Limit/Remaining/Reset; rejected calls get 429 with Retry-After.
+// Limits are kept in memory or, with RATE_LIMIT_STORE=redis, shared by all
+// API instances. These limits protect the API; the safety engine separately
+// paces what each WhatsApp number sends.
+
+// Endpoint classes
+const (
+	RateClassSend  = "send"
+	RateClassRead  = "read"
+	RateClassWrite = "write"
+)
+
+// RateLimiter applies the configured limits to API calls
+type RateLimiter struct {
+	store     ratelimit.Store
+	mu        sync.RWMutex // guards the limits, which config reloads replace
+	limits    map[string]ratelimit.Limit
+	userLimit map[int]map[string]ratelimit.Limit
+}
+
+// NewRateLimiter creates the limiter of the config, or nil when rate limiting
+// is disabled
+func NewRateLimiter(cfg *Config) (*RateLimiter, error) {
+	if !cfg.RateLimitEnabled {
+		return nil, nil
+	}
+
+	store, err := ratelimit.New(ratelimit.Config{
+		Store:         cfg.RateLimitStore,
+		RedisAddr:     cfg.RedisAddr,
+		RedisPassword: cfg.RedisPassword,
+		RedisDB:       cfg.RedisDB,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	userLimits, err := parseUserRateLimits(cfg.RateLimitUserLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	rl := &RateLimiter{store: store}
+	rl.SetLimits(cfg, userLimits)
+	return rl, nil
+}
+
+// SetLimits replaces the class limits and per-user limits
+func (rl *RateLimiter) SetLimits(cfg *Config, userLimits map[int]map[string]ratelimit.Limit) {
+	if rl == nil {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.limits = map[string]ratelimit.Limit{
+		RateClassSend:  {PerMinute: cfg.RateLimitSendPerMinute, Burst: cfg.RateLimitSendBurst},
+		RateClassRead:  {PerMinute: cfg.RateLimitReadPerMinute, Burst: cfg.RateLimitReadBurst},
+		RateClassWrite: {PerMinute: cfg.RateLimitPerMinute, Burst: cfg.RateLimitBurst},
+	}
+	rl.userLimit = userLimits
+}
+
+// limit returns the limit of a user for a class
+func (rl *RateLimiter) limit(userID int, class string) ratelimit.Limit {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	if limit, ok := rl.userLimit[userID][class]; ok {
+		return limit
+	}
+	return rl.limits[class]
+}
+
+// Middleware limits the routes it is attached to; it must run after
+// AuthMiddleware. A limiter that can't reach its store lets calls through.
+func (rl *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rl == nil {
+			c.Next()
+			return
+		}
+
+		userID := c.GetInt("user_id")
+		class := rateLimitClass(c)
+		limit := rl.limit(userID, class)
+		if !limit.Enabled() {
+			c.Next()
+			return
+		}
+
+		res, err := rl.store.Take(c.Request.Context(), fmt.Sprintf("%s:%d", class, userID), limit)
+		if err != nil {
+			log.Printf("⚠️  Rate limit check failed (allowing request): %v", err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+		if !res.Allowed {
+			retryAfter := ceilSeconds(res.RetryAfter)
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.AbortWithStatusJSON(apierr.ErrRateLimited.Status, gin.H{
+				"success":  false,
+				"error":    fmt.Sprintf("Too many %s requests, limit is %s", class, limit),
+				"code":     apierr.ErrRateLimited.Code,
+				"retry_at": time.Now().Add(res.RetryAfter),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// rateLimitClass sorts a call into an endpoint class by its route
+func rateLimitClass(c *gin.Context) string {
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		return RateClassRead
+	}
+
+	route := c.FullPath()
+	switch {
+	case strings.Contains(route, "/send"), // send, send-advanced, /messages/send/*, broadcast sends
+		strings.HasSuffix(route, "/sessions/:session_id/notes"), // notes to self, not internal chat notes
+		c.Request.Method == http.MethodPost && strings.HasSuffix(route, "/campaigns"),
+		strings.HasSuffix(route, "/retry-failed"),
+		c.Request.Method == http.MethodPost && strings.HasSuffix(route, "/status/:session_id"):
+		return RateClassSend
+	}
+	return RateClassWrite
+}
+
+// parseUserRateLimits parses RATE_LIMIT_USER_LIMITS:
+// "<user_id>:<class>=<per_minute>/<burst>[,...]", e.g. "7:send=300/50,7:read=1200/100"
+func parseUserRateLimits(spec string) (map[int]map[string]ratelimit.Limit, error) {
+	limits := make(map[int]map[string]ratelimit.Limit)
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return limits, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		user, rest, ok := strings.Cut(entry, ":")
+		class, rate, ok2 := strings.Cut(rest, "=")
+		perMinute, burst, ok3 := strings.Cut(rate, "/")
+		if !ok || !ok2 || !ok3 {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_USER_LIMITS entry %q (expected user_id:class=per_minute/burst)", entry)
+		}
+
+		userID, err := strconv.Atoi(user)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user ID in RATE_LIMIT_USER_LIMITS entry %q", entry)
+		}
+		switch class {
+		case RateClassSend, RateClassRead, RateClassWrite:
+		default:
+			return nil, fmt.Errorf("unknown class %q in RATE_LIMIT_USER_LIMITS (expected send, read or write)", class)
+		}
+		limit := ratelimit.Limit{}
+		if limit.PerMinute, err = strconv.Atoi(perMinute); err != nil || limit.PerMinute < 0 {
+			return nil, fmt.Errorf("invalid rate in RATE_LIMIT_USER_LIMITS entry %q", entry)
+		}
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst < 0 {
+			return nil, fmt.Errorf("invalid burst in RATE_LIMIT_USER_LIMITS entry %q", entry)
+		}
+
+		if limits[userID] == nil {
+			limits[userID] = make(map[string]ratelimit.Limit)
+		}
+		limits[userID][class] = limit
+	}
+	return limits, nil
+}
+
+// ceilSeconds rounds a duration up to whole seconds for headers
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
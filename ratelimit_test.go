@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitClass(t *testing.T) {
+	tests := []struct {
+		method string
+		route  string
+		path   string
+		want   string
+	}{
+		{http.MethodPost, "/sessions/:session_id/send", "/sessions/s1/send", RateClassSend},
+		{http.MethodPost, "/sessions/:session_id/notes", "/sessions/s1/notes", RateClassSend},
+		{http.MethodPost, "/chats/:session_id/:jid/notes", "/chats/s1/123@s.whatsapp.net/notes", RateClassWrite},
+		{http.MethodGet, "/chats/:session_id/:jid/notes", "/chats/s1/123@s.whatsapp.net/notes", RateClassRead},
+		{http.MethodPost, "/contact-notes/:jid", "/contact-notes/123@s.whatsapp.net", RateClassWrite},
+		{http.MethodPost, "/campaigns", "/campaigns", RateClassSend},
+		{http.MethodPost, "/status/:session_id", "/status/s1", RateClassSend},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.route, func(t *testing.T) {
+			var got string
+			router := gin.New()
+			router.Handle(tt.method, "/api/v1"+tt.route, func(c *gin.Context) {
+				got = rateLimitClass(c)
+			})
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, "/api/v1"+tt.path, nil))
+			if got != tt.want {
+				t.Errorf("class = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= READ RECEIPTS =============
+// Two separate switches control the blue ticks of a session. The session's
+// send_read_receipts setting is ours: when it's off, marking a chat read only
+// updates the stored chat and never sends a read receipt, so the session can
+// read without notifying the sender. The account's read receipts privacy
+// setting is WhatsApp's: set to "none", WhatsApp stops showing read receipts
+// in both directions (except in groups), on every device of the account.
+
+// ReadReceiptSettingsRequest changes the read receipt settings of a session
+type ReadReceiptSettingsRequest struct {
+	Send    *bool   `json:"send"`    // send read receipts when a chat is marked read
+	Privacy *string `json:"privacy"` // the account's read receipts privacy: all or none
+}
+
+// ReadReceiptSettings are the read receipt settings of a session
+type ReadReceiptSettings struct {
+	Send    bool   `json:"send"`
+	Privacy string `json:"privacy,omitempty"` // empty while the session isn't connected
+}
+
+// GetReadReceiptSettings returns the read receipt settings of a session; the
+// privacy setting is read from WhatsApp when the session is connected
+func (ws *WhatsAppService) GetReadReceiptSettings(sessionID string, userID int) (*ReadReceiptSettings, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	settings := &ReadReceiptSettings{Send: session.SendReadReceipts}
+	sc, err := ws.getConnectedClient(sessionID, userID)
+	if err != nil {
+		return settings, nil
+	}
+	var privacy *types.PrivacySettings
+	err = ws.callWhatsApp(sc, priorityInteractive, "get privacy settings", func() (err error) {
+		privacy, err = sc.Client.TryFetchPrivacySettings(context.Background(), false)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get privacy settings: %w", err)
+	}
+	settings.Privacy = string(privacy.ReadReceipts)
+	return settings, nil
+}
+
+// UpdateReadReceiptSettings changes the send setting of a session and, when
+// given, the account's read receipts privacy setting
+func (ws *WhatsAppService) UpdateReadReceiptSettings(sessionID string, userID int, req ReadReceiptSettingsRequest) (*ReadReceiptSettings, error) {
+	if req.Send == nil && req.Privacy == nil {
+		return nil, fmt.Errorf("nothing to update")
+	}
+	var privacy types.PrivacySetting
+	if req.Privacy != nil {
+		privacy = types.PrivacySetting(*req.Privacy)
+		if privacy != types.PrivacySettingAll && privacy != types.PrivacySettingNone {
+			return nil, fmt.Errorf("%w: invalid privacy %q: expected all or none", apierr.ErrInvalidRequest, *req.Privacy)
+		}
+	}
+
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	// The privacy setting first: it needs the connection, and a failure
+	// shouldn't leave the send setting half applied
+	if req.Privacy != nil {
+		sc, err := ws.getConnectedClient(sessionID, userID)
+		if err != nil {
+			return nil, err
+		}
+		err = ws.callWhatsApp(sc, priorityInteractive, "set privacy setting", func() error {
+			_, err := sc.Client.SetPrivacySetting(context.Background(), types.PrivacySettingTypeReadReceipts, privacy)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to set read receipts privacy: %w", err)
+		}
+		log.Printf("🔒 Read receipts privacy of session %s set to %s", sessionID, privacy)
+	}
+	if req.Send != nil {
+		if err := ws.db.SetSessionReadReceipts(sessionID, *req.Send); err != nil {
+			return nil, fmt.Errorf("failed to update read receipt settings: %w", err)
+		}
+		log.Printf("👁️  Read receipts of session %s: send=%t", sessionID, *req.Send)
+	}
+	return ws.GetReadReceiptSettings(sessionID, userID)
+}
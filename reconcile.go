@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// ============= STORE RECONCILIATION =============
+// Sessions (whats_app_sessions) and whatsmeow's device store are only linked
+// by the device JID in the session's j_id, and RestoreActiveSessions skips
+// whatever doesn't pair up. ReconcileStore finds the mismatches:
+//
+//   - orphan_device: a stored device no session points at, e.g. left behind
+//     by a deleted session. Repair deletes it from the store; the phone keeps
+//     listing it under Linked Devices until WhatsApp drops it.
+//   - missing_device: a session that should have a device (it isn't
+//     unlinked, expired or failed) whose device is gone from the store.
+//     Repair marks the session unlinked, so it is paired again with /refresh.
+//     Each instance's store only holds the devices paired on it, so with
+//     several instances STORE_RECONCILE_MISSING=false turns this check off.
+//   - duplicate_jid: several sessions paired with the same WhatsApp account.
+//     The one connected most recently is kept; repair deletes the devices of
+//     the others and marks them unlinked.
+//
+// It runs at startup before sessions are restored (STORE_RECONCILE: off,
+// report or repair) and on demand through the admin API. Sessions loaded on
+// this instance are reported but never repaired; log them out instead.
+
+// Store issue kinds
+const (
+	StoreIssueOrphanDevice  = "orphan_device"
+	StoreIssueMissingDevice = "missing_device"
+	StoreIssueDuplicateJID  = "duplicate_jid"
+)
+
+// STORE_RECONCILE modes
+const (
+	StoreReconcileOff    = "off"
+	StoreReconcileReport = "report"
+	StoreReconcileRepair = "repair"
+)
+
+// deviceLessStatuses are the session statuses that don't need a device
+var deviceLessStatuses = map[SessionStatus]bool{
+	StatusUnlinked: true,
+	StatusExpired:  true,
+	StatusFailed:   true,
+}
+
+// StoreIssue is one mismatch between the sessions and the device store
+type StoreIssue struct {
+	Kind      string `json:"kind"`
+	JID       string `json:"jid"`
+	SessionID string `json:"session_id,omitempty"`
+	UserID    int    `json:"user_id,omitempty"`
+	Detail    string `json:"detail"`
+	Repaired  bool   `json:"repaired"`
+	Error     string `json:"error,omitempty"` // why the repair failed or was skipped
+}
+
+func (i StoreIssue) String() string {
+	s := i.Kind + " " + i.JID
+	if i.SessionID != "" {
+		s += " (session " + i.SessionID + ")"
+	}
+	return s + ": " + i.Detail
+}
+
+// StoreReconciliation is the outcome of a reconciliation
+type StoreReconciliation struct {
+	Repair    bool         `json:"repair"`
+	Devices   int          `json:"devices"`
+	Sessions  int          `json:"sessions"` // sessions with a device JID
+	Issues    []StoreIssue `json:"issues"`
+	Repaired  int          `json:"repaired"`
+	CheckedAt time.Time    `json:"checked_at"`
+}
+
+// ReconcileStore compares the sessions with the device store and, with
+// repair, fixes the mismatches it can
+func (ws *WhatsAppService) ReconcileStore(repair bool) (*StoreReconciliation, error) {
+	devices, err := ws.db.GetAllDevices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices from store: %w", err)
+	}
+	sessions, err := ws.db.GetPairedSessions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sessions: %w", err)
+	}
+
+	report := &StoreReconciliation{
+		Repair:    repair,
+		Devices:   len(devices),
+		Sessions:  len(sessions),
+		Issues:    []StoreIssue{},
+		CheckedAt: time.Now(),
+	}
+
+	byJID := make(map[string]*store.Device, len(devices))
+	for _, device := range devices {
+		if device.ID != nil {
+			byJID[device.ID.String()] = device
+		}
+	}
+	claimed := make(map[string]bool, len(sessions))
+	accounts := make(map[string][]*WhatsAppSession)
+	for i := range sessions {
+		session := &sessions[i]
+		jid := *session.JID
+		claimed[jid] = true
+
+		if deviceLessStatuses[session.Status] {
+			continue
+		}
+		if _, ok := byJID[jid]; ok {
+			if account, err := deviceAccount(jid); err == nil {
+				accounts[account] = append(accounts[account], session)
+			}
+			continue
+		}
+		if !ws.cfg.StoreReconcileMissing {
+			continue
+		}
+		issue := StoreIssue{
+			Kind:      StoreIssueMissingDevice,
+			JID:       jid,
+			SessionID: session.ID,
+			UserID:    session.UserID,
+			Detail:    fmt.Sprintf("session is %s but its device is not in the store", session.Status),
+		}
+		if repair {
+			ws.repairStoreIssue(&issue, nil, "device missing from the WhatsApp store")
+		}
+		report.add(issue)
+	}
+
+	for jid, device := range byJID {
+		if claimed[jid] {
+			continue
+		}
+		issue := StoreIssue{
+			Kind:   StoreIssueOrphanDevice,
+			JID:    jid,
+			Detail: "no session is paired with this device",
+		}
+		if repair {
+			ws.repairStoreIssue(&issue, device, "")
+		}
+		report.add(issue)
+	}
+
+	for account, paired := range accounts {
+		if len(paired) < 2 {
+			continue
+		}
+		sort.SliceStable(paired, func(i, j int) bool {
+			return lastConnected(paired[i]).After(lastConnected(paired[j]))
+		})
+		kept := paired[0]
+		for _, session := range paired[1:] {
+			issue := StoreIssue{
+				Kind:      StoreIssueDuplicateJID,
+				JID:       *session.JID,
+				SessionID: session.ID,
+				UserID:    session.UserID,
+				Detail:    fmt.Sprintf("account %s is also paired with session %s, which is kept", account, kept.ID),
+			}
+			if repair {
+				ws.repairStoreIssue(&issue, byJID[*session.JID], "duplicate of session "+kept.ID)
+			}
+			report.add(issue)
+		}
+	}
+
+	sort.SliceStable(report.Issues, func(i, j int) bool {
+		if report.Issues[i].Kind != report.Issues[j].Kind {
+			return report.Issues[i].Kind < report.Issues[j].Kind
+		}
+		return report.Issues[i].JID < report.Issues[j].JID
+	})
+	return report, nil
+}
+
+func (r *StoreReconciliation) add(issue StoreIssue) {
+	r.Issues = append(r.Issues, issue)
+	if issue.Repaired {
+		r.Repaired++
+	}
+}
+
+// repairStoreIssue deletes the issue's device, if any, and marks its session,
+// if any, unlinked for reason
+func (ws *WhatsAppService) repairStoreIssue(issue *StoreIssue, device *store.Device, reason string) {
+	if issue.SessionID != "" {
+		unlock := ws.sessions.Lock(issue.SessionID)
+		defer unlock()
+		if _, ok := ws.sessions.Get(issue.SessionID); ok {
+			issue.Error = "session is loaded on this instance"
+			return
+		}
+	} else if ws.deviceLoaded(issue.JID) {
+		issue.Error = "device is loaded on this instance"
+		return
+	}
+
+	if device != nil {
+		if err := ws.db.DeleteDevice(device); err != nil {
+			issue.Error = fmt.Sprintf("failed to delete device: %v", err)
+			return
+		}
+	}
+	if issue.SessionID != "" {
+		sessionUUID, _ := uuid.Parse(issue.SessionID)
+		if err := ws.db.SetSessionFailure(sessionUUID, StatusUnlinked, 0, reason, nil); err != nil {
+			issue.Error = fmt.Sprintf("failed to update session: %v", err)
+			return
+		}
+		data := map[string]interface{}{
+			"status": StatusUnlinked,
+			"reason": reason,
+			"code":   0,
+		}
+		ws.wsManager.SendToSession(issue.SessionID, WebSocketMessage{
+			Type: "logged_out",
+			Data: data,
+		})
+		ws.db.CreateEvent(sessionUUID, issue.UserID, "logged_out", data)
+	}
+	issue.Repaired = true
+}
+
+// deviceLoaded reports whether a loaded client uses the device of a JID
+func (ws *WhatsAppService) deviceLoaded(jid string) bool {
+	loaded := false
+	ws.sessions.Range(func(sc *SessionClient) bool {
+		if sc.Device != nil && sc.Device.ID != nil && sc.Device.ID.String() == jid {
+			loaded = true
+			return false
+		}
+		return true
+	})
+	return loaded
+}
+
+// RunStoreReconcile runs the startup reconciliation of STORE_RECONCILE
+func (ws *WhatsAppService) RunStoreReconcile() {
+	if ws.cfg.StoreReconcile == StoreReconcileOff {
+		return
+	}
+	report, err := ws.ReconcileStore(ws.cfg.StoreReconcile == StoreReconcileRepair)
+	if err != nil {
+		log.Printf("❌ Store reconciliation failed: %v", err)
+		return
+	}
+	if len(report.Issues) == 0 {
+		log.Printf("✅ Store reconciled: %d device(s), %d paired session(s), no mismatches", report.Devices, report.Sessions)
+		return
+	}
+	for _, issue := range report.Issues {
+		switch {
+		case issue.Repaired:
+			log.Printf("   🔧 Repaired %s", issue)
+		case issue.Error != "":
+			log.Printf("   ⚠️  Not repaired %s (%s)", issue, issue.Error)
+		default:
+			log.Printf("   ⚠️  Found %s", issue)
+		}
+	}
+	log.Printf("⚠️  Store reconciliation found %d mismatch(es), repaired %d", len(report.Issues), report.Repaired)
+}
+
+// deviceAccount returns the account of a device JID, without the device
+func deviceAccount(jid string) (string, error) {
+	parsed, err := types.ParseJID(jid)
+	if err != nil {
+		return "", err
+	}
+	return parsed.ToNonAD().String(), nil
+}
+
+// lastConnected is when a session last connected, its creation if never
+func lastConnected(session *WhatsAppSession) time.Time {
+	if session.ConnectedAt != nil {
+		return *session.ConnectedAt
+	}
+	return session.CreatedAt
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============= SESSION REGISTRY =============
+// The clients of the sessions loaded on this instance. Each session has a
+// lifecycle state:
+//
+//	initializing -> connecting -> connected <-> disconnected
+//	      any state except draining -> draining (being removed)
+//
+// A draining session is invisible to Get and Range, refuses restores and
+// its client's events are dropped, so a deleted or logged out session isn't
+// written to by its own late events. Lifecycle operations on one session
+// (restore, delete, logout, refresh) hold the session's lock, so a session
+// is restored at most once however many requests find it missing. Sessions
+// are spread over shards to keep lookups from contending on one lock.
+
+const registryShards = 16
+
+// SessionState is the lifecycle state of a loaded session
+type SessionState string
+
+const (
+	SessionStateInitializing SessionState = "initializing" // client created, not connecting yet
+	SessionStateConnecting   SessionState = "connecting"
+	SessionStateConnected    SessionState = "connected"
+	SessionStateDisconnected SessionState = "disconnected" // lost the connection; may reconnect
+	SessionStateDraining     SessionState = "draining"     // being removed; terminal
+)
+
+// sessionTransitions are the allowed state changes
+var sessionTransitions = map[SessionState][]SessionState{
+	SessionStateInitializing: {SessionStateConnecting, SessionStateDraining},
+	SessionStateConnecting:   {SessionStateConnected, SessionStateDisconnected, SessionStateDraining},
+	SessionStateConnected:    {SessionStateDisconnected, SessionStateConnecting, SessionStateDraining},
+	SessionStateDisconnected: {SessionStateConnecting, SessionStateConnected, SessionStateDraining},
+}
+
+// ErrSessionDraining is returned when restoring a session that is being
+// removed
+var ErrSessionDraining = errors.New("session is being removed")
+
+// SessionRegistry holds the loaded sessions by ID
+type SessionRegistry struct {
+	shards [registryShards]registryShard
+
+	restores        atomic.Int64
+	restoreFailures atomic.Int64
+	lastFailureMu   sync.Mutex
+	lastFailure     *RegistryFailure
+}
+
+type registryShard struct {
+	mu       sync.RWMutex
+	sessions map[string]*registryEntry
+	locks    map[string]*sync.Mutex // lifecycle locks by session ID, kept for the process lifetime
+}
+
+type registryEntry struct {
+	sc    *SessionClient
+	state SessionState
+}
+
+// RegistryFailure is the last failed restore
+type RegistryFailure struct {
+	SessionID string    `json:"session_id"`
+	Error     string    `json:"error"`
+	At        time.Time `json:"at"`
+}
+
+// RegistryStats are the metrics of the registry
+type RegistryStats struct {
+	Size            int                  `json:"size"`
+	ByState         map[SessionState]int `json:"by_state"`
+	Restores        int64                `json:"restores"`
+	RestoreFailures int64                `json:"restore_failures"`
+	LastFailure     *RegistryFailure     `json:"last_failure,omitempty"`
+}
+
+func NewSessionRegistry() *SessionRegistry {
+	r := &SessionRegistry{}
+	for i := range r.shards {
+		r.shards[i].sessions = make(map[string]*registryEntry)
+		r.shards[i].locks = make(map[string]*sync.Mutex)
+	}
+	return r
+}
+
+func (r *SessionRegistry) shard(sessionID string) *registryShard {
+	h := fnv.New32a()
+	h.Write([]byte(sessionID))
+	return &r.shards[h.Sum32()%registryShards]
+}
+
+// Lock takes the lifecycle lock of a session and returns its unlock
+func (r *SessionRegistry) Lock(sessionID string) func() {
+	s := r.shard(sessionID)
+	s.mu.Lock()
+	lock, ok := s.locks[sessionID]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[sessionID] = lock
+	}
+	s.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// Get returns the client of a session unless it is draining
+func (r *SessionRegistry) Get(sessionID string) (*SessionClient, bool) {
+	s := r.shard(sessionID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.sessions[sessionID]
+	if !ok || entry.state == SessionStateDraining {
+		return nil, false
+	}
+	return entry.sc, true
+}
+
+// Current reports whether a client is its session's live client
+func (r *SessionRegistry) Current(sc *SessionClient) bool {
+	current, ok := r.Get(sc.SessionID)
+	return ok && current == sc
+}
+
+// State returns the lifecycle state of a session, "" when it isn't loaded
+func (r *SessionRegistry) State(sessionID string) SessionState {
+	s := r.shard(sessionID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if entry, ok := s.sessions[sessionID]; ok {
+		return entry.state
+	}
+	return ""
+}
+
+// Add registers a new client as initializing, replacing any previous client
+// of the session; the caller holds the session's lock
+func (r *SessionRegistry) Add(sc *SessionClient) {
+	s := r.shard(sc.SessionID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[sc.SessionID] = &registryEntry{sc: sc, state: SessionStateInitializing}
+}
+
+// Restore returns the loaded client of a session or loads it with restore,
+// once: concurrent callers wait for the first one and get its client.
+// restored reports whether this call loaded it.
+func (r *SessionRegistry) Restore(sessionID string, restore func() (*SessionClient, error)) (sc *SessionClient, restored bool, err error) {
+	unlock := r.Lock(sessionID)
+	defer unlock()
+
+	switch r.State(sessionID) {
+	case SessionStateDraining:
+		return nil, false, ErrSessionDraining
+	case "":
+	default:
+		sc, _ := r.Get(sessionID)
+		return sc, false, nil
+	}
+
+	r.restores.Add(1)
+	sc, err = restore()
+	if err != nil {
+		r.restoreFailures.Add(1)
+		r.lastFailureMu.Lock()
+		r.lastFailure = &RegistryFailure{SessionID: sessionID, Error: err.Error(), At: time.Now()}
+		r.lastFailureMu.Unlock()
+		return nil, false, err
+	}
+	r.Add(sc)
+	return sc, true, nil
+}
+
+// SetState moves a client to a new state; changes of replaced clients and
+// transitions the state machine doesn't allow are ignored
+func (r *SessionRegistry) SetState(sc *SessionClient, state SessionState) bool {
+	s := r.shard(sc.SessionID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.sessions[sc.SessionID]
+	if !ok || entry.sc != sc {
+		return false
+	}
+	if entry.state == state {
+		return true
+	}
+	for _, next := range sessionTransitions[entry.state] {
+		if next == state {
+			entry.state = state
+			return true
+		}
+	}
+	log.Printf("⚠️  Session %s: ignoring state change %s -> %s", sc.SessionID, entry.state, state)
+	return false
+}
+
+// Drain marks a client draining unless it was replaced or is draining
+// already; the caller that drains a client shuts it down and then calls
+// Remove
+func (r *SessionRegistry) Drain(sc *SessionClient) bool {
+	s := r.shard(sc.SessionID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.sessions[sc.SessionID]
+	if !ok || entry.sc != sc || entry.state == SessionStateDraining {
+		return false
+	}
+	entry.state = SessionStateDraining
+	return true
+}
+
+// Remove unregisters a client unless it was replaced; it reports whether the
+// client was removed
+func (r *SessionRegistry) Remove(sc *SessionClient) bool {
+	s := r.shard(sc.SessionID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.sessions[sc.SessionID]; ok && entry.sc == sc {
+		delete(s.sessions, sc.SessionID)
+		return true
+	}
+	return false
+}
+
+// Range calls fn for the clients that aren't draining until it returns
+// false. fn runs without registry locks held.
+func (r *SessionRegistry) Range(fn func(sc *SessionClient) bool) {
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.RLock()
+		clients := make([]*SessionClient, 0, len(s.sessions))
+		for _, entry := range s.sessions {
+			if entry.state != SessionStateDraining {
+				clients = append(clients, entry.sc)
+			}
+		}
+		s.mu.RUnlock()
+
+		for _, sc := range clients {
+			if !fn(sc) {
+				return
+			}
+		}
+	}
+}
+
+// Stats returns the registry size by state and the restore counters
+func (r *SessionRegistry) Stats() RegistryStats {
+	stats := RegistryStats{
+		ByState:         make(map[SessionState]int),
+		Restores:        r.restores.Load(),
+		RestoreFailures: r.restoreFailures.Load(),
+	}
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.RLock()
+		for _, entry := range s.sessions {
+			stats.Size++
+			stats.ByState[entry.state]++
+		}
+		s.mu.RUnlock()
+	}
+	r.lastFailureMu.Lock()
+	stats.LastFailure = r.lastFailure
+	r.lastFailureMu.Unlock()
+	return stats
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ============= READ REPLICA =============
+// With DB_REPLICA_ENABLED the high-volume reads (event, contact, group, chat,
+// message, call and audit listings and the statistics) go to a read replica
+// at DB_REPLICA_DSN; writes and the lookups that must see a write right away
+// (sessions, outbox, event replay) stay on the primary. Every
+// replicaCheckInterval each instance writes a heartbeat row to the primary
+// and reads its copy back from the replica. While the replica doesn't answer
+// or its copy trails by more than DB_REPLICA_MAX_LAG, reads go to the
+// primary; a read that fails on the replica is retried on the primary and
+// sends the following reads there until the next check passes.
+
+const replicaCheckInterval = 5 * time.Second
+
+// readReplica is the replica connection and the outcome of its last check
+type readReplica struct {
+	db       *gorm.DB
+	maxLag   time.Duration
+	instance string // heartbeat row of this instance
+
+	healthy atomic.Bool
+
+	mu        sync.Mutex
+	lag       time.Duration
+	lastCheck time.Time
+	lastError string
+	firstBeat int64 // UnixNano of the first and last heartbeat written
+	lastBeat  int64
+}
+
+// ReplicaStatus is the state of the read replica
+type ReplicaStatus struct {
+	Healthy       bool       `json:"healthy"` // reads go to the replica
+	LagSeconds    float64    `json:"lag_seconds"`
+	MaxLagSeconds float64    `json:"max_lag_seconds"` // 0 = lag isn't checked
+	LastCheck     *time.Time `json:"last_check,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// openReplica connects to the read replica. Reads stay on the primary until
+// the replica monitor has measured its lag.
+func (dm *DatabaseManager) openReplica(cfg *Config) error {
+	var dialector gorm.Dialector
+	switch cfg.DBDriver {
+	case DBDriverMySQL:
+		dialector = mysql.Open(cfg.DBReplicaDSN)
+	case DBDriverPostgres:
+		dialector = postgres.Open(cfg.DBReplicaDSN)
+	default:
+		return fmt.Errorf("unknown DB_DRIVER %q (expected mysql or postgres)", cfg.DBDriver)
+	}
+
+	log.Printf("📊 Connecting to %s read replica...", dialectName(cfg.DBDriver))
+	gormConfig := appGormConfig(cfg)
+	// An unreachable replica shouldn't keep the server from starting
+	gormConfig.DisableAutomaticPing = true
+	replicaDB, err := gorm.Open(dialector, gormConfig)
+	if err != nil {
+		return fmt.Errorf("failed to open the read replica: %w", err)
+	}
+	if err := configurePool(cfg, replicaDB); err != nil {
+		return err
+	}
+
+	instance, err := os.Hostname()
+	if err != nil || instance == "" {
+		instance = "default"
+	}
+	dm.replica = &readReplica{
+		db:       replicaDB,
+		maxLag:   cfg.DBReplicaMaxLag,
+		instance: instance,
+	}
+	return nil
+}
+
+// read runs a query on the replica while it's healthy and on the primary
+// otherwise, or when it failed on the replica
+func (dm *DatabaseManager) read(fn func(db *gorm.DB) error) error {
+	replica := dm.replica
+	if replica == nil || !replica.healthy.Load() {
+		return fn(dm.db)
+	}
+	err := fn(replica.db)
+	if err == nil || errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	log.Printf("⚠️  Replica read failed, reading from the primary: %v", err)
+	replica.markUnhealthy(err)
+	return fn(dm.db)
+}
+
+func (r *readReplica) markUnhealthy(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.healthy.Store(false)
+	r.lastError = err.Error()
+}
+
+// StartReplicaMonitor starts the periodic lag checks of the read replica
+func (dm *DatabaseManager) StartReplicaMonitor(ctx context.Context) {
+	if dm.replica == nil {
+		return
+	}
+
+	dm.checkReplica(ctx)
+	go func() {
+		ticker := time.NewTicker(replicaCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				dm.checkReplica(ctx)
+			}
+		}
+	}()
+	log.Println("✅ Replica monitor started")
+}
+
+// checkReplica compares the replica's copy of this instance's heartbeat with
+// the last one written, then writes the next one. The lag is known to the
+// check interval: a replica missing the last heartbeat trails at most since
+// the heartbeat it has.
+func (dm *DatabaseManager) checkReplica(ctx context.Context) {
+	r := dm.replica
+	ctx, cancel := context.WithTimeout(ctx, replicaCheckInterval)
+	defer cancel()
+
+	var copied WhatsAppReplicaHeartbeat
+	err := r.db.WithContext(ctx).Where("instance = ?", r.instance).First(&copied).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	r.lastCheck = now
+	var lag time.Duration
+	switch {
+	case r.lastBeat == 0 || copied.Beat >= r.lastBeat:
+	case copied.Beat >= r.firstBeat:
+		lag = now.Sub(time.Unix(0, copied.Beat))
+	default:
+		// Not even the first heartbeat has arrived
+		lag = now.Sub(time.Unix(0, r.firstBeat))
+	}
+	if err == nil {
+		r.lag = lag
+	}
+	wasHealthy := r.healthy.Load()
+	// Before a heartbeat was written the lag is unknown
+	healthy := err == nil && r.lastBeat != 0 && (r.maxLag <= 0 || lag <= r.maxLag)
+	switch {
+	case err != nil:
+		r.lastError = err.Error()
+	case !healthy && r.lastBeat != 0:
+		r.lastError = fmt.Sprintf("replica lags %s behind", lag.Round(time.Second))
+	default:
+		r.lastError = ""
+	}
+	lastError := r.lastError
+	r.healthy.Store(healthy)
+	r.mu.Unlock()
+
+	if wasHealthy && !healthy {
+		log.Printf("⚠️  Read replica unavailable, reading from the primary: %s", lastError)
+	} else if !wasHealthy && healthy {
+		log.Printf("✅ Read replica available (lag %s)", lag.Round(time.Millisecond))
+	}
+
+	beat := time.Now().UnixNano()
+	if err := dm.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&WhatsAppReplicaHeartbeat{Instance: r.instance, Beat: beat}).Error; err != nil {
+		log.Printf("⚠️  Failed to write the replica heartbeat: %v", err)
+		return
+	}
+	r.mu.Lock()
+	if r.firstBeat == 0 {
+		r.firstBeat = beat
+	}
+	r.lastBeat = beat
+	r.mu.Unlock()
+}
+
+// ReplicaStatus returns the state of the read replica, nil without one
+func (dm *DatabaseManager) ReplicaStatus() *ReplicaStatus {
+	r := dm.replica
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := &ReplicaStatus{
+		Healthy:       r.healthy.Load(),
+		LagSeconds:    r.lag.Seconds(),
+		MaxLagSeconds: r.maxLag.Seconds(),
+		LastError:     r.lastError,
+	}
+	if !r.lastCheck.IsZero() {
+		lastCheck := r.lastCheck
+		status.LastCheck = &lastCheck
+	}
+	return status
+}
+
+// readPage is findPage on the replica; build returns the query on a connection
+func (dm *DatabaseManager) readPage(q ListQuery, dest interface{}, build func(db *gorm.DB) *gorm.DB) (int64, error) {
+	var total int64
+	err := dm.read(func(db *gorm.DB) (err error) {
+		total, err = findPage(build(db), q, dest)
+		return err
+	})
+	return total, err
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= SAFETY ENGINE =============
+// Every new outgoing message goes through ws.sendMessage, which spaces the
+// sends of a session by a random delay, enforces a daily cap and pauses the
+// session when too many recent sends fail. A send reserves its start time and
+// a place under the cap, then waits and sends without holding the session's
+// safety lock, so the safety endpoints never wait behind a send. Numbers paired through this API
+// start with a lower cap that grows day by day according to their warm-up
+// profile; sessions paired before pairing times were recorded skip the ramp.
+// Daily counts are stored in WhatsAppSafetyCounter so caps survive restarts;
+// they are kept while the engine is disabled too, for the session stats.
+
+const (
+	safetyWindowSize = 20 // recent sends used to compute the failure rate
+	safetyMinSamples = 10 // sends needed before a session can be paused
+	safetyDayLayout  = "2006-01-02"
+)
+
+// warmupProfiles are the daily caps for the first days after pairing. Once a
+// schedule runs out only the session's daily limit applies.
+var warmupProfiles = map[string][]int{
+	"conservative": {20, 30, 50, 80, 120, 170, 230, 300, 400, 500, 650, 800},
+	"standard":     {50, 100, 200, 350, 500, 700, 1000},
+	"aggressive":   {200, 500, 1000},
+	"none":         nil,
+}
+
+// SendLimitError is returned when the safety engine holds a send back
+type SendLimitError struct {
+	Reason  string // daily_limit, paused, throttled, busy
+	RetryAt time.Time
+}
+
+func (e *SendLimitError) Error() string {
+	switch e.Reason {
+	case "paused":
+		return fmt.Sprintf("session paused after repeated send errors until %s", e.RetryAt.UTC().Format(time.RFC3339))
+	case "throttled":
+		return fmt.Sprintf("session rate limited by WhatsApp, sending resumes at %s", e.RetryAt.UTC().Format(time.RFC3339))
+	case "busy":
+		return fmt.Sprintf("session busy with other WhatsApp calls, retry at %s", e.RetryAt.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("daily send limit reached, sending resumes at %s", e.RetryAt.UTC().Format(time.RFC3339))
+}
+
+// SafetySettingsRequest overrides the safety settings of a session
+type SafetySettingsRequest struct {
+	WarmupProfile *string `json:"warmup_profile"` // "" = SAFETY_WARMUP_PROFILE
+	DailyLimit    *int    `json:"daily_limit"`    // 0 = SAFETY_DAILY_LIMIT
+}
+
+// SafetyStatus reports the send budget and pause state of a session
+type SafetyStatus struct {
+	SessionID         string     `json:"session_id"`
+	Enabled           bool       `json:"enabled"`
+	WarmupProfile     string     `json:"warmup_profile"`
+	WarmupDay         int        `json:"warmup_day,omitempty"` // day of the ramp, omitted once it is over
+	DailyLimit        int        `json:"daily_limit"`          // today's cap, 0 = unlimited
+	SentToday         int        `json:"sent_today"`
+	FailedToday       int        `json:"failed_today"`
+	Remaining         *int       `json:"remaining,omitempty"`
+	RecentFailureRate float64    `json:"recent_failure_rate"`
+	Paused            bool       `json:"paused"`
+	PausedUntil       *time.Time `json:"paused_until,omitempty"`
+	MinDelay          string     `json:"min_delay"`
+	MaxDelay          string     `json:"max_delay"`
+}
+
+// sessionSafety is the in-memory send state of one session
+type sessionSafety struct {
+	mu          sync.Mutex // never held while a send waits or runs
+	loaded      bool
+	day         string
+	sent        int
+	failed      int
+	nextSend    time.Time // earliest start of the next send
+	reserved    int       // sends waiting or running, counted against the cap
+	recent      []bool    // latest send outcomes, true = failed
+	pausedUntil time.Time
+
+	pairedAt   *time.Time
+	profile    string
+	dailyLimit int
+}
+
+func (ws *WhatsAppService) sessionSafetyState(sessionID string) *sessionSafety {
+	state, _ := ws.safety.LoadOrStore(sessionID, &sessionSafety{})
+	return state.(*sessionSafety)
+}
+
+// loadSafety reads the session settings on first use and the day's counters
+// whenever the UTC day changes. The caller holds state.mu.
+func (ws *WhatsAppService) loadSafety(state *sessionSafety, sessionID string, userID int, now time.Time) error {
+	day := now.UTC().Format(safetyDayLayout)
+	if state.loaded && state.day == day {
+		return nil
+	}
+
+	if !state.loaded {
+		sessionUUID, err := uuid.Parse(sessionID)
+		if err != nil {
+			return apierr.ErrInvalidSessionID
+		}
+		session, err := ws.db.GetSession(sessionUUID, userID)
+		if err != nil {
+			return apierr.ErrSessionNotFound
+		}
+		state.pairedAt = session.PairedAt
+		state.profile = session.WarmupProfile
+		state.dailyLimit = session.DailySendLimit
+		state.pausedUntil = time.Time{}
+		if session.SafetyPausedUntil != nil {
+			state.pausedUntil = *session.SafetyPausedUntil
+		}
+	}
+
+	counter, err := ws.db.GetSafetyCounter(sessionID, day)
+	if err != nil {
+		return fmt.Errorf("failed to load send counters: %w", err)
+	}
+	state.day = day
+	state.sent = counter.Sent
+	state.failed = counter.Failed
+	state.loaded = true
+	return nil
+}
+
+// dailyCap returns today's cap (0 = unlimited), the effective warm-up profile
+// and the warm-up day, which is 0 once the ramp is over
+func (ws *WhatsAppService) dailyCap(state *sessionSafety, now time.Time) (int, string, int) {
+	limit := state.dailyLimit
+	if limit == 0 {
+		limit = ws.cfg.SafetyDailyLimit
+	}
+	profile := state.profile
+	if profile == "" {
+		profile = ws.cfg.SafetyWarmupProfile
+	}
+
+	if state.pairedAt == nil {
+		return limit, profile, 0
+	}
+
+	day := int(now.UTC().Truncate(24*time.Hour).Sub(state.pairedAt.UTC().Truncate(24*time.Hour)) / (24 * time.Hour))
+	schedule := warmupProfiles[profile]
+	if day < 0 || day >= len(schedule) {
+		return limit, profile, 0
+	}
+	if limit == 0 || schedule[day] < limit {
+		limit = schedule[day]
+	}
+	return limit, profile, day + 1
+}
+
+// failureRate returns the share of failed sends in the recent window
+func (state *sessionSafety) failureRate() float64 {
+	if len(state.recent) == 0 {
+		return 0
+	}
+	failed := 0
+	for _, f := range state.recent {
+		if f {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(state.recent))
+}
+
+// sendMessage sends a new message once the session's safety limits and the
+// user's usage quota allow it. A session backing off after a WhatsApp rate
+// limit gets a throttled SendLimitError, so callers hold the send back like
+// any other limit.
+func (ws *WhatsAppService) sendMessage(sc *SessionClient, recipient types.JID, message *waE2E.Message) (whatsmeow.SendResponse, error) {
+	if err := sc.throttle.check(sc.SessionID); err != nil {
+		return whatsmeow.SendResponse{}, throttledSend(err)
+	}
+	release, err := ws.reserveUsage(sc.UserID)
+	if err != nil {
+		return whatsmeow.SendResponse{}, err
+	}
+
+	resp, err := ws.pacedSend(sc, recipient, message)
+	if err != nil {
+		release()
+	}
+	return resp, err
+}
+
+// pacedSend sends a message in one of the session's call slots, spaced and
+// capped by the safety engine when it is enabled
+func (ws *WhatsAppService) pacedSend(sc *SessionClient, recipient types.JID, message *waE2E.Message) (whatsmeow.SendResponse, error) {
+	if !ws.cfg.SafetyEnabled {
+		var resp whatsmeow.SendResponse
+		err := ws.withCallSlot(sc, priorityInteractive, func() error {
+			intent, err := ws.beginSend(sc, recipient, message)
+			if err != nil {
+				return err
+			}
+			resp, err = ws.deliverSend(sc, intent, recipient, message)
+			ws.countSend(sc.SessionID, err != nil)
+			sc.health.recordSend(err)
+			return ws.observeWhatsApp(sc, "send message", err)
+		})
+		return resp, throttledSend(err)
+	}
+
+	state := ws.sessionSafetyState(sc.SessionID)
+	state.mu.Lock()
+	start, err := ws.acquireSend(sc, state)
+	state.mu.Unlock()
+	if err != nil {
+		return whatsmeow.SendResponse{}, err
+	}
+	if wait := time.Until(start); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	var resp whatsmeow.SendResponse
+	recorded := false
+	err = ws.withCallSlot(sc, priorityInteractive, func() error {
+		// The session may have been paused or throttled while the send waited
+		state.mu.Lock()
+		pausedUntil := state.pausedUntil
+		state.mu.Unlock()
+		if time.Now().Before(pausedUntil) {
+			return &SendLimitError{Reason: "paused", RetryAt: pausedUntil}
+		}
+		if err := sc.throttle.check(sc.SessionID); err != nil {
+			return err
+		}
+		intent, err := ws.beginSend(sc, recipient, message)
+		if err != nil {
+			return err
+		}
+		resp, err = ws.deliverSend(sc, intent, recipient, message)
+		state.mu.Lock()
+		ws.recordSend(sc, state, err != nil)
+		state.mu.Unlock()
+		recorded = true
+		sc.health.recordSend(err)
+		return ws.observeWhatsApp(sc, "send message", err)
+	})
+	if !recorded {
+		state.mu.Lock()
+		state.reserved--
+		state.mu.Unlock()
+	}
+	return resp, throttledSend(err)
+}
+
+// countSend adds an unpaced send to the day's counters, which the session
+// stats report even while the safety engine is disabled
+func (ws *WhatsAppService) countSend(sessionID string, failed bool) {
+	sent, failures := 1, 0
+	if failed {
+		sent, failures = 0, 1
+	}
+	day := time.Now().UTC().Format(safetyDayLayout)
+	if err := ws.db.IncrementSafetyCounter(sessionID, day, sent, failures); err != nil {
+		log.Printf("⚠️  Failed to update send counters for session %s: %v", sessionID, err)
+	}
+}
+
+// throttledSend turns a ThrottleError or SessionBusyError into the
+// SendLimitError send callers handle
+func throttledSend(err error) error {
+	var throttleErr *ThrottleError
+	var busyErr *SessionBusyError
+	switch {
+	case errors.As(err, &throttleErr):
+		return &SendLimitError{Reason: "throttled", RetryAt: throttleErr.RetryAt}
+	case errors.As(err, &busyErr):
+		return &SendLimitError{Reason: "busy", RetryAt: busyErr.RetryAt}
+	}
+	return err
+}
+
+// acquireSend checks the pause and the daily cap, counting the sends still
+// waiting or running, and reserves the session's next send. It returns when
+// the send may start; the caller waits until then without state.mu and then
+// records the send or, if it never went out, gives the reservation back. The
+// caller holds state.mu.
+func (ws *WhatsAppService) acquireSend(sc *SessionClient, state *sessionSafety) (time.Time, error) {
+	now := time.Now()
+	if err := ws.loadSafety(state, sc.SessionID, sc.UserID, now); err != nil {
+		return time.Time{}, err
+	}
+
+	if now.Before(state.pausedUntil) {
+		return time.Time{}, &SendLimitError{Reason: "paused", RetryAt: state.pausedUntil}
+	}
+
+	limit, _, _ := ws.dailyCap(state, now)
+	if limit > 0 && state.sent+state.reserved >= limit {
+		return time.Time{}, &SendLimitError{
+			Reason:  "daily_limit",
+			RetryAt: now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour),
+		}
+	}
+
+	start := now
+	if state.nextSend.After(start) {
+		start = state.nextSend
+	}
+	state.nextSend = start.Add(ws.sendGap())
+	state.reserved++
+	return start, nil
+}
+
+// sendGap picks the random delay between two sends of a session
+func (ws *WhatsAppService) sendGap() time.Duration {
+	gap := ws.cfg.SafetyMinDelay
+	if spread := ws.cfg.SafetyMaxDelay - ws.cfg.SafetyMinDelay; spread > 0 {
+		gap += time.Duration(rand.Int63n(int64(spread)))
+	}
+	return gap
+}
+
+// recordSend counts a reserved send and pauses the session on a failure
+// spike. The caller holds state.mu.
+func (ws *WhatsAppService) recordSend(sc *SessionClient, state *sessionSafety, failed bool) {
+	state.reserved--
+	// A slow send still keeps the minimum delay before the next one starts
+	if next := time.Now().Add(ws.cfg.SafetyMinDelay); next.After(state.nextSend) {
+		state.nextSend = next
+	}
+
+	sent, failures := 1, 0
+	if failed {
+		sent, failures = 0, 1
+	}
+	state.sent += sent
+	state.failed += failures
+	if err := ws.db.IncrementSafetyCounter(sc.SessionID, state.day, sent, failures); err != nil {
+		log.Printf("⚠️  Failed to update send counters for session %s: %v", sc.SessionID, err)
+	}
+
+	state.recent = append(state.recent, failed)
+	if len(state.recent) > safetyWindowSize {
+		state.recent = state.recent[len(state.recent)-safetyWindowSize:]
+	}
+
+	if !failed || len(state.recent) < safetyMinSamples {
+		return
+	}
+	rate := state.failureRate()
+	if rate < ws.cfg.SafetyFailureThreshold {
+		return
+	}
+
+	state.pausedUntil = time.Now().Add(ws.cfg.SafetyPauseDuration)
+	state.recent = nil
+	ws.db.UpdateSessionSafety(sc.SessionID, map[string]interface{}{"safety_paused_until": state.pausedUntil})
+
+	log.Printf("🛑 Session %s paused until %s: %.0f%% of recent sends failed", sc.SessionID, state.pausedUntil.Format(time.RFC3339), rate*100)
+
+	data := map[string]interface{}{
+		"paused_until": state.pausedUntil,
+		"failure_rate": rate,
+	}
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "session_safety_paused", data)
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "session_safety_paused",
+		Data: data,
+	})
+}
+
+// GetSessionSafety returns the send budget and pause state of a session
+func (ws *WhatsAppService) GetSessionSafety(sessionID string, userID int) (*SafetyStatus, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	state := ws.sessionSafetyState(sessionID)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	now := time.Now()
+	if err := ws.loadSafety(state, sessionID, userID, now); err != nil {
+		return nil, err
+	}
+
+	limit, profile, warmupDay := ws.dailyCap(state, now)
+	status := &SafetyStatus{
+		SessionID:         sessionID,
+		Enabled:           ws.cfg.SafetyEnabled,
+		WarmupProfile:     profile,
+		WarmupDay:         warmupDay,
+		DailyLimit:        limit,
+		SentToday:         state.sent,
+		FailedToday:       state.failed,
+		RecentFailureRate: state.failureRate(),
+		MinDelay:          ws.cfg.SafetyMinDelay.String(),
+		MaxDelay:          ws.cfg.SafetyMaxDelay.String(),
+	}
+	if limit > 0 {
+		remaining := max(0, limit-state.sent)
+		status.Remaining = &remaining
+	}
+	if now.Before(state.pausedUntil) {
+		pausedUntil := state.pausedUntil
+		status.Paused = true
+		status.PausedUntil = &pausedUntil
+	}
+	return status, nil
+}
+
+// UpdateSessionSafety changes the warm-up profile or daily limit of a session
+func (ws *WhatsAppService) UpdateSessionSafety(sessionID string, userID int, req SafetySettingsRequest) (*SafetyStatus, error) {
+	updates := make(map[string]interface{})
+	if req.WarmupProfile != nil {
+		if _, ok := warmupProfiles[*req.WarmupProfile]; !ok && *req.WarmupProfile != "" {
+			return nil, fmt.Errorf("unknown warm-up profile %q", *req.WarmupProfile)
+		}
+		updates["warmup_profile"] = *req.WarmupProfile
+	}
+	if req.DailyLimit != nil {
+		if *req.DailyLimit < 0 {
+			return nil, fmt.Errorf("daily_limit must not be negative")
+		}
+		updates["daily_send_limit"] = *req.DailyLimit
+	}
+	if len(updates) == 0 {
+		return nil, fmt.Errorf("nothing to update")
+	}
+
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+	if err := ws.db.UpdateSessionSafety(sessionID, updates); err != nil {
+		return nil, fmt.Errorf("failed to update safety settings: %w", err)
+	}
+
+	state := ws.sessionSafetyState(sessionID)
+	state.mu.Lock()
+	state.loaded = false
+	state.mu.Unlock()
+
+	log.Printf("🛡️  Safety settings of session %s updated: %v", sessionID, updates)
+	return ws.GetSessionSafety(sessionID, userID)
+}
+
+// ResumeSessionSafety lifts a failure pause before it expires
+func (ws *WhatsAppService) ResumeSessionSafety(sessionID string, userID int) (*SafetyStatus, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+	if err := ws.db.UpdateSessionSafety(sessionID, map[string]interface{}{"safety_paused_until": nil}); err != nil {
+		return nil, fmt.Errorf("failed to resume session: %w", err)
+	}
+
+	state := ws.sessionSafetyState(sessionID)
+	state.mu.Lock()
+	state.pausedUntil = time.Time{}
+	state.recent = nil
+	state.mu.Unlock()
+
+	log.Printf("▶️  Session %s resumed sending", sessionID)
+	return ws.GetSessionSafety(sessionID, userID)
+}
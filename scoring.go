@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"gorm.io/gorm"
+
+	"whatsapp-api/pkg/apierr"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= LEAD SCORING =============
+// Scoring rules give points to the senders of incoming 1:1 messages that
+// match a keyword, matched like auto-reply keywords. Every matching rule
+// counts, so one message can add the points of several rules; negative
+// points lower the score. Scores belong to the user and are keyed by the
+// contact's JID like tags (a mapped LID counts towards its phone number).
+// When a score reaches the user's hot lead threshold, hot_lead is emitted
+// once and POSTed to the user's lead webhook, if one is set, so leads can be
+// routed to a CRM or a sales team. Resetting a score arms it again.
+
+const (
+	scoringMaxPoints      = 1000
+	leadWebhookAttempts   = 3
+	leadWebhookRetryDelay = 5 * time.Second
+)
+
+// leadWebhookClient posts hot leads to the users' webhooks
+var leadWebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// ScoringRuleRequest creates or replaces a scoring rule
+type ScoringRuleRequest struct {
+	SessionID string             `json:"session_id"` // empty = all sessions
+	Name      string             `json:"name"`
+	Keyword   string             `json:"keyword" binding:"required"`
+	MatchType AutoReplyMatchType `json:"match_type"` // exact, contains (default) or regex
+	Points    int                `json:"points" binding:"required"`
+	Enabled   *bool              `json:"enabled"` // defaults to true
+}
+
+// LeadSettingsRequest changes the hot lead threshold and webhook of a user
+type LeadSettingsRequest struct {
+	Threshold     *int    `json:"threshold"`
+	WebhookURL    *string `json:"webhook_url"`
+	WebhookSecret *string `json:"webhook_secret"`
+}
+
+// LeadSettings are the hot lead settings of a user; the webhook secret is
+// never returned
+type LeadSettings struct {
+	Threshold        int    `json:"threshold"`
+	WebhookURL       string `json:"webhook_url"`
+	WebhookSecretSet bool   `json:"webhook_secret_set"`
+}
+
+// applyScoringRequest validates a request and copies it onto rule
+func (ws *WhatsAppService) applyScoringRequest(userID int, req ScoringRuleRequest, rule *WhatsAppScoringRule) error {
+	keyword, matchType, err := parseKeyword(req.Keyword, req.MatchType)
+	if err != nil {
+		return err
+	}
+	if req.Points == 0 || req.Points < -scoringMaxPoints || req.Points > scoringMaxPoints {
+		return fmt.Errorf("points must be between -%d and %d and not 0", scoringMaxPoints, scoringMaxPoints)
+	}
+
+	if req.SessionID != "" {
+		sessionUUID, err := uuid.Parse(req.SessionID)
+		if err != nil {
+			return apierr.ErrInvalidSessionID
+		}
+		if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+			return apierr.ErrSessionNotFound
+		}
+	}
+
+	rule.UserID = userID
+	rule.SessionID = req.SessionID
+	rule.Name = strings.TrimSpace(req.Name)
+	rule.Keyword = keyword
+	rule.MatchType = matchType
+	rule.Points = req.Points
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+	return nil
+}
+
+// CreateScoringRule saves a new scoring rule
+func (ws *WhatsAppService) CreateScoringRule(userID int, req ScoringRuleRequest) (*WhatsAppScoringRule, error) {
+	rule := &WhatsAppScoringRule{}
+	if err := ws.applyScoringRequest(userID, req, rule); err != nil {
+		return nil, err
+	}
+	if err := ws.db.CreateScoringRule(rule); err != nil {
+		return nil, fmt.Errorf("failed to create scoring rule: %w", err)
+	}
+
+	log.Printf("🎯 Scoring rule %d created for keyword %q (%+d)", rule.ID, rule.Keyword, rule.Points)
+	return rule, nil
+}
+
+// GetScoringRule returns a scoring rule of the user
+func (ws *WhatsAppService) GetScoringRule(userID int, ruleID int64) (*WhatsAppScoringRule, error) {
+	rule, err := ws.db.GetScoringRule(ruleID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("scoring rule not found")
+		}
+		return nil, fmt.Errorf("failed to load scoring rule: %w", err)
+	}
+	return rule, nil
+}
+
+// UpdateScoringRule replaces a scoring rule; scores already given stay
+func (ws *WhatsAppService) UpdateScoringRule(userID int, ruleID int64, req ScoringRuleRequest) (*WhatsAppScoringRule, error) {
+	rule, err := ws.GetScoringRule(userID, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ws.applyScoringRequest(userID, req, rule); err != nil {
+		return nil, err
+	}
+	if err := ws.db.UpdateScoringRule(rule); err != nil {
+		return nil, fmt.Errorf("failed to update scoring rule: %w", err)
+	}
+	return rule, nil
+}
+
+// DeleteScoringRule removes a scoring rule
+func (ws *WhatsAppService) DeleteScoringRule(userID int, ruleID int64) error {
+	deleted, err := ws.db.DeleteScoringRule(ruleID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete scoring rule: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("scoring rule not found")
+	}
+	return nil
+}
+
+// GetLeadSettings returns the hot lead settings of a user
+func (ws *WhatsAppService) GetLeadSettings(userID int) (*LeadSettings, error) {
+	settings, err := ws.db.GetLeadSettings(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lead settings: %w", err)
+	}
+	if settings == nil {
+		return &LeadSettings{}, nil
+	}
+	return &LeadSettings{
+		Threshold:        settings.Threshold,
+		WebhookURL:       settings.WebhookURL,
+		WebhookSecretSet: settings.WebhookSecret != "",
+	}, nil
+}
+
+// UpdateLeadSettings changes the threshold, webhook URL or webhook secret of
+// a user; fields left out keep their value
+func (ws *WhatsAppService) UpdateLeadSettings(userID int, req LeadSettingsRequest) (*LeadSettings, error) {
+	settings, err := ws.db.GetLeadSettings(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lead settings: %w", err)
+	}
+	if settings == nil {
+		settings = &WhatsAppLeadSettings{UserID: userID}
+	}
+
+	if req.Threshold != nil {
+		if *req.Threshold < 0 {
+			return nil, fmt.Errorf("threshold must not be negative")
+		}
+		settings.Threshold = *req.Threshold
+	}
+	if req.WebhookURL != nil {
+		webhookURL := strings.TrimSpace(*req.WebhookURL)
+		if webhookURL != "" {
+			parsed, err := url.Parse(webhookURL)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return nil, fmt.Errorf("webhook_url must be an http or https URL")
+			}
+			if len(webhookURL) > 2048 {
+				return nil, fmt.Errorf("webhook_url is longer than 2048 characters")
+			}
+		}
+		settings.WebhookURL = webhookURL
+	}
+	if req.WebhookSecret != nil {
+		if len(*req.WebhookSecret) > 255 {
+			return nil, fmt.Errorf("webhook_secret is longer than 255 characters")
+		}
+		settings.WebhookSecret = *req.WebhookSecret
+	}
+
+	settings.UpdatedAt = time.Now()
+	if err := ws.db.SaveLeadSettings(settings); err != nil {
+		return nil, fmt.Errorf("failed to save lead settings: %w", err)
+	}
+	return ws.GetLeadSettings(userID)
+}
+
+// GetContactScores pages through the scored contacts of a user
+func (ws *WhatsAppService) GetContactScores(userID int, q ListQuery) ([]WhatsAppContactScore, int64, error) {
+	if hot, ok := q.Filters["hot"]; ok && hot != "true" && hot != "false" {
+		return nil, 0, fmt.Errorf("hot must be true or false")
+	}
+	scores, total, err := ws.db.PageContactScores(userID, q)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load contact scores: %w", err)
+	}
+	return scores, total, nil
+}
+
+// GetContactScore returns the score of a contact, 0 if it never scored
+func (ws *WhatsAppService) GetContactScore(userID int, contact string) (*WhatsAppContactScore, error) {
+	jid, err := parseContactJID(contact)
+	if err != nil {
+		return nil, err
+	}
+
+	contactJID := ws.db.CanonicalContactJID(jid.String())
+	score, err := ws.db.GetContactScore(userID, contactJID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &WhatsAppContactScore{UserID: userID, JID: contactJID}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load contact score: %w", err)
+	}
+	return score, nil
+}
+
+// ResetContactScore sets the score of a contact back to 0, so it can become
+// a hot lead again
+func (ws *WhatsAppService) ResetContactScore(userID int, contact string) error {
+	jid, err := parseContactJID(contact)
+	if err != nil {
+		return err
+	}
+
+	removed, err := ws.db.DeleteContactScore(userID, ws.db.CanonicalContactJID(jid.String()))
+	if err != nil {
+		return fmt.Errorf("failed to reset contact score: %w", err)
+	}
+	if removed == 0 {
+		return fmt.Errorf("contact score not found")
+	}
+	return nil
+}
+
+// attachContactScores loads the scores of a page of contacts
+func (ws *WhatsAppService) attachContactScores(userID int, contacts []WhatsAppContact) error {
+	jids := make([]string, 0, len(contacts))
+	for _, contact := range contacts {
+		jids = append(jids, contact.JID)
+	}
+	scores, err := ws.db.GetContactScores(userID, jids)
+	if err != nil {
+		return err
+	}
+	for i := range contacts {
+		if score, ok := scores[contacts[i].JID]; ok {
+			contacts[i].Score = score.Score
+			contacts[i].HotLeadAt = score.HotLeadAt
+		}
+	}
+	return nil
+}
+
+// scoreMessage adds the points of the scoring rules an incoming message
+// matches to its sender's score
+func (ws *WhatsAppService) scoreMessage(sc *SessionClient, evt *events.Message, content string) {
+	if evt.Info.IsFromMe || !wajid.IsUser(evt.Info.Chat) || strings.TrimSpace(content) == "" {
+		return
+	}
+
+	rules, err := ws.db.GetActiveScoringRules(sc.UserID, sc.SessionID)
+	if err != nil {
+		log.Printf("❌ Failed to load scoring rules for session %s: %v", sc.SessionID, err)
+		return
+	}
+
+	points := 0
+	var ruleIDs []int64
+	for _, rule := range rules {
+		if keywordMatches(rule.MatchType, rule.Keyword, content) {
+			points += rule.Points
+			ruleIDs = append(ruleIDs, rule.ID)
+		}
+	}
+	if len(ruleIDs) == 0 {
+		return
+	}
+
+	jid := evt.Info.Sender.ToNonAD()
+	if phone := ws.phoneForJID(sc, evt.Info.Sender); phone != "" {
+		jid = types.NewJID(phone, types.DefaultUserServer)
+	}
+	score, err := ws.db.AddContactScore(sc.UserID, jid.String(), points)
+	if err != nil {
+		log.Printf("❌ Failed to score %s: %v", jid.String(), err)
+		return
+	}
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	scored := map[string]interface{}{
+		"jid":        score.JID,
+		"points":     points,
+		"score":      score.Score,
+		"rule_ids":   ruleIDs,
+		"message_id": evt.Info.ID,
+	}
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "contact_scored", scored)
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{Type: "contact_scored", Data: scored})
+
+	settings, err := ws.db.GetLeadSettings(sc.UserID)
+	if err != nil {
+		log.Printf("⚠️  Failed to load lead settings of user %d: %v", sc.UserID, err)
+		return
+	}
+	if settings == nil || settings.Threshold <= 0 || score.Score < settings.Threshold {
+		return
+	}
+	hot, err := ws.db.MarkHotLead(sc.UserID, score.JID, settings.Threshold)
+	if err != nil {
+		log.Printf("❌ Failed to mark %s as a hot lead: %v", score.JID, err)
+		return
+	}
+	if !hot {
+		return
+	}
+
+	log.Printf("🔥 %s is a hot lead (score %d, threshold %d)", score.JID, score.Score, settings.Threshold)
+	lead := map[string]interface{}{
+		"jid":        score.JID,
+		"score":      score.Score,
+		"threshold":  settings.Threshold,
+		"message_id": evt.Info.ID,
+	}
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "hot_lead", lead)
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{Type: "hot_lead", Data: lead})
+
+	if settings.WebhookURL != "" {
+		payload := map[string]interface{}{
+			"event":      "hot_lead",
+			"user_id":    sc.UserID,
+			"session_id": sc.SessionID,
+			"timestamp":  time.Now().UTC().Format(time.RFC3339),
+		}
+		for k, v := range lead {
+			payload[k] = v
+		}
+		go ws.postLeadWebhook(*settings, payload)
+	}
+}
+
+// postLeadWebhook POSTs a hot lead to the user's webhook, retrying failed
+// deliveries. With a secret the body is signed with HMAC-SHA256 in the
+// X-Webhook-Signature header ("sha256=<hex>").
+func (ws *WhatsAppService) postLeadWebhook(settings WhatsAppLeadSettings, payload map[string]interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("❌ Failed to encode hot lead webhook: %v", err)
+		return
+	}
+
+	for attempt := 1; attempt <= leadWebhookAttempts; attempt++ {
+		err = postSignedJSON(settings.WebhookURL, settings.WebhookSecret, "hot_lead", body)
+		if err == nil {
+			return
+		}
+		log.Printf("⚠️  Hot lead webhook of user %d failed (attempt %d/%d): %v",
+			settings.UserID, attempt, leadWebhookAttempts, err)
+		if attempt < leadWebhookAttempts {
+			time.Sleep(time.Duration(attempt) * leadWebhookRetryDelay)
+		}
+	}
+	log.Printf("❌ Hot lead webhook of user %d gave up after %d attempts", settings.UserID, leadWebhookAttempts)
+}
+
+// postSignedJSON POSTs a JSON body to a webhook
+func postSignedJSON(webhookURL, secret, event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event)
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := leadWebhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ============= SEGMENTS =============
+// A segment is a saved filter over the contacts table. Nothing is stored per
+// member: broadcast lists and campaigns that target a segment evaluate the
+// filter when they send, so contacts synced or tagged later are included.
+// All conditions of a filter must match; list values match any entry.
+
+// SegmentFilter selects contacts. Empty fields don't restrict the result.
+type SegmentFilter struct {
+	CountryCodes          []string `json:"country_codes,omitempty"`            // dialing codes, e.g. "20"
+	GroupMember           *bool    `json:"group_member,omitempty"`             // seen as a group participant
+	GroupJIDs             []string `json:"group_jids,omitempty"`               // participant of any of these groups
+	Tags                  []string `json:"tags,omitempty"`                     // has any of these tags
+	ExcludeTags           []string `json:"exclude_tags,omitempty"`             // has none of these tags
+	LastMessageWithinDays int      `json:"last_message_within_days,omitempty"` // chat active in the last N days
+	NoMessageForDays      int      `json:"no_message_for_days,omitempty"`      // no chat activity in the last N days
+}
+
+// SegmentRequest creates or replaces a segment
+type SegmentRequest struct {
+	Name        string        `json:"name" binding:"required"`
+	Description string        `json:"description"`
+	Filter      SegmentFilter `json:"filter"`
+}
+
+// normalizeSegmentFilter validates a filter and brings its values into the
+// form stored in the contacts table
+func normalizeSegmentFilter(filter SegmentFilter) (SegmentFilter, error) {
+	if filter.LastMessageWithinDays < 0 || filter.NoMessageForDays < 0 {
+		return filter, fmt.Errorf("day filters must not be negative")
+	}
+
+	codes := make([]string, 0, len(filter.CountryCodes))
+	for _, code := range filter.CountryCodes {
+		code = strings.TrimPrefix(strings.TrimSpace(code), "+")
+		if code == "" || len(code) > 4 || strings.Trim(code, "0123456789") != "" {
+			return filter, fmt.Errorf("invalid country code %q", code)
+		}
+		codes = append(codes, code)
+	}
+	filter.CountryCodes = codes
+
+	groups := make([]string, 0, len(filter.GroupJIDs))
+	for _, group := range filter.GroupJIDs {
+		groupJID, err := parseGroupJID(strings.TrimSpace(group))
+		if err != nil {
+			return filter, err
+		}
+		groups = append(groups, groupJID.String())
+	}
+	filter.GroupJIDs = groups
+
+	for _, tags := range []*[]string{&filter.Tags, &filter.ExcludeTags} {
+		normalized := make([]string, 0, len(*tags))
+		for _, tag := range *tags {
+			tag, err := normalizeTag(tag)
+			if err != nil {
+				return filter, err
+			}
+			normalized = append(normalized, tag)
+		}
+		*tags = normalized
+	}
+
+	return filter, nil
+}
+
+// CreateSegment saves a new segment
+func (ws *WhatsAppService) CreateSegment(userID int, req SegmentRequest) (*WhatsAppSegment, error) {
+	filter, err := normalizeSegmentFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	segment := &WhatsAppSegment{
+		UserID:      userID,
+		Name:        strings.TrimSpace(req.Name),
+		Description: req.Description,
+		Filter:      filter,
+	}
+	if err := ws.db.CreateSegment(segment); err != nil {
+		if isDuplicateKeyError(err) {
+			return nil, fmt.Errorf("segment %q already exists", segment.Name)
+		}
+		return nil, fmt.Errorf("failed to create segment: %w", err)
+	}
+
+	log.Printf("🎯 Segment %d %q created", segment.ID, segment.Name)
+	return segment, nil
+}
+
+// GetSegment returns a saved segment
+func (ws *WhatsAppService) GetSegment(userID int, segmentID int64) (*WhatsAppSegment, error) {
+	segment, err := ws.db.GetSegment(segmentID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("segment not found")
+		}
+		return nil, fmt.Errorf("failed to load segment: %w", err)
+	}
+	return segment, nil
+}
+
+// UpdateSegment replaces the name, description and filter of a segment
+func (ws *WhatsAppService) UpdateSegment(userID int, segmentID int64, req SegmentRequest) (*WhatsAppSegment, error) {
+	segment, err := ws.GetSegment(userID, segmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := normalizeSegmentFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	segment.Name = strings.TrimSpace(req.Name)
+	segment.Description = req.Description
+	segment.Filter = filter
+	if err := ws.db.UpdateSegment(segment); err != nil {
+		if isDuplicateKeyError(err) {
+			return nil, fmt.Errorf("segment %q already exists", segment.Name)
+		}
+		return nil, fmt.Errorf("failed to update segment: %w", err)
+	}
+	return segment, nil
+}
+
+// DeleteSegment removes a segment. Broadcast lists linked to it keep their
+// static members only.
+func (ws *WhatsAppService) DeleteSegment(userID int, segmentID int64) error {
+	deleted, err := ws.db.DeleteSegment(segmentID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete segment: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("segment not found")
+	}
+	return nil
+}
+
+// GetSegmentContacts evaluates a segment and returns the matching contacts
+func (ws *WhatsAppService) GetSegmentContacts(userID int, segmentID int64, limit int) ([]WhatsAppContact, int64, error) {
+	segment, err := ws.GetSegment(userID, segmentID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := ws.db.CountSegmentContacts(userID, segment.Filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to evaluate segment: %w", err)
+	}
+	contacts, err := ws.db.GetSegmentContacts(userID, segment.Filter, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to evaluate segment: %w", err)
+	}
+	return contacts, total, nil
+}
+
+// segmentMembers evaluates a segment for sending
+func (ws *WhatsAppService) segmentMembers(userID int, segmentID int64) ([]WhatsAppContact, error) {
+	segment, err := ws.GetSegment(userID, segmentID)
+	if err != nil {
+		return nil, err
+	}
+	contacts, err := ws.db.GetSegmentContacts(userID, segment.Filter, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate segment: %w", err)
+	}
+	return contacts, nil
+}
+
+// addSegmentRecipients adds the current members of a list's segment to its
+// static recipients
+func (ws *WhatsAppService) addSegmentRecipients(list *WhatsAppBroadcastList) error {
+	if list.SegmentID == nil {
+		return nil
+	}
+
+	contacts, err := ws.segmentMembers(list.UserID, *list.SegmentID)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(list.Recipients))
+	for _, recipient := range list.Recipients {
+		seen[recipient.JID] = true
+	}
+	for _, contact := range contacts {
+		if !seen[contact.JID] {
+			seen[contact.JID] = true
+			list.Recipients = append(list.Recipients, WhatsAppBroadcastRecipient{ListID: list.ID, JID: contact.JID})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// ============= SEND INTENTS =============
+// Outgoing messages are recorded before they are handed to WhatsApp. The
+// message ID is generated up front and stored in a pending send intent; a
+// send whose intent can't be stored is refused. When WhatsApp accepts the
+// message, the intent is deleted and the message stored in one transaction,
+// so a stored sent message always exists on WhatsApp, and it is stored once
+// (history sync brings the same message ID back and is ignored). A rejected
+// send only deletes its intent.
+//
+// Intents still pending on startup were interrupted between the two steps.
+// Whether WhatsApp got the message is unknown, so they aren't resent or
+// stored; they are marked interrupted and reported (send_interrupted). Each
+// intent records the instance sending it (JOBS_INSTANCE), and an instance
+// only reconciles its own intents, since those of other instances sharing the
+// database may still be in flight. Intents older than sendIntentLease are
+// reconciled by any instance, so those of an instance that never comes back
+// are reported too.
+
+// sendIntentLease is how long a send may take before any instance treats its
+// pending intent as interrupted; whatsmeow gives up on a send well before
+const sendIntentLease = 10 * time.Minute
+
+// beginSend records the intent to send a message
+func (ws *WhatsAppService) beginSend(sc *SessionClient, recipient types.JID, message *waE2E.Message) (*WhatsAppSendIntent, error) {
+	intent := &WhatsAppSendIntent{
+		SessionID:   sc.SessionID,
+		UserID:      sc.UserID,
+		ChatJID:     recipient.String(),
+		MessageID:   string(sc.Client.GenerateMessageID()),
+		MessageType: ws.getMessageType(message),
+		Status:      SendIntentPending,
+		Instance:    ws.cfg.JobsInstance,
+	}
+	if err := ws.db.CreateSendIntent(intent); err != nil {
+		return nil, fmt.Errorf("failed to record send: %w", err)
+	}
+	return intent, nil
+}
+
+// deliverSend sends a message under its intent's ID and settles the intent
+func (ws *WhatsAppService) deliverSend(sc *SessionClient, intent *WhatsAppSendIntent, recipient types.JID, message *waE2E.Message) (whatsmeow.SendResponse, error) {
+	resp, err := sc.Client.SendMessage(context.Background(), recipient, message, whatsmeow.SendRequestExtra{
+		ID: types.MessageID(intent.MessageID),
+	})
+	if err != nil {
+		if dbErr := ws.db.DeleteSendIntent(intent.ID); dbErr != nil {
+			log.Printf("⚠️  Failed to clear send intent %s: %v", intent.MessageID, dbErr)
+		}
+		return resp, err
+	}
+
+	// Status updates are tracked as status posts, not chat messages
+	var stored *WhatsAppMessage
+	if recipient != types.StatusBroadcastJID {
+		stored = ws.buildSentMessage(sc, recipient, message, resp)
+	}
+	if err := ws.db.CompleteSendIntent(intent.ID, stored); err != nil {
+		// The intent stays pending and is reported on the next startup
+		log.Printf("⚠️  Message %s sent but not recorded for session %s: %v", resp.ID, sc.SessionID, err)
+		return resp, nil
+	}
+	if stored != nil {
+		if err := ws.db.TouchChat(sc.SessionID, sc.UserID, stored.ChatJID, recipient.Server == types.GroupServer, resp.Timestamp); err != nil {
+			log.Printf("⚠️  Failed to update chat %s for session %s: %v", stored.ChatJID, sc.SessionID, err)
+		}
+	}
+	return resp, nil
+}
+
+// buildSentMessage converts a message WhatsApp accepted into its database
+// representation
+func (ws *WhatsAppService) buildSentMessage(sc *SessionClient, recipient types.JID, message *waE2E.Message, resp whatsmeow.SendResponse) *WhatsAppMessage {
+	_, viewOnce, ephemeral := unwrapMessage(message)
+	stored := &WhatsAppMessage{
+		SessionID:   sc.SessionID,
+		UserID:      sc.UserID,
+		ChatJID:     recipient.String(),
+		MessageID:   resp.ID,
+		FromMe:      true,
+		MessageType: ws.getMessageType(message),
+		Content:     ws.extractMessageContent(message),
+		Media:       mediaReference(message),
+		IsRead:      true,
+		Source:      "api",
+		ViewOnce:    viewOnce,
+		Ephemeral:   ephemeral,
+		Timestamp:   resp.Timestamp,
+	}
+	if sc.Device.ID != nil {
+		stored.SenderJID = sc.Device.ID.ToNonAD().String()
+	}
+	return stored
+}
+
+// ReconcileSendIntents marks the sends interrupted by a previous run of this
+// instance, or left pending past the lease by any instance, and reports them;
+// call it on startup before sessions are restored
+func (ws *WhatsAppService) ReconcileSendIntents() {
+	now := time.Now()
+	intents, err := ws.db.GetPendingSendIntents(ws.cfg.JobsInstance, now, now.Add(-sendIntentLease))
+	if err != nil {
+		log.Printf("❌ Failed to load pending send intents: %v", err)
+		return
+	}
+	if len(intents) == 0 {
+		return
+	}
+
+	interrupted := 0
+	for _, intent := range intents {
+		marked, err := ws.db.InterruptSendIntent(intent.ID)
+		if err != nil {
+			log.Printf("❌ Failed to mark send intent %s interrupted: %v", intent.MessageID, err)
+			continue
+		}
+		if !marked {
+			continue
+		}
+		interrupted++
+
+		data := map[string]interface{}{
+			"message_id": intent.MessageID,
+			"to":         intent.ChatJID,
+			"type":       intent.MessageType,
+			"created_at": intent.CreatedAt,
+		}
+		if sessionUUID, err := uuid.Parse(intent.SessionID); err == nil {
+			ws.db.CreateEvent(sessionUUID, intent.UserID, "send_interrupted", data)
+		}
+		ws.wsManager.SendToSession(intent.SessionID, WebSocketMessage{
+			Type: "send_interrupted",
+			Data: data,
+		})
+	}
+	if interrupted > 0 {
+		log.Printf("⚠️  %d send(s) were interrupted by a restart; delivery unknown, not resent", interrupted)
+	}
+}
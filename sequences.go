@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"gorm.io/gorm"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= SEQUENCES =============
+// A sequence is a drip campaign of a session: ordered steps, each a message
+// template sent a delay after the enrollment (first step) or the previous
+// step. Contacts are enrolled through the API or by an auto-reply rule with a
+// sequence_id. The sequence worker sends the due steps; each step is claimed
+// for sequenceClaimLease first so two pollers don't both send it. Progress
+// is kept per enrollment, so a restart resumes where it left off. A message
+// from an enrolled contact to the sequence's session cancels the enrollment
+// (reason "replied"), so follow-ups stop once the contact answers. Suppressed
+// contacts are cancelled instead of messaged. Steps held back by the safety
+// engine wait until it allows sends again; steps of an offline session or a
+// disabled sequence wait too.
+
+const (
+	sequencePollInterval = 30 * time.Second
+	sequenceBatchSize    = 100
+	sequenceClaimLease   = 5 * time.Minute
+	sequenceMaxSteps     = 20
+	sequenceNameMaxLen   = 100
+	sequenceMaxDelay     = 90 * 24 * time.Hour
+)
+
+// Enrollment sources and cancel reasons
+const (
+	EnrollmentSourceManual    = "manual"
+	EnrollmentSourceAutoReply = "auto_reply"
+
+	EnrollmentReplied    = "replied"
+	EnrollmentUnenrolled = "unenrolled"
+	EnrollmentSuppressed = "suppressed"
+)
+
+// SequenceRequest creates or replaces a sequence
+type SequenceRequest struct {
+	Name      string         `json:"name" binding:"required"`
+	SessionID string         `json:"session_id" binding:"required"`
+	Steps     []SequenceStep `json:"steps" binding:"required"`
+	Enabled   *bool          `json:"enabled"` // defaults to true
+}
+
+// EnrollRequest enrolls contacts in a sequence
+type EnrollRequest struct {
+	Contacts []string `json:"contacts" binding:"required,min=1,max=1000"` // phone numbers or JIDs
+}
+
+// applySequenceRequest validates a request and copies it onto sequence
+func (ws *WhatsAppService) applySequenceRequest(userID int, req SequenceRequest, sequence *WhatsAppSequence) error {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > sequenceNameMaxLen {
+		return fmt.Errorf("%w: name must be 1 to %d characters", apierr.ErrInvalidRequest, sequenceNameMaxLen)
+	}
+	if len(req.Steps) == 0 || len(req.Steps) > sequenceMaxSteps {
+		return fmt.Errorf("%w: a sequence has 1 to %d steps", apierr.ErrInvalidRequest, sequenceMaxSteps)
+	}
+	for i, step := range req.Steps {
+		if strings.TrimSpace(step.Message) == "" {
+			return fmt.Errorf("%w: step %d has no message", apierr.ErrInvalidRequest, i+1)
+		}
+		if err := validateTemplate(step.Message); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+		if step.DelaySeconds < 0 || time.Duration(step.DelaySeconds)*time.Second > sequenceMaxDelay {
+			return fmt.Errorf("%w: delay_seconds of step %d must be 0 to %d", apierr.ErrInvalidRequest, i+1, int64(sequenceMaxDelay/time.Second))
+		}
+	}
+
+	sessionUUID, err := uuid.Parse(req.SessionID)
+	if err != nil {
+		return apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return apierr.ErrSessionNotFound
+	}
+
+	sequence.UserID = userID
+	sequence.SessionID = req.SessionID
+	sequence.Name = name
+	sequence.Steps = req.Steps
+	sequence.Enabled = req.Enabled == nil || *req.Enabled
+	return nil
+}
+
+// CreateSequence saves a new sequence
+func (ws *WhatsAppService) CreateSequence(userID int, req SequenceRequest) (*WhatsAppSequence, error) {
+	sequence := &WhatsAppSequence{}
+	if err := ws.applySequenceRequest(userID, req, sequence); err != nil {
+		return nil, err
+	}
+	if err := ws.db.CreateSequence(sequence); err != nil {
+		if isDuplicateKeyError(err) {
+			return nil, fmt.Errorf("%w: sequence %q already exists", apierr.ErrConflict, sequence.Name)
+		}
+		return nil, fmt.Errorf("failed to create sequence: %w", err)
+	}
+
+	log.Printf("📨 Sequence %d %q created with %d steps", sequence.ID, sequence.Name, len(sequence.Steps))
+	return sequence, nil
+}
+
+// GetSequences lists the user's sequences
+func (ws *WhatsAppService) GetSequences(userID int) ([]WhatsAppSequence, error) {
+	sequences, err := ws.db.GetSequences(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sequences: %w", err)
+	}
+	return sequences, nil
+}
+
+// GetSequence returns a sequence of the user
+func (ws *WhatsAppService) GetSequence(userID int, sequenceID int64) (*WhatsAppSequence, error) {
+	sequence, err := ws.db.GetSequence(sequenceID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: sequence not found", apierr.ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to load sequence: %w", err)
+	}
+	return sequence, nil
+}
+
+// UpdateSequence replaces a sequence. Active enrollments keep their position:
+// they continue with the step at their next_step index, and finish if the
+// sequence has no step there anymore.
+func (ws *WhatsAppService) UpdateSequence(userID int, sequenceID int64, req SequenceRequest) (*WhatsAppSequence, error) {
+	sequence, err := ws.GetSequence(userID, sequenceID)
+	if err != nil {
+		return nil, err
+	}
+	if req.SessionID != sequence.SessionID {
+		return nil, fmt.Errorf("%w: the session of a sequence can't be changed", apierr.ErrInvalidRequest)
+	}
+	if err := ws.applySequenceRequest(userID, req, sequence); err != nil {
+		return nil, err
+	}
+	if err := ws.db.UpdateSequence(sequence); err != nil {
+		if isDuplicateKeyError(err) {
+			return nil, fmt.Errorf("%w: sequence %q already exists", apierr.ErrConflict, sequence.Name)
+		}
+		return nil, fmt.Errorf("failed to update sequence: %w", err)
+	}
+	return sequence, nil
+}
+
+// DeleteSequence removes a sequence with its enrollments
+func (ws *WhatsAppService) DeleteSequence(userID int, sequenceID int64) error {
+	deleted, err := ws.db.DeleteSequence(sequenceID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete sequence: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%w: sequence not found", apierr.ErrNotFound)
+	}
+	log.Printf("📨 Sequence %d deleted", sequenceID)
+	return nil
+}
+
+// EnrollContacts enrolls contacts in a sequence. Contacts already active in
+// it are returned unchanged; finished enrollments start over. Inputs that
+// aren't phone numbers or user JIDs are returned in invalid.
+func (ws *WhatsAppService) EnrollContacts(userID int, sequenceID int64, contacts []string) ([]WhatsAppSequenceEnrollment, map[string]string, error) {
+	sequence, err := ws.GetSequence(userID, sequenceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	enrolled := make([]WhatsAppSequenceEnrollment, 0, len(contacts))
+	invalid := make(map[string]string)
+	for _, input := range contacts {
+		jid, err := parseContactJID(input)
+		if err != nil {
+			invalid[input] = err.Error()
+			continue
+		}
+		enrollment, err := ws.enroll(sequence, ws.db.CanonicalContactJID(jid.String()), EnrollmentSourceManual)
+		if err != nil {
+			return nil, invalid, err
+		}
+		enrolled = append(enrolled, *enrollment)
+	}
+	return enrolled, invalid, nil
+}
+
+// enroll starts a contact on the first step of a sequence
+func (ws *WhatsAppService) enroll(sequence *WhatsAppSequence, contactJID, source string) (*WhatsAppSequenceEnrollment, error) {
+	now := time.Now()
+	nextRunAt := now.Add(time.Duration(sequence.Steps[0].DelaySeconds) * time.Second)
+	enrollment := &WhatsAppSequenceEnrollment{
+		SequenceID: sequence.ID,
+		UserID:     sequence.UserID,
+		SessionID:  sequence.SessionID,
+		ContactJID: contactJID,
+		Status:     EnrollmentActive,
+		Source:     source,
+		NextRunAt:  &nextRunAt,
+		EnrolledAt: now,
+	}
+
+	existing, err := ws.db.GetEnrollment(sequence.ID, contactJID)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		err = ws.db.CreateEnrollment(enrollment)
+		if isDuplicateKeyError(err) {
+			// Enrolled concurrently
+			return ws.db.GetEnrollment(sequence.ID, contactJID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to enroll %s: %w", contactJID, err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to load enrollment of %s: %w", contactJID, err)
+	case existing.Status == EnrollmentActive:
+		return existing, nil
+	default:
+		enrollment.ID = existing.ID
+		if _, err := ws.db.RestartEnrollment(enrollment); err != nil {
+			return nil, fmt.Errorf("failed to enroll %s: %w", contactJID, err)
+		}
+		return ws.db.GetEnrollment(sequence.ID, contactJID)
+	}
+
+	log.Printf("📨 Enrolled %s in sequence %d (%s)", contactJID, sequence.ID, source)
+	return enrollment, nil
+}
+
+// UnenrollContact cancels the active enrollment of a contact in a sequence
+func (ws *WhatsAppService) UnenrollContact(userID int, sequenceID int64, contact string) error {
+	sequence, err := ws.GetSequence(userID, sequenceID)
+	if err != nil {
+		return err
+	}
+	jid, err := parseContactJID(contact)
+	if err != nil {
+		return err
+	}
+
+	enrollment, err := ws.db.GetEnrollment(sequence.ID, ws.db.CanonicalContactJID(jid.String()))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: enrollment not found", apierr.ErrNotFound)
+	} else if err != nil {
+		return fmt.Errorf("failed to load enrollment: %w", err)
+	}
+
+	cancelled, err := ws.db.UpdateActiveEnrollment(enrollment.ID, map[string]interface{}{
+		"status":        EnrollmentCancelled,
+		"cancel_reason": EnrollmentUnenrolled,
+		"next_run_at":   nil,
+		"ended_at":      time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to cancel enrollment: %w", err)
+	}
+	if !cancelled {
+		return fmt.Errorf("%w: enrollment is %s, only active enrollments can be cancelled", apierr.ErrConflict, enrollment.Status)
+	}
+	return nil
+}
+
+// GetEnrollments pages through the enrollments of a sequence
+func (ws *WhatsAppService) GetEnrollments(userID int, sequenceID int64, q ListQuery) ([]WhatsAppSequenceEnrollment, int64, error) {
+	if _, err := ws.GetSequence(userID, sequenceID); err != nil {
+		return nil, 0, err
+	}
+	enrollments, total, err := ws.db.GetEnrollments(sequenceID, q)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load enrollments: %w", err)
+	}
+	return enrollments, total, nil
+}
+
+// enrollSender enrolls the sender of a message that matched an auto-reply
+// rule. Only messages to the sequence's own session enroll.
+func (ws *WhatsAppService) enrollSender(sc *SessionClient, sender types.JID, sequenceID int64) {
+	sequence, err := ws.db.GetSequence(sequenceID, sc.UserID)
+	if err != nil {
+		log.Printf("⚠️  Auto-reply sequence %d not found: %v", sequenceID, err)
+		return
+	}
+	if sequence.SessionID != sc.SessionID {
+		return
+	}
+
+	jid := sender.ToNonAD()
+	if phone := ws.phoneForJID(sc, sender); phone != "" {
+		jid = types.NewJID(phone, types.DefaultUserServer)
+	}
+	if _, err := ws.enroll(sequence, jid.String(), EnrollmentSourceAutoReply); err != nil {
+		log.Printf("❌ %v", err)
+	}
+}
+
+// cancelSequencesOnReply stops the sequences of a session for a contact who
+// sent it a message
+func (ws *WhatsAppService) cancelSequencesOnReply(sc *SessionClient, evt *events.Message) {
+	if evt.Info.IsFromMe || evt.Info.IsGroup {
+		return
+	}
+
+	jids := []string{evt.Info.Sender.ToNonAD().String()}
+	if !evt.Info.SenderAlt.IsEmpty() {
+		jids = append(jids, evt.Info.SenderAlt.ToNonAD().String())
+	}
+	if aliases, err := ws.db.GetJIDAliases(jids); err == nil {
+		jids = aliases
+	}
+
+	sequenceIDs, err := ws.db.CancelEnrollments(sc.SessionID, jids, EnrollmentReplied)
+	if err != nil {
+		log.Printf("❌ Failed to cancel sequences of %s on session %s: %v", jids[0], sc.SessionID, err)
+		return
+	}
+	if len(sequenceIDs) == 0 {
+		return
+	}
+
+	log.Printf("📨 %s replied, cancelled %d sequence enrollment(s)", jids[0], len(sequenceIDs))
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	data := map[string]interface{}{
+		"contact_jid":  jids[0],
+		"sequence_ids": sequenceIDs,
+		"reason":       EnrollmentReplied,
+	}
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "sequence_cancelled", data)
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{Type: "sequence_cancelled", Data: data})
+}
+
+// StartSequenceWorker sends due sequence steps until the context is cancelled
+func (ws *WhatsAppService) StartSequenceWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(sequencePollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				ws.processSequences(ctx, now)
+			}
+		}
+	}()
+	log.Println("✅ Sequence worker started")
+}
+
+func (ws *WhatsAppService) processSequences(ctx context.Context, now time.Time) {
+	due, err := ws.db.GetDueEnrollments(now, sequenceBatchSize)
+	if err != nil {
+		log.Printf("❌ Failed to load due sequence steps: %v", err)
+		return
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	ids := make([]int64, 0, len(due))
+	for _, enrollment := range due {
+		ids = append(ids, enrollment.SequenceID)
+	}
+	sequences, err := ws.db.GetSequencesByID(ids)
+	if err != nil {
+		log.Printf("❌ Failed to load sequences: %v", err)
+		return
+	}
+
+	for i := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		sequence := sequences[due[i].SequenceID]
+		if sequence == nil || !sequence.Enabled {
+			continue
+		}
+		ws.runSequenceStep(sequence, &due[i], now)
+	}
+}
+
+// runSequenceStep sends the next step of an enrollment and schedules the
+// one after it
+func (ws *WhatsAppService) runSequenceStep(sequence *WhatsAppSequence, enrollment *WhatsAppSequenceEnrollment, now time.Time) {
+	if enrollment.NextStep >= len(sequence.Steps) {
+		// The sequence lost steps since the last one was sent
+		ws.db.UpdateActiveEnrollment(enrollment.ID, map[string]interface{}{
+			"status":      EnrollmentCompleted,
+			"next_run_at": nil,
+			"ended_at":    now,
+		})
+		return
+	}
+
+	sc, err := ws.GetSessionClient(enrollment.SessionID)
+	if err != nil || !sc.Client.IsConnected() {
+		return
+	}
+	contact, err := types.ParseJID(enrollment.ContactJID)
+	if err != nil {
+		ws.endEnrollment(enrollment, EnrollmentFailed, "", err)
+		return
+	}
+
+	claimed, err := ws.db.ClaimEnrollmentStep(enrollment, now, now.Add(sequenceClaimLease))
+	if err != nil {
+		log.Printf("❌ Failed to claim step of enrollment %d: %v", enrollment.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	if err := ws.checkSuppressed(sc, contact); err != nil {
+		if errors.Is(err, ErrSuppressed) {
+			ws.endEnrollment(enrollment, EnrollmentCancelled, EnrollmentSuppressed, nil)
+		} else {
+			log.Printf("⚠️  Sequence step for %s postponed: %v", enrollment.ContactJID, err)
+		}
+		return
+	}
+
+	step := sequence.Steps[enrollment.NextStep]
+	text := step.Message
+	if isTemplate(text) {
+		vars := ws.recipientVariables(sc, contact, ws.templateContacts(sc, []types.JID{contact}))
+		rendered, err := renderMessage(text, vars, rand.New(rand.NewSource(time.Now().UnixNano())), ws.textLengthLimit())
+		if err != nil {
+			ws.endEnrollment(enrollment, EnrollmentFailed, "", err)
+			return
+		}
+		text = rendered.Text
+	}
+
+	_, err = ws.sendTextToJID(sc, contact, text)
+	var limitErr *SendLimitError
+	if errors.As(err, &limitErr) {
+		ws.db.UpdateActiveEnrollment(enrollment.ID, map[string]interface{}{"next_run_at": limitErr.RetryAt})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Sequence %d step %d to %s failed: %v", sequence.ID, enrollment.NextStep+1, enrollment.ContactJID, err)
+		ws.endEnrollment(enrollment, EnrollmentFailed, "", err)
+		return
+	}
+
+	next := enrollment.NextStep + 1
+	if next >= len(sequence.Steps) {
+		ws.endEnrollment(enrollment, EnrollmentCompleted, "", nil)
+		log.Printf("✅ %s completed sequence %d", enrollment.ContactJID, sequence.ID)
+		return
+	}
+	nextRunAt := time.Now().Add(time.Duration(sequence.Steps[next].DelaySeconds) * time.Second)
+	ws.db.UpdateActiveEnrollment(enrollment.ID, map[string]interface{}{
+		"next_step":   next,
+		"next_run_at": nextRunAt,
+		"last_error":  "",
+	})
+}
+
+// endEnrollment finishes an active enrollment
+func (ws *WhatsAppService) endEnrollment(enrollment *WhatsAppSequenceEnrollment, status EnrollmentStatus, reason string, cause error) {
+	updates := map[string]interface{}{
+		"status":        status,
+		"cancel_reason": reason,
+		"next_run_at":   nil,
+		"ended_at":      time.Now(),
+	}
+	if cause != nil {
+		updates["last_error"] = truncate(cause.Error(), 500)
+	}
+	if _, err := ws.db.UpdateActiveEnrollment(enrollment.ID, updates); err != nil {
+		log.Printf("❌ Failed to end enrollment %d: %v", enrollment.ID, err)
+	}
+}
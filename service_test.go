@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"whatsapp-api/internal/wafake"
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= SERVICE TESTS =============
+// These run WhatsAppService end to end against a temporary SQLite database
+// and a wafake.Client in place of the whatsmeow connection: sessions are
+// created and connected through the service, events are delivered with
+// Dispatch and the calls reaching WhatsApp are read back from the fake.
+
+const testUserID = 1
+
+var testOwnJID = types.NewJID("15550000001", types.DefaultUserServer)
+
+// newTestService returns a service whose sessions connect through fake
+func newTestService(t *testing.T, fake *wafake.Client) *WhatsAppService {
+	t.Helper()
+	dir := t.TempDir()
+
+	t.Setenv("JWT_SECRET", "service-test-secret-service-test-secret")
+	t.Setenv("MEDIA_STORAGE", "local")
+	t.Setenv("MEDIA_STORAGE_DIR", filepath.Join(dir, "media"))
+	t.Setenv("JOBS_BACKEND", "memory")
+	t.Setenv("SAFETY_MIN_DELAY", "0s")
+	t.Setenv("SAFETY_MAX_DELAY", "0s")
+	cfg, err := readConfig()
+	if err != nil {
+		t.Fatalf("readConfig: %v", err)
+	}
+
+	dm := newTestDatabase(t, dir)
+	ws := NewWhatsAppService(cfg, dm, NewWebSocketManager(dm))
+	ws.newClient = func(device *store.Device) WhatsAppClient { return fake }
+	return ws
+}
+
+// newTestDatabase opens a temporary SQLite app database and device store in
+// dir
+func newTestDatabase(t *testing.T, dir string) *DatabaseManager {
+	t.Helper()
+
+	// Event handlers and session workers write concurrently with the test
+	dsn := filepath.Join(dir, "app.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := gorm.Open(sqlite.New(sqlite.Config{DriverName: "sqlite", DSN: dsn}),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open app database: %v", err)
+	}
+	if err := db.AutoMigrate(appModels()...); err != nil {
+		t.Fatalf("migrate app database: %v", err)
+	}
+
+	raw, err := sql.Open("sqlite", filepath.Join(dir, "store.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("open device store: %v", err)
+	}
+	container := sqlstore.NewWithDB(raw, "sqlite", nil)
+	if err := container.Upgrade(context.Background()); err != nil {
+		t.Fatalf("upgrade device store: %v", err)
+	}
+	t.Cleanup(func() { raw.Close() })
+
+	return &DatabaseManager{db: db, sqlDB: container, waContainer: container}
+}
+
+// connectTestSession creates a session and connects it as testOwnJID
+func connectTestSession(t *testing.T, ws *WhatsAppService, fake *wafake.Client) *SessionClient {
+	t.Helper()
+	session, err := ws.CreateSession(testUserID, "test")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	t.Cleanup(func() { ws.DeleteSession(session.ID, testUserID) })
+
+	waitFor(t, "client to connect", fake.IsConnected)
+	sc, err := ws.GetSessionClient(session.ID)
+	if err != nil {
+		t.Fatalf("GetSessionClient: %v", err)
+	}
+	jid := testOwnJID
+	sc.Device.ID = &jid
+	fake.Dispatch(&events.Connected{})
+	return sc
+}
+
+// waitFor polls until done reports true
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func sessionStatus(t *testing.T, ws *WhatsAppService, sessionID string) SessionStatus {
+	t.Helper()
+	session, err := ws.db.GetSession(uuid.MustParse(sessionID), testUserID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	return session.Status
+}
+
+func TestConnectAndDisconnect(t *testing.T) {
+	fake := &wafake.Client{OwnJID: testOwnJID, LoggedIn: true}
+	ws := newTestService(t, fake)
+	sc := connectTestSession(t, ws, fake)
+
+	if status := sessionStatus(t, ws, sc.SessionID); status != StatusConnected {
+		t.Fatalf("status after Connected = %q, want %q", status, StatusConnected)
+	}
+	session, _ := ws.db.GetSession(uuid.MustParse(sc.SessionID), testUserID)
+	if session.JID == nil || *session.JID != testOwnJID.String() {
+		t.Errorf("stored JID = %v, want %s", session.JID, testOwnJID)
+	}
+	if state := ws.sessions.State(sc.SessionID); state != SessionStateConnected {
+		t.Errorf("session state = %v, want connected", state)
+	}
+
+	fake.Disconnect()
+	fake.Dispatch(&events.Disconnected{})
+	if status := sessionStatus(t, ws, sc.SessionID); status != StatusDisconnected {
+		t.Errorf("status after Disconnected = %q, want %q", status, StatusDisconnected)
+	}
+	if _, err := ws.SendMessage(sc.SessionID, testUserID, "15550000002", "hello"); !errors.Is(err, apierr.ErrSessionNotConnected) {
+		t.Errorf("SendMessage while disconnected = %v, want ErrSessionNotConnected", err)
+	}
+}
+
+func TestDeviceQuota(t *testing.T) {
+	fake := &wafake.Client{OwnJID: testOwnJID, LoggedIn: true}
+	ws := newTestService(t, fake)
+	if err := ws.db.SetUserQuota(testUserID, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Past the default MAX_DEVICES_PER_USER of 5
+	for i := 1; i <= 6; i++ {
+		session, err := ws.CreateSession(testUserID, fmt.Sprintf("device %d", i))
+		if err != nil {
+			t.Fatalf("CreateSession of device %d under a quota of 10: %v", i, err)
+		}
+		t.Cleanup(func() { ws.DeleteSession(session.ID, testUserID) })
+	}
+	if limit := ws.MaxDevices(testUserID); limit != 10 {
+		t.Errorf("MaxDevices = %d, want the quota of 10", limit)
+	}
+
+	if _, err := ws.db.DeleteUserQuota(testUserID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.CreateSession(testUserID, "device 7"); !errors.Is(err, apierr.ErrDeviceLimit) {
+		t.Errorf("CreateSession over MAX_DEVICES_PER_USER = %v, want ErrDeviceLimit", err)
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	recipient := types.NewJID("15550000002", types.DefaultUserServer)
+	fake := &wafake.Client{
+		OwnJID:     testOwnJID,
+		LoggedIn:   true,
+		OnWhatsApp: map[string]types.JID{recipient.User: recipient},
+	}
+	ws := newTestService(t, fake)
+	sc := connectTestSession(t, ws, fake)
+
+	resp, err := ws.SendMessage(sc.SessionID, testUserID, "+"+recipient.User, "hello")
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	sent := fake.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	if sent[0].To != recipient || sent[0].Message.GetConversation() != "hello" {
+		t.Errorf("sent %s %q, want %s %q", sent[0].To, sent[0].Message.GetConversation(), recipient, "hello")
+	}
+	if resp.MessageID != sent[0].ID {
+		t.Errorf("response message ID = %q, want %q", resp.MessageID, sent[0].ID)
+	}
+
+	var stored WhatsAppMessage
+	if err := ws.db.db.Where("session_id = ? AND message_id = ?", sc.SessionID, resp.MessageID).First(&stored).Error; err != nil {
+		t.Errorf("sent message wasn't stored: %v", err)
+	} else if !stored.FromMe || stored.ChatJID != recipient.String() {
+		t.Errorf("stored message from me %v in chat %s, want true in %s", stored.FromMe, stored.ChatJID, recipient)
+	}
+	var intents int64
+	ws.db.db.Model(&WhatsAppSendIntent{}).Where("session_id = ?", sc.SessionID).Count(&intents)
+	if intents != 0 {
+		t.Errorf("%d send intents left after the send", intents)
+	}
+
+	if _, err := ws.SendMessage(sc.SessionID, testUserID, "+15550000009", "hello"); err == nil {
+		t.Error("SendMessage to a number not on WhatsApp returned no error")
+	}
+	if len(fake.Sent()) != 1 {
+		t.Errorf("sent %d messages after the failed send, want 1", len(fake.Sent()))
+	}
+}
+
+func TestSendMessageError(t *testing.T) {
+	recipient := types.NewJID("15550000002", types.DefaultUserServer)
+	fake := &wafake.Client{
+		OwnJID:     testOwnJID,
+		LoggedIn:   true,
+		OnWhatsApp: map[string]types.JID{recipient.User: recipient},
+		Errors:     map[string]error{"SendMessage": &whatsmeow.IQError{Code: 500}},
+	}
+	ws := newTestService(t, fake)
+	sc := connectTestSession(t, ws, fake)
+
+	if _, err := ws.SendMessage(sc.SessionID, testUserID, "+"+recipient.User, "hello"); err == nil {
+		t.Fatal("SendMessage returned no error when WhatsApp failed")
+	}
+	var intents int64
+	ws.db.db.Model(&WhatsAppSendIntent{}).Where("session_id = ?", sc.SessionID).Count(&intents)
+	if intents != 0 {
+		t.Errorf("%d send intents left after the failed send", intents)
+	}
+}
+
+func TestGroupOperations(t *testing.T) {
+	member := types.NewJID("15550000003", types.DefaultUserServer)
+	requester := types.NewJID("15550000004", types.DefaultUserServer)
+	joined := types.NewJID("120363000000000001", types.GroupServer)
+	fake := &wafake.Client{
+		OwnJID:     testOwnJID,
+		LoggedIn:   true,
+		OnWhatsApp: map[string]types.JID{member.User: member},
+		Groups: map[types.JID]*types.GroupInfo{
+			joined: {
+				JID:          joined,
+				GroupName:    types.GroupName{Name: "Joined group"},
+				Participants: []types.GroupParticipant{{JID: testOwnJID, IsAdmin: true}},
+			},
+		},
+		Requests: map[types.JID][]types.GroupParticipantRequest{
+			joined: {{JID: requester, RequestedAt: time.Now()}},
+		},
+	}
+	ws := newTestService(t, fake)
+	sc := connectTestSession(t, ws, fake)
+
+	created, err := ws.CreateGroupAndInvite(testUserID, GroupCreateInvite{
+		SessionID:    sc.SessionID,
+		Name:         "Test group",
+		Participants: []string{member.User},
+	})
+	if err != nil {
+		t.Fatalf("CreateGroupAndInvite: %v", err)
+	}
+	groupJID, err := types.ParseJID(created.GroupJID)
+	if err != nil {
+		t.Fatalf("created group JID %q: %v", created.GroupJID, err)
+	}
+	group := fake.Groups[groupJID]
+	if group == nil || group.Name != "Test group" {
+		t.Fatalf("group %s wasn't created in WhatsApp", groupJID)
+	}
+	if len(group.Participants) != 2 {
+		t.Errorf("group has %d participants, want the owner and %s", len(group.Participants), member)
+	}
+
+	name, announce := "Renamed", true
+	applied, err := ws.UpdateGroupSettings(sc.SessionID, testUserID, created.GroupJID, GroupSettingsUpdate{
+		Name:     &name,
+		Announce: &announce,
+	})
+	if err != nil {
+		t.Fatalf("UpdateGroupSettings: %v", err)
+	}
+	if applied["name"] != name || applied["announce"] != true {
+		t.Errorf("applied settings = %v", applied)
+	}
+	if group.Name != name || !group.IsAnnounce {
+		t.Errorf("group name %q announce %v after update, want %q true", group.Name, group.IsAnnounce, name)
+	}
+
+	requests, err := ws.GetGroupJoinRequests(sc.SessionID, testUserID, joined.String())
+	if err != nil {
+		t.Fatalf("GetGroupJoinRequests: %v", err)
+	}
+	if len(requests) != 1 || requests[0].JID != requester.String() {
+		t.Fatalf("join requests = %+v, want %s", requests, requester)
+	}
+	if _, err := ws.UpdateGroupJoinRequests(sc.SessionID, testUserID, joined.String(), []string{requester.String()}, true); err != nil {
+		t.Fatalf("UpdateGroupJoinRequests: %v", err)
+	}
+	if pending := fake.Requests[joined]; len(pending) != 0 {
+		t.Errorf("%d join requests left after approving", len(pending))
+	}
+	if participants := fake.Groups[joined].Participants; len(participants) != 2 || participants[1].JID != requester {
+		t.Errorf("approved requester isn't a member: %+v", participants)
+	}
+
+	if _, err := ws.GetGroupJoinRequests(sc.SessionID, testUserID, "120363999999999999@g.us"); err == nil {
+		t.Error("GetGroupJoinRequests of an unknown group returned no error")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= SESSION STATS =============
+// Usage of a session over the last days, read from what is already stored:
+// outgoing messages with their first delivery and read receipts (sent,
+// delivered, read, type and delivery latency) and the daily send counters of
+// the safety engine (failed). In groups, delivered and read mean the first
+// participant's receipt. Days are UTC.
+
+const sessionStatsMaxDays = 90
+
+// mediaMessageTypes are the message types counted as media
+var mediaMessageTypes = map[string]bool{"image": true, "video": true, "audio": true, "document": true}
+
+// SessionDayStatsEntry is one day of the session stats
+type SessionDayStatsEntry struct {
+	Day string `json:"day"`
+	SessionDayStats
+}
+
+// SessionStats is the message usage of a session
+type SessionStats struct {
+	SessionID          string                 `json:"session_id"`
+	Since              string                 `json:"since"`
+	Sent               int                    `json:"sent"`
+	Delivered          int                    `json:"delivered"`
+	Read               int                    `json:"read"`
+	Failed             int                    `json:"failed"`
+	AvgDeliverySeconds *float64               `json:"avg_delivery_seconds"`
+	Text               int                    `json:"text"`
+	Media              int                    `json:"media"`
+	Other              int                    `json:"other"`
+	ByType             map[string]int         `json:"by_type"`
+	ByDay              []SessionDayStatsEntry `json:"by_day"`
+}
+
+// GetSessionStats returns the sent, delivered, read and failed messages of a
+// session per day for the last days, with the type breakdown and average
+// delivery latency
+func (ws *WhatsAppService) GetSessionStats(sessionID string, userID, days int) (*SessionStats, error) {
+	if days < 1 || days > sessionStatsMaxDays {
+		return nil, fmt.Errorf("days must be between 1 and %d", sessionStatsMaxDays)
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -(days - 1))
+	sinceDay := since.Format(safetyDayLayout)
+
+	dayStats, err := ws.db.GetSessionDayStats(sessionID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message stats: %w", err)
+	}
+	counters, err := ws.db.GetSafetyCounters(sessionID, sinceDay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load send counters: %w", err)
+	}
+	byType, err := ws.db.GetSessionTypeCounts(sessionID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message stats: %w", err)
+	}
+
+	stats := &SessionStats{SessionID: sessionID, Since: sinceDay, ByType: byType}
+
+	// Merge the message days with the failure counters
+	byDay := make(map[string]*SessionDayStatsEntry)
+	for _, day := range dayStats {
+		entry := &SessionDayStatsEntry{Day: day.Day.Format(safetyDayLayout), SessionDayStats: day}
+		byDay[entry.Day] = entry
+	}
+	for _, counter := range counters {
+		if counter.Failed == 0 {
+			continue
+		}
+		entry, ok := byDay[counter.Day]
+		if !ok {
+			entry = &SessionDayStatsEntry{Day: counter.Day}
+			byDay[counter.Day] = entry
+		}
+		entry.Failed = counter.Failed
+	}
+
+	var latencySum float64
+	var latencyCount int
+	for day := since; !day.After(time.Now().UTC()); day = day.AddDate(0, 0, 1) {
+		entry, ok := byDay[day.Format(safetyDayLayout)]
+		if !ok {
+			continue
+		}
+		stats.ByDay = append(stats.ByDay, *entry)
+		stats.Sent += entry.Sent
+		stats.Delivered += entry.Delivered
+		stats.Read += entry.Read
+		stats.Failed += entry.Failed
+		if entry.AvgDeliverySeconds != nil {
+			latencySum += *entry.AvgDeliverySeconds * float64(entry.Delivered)
+			latencyCount += entry.Delivered
+		}
+	}
+	if latencyCount > 0 {
+		avg := latencySum / float64(latencyCount)
+		stats.AvgDeliverySeconds = &avg
+	}
+	if stats.ByDay == nil {
+		stats.ByDay = []SessionDayStatsEntry{}
+	}
+
+	for messageType, count := range byType {
+		switch {
+		case messageType == "text":
+			stats.Text += count
+		case mediaMessageTypes[messageType]:
+			stats.Media += count
+		default:
+			stats.Other += count
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// ============= SESSION WORKER =============
+// whatsmeow runs the event handlers of a client one at a time on its event
+// loop. Work that writes to the database or calls WhatsApp (messages,
+// receipts, history sync, contact flushes, the follow-ups of a connect) runs
+// on the session's worker instead: one goroutine per session draining a
+// bounded queue (SESSION_QUEUE_SIZE), so a session's writes are serialized
+// and a burst of messages can't pile up goroutines. Events are queued with
+// submit, which blocks while the queue is full, pushing back on whatsmeow
+// rather than losing events; best-effort work uses enqueue, which drops it
+// instead. Tasks must not submit to their own session's queue (a full queue
+// would wait on itself); they run follow-up work inline. The worker stops
+// with the session (stopChan) and tasks of a replaced or removed client are
+// skipped.
+
+// sessionTask is a unit of work on a session's worker
+type sessionTask struct {
+	name string
+	fn   func()
+}
+
+// sessionWorker is the queue of a session and its counters
+type sessionWorker struct {
+	queue     chan sessionTask
+	processed atomic.Int64
+	dropped   atomic.Int64
+	panics    atomic.Int64
+}
+
+// SessionQueueHealth is the state of a session's worker queue
+type SessionQueueHealth struct {
+	Depth     int   `json:"depth"`
+	Capacity  int   `json:"capacity"`
+	Processed int64 `json:"processed"`
+	Dropped   int64 `json:"dropped"`
+	Panics    int64 `json:"panics"`
+}
+
+// startSessionWorker creates the queue of a new client and starts its worker
+func (ws *WhatsAppService) startSessionWorker(sc *SessionClient) {
+	sc.worker.queue = make(chan sessionTask, ws.cfg.SessionQueueSize)
+	go ws.runSessionWorker(sc)
+}
+
+func (ws *WhatsAppService) runSessionWorker(sc *SessionClient) {
+	for {
+		select {
+		case <-sc.stopChan:
+			if n := len(sc.worker.queue); n > 0 {
+				log.Printf("ℹ️  Session %s stopped with %d queued task(s)", sc.SessionID, n)
+			}
+			return
+		case task := <-sc.worker.queue:
+			if ws.sessions.Current(sc) {
+				ws.runSessionTask(sc, task)
+			}
+		}
+	}
+}
+
+// runSessionTask runs a task, keeping a panic from stopping the worker
+func (ws *WhatsAppService) runSessionTask(sc *SessionClient, task sessionTask) {
+	defer func() {
+		if r := recover(); r != nil {
+			sc.worker.panics.Add(1)
+			log.Printf("❌ Session %s: %s task panicked: %v\n%s", sc.SessionID, task.name, r, debug.Stack())
+		}
+	}()
+	task.fn()
+	sc.worker.processed.Add(1)
+}
+
+// submit queues a task on the session's worker, waiting while the queue is
+// full; it returns false when the session stopped first
+func (sc *SessionClient) submit(name string, fn func()) bool {
+	select {
+	case sc.worker.queue <- sessionTask{name: name, fn: fn}:
+		return true
+	default:
+	}
+
+	log.Printf("⚠️  Session %s: worker queue full, %s waits", sc.SessionID, name)
+	select {
+	case sc.worker.queue <- sessionTask{name: name, fn: fn}:
+		return true
+	case <-sc.stopChan:
+		return false
+	}
+}
+
+// enqueue queues a best-effort task, dropping it when the queue is full
+func (sc *SessionClient) enqueue(name string, fn func()) bool {
+	select {
+	case sc.worker.queue <- sessionTask{name: name, fn: fn}:
+		return true
+	default:
+		sc.worker.dropped.Add(1)
+		log.Printf("⚠️  Session %s: worker queue full, dropped %s", sc.SessionID, name)
+		return false
+	}
+}
+
+// enqueueAfter queues a best-effort task after a delay, unless the session
+// stopped meanwhile
+func (sc *SessionClient) enqueueAfter(delay time.Duration, name string, fn func()) {
+	time.AfterFunc(delay, func() {
+		select {
+		case <-sc.stopChan:
+		default:
+			sc.enqueue(name, fn)
+		}
+	})
+}
+
+// queueHealth returns the state of the session's worker queue
+func (sc *SessionClient) queueHealth() SessionQueueHealth {
+	return SessionQueueHealth{
+		Depth:     len(sc.worker.queue),
+		Capacity:  cap(sc.worker.queue),
+		Processed: sc.worker.processed.Load(),
+		Dropped:   sc.worker.dropped.Load(),
+		Panics:    sc.worker.panics.Load(),
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"regexp"
+	"strings"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// ============= SPINTAX & PERSONALIZATION =============
+// Broadcast messages may contain spintax groups ({Hello|Hi|Hey}, which can be
+// nested) and {{variable}} placeholders filled from the contacts table. Every
+// recipient gets an independent pick and their own values, so bulk sends vary
+// naturally. {{name|there}} falls back to "there" when the value is empty.
+// Campaign recipients imported from CSV also expose their custom columns.
+// Braces that don't form a group (a lone "}") are sent as-is.
+
+// templateVariables lists the placeholders a message may use
+var templateVariables = map[string]bool{
+	"name":         true,
+	"first_name":   true,
+	"last_name":    true,
+	"phone":        true,
+	"country_code": true,
+}
+
+var templateVariablePattern = regexp.MustCompile(`^\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*(?:\|([^{}]*))?\}\}`)
+
+// templatePlaceholderPattern finds {{...}} placeholders without a fallback;
+// one that isn't a variable, like {{first-name}}, would render as plain text
+var templatePlaceholderPattern = regexp.MustCompile(`\{\{[^{}|]*\}\}`)
+
+// isTemplate reports whether a message needs per-recipient rendering
+func isTemplate(text string) bool {
+	return strings.Contains(text, "{")
+}
+
+// validateTemplate checks that groups are closed and variables are known.
+// fields are extra variable names available to every recipient.
+func validateTemplate(text string, fields ...string) error {
+	if !isTemplate(text) {
+		return nil
+	}
+	for _, placeholder := range templatePlaceholderPattern.FindAllString(text, -1) {
+		if !templateVariablePattern.MatchString(placeholder) {
+			return fmt.Errorf("invalid message template: malformed placeholder %s", placeholder)
+		}
+	}
+	vars := make(map[string]string, len(fields))
+	for _, field := range fields {
+		vars[field] = ""
+	}
+	_, err := renderTemplate(text, vars, rand.New(rand.NewSource(0)))
+	if err != nil {
+		return fmt.Errorf("invalid message template: %w", err)
+	}
+	return nil
+}
+
+// renderTemplate picks one alternative per spintax group and fills variables.
+// Besides the built-in variables, any key present in vars may be used.
+func renderTemplate(text string, vars map[string]string, rng *rand.Rand) (string, error) {
+	p := &templateParser{src: text, vars: vars, rng: rng}
+	return p.parse(false)
+}
+
+type templateParser struct {
+	src  string
+	pos  int
+	vars map[string]string
+	rng  *rand.Rand
+
+	format func(string) string // applied to substituted values, if set
+	empty  []string            // variables that rendered empty
+}
+
+// parse reads until the end of the input or, inside a group, until the next
+// "|" or "}" which is left for the caller
+func (p *templateParser) parse(inGroup bool) (string, error) {
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		rest := p.src[p.pos:]
+		if m := templateVariablePattern.FindStringSubmatch(rest); m != nil {
+			value, err := p.variable(strings.ToLower(m[1]), m[2])
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(value)
+			p.pos += len(m[0])
+			continue
+		}
+
+		switch c := rest[0]; {
+		case c == '{':
+			p.pos++
+			value, err := p.group()
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(value)
+		case inGroup && (c == '|' || c == '}'):
+			return sb.String(), nil
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	if inGroup {
+		return "", fmt.Errorf("unclosed {")
+	}
+	return sb.String(), nil
+}
+
+// group parses the alternatives of a group whose "{" was consumed. All
+// alternatives are parsed so errors surface whichever one is picked.
+func (p *templateParser) group() (string, error) {
+	var options []string
+	for {
+		option, err := p.parse(true)
+		if err != nil {
+			return "", err
+		}
+		options = append(options, option)
+
+		closing := p.src[p.pos] == '}'
+		p.pos++
+		if closing {
+			break
+		}
+	}
+	return options[p.rng.Intn(len(options))], nil
+}
+
+func (p *templateParser) variable(name, fallback string) (string, error) {
+	if _, custom := p.vars[name]; !custom && !templateVariables[name] {
+		return "", fmt.Errorf("unknown variable {{%s}}", name)
+	}
+	value := p.vars[name]
+	if value == "" {
+		value = strings.TrimSpace(fallback)
+	}
+	if value == "" {
+		p.empty = append(p.empty, name)
+		return "", nil
+	}
+	if p.format != nil {
+		value = p.format(value)
+	}
+	return value, nil
+}
+
+// contactVariables returns the template values for a recipient. contact may
+// be nil when the number isn't in the contacts table.
+func contactVariables(contact *WhatsAppContact, phone string) map[string]string {
+	vars := map[string]string{}
+	if phone != "" {
+		vars["phone"] = "+" + phone
+	}
+	if contact == nil {
+		return vars
+	}
+
+	vars["name"] = contact.FullName
+	vars["first_name"] = contact.FirstName
+	vars["last_name"] = contact.LastName
+	vars["country_code"] = contact.CountryCode
+	if vars["name"] == "" {
+		vars["name"] = strings.TrimSpace(contact.FirstName + " " + contact.LastName)
+	}
+	return vars
+}
+
+// recipientVariables loads the contact behind a recipient JID, falling back to
+// the phone number JID for LID recipients
+func (ws *WhatsAppService) recipientVariables(sc *SessionClient, recipient types.JID, contacts map[string]*WhatsAppContact) map[string]string {
+	phone := ws.phoneForJID(sc, recipient)
+
+	contact := contacts[recipient.ToNonAD().String()]
+	if contact == nil && phone != "" {
+		contact = contacts[types.NewJID(phone, types.DefaultUserServer).String()]
+	}
+	return contactVariables(contact, phone)
+}
+
+// templateContacts loads the contacts of the given recipients keyed by JID
+func (ws *WhatsAppService) templateContacts(sc *SessionClient, recipients []types.JID) map[string]*WhatsAppContact {
+	jids := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		jids = append(jids, recipient.ToNonAD().String())
+		if recipient.Server == types.HiddenUserServer {
+			if phone := ws.phoneForJID(sc, recipient); phone != "" {
+				jids = append(jids, types.NewJID(phone, types.DefaultUserServer).String())
+			}
+		}
+	}
+
+	contacts, err := ws.db.GetContactsByJIDs(sc.UserID, jids)
+	if err != nil {
+		log.Printf("⚠️  Failed to load contacts for personalization: %v", err)
+		return nil
+	}
+	byJID := make(map[string]*WhatsAppContact, len(contacts))
+	for i := range contacts {
+		byJID[contacts[i].JID] = &contacts[i]
+	}
+	return byJID
+}
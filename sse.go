@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============= SERVER-SENT EVENTS =============
+// Clients that can't hold a WebSocket (browsers' EventSource, proxies that
+// only pass plain HTTP) can follow a session over Server-Sent Events. SSE
+// streams are regular streamClients, so they get the same fan-out, topics,
+// sequence numbers and heartbeats as WebSocket connections. Every frame is
+//
+//	event: <message type>
+//	data: <the same JSON envelope a WebSocket client receives>
+//
+// Replayed events and heartbeats also carry an id: line with the event
+// cursor. When a client reconnects with Last-Event-ID (sent automatically by
+// EventSource, or ?last_event_id=), the stored events after it are replayed
+// before live events resume. Heartbeat cursors can run ahead of events still
+// being delivered, so a resumed stream may repeat an event; clients should
+// de-duplicate on event_id.
+
+// clearWriteDeadline lifts the server's WriteTimeout for a streaming
+// response, which would otherwise cut the stream off after a few seconds
+func clearWriteDeadline(w http.ResponseWriter) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("⚠️  Failed to clear the write deadline of a stream: %v", err)
+	}
+}
+
+// newSSEClient creates a client writing event-stream frames to w
+func newSSEClient(w http.ResponseWriter, flusher http.Flusher, sessionID string, userID int, topics []string) *streamClient {
+	return newStreamClient(sessionID, userID, topics, func(message WebSocketMessage) error {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		frame := fmt.Sprintf("event: %s\ndata: %s\n", message.Type, data)
+		if id, ok := sseEventID(message); ok {
+			frame += fmt.Sprintf("id: %d\n", id)
+		}
+		if _, err := fmt.Fprint(w, frame+"\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+}
+
+// sseEventID is the resume cursor of a frame: the event ID of replayed
+// events and the cursor of heartbeats and replay_done
+func sseEventID(message WebSocketMessage) (int64, bool) {
+	var id interface{}
+	switch message.Type {
+	case "replay":
+		id = message.Data["event_id"]
+	case "heartbeat", "replay_done":
+		id = message.Data["cursor"]
+	default:
+		return 0, false
+	}
+	cursor, ok := id.(int64)
+	return cursor, ok && cursor > 0
+}
+
+// writeSSE writes one event-stream frame
+func writeSSE(w http.ResponseWriter, flusher http.Flusher, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
+
+// sseLastEventID reads the resume point of a reconnecting client
+func sseLastEventID(r *http.Request) (int64, bool, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, false, fmt.Errorf("invalid Last-Event-ID")
+	}
+	return id, true, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClearWriteDeadline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/stream", func(c *gin.Context) {
+		clearWriteDeadline(c.Writer)
+		c.Header("Content-Type", "text/event-stream")
+		c.Status(http.StatusOK)
+		// Write past the server's WriteTimeout, as a stream's heartbeats do
+		time.Sleep(300 * time.Millisecond)
+		writeSSE(c.Writer, c.Writer, "heartbeat", gin.H{"n": 1})
+	})
+
+	server := httptest.NewUnstartedServer(router)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream")
+	if err != nil {
+		t.Fatalf("stream request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("stream was cut off: %v", err)
+	}
+	if !strings.Contains(string(body), "event: heartbeat") {
+		t.Errorf("stream body = %q, want the heartbeat written after the write timeout", body)
+	}
+}
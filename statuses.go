@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= STATUS POSTS =============
+// Statuses (stories) are posted by the status worker at their scheduled_at,
+// right away when none is given. Media is kept in media storage until the
+// post goes out and is uploaded to WhatsApp only then, since media handles
+// expire. A daily or weekly post schedules its next occurrence once it is
+// posted, until repeat_until. WhatsApp hides statuses after 24 hours; the
+// worker then marks the post expired and deletes its media once no scheduled
+// or live post of the series uses it. Finished posts are kept for
+// statusPostRetention.
+
+const (
+	statusPollInterval  = 30 * time.Second
+	statusBatchSize     = 20
+	statusLifetime      = 24 * time.Hour
+	statusPostRetention = 30 * 24 * time.Hour
+)
+
+// statusRepeatIntervals are the supported recurrences of a status post
+var statusRepeatIntervals = map[string]time.Duration{
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// StatusPostRequest posts or schedules a status
+type StatusPostRequest struct {
+	Text        string     `json:"text"` // text status, or caption of media
+	MediaURL    string     `json:"media_url"`
+	MediaBase64 string     `json:"media_base64"`
+	Mimetype    string     `json:"mimetype"`
+	ScheduledAt *time.Time `json:"scheduled_at"` // RFC 3339, empty posts now
+	Repeat      string     `json:"repeat"`       // daily, weekly
+	RepeatUntil *time.Time `json:"repeat_until"`
+}
+
+// CreateStatusPost validates a status, stores its media and queues it
+func (ws *WhatsAppService) CreateStatusPost(sessionID string, userID int, req StatusPostRequest) (*WhatsAppStatusPost, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	hasMedia := req.MediaURL != "" || req.MediaBase64 != ""
+	if strings.TrimSpace(req.Text) == "" && !hasMedia {
+		return nil, fmt.Errorf("text, media_url or media_base64 is required")
+	}
+	if req.Repeat != "" {
+		if _, ok := statusRepeatIntervals[req.Repeat]; !ok {
+			return nil, fmt.Errorf("invalid repeat %q, expected daily or weekly", req.Repeat)
+		}
+	} else if req.RepeatUntil != nil {
+		return nil, fmt.Errorf("repeat_until requires repeat")
+	}
+
+	now := time.Now()
+	scheduledAt := now
+	if req.ScheduledAt != nil && req.ScheduledAt.After(now) {
+		scheduledAt = *req.ScheduledAt
+	}
+	if req.RepeatUntil != nil && req.RepeatUntil.Before(scheduledAt) {
+		return nil, fmt.Errorf("repeat_until is before scheduled_at")
+	}
+
+	post := &WhatsAppStatusPost{
+		UserID:      userID,
+		SessionID:   sessionID,
+		Text:        req.Text,
+		Status:      StatusPostScheduled,
+		ScheduledAt: scheduledAt,
+		Repeat:      req.Repeat,
+		RepeatUntil: req.RepeatUntil,
+	}
+
+	if hasMedia {
+		data, mimetype, err := ws.loadMediaPayload(SendRequest{
+			MediaURL:    req.MediaURL,
+			MediaBase64: req.MediaBase64,
+			Mimetype:    req.Mimetype,
+		})
+		if err != nil {
+			return nil, err
+		}
+		mediaType := detectMediaType(mimetype, "", data)
+		if mediaType != "image" && mediaType != "video" {
+			return nil, fmt.Errorf("status media must be an image or a video, got %s", mediaType)
+		}
+		if maxSize := ws.cfg.maxMediaSize(mediaType); int64(len(data)) > maxSize {
+			return nil, fmt.Errorf("%w: %d bytes (max %d bytes)", ErrMediaTooLarge, len(data), maxSize)
+		}
+		head := data
+		if len(head) > 512 {
+			head = head[:512]
+		}
+		mimetype = sniffMimetype(mediaType, mimetype, "", head)
+
+		key := fmt.Sprintf("%s%s%s", statusMediaPrefix(sessionID), uuid.New().String(), mediaExtension(mimetype))
+		if err := ws.media.Put(context.Background(), key, bytes.NewReader(data), int64(len(data)), mimetype); err != nil {
+			return nil, fmt.Errorf("failed to store status media: %w", err)
+		}
+		post.MediaType = mediaType
+		post.Mimetype = mimetype
+		post.MediaKey = key
+	}
+
+	if err := ws.db.CreateStatusPost(post); err != nil {
+		if post.MediaKey != "" {
+			ws.media.Delete(context.Background(), post.MediaKey)
+		}
+		return nil, fmt.Errorf("failed to create status post: %w", err)
+	}
+
+	log.Printf("📅 Status post %d for session %s scheduled at %s", post.ID, sessionID, post.ScheduledAt.Format(time.RFC3339))
+
+	// Posts due now don't wait for the next poll
+	if !post.ScheduledAt.After(now) {
+		go ws.runStatusPost(*post)
+	}
+	return post, nil
+}
+
+// GetStatusPost returns a status post of a session
+func (ws *WhatsAppService) GetStatusPost(sessionID string, userID int, postID int64) (*WhatsAppStatusPost, error) {
+	post, err := ws.db.GetStatusPost(postID, sessionID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("status post not found")
+		}
+		return nil, fmt.Errorf("failed to load status post: %w", err)
+	}
+	return post, nil
+}
+
+// CancelStatusPost cancels a scheduled status post; for a recurring post
+// this ends the series
+func (ws *WhatsAppService) CancelStatusPost(sessionID string, userID int, postID int64) (*WhatsAppStatusPost, error) {
+	post, err := ws.GetStatusPost(sessionID, userID, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := ws.db.TransitionStatusPost(post.ID, StatusPostScheduled, map[string]interface{}{"status": StatusPostCancelled})
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel status post: %w", err)
+	}
+	if !ok {
+		current, err := ws.GetStatusPost(sessionID, userID, postID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("status post is %s, only scheduled posts can be cancelled", current.Status)
+	}
+	post.Status = StatusPostCancelled
+	ws.releaseStatusMedia(post)
+
+	log.Printf("🛑 Status post %d cancelled", post.ID)
+	return post, nil
+}
+
+// StartStatusWorker posts due statuses and cleans up expired ones until the
+// context is cancelled
+func (ws *WhatsAppService) StartStatusWorker(ctx context.Context) {
+	if reset, err := ws.db.ResetInterruptedStatusPosts(); err != nil {
+		log.Printf("❌ Failed to reset interrupted status posts: %v", err)
+	} else if reset > 0 {
+		log.Printf("⚠️  Rescheduled %d status posts interrupted by a restart", reset)
+	}
+
+	go func() {
+		ticker := time.NewTicker(statusPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				ws.processStatusPosts(ctx, now)
+				ws.expireStatusPosts(now)
+			}
+		}
+	}()
+	log.Println("✅ Status worker started")
+}
+
+func (ws *WhatsAppService) processStatusPosts(ctx context.Context, now time.Time) {
+	posts, err := ws.db.GetDueStatusPosts(now, statusBatchSize)
+	if err != nil {
+		log.Printf("❌ Failed to load due status posts: %v", err)
+		return
+	}
+
+	for _, post := range posts {
+		if ctx.Err() != nil {
+			return
+		}
+		ws.runStatusPost(post)
+	}
+}
+
+// runStatusPost posts a scheduled status. Posts of an offline session stay
+// scheduled and are retried on the next poll.
+func (ws *WhatsAppService) runStatusPost(post WhatsAppStatusPost) {
+	sc, err := ws.GetSessionClient(post.SessionID)
+	if err != nil || !sc.Client.IsConnected() {
+		return
+	}
+
+	// Claim the post so the poll and an immediate post don't both send it
+	claimed, err := ws.db.TransitionStatusPost(post.ID, StatusPostScheduled, map[string]interface{}{"status": StatusPostPosting})
+	if err != nil {
+		log.Printf("❌ Failed to claim status post %d: %v", post.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	messageID, err := ws.sendStatus(sc, &post)
+	var limitErr *SendLimitError
+	if errors.As(err, &limitErr) {
+		ws.db.TransitionStatusPost(post.ID, StatusPostPosting, map[string]interface{}{
+			"status":       StatusPostScheduled,
+			"scheduled_at": limitErr.RetryAt,
+			"error":        truncate(err.Error(), 500),
+		})
+		return
+	}
+
+	postedAt := time.Now()
+	if err != nil {
+		log.Printf("❌ Status post %d failed: %v", post.ID, err)
+		ws.db.TransitionStatusPost(post.ID, StatusPostPosting, map[string]interface{}{
+			"status": StatusPostFailed,
+			"error":  truncate(err.Error(), 500),
+		})
+		post.Status = StatusPostFailed
+	} else {
+		expiresAt := postedAt.Add(statusLifetime)
+		ws.db.TransitionStatusPost(post.ID, StatusPostPosting, map[string]interface{}{
+			"status":     StatusPostPosted,
+			"message_id": messageID,
+			"posted_at":  postedAt,
+			"expires_at": expiresAt,
+			"error":      "",
+		})
+		post.Status = StatusPostPosted
+		post.MessageID = messageID
+		post.PostedAt = &postedAt
+		post.ExpiresAt = &expiresAt
+		log.Printf("✅ Status post %d posted for session %s (ID: %s)", post.ID, post.SessionID, messageID)
+	}
+
+	// The next occurrence is scheduled before the media is released so the
+	// series keeps it
+	ws.scheduleNextStatusPost(&post, postedAt)
+	if post.Status == StatusPostFailed {
+		ws.releaseStatusMedia(&post)
+	}
+
+	data := map[string]interface{}{
+		"status_post_id": post.ID,
+		"message_id":     post.MessageID,
+		"expires_at":     post.ExpiresAt,
+	}
+	eventType := "status_posted"
+	if err != nil {
+		eventType = "status_failed"
+		data["error"] = err.Error()
+	}
+	ws.emitStatusEvent(&post, eventType, data)
+}
+
+// sendStatus uploads the media of a status post and sends it to the status
+// broadcast list
+func (ws *WhatsAppService) sendStatus(sc *SessionClient, post *WhatsAppStatusPost) (string, error) {
+	var msg *waE2E.Message
+	if post.MediaKey == "" {
+		msg = &waE2E.Message{
+			ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+				Text: proto.String(post.Text),
+			},
+		}
+	} else {
+		reader, err := ws.media.Open(context.Background(), post.MediaKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to open status media: %w", err)
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to read status media: %w", err)
+		}
+
+		media, err := ws.uploadMediaBytes(sc, post.MediaType, data, post.Mimetype, "")
+		if err != nil {
+			return "", err
+		}
+		msg = buildMediaMessage(media, post.Text, false)
+	}
+
+	resp, err := ws.sendMessage(sc, types.StatusBroadcastJID, msg)
+	if err != nil {
+		return "", fmt.Errorf("failed to post status: %w", err)
+	}
+	return resp.ID, nil
+}
+
+// scheduleNextStatusPost queues the next occurrence of a recurring post
+func (ws *WhatsAppService) scheduleNextStatusPost(post *WhatsAppStatusPost, now time.Time) {
+	interval, ok := statusRepeatIntervals[post.Repeat]
+	if !ok {
+		return
+	}
+
+	// Occurrences missed while the session was offline are skipped
+	next := post.ScheduledAt.Add(interval)
+	for !next.After(now) {
+		next = next.Add(interval)
+	}
+	if post.RepeatUntil != nil && next.After(*post.RepeatUntil) {
+		return
+	}
+
+	seriesID := post.ID
+	if post.SeriesID != nil {
+		seriesID = *post.SeriesID
+	}
+	occurrence := &WhatsAppStatusPost{
+		UserID:      post.UserID,
+		SessionID:   post.SessionID,
+		Text:        post.Text,
+		MediaType:   post.MediaType,
+		Mimetype:    post.Mimetype,
+		MediaKey:    post.MediaKey,
+		Status:      StatusPostScheduled,
+		ScheduledAt: next,
+		Repeat:      post.Repeat,
+		RepeatUntil: post.RepeatUntil,
+		SeriesID:    &seriesID,
+	}
+	if err := ws.db.CreateStatusPost(occurrence); err != nil {
+		log.Printf("❌ Failed to schedule next occurrence of status post %d: %v", post.ID, err)
+		return
+	}
+	log.Printf("📅 Status post %d repeats as %d at %s", post.ID, occurrence.ID, next.Format(time.RFC3339))
+}
+
+// expireStatusPosts marks statuses past their 24 hours expired, deletes
+// their media and purges finished posts past the retention
+func (ws *WhatsAppService) expireStatusPosts(now time.Time) {
+	posts, err := ws.db.GetExpiredStatusPosts(now)
+	if err != nil {
+		log.Printf("❌ Failed to load expired status posts: %v", err)
+		return
+	}
+
+	for i := range posts {
+		post := &posts[i]
+		ok, err := ws.db.TransitionStatusPost(post.ID, StatusPostPosted, map[string]interface{}{"status": StatusPostExpired})
+		if err != nil {
+			log.Printf("⚠️  Failed to expire status post %d: %v", post.ID, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		post.Status = StatusPostExpired
+		ws.releaseStatusMedia(post)
+		ws.emitStatusEvent(post, "status_expired", map[string]interface{}{
+			"status_post_id": post.ID,
+			"message_id":     post.MessageID,
+		})
+	}
+
+	if purged, err := ws.db.DeleteOldStatusPosts(now.Add(-statusPostRetention)); err != nil {
+		log.Printf("⚠️  Failed to purge old status posts: %v", err)
+	} else if purged > 0 {
+		log.Printf("🧹 Purged %d finished status posts", purged)
+	}
+}
+
+// releaseStatusMedia deletes the stored media of a finished status post
+// unless a scheduled or live post of the series still uses it
+func (ws *WhatsAppService) releaseStatusMedia(post *WhatsAppStatusPost) {
+	if post.MediaKey == "" {
+		return
+	}
+	inUse, err := ws.db.StatusMediaInUse(post.MediaKey)
+	if err != nil {
+		log.Printf("⚠️  Failed to check status media %s: %v", post.MediaKey, err)
+		return
+	}
+	if inUse {
+		return
+	}
+	if err := ws.media.Delete(context.Background(), post.MediaKey); err != nil {
+		log.Printf("⚠️  Failed to delete status media %s: %v", post.MediaKey, err)
+	}
+}
+
+// emitStatusEvent stores a status post event and pushes it to clients
+func (ws *WhatsAppService) emitStatusEvent(post *WhatsAppStatusPost, eventType string, data map[string]interface{}) {
+	sessionUUID, _ := uuid.Parse(post.SessionID)
+	ws.db.CreateEvent(sessionUUID, post.UserID, eventType, data)
+	ws.wsManager.SendToSession(post.SessionID, WebSocketMessage{
+		Type: eventType,
+		Data: data,
+	})
+}
+
+// statusMediaPrefix is the media storage prefix of a session's status media
+func statusMediaPrefix(sessionID string) string {
+	return "statuses/" + sessionID + "/"
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"go.mau.fi/whatsmeow/store/sqlstore"
+
+	"whatsapp-api/internal/storecrypt"
+)
+
+// ============= WHATSAPP STORE ENCRYPTION =============
+// The whatsmeow store (./data/whatsapp_store.db) holds each device's identity,
+// noise and pre-keys plus its Signal sessions. With STORE_ENCRYPTION_KEYS (or
+// STORE_ENCRYPTION_KEYS_FILE) set, those columns are encrypted at rest by
+// wrapping the SQLite driver (internal/storecrypt). An existing plaintext
+// store is converted with the store-encrypt command, which is also how keys
+// are rotated; store-decrypt turns encryption off again. Both must run while
+// the API is stopped.
+
+const (
+	whatsAppStoreDir  = "./data"
+	whatsAppStoreFile = "whatsapp_store.db"
+)
+
+func whatsAppStoreDSN() string {
+	return filepath.Join(whatsAppStoreDir, whatsAppStoreFile) + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+}
+
+// openWhatsAppStore opens and upgrades the whatsmeow store, encrypting it when
+// keys are configured
+func openWhatsAppStore(ctx context.Context, cfg *Config) (*sqlstore.Container, error) {
+	if err := os.MkdirAll(whatsAppStoreDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	log.Printf("   Store location: %s", filepath.Join(whatsAppStoreDir, whatsAppStoreFile))
+
+	keyring, err := storecrypt.ParseKeyring(cfg.StoreEncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := whatsAppStoreDSN()
+	raw, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WhatsApp store: %w", err)
+	}
+
+	cipher, err := storecrypt.Load(ctx, raw, keyring)
+	if errors.Is(err, storecrypt.ErrNotMigrated) {
+		raw.Close()
+		return nil, fmt.Errorf("%w: stop the API, back up %s and run `whatsapp-api store-encrypt`", err, whatsAppStoreFile)
+	} else if err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("failed to load WhatsApp store encryption: %w", err)
+	}
+
+	db := raw
+	if cipher != nil {
+		db = sql.OpenDB(storecrypt.NewConnector(raw.Driver(), dsn, cipher))
+		raw.Close()
+		log.Printf("   🔐 Store keys encrypted (key %s)", cipher.StoreKeyID())
+		if cipher.RotationPending() {
+			log.Printf("   ⚠️  Store is still encrypted with key %s; run `whatsapp-api store-encrypt` to rotate to %s", cipher.StoreKeyID(), keyring.Primary())
+		}
+	}
+
+	container := sqlstore.NewWithDB(db, "sqlite", nil)
+	if err := container.Upgrade(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create WhatsApp store: %w", err)
+	}
+	return container, nil
+}
+
+// runStoreCommand runs the store-encrypt / store-decrypt admin commands
+func runStoreCommand(cfg *Config, command string) error {
+	keyring, err := storecrypt.ParseKeyring(cfg.StoreEncryptionKeys)
+	if err != nil {
+		return err
+	}
+	if keyring == nil {
+		return fmt.Errorf("STORE_ENCRYPTION_KEYS or STORE_ENCRYPTION_KEYS_FILE must be set")
+	}
+
+	path := filepath.Join(whatsAppStoreDir, whatsAppStoreFile)
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("WhatsApp store not found: %w", err)
+	}
+	db, err := sql.Open("sqlite", whatsAppStoreDSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	decrypt := command == "store-decrypt"
+	rewritten, err := storecrypt.Migrate(context.Background(), db, keyring, decrypt)
+	if err != nil {
+		return err
+	}
+
+	if decrypt {
+		log.Printf("✅ Decrypted %d value(s); the store is plaintext again. Remove STORE_ENCRYPTION_KEYS before starting the API.", rewritten)
+	} else {
+		log.Printf("✅ Encrypted %d value(s) with key %s. Keys other than %s can now be removed.", rewritten, keyring.Primary(), keyring.Primary())
+	}
+	return nil
+}
+
+// runStoreVacuum runs `whatsapp-api store vacuum`: it rebuilds the WhatsApp
+// store to give the space of deleted sessions and messages back to the disk
+func runStoreVacuum() error {
+	path := filepath.Join(whatsAppStoreDir, whatsAppStoreFile)
+	before, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("WhatsApp store not found: %w", err)
+	}
+	db, err := sql.Open("sqlite", whatsAppStoreDSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum WhatsApp store: %w", err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	log.Printf("✅ Vacuumed %s: %d KB -> %d KB", whatsAppStoreFile, before.Size()/1024, after.Size()/1024)
+	return nil
+}
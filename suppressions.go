@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= SUPPRESSION LIST =============
+// Numbers on a user's suppression list are never messaged by that user's
+// sessions. Entries are added through the API or automatically when a contact
+// replies with an opt-out keyword. Single sends fail with ErrSuppressed;
+// broadcast deliveries and queued sends are marked "suppressed".
+
+// ErrSuppressed is returned when the recipient opted out of messages
+var ErrSuppressed = errors.New("recipient has opted out")
+
+// defaultOptOutKeywords are the replies treated as an opt-out request unless
+// OPT_OUT_KEYWORDS sets others: English and the common opt-out words of
+// Spanish, Portuguese, French, German, Italian, Dutch, Indonesian, Turkish,
+// Arabic and Hindi
+var defaultOptOutKeywords = []string{
+	"STOP", "STOPALL", "UNSUBSCRIBE", "CANCEL", "END", "QUIT",
+	"BAJA", "PARAR", "ALTO", "CANCELAR",
+	"PARE", "SAIR", "DESCADASTRAR",
+	"ARRET", "ARRÊT", "DESABONNER", "DÉSABONNER",
+	"STOPP", "ABMELDEN",
+	"BASTA", "DISISCRIVITI",
+	"AFMELDEN", "UITSCHRIJVEN",
+	"BERHENTI",
+	"DUR", "IPTAL", "İPTAL",
+	"توقف", "ايقاف", "إيقاف", "الغاء", "إلغاء",
+	"रोकें", "बंद",
+}
+
+// parseOptOutKeywords parses OPT_OUT_KEYWORDS, a comma separated list of
+// keywords; empty means the defaults
+func parseOptOutKeywords(value string) (map[string]bool, error) {
+	items := defaultOptOutKeywords
+	if strings.TrimSpace(value) != "" {
+		items = strings.Split(value, ",")
+	}
+	keywords := make(map[string]bool, len(items))
+	for _, item := range items {
+		if keyword := normalizeOptOutKeyword(item); keyword != "" {
+			keywords[keyword] = true
+		}
+	}
+	if len(keywords) == 0 {
+		return nil, fmt.Errorf("at least one keyword is required")
+	}
+	return keywords, nil
+}
+
+// normalizeOptOutKeyword uppercases a reply and strips surrounding spaces and
+// punctuation
+func normalizeOptOutKeyword(text string) string {
+	return strings.ToUpper(strings.Trim(strings.TrimSpace(text), ".!¡"))
+}
+
+// isStopKeyword reports whether a message is an opt-out request
+func (ws *WhatsAppService) isStopKeyword(text string) bool {
+	return ws.optOutKeywords[normalizeOptOutKeyword(text)]
+}
+
+// AddSuppressions adds phone numbers to the user's suppression list. Numbers
+// that were already on it are returned in existing, inputs that aren't valid
+// phone numbers in invalid.
+func (ws *WhatsAppService) AddSuppressions(userID int, phones []string, reason string) (added []WhatsAppSuppression, existing []string, invalid map[string]string, err error) {
+	if len(phones) == 0 {
+		return nil, nil, nil, fmt.Errorf("at least one phone number is required")
+	}
+	if reason == "" {
+		reason = "manual"
+	}
+
+	added = make([]WhatsAppSuppression, 0, len(phones))
+	existing = []string{}
+	invalid = make(map[string]string)
+	for _, input := range phones {
+		phone, err := wajid.NormalizePhone(input)
+		if err != nil {
+			invalid[input] = err.Error()
+			continue
+		}
+
+		suppression := WhatsAppSuppression{
+			UserID: userID,
+			Phone:  phone,
+			Reason: reason,
+		}
+		created, err := ws.db.AddSuppression(&suppression)
+		if err != nil {
+			return nil, nil, invalid, fmt.Errorf("failed to save suppression: %w", err)
+		}
+		if created {
+			added = append(added, suppression)
+		} else {
+			existing = append(existing, phone)
+		}
+	}
+
+	return added, existing, invalid, nil
+}
+
+// RemoveSuppression takes a phone number off the user's suppression list
+func (ws *WhatsAppService) RemoveSuppression(userID int, input string) error {
+	phone, err := wajid.NormalizePhone(input)
+	if err != nil {
+		return err
+	}
+
+	removed, err := ws.db.RemoveSuppression(userID, phone)
+	if err != nil {
+		return fmt.Errorf("failed to remove suppression: %w", err)
+	}
+	if removed == 0 {
+		return fmt.Errorf("suppression not found")
+	}
+	return nil
+}
+
+// GetSuppressions lists the user's suppressed numbers
+func (ws *WhatsAppService) GetSuppressions(userID int, q ListQuery) ([]WhatsAppSuppression, int64, error) {
+	suppressions, total, err := ws.db.GetSuppressions(userID, q)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load suppressions: %w", err)
+	}
+	return suppressions, total, nil
+}
+
+// checkSuppressed returns ErrSuppressed if the recipient is on the session
+// owner's suppression list. Groups and broadcast lists are never suppressed.
+func (ws *WhatsAppService) checkSuppressed(sc *SessionClient, recipient types.JID) error {
+	phone := ws.phoneForJID(sc, recipient)
+	if phone == "" {
+		return nil
+	}
+
+	suppressed, err := ws.db.IsSuppressed(sc.UserID, phone)
+	if err != nil {
+		return fmt.Errorf("failed to check suppression list: %w", err)
+	}
+	if suppressed {
+		return fmt.Errorf("%w: %s", ErrSuppressed, phone)
+	}
+	return nil
+}
+
+// phoneForJID returns the phone number behind a user JID, looking LIDs up in
+// the session's LID store. Returns "" for non-user JIDs or unknown LIDs.
+func (ws *WhatsAppService) phoneForJID(sc *SessionClient, jid types.JID) string {
+	switch jid.Server {
+	case types.DefaultUserServer:
+		return jid.User
+	case types.HiddenUserServer:
+		pn, err := sc.Device.LIDs.GetPNForLID(context.Background(), jid)
+		if err != nil || pn.IsEmpty() {
+			return ""
+		}
+		return pn.User
+	default:
+		return ""
+	}
+}
+
+// handleOptOut suppresses the sender of an incoming opt-out keyword
+func (ws *WhatsAppService) handleOptOut(sc *SessionClient, evt *events.Message, content string) {
+	if evt.Info.IsFromMe || evt.Info.IsGroup || !ws.isStopKeyword(content) {
+		return
+	}
+
+	phone := ws.phoneForJID(sc, evt.Info.Sender)
+	if phone == "" && !evt.Info.SenderAlt.IsEmpty() {
+		phone = ws.phoneForJID(sc, evt.Info.SenderAlt)
+	}
+	if phone == "" {
+		log.Printf("⚠️  Opt-out from %s ignored: phone number unknown", evt.Info.Sender.String())
+		return
+	}
+
+	suppression := WhatsAppSuppression{
+		UserID:    sc.UserID,
+		Phone:     phone,
+		Reason:    "stop_keyword",
+		SessionID: sc.SessionID,
+	}
+	if _, err := ws.db.AddSuppression(&suppression); err != nil {
+		log.Printf("❌ Failed to save opt-out from %s: %v", phone, err)
+		return
+	}
+
+	log.Printf("🚫 %s opted out of messages from user %d", phone, sc.UserID)
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "contact_opted_out", map[string]interface{}{
+		"phone_number": phone,
+		"message_id":   evt.Info.ID,
+	})
+
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "contact_opted_out",
+		Data: map[string]interface{}{
+			"phone_number": phone,
+			"keyword":      strings.TrimSpace(content),
+		},
+	})
+}
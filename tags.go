@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/types"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= CONTACT TAGS =============
+// Tags are free-form labels a user attaches to contacts, keyed by the
+// contact's JID so numbers that never synced into the contacts table (e.g. a
+// lead who just messaged) can be tagged too. Tags are lowercased. They are set
+// through the API or by auto-reply rules, and segments can filter on them.
+
+// normalizeTag lowercases a tag and checks its length
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", fmt.Errorf("tag must not be empty")
+	}
+	if len(tag) > 50 {
+		return "", fmt.Errorf("tag %q is longer than 50 characters", tag)
+	}
+	return tag, nil
+}
+
+// parseContactJID accepts a phone number or a user JID
+func parseContactJID(input string) (types.JID, error) {
+	jid, err := wajid.Parse(input)
+	if err != nil {
+		return types.JID{}, err
+	}
+	if !wajid.IsUser(jid) {
+		return types.JID{}, fmt.Errorf("%s is not a contact", jid.String())
+	}
+	return jid, nil
+}
+
+// AddContactTags tags a contact and returns all of its tags
+func (ws *WhatsAppService) AddContactTags(userID int, contact string, tags []string) ([]string, error) {
+	jid, err := parseContactJID(contact)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("at least one tag is required")
+	}
+
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, tag)
+	}
+
+	// Tags of a mapped LID go to its phone number contact
+	contactJID := ws.db.CanonicalContactJID(jid.String())
+	if err := ws.db.AddContactTags(userID, contactJID, normalized); err != nil {
+		return nil, fmt.Errorf("failed to save tags: %w", err)
+	}
+	return ws.GetContactTags(userID, contactJID)
+}
+
+// RemoveContactTag removes one tag from a contact
+func (ws *WhatsAppService) RemoveContactTag(userID int, contact, tag string) error {
+	jid, err := parseContactJID(contact)
+	if err != nil {
+		return err
+	}
+	tag, err = normalizeTag(tag)
+	if err != nil {
+		return err
+	}
+
+	removed, err := ws.db.RemoveContactTag(userID, ws.db.CanonicalContactJID(jid.String()), tag)
+	if err != nil {
+		return fmt.Errorf("failed to remove tag: %w", err)
+	}
+	if removed == 0 {
+		return fmt.Errorf("tag not found")
+	}
+	return nil
+}
+
+// GetContactTags lists the tags of a contact
+func (ws *WhatsAppService) GetContactTags(userID int, contact string) ([]string, error) {
+	jid, err := parseContactJID(contact)
+	if err != nil {
+		return nil, err
+	}
+
+	contactJID := ws.db.CanonicalContactJID(jid.String())
+	tags, err := ws.db.GetContactTags(userID, []string{contactJID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tags: %w", err)
+	}
+	if tags[contactJID] == nil {
+		return []string{}, nil
+	}
+	return tags[contactJID], nil
+}
+
+// GetContacts pages through the user's contacts with their tags and notes. With a tag
+// (the "tag" filter), only contacts carrying it are returned, including tagged
+// numbers that aren't in the contacts table (those have just their JID and
+// number set).
+func (ws *WhatsAppService) GetContacts(userID int, q ListQuery) ([]WhatsAppContact, int64, error) {
+	var (
+		contacts []WhatsAppContact
+		total    int64
+		err      error
+	)
+	if tag, ok := q.Filters["tag"]; !ok {
+		contacts, total, err = ws.db.GetUserContacts(userID, q)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to load contacts: %w", err)
+		}
+	} else {
+		if tag, err = normalizeTag(tag); err != nil {
+			return nil, 0, err
+		}
+		var jids []string
+		jids, total, err = ws.db.PageTaggedJIDs(userID, tag, q)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to load contacts: %w", err)
+		}
+		found, err := ws.db.GetContactsByJIDs(userID, jids)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to load contacts: %w", err)
+		}
+
+		// Keep the page order
+		byJID := make(map[string]WhatsAppContact, len(found))
+		for _, contact := range found {
+			byJID[contact.JID] = contact
+		}
+		contacts = make([]WhatsAppContact, 0, len(jids))
+		for _, jid := range jids {
+			contact, ok := byJID[jid]
+			if !ok {
+				contact = WhatsAppContact{UserID: userID, JID: jid}
+				if parsed, err := types.ParseJID(jid); err == nil && parsed.Server == types.DefaultUserServer {
+					contact.MobileNumber = parsed.User
+				}
+			}
+			contacts = append(contacts, contact)
+		}
+	}
+
+	jids := make([]string, 0, len(contacts))
+	for _, contact := range contacts {
+		jids = append(jids, contact.JID)
+	}
+	tags, err := ws.db.GetContactTags(userID, jids)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load tags: %w", err)
+	}
+	notes, err := ws.db.GetNotes(userID, "", NoteTargetContact, jids)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load notes: %w", err)
+	}
+	for i := range contacts {
+		contacts[i].Tags = tags[contacts[i].JID]
+		contacts[i].Notes = notes[contacts[i].JID]
+	}
+	if err := ws.attachContactScores(userID, contacts); err != nil {
+		return nil, 0, fmt.Errorf("failed to load scores: %w", err)
+	}
+	return contacts, total, nil
+}
+
+// tagSender tags the sender of an incoming message. LID senders are stored
+// under their phone number JID when it is known, matching the contacts table.
+func (ws *WhatsAppService) tagSender(sc *SessionClient, sender types.JID, tag string) {
+	jid := sender.ToNonAD()
+	if phone := ws.phoneForJID(sc, sender); phone != "" {
+		jid = types.NewJID(phone, types.DefaultUserServer)
+	}
+
+	if err := ws.db.AddContactTags(sc.UserID, jid.String(), []string{tag}); err != nil {
+		log.Printf("❌ Failed to tag %s with %q: %v", jid.String(), tag, err)
+		return
+	}
+
+	log.Printf("🏷️  Tagged %s with %q", jid.String(), tag)
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "contact_tagged", map[string]interface{}{
+		"jid": jid.String(),
+		"tag": tag,
+	})
+}
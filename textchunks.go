@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ============= TEXT CHUNKING =============
+// WhatsApp rejects very long texts, so a text longer than
+// TEXT_CHUNK_MAX_LENGTH characters is sent as several messages instead of
+// failing. Parts end at the last paragraph break that fits, else the last
+// line break, sentence end or space; only a text without any of them is cut
+// mid-word. With TEXT_CHUNK_NUMBERING each part starts with "1/3 " and so on,
+// counted within the limit.
+
+// textBreaks are where a part may end, most preferred first. The first keep
+// bytes of a break stay with the part, the rest is dropped between parts.
+var textBreaks = []struct {
+	sep  string
+	keep int
+}{
+	{"\n\n", 0}, {"\n", 0}, {". ", 1}, {"! ", 1}, {"? ", 1}, {" ", 0},
+}
+
+// splitText splits a text into parts of at most maxLength characters, each
+// prefixed with its number when numbered is set. maxLength 0 never splits.
+func splitText(text string, maxLength int, numbered bool) []string {
+	if maxLength <= 0 || utf8.RuneCountInString(text) <= maxLength {
+		return []string{text}
+	}
+	if !numbered {
+		return splitTextParts(text, maxLength)
+	}
+
+	// The prefix length depends on the number of parts, which depends on
+	// the room left by the prefix
+	parts := splitTextParts(text, maxLength)
+	for {
+		prefix := len(fmt.Sprintf("%d/%d ", len(parts), len(parts)))
+		resplit := splitTextParts(text, max(maxLength-prefix, 1))
+		if len(resplit) == len(parts) {
+			parts = resplit
+			break
+		}
+		parts = resplit
+	}
+	for i, part := range parts {
+		parts[i] = fmt.Sprintf("%d/%d %s", i+1, len(parts), part)
+	}
+	return parts
+}
+
+// splitTextParts splits a text at the preferred breaks into parts of at most
+// maxLength characters
+func splitTextParts(text string, maxLength int) []string {
+	var parts []string
+	for {
+		text = strings.TrimLeft(text, " \n")
+		if utf8.RuneCountInString(text) <= maxLength {
+			if text != "" {
+				parts = append(parts, text)
+			}
+			return parts
+		}
+
+		// Byte offset of the first character past the limit
+		limit := 0
+		for i := 0; i < maxLength; i++ {
+			_, size := utf8.DecodeRuneInString(text[limit:])
+			limit += size
+		}
+
+		// A hard cut moves back to the start of an emoji sequence or a letter
+		// with combining marks, so neither is split across parts
+		hard := limit
+		for hard > 0 && joinsPrevious(text, hard) {
+			_, size := utf8.DecodeLastRuneInString(text[:hard])
+			hard -= size
+		}
+		if hard == 0 {
+			hard = limit
+		}
+
+		cut, next := hard, hard
+		for _, b := range textBreaks {
+			// The dropped part of a break may lie past the limit; a break in
+			// the first half of the part is passed over for a later kind, so
+			// no part ends up a sliver
+			window := text[:min(limit+len(b.sep)-b.keep, len(text))]
+			if i := strings.LastIndex(window, b.sep); i > 0 && utf8.RuneCountInString(text[:i]) >= maxLength/2 {
+				cut, next = i+b.keep, i+len(b.sep)
+				break
+			}
+		}
+		parts = append(parts, strings.TrimRight(text[:cut], " \n"))
+		text = text[next:]
+	}
+}
+
+// joinsPrevious reports whether the character at byte offset i belongs to the
+// same visible character as the one before it: combining marks, variation
+// selectors, skin tones, emoji tags, flag pairs and anything after a
+// zero-width joiner
+func joinsPrevious(text string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(text[i:])
+	prev, _ := utf8.DecodeLastRuneInString(text[:i])
+	isFlag := func(r rune) bool { return r >= 0x1F1E6 && r <= 0x1F1FF }
+	switch {
+	case prev == '\u200d' || r == '\u200d':
+		return true
+	case unicode.In(r, unicode.Mn, unicode.Me):
+		return true
+	case r >= 0xFE00 && r <= 0xFE0F, r >= 0x1F3FB && r <= 0x1F3FF, r >= 0xE0020 && r <= 0xE007F:
+		return true
+	case isFlag(r) && isFlag(prev):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/bidi"
+	"golang.org/x/text/unicode/norm"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= MESSAGE RENDERING =============
+// Broadcasts, campaigns and auto-replies render their template for every
+// recipient through renderMessage. The template and the substituted values
+// are normalized to NFC with invalid UTF-8, byte order marks and control
+// characters removed, so names imported from other systems don't end up as
+// broken characters; emoji sequences are left intact. Direction controls in
+// values are dropped, so a name can't flip the rest of a message.
+//
+// Arabic and Hebrew texts are written right-to-left and WhatsApp picks the
+// direction of a message from its first letter. A value running the other
+// way than the template (a Latin name in an Arabic text, or a phone number,
+// whose "+" would jump to the wrong end) is wrapped in first-strong isolate
+// marks, and a message whose first letter runs the other way than the
+// template starts with a right-to-left or left-to-right mark. Texts longer
+// than the length limit, variables without a value or fallback and unbalanced
+// direction controls in the template are reported as warnings.
+
+// whatsAppTextMaxLength is the longest text WhatsApp accepts
+const whatsAppTextMaxLength = 65536
+
+// Unicode direction marks and controls
+const (
+	leftToRightMark    = '\u200e'
+	rightToLeftMark    = '\u200f'
+	firstStrongIsolate = '\u2068'
+	popDirIsolate      = '\u2069'
+	popDirFormatting   = '\u202c'
+)
+
+const (
+	TextDirectionLTR = "ltr"
+	TextDirectionRTL = "rtl"
+)
+
+// TemplatePreviewRequest renders a template with sample values
+type TemplatePreviewRequest struct {
+	Text      string            `json:"text" binding:"required"`
+	Variables map[string]string `json:"variables"`
+}
+
+// RenderedText is a message rendered for one recipient
+type RenderedText struct {
+	Text      string   `json:"text"`
+	Length    int      `json:"length"`    // characters
+	Direction string   `json:"direction"` // ltr or rtl
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// textLengthLimit is the longest text sent as a single message
+func (ws *WhatsAppService) textLengthLimit() int {
+	if ws.cfg.TextChunkMaxLength > 0 {
+		return ws.cfg.TextChunkMaxLength
+	}
+	return whatsAppTextMaxLength
+}
+
+// PreviewTemplate renders a template the way a recipient with the given
+// values would get it
+func (ws *WhatsAppService) PreviewTemplate(req TemplatePreviewRequest) (*RenderedText, error) {
+	fields := make([]string, 0, len(req.Variables))
+	vars := make(map[string]string, len(req.Variables))
+	for name, value := range req.Variables {
+		name = strings.ToLower(strings.TrimSpace(name))
+		fields = append(fields, name)
+		vars[name] = value
+	}
+	if err := validateTemplate(req.Text, fields...); err != nil {
+		return nil, fmt.Errorf("%w: %v", apierr.ErrInvalidRequest, err)
+	}
+	return renderMessage(req.Text, vars, rand.New(rand.NewSource(time.Now().UnixNano())), ws.textLengthLimit())
+}
+
+// renderMessage renders a template for one recipient with normalized values,
+// direction marks where needed and warnings about the result
+func renderMessage(text string, vars map[string]string, rng *rand.Rand, maxLength int) (*RenderedText, error) {
+	text = normalizeText(text, false)
+	direction := templateDirection(text)
+
+	var warnings []string
+	if !directionControlsBalanced(text) {
+		warnings = append(warnings, "the template has unbalanced direction controls")
+	}
+
+	p := &templateParser{src: text, vars: vars, rng: rng}
+	p.format = func(value string) string {
+		value = normalizeText(value, true)
+		if valueDirection(value) != direction {
+			return string(firstStrongIsolate) + value + string(popDirIsolate)
+		}
+		return value
+	}
+	rendered, err := p.parse(false)
+	if err != nil {
+		return nil, err
+	}
+
+	if first := firstStrongDirection(rendered); first != "" && first != direction {
+		mark := leftToRightMark
+		if direction == TextDirectionRTL {
+			mark = rightToLeftMark
+		}
+		rendered = string(mark) + rendered
+	}
+
+	for _, name := range uniqueStrings(p.empty) {
+		warnings = append(warnings, fmt.Sprintf("{{%s}} is empty and has no fallback", name))
+	}
+	length := utf8.RuneCountInString(rendered)
+	if strings.TrimSpace(rendered) == "" {
+		warnings = append(warnings, "the rendered text is empty")
+	} else if length > maxLength {
+		warnings = append(warnings, fmt.Sprintf("the rendered text is %d characters, over the limit of %d", length, maxLength))
+	}
+
+	return &RenderedText{
+		Text:      rendered,
+		Length:    length,
+		Direction: direction,
+		Warnings:  warnings,
+	}, nil
+}
+
+// normalizeText converts text to NFC and drops invalid UTF-8, byte order
+// marks and control characters other than tabs and line breaks; with
+// dropDirection it also drops direction controls
+func normalizeText(text string, dropDirection bool) string {
+	text = norm.NFC.String(strings.ToValidUTF8(text, ""))
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t' || r == '\n':
+			return r
+		case r == '\ufeff' || unicode.IsControl(r):
+			return -1
+		case dropDirection && isDirectionControl(r):
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// isDirectionControl reports whether r is an embedding, override or isolate
+// control, which affect the text after them until closed
+func isDirectionControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
+
+// directionControlsBalanced reports whether every embedding, override and
+// isolate in text is closed
+func directionControlsBalanced(text string) bool {
+	embeddings, isolates := 0, 0
+	for _, r := range text {
+		switch {
+		case r == popDirFormatting:
+			embeddings--
+		case r == popDirIsolate:
+			isolates--
+		case r >= '\u202a' && r <= '\u202e':
+			embeddings++
+		case r >= '\u2066' && r <= '\u2068':
+			isolates++
+		}
+		if embeddings < 0 || isolates < 0 {
+			return false
+		}
+	}
+	return embeddings == 0 && isolates == 0
+}
+
+// templateDirection is the direction of a template's own text: that of its
+// first letter outside placeholders, left-to-right without any
+func templateDirection(text string) string {
+	if direction := firstStrongDirection(templateVariablesPattern.ReplaceAllString(text, "")); direction != "" {
+		return direction
+	}
+	return TextDirectionLTR
+}
+
+// templateVariablesPattern finds the placeholders in a template
+var templateVariablesPattern = regexp.MustCompile(`\{\{[^{}]*\}\}`)
+
+// valueDirection is the direction of a substituted value; digits and symbols
+// without any letter read left-to-right
+func valueDirection(value string) string {
+	if direction := firstStrongDirection(value); direction != "" {
+		return direction
+	}
+	return TextDirectionLTR
+}
+
+// firstStrongDirection returns the direction of the first letter in text, or
+// "" when it has none
+func firstStrongDirection(text string) string {
+	for _, r := range text {
+		props, _ := bidi.LookupRune(r)
+		switch props.Class() {
+		case bidi.L:
+			return TextDirectionLTR
+		case bidi.R, bidi.AL:
+			return TextDirectionRTL
+		}
+	}
+	return ""
+}
+
+// uniqueStrings drops repeated values, keeping the first of each
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow"
+)
+
+// ============= WHATSAPP THROTTLING =============
+// WhatsApp answers too many requests with 429 rate-overlimit. Calls made
+// through callWhatsApp are watched for it: the first rate limit makes the
+// session back off for 30s, each further one doubles the backoff (up to 15
+// minutes) and every successful call afterwards steps it back down. While a
+// session backs off its calls fail fast with a ThrottleError instead of
+// reaching WhatsApp. Sends are held back like safety-engine limits (the
+// outbox and campaigns retry them at RetryAt); background jobs such as group
+// sync go back to the job queue until RetryAt. The state is part of the
+// session health check.
+
+const (
+	throttleBaseBackoff = 30 * time.Second
+	throttleMaxBackoff  = 15 * time.Minute
+)
+
+// ThrottleError is returned for calls of a session that is backing off after
+// WhatsApp rate limited it
+type ThrottleError struct {
+	SessionID string
+	RetryAt   time.Time
+	Err       error // the rate limit that started the backoff, if this call hit it
+}
+
+func (e *ThrottleError) Error() string {
+	msg := fmt.Sprintf("session rate limited by WhatsApp, retry after %s", e.RetryAt.UTC().Format(time.RFC3339))
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ThrottleError) Unwrap() error {
+	return e.Err
+}
+
+// isRateLimitError reports whether WhatsApp rejected a call for going over its
+// rate limits: a 429 IQ error, a send acked with error 429, or an error that
+// only kept the rate-overlimit text
+func isRateLimitError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var throttleErr *ThrottleError
+	if errors.As(err, &throttleErr) {
+		return true
+	}
+	var iqErr *whatsmeow.IQError
+	if errors.As(err, &iqErr) && iqErr.Code == 429 {
+		return true
+	}
+	msg := err.Error()
+	if errors.Is(err, whatsmeow.ErrServerReturnedError) && strings.Contains(msg, whatsmeow.ErrServerReturnedError.Error()+" 429") {
+		return true
+	}
+	return strings.Contains(msg, "rate-overlimit")
+}
+
+// sessionThrottle is the backoff state of one session client
+type sessionThrottle struct {
+	mu        sync.Mutex
+	strikes   int // steps of the current backoff, 0 = not throttled
+	until     time.Time
+	lastHit   time.Time
+	lastError string
+	total     int // rate limits since the client was created
+}
+
+// check fails fast while the session backs off
+func (t *sessionThrottle) check(sessionID string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Now().Before(t.until) {
+		return &ThrottleError{SessionID: sessionID, RetryAt: t.until}
+	}
+	return nil
+}
+
+// callWhatsApp runs a WhatsApp request of a session in one of its call slots
+// unless it is backing off, and starts or extends the backoff when WhatsApp
+// rate limits the request
+func (ws *WhatsAppService) callWhatsApp(sc *SessionClient, priority callPriority, op string, fn func() error) error {
+	if err := sc.throttle.check(sc.SessionID); err != nil {
+		return err
+	}
+	return ws.withCallSlot(sc, priority, func() error {
+		// The backoff may have started while the call waited for its slot
+		if err := sc.throttle.check(sc.SessionID); err != nil {
+			return err
+		}
+		return ws.observeWhatsApp(sc, op, fn())
+	})
+}
+
+// observeWhatsApp records the outcome of a WhatsApp request; rate limits are
+// returned as a ThrottleError
+func (ws *WhatsAppService) observeWhatsApp(sc *SessionClient, op string, err error) error {
+	var throttleErr *ThrottleError
+	if errors.As(err, &throttleErr) {
+		return err // already recorded by a nested call
+	}
+
+	t := &sc.throttle
+	if !isRateLimitError(err) {
+		if err == nil {
+			t.mu.Lock()
+			if t.strikes > 0 && !time.Now().Before(t.until) {
+				t.strikes--
+			}
+			t.mu.Unlock()
+		}
+		return err
+	}
+
+	now := time.Now()
+	t.mu.Lock()
+	if now.Before(t.until) {
+		// Calls started before the backoff began; don't escalate for each
+		retryAt := t.until
+		t.mu.Unlock()
+		return &ThrottleError{SessionID: sc.SessionID, RetryAt: retryAt, Err: err}
+	}
+	t.strikes++
+	backoff := throttleBaseBackoff << uint(t.strikes-1)
+	if backoff > throttleMaxBackoff || backoff <= 0 {
+		backoff = throttleMaxBackoff
+	}
+	t.until = now.Add(backoff)
+	t.lastHit = now
+	t.lastError = err.Error()
+	t.total++
+	retryAt, strikes := t.until, t.strikes
+	t.mu.Unlock()
+
+	log.Printf("⏸️  Session %s rate limited by WhatsApp (%s), backing off %v: %v", sc.SessionID, op, backoff, err)
+
+	data := map[string]interface{}{
+		"operation": op,
+		"retry_at":  retryAt,
+		"backoff":   backoff.String(),
+		"strikes":   strikes,
+		"error":     err.Error(),
+	}
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "session_throttled", data)
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "session_throttled",
+		Data: data,
+	})
+
+	return &ThrottleError{SessionID: sc.SessionID, RetryAt: retryAt, Err: err}
+}
+
+// ThrottleHealth is the WhatsApp rate-limit state of a session
+type ThrottleHealth struct {
+	Throttled     bool       `json:"throttled"`
+	RetryAt       *time.Time `json:"retry_at,omitempty"`
+	Strikes       int        `json:"strikes"`     // backoff steps, each doubles the wait
+	RateLimits    int        `json:"rate_limits"` // since the client was loaded
+	LastRateLimit *time.Time `json:"last_rate_limit,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+func (t *sessionThrottle) health() ThrottleHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	health := ThrottleHealth{
+		Throttled:  time.Now().Before(t.until),
+		Strikes:    t.strikes,
+		RateLimits: t.total,
+		LastError:  t.lastError,
+	}
+	if health.Throttled {
+		retryAt := t.until
+		health.RetryAt = &retryAt
+	}
+	if !t.lastHit.IsZero() {
+		lastHit := t.lastHit
+		health.LastRateLimit = &lastHit
+	}
+	return health
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ============= THUMBNAILS =============
+// Images and videos are sent with a small inline JPEG so recipients see a
+// preview instead of a grey placeholder until the download finishes. Video
+// frames are extracted with ffmpeg when it is installed; without it videos are
+// sent without a thumbnail.
+
+const (
+	thumbnailMaxSide = 100
+	thumbnailQuality = 60
+	ffmpegTimeout    = 15 * time.Second
+)
+
+// MediaThumbnail is a JPEG preview plus the dimensions of the original media
+type MediaThumbnail struct {
+	JPEG   []byte
+	Width  uint32
+	Height uint32
+}
+
+// imageThumbnail decodes an image (JPEG, PNG, GIF or WebP) and downscales it
+func imageThumbnail(r io.Reader) (*MediaThumbnail, error) {
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("image has no pixels")
+	}
+
+	thumbWidth, thumbHeight := width, height
+	if width > thumbnailMaxSide || height > thumbnailMaxSide {
+		if width >= height {
+			thumbWidth = thumbnailMaxSide
+			thumbHeight = max(1, height*thumbnailMaxSide/width)
+		} else {
+			thumbHeight = thumbnailMaxSide
+			thumbWidth = max(1, width*thumbnailMaxSide/height)
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+
+	return &MediaThumbnail{
+		JPEG:   buf.Bytes(),
+		Width:  uint32(width),
+		Height: uint32(height),
+	}, nil
+}
+
+// videoThumbnail grabs the first frame of a video file with ffmpeg
+func videoThumbnail(path string) (*MediaThumbnail, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg not installed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ffmpegTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpeg,
+		"-hide_banner", "-loglevel", "error",
+		"-i", path,
+		"-frames:v", "1",
+		"-f", "image2pipe", "-vcodec", "mjpeg",
+		"-",
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to extract video frame: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return imageThumbnail(&stdout)
+}
+
+// wantsThumbnail reports whether a thumbnail is generated for the media
+func wantsThumbnail(mediaType, mimetype string) bool {
+	switch mediaType {
+	case "image", "video":
+		return true
+	case "document":
+		return strings.HasPrefix(mimetype, "image/") || strings.HasPrefix(mimetype, "video/")
+	default:
+		return false
+	}
+}
+
+// generateThumbnail builds a thumbnail from in-memory media. Failures are not
+// fatal; the media is simply sent without a preview.
+func generateThumbnail(mimetype string, data []byte) *MediaThumbnail {
+	if !strings.HasPrefix(mimetype, "video/") {
+		thumb, err := imageThumbnail(bytes.NewReader(data))
+		if err != nil {
+			log.Printf("⚠️  No thumbnail for %s: %v", mimetype, err)
+			return nil
+		}
+		return thumb
+	}
+
+	// ffmpeg needs a seekable input for most containers
+	tempFile, err := os.CreateTemp("", "wa-thumb-*")
+	if err != nil {
+		log.Printf("⚠️  No thumbnail for %s: %v", mimetype, err)
+		return nil
+	}
+	defer os.Remove(tempFile.Name())
+
+	_, err = tempFile.Write(data)
+	tempFile.Close()
+	if err != nil {
+		log.Printf("⚠️  No thumbnail for %s: %v", mimetype, err)
+		return nil
+	}
+	return generateThumbnailFromFile(mimetype, tempFile.Name())
+}
+
+// generateThumbnailFromFile builds a thumbnail from media stored on disk
+func generateThumbnailFromFile(mimetype, path string) *MediaThumbnail {
+	var (
+		thumb *MediaThumbnail
+		err   error
+	)
+	if strings.HasPrefix(mimetype, "video/") {
+		thumb, err = videoThumbnail(path)
+	} else {
+		var file *os.File
+		if file, err = os.Open(path); err == nil {
+			thumb, err = imageThumbnail(bufio.NewReader(file))
+			file.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("⚠️  No thumbnail for %s: %v", mimetype, err)
+		return nil
+	}
+	return thumb
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= USAGE QUOTAS =============
+// Every message WhatsApp accepts is counted against its user's billing
+// period, the calendar month in UTC (WhatsAppUsageCounter). Each user has a
+// soft and a hard limit of messages per period: USAGE_SOFT_LIMIT and
+// USAGE_HARD_LIMIT, or their own quota set with `whatsapp-api user usage set`.
+// Past the soft limit sends still go out but carry X-Usage-Warning; at the
+// hard limit send endpoints answer 402 quota_exceeded, and queued sends
+// (outbox, campaigns, broadcasts) fail with the same error. A send reserves
+// its message before it goes out, checking and counting in one UPDATE, so
+// concurrent sends can't overshoot the hard limit; a send WhatsApp doesn't
+// accept gives its message back.
+
+const usagePeriodLayout = "2006-01"
+
+// UsageLimits are the soft and hard limits of a user, 0 = none
+type UsageLimits struct {
+	SoftLimit int    `json:"soft_limit"`
+	HardLimit int    `json:"hard_limit"`
+	Source    string `json:"source"` // config or quota
+}
+
+// UsageReport is a user's consumption in one billing period
+type UsageReport struct {
+	Period            string    `json:"period"`
+	PeriodStart       time.Time `json:"period_start"`
+	PeriodEnd         time.Time `json:"period_end"` // start of the next period
+	MessagesSent      int       `json:"messages_sent"`
+	Remaining         *int      `json:"remaining"` // until the hard limit, null without one
+	SoftLimitExceeded bool      `json:"soft_limit_exceeded"`
+	HardLimitReached  bool      `json:"hard_limit_reached"`
+	UsageLimits
+}
+
+// usagePeriod returns the billing period of a time
+func usagePeriod(t time.Time) string {
+	return t.UTC().Format(usagePeriodLayout)
+}
+
+// GetUsage returns the consumption of a user in a period (YYYY-MM, empty
+// for the current one)
+func (ws *WhatsAppService) GetUsage(userID int, period string) (*UsageReport, error) {
+	return loadUsage(ws.db, ws.cfg, userID, period)
+}
+
+// loadUsage builds a usage report from the counters and the user's limits:
+// their quota, or the configured defaults
+func loadUsage(dm *DatabaseManager, cfg *Config, userID int, period string) (*UsageReport, error) {
+	if period == "" {
+		period = usagePeriod(time.Now())
+	}
+	start, err := time.Parse(usagePeriodLayout, period)
+	if err != nil {
+		return nil, fmt.Errorf("invalid period %q (expected YYYY-MM)", period)
+	}
+
+	limits, err := usageLimits(dm, cfg, userID)
+	if err != nil {
+		return nil, err
+	}
+	sent, err := dm.GetUsage(userID, period)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load usage: %w", err)
+	}
+
+	report := &UsageReport{
+		Period:       period,
+		PeriodStart:  start,
+		PeriodEnd:    start.AddDate(0, 1, 0),
+		MessagesSent: sent,
+		UsageLimits:  limits,
+	}
+	report.SoftLimitExceeded = limits.SoftLimit > 0 && sent > limits.SoftLimit
+	if limits.HardLimit > 0 {
+		remaining := max(limits.HardLimit-sent, 0)
+		report.Remaining = &remaining
+		report.HardLimitReached = remaining == 0
+	}
+	return report, nil
+}
+
+// usageLimits returns the limits of a user: their quota, or the configured
+// defaults
+func usageLimits(dm *DatabaseManager, cfg *Config, userID int) (UsageLimits, error) {
+	quota, err := dm.GetUsageQuota(userID)
+	if err != nil {
+		return UsageLimits{}, fmt.Errorf("failed to load usage quota: %w", err)
+	}
+	if quota != nil {
+		return UsageLimits{SoftLimit: quota.SoftLimit, HardLimit: quota.HardLimit, Source: "quota"}, nil
+	}
+	return UsageLimits{SoftLimit: cfg.UsageSoftLimit, HardLimit: cfg.UsageHardLimit, Source: "config"}, nil
+}
+
+// checkUsage refuses a send once the user reached the hard limit of the
+// current period. Usage that can't be loaded lets the send through.
+func (ws *WhatsAppService) checkUsage(userID int) (*UsageReport, error) {
+	report, err := ws.GetUsage(userID, "")
+	if err != nil {
+		log.Printf("⚠️  Usage check failed for user %d (allowing send): %v", userID, err)
+		return nil, nil
+	}
+	if report.HardLimitReached {
+		return report, fmt.Errorf("%w: %d/%d messages sent in %s", apierr.ErrQuotaExceeded, report.MessagesSent, report.HardLimit, report.Period)
+	}
+	return report, nil
+}
+
+// reserveUsage counts a message to be sent against the user's current
+// period, refusing it at the hard limit. The returned release gives the
+// message back if WhatsApp doesn't accept it. Usage that can't be loaded or
+// counted lets the send through.
+func (ws *WhatsAppService) reserveUsage(userID int) (func(), error) {
+	period := usagePeriod(time.Now())
+	release := func() {
+		if err := ws.db.IncrementUsage(userID, period, -1); err != nil {
+			log.Printf("⚠️  Failed to release usage of user %d: %v", userID, err)
+		}
+	}
+
+	limits, err := usageLimits(ws.db, ws.cfg, userID)
+	if err != nil {
+		log.Printf("⚠️  Usage check failed for user %d (allowing send): %v", userID, err)
+		return func() {}, nil
+	}
+	if limits.HardLimit == 0 {
+		if err := ws.db.IncrementUsage(userID, period, 1); err != nil {
+			log.Printf("⚠️  Failed to count usage of user %d: %v", userID, err)
+			return func() {}, nil
+		}
+		return release, nil
+	}
+
+	reserved, err := ws.db.ReserveUsage(userID, period, limits.HardLimit)
+	if err != nil {
+		log.Printf("⚠️  Usage check failed for user %d (allowing send): %v", userID, err)
+		return func() {}, nil
+	}
+	if !reserved {
+		sent, err := ws.db.GetUsage(userID, period)
+		if err != nil {
+			sent = limits.HardLimit
+		}
+		return nil, fmt.Errorf("%w: %d/%d messages sent in %s", apierr.ErrQuotaExceeded, sent, limits.HardLimit, period)
+	}
+	return release, nil
+}
+
+// UsageMiddleware rejects calls of the send endpoint class once the user
+// reached their hard limit and reports the usage in X-Usage-* headers; it
+// must run after AuthMiddleware
+func UsageMiddleware(ws *WhatsAppService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rateLimitClass(c) != RateClassSend {
+			c.Next()
+			return
+		}
+
+		report, err := ws.checkUsage(c.GetInt("user_id"))
+		if report != nil {
+			c.Header("X-Usage-Period", report.Period)
+			c.Header("X-Usage-Sent", strconv.Itoa(report.MessagesSent))
+			if report.Remaining != nil {
+				c.Header("X-Usage-Limit", strconv.Itoa(report.HardLimit))
+				c.Header("X-Usage-Remaining", strconv.Itoa(*report.Remaining))
+			}
+			if report.SoftLimitExceeded {
+				c.Header("X-Usage-Warning", fmt.Sprintf("soft limit of %d messages exceeded", report.SoftLimit))
+			}
+		}
+		if errors.Is(err, apierr.ErrQuotaExceeded) {
+			c.AbortWithStatusJSON(apierr.ErrQuotaExceeded.Status, gin.H{
+				"success":  false,
+				"error":    err.Error(),
+				"code":     apierr.ErrQuotaExceeded.Code,
+				"reset_at": report.PeriodEnd,
+			})
+			return
+		}
+		c.Next()
+	}
+}
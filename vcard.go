@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ============= VCARD =============
+
+// MaxContactsPerMessage caps the number of cards in a single contacts array message
+const MaxContactsPerMessage = 50
+
+// ContactPhone is a phone number of a shared contact
+type ContactPhone struct {
+	Number string `json:"number" binding:"required"`
+	Type   string `json:"type"` // CELL, HOME, WORK, MAIN, ... (default CELL)
+}
+
+// ContactCard describes a contact to share. Either VCard holds a full vCard
+// payload, or the structured fields are used to build one.
+type ContactCard struct {
+	VCard string `json:"vcard"`
+
+	DisplayName  string         `json:"display_name"`
+	FirstName    string         `json:"first_name"`
+	LastName     string         `json:"last_name"`
+	MiddleName   string         `json:"middle_name"`
+	Prefix       string         `json:"prefix"`
+	Suffix       string         `json:"suffix"`
+	Organization string         `json:"organization"`
+	Title        string         `json:"title"`
+	Phones       []ContactPhone `json:"phones"`
+	Emails       []string       `json:"emails"`
+}
+
+var (
+	vcardPropertyName = regexp.MustCompile(`^(?:[A-Za-z0-9-]+\.)?[A-Za-z0-9-]+$`)
+	vcardPhoneDigits  = regexp.MustCompile(`[^0-9]`)
+	vcardEmail        = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+)
+
+// Resolve returns the display name and vCard payload of a contact card
+func (card ContactCard) Resolve() (string, string, error) {
+	if strings.TrimSpace(card.VCard) != "" {
+		displayName, err := ValidateVCard(card.VCard)
+		if err != nil {
+			return "", "", err
+		}
+		if card.DisplayName != "" {
+			displayName = card.DisplayName
+		}
+		return displayName, normalizeVCardLineEndings(card.VCard), nil
+	}
+
+	return card.build()
+}
+
+// build generates a vCard 3.0 payload from the structured fields
+func (card ContactCard) build() (string, string, error) {
+	displayName := strings.TrimSpace(card.DisplayName)
+	if displayName == "" {
+		parts := []string{card.Prefix, card.FirstName, card.MiddleName, card.LastName, card.Suffix}
+		nonEmpty := make([]string, 0, len(parts))
+		for _, part := range parts {
+			if part = strings.TrimSpace(part); part != "" {
+				nonEmpty = append(nonEmpty, part)
+			}
+		}
+		displayName = strings.Join(nonEmpty, " ")
+	}
+	if displayName == "" {
+		displayName = strings.TrimSpace(card.Organization)
+	}
+	if displayName == "" {
+		return "", "", fmt.Errorf("contact requires a display_name, name parts or organization")
+	}
+
+	if len(card.Phones) == 0 {
+		return "", "", fmt.Errorf("contact %q requires at least one phone number", displayName)
+	}
+
+	var b strings.Builder
+	b.WriteString("BEGIN:VCARD\r\n")
+	b.WriteString("VERSION:3.0\r\n")
+	fmt.Fprintf(&b, "N:%s;%s;%s;%s;%s\r\n",
+		escapeVCardValue(card.LastName), escapeVCardValue(card.FirstName), escapeVCardValue(card.MiddleName),
+		escapeVCardValue(card.Prefix), escapeVCardValue(card.Suffix))
+	fmt.Fprintf(&b, "FN:%s\r\n", escapeVCardValue(displayName))
+
+	if card.Organization != "" {
+		fmt.Fprintf(&b, "ORG:%s\r\n", escapeVCardValue(card.Organization))
+	}
+	if card.Title != "" {
+		fmt.Fprintf(&b, "TITLE:%s\r\n", escapeVCardValue(card.Title))
+	}
+
+	for _, phone := range card.Phones {
+		digits := vcardPhoneDigits.ReplaceAllString(phone.Number, "")
+		if len(digits) < 7 || len(digits) > 15 {
+			return "", "", fmt.Errorf("invalid phone number %q for contact %q", phone.Number, displayName)
+		}
+
+		phoneType := strings.ToUpper(strings.TrimSpace(phone.Type))
+		if phoneType == "" {
+			phoneType = "CELL"
+		}
+
+		// waid lets WhatsApp link the card to the account behind the number
+		fmt.Fprintf(&b, "TEL;type=%s;waid=%s:+%s\r\n", phoneType, digits, digits)
+	}
+
+	for _, email := range card.Emails {
+		email = strings.TrimSpace(email)
+		if !vcardEmail.MatchString(email) {
+			return "", "", fmt.Errorf("invalid email %q for contact %q", email, displayName)
+		}
+		fmt.Fprintf(&b, "EMAIL;type=INTERNET:%s\r\n", email)
+	}
+
+	b.WriteString("END:VCARD")
+
+	return displayName, b.String(), nil
+}
+
+// ValidateVCard checks the syntax of a single vCard payload and returns its formatted name
+func ValidateVCard(vcard string) (string, error) {
+	lines := unfoldVCardLines(vcard)
+
+	// Drop trailing blank lines
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) < 3 {
+		return "", fmt.Errorf("invalid vCard: too short")
+	}
+	if !strings.EqualFold(strings.TrimSpace(lines[0]), "BEGIN:VCARD") {
+		return "", fmt.Errorf("invalid vCard: must start with BEGIN:VCARD")
+	}
+	if !strings.EqualFold(strings.TrimSpace(lines[len(lines)-1]), "END:VCARD") {
+		return "", fmt.Errorf("invalid vCard: must end with END:VCARD")
+	}
+
+	var version, formattedName string
+	hasTel := false
+
+	for i, line := range lines[1 : len(lines)-1] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		colon := strings.Index(line, ":")
+		if colon <= 0 {
+			return "", fmt.Errorf("invalid vCard: line %d is not a property", i+2)
+		}
+
+		nameAndParams := strings.Split(line[:colon], ";")
+		name := strings.ToUpper(nameAndParams[0])
+		if !vcardPropertyName.MatchString(name) {
+			return "", fmt.Errorf("invalid vCard: bad property name %q on line %d", nameAndParams[0], i+2)
+		}
+		if idx := strings.LastIndex(name, "."); idx >= 0 {
+			name = name[idx+1:] // strip group prefix (item1.TEL)
+		}
+
+		value := line[colon+1:]
+		switch name {
+		case "BEGIN", "END":
+			return "", fmt.Errorf("invalid vCard: nested or multiple cards are not supported, send them as separate contacts")
+		case "VERSION":
+			version = strings.TrimSpace(value)
+		case "FN":
+			formattedName = strings.TrimSpace(value)
+		case "TEL":
+			hasTel = true
+		}
+	}
+
+	switch version {
+	case "2.1", "3.0", "4.0":
+	case "":
+		return "", fmt.Errorf("invalid vCard: VERSION is required")
+	default:
+		return "", fmt.Errorf("invalid vCard: unsupported VERSION %q", version)
+	}
+
+	if formattedName == "" {
+		return "", fmt.Errorf("invalid vCard: FN is required")
+	}
+	if !hasTel {
+		return "", fmt.Errorf("invalid vCard: at least one TEL is required")
+	}
+
+	return unescapeVCardValue(formattedName), nil
+}
+
+// unfoldVCardLines splits a vCard into logical lines, joining folded continuation lines
+func unfoldVCardLines(vcard string) []string {
+	raw := strings.Split(strings.ReplaceAll(vcard, "\r\n", "\n"), "\n")
+	lines := make([]string, 0, len(raw))
+	for _, line := range raw {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, "\r"))
+	}
+	return lines
+}
+
+func normalizeVCardLineEndings(vcard string) string {
+	vcard = strings.TrimSpace(strings.ReplaceAll(vcard, "\r\n", "\n"))
+	return strings.ReplaceAll(vcard, "\n", "\r\n")
+}
+
+func escapeVCardValue(value string) string {
+	value = strings.TrimSpace(value)
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, ";", `\;`)
+	value = strings.ReplaceAll(value, ",", `\,`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return value
+}
+
+func unescapeVCardValue(value string) string {
+	replacer := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	return replacer.Replace(value)
+}
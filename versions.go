@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= API VERSIONS =============
+// Every REST version is mounted through the version registry under
+// /api/<name>. Each version's middleware stamps responses with API-Version and,
+// once the version is deprecated, with the Deprecation and Sunset headers
+// (RFC 9745 / RFC 8594) plus a successor-version link. After the sunset date
+// the version answers 410. A new version is added by registering it and
+// mounting its routes on the group returned by Mount; older versions stay
+// untouched. Only v1 exists so far: /api/v2 and its DTOs are not built yet,
+// and readConfig refuses a sunset date for v1 until they are.
+
+// Version statuses
+const (
+	VersionStable     = "stable"
+	VersionDeprecated = "deprecated"
+	VersionSunset     = "sunset"
+)
+
+// APIVersion is a version of the REST API
+type APIVersion struct {
+	Name         string     `json:"name"`
+	Prefix       string     `json:"prefix"`
+	DeprecatedAt *time.Time `json:"deprecated_at,omitempty"`
+	SunsetAt     *time.Time `json:"sunset_at,omitempty"`
+	Successor    string     `json:"successor,omitempty"` // name of the version replacing this one
+}
+
+// Status is the version's lifecycle state at now
+func (v *APIVersion) Status(now time.Time) string {
+	switch {
+	case v.SunsetAt != nil && !now.Before(*v.SunsetAt):
+		return VersionSunset
+	case v.DeprecatedAt != nil && !now.Before(*v.DeprecatedAt):
+		return VersionDeprecated
+	}
+	return VersionStable
+}
+
+// VersionRegistry holds the API versions in the order they were introduced
+type VersionRegistry struct {
+	versions []*APIVersion
+}
+
+// NewVersionRegistry registers the API versions with their deprecation
+// schedule from the config. The newest version never has a sunset date;
+// readConfig rejects one.
+func NewVersionRegistry(cfg *Config) *VersionRegistry {
+	registry := &VersionRegistry{}
+	registry.Register(&APIVersion{
+		Name:         "v1",
+		DeprecatedAt: parseVersionDate("API_V1_DEPRECATED_AT", cfg.APIV1DeprecatedAt),
+		SunsetAt:     parseVersionDate("API_V1_SUNSET_AT", cfg.APIV1SunsetAt),
+	})
+	return registry
+}
+
+// Register adds a version; the previously newest version gets it as its
+// successor unless one is set. A sunset without a deprecation date counts as
+// deprecated from the sunset on.
+func (r *VersionRegistry) Register(version *APIVersion) {
+	if version.Prefix == "" {
+		version.Prefix = "/api/" + version.Name
+	}
+	if version.DeprecatedAt == nil {
+		version.DeprecatedAt = version.SunsetAt
+	}
+	if n := len(r.versions); n > 0 && r.versions[n-1].Successor == "" {
+		r.versions[n-1].Successor = version.Name
+	}
+	r.versions = append(r.versions, version)
+}
+
+// Get returns a registered version
+func (r *VersionRegistry) Get(name string) (*APIVersion, bool) {
+	for _, version := range r.versions {
+		if version.Name == name {
+			return version, true
+		}
+	}
+	return nil, false
+}
+
+// Mount creates the router group of a registered version
+func (r *VersionRegistry) Mount(router *gin.Engine, name string) *gin.RouterGroup {
+	version, ok := r.Get(name)
+	if !ok {
+		panic(fmt.Sprintf("API version %s is not registered", name))
+	}
+	return router.Group(version.Prefix, r.middleware(version))
+}
+
+// middleware stamps the version headers and rejects calls after the sunset
+func (r *VersionRegistry) middleware(version *APIVersion) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("API-Version", version.Name)
+
+		now := time.Now()
+		status := version.Status(now)
+		if status != VersionStable {
+			c.Header("Deprecation", fmt.Sprintf("@%d", version.DeprecatedAt.Unix()))
+			if version.SunsetAt != nil {
+				c.Header("Sunset", version.SunsetAt.UTC().Format(http.TimeFormat))
+			}
+			if successor, ok := r.Get(version.Successor); ok {
+				c.Header("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor.Prefix))
+			}
+		}
+		if status == VersionSunset {
+			respondAPIError(c, apierr.ErrGone, fmt.Sprintf("API %s was retired on %s", version.Name, version.SunsetAt.UTC().Format("2006-01-02")))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// HandleVersions lists the API versions and their lifecycle state
+func (r *VersionRegistry) HandleVersions(c *gin.Context) {
+	now := time.Now()
+	versions := make([]gin.H, 0, len(r.versions))
+	for _, version := range r.versions {
+		versions = append(versions, gin.H{
+			"name":          version.Name,
+			"prefix":        version.Prefix,
+			"status":        version.Status(now),
+			"deprecated_at": version.DeprecatedAt,
+			"sunset_at":     version.SunsetAt,
+			"successor":     version.Successor,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    versions,
+	})
+}
+
+// parseVersionDate reads a deprecation or sunset date (RFC 3339 or
+// YYYY-MM-DD)
+func parseVersionDate(name, value string) *time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t
+		}
+	}
+	log.Printf("Ignoring invalid %s %q (expected RFC 3339 or YYYY-MM-DD)", name, value)
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"gorm.io/gorm"
+
+	"whatsapp-api/pkg/apierr"
+)
+
+// ============= VIEW-ONCE AND EPHEMERAL MESSAGES =============
+// View-once and disappearing messages arrive wrapped in container messages
+// (ViewOnceMessage*, EphemeralMessage, DeviceSentMessage for our own
+// messages from other devices). Content, type and media reference are read
+// from the inner message and the message is stored with view_once and
+// ephemeral flags. WhatsApp lets view-once media be downloaded only for a
+// short time, so with VIEW_ONCE_AUTO_DOWNLOAD incoming view-once media is
+// copied to media storage right away (media.stored_key, see saveMessageMedia)
+// and served by GET /chats/:session_id/:jid/messages/:message_id/media.
+
+const messageMediaURLTTL = time.Hour // lifetime of signed message media links
+
+// ErrMessageMediaNotStored is returned for messages without a stored copy of
+// their media
+var ErrMessageMediaNotStored = errors.New("message media not found (only auto-downloaded view-once media and media of sessions with media_auto_store are stored)")
+
+// unwrapMessage returns the content message inside the container messages
+// and whether it was view-once or ephemeral
+func unwrapMessage(msg *waE2E.Message) (inner *waE2E.Message, viewOnce, ephemeral bool) {
+	for msg != nil {
+		switch {
+		case msg.GetDeviceSentMessage().GetMessage() != nil:
+			msg = msg.GetDeviceSentMessage().GetMessage()
+		case msg.GetEphemeralMessage().GetMessage() != nil:
+			msg = msg.GetEphemeralMessage().GetMessage()
+			ephemeral = true
+		case msg.GetViewOnceMessage().GetMessage() != nil:
+			msg = msg.GetViewOnceMessage().GetMessage()
+			viewOnce = true
+		case msg.GetViewOnceMessageV2().GetMessage() != nil:
+			msg = msg.GetViewOnceMessageV2().GetMessage()
+			viewOnce = true
+		case msg.GetViewOnceMessageV2Extension().GetMessage() != nil:
+			msg = msg.GetViewOnceMessageV2Extension().GetMessage()
+			viewOnce = true
+		default:
+			// Newer clients flag view-once on the media itself
+			viewOnce = viewOnce || msg.GetImageMessage().GetViewOnce() ||
+				msg.GetVideoMessage().GetViewOnce() || msg.GetAudioMessage().GetViewOnce()
+			return msg, viewOnce, ephemeral
+		}
+	}
+	return msg, viewOnce, ephemeral
+}
+
+// mediaExtension returns the file extension of a mimetype, e.g. ".jpg"
+func mediaExtension(mimetype string) string {
+	if mediaType, _, err := mime.ParseMediaType(mimetype); err == nil {
+		switch mediaType {
+		case "image/jpeg":
+			return ".jpg"
+		case "video/mp4":
+			return ".mp4"
+		case "audio/ogg":
+			return ".ogg"
+		}
+		if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+			return exts[0]
+		}
+	}
+	return ".bin"
+}
+
+// StoredMessageMedia is a stored copy of a message's media
+type StoredMessageMedia struct {
+	Key      string
+	Mimetype string
+}
+
+// GetMessageMedia returns the stored media copy of a message
+func (ws *WhatsAppService) GetMessageMedia(sessionID string, userID int, chatJID, messageID string) (*StoredMessageMedia, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	message, err := ws.db.GetMessage(sessionID, chatJID, messageID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("message not found")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load message: %w", err)
+	}
+
+	key := mediaField(message.Media, "stored_key")
+	if key == "" || message.Revoked {
+		return nil, ErrMessageMediaNotStored
+	}
+	return &StoredMessageMedia{
+		Key:      key,
+		Mimetype: mediaField(message.Media, "mimetype"),
+	}, nil
+}
+
+// OpenMessageMedia opens a stored media copy
+func (ws *WhatsAppService) OpenMessageMedia(media *StoredMessageMedia) (io.ReadCloser, error) {
+	return ws.media.Open(context.Background(), media.Key)
+}
+
+// MessageMediaURL returns a signed link to a stored media copy
+func (ws *WhatsAppService) MessageMediaURL(media *StoredMessageMedia) (string, time.Time, error) {
+	expiresAt := time.Now().Add(messageMediaURLTTL)
+	url, err := ws.media.SignedURL(context.Background(), media.Key, messageMediaURLTTL)
+	return url, expiresAt, err
+}
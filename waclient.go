@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/proto/waCommon"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/proto/waWeb"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"whatsapp-api/internal/wafake"
+	"whatsapp-api/pkg/wajid"
+)
+
+// ============= WHATSAPP CLIENT =============
+// Sessions talk to WhatsApp through WhatsAppClient, the subset of
+// *whatsmeow.Client the services use, so tests can run the services against
+// a fake (internal/wafake) instead of a live connection. Clients are created
+// by WhatsAppService.newClient, a *whatsmeow.Client with auto-reconnect and
+// the push name set unless a test replaces it; the device store of a session
+// is SessionClient.Device, not read through the client. New whatsmeow calls
+// in the services need a method here (and in the fake).
+
+// WhatsAppClient is the WhatsApp connection of a session
+type WhatsAppClient interface {
+	// Connection
+	Connect() error
+	Disconnect()
+	IsConnected() bool
+	IsLoggedIn() bool
+	Logout(ctx context.Context) error
+	AddEventHandler(handler whatsmeow.EventHandler) uint32
+
+	// Messages
+	GenerateMessageID() types.MessageID
+	SendMessage(ctx context.Context, to types.JID, message *waE2E.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error)
+	BuildEdit(chat types.JID, id types.MessageID, newContent *waE2E.Message) *waE2E.Message
+	BuildMessageKey(chat, sender types.JID, id types.MessageID) *waCommon.MessageKey
+	ParseWebMessage(chatJID types.JID, webMsg *waWeb.WebMessageInfo) (*events.Message, error)
+	MarkRead(ctx context.Context, ids []types.MessageID, timestamp time.Time, chat, sender types.JID, receiptTypeExtra ...types.ReceiptType) error
+	SendPresence(ctx context.Context, state types.Presence) error
+	SubscribePresence(ctx context.Context, jid types.JID) error
+	SetDisappearingTimer(ctx context.Context, chat types.JID, timer time.Duration, settingTS time.Time) error
+	SendAppState(ctx context.Context, patch appstate.PatchInfo) error
+	RejectCall(ctx context.Context, callFrom types.JID, callID string) error
+
+	// Media
+	Upload(ctx context.Context, plaintext []byte, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error)
+	UploadReader(ctx context.Context, plaintext io.Reader, tempFile io.ReadWriteSeeker, appInfo whatsmeow.MediaType) (whatsmeow.UploadResponse, error)
+	Download(ctx context.Context, msg whatsmeow.DownloadableMessage) ([]byte, error)
+
+	// Users
+	wajid.Checker // IsOnWhatsApp, for the JID resolver
+	GetUserInfo(ctx context.Context, jids []types.JID) (map[types.JID]types.UserInfo, error)
+	GetProfilePictureInfo(ctx context.Context, jid types.JID, params *whatsmeow.GetProfilePictureParams) (*types.ProfilePictureInfo, error)
+	TryFetchPrivacySettings(ctx context.Context, ignoreCache bool) (*types.PrivacySettings, error)
+	SetPrivacySetting(ctx context.Context, name types.PrivacySettingType, value types.PrivacySetting) (types.PrivacySettings, error)
+	GetBlocklist(ctx context.Context) (*types.Blocklist, error)
+	UpdateBlocklist(ctx context.Context, jid types.JID, action events.BlocklistChangeAction) (*types.Blocklist, error)
+
+	// Groups
+	CreateGroup(ctx context.Context, req whatsmeow.ReqCreateGroup) (*types.GroupInfo, error)
+	GetJoinedGroups(ctx context.Context) ([]*types.GroupInfo, error)
+	GetGroupInfo(ctx context.Context, jid types.JID) (*types.GroupInfo, error)
+	GetGroupInfoFromLink(ctx context.Context, code string) (*types.GroupInfo, error)
+	GetGroupInviteLink(ctx context.Context, jid types.JID, reset bool) (string, error)
+	GetGroupRequestParticipants(ctx context.Context, jid types.JID) ([]types.GroupParticipantRequest, error)
+	UpdateGroupParticipants(ctx context.Context, jid types.JID, participantChanges []types.JID, action whatsmeow.ParticipantChange) ([]types.GroupParticipant, error)
+	UpdateGroupRequestParticipants(ctx context.Context, jid types.JID, participantChanges []types.JID, action whatsmeow.ParticipantRequestChange) ([]types.GroupParticipant, error)
+	SetGroupName(ctx context.Context, jid types.JID, name string) error
+	SetGroupTopic(ctx context.Context, jid types.JID, previousID, newID, topic string) error
+	SetGroupAnnounce(ctx context.Context, jid types.JID, announce bool) error
+	SetGroupLocked(ctx context.Context, jid types.JID, locked bool) error
+	SetGroupMemberAddMode(ctx context.Context, jid types.JID, mode types.GroupMemberAddMode) error
+	SetGroupJoinApprovalMode(ctx context.Context, jid types.JID, mode bool) error
+}
+
+var (
+	_ WhatsAppClient = (*whatsmeow.Client)(nil)
+	_ WhatsAppClient = (*wafake.Client)(nil)
+)
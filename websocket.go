@@ -0,0 +1,642 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ============= WEBSOCKET =============
+// Every frame the server sends carries a per-connection sequence number
+// ("seq"), so a client that sees a jump knows it missed frames. Clients send
+// JSON requests over the same socket:
+//
+//	{"action": "subscribe", "topics": ["messages", "receipts"]}
+//	{"action": "unsubscribe", "topics": ["presence"]}
+//	{"action": "replay", "since": 1234, "limit": 100}
+//	{"action": "ping"}
+//
+// A connection starts subscribed to every topic (or to ?topics= when given).
+// Replay reads the whatsapp_events table after the given event ID; the
+// heartbeat sent every wsHeartbeatInterval reports the latest event ID as the
+// cursor to resume from. Presence updates are live only and never replayed.
+//
+// Connections either follow one session or, on the user stream, every session
+// of a user; user stream frames carry the session_id they belong to. The same
+// fan-out feeds Server-Sent Events streams (sse.go), which can't send requests
+// and pick topics and the resume point when they connect.
+//
+// Each connection has one writer goroutine draining a buffer of wsSendBuffer
+// frames, so frames go out in order and never concurrently. Live events are
+// offered without waiting: a client whose buffer is full is stalled and gets
+// disconnected, and so is one whose write fails or, on WebSockets, that
+// doesn't answer pings within wsPongWait. Its handler then returns and
+// removes the connection.
+
+const (
+	wsHeartbeatInterval = 30 * time.Second
+	wsWriteTimeout      = 10 * time.Second
+	wsPongWait          = 60 * time.Second    // a WebSocket without a pong (or request) this long is dead
+	wsPingInterval      = wsPongWait * 9 / 10 // must be below wsPongWait
+	wsSendBuffer        = 256                 // frames queued per connection before it counts as stalled
+	wsReplayLimit       = 100
+	wsReplayMaxLimit    = 500
+)
+
+// wsTopicList are the topics a client can subscribe to
+var wsTopicList = []string{"messages", "receipts", "qr", "presence", "session", "events"}
+
+// wsSessionTypes are the message types of the "session" topic
+var wsSessionTypes = map[string]bool{
+	"status":                    true,
+	"connected":                 true,
+	"disconnected":              true,
+	"logged_out":                true,
+	"pair_success":              true,
+	"connection_failed":         true,
+	"refresh_success":           true,
+	"refresh_failed":            true,
+	"business_account_detected": true,
+	"channel_failover":          true,
+	"channel_failback":          true,
+	"device_changed":            true,
+}
+
+// wsTopic returns the topic of a WebSocket message or stored event type
+func wsTopic(messageType string) string {
+	switch {
+	case messageType == "receipt", messageType == "campaign_receipts":
+		return "receipts"
+	case strings.HasPrefix(messageType, "qr_"):
+		return "qr"
+	case messageType == "presence" || messageType == "chat_presence":
+		return "presence"
+	case wsSessionTypes[messageType], strings.HasPrefix(messageType, "session_"), strings.HasPrefix(messageType, "history_sync"):
+		return "session"
+	case strings.HasPrefix(messageType, "message"), strings.HasPrefix(messageType, "outbox_message"),
+		strings.HasPrefix(messageType, "broadcast_list"), messageType == "auto_reply_matched":
+		return "messages"
+	default:
+		return "events"
+	}
+}
+
+// parseWSTopics validates a list of topic names
+func parseWSTopics(topics []string) ([]string, error) {
+	known := make(map[string]bool, len(wsTopicList))
+	for _, topic := range wsTopicList {
+		known[topic] = true
+	}
+
+	parsed := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		topic = strings.ToLower(strings.TrimSpace(topic))
+		if topic == "" {
+			continue
+		}
+		if !known[topic] {
+			return nil, fmt.Errorf("unknown topic %q (expected one of %s)", topic, strings.Join(wsTopicList, ", "))
+		}
+		parsed = append(parsed, topic)
+	}
+	return parsed, nil
+}
+
+// WebSocketManager manages WebSocket connections for real-time updates
+type WebSocketManager struct {
+	db              *DatabaseManager
+	connections     sync.Map // sessionID -> []*streamClient
+	userConnections sync.Map // userID -> []*streamClient
+	sessionOwners   sync.Map // sessionID -> userID
+	sessionSeqs     sync.Map // sessionID -> *sessionSequence
+	eventFilters    sync.Map // userID -> map[sessionID]EventFilterRules, "" = user default
+	mu              sync.RWMutex
+}
+
+// sessionSequence numbers the messages of a session in the order they are
+// sent to the connections
+type sessionSequence struct {
+	mu   sync.Mutex
+	last uint64
+}
+
+// WebSocketMessage represents a message sent through WebSocket
+type WebSocketMessage struct {
+	Type       string                 `json:"type"`
+	Seq        uint64                 `json:"seq,omitempty"`         // per connection, without gaps
+	SessionSeq uint64                 `json:"session_seq,omitempty"` // per session, gaps for unsubscribed topics
+	SessionID  string                 `json:"session_id,omitempty"`  // set on the user stream
+	Data       map[string]interface{} `json:"data"`
+	Timestamp  time.Time              `json:"timestamp"`
+}
+
+// errStreamClosed is returned when sending to a client that disconnected
+var errStreamClosed = errors.New("stream closed")
+
+// streamClient is one event stream connection (WebSocket or SSE) with its
+// subscriptions
+type streamClient struct {
+	sessionID string // empty on the user stream
+	userID    int
+	write     func(WebSocketMessage) error // frames a message for the transport
+	ping      func() error                 // transport keepalive, nil when it has none
+	onClose   func()                       // unblocks the transport's reader, may be nil
+
+	queue     chan WebSocketMessage
+	seq       uint64 // owned by the writer goroutine
+	done      chan struct{}
+	stopped   chan struct{} // closed when the writer goroutine exits
+	closeOnce sync.Once
+
+	topicsMu sync.RWMutex
+	topics   map[string]bool
+}
+
+func newStreamClient(sessionID string, userID int, topics []string, write func(WebSocketMessage) error) *streamClient {
+	return startStreamClient(&streamClient{sessionID: sessionID, userID: userID, write: write}, topics)
+}
+
+// newWSClient creates a client writing JSON frames to a WebSocket
+func newWSClient(conn *websocket.Conn, sessionID string, userID int, topics []string) *streamClient {
+	return startStreamClient(&streamClient{
+		sessionID: sessionID,
+		userID:    userID,
+		write: func(message WebSocketMessage) error {
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			return conn.WriteJSON(message)
+		},
+		ping: func() error {
+			return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		},
+		onClose: func() { conn.Close() },
+	}, topics)
+}
+
+// startStreamClient subscribes a new client and starts its writer
+func startStreamClient(client *streamClient, topics []string) *streamClient {
+	client.topics = make(map[string]bool)
+	client.queue = make(chan WebSocketMessage, wsSendBuffer)
+	client.done = make(chan struct{})
+	client.stopped = make(chan struct{})
+	if len(topics) == 0 {
+		topics = wsTopicList
+	}
+	client.subscribe(topics)
+	go client.writePump()
+	return client
+}
+
+// writePump writes the queued messages, numbering them, and the pings of the
+// transport until the client is closed or a write fails
+func (c *streamClient) writePump() {
+	defer close(c.stopped)
+
+	var pings <-chan time.Time
+	if c.ping != nil {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case message := <-c.queue:
+			select {
+			case <-c.done:
+				return // closed while this was queued; the transport may be gone
+			default:
+			}
+			c.seq++
+			message.Seq = c.seq
+			if message.Timestamp.IsZero() {
+				message.Timestamp = time.Now()
+			}
+			if err := c.write(message); err != nil {
+				c.stop()
+				return
+			}
+		case <-pings:
+			if err := c.ping(); err != nil {
+				c.stop()
+				return
+			}
+		}
+	}
+}
+
+// send queues a message, waiting while the buffer is full; the connection's
+// own loops (status, replay, heartbeats, answers) use it
+func (c *streamClient) send(message WebSocketMessage) error {
+	select {
+	case <-c.done:
+		return errStreamClosed
+	default:
+	}
+	select {
+	case c.queue <- message:
+		return nil
+	case <-c.done:
+		return errStreamClosed
+	}
+}
+
+// offer queues a live event without waiting; a client whose buffer is full
+// is evicted
+func (c *streamClient) offer(message WebSocketMessage) {
+	select {
+	case <-c.done:
+	case c.queue <- message:
+	default:
+		log.Printf("⚠️  Dropping stalled event stream of user %d (%d frames buffered)", c.userID, len(c.queue))
+		c.stop()
+	}
+}
+
+// stop ends the connection without waiting for the writer
+func (c *streamClient) stop() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+}
+
+// close ends the connection and waits for the writer to let go of the
+// transport, so handlers can return safely
+func (c *streamClient) close() {
+	c.stop()
+	<-c.stopped
+}
+
+func (c *streamClient) subscribed(topic string) bool {
+	c.topicsMu.RLock()
+	defer c.topicsMu.RUnlock()
+	return c.topics[topic]
+}
+
+func (c *streamClient) subscribe(topics []string) {
+	c.topicsMu.Lock()
+	defer c.topicsMu.Unlock()
+	for _, topic := range topics {
+		c.topics[topic] = true
+	}
+}
+
+func (c *streamClient) unsubscribe(topics []string) {
+	c.topicsMu.Lock()
+	defer c.topicsMu.Unlock()
+	for _, topic := range topics {
+		delete(c.topics, topic)
+	}
+}
+
+// subscriptions lists the client's topics in a stable order
+func (c *streamClient) subscriptions() []string {
+	c.topicsMu.RLock()
+	defer c.topicsMu.RUnlock()
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// NewWebSocketManager creates a new WebSocket manager
+func NewWebSocketManager(db *DatabaseManager) *WebSocketManager {
+	return &WebSocketManager{db: db}
+}
+
+// AddConnection adds a WebSocket connection for a session
+func (wsm *WebSocketManager) AddConnection(sessionID string, client *streamClient) {
+	wsm.addClient(&wsm.connections, sessionID, client)
+}
+
+// RemoveConnection removes a WebSocket connection
+func (wsm *WebSocketManager) RemoveConnection(sessionID string, client *streamClient) {
+	wsm.removeClient(&wsm.connections, sessionID, client)
+}
+
+// AddUserConnection adds a connection following all sessions of a user
+func (wsm *WebSocketManager) AddUserConnection(userID int, client *streamClient) {
+	wsm.addClient(&wsm.userConnections, userID, client)
+}
+
+// RemoveUserConnection removes a user stream connection
+func (wsm *WebSocketManager) RemoveUserConnection(userID int, client *streamClient) {
+	wsm.removeClient(&wsm.userConnections, userID, client)
+}
+
+func (wsm *WebSocketManager) addClient(clients *sync.Map, key interface{}, client *streamClient) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+
+	connsInterface, _ := clients.LoadOrStore(key, []*streamClient{})
+	conns := connsInterface.([]*streamClient)
+	conns = append(conns, client)
+	clients.Store(key, conns)
+}
+
+func (wsm *WebSocketManager) removeClient(clients *sync.Map, key interface{}, client *streamClient) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+
+	connsInterface, exists := clients.Load(key)
+	if !exists {
+		return
+	}
+
+	conns := connsInterface.([]*streamClient)
+	remaining := make([]*streamClient, 0, len(conns))
+	for _, c := range conns {
+		if c != client {
+			remaining = append(remaining, c)
+		}
+	}
+
+	if len(remaining) > 0 {
+		clients.Store(key, remaining)
+	} else {
+		clients.Delete(key)
+	}
+}
+
+// sessionOwner returns the user owning a session, caching the lookup
+func (wsm *WebSocketManager) sessionOwner(sessionID string) (int, bool) {
+	if userID, ok := wsm.sessionOwners.Load(sessionID); ok {
+		return userID.(int), true
+	}
+	userID, err := wsm.db.GetSessionOwner(sessionID)
+	if err != nil {
+		return 0, false
+	}
+	wsm.sessionOwners.Store(sessionID, userID)
+	return userID, true
+}
+
+// SendToSession sends a message to all connections for a session, and to the
+// owner's user streams, that are subscribed to its topic. Messages of a
+// session are numbered (session_seq) and handed to the connections in that
+// order, so consumers can spot reordered or missing events of the session.
+func (wsm *WebSocketManager) SendToSession(sessionID string, message WebSocketMessage) {
+	userID, owned := wsm.sessionOwner(sessionID)
+	if owned && !wsm.eventAllowed(sessionID, userID, message.Type, message.Data) {
+		return
+	}
+
+	seqInterface, _ := wsm.sessionSeqs.LoadOrStore(sessionID, &sessionSequence{})
+	seq := seqInterface.(*sessionSequence)
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+	seq.last++
+	message.SessionSeq = seq.last
+
+	message.Timestamp = time.Now()
+	topic := wsTopic(message.Type)
+
+	if connsInterface, exists := wsm.connections.Load(sessionID); exists {
+		broadcastToClients(connsInterface.([]*streamClient), topic, message)
+	}
+
+	if owned {
+		if connsInterface, exists := wsm.userConnections.Load(userID); exists {
+			message.SessionID = sessionID
+			broadcastToClients(connsInterface.([]*streamClient), topic, message)
+		}
+	}
+}
+
+func broadcastToClients(conns []*streamClient, topic string, message WebSocketMessage) {
+	for _, client := range conns {
+		if !client.subscribed(topic) {
+			continue
+		}
+		client.offer(message)
+	}
+}
+
+// wsRequest is a client request on an open socket
+type wsRequest struct {
+	Action string   `json:"action"` // subscribe, unsubscribe, replay or ping
+	Topics []string `json:"topics,omitempty"`
+	Since  int64    `json:"since,omitempty"` // replay events after this event ID
+	Limit  int      `json:"limit,omitempty"`
+}
+
+// serveWebSocket runs a connection until the client goes away: it answers
+// client requests and sends heartbeats
+func (wsm *WebSocketManager) serveWebSocket(client *streamClient, conn *websocket.Conn) {
+	defer client.close()
+	done := make(chan struct{})
+
+	// Pongs and requests show the client is alive
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	go func() {
+		defer close(done)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(wsPongWait))
+			wsm.handleRequest(client, data)
+		}
+	}()
+
+	wsm.sendHeartbeats(client, done)
+}
+
+// serveStream runs a connection that can't send requests (SSE, gRPC): it
+// replays the events after since when resuming, then sends heartbeats until
+// done is closed
+func (wsm *WebSocketManager) serveStream(client *streamClient, since int64, resume bool, done <-chan struct{}) {
+	defer client.close()
+
+	if resume {
+		cursor := since
+		for {
+			next, more, err := wsm.replay(client, cursor, wsReplayMaxLimit)
+			if err != nil {
+				return
+			}
+			cursor = next
+			if !more {
+				break
+			}
+		}
+		err := client.send(WebSocketMessage{
+			Type: "replay_done",
+			Data: map[string]interface{}{
+				"cursor": cursor,
+				"more":   false,
+			},
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	wsm.sendHeartbeats(client, done)
+}
+
+// sendHeartbeats sends a heartbeat with the current event cursor every
+// wsHeartbeatInterval until done is closed or the client is closed
+func (wsm *WebSocketManager) sendHeartbeats(client *streamClient, done <-chan struct{}) {
+	ticker := time.NewTicker(wsHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cursor, err := wsm.latestEventID(client)
+			if err != nil {
+				log.Printf("⚠️  Failed to load event cursor for user %d: %v", client.userID, err)
+			}
+			err = client.send(WebSocketMessage{
+				Type: "heartbeat",
+				Data: map[string]interface{}{
+					"cursor": cursor,
+				},
+			})
+			if err != nil {
+				return
+			}
+		case <-done:
+			return
+		case <-client.done:
+			return
+		}
+	}
+}
+
+// handleRequest answers one client request
+func (wsm *WebSocketManager) handleRequest(client *streamClient, data []byte) {
+	var req wsRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		client.send(wsError("invalid request: expected JSON"))
+		return
+	}
+
+	switch req.Action {
+	case "subscribe", "unsubscribe":
+		topics, err := parseWSTopics(req.Topics)
+		if err != nil {
+			client.send(wsError(err.Error()))
+			return
+		}
+		if req.Action == "subscribe" {
+			client.subscribe(topics)
+		} else {
+			client.unsubscribe(topics)
+		}
+		client.send(WebSocketMessage{
+			Type: "subscribed",
+			Data: map[string]interface{}{
+				"topics": client.subscriptions(),
+			},
+		})
+	case "replay":
+		cursor, more, err := wsm.replay(client, req.Since, req.Limit)
+		if err != nil {
+			return
+		}
+		client.send(WebSocketMessage{
+			Type: "replay_done",
+			Data: map[string]interface{}{
+				"cursor": cursor,
+				"more":   more,
+			},
+		})
+	case "ping":
+		client.send(WebSocketMessage{Type: "pong", Data: map[string]interface{}{}})
+	default:
+		client.send(wsError(fmt.Sprintf("unknown action %q", req.Action)))
+	}
+}
+
+// replay sends the stored events after since that match the client's topics.
+// Returns the cursor to continue from and whether more events are stored.
+func (wsm *WebSocketManager) replay(client *streamClient, since int64, limit int) (int64, bool, error) {
+	if limit <= 0 {
+		limit = wsReplayLimit
+	}
+	if limit > wsReplayMaxLimit {
+		limit = wsReplayMaxLimit
+	}
+
+	var (
+		events []WhatsAppEvent
+		err    error
+	)
+	if client.sessionID != "" {
+		events, err = wsm.db.GetSessionEventsSince(client.sessionID, since, limit+1)
+	} else {
+		events, err = wsm.db.GetUserEventsSince(client.userID, since, limit+1)
+	}
+	if err != nil {
+		client.send(wsError("failed to load events"))
+		return since, false, err
+	}
+	more := len(events) > limit
+	if more {
+		events = events[:limit]
+	}
+
+	cursor := since
+	for _, event := range events {
+		cursor = event.ID
+		if !client.subscribed(wsTopic(event.EventType)) {
+			continue
+		}
+		if !wsm.eventAllowed(event.SessionID, client.userID, event.EventType, event.EventData) {
+			continue
+		}
+		message := WebSocketMessage{
+			Type: "replay",
+			Data: map[string]interface{}{
+				"event_id":   event.ID,
+				"event_type": event.EventType,
+				"event_data": event.EventData,
+				"created_at": event.CreatedAt,
+			},
+		}
+		if client.sessionID == "" {
+			message.SessionID = event.SessionID
+		}
+		if err := client.send(message); err != nil {
+			return cursor, more, err
+		}
+	}
+	return cursor, more, nil
+}
+
+// latestEventID is the heartbeat cursor of a connection
+func (wsm *WebSocketManager) latestEventID(client *streamClient) (int64, error) {
+	if client.sessionID != "" {
+		return wsm.db.GetLatestEventID(client.sessionID)
+	}
+	return wsm.db.GetLatestUserEventID(client.userID)
+}
+
+func wsError(message string) WebSocketMessage {
+	return WebSocketMessage{
+		Type: "error",
+		Data: map[string]interface{}{
+			"error": message,
+		},
+	}
+}
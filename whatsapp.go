@@ -3,28 +3,37 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 	"github.com/mdp/qrterminal/v3"
 	"github.com/nyaruka/phonenumbers"
 	"github.com/skip2/go-qrcode"
 	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/proto/waCommon"
 	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/proto/waHistorySync"
 	"go.mau.fi/whatsmeow/store"
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
 	waLog "go.mau.fi/whatsmeow/util/log"
 	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
 	"io"
 	"log"
-	"mime"
+	"math/rand"
 	"net/http"
-	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"whatsapp-api/internal/jobs"
+	"whatsapp-api/internal/storage"
+	"whatsapp-api/pkg/apierr"
+	"whatsapp-api/pkg/wajid"
 )
 
 // ============= BRANDING CONFIGURATION =============
@@ -55,81 +64,32 @@ const (
 type SessionClient struct {
 	SessionID string
 	UserID    int
-	Client    *whatsmeow.Client
+	Client    WhatsAppClient
 	Device    *store.Device
 	QRChannel chan string
 	stopChan  chan struct{}
 	mu        sync.Mutex
-}
-
-// WebSocketManager manages WebSocket connections for real-time updates
-type WebSocketManager struct {
-	connections sync.Map // sessionID -> []*websocket.Conn
-	mu          sync.RWMutex
-}
-
-// WebSocketMessage represents a message sent through WebSocket
-type WebSocketMessage struct {
-	Type      string                 `json:"type"`
-	Data      map[string]interface{} `json:"data"`
-	Timestamp time.Time              `json:"timestamp"`
-}
-
-// NewWebSocketManager creates a new WebSocket manager
-func NewWebSocketManager() *WebSocketManager {
-	return &WebSocketManager{}
-}
-
-// AddConnection adds a WebSocket connection for a session
-func (wsm *WebSocketManager) AddConnection(sessionID string, conn *websocket.Conn) {
-	wsm.mu.Lock()
-	defer wsm.mu.Unlock()
-
-	connsInterface, _ := wsm.connections.LoadOrStore(sessionID, []*websocket.Conn{})
-	conns := connsInterface.([]*websocket.Conn)
-	conns = append(conns, conn)
-	wsm.connections.Store(sessionID, conns)
-}
-
-// RemoveConnection removes a WebSocket connection
-func (wsm *WebSocketManager) RemoveConnection(sessionID string, conn *websocket.Conn) {
-	wsm.mu.Lock()
-	defer wsm.mu.Unlock()
-
-	connsInterface, exists := wsm.connections.Load(sessionID)
-	if !exists {
-		return
-	}
-
-	conns := connsInterface.([]*websocket.Conn)
-	for i, c := range conns {
-		if c == conn {
-			conns = append(conns[:i], conns[i+1:]...)
-			break
-		}
-	}
 
-	if len(conns) > 0 {
-		wsm.connections.Store(sessionID, conns)
-	} else {
-		wsm.connections.Delete(sessionID)
-	}
+	qrMu        sync.Mutex
+	qrCancel    context.CancelFunc // stops the running QR rotation
+	qrAttempts  int                // pairing attempts of this client
+	qrStartedAt time.Time          // first QR code of this client
+	qrAttemptID int64              // WhatsAppQRAttempt of the running rotation
+
+	health   connHealth
+	canary   canaryHealth
+	throttle sessionThrottle
+	calls    sessionCalls
+	worker   sessionWorker
 }
 
-// SendToSession sends a message to all connections for a session
-func (wsm *WebSocketManager) SendToSession(sessionID string, message WebSocketMessage) {
-	connsInterface, exists := wsm.connections.Load(sessionID)
-	if !exists {
-		return
-	}
-
-	message.Timestamp = time.Now()
-	conns := connsInterface.([]*websocket.Conn)
-
-	for _, conn := range conns {
-		go func(c *websocket.Conn) {
-			c.WriteJSON(message)
-		}(conn)
+// stopQRRotation stops showing QR codes, e.g. once the session is paired
+func (sc *SessionClient) stopQRRotation() {
+	sc.qrMu.Lock()
+	defer sc.qrMu.Unlock()
+	if sc.qrCancel != nil {
+		sc.qrCancel()
+		sc.qrCancel = nil
 	}
 }
 
@@ -137,21 +97,78 @@ func (wsm *WebSocketManager) SendToSession(sessionID string, message WebSocketMe
 type WhatsAppService struct {
 	cfg         *Config
 	db          *DatabaseManager
-	sessions    sync.Map // sessionID -> *SessionClient
+	sessions    *SessionRegistry
 	wsManager   *WebSocketManager
 	container   *sqlstore.Container
 	containerMu sync.RWMutex
 	monitorCtx  context.Context    // ADD THIS
 	monitorStop context.CancelFunc // ADD THIS
+
+	liveLocations  sync.Map // shareID -> *LiveLocationShare
+	jidResolver    *wajid.Resolver
+	media          storage.MediaStorage
+	jobs           jobs.Queue
+	safety         sync.Map     // sessionID -> *sessionSafety
+	groupSyncs     sync.Map     // sessionID -> *groupSyncRun
+	groupSyncMu    sync.Mutex   // serializes starting group syncs
+	contactChanges sync.Map     // sessionID -> *pendingContacts
+	autoReplies    sync.Map     // sessionID|chat JID -> time of the last auto-reply
+	lidMappings    sync.Map     // LID -> phone number JID already stored
+	groupSyncDelay atomic.Int64 // GROUP_SYNC_DELAY, changed by config reloads
+	messageDedup   *messageDedup
+	optOutKeywords map[string]bool // OPT_OUT_KEYWORDS
+
+	// newClient creates the WhatsApp client of a device; tests replace it
+	// with one returning a fake
+	newClient func(device *store.Device) WhatsAppClient
 }
 
 // NewWhatsAppService creates a new WhatsApp service
 func NewWhatsAppService(cfg *Config, db *DatabaseManager, wsm *WebSocketManager) *WhatsAppService {
 	ws := &WhatsAppService{
-		cfg:       cfg,
-		db:        db,
-		wsManager: wsm,
+		cfg:         cfg,
+		db:          db,
+		wsManager:   wsm,
+		sessions:    NewSessionRegistry(),
+		jidResolver: newJIDResolver(cfg, db),
+
+		messageDedup: newMessageDedup(cfg.MessageDedupWindow, cfg.MessageDedupSize),
+	}
+	ws.newClient = ws.newWhatsmeowClient
+
+	media, err := storage.New(storage.Config{
+		Backend:           cfg.MediaStorage,
+		Dir:               cfg.MediaStorageDir,
+		PublicURL:         cfg.MediaPublicURL + "/api/v1/media/files",
+		SigningKey:        cfg.MediaURLSigningKey,
+		S3Bucket:          cfg.S3Bucket,
+		S3Region:          cfg.S3Region,
+		S3Endpoint:        cfg.S3Endpoint,
+		S3AccessKeyID:     cfg.S3AccessKeyID,
+		S3SecretAccessKey: cfg.S3SecretAccessKey,
+		S3ForcePathStyle:  cfg.S3ForcePathStyle,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize media storage: %v", err)
+	}
+	ws.media = media
+
+	if ws.optOutKeywords, err = parseOptOutKeywords(cfg.OptOutKeywords); err != nil {
+		log.Fatalf("Invalid OPT_OUT_KEYWORDS: %v", err)
+	}
+
+	queue, err := newJobQueue(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize job queue: %v", err)
+	}
+	ws.jobs = queue
+	ws.registerJobs()
+
+	if err := configureKeepAlive(cfg); err != nil {
+		log.Fatalf("Invalid keepalive settings: %v", err)
 	}
+	setClientLogLevel(cfg.LogLevel)
+	ws.SetGroupSyncDelay(cfg.GroupSyncDelay)
 
 	// Initialize WhatsApp SQL store container
 	if err := ws.initializeContainer(); err != nil {
@@ -161,6 +178,29 @@ func NewWhatsAppService(cfg *Config, db *DatabaseManager, wsm *WebSocketManager)
 	return ws
 }
 
+// GroupSyncDelay returns the pause between group info requests
+func (ws *WhatsAppService) GroupSyncDelay() time.Duration {
+	return time.Duration(ws.groupSyncDelay.Load())
+}
+
+// SetGroupSyncDelay changes the pause between group info requests
+func (ws *WhatsAppService) SetGroupSyncDelay(delay time.Duration) {
+	ws.groupSyncDelay.Store(int64(delay))
+}
+
+// OpenSignedMedia opens a locally stored file for a link returned by the local
+// backend's SignedURL. Other backends serve their signed links themselves.
+func (ws *WhatsAppService) OpenSignedMedia(key, expires, signature string) (io.ReadCloser, error) {
+	local, ok := ws.media.(*storage.Local)
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	if err := local.VerifySignature(key, expires, signature); err != nil {
+		return nil, err
+	}
+	return local.Open(context.Background(), key)
+}
+
 // initializeContainer initializes the WhatsApp SQL store container
 func (ws *WhatsAppService) initializeContainer() error {
 	// Get container from database manager (already using MySQL)
@@ -186,6 +226,19 @@ func (ws *WhatsAppService) initializeContainer() error {
 	return nil
 }
 
+// MaxDevices returns the device limit of a user: their quota, or
+// MAX_DEVICES_PER_USER without one
+func (ws *WhatsAppService) MaxDevices(userID int) int {
+	quota, err := ws.db.GetUserQuota(userID)
+	if err != nil {
+		log.Printf("⚠️  Failed to load quota of user %d: %v", userID, err)
+	}
+	if quota == nil {
+		return ws.cfg.MaxDevicesPerUser
+	}
+	return quota.MaxDevices
+}
+
 // CreateSession creates a new WhatsApp session
 func (ws *WhatsAppService) CreateSession(userID int, sessionName string) (*WhatsAppSession, error) {
 	// Check device limit
@@ -194,13 +247,16 @@ func (ws *WhatsAppService) CreateSession(userID int, sessionName string) (*Whats
 		return nil, err
 	}
 
-	if int(count) >= ws.cfg.MaxDevicesPerUser {
-		return nil, fmt.Errorf("device limit reached: %d/%d", count, ws.cfg.MaxDevicesPerUser)
+	if maxDevices := ws.MaxDevices(userID); int(count) >= maxDevices {
+		return nil, fmt.Errorf("%w: %d/%d", apierr.ErrDeviceLimit, count, maxDevices)
 	}
 
 	// Create session in database
 	session, err := ws.db.CreateSession(userID, sessionName)
 	if err != nil {
+		if isDuplicateKeyError(err) {
+			return nil, fmt.Errorf("%w: %q", apierr.ErrSessionExists, sessionName)
+		}
 		return nil, err
 	}
 
@@ -220,6 +276,36 @@ func (ws *WhatsAppService) CreateSession(userID int, sessionName string) (*Whats
 	return session, nil
 }
 
+// EnsureSession returns the user's session with the given name, creating it
+// when there is none. created reports whether a new session was started.
+func (ws *WhatsAppService) EnsureSession(userID int, sessionName string) (session *WhatsAppSession, created bool, err error) {
+	session, err = ws.db.GetSessionByName(userID, sessionName)
+	if err == nil {
+		return session, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, fmt.Errorf("failed to load session: %w", err)
+	}
+
+	session, err = ws.CreateSession(userID, sessionName)
+	if !errors.Is(err, apierr.ErrSessionExists) {
+		return session, err == nil, err
+	}
+	// Created concurrently by another request
+	session, err = ws.db.GetSessionByName(userID, sessionName)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load session: %w", err)
+	}
+	return session, false, nil
+}
+
+// IsSessionConnected reports whether a session's client is loaded and
+// connected
+func (ws *WhatsAppService) IsSessionConnected(sessionID string) bool {
+	sc, ok := ws.sessions.Get(sessionID)
+	return ok && sc.Client.IsConnected()
+}
+
 // InitializeClient initializes a WhatsApp client for a session
 func (ws *WhatsAppService) InitializeClient(session *WhatsAppSession) error {
 	// Create device store
@@ -229,45 +315,59 @@ func (ws *WhatsAppService) InitializeClient(session *WhatsAppSession) error {
 		return fmt.Errorf("failed to create device store for session %s", session.ID)
 	}
 
-	// Set up logger
-	clientLog := waLog.Stdout("Client", "INFO", true)
+	sessionClient := ws.newSessionClient(session, deviceStore)
+
+	unlock := ws.sessions.Lock(session.ID)
+	ws.sessions.Add(sessionClient)
+	unlock()
+
+	// Connect client
+	go ws.connectClient(sessionClient)
+
+	log.Printf("🚀 Initialized WhatsApp client '%s' for session %s", ClientName, session.ID)
+
+	return nil
+}
 
-	// Create WhatsApp client
-	client := whatsmeow.NewClient(deviceStore, clientLog)
+// newWhatsmeowClient creates the whatsmeow client of a device
+func (ws *WhatsAppService) newWhatsmeowClient(device *store.Device) WhatsAppClient {
+	client := whatsmeow.NewClient(device, newClientLogger("Client"))
 	client.EnableAutoReconnect = ws.cfg.AutoReconnect
 
 	// ============= SET CLIENT PUSH NAME =============
 	// This is the name that appears in WhatsApp at the top of the connection
 	// and in the "Linked Devices" list
-	client.Store.PushName = ClientName // "WA Sender Pro"
+	if client.Store.PushName == "" {
+		client.Store.PushName = ClientName // "WA Sender Pro"
+	}
+	return client
+}
+
+// newSessionClient creates the client of a session for its device and
+// registers the event handlers; it isn't connected yet
+func (ws *WhatsAppService) newSessionClient(session *WhatsAppSession, device *store.Device) *SessionClient {
+	client := ws.newClient(device)
 
-	// Create session client
-	sessionClient := &SessionClient{
+	sc := &SessionClient{
 		SessionID: session.ID,
 		UserID:    session.UserID,
 		Client:    client,
-		Device:    deviceStore,
+		Device:    device,
 		QRChannel: make(chan string, 1),
 		stopChan:  make(chan struct{}),
 	}
-
-	// Register event handlers
-	ws.registerEventHandlers(sessionClient)
-
-	// Store session client
-	ws.sessions.Store(session.ID, sessionClient)
-
-	// Connect client
-	go ws.connectClient(sessionClient)
-
-	log.Printf("🚀 Initialized WhatsApp client '%s' for session %s", ClientName, session.ID)
-
-	return nil
+	ws.startSessionWorker(sc)
+	ws.registerEventHandlers(sc)
+	return sc
 }
 
 // connectClient connects a WhatsApp client
 func (ws *WhatsAppService) connectClient(sc *SessionClient) {
+	if !ws.sessions.SetState(sc, SessionStateConnecting) {
+		return // removed before it got to connect
+	}
 	if err := sc.Client.Connect(); err != nil {
+		ws.sessions.SetState(sc, SessionStateDisconnected)
 		log.Printf("Failed to connect client %s: %v", sc.SessionID, err)
 		sessionUUID, _ := uuid.Parse(sc.SessionID)
 		ws.db.UpdateSessionStatus(sessionUUID, StatusFailed)
@@ -335,96 +435,74 @@ func (ws *WhatsAppService) createDeviceStore(session *WhatsAppSession) *store.De
 
 // GetSessionClient gets a session client from memory
 func (ws *WhatsAppService) GetSessionClient(sessionID string) (*SessionClient, error) {
-	clientInterface, ok := ws.sessions.Load(sessionID)
+	sc, ok := ws.sessions.Get(sessionID)
 	if !ok {
 		// Try to restore from database
 		log.Printf("⚠️  Session %s not in memory, attempting to restore...", sessionID)
 
 		sessionUUID, err := uuid.Parse(sessionID)
 		if err != nil {
-			return nil, fmt.Errorf("invalid session ID")
+			return nil, apierr.ErrInvalidSessionID
 		}
 
 		// Get session from database
 		session, err := ws.db.GetSession(sessionUUID, 0) // userID doesn't matter for restore
 		if err != nil {
-			return nil, fmt.Errorf("session not found in database: %w", err)
+			return nil, fmt.Errorf("%w: %v", apierr.ErrSessionNotFound, err)
 		}
 
 		// Only restore if session was previously connected
 		if session.Status != StatusConnected && session.JID == nil {
-			return nil, fmt.Errorf("session %s is not connected (status: %s)", sessionID, session.Status)
+			return nil, fmt.Errorf("%w (status: %s)", apierr.ErrSessionNotConnected, session.Status)
 		}
 
 		// Try to restore this single session
-		if err := ws.restoreSingleSession(session); err != nil {
+		if sc, err = ws.restoreSession(session); err != nil {
 			return nil, fmt.Errorf("failed to restore session: %w", err)
 		}
-
-		// Try to load again
-		clientInterface, ok = ws.sessions.Load(sessionID)
-		if !ok {
-			return nil, fmt.Errorf("failed to restore session %s", sessionID)
-		}
 	}
 
-	return clientInterface.(*SessionClient), nil
+	return sc, nil
 }
 
-// restoreSingleSession restores a single session
-func (ws *WhatsAppService) restoreSingleSession(session *WhatsAppSession) error {
-	if session.JID == nil || *session.JID == "" {
-		return fmt.Errorf("session has no JID")
-	}
+// restoreSession loads and connects the client of a session from its
+// device, unless it is loaded already
+func (ws *WhatsAppService) restoreSession(session *WhatsAppSession) (*SessionClient, error) {
+	sc, restored, err := ws.sessions.Restore(session.ID, func() (*SessionClient, error) {
+		if session.JID == nil || *session.JID == "" {
+			return nil, fmt.Errorf("session has no JID")
+		}
 
-	// Parse JID
-	jid, err := types.ParseJID(*session.JID)
-	if err != nil {
-		return fmt.Errorf("invalid JID: %w", err)
-	}
+		// Parse JID
+		jid, err := types.ParseJID(*session.JID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JID: %w", err)
+		}
 
-	// Get device from store
-	device, err := ws.db.GetWhatsAppDevice(jid)
+		// Get device from store
+		device, err := ws.db.GetWhatsAppDevice(jid)
+		if err != nil {
+			return nil, fmt.Errorf("device not found in store: %w", err)
+		}
+		return ws.newSessionClient(session, device), nil
+	})
 	if err != nil {
-		return fmt.Errorf("device not found in store: %w", err)
-	}
-
-	// Create client
-	clientLog := waLog.Stdout("Client", "INFO", true)
-	client := whatsmeow.NewClient(device, clientLog)
-	client.EnableAutoReconnect = ws.cfg.AutoReconnect
-
-	// Set push name
-	if client.Store.PushName == "" {
-		client.Store.PushName = ClientName
+		return nil, err
 	}
-
-	// Create session client
-	sessionClient := &SessionClient{
-		SessionID: session.ID,
-		UserID:    session.UserID,
-		Client:    client,
-		Device:    device,
-		QRChannel: make(chan string, 1),
-		stopChan:  make(chan struct{}),
+	if restored {
+		go ws.connectClient(sc)
+		log.Printf("✅ Restored session %s", session.ID)
 	}
-
-	// Register event handlers
-	ws.registerEventHandlers(sessionClient)
-
-	// Store in memory
-	ws.sessions.Store(session.ID, sessionClient)
-
-	// Connect
-	go ws.connectClient(sessionClient)
-
-	log.Printf("✅ Restored session %s", session.ID)
-	return nil
+	return sc, nil
 }
 
-// registerEventHandlers registers WhatsApp event handlers
+// registerEventHandlers dispatches the events of a client; events of a
+// client that was replaced or is being removed are dropped
 func (ws *WhatsAppService) registerEventHandlers(sc *SessionClient) {
 	sc.Client.AddEventHandler(func(evt interface{}) {
+		if !ws.sessions.Current(sc) {
+			return
+		}
 		switch v := evt.(type) {
 		case *events.QR:
 			ws.handleQREvent(sc, v)
@@ -433,30 +511,75 @@ func (ws *WhatsAppService) registerEventHandlers(sc *SessionClient) {
 		case *events.Disconnected:
 			ws.handleDisconnectedEvent(sc)
 		case *events.LoggedOut:
-			ws.handleLoggedOutEvent(sc)
+			ws.handleLoggedOutEvent(sc, v)
+		case *events.TemporaryBan:
+			ws.handleTemporaryBan(sc, v)
+		case *events.ConnectFailure:
+			reason := v.Reason.String()
+			if v.Message != "" {
+				reason += " (" + v.Message + ")"
+			}
+			ws.handleConnectFailure(sc, int(v.Reason), reason)
+		case *events.ClientOutdated:
+			ws.handleConnectFailure(sc, int(events.ConnectFailureClientOutdated), events.ConnectFailureClientOutdated.String())
 		case *events.Message:
-			ws.handleMessageEvent(sc, v)
+			sc.submit("message", func() { ws.handleMessageEvent(sc, v) })
 		case *events.Receipt:
-			ws.handleReceiptEvent(sc, v)
+			sc.submit("receipt", func() { ws.handleReceiptEvent(sc, v) })
 		case *events.PairSuccess:
 			ws.handlePairSuccess(sc, v)
-		case *events.HistorySync: // ← Add this
-			ws.handleHistorySync(sc, v)
+		case *events.OfflineSyncCompleted:
+			sc.submit("device info", func() { ws.refreshDeviceInfo(sc, v) })
+		case *events.HistorySync:
+			sc.submit("history sync", func() { ws.handleHistorySync(sc, v) })
+		case *events.Contact:
+			ws.queueContactChange(sc, v.JID)
+		case *events.PushName:
+			if v.JID.Server == types.DefaultUserServer {
+				ws.queueContactChange(sc, v.JID)
+			} else {
+				ws.queueContactChange(sc, v.JIDAlt)
+			}
+			if !v.JIDAlt.IsEmpty() {
+				sc.submit("lid mapping", func() { ws.recordLIDPair(sc, v.JID, v.JIDAlt) })
+			}
+		case *events.Picture:
+			sc.submit("picture", func() { ws.handlePictureEvent(sc, v) })
+		case *events.Blocklist:
+			sc.submit("blocklist", func() { ws.handleBlocklistEvent(sc, v) })
+		case *events.GroupInfo:
+			sc.submit("group info", func() { ws.handleGroupInfoEvent(sc, v) })
+		case *events.CallOffer, *events.CallOfferNotice, *events.CallAccept, *events.CallReject, *events.CallTerminate:
+			ws.handleCallEvent(sc, v)
+		case *events.Presence:
+			ws.handlePresenceEvent(sc, v)
+		case *events.ChatPresence:
+			ws.handleChatPresenceEvent(sc, v)
+		case *events.KeepAliveTimeout:
+			ws.handleKeepAliveTimeout(sc, v)
+		case *events.KeepAliveRestored:
+			ws.handleKeepAliveRestored(sc)
+		case *events.StreamReplaced:
+			ws.handleStreamReplaced(sc)
 		}
 	})
 }
 
-// handleHistorySync handles history sync to update push name
+// handleHistorySync handles history sync to update push names, chats and messages
 func (ws *WhatsAppService) handleHistorySync(sc *SessionClient, evt *events.HistorySync) {
-	// Get push names from history sync
-	pushnames := evt.Data.GetPushnames()
+	ws.syncHistoryPushNames(sc, evt.Data.GetPushnames())
+	ws.syncHistoryConversations(sc, evt)
+}
+
+// syncHistoryPushNames stores contacts (and our own push name) from history sync
+func (ws *WhatsAppService) syncHistoryPushNames(sc *SessionClient, pushnames []*waHistorySync.Pushname) {
 	if len(pushnames) == 0 {
 		return
 	}
 
 	log.Printf("📇 Syncing %d contacts for session %s", len(pushnames), sc.SessionID)
 
-	myJID := sc.Client.Store.ID
+	myJID := sc.Device.ID
 	contacts := make([]WhatsAppContact, 0, len(pushnames))
 
 	for _, pn := range pushnames {
@@ -482,26 +605,244 @@ func (ws *WhatsAppService) handleHistorySync(sc *SessionClient, evt *events.Hist
 		contacts = append(contacts, *contact)
 	}
 
-	// Bulk insert contacts
-	if len(contacts) > 0 {
-		if err := ws.db.BulkUpsertContacts(contacts); err != nil {
-			log.Printf("❌ Failed to save contacts: %v", err)
-		} else {
-			log.Printf("✅ Saved %d contacts for user %d", len(contacts), sc.UserID)
+	// Only new contacts and changed names are written
+	updated, err := ws.saveContactChanges(sc.UserID, contacts)
+	if err != nil {
+		log.Printf("❌ Failed to save contacts: %v", err)
+	} else {
+		log.Printf("✅ Saved %d new or renamed contacts for user %d", updated, sc.UserID)
+	}
+}
+
+// syncHistoryConversations imports chats and their most recent messages from history sync
+func (ws *WhatsAppService) syncHistoryConversations(sc *SessionClient, evt *events.HistorySync) {
+	conversations := evt.Data.GetConversations()
+	if len(conversations) == 0 {
+		return
+	}
+
+	chats := make([]WhatsAppChat, 0, len(conversations))
+	messages := make([]WhatsAppMessage, 0)
+
+	for _, conv := range conversations {
+		chatJID, err := types.ParseJID(conv.GetID())
+		if err != nil {
+			log.Printf("⚠️  Skipping history conversation with invalid JID %s: %v", conv.GetID(), err)
+			continue
+		}
+
+		chat := WhatsAppChat{
+			SessionID:   sc.SessionID,
+			UserID:      sc.UserID,
+			ChatJID:     chatJID.String(),
+			Name:        conv.GetName(),
+			IsGroup:     chatJID.Server == types.GroupServer,
+			UnreadCount: int(conv.GetUnreadCount()),
+		}
+		if chat.Name == "" {
+			chat.Name = conv.GetDisplayName()
+		}
+		if ts := conv.GetConversationTimestamp(); ts > 0 {
+			lastMessageAt := time.Unix(int64(ts), 0)
+			chat.LastMessageAt = &lastMessageAt
+		}
+		chat.Archived = conv.GetArchived()
+		chat.Pinned = conv.GetPinned() > 0
+
+		if ws.cfg.HistorySyncDepth > 0 {
+			convMessages := make([]WhatsAppMessage, 0, len(conv.GetMessages()))
+			for _, historyMsg := range conv.GetMessages() {
+				msgEvt, err := sc.Client.ParseWebMessage(chatJID, historyMsg.GetMessage())
+				if err != nil || msgEvt.Message == nil {
+					continue
+				}
+				convMessages = append(convMessages, ws.buildStoredMessage(sc, msgEvt, "history"))
+			}
+
+			// Keep only the newest messages up to the configured depth
+			sort.Slice(convMessages, func(i, j int) bool {
+				return convMessages[i].Timestamp.After(convMessages[j].Timestamp)
+			})
+			if len(convMessages) > ws.cfg.HistorySyncDepth {
+				convMessages = convMessages[:ws.cfg.HistorySyncDepth]
+			}
+
+			// The newest incoming messages up to the chat's unread count are still unread
+			unread := chat.UnreadCount
+			for i := range convMessages {
+				if !convMessages[i].FromMe && unread > 0 {
+					convMessages[i].IsRead = false
+					unread--
+				}
+			}
+			if chat.LastMessageAt == nil && len(convMessages) > 0 {
+				chat.LastMessageAt = &convMessages[0].Timestamp
+			}
+			messages = append(messages, convMessages...)
 		}
+
+		chats = append(chats, chat)
+	}
+
+	if err := ws.db.BulkUpsertChats(chats); err != nil {
+		log.Printf("❌ Failed to save history chats for session %s: %v", sc.SessionID, err)
+	}
+	if err := ws.db.BulkSaveMessages(messages); err != nil {
+		log.Printf("❌ Failed to save history messages for session %s: %v", sc.SessionID, err)
+	}
+
+	log.Printf("📚 History sync chunk %d (%s, %d%%) for session %s: %d chats, %d messages",
+		evt.Data.GetChunkOrder(), evt.Data.GetSyncType().String(), evt.Data.GetProgress(),
+		sc.SessionID, len(chats), len(messages))
+
+	progress := map[string]interface{}{
+		"sync_type":     evt.Data.GetSyncType().String(),
+		"chunk_order":   evt.Data.GetChunkOrder(),
+		"progress":      evt.Data.GetProgress(),
+		"conversations": len(chats),
+		"messages":      len(messages),
 	}
+
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "history_sync_progress",
+		Data: progress,
+	})
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "history_synced", progress)
+}
+
+// buildStoredMessage converts a message event into its database representation
+func (ws *WhatsAppService) buildStoredMessage(sc *SessionClient, evt *events.Message, source string) WhatsAppMessage {
+	_, viewOnce, ephemeral := unwrapMessage(evt.Message)
+	stored := WhatsAppMessage{
+		SessionID:   sc.SessionID,
+		UserID:      sc.UserID,
+		ChatJID:     evt.Info.Chat.String(),
+		MessageID:   evt.Info.ID,
+		SenderJID:   evt.Info.Sender.ToNonAD().String(),
+		PushName:    evt.Info.PushName,
+		FromMe:      evt.Info.IsFromMe,
+		MessageType: ws.getMessageType(evt.Message),
+		Content:     ws.extractMessageContent(evt.Message),
+		Media:       mediaReference(evt.Message),
+		IsRead:      evt.Info.IsFromMe || source == "history",
+		Source:      source,
+		ViewOnce:    viewOnce || evt.IsViewOnce,
+		Ephemeral:   ephemeral || evt.IsEphemeral,
+		Timestamp:   evt.Info.Timestamp,
+	}
+	applyMessageContext(&stored, evt.Message)
+	return stored
 }
 
-// handleQREvent handles QR code events
+// QR code lifetimes, matching WhatsApp's: the first code of a pairing
+// attempt is valid for a minute, each following one for 20 seconds
+const (
+	qrFirstCodeTimeout = 60 * time.Second
+	qrCodeTimeout      = 20 * time.Second
+)
+
+// handleQREvent handles QR code events. WhatsApp sends all codes of a pairing
+// attempt at once; they are published one after another as each expires.
+// A session that used up its attempts or pairing time is expired instead.
 func (ws *WhatsAppService) handleQREvent(sc *SessionClient, evt *events.QR) {
-	log.Printf("QR event for session %s", sc.SessionID)
+	log.Printf("QR event for session %s (%d codes)", sc.SessionID, len(evt.Codes))
+
+	sc.qrMu.Lock()
+	defer sc.qrMu.Unlock()
+	if sc.qrCancel != nil {
+		sc.qrCancel()
+		sc.qrCancel = nil
+	}
+	if sc.qrAttemptID != 0 {
+		ws.db.FinishQRAttempt(sc.qrAttemptID, QRAttemptSuperseded)
+		sc.qrAttemptID = 0
+	}
+	if sc.qrStartedAt.IsZero() {
+		sc.qrStartedAt = time.Now()
+	}
+	if reason := ws.qrExpiryReason(sc.qrAttempts, sc.qrStartedAt); reason != "" {
+		go ws.expireSession(sc, reason) // disconnecting from the event handler would block it
+		return
+	}
+	sc.qrAttempts++
+
+	attempt := &WhatsAppQRAttempt{
+		SessionID: sc.SessionID,
+		UserID:    sc.UserID,
+		Attempt:   sc.qrAttempts,
+		Codes:     len(evt.Codes),
+		Outcome:   QRAttemptPending,
+		StartedAt: time.Now(),
+	}
+	if err := ws.db.CreateQRAttempt(attempt); err != nil {
+		log.Printf("⚠️  Failed to record QR attempt of session %s: %v", sc.SessionID, err)
+	}
+	sc.qrAttemptID = attempt.ID
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sc.qrCancel = cancel
+	go ws.rotateQRCodes(ctx, sc, evt.Codes, sc.qrStartedAt)
+}
+
+// rotateQRCodes publishes the codes of a pairing attempt until the session is
+// paired, stopped or the codes run out. The session expires when it runs out
+// of attempts or its pairing time (from startedAt) is up.
+func (ws *WhatsAppService) rotateQRCodes(ctx context.Context, sc *SessionClient, codes []string, startedAt time.Time) {
+	var deadline <-chan time.Time
+	if ws.cfg.QRPairingTimeout > 0 {
+		timer := time.NewTimer(time.Until(startedAt.Add(ws.cfg.QRPairingTimeout)))
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for i, code := range codes {
+		timeout := qrCodeTimeout
+		if i == 0 {
+			timeout = qrFirstCodeTimeout
+		}
+		ws.publishQRCode(sc, code, timeout)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-sc.stopChan:
+			ws.finishQRAttempt(sc, QRAttemptCancelled)
+			return
+		case <-deadline:
+			ws.expireSession(sc, ws.qrTimeoutReason())
+			return
+		case <-time.After(timeout):
+		}
+	}
+
+	log.Printf("⌛ QR codes of session %s expired without being scanned", sc.SessionID)
+	ws.finishQRAttempt(sc, QRAttemptExpired)
+
+	sc.qrMu.Lock()
+	reason := ws.qrExpiryReason(sc.qrAttempts, startedAt)
+	sc.qrMu.Unlock()
+	if reason != "" {
+		ws.expireSession(sc, reason)
+		return
+	}
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.UpdateSessionStatus(sessionUUID, StatusExpired)
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "qr_timeout",
+		Data: nil,
+	})
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "qr_timeout", nil)
+}
 
-	// Update status
+// publishQRCode stores a QR code and pushes it to the session's listeners
+func (ws *WhatsAppService) publishQRCode(sc *SessionClient, code string, timeout time.Duration) {
 	sessionUUID, _ := uuid.Parse(sc.SessionID)
-	ws.db.UpdateSessionStatus(sessionUUID, StatusQRReady)
 
 	// Generate QR code as base64 image
-	qrPNG, err := qrcode.Encode(evt.Codes[0], qrcode.Medium, 256)
+	qrPNG, err := qrcode.Encode(code, qrcode.Medium, 256)
 	if err != nil {
 		log.Printf("Failed to generate QR code: %v", err)
 		return
@@ -518,18 +859,20 @@ func (ws *WhatsAppService) handleQREvent(sc *SessionClient, evt *events.QR) {
 	}
 
 	// Update database with QR
-	ws.db.UpdateSessionQR(sessionUUID, evt.Codes[0], qrBase64, ws.cfg.QRTimeout)
+	expiresAt := time.Now().Add(timeout)
+	ws.db.UpdateSessionQR(sessionUUID, code, qrBase64, expiresAt)
 
 	// Send WebSocket update
 	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
 		Type: "qr_ready",
 		Data: map[string]interface{}{
-			"qr_code": qrBase64,
+			"qr_code":    qrBase64,
+			"expires_at": expiresAt,
 		},
 	})
 
 	// Print to terminal for debugging
-	qrterminal.GenerateWithConfig(evt.Codes[0], qrterminal.Config{
+	qrterminal.GenerateWithConfig(code, qrterminal.Config{
 		Level:     qrterminal.L,
 		Writer:    log.Writer(),
 		BlackChar: qrterminal.WHITE,
@@ -541,37 +884,42 @@ func (ws *WhatsAppService) handleQREvent(sc *SessionClient, evt *events.QR) {
 // handleConnectedEvent handles connected events
 func (ws *WhatsAppService) handleConnectedEvent(sc *SessionClient, evt *events.Connected) {
 	log.Printf("Connected event for session %s", sc.SessionID)
+	ws.sessions.SetState(sc, SessionStateConnected)
+	sc.stopQRRotation()
+	sc.health.recordConnected()
 
 	sessionUUID, _ := uuid.Parse(sc.SessionID)
 
 	// ============= ENSURE PUSH NAME IS SET =============
-	if sc.Client.Store.PushName == "" {
-		sc.Client.Store.PushName = ClientName
+	if sc.Device.PushName == "" {
+		sc.Device.PushName = ClientName
 	}
 
 	// Send presence to ensure WhatsApp registers our push name
-	go func() {
-		time.Sleep(2 * time.Second)
+	sc.enqueueAfter(2*time.Second, "presence", func() {
 		ctx := context.Background()
-		if err := sc.Client.SendPresence(ctx, types.PresenceAvailable); err != nil {
+		err := ws.callWhatsApp(sc, priorityBackground, "send presence", func() error {
+			return sc.Client.SendPresence(ctx, types.PresenceAvailable)
+		})
+		if err != nil {
 			log.Printf("⚠️  Failed to send presence for session %s: %v", sc.SessionID, err)
 		} else {
 			log.Printf("✅ Sent presence with push name '%s' for session %s",
-				sc.Client.Store.PushName, sc.SessionID)
+				sc.Device.PushName, sc.SessionID)
 		}
-	}()
+	})
 
 	// Only update if we have the info
-	if sc.Client.Store.ID != nil {
-		jid := sc.Client.Store.ID.String()
-		phoneNumber := sc.Client.Store.ID.User
-		platform := sc.Client.Store.Platform
+	if sc.Device.ID != nil {
+		jid := sc.Device.ID.String()
+		phoneNumber := sc.Device.ID.User
+		platform := sc.Device.Platform
 
 		if jid != "" && phoneNumber != "" {
 			ws.db.db.Model(&WhatsAppSession{}).
 				Where("id = ?", sessionUUID.String()).
 				Updates(map[string]interface{}{
-					"jid":          jid,
+					"j_id":         jid,
 					"phone_number": phoneNumber,
 					"platform":     platform,
 					"status":       StatusConnected,
@@ -588,32 +936,36 @@ func (ws *WhatsAppService) handleConnectedEvent(sc *SessionClient, evt *events.C
 		Type: "connected",
 		Data: map[string]interface{}{
 			"session_id": sc.SessionID,
-			"push_name":  sc.Client.Store.PushName,
+			"push_name":  sc.Device.PushName,
 		},
 	})
 
 	// Log event
 	ws.db.CreateEvent(sessionUUID, sc.UserID, "connected", map[string]interface{}{
-		"push_name": sc.Client.Store.PushName,
+		"push_name": sc.Device.PushName,
 	})
+	sc.submit("device info", func() { ws.refreshDeviceInfo(sc, nil) })
+	ws.checkSessionChannels(sc.SessionID)
 
 	// ============= NEW: SYNC GROUPS AND DETECT BUSINESS ACCOUNT =============
-	// Run in background to avoid blocking the connection event
-	go func() {
-		// Wait a bit for connection to stabilize
-		time.Sleep(3 * time.Second)
-
+	// Runs on the session worker once the connection had a moment to
+	// stabilize
+	sc.enqueueAfter(3*time.Second, "business detection and group sync", func() {
 		// Detect if this is a business account
 		ws.detectBusinessAccount(sc)
 
-		// Sync all groups
-		ws.syncUserGroups(sc)
-	}()
+		// Sync all groups (resumes an interrupted sync)
+		if _, err := ws.startGroupSync(sc, "connect", false); err != nil && !errors.Is(err, ErrGroupSyncRunning) {
+			log.Printf("❌ Failed to start group sync for session %s: %v", sc.SessionID, err)
+		}
+	})
 }
 
 // handleDisconnectedEvent handles disconnected events
 func (ws *WhatsAppService) handleDisconnectedEvent(sc *SessionClient) {
 	log.Printf("Disconnected event for session %s", sc.SessionID)
+	ws.sessions.SetState(sc, SessionStateDisconnected)
+	ws.recordSessionDisconnect(sc)
 
 	sessionUUID, _ := uuid.Parse(sc.SessionID)
 	ws.db.SetSessionDisconnected(sessionUUID)
@@ -624,36 +976,104 @@ func (ws *WhatsAppService) handleDisconnectedEvent(sc *SessionClient) {
 	})
 
 	ws.db.CreateEvent(sessionUUID, sc.UserID, "disconnected", nil)
+	ws.checkSessionChannels(sc.SessionID)
 }
 
-// handleLoggedOutEvent handles logged out events
-func (ws *WhatsAppService) handleLoggedOutEvent(sc *SessionClient) {
-	log.Printf("Logged out event for session %s", sc.SessionID)
+// handleLoggedOutEvent marks a session unlinked: the device was removed from
+// the phone's Linked Devices or logged out by WhatsApp
+func (ws *WhatsAppService) handleLoggedOutEvent(sc *SessionClient, evt *events.LoggedOut) {
+	code := 0
+	reason := "logged out from the phone"
+	if evt.OnConnect {
+		code = int(evt.Reason)
+		reason = evt.Reason.String()
+	}
+	log.Printf("Logged out event for session %s (%s)", sc.SessionID, reason)
 
 	sessionUUID, _ := uuid.Parse(sc.SessionID)
-	ws.db.UpdateSessionStatus(sessionUUID, StatusDisconnected)
+	ws.db.SetSessionFailure(sessionUUID, StatusUnlinked, code, reason, nil)
 
-	ws.sessions.Delete(sc.SessionID)
-	close(sc.stopChan)
+	// LogoutSession may be removing the client already
+	if ws.sessions.Drain(sc) {
+		close(sc.stopChan)
+		ws.sessions.Remove(sc)
+	}
 
+	data := map[string]interface{}{
+		"status": StatusUnlinked,
+		"reason": reason,
+		"code":   code,
+	}
 	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
 		Type: "logged_out",
-		Data: nil,
+		Data: data,
+	})
+
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "logged_out", data)
+	ws.checkSessionChannels(sc.SessionID)
+}
+
+// handleTemporaryBan marks a session banned until the ban expires. whatsmeow
+// doesn't reconnect; reconnect with /refresh once banned_until has passed.
+func (ws *WhatsAppService) handleTemporaryBan(sc *SessionClient, evt *events.TemporaryBan) {
+	log.Printf("🚫 Session %s temporarily banned: %s", sc.SessionID, evt.String())
+
+	var bannedUntil *time.Time
+	if evt.Expire > 0 {
+		until := time.Now().Add(evt.Expire)
+		bannedUntil = &until
+	}
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.SetSessionFailure(sessionUUID, StatusBanned, int(evt.Code), evt.Code.String(), bannedUntil)
+
+	data := map[string]interface{}{
+		"status":       StatusBanned,
+		"reason":       evt.Code.String(),
+		"code":         int(evt.Code),
+		"banned_until": bannedUntil,
+	}
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "session_banned",
+		Data: data,
 	})
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "session_banned", data)
+	ws.checkSessionChannels(sc.SessionID)
+}
 
-	ws.db.CreateEvent(sessionUUID, sc.UserID, "logged_out", nil)
+// handleConnectFailure marks a session whose connection WhatsApp refused
+// for a reason whatsmeow doesn't handle itself
+func (ws *WhatsAppService) handleConnectFailure(sc *SessionClient, code int, reason string) {
+	log.Printf("❌ Connection of session %s refused: %s", sc.SessionID, reason)
+
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	ws.db.SetSessionFailure(sessionUUID, StatusConnectFailed, code, reason, nil)
+
+	data := map[string]interface{}{
+		"status": StatusConnectFailed,
+		"reason": reason,
+		"code":   code,
+	}
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "session_connect_failed",
+		Data: data,
+	})
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "session_connect_failed", data)
+	ws.checkSessionChannels(sc.SessionID)
 }
 
 // handlePairSuccess handles successful pairing
 func (ws *WhatsAppService) handlePairSuccess(sc *SessionClient, evt *events.PairSuccess) {
 	log.Printf("✅ Pair success for session %s: JID=%s", sc.SessionID, evt.ID.String())
+	sc.stopQRRotation()
+	ws.finishQRAttempt(sc, QRAttemptPaired)
 
 	jidStr := evt.ID.String()
 	phoneNumber := evt.ID.User
 
 	// ============= SET CUSTOM PUSH NAME =============
 	// Override the push name with our custom name
-	sc.Client.Store.PushName = ClientName
+	sc.Device.PushName = ClientName
 	userPushName := evt.BusinessName
 	if userPushName == "" {
 		userPushName = ClientName // Fallback to our brand name
@@ -663,6 +1083,10 @@ func (ws *WhatsAppService) handlePairSuccess(sc *SessionClient, evt *events.Pair
 	sessionUUID, _ := uuid.Parse(sc.SessionID)
 	ws.db.SetSessionConnected(sessionUUID, jidStr, phoneNumber, userPushName, evt.Platform)
 
+	// A freshly paired number starts its warm-up ramp
+	ws.db.SetSessionPaired(sessionUUID)
+	ws.safety.Delete(sc.SessionID)
+
 	log.Printf("📱 Set push name to '%s' for session %s", ClientName, sc.SessionID)
 
 	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
@@ -684,107 +1108,408 @@ func (ws *WhatsAppService) handlePairSuccess(sc *SessionClient, evt *events.Pair
 
 // handleMessageEvent handles message events
 func (ws *WhatsAppService) handleMessageEvent(sc *SessionClient, evt *events.Message) {
+	// Messages replayed after a reconnect were handled already
+	if ws.messageDedup.duplicate(sc.SessionID, evt.Info.Chat.String(), evt.Info.ID) {
+		log.Printf("ℹ️  Session %s: dropped duplicate message %s", sc.SessionID, evt.Info.ID)
+		return
+	}
+
+	// Both addresses of the sender (or of the recipient of our own DMs)
+	ws.recordLIDPair(sc, evt.Info.Sender, evt.Info.SenderAlt)
+	if evt.Info.IsFromMe && !evt.Info.IsGroup {
+		ws.recordLIDPair(sc, evt.Info.Chat, evt.Info.RecipientAlt)
+	}
+
+	// Unknown senders may be ignored, answered or blocked
+	if ws.filterInbound(sc, evt) {
+		return
+	}
+
+	// Reactions, edits and revokes update the message they refer to
+	if ws.handleMessageUpdate(sc, evt) {
+		return
+	}
+
 	content := ws.extractMessageContent(evt.Message)
 	messageType := ws.getMessageType(evt.Message)
+	stored := ws.buildStoredMessage(sc, evt, "live")
 
+	data := map[string]interface{}{
+		"message_id": evt.Info.ID,
+		"chat":       evt.Info.Chat.String(),
+		"from":       evt.Info.Sender.String(),
+		"content":    content,
+		"type":       messageType,
+		"view_once":  stored.ViewOnce,
+		"ephemeral":  stored.Ephemeral,
+		"timestamp":  evt.Info.Timestamp,
+	}
+	addMessageContext(data, &stored)
 	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
 		Type: "message",
-		Data: map[string]interface{}{
-			"message_id": evt.Info.ID,
-			"from":       evt.Info.Sender.String(),
-			"content":    content,
-			"type":       messageType,
-			"timestamp":  evt.Info.Timestamp,
-		},
+		Data: data,
 	})
 
+	// Persist the message so it is available through the chats API. It goes
+	// out with the next batch, unless a copy of its media updates it right away.
+	if keyPrefix, reason := ws.messageMediaCopy(sc, &stored); keyPrefix != "" {
+		if err := ws.db.SaveMessage(&stored); err != nil {
+			log.Printf("⚠️  Failed to store message %s for session %s: %v", evt.Info.ID, sc.SessionID, err)
+		} else {
+			ws.saveMessageMedia(sc, stored, evt.Message, keyPrefix, reason) // already on the session worker
+		}
+	} else {
+		ws.db.QueueMessage(&stored)
+	}
+	if err := ws.db.TouchChat(sc.SessionID, sc.UserID, stored.ChatJID, evt.Info.IsGroup, evt.Info.Timestamp); err != nil {
+		log.Printf("⚠️  Failed to update chat %s for session %s: %v", stored.ChatJID, sc.SessionID, err)
+	} else if !evt.Info.IsFromMe {
+		ws.db.IncrementChatUnread(sc.SessionID, stored.ChatJID)
+	}
+
 	sessionUUID, _ := uuid.Parse(sc.SessionID)
-	ws.db.CreateEvent(sessionUUID, sc.UserID, "message_received", map[string]interface{}{
+	received := map[string]interface{}{
 		"message_id": evt.Info.ID,
 		"from":       evt.Info.Sender.String(),
 		"type":       messageType,
-	})
-}
+	}
+	addMessageContext(received, &stored)
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "message_received", received)
+
+	ws.handleOptOut(sc, evt, content)
+	ws.cancelSequencesOnReply(sc, evt)
+	ws.scoreMessage(sc, evt, content)
+	ws.handleAutoReply(sc, evt, content)
+}
+
+// handlePresenceEvent forwards online/last seen updates of subscribed contacts.
+// Presence is high-volume, so it is pushed live only and not stored as an event.
+func (ws *WhatsAppService) handlePresenceEvent(sc *SessionClient, evt *events.Presence) {
+	sc.canary.presenceReceived(evt.From)
+
+	data := map[string]interface{}{
+		"from":      evt.From.String(),
+		"available": !evt.Unavailable,
+	}
+	if !evt.LastSeen.IsZero() {
+		data["last_seen"] = evt.LastSeen
+	}
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "presence",
+		Data: data,
+	})
+}
+
+// handleChatPresenceEvent forwards typing and recording indicators
+func (ws *WhatsAppService) handleChatPresenceEvent(sc *SessionClient, evt *events.ChatPresence) {
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+		Type: "chat_presence",
+		Data: map[string]interface{}{
+			"chat":  evt.Chat.String(),
+			"from":  evt.Sender.String(),
+			"state": string(evt.State),
+			"media": string(evt.Media),
+		},
+	})
+}
 
-// handleReceiptEvent handles receipt events
+// handleReceiptEvent handles receipt events. Delivery and read receipts of
+// recipients are stored on the outgoing messages for the session stats.
 func (ws *WhatsAppService) handleReceiptEvent(sc *SessionClient, evt *events.Receipt) {
+	if !evt.IsFromMe {
+		switch evt.Type {
+		case types.ReceiptTypeDelivered, types.ReceiptTypeRead, types.ReceiptTypePlayed:
+			read := evt.Type != types.ReceiptTypeDelivered
+			if err := ws.db.MarkMessagesReceipt(sc.SessionID, evt.MessageIDs, read, evt.Timestamp); err != nil {
+				log.Printf("⚠️  Failed to store receipt for session %s: %v", sc.SessionID, err)
+			}
+			// Receipts of aggregate campaigns are reported as campaign_receipts
+			if ws.recordCampaignReceipts(sc, evt.MessageIDs, read, evt.Timestamp) {
+				return
+			}
+		}
+	}
+
 	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
 		Type: "receipt",
 		Data: map[string]interface{}{
 			"message_id": evt.MessageIDs[0],
+			"chat":       evt.Chat.String(),
 			"status":     string(evt.Type),
 			"timestamp":  evt.Timestamp,
 		},
 	})
 }
 
-// SendMessage sends a WhatsApp message
-func (ws *WhatsAppService) SendMessage(sessionID string, userID int, to string, content string) error {
-	// Use the new helper that auto-restores if needed
-	sc, err := ws.GetSessionClient(sessionID)
-	if err != nil {
-		return err
+// MessageResponse describes a message accepted by WhatsApp
+type MessageResponse struct {
+	MessageID  string    `json:"message_id,omitempty"`
+	MessageIDs []string  `json:"message_ids,omitempty"` // every part of a text sent in parts, message_id is the first
+	To         string    `json:"to"`
+	Type       string    `json:"type"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+func newMessageResponse(resp whatsmeow.SendResponse, recipient types.JID, messageType string) *MessageResponse {
+	return &MessageResponse{
+		MessageID: resp.ID,
+		To:        recipient.String(),
+		Type:      messageType,
+		Timestamp: resp.Timestamp,
 	}
+}
 
-	if !sc.Client.IsConnected() {
-		return fmt.Errorf("client not connected")
+// LocationPayload is a static location pin in a SendRequest
+type LocationPayload struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name"`
+	Address   string  `json:"address"`
+}
+
+// SendRequest is a single polymorphic outbound message. The message type is
+// picked from the fields that are set: location, contact(s), buttons, media
+// handle, media, then text. Text doubles as the media caption.
+type SendRequest struct {
+	SessionID   string           `json:"session_id,omitempty"`
+	ChannelID   int64            `json:"channel_id,omitempty"` // instead of session_id: the channel's active session
+	To          string           `json:"to" binding:"required"`
+	Text        string           `json:"text"`
+	MediaType   string           `json:"media_type,omitempty"` // forces image/video/audio/document
+	MediaID     string           `json:"media_id,omitempty"`
+	MediaURL    string           `json:"media_url,omitempty"`
+	MediaBase64 string           `json:"media_base64,omitempty"`
+	Filename    string           `json:"filename,omitempty"`
+	Mimetype    string           `json:"mimetype,omitempty"`
+	IsVoice     bool             `json:"is_voice,omitempty"`
+	Location    *LocationPayload `json:"location,omitempty"`
+	Contact     *ContactCard     `json:"contact,omitempty"`
+	Contacts    []ContactCard    `json:"contacts,omitempty"`
+	Buttons     []string         `json:"buttons,omitempty"`
+	MentionAll  bool             `json:"mention_all,omitempty"` // text to a group: mention every participant
+}
+
+// DispatchSend sends a SendRequest through the matching Send* method
+func (ws *WhatsAppService) DispatchSend(userID int, req SendRequest) (*MessageResponse, error) {
+	if req.ChannelID != 0 {
+		if req.SessionID != "" {
+			return nil, fmt.Errorf("%w: send to either a session_id or a channel_id", apierr.ErrInvalidRequest)
+		}
+		sessionID, err := ws.channelSendSession(userID, req.ChannelID)
+		if err != nil {
+			return nil, err
+		}
+		req.SessionID = sessionID
 	}
+	if _, err := uuid.Parse(req.SessionID); err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+	if req.MentionAll && (req.Location != nil || req.Contact != nil || len(req.Contacts) > 0 || len(req.Buttons) > 0 ||
+		req.MediaID != "" || req.MediaURL != "" || req.MediaBase64 != "") {
+		return nil, fmt.Errorf("%w: mention_all is only supported on text messages", apierr.ErrInvalidRequest)
+	}
+
+	switch {
+	case req.Location != nil:
+		return ws.SendLocationMessage(req.SessionID, userID, req.To, req.Location.Latitude, req.Location.Longitude, req.Location.Name, req.Location.Address)
+
+	case req.Contact != nil || len(req.Contacts) > 0:
+		contacts := req.Contacts
+		if req.Contact != nil {
+			contacts = append([]ContactCard{*req.Contact}, contacts...)
+		}
+		return ws.SendContactMessage(req.SessionID, userID, req.To, contacts)
 
-	var recipient types.JID
+	case len(req.Buttons) > 0:
+		// Interactive buttons are no longer delivered to regular accounts, so
+		// they are rendered as a numbered list the recipient can reply to
+		if req.Text == "" {
+			return nil, fmt.Errorf("text is required when buttons are provided")
+		}
+		var sb strings.Builder
+		sb.WriteString(req.Text)
+		sb.WriteString("\n")
+		for i, button := range req.Buttons {
+			fmt.Fprintf(&sb, "\n%d. %s", i+1, button)
+		}
+		resp, err := ws.SendMessage(req.SessionID, userID, req.To, sb.String())
+		if resp != nil {
+			resp.Type = "buttons"
+		}
+		return resp, err
 
-	// Try to parse as JID first (e.g., 201097154916@s.whatsapp.net)
-	if strings.Contains(to, "@") {
-		recipient, err = types.ParseJID(to)
+	case req.MediaID != "":
+		media, err := ws.GetMediaHandle(req.SessionID, userID, req.MediaID)
 		if err != nil {
-			return fmt.Errorf("invalid JID format: %w", err)
+			return nil, err
 		}
-	} else {
-		// Clean the phone number - remove + and any non-digit characters
-		cleanNumber := ""
-		for _, char := range to {
-			if char >= '0' && char <= '9' {
-				cleanNumber += string(char)
-			}
+		if req.MediaType != "" && media.MediaType != req.MediaType {
+			return nil, fmt.Errorf("media handle is a %s, not a %s", media.MediaType, req.MediaType)
 		}
+		return ws.SendMediaUpload(req.SessionID, userID, req.To, media, req.Text, req.IsVoice)
 
-		// Validate that we have a number
-		if cleanNumber == "" {
-			return fmt.Errorf("invalid phone number format")
+	case req.MediaURL != "" || req.MediaBase64 != "":
+		sc, err := ws.getConnectedClient(req.SessionID, userID)
+		if err != nil {
+			return nil, err
 		}
 
-		// Verify the number is on WhatsApp and get the proper JID
-		// This is the KEY FIX - it ensures we get the correct JID format from WhatsApp
-		resp, err := sc.Client.IsOnWhatsApp(context.Background(), []string{"+" + cleanNumber})
+		mediaData, mimetype, err := ws.loadMediaPayload(req)
 		if err != nil {
-			return fmt.Errorf("failed to verify WhatsApp number: %w", err)
+			return nil, err
 		}
 
-		if len(resp) == 0 {
-			return fmt.Errorf("unable to verify phone number")
+		mediaType := req.MediaType
+		if mediaType == "" {
+			mediaType = detectMediaType(mimetype, req.Filename, mediaData)
+		}
+		if maxSize := ws.cfg.maxMediaSize(mediaType); int64(len(mediaData)) > maxSize {
+			return nil, fmt.Errorf("%w: %d bytes (max %d bytes)", ErrMediaTooLarge, len(mediaData), maxSize)
+		}
+
+		return ws.sendMediaBytes(sc.SessionID, req.To, mediaType, mediaData, req.Text, req.Filename, mimetype, req.IsVoice)
+
+	case req.Text != "" && req.MentionAll:
+		return ws.SendMentionAll(req.SessionID, userID, req.To, req.Text)
+
+	case req.Text != "":
+		return ws.SendMessage(req.SessionID, userID, req.To, req.Text)
+
+	default:
+		return nil, fmt.Errorf("nothing to send: provide text, media_id, media_url, media_base64, location, contact(s) or buttons")
+	}
+}
+
+// loadMediaPayload decodes media_base64 or downloads media_url. A data URI
+// prefix supplies the mimetype when none is given.
+func (ws *WhatsAppService) loadMediaPayload(req SendRequest) ([]byte, string, error) {
+	mimetype := req.Mimetype
+
+	if req.MediaBase64 != "" {
+		base64Data := req.MediaBase64
+		if idx := strings.Index(base64Data, ","); idx != -1 {
+			if mimetype == "" && strings.HasPrefix(base64Data, "data:") {
+				mimetype = strings.TrimSuffix(strings.TrimPrefix(base64Data[:idx], "data:"), ";base64")
+			}
+			base64Data = base64Data[idx+1:]
+		}
+		data, err := base64.StdEncoding.DecodeString(base64Data)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid base64 media data: %v", err)
 		}
+		return data, mimetype, nil
+	}
 
-		if !resp[0].IsIn {
-			return fmt.Errorf("phone number %s is not registered on WhatsApp", cleanNumber)
+	// Without a forced type the download is capped at the largest limit
+	maxSize := ws.cfg.maxMediaSize("document")
+	if req.MediaType != "" {
+		maxSize = ws.cfg.maxMediaSize(req.MediaType)
+	}
+	data, err := ws.downloadMediaFromURL(req.MediaURL, maxSize)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not download media: %w", err)
+	}
+	return data, mimetype, nil
+}
+
+// SendMessage sends a WhatsApp message
+func (ws *WhatsAppService) SendMessage(sessionID string, userID int, to string, content string) (*MessageResponse, error) {
+	// Use the new helper that auto-restores if needed
+	sc, err := ws.GetSessionClient(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sc.Client.IsConnected() {
+		return nil, apierr.ErrSessionNotConnected
+	}
+
+	recipient, err := ws.validateAndGetRecipient(sc, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Broadcast lists are delivered to each member individually
+	if isBroadcastListJID(recipient) {
+		list, err := ws.db.GetBroadcastListByJID(sessionID, recipient.String())
+		if err != nil || list.UserID != userID {
+			return nil, fmt.Errorf("broadcast list %s not found", recipient.String())
+		}
+		if err := validateTemplate(content); err != nil {
+			return nil, err
+		}
+		if err := ws.addSegmentRecipients(list); err != nil {
+			return nil, err
 		}
+		deliveries := ws.sendToBroadcastList(sc, list, content, nil, "")
+		failed := 0
+		for _, delivery := range deliveries {
+			if !delivery.Success {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return nil, fmt.Errorf("broadcast list delivery failed for %d/%d recipients", failed, len(deliveries))
+		}
+		return &MessageResponse{To: recipient.String(), Type: "broadcast_list", Timestamp: time.Now()}, nil
+	}
 
-		// Use the JID returned by WhatsApp - this handles both regular JIDs and LIDs
-		recipient = resp[0].JID
+	// Long texts go out as several messages, in order
+	parts := splitText(content, ws.cfg.TextChunkMaxLength, ws.cfg.TextChunkNumbering)
+	var (
+		first      *whatsmeow.SendResponse
+		messageIDs []string
+	)
+	for i, part := range parts {
+		resp, err := ws.sendTextToJID(sc, recipient, part)
+		if err != nil {
+			if i > 0 {
+				return nil, fmt.Errorf("%w (sent %d of %d parts: %s)", err, i, len(parts), strings.Join(messageIDs, ", "))
+			}
+			return nil, err
+		}
+		if first == nil {
+			first = resp
+		}
+		messageIDs = append(messageIDs, resp.ID)
+	}
 
-		log.Printf("📱 Verified number %s -> JID: %s", cleanNumber, recipient.String())
+	response := newMessageResponse(*first, recipient, "text")
+	if len(parts) > 1 {
+		response.MessageIDs = messageIDs
 	}
+	return response, nil
+}
+
+// sendTextToJID sends a plain text message to an already resolved JID
+func (ws *WhatsAppService) sendTextToJID(sc *SessionClient, recipient types.JID, content string) (*whatsmeow.SendResponse, error) {
+	return ws.sendMentionTextToJID(sc, recipient, content, nil)
+}
 
+// sendMentionTextToJID sends a text message mentioning the given JIDs; the
+// text doesn't need to contain them, mentions without an @ in the text are
+// hidden
+func (ws *WhatsAppService) sendMentionTextToJID(sc *SessionClient, recipient types.JID, content string, mentions []string) (*whatsmeow.SendResponse, error) {
 	message := &waE2E.Message{
 		Conversation: proto.String(content),
 	}
+	if len(mentions) > 0 {
+		message = &waE2E.Message{
+			ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+				Text:        proto.String(content),
+				ContextInfo: &waE2E.ContextInfo{MentionedJID: mentions},
+			},
+		}
+	}
 
-	resp, err := sc.Client.SendMessage(context.Background(), recipient, message)
+	resp, err := ws.sendMessage(sc, recipient, message)
 	if err != nil {
-		return fmt.Errorf("failed to send message: %w", err)
+		return nil, fmt.Errorf("failed to send message: %w", err)
 	}
 
 	log.Printf("✅ Message sent successfully to %s (ID: %s)", recipient.String(), resp.ID)
 
-	ws.wsManager.SendToSession(sessionID, WebSocketMessage{
+	ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
 		Type: "message_sent",
 		Data: map[string]interface{}{
 			"message_id": resp.ID,
@@ -793,14 +1518,566 @@ func (ws *WhatsAppService) SendMessage(sessionID string, userID int, to string,
 		},
 	})
 
+	return &resp, nil
+}
+
+// isSelfRecipient reports whether a recipient string refers to the session's own account (notes to self)
+func isSelfRecipient(to string) bool {
+	switch strings.ToLower(strings.TrimSpace(to)) {
+	case "me", "self":
+		return true
+	}
+	return false
+}
+
+// ownJID returns the session's own JID without device part
+func (ws *WhatsAppService) ownJID(sc *SessionClient) (types.JID, error) {
+	if sc.Device.ID == nil {
+		return types.JID{}, fmt.Errorf("session is not logged in")
+	}
+	return sc.Device.ID.ToNonAD(), nil
+}
+
+// isBroadcastListJID reports whether a JID points to a broadcast list (excluding status updates)
+func isBroadcastListJID(jid types.JID) bool {
+	return wajid.IsBroadcastList(jid)
+}
+
+// ============= BROADCAST LISTS =============
+// whatsmeow can't send to server-side broadcast lists, so lists are managed locally
+// under a generated broadcast JID and each send is delivered to every member's chat.
+
+// BroadcastListDelivery is the per-recipient result of sending to a broadcast list
+type BroadcastListDelivery struct {
+	To        string   `json:"to"`
+	Success   bool     `json:"success"`
+	Status    string   `json:"status"` // sent, failed or suppressed
+	MessageID string   `json:"message_id,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"` // about the rendered text
+}
+
+// resolveRecipients validates recipients and returns their JIDs, plus the inputs that could not be resolved
+func (ws *WhatsAppService) resolveRecipients(sc *SessionClient, recipients []string) ([]string, map[string]string) {
+	resolved := make([]string, 0, len(recipients))
+	invalid := make(map[string]string)
+	for _, to := range recipients {
+		jid, err := ws.validateAndGetRecipient(sc, to)
+		if err != nil {
+			invalid[to] = err.Error()
+			continue
+		}
+		if isBroadcastListJID(jid) || jid.Server == types.GroupServer {
+			invalid[to] = "broadcast list recipients must be individual users"
+			continue
+		}
+		resolved = append(resolved, jid.ToNonAD().String())
+	}
+	return resolved, invalid
+}
+
+// getConnectedClient returns the session client after checking ownership and connection state
+func (ws *WhatsAppService) getConnectedClient(sessionID string, userID int) (*SessionClient, error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apierr.ErrInvalidSessionID
+	}
+
+	if _, err := ws.db.GetSession(sessionUUID, userID); err != nil {
+		return nil, apierr.ErrSessionNotFound
+	}
+
+	sc, err := ws.GetSessionClient(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sc.Client.IsConnected() {
+		return nil, apierr.ErrSessionNotConnected
+	}
+
+	return sc, nil
+}
+
+// CreateBroadcastList creates a broadcast list with the given recipients
+func (ws *WhatsAppService) CreateBroadcastList(sessionID string, userID int, name string, recipients []string, segmentID *int64) (*WhatsAppBroadcastList, map[string]string, error) {
+	sc, err := ws.getConnectedClient(sessionID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if segmentID != nil {
+		if _, err := ws.GetSegment(userID, *segmentID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	resolved, invalid := ws.resolveRecipients(sc, recipients)
+
+	list := &WhatsAppBroadcastList{
+		UserID:       userID,
+		SessionID:    sessionID,
+		Name:         name,
+		BroadcastJID: types.NewJID(fmt.Sprintf("%d", time.Now().UnixMilli()), types.BroadcastServer).String(),
+		SegmentID:    segmentID,
+	}
+	if err := ws.db.CreateBroadcastList(list); err != nil {
+		return nil, nil, fmt.Errorf("failed to create broadcast list: %w", err)
+	}
+	if err := ws.db.AddBroadcastRecipients(list.ID, resolved); err != nil {
+		return nil, nil, fmt.Errorf("failed to add recipients: %w", err)
+	}
+
+	list, err = ws.db.GetBroadcastList(sessionID, userID, list.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sessionUUID, _ := uuid.Parse(sessionID)
+	ws.db.CreateEvent(sessionUUID, userID, "broadcast_list_created", map[string]interface{}{
+		"list_id":       list.ID,
+		"broadcast_jid": list.BroadcastJID,
+		"recipients":    len(list.Recipients),
+	})
+
+	return list, invalid, nil
+}
+
+// AddBroadcastListRecipients validates and adds recipients to a broadcast list
+func (ws *WhatsAppService) AddBroadcastListRecipients(sessionID string, userID int, listID int64, recipients []string) (*WhatsAppBroadcastList, map[string]string, error) {
+	sc, err := ws.getConnectedClient(sessionID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := ws.db.GetBroadcastList(sessionID, userID, listID); err != nil {
+		return nil, nil, fmt.Errorf("broadcast list not found")
+	}
+
+	resolved, invalid := ws.resolveRecipients(sc, recipients)
+	if err := ws.db.AddBroadcastRecipients(listID, resolved); err != nil {
+		return nil, nil, fmt.Errorf("failed to add recipients: %w", err)
+	}
+
+	list, err := ws.db.GetBroadcastList(sessionID, userID, listID)
+	return list, invalid, err
+}
+
+// RemoveBroadcastListRecipients removes recipients (JIDs or phone numbers) from a broadcast list
+func (ws *WhatsAppService) RemoveBroadcastListRecipients(sessionID string, userID int, listID int64, recipients []string) (*WhatsAppBroadcastList, error) {
+	if _, err := ws.db.GetBroadcastList(sessionID, userID, listID); err != nil {
+		return nil, fmt.Errorf("broadcast list not found")
+	}
+
+	jids := make([]string, 0, len(recipients))
+	for _, to := range recipients {
+		jid, err := wajid.Parse(to)
+		if err != nil {
+			continue
+		}
+		jids = append(jids, jid.String())
+	}
+
+	if err := ws.db.RemoveBroadcastRecipients(listID, jids); err != nil {
+		return nil, fmt.Errorf("failed to remove recipients: %w", err)
+	}
+
+	return ws.db.GetBroadcastList(sessionID, userID, listID)
+}
+
+// SendToBroadcastList sends a text message, or a previously uploaded media
+// handle with content as caption, to every member of a broadcast list
+func (ws *WhatsAppService) SendToBroadcastList(sessionID string, userID int, listID int64, content, mediaID string) ([]BroadcastListDelivery, error) {
+	sc, list, media, err := ws.prepareBroadcastListSend(sessionID, userID, listID, content, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	return ws.sendToBroadcastList(sc, list, content, media, ""), nil
+}
+
+// BroadcastListJob is a broadcast list send queued as a background job
+type BroadcastListJob struct {
+	JobID      string `json:"job_id"`
+	ListID     int64  `json:"list_id"`
+	Recipients int    `json:"recipients"`
+}
+
+// broadcastListJob is the payload of a queued broadcast list send
+type broadcastListJob struct {
+	SessionID string `json:"session_id"`
+	UserID    int    `json:"user_id"`
+	ListID    int64  `json:"list_id"`
+	Content   string `json:"content"`
+	MediaID   string `json:"media_id,omitempty"`
+}
+
+// QueueBroadcastListSend checks a broadcast list send like
+// SendToBroadcastList and queues it as a job; the outcome is reported with
+// the broadcast_list_sent or broadcast_list_failed event
+func (ws *WhatsAppService) QueueBroadcastListSend(sessionID string, userID int, listID int64, content, mediaID string) (*BroadcastListJob, error) {
+	_, list, _, err := ws.prepareBroadcastListSend(sessionID, userID, listID, content, mediaID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := broadcastListJob{SessionID: sessionID, UserID: userID, ListID: listID, Content: content, MediaID: mediaID}
+	job, err := ws.jobs.Enqueue(context.Background(), jobBroadcastListSend, payload, jobs.WithMaxAttempts(1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue broadcast list send: %w", err)
+	}
+	return &BroadcastListJob{JobID: job.ID, ListID: listID, Recipients: len(list.Recipients)}, nil
+}
+
+// runBroadcastListJob sends a queued broadcast list send. It runs once at
+// most: a send that can't start (the session disconnected, the list was
+// deleted) is reported as broadcast_list_failed instead of retried.
+func (ws *WhatsAppService) runBroadcastListJob(ctx context.Context, job *jobs.Job) error {
+	var payload broadcastListJob
+	if err := job.Decode(&payload); err != nil {
+		return err
+	}
+
+	sc, list, media, err := ws.prepareBroadcastListSend(payload.SessionID, payload.UserID, payload.ListID, payload.Content, payload.MediaID)
+	if err != nil {
+		data := map[string]interface{}{
+			"job_id":  job.ID,
+			"list_id": payload.ListID,
+			"error":   err.Error(),
+		}
+		if sessionUUID, parseErr := uuid.Parse(payload.SessionID); parseErr == nil {
+			ws.db.CreateEvent(sessionUUID, payload.UserID, "broadcast_list_failed", data)
+		}
+		ws.wsManager.SendToSession(payload.SessionID, WebSocketMessage{
+			Type: "broadcast_list_failed",
+			Data: data,
+		})
+		return err
+	}
+
+	ws.sendToBroadcastList(sc, list, payload.Content, media, job.ID)
 	return nil
 }
 
+// prepareBroadcastListSend loads what a broadcast list send needs and checks
+// that it can be sent
+func (ws *WhatsAppService) prepareBroadcastListSend(sessionID string, userID int, listID int64, content, mediaID string) (*SessionClient, *WhatsAppBroadcastList, *MediaUpload, error) {
+	sc, err := ws.getConnectedClient(sessionID, userID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var media *MediaUpload
+	if mediaID != "" {
+		media, err = ws.GetMediaHandle(sessionID, userID, mediaID)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	list, err := ws.db.GetBroadcastList(sessionID, userID, listID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("broadcast list not found")
+	}
+
+	if err := ws.addSegmentRecipients(list); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if len(list.Recipients) == 0 {
+		return nil, nil, nil, fmt.Errorf("broadcast list has no recipients")
+	}
+
+	if err := validateTemplate(content); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return sc, list, media, nil
+}
+
+// sendToBroadcastList delivers a text or media message to each member of a
+// list. Spintax and {{variables}} in content are rendered per recipient.
+// jobID is set when the send runs as a background job.
+func (ws *WhatsAppService) sendToBroadcastList(sc *SessionClient, list *WhatsAppBroadcastList, content string, media *MediaUpload, jobID string) []BroadcastListDelivery {
+	var contacts map[string]*WhatsAppContact
+	personalize := isTemplate(content)
+	if personalize {
+		jids := make([]types.JID, 0, len(list.Recipients))
+		for _, member := range list.Recipients {
+			if jid, err := types.ParseJID(member.JID); err == nil {
+				jids = append(jids, jid)
+			}
+		}
+		contacts = ws.templateContacts(sc, jids)
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	deliveries := make([]BroadcastListDelivery, 0, len(list.Recipients))
+	for _, member := range list.Recipients {
+		delivery := BroadcastListDelivery{To: member.JID}
+
+		jid, err := types.ParseJID(member.JID)
+		if err != nil {
+			delivery.Status = "failed"
+			delivery.Error = "invalid recipient JID"
+			deliveries = append(deliveries, delivery)
+			continue
+		}
+
+		if err := ws.checkSuppressed(sc, jid); err != nil {
+			delivery.Status = "failed"
+			if errors.Is(err, ErrSuppressed) {
+				delivery.Status = "suppressed"
+			}
+			delivery.Error = err.Error()
+			deliveries = append(deliveries, delivery)
+			continue
+		}
+
+		text := content
+		if personalize {
+			rendered, err := renderMessage(content, ws.recipientVariables(sc, jid, contacts), rng, ws.textLengthLimit())
+			if err != nil {
+				delivery.Status = "failed"
+				delivery.Error = err.Error()
+				deliveries = append(deliveries, delivery)
+				continue
+			}
+			text, delivery.Warnings = rendered.Text, rendered.Warnings
+		}
+
+		var messageID string
+		if media != nil {
+			var resp *MessageResponse
+			if resp, err = ws.sendMedia(sc, jid, media, text, false); err == nil {
+				messageID = resp.MessageID
+			}
+		} else {
+			var resp *whatsmeow.SendResponse
+			if resp, err = ws.sendTextToJID(sc, jid, text); err == nil {
+				messageID = resp.ID
+			}
+		}
+		if err != nil {
+			delivery.Status = "failed"
+			delivery.Error = err.Error()
+		} else {
+			delivery.Status = "sent"
+			delivery.Success = true
+			delivery.MessageID = messageID
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	sent := 0
+	for _, delivery := range deliveries {
+		if delivery.Success {
+			sent++
+		}
+	}
+	log.Printf("📢 Broadcast list %s delivered to %d/%d recipients", list.BroadcastJID, sent, len(deliveries))
+
+	data := map[string]interface{}{
+		"list_id":       list.ID,
+		"broadcast_jid": list.BroadcastJID,
+		"sent":          sent,
+		"failed":        len(deliveries) - sent,
+	}
+	sessionUUID, _ := uuid.Parse(sc.SessionID)
+	if jobID != "" {
+		// Nobody waits for the response of a queued send
+		data["job_id"] = jobID
+		data["results"] = deliveries
+		ws.wsManager.SendToSession(sc.SessionID, WebSocketMessage{
+			Type: "broadcast_list_sent",
+			Data: data,
+		})
+	}
+	ws.db.CreateEvent(sessionUUID, sc.UserID, "broadcast_list_sent", data)
+
+	return deliveries
+}
+
+// ============= CHAT MANAGEMENT =============
+
+// getChatTarget resolves the connected client and chat JID for chat management actions
+func (ws *WhatsAppService) getChatTarget(sessionID string, userID int, chat string) (*SessionClient, types.JID, error) {
+	chatJID, err := types.ParseJID(chat)
+	if err != nil {
+		return nil, types.JID{}, fmt.Errorf("invalid chat JID: %w", err)
+	}
+
+	sc, err := ws.getConnectedClient(sessionID, userID)
+	if err != nil {
+		return nil, types.JID{}, err
+	}
+
+	return sc, chatJID, nil
+}
+
+// lastChatMessageRange returns the timestamp and key of the newest stored message of a chat,
+// which app state patches use to anchor the action. Zero values are valid when nothing is stored.
+func (ws *WhatsAppService) lastChatMessageRange(sc *SessionClient, chatJID types.JID) (time.Time, *waCommon.MessageKey) {
+	last, err := ws.db.GetLatestChatMessage(sc.SessionID, chatJID.String())
+	if err != nil {
+		return time.Time{}, nil
+	}
+
+	sender, _ := types.ParseJID(last.SenderJID)
+	if last.FromMe {
+		sender = types.EmptyJID
+	}
+	return last.Timestamp, sc.Client.BuildMessageKey(chatJID, sender, last.MessageID)
+}
+
+// MarkChatRead marks all pending incoming messages of a chat read and sends
+// their read receipts, unless the session doesn't send read receipts; it
+// reports whether receipts were sent
+func (ws *WhatsAppService) MarkChatRead(sessionID string, userID int, chat string) (int, bool, error) {
+	sc, chatJID, err := ws.getChatTarget(sessionID, userID, chat)
+	if err != nil {
+		return 0, false, err
+	}
+
+	unread, err := ws.db.GetUnreadChatMessages(sessionID, chatJID.String())
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to load unread messages: %w", err)
+	}
+
+	sessionUUID, _ := uuid.Parse(sessionID)
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return 0, false, apierr.ErrSessionNotFound
+	}
+	if !session.SendReadReceipts {
+		if err := ws.db.MarkChatMessagesRead(sessionID, chatJID.String()); err != nil {
+			return 0, false, fmt.Errorf("failed to update messages: %w", err)
+		}
+		log.Printf("✅ Marked %d message(s) read in chat %s for session %s (no receipts)", len(unread), chatJID.String(), sessionID)
+		return len(unread), false, nil
+	}
+
+	// Receipts can only cover messages from a single sender, so group them
+	bySender := make(map[string][]WhatsAppMessage)
+	for _, msg := range unread {
+		bySender[msg.SenderJID] = append(bySender[msg.SenderJID], msg)
+	}
+
+	ctx := context.Background()
+	for senderStr, msgs := range bySender {
+		sender, _ := types.ParseJID(senderStr)
+		ids := make([]types.MessageID, 0, len(msgs))
+		for _, msg := range msgs {
+			ids = append(ids, msg.MessageID)
+		}
+		err := ws.callWhatsApp(sc, priorityInteractive, "mark read", func() error {
+			return sc.Client.MarkRead(ctx, ids, time.Now(), chatJID, sender)
+		})
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to send read receipts: %w", err)
+		}
+	}
+
+	if err := ws.db.MarkChatMessagesRead(sessionID, chatJID.String()); err != nil {
+		return 0, false, fmt.Errorf("failed to update messages: %w", err)
+	}
+
+	log.Printf("✅ Marked %d message(s) read in chat %s for session %s", len(unread), chatJID.String(), sessionID)
+	return len(unread), true, nil
+}
+
+// MarkChatUnread flags a chat as unread on all linked devices via an app state patch
+func (ws *WhatsAppService) MarkChatUnread(sessionID string, userID int, chat string) error {
+	sc, chatJID, err := ws.getChatTarget(sessionID, userID, chat)
+	if err != nil {
+		return err
+	}
+
+	lastTimestamp, lastKey := ws.lastChatMessageRange(sc, chatJID)
+	patch := appstate.BuildMarkChatAsRead(chatJID, false, lastTimestamp, lastKey)
+	err = ws.callWhatsApp(sc, priorityInteractive, "mark chat unread", func() error {
+		return sc.Client.SendAppState(context.Background(), patch)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark chat as unread: %w", err)
+	}
+
+	return ws.db.UpdateChatState(sessionID, chatJID.String(), map[string]interface{}{
+		"unread_count": gorm.Expr("GREATEST(unread_count, 1)"),
+	})
+}
+
+// SetChatArchived archives or unarchives a chat
+func (ws *WhatsAppService) SetChatArchived(sessionID string, userID int, chat string, archived bool) error {
+	sc, chatJID, err := ws.getChatTarget(sessionID, userID, chat)
+	if err != nil {
+		return err
+	}
+
+	lastTimestamp, lastKey := ws.lastChatMessageRange(sc, chatJID)
+	patch := appstate.BuildArchive(chatJID, archived, lastTimestamp, lastKey)
+	err = ws.callWhatsApp(sc, priorityInteractive, "archive chat", func() error {
+		return sc.Client.SendAppState(context.Background(), patch)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update archive state: %w", err)
+	}
+
+	updates := map[string]interface{}{"archived": archived}
+	if archived {
+		// Archiving a chat also unpins it
+		updates["pinned"] = false
+	}
+	return ws.db.UpdateChatState(sessionID, chatJID.String(), updates)
+}
+
+// SetChatPinned pins or unpins a chat
+func (ws *WhatsAppService) SetChatPinned(sessionID string, userID int, chat string, pinned bool) error {
+	sc, chatJID, err := ws.getChatTarget(sessionID, userID, chat)
+	if err != nil {
+		return err
+	}
+
+	err = ws.callWhatsApp(sc, priorityInteractive, "pin chat", func() error {
+		return sc.Client.SendAppState(context.Background(), appstate.BuildPin(chatJID, pinned))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update pin state: %w", err)
+	}
+
+	return ws.db.UpdateChatState(sessionID, chatJID.String(), map[string]interface{}{"pinned": pinned})
+}
+
+// SetChatMuted mutes a chat for the given duration (zero mutes forever) or unmutes it
+func (ws *WhatsAppService) SetChatMuted(sessionID string, userID int, chat string, muted bool, duration time.Duration) error {
+	sc, chatJID, err := ws.getChatTarget(sessionID, userID, chat)
+	if err != nil {
+		return err
+	}
+
+	err = ws.callWhatsApp(sc, priorityInteractive, "mute chat", func() error {
+		return sc.Client.SendAppState(context.Background(), appstate.BuildMute(chatJID, muted, duration))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update mute state: %w", err)
+	}
+
+	var mutedUntil interface{}
+	if muted {
+		if duration > 0 {
+			mutedUntil = time.Now().Add(duration)
+		} else {
+			// Muted forever
+			mutedUntil = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+		}
+	}
+	return ws.db.UpdateChatState(sessionID, chatJID.String(), map[string]interface{}{"muted_until": mutedUntil})
+}
+
 // GetQRCode gets the QR code for a session
 func (ws *WhatsAppService) GetQRCode(sessionID string, userID int) (string, error) {
 	sessionUUID, err := uuid.Parse(sessionID)
 	if err != nil {
-		return "", fmt.Errorf("invalid session ID")
+		return "", apierr.ErrInvalidSessionID
 	}
 
 	session, err := ws.db.GetSession(sessionUUID, userID)
@@ -810,42 +2087,141 @@ func (ws *WhatsAppService) GetQRCode(sessionID string, userID int) (string, erro
 
 	if session.QRCodeBase64 != nil && *session.QRCodeBase64 != "" {
 		if session.QRExpiresAt != nil && session.QRExpiresAt.Before(time.Now()) {
-			return "", fmt.Errorf("QR code expired")
+			return "", fmt.Errorf("%w: QR code expired", apierr.ErrQRNotAvailable)
 		}
 		return *session.QRCodeBase64, nil
 	}
 
-	clientInterface, ok := ws.sessions.Load(sessionID)
+	sc, ok := ws.sessions.Get(sessionID)
 	if !ok {
-		return "", fmt.Errorf("session not initialized")
+		return "", fmt.Errorf("%w: session not initialized", apierr.ErrQRNotAvailable)
 	}
 
-	sc := clientInterface.(*SessionClient)
 	select {
 	case qr := <-sc.QRChannel:
 		sc.QRChannel <- qr
 		return qr, nil
 	default:
-		return "", fmt.Errorf("QR code not available")
+		return "", apierr.ErrQRNotAvailable
 	}
 }
 
 // DeleteSession deletes a WhatsApp session
 func (ws *WhatsAppService) DeleteSession(sessionID string, userID int) error {
-	if clientInterface, ok := ws.sessions.Load(sessionID); ok {
-		sc := clientInterface.(*SessionClient)
+	unlock := ws.sessions.Lock(sessionID)
+	defer unlock()
+
+	ws.stopSessionLiveLocations(sessionID)
+
+	if sc, ok := ws.sessions.Get(sessionID); ok && ws.sessions.Drain(sc) {
 		sc.Client.Disconnect()
 		close(sc.stopChan)
-		ws.sessions.Delete(sessionID)
+		ws.sessions.Remove(sc)
 	}
 
 	sessionUUID, err := uuid.Parse(sessionID)
 	if err != nil {
-		return fmt.Errorf("invalid session ID")
+		return apierr.ErrInvalidSessionID
 	}
 	return ws.db.DeleteSession(sessionUUID, userID)
 }
 
+// LogoutSession unlinks a session's device from the phone, removes it from
+// the device store and deletes the session together with the data synced
+// from the device (chats, messages, groups, avatars, media handles).
+// unlinked reports whether the phone was told; a session that isn't
+// connected is only removed locally and stays in the phone's Linked Devices
+// until WhatsApp drops it.
+func (ws *WhatsAppService) LogoutSession(sessionID string, userID int) (unlinked bool, err error) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return false, apierr.ErrInvalidSessionID
+	}
+	session, err := ws.db.GetSession(sessionUUID, userID)
+	if err != nil {
+		return false, apierr.ErrSessionNotFound
+	}
+
+	unlock := ws.sessions.Lock(sessionID)
+	defer unlock()
+
+	ws.stopSessionLiveLocations(sessionID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if sc, ok := ws.sessions.Get(sessionID); ok && ws.sessions.Drain(sc) {
+		if sc.Client.IsConnected() && sc.Device.ID != nil {
+			if err := sc.Client.Logout(ctx); err != nil {
+				log.Printf("⚠️  Logout request for session %s failed: %v", sessionID, err)
+			} else {
+				unlinked = true
+			}
+		}
+		if !unlinked {
+			sc.Client.Disconnect()
+			if sc.Device.ID != nil {
+				if err := sc.Device.Delete(ctx); err != nil {
+					log.Printf("⚠️  Failed to delete device of session %s: %v", sessionID, err)
+				}
+			}
+		}
+		close(sc.stopChan)
+		ws.sessions.Remove(sc)
+	} else if session.JID != nil && *session.JID != "" {
+		ws.deleteStoredDevice(ctx, sessionID, *session.JID)
+	}
+	ws.safety.Delete(sessionID)
+
+	if err := ws.db.LogoutSession(sessionID, userID); err != nil {
+		return unlinked, fmt.Errorf("failed to delete session data: %w", err)
+	}
+	if err := ws.media.DeletePrefix(ctx, avatarKeyPrefix(sessionID)); err != nil {
+		log.Printf("⚠️  Failed to remove avatar cache of session %s: %v", sessionID, err)
+	}
+	if err := ws.media.DeletePrefix(ctx, statusMediaPrefix(sessionID)); err != nil {
+		log.Printf("⚠️  Failed to remove status media of session %s: %v", sessionID, err)
+	}
+	if err := ws.media.DeletePrefix(ctx, inboundMediaPrefix(sessionID)); err != nil {
+		log.Printf("⚠️  Failed to remove stored incoming media of session %s: %v", sessionID, err)
+	}
+
+	ws.wsManager.SendToSession(sessionID, WebSocketMessage{
+		Type: "logged_out",
+		Data: map[string]interface{}{
+			"unlinked": unlinked,
+		},
+	})
+	ws.db.CreateEvent(sessionUUID, userID, "logged_out", map[string]interface{}{
+		"unlinked": unlinked,
+	})
+
+	log.Printf("👋 Session %s logged out (unlinked from phone: %v)", session.SessionName, unlinked)
+	return unlinked, nil
+}
+
+// deleteStoredDevice removes the device of a session that isn't loaded from
+// the whatsmeow store; it reports whether there was one
+func (ws *WhatsAppService) deleteStoredDevice(ctx context.Context, sessionID, jidStr string) bool {
+	ws.containerMu.RLock()
+	container := ws.container
+	ws.containerMu.RUnlock()
+
+	jid, err := types.ParseJID(jidStr)
+	if err != nil || container == nil {
+		return false
+	}
+	device, err := container.GetDevice(ctx, jid)
+	if err != nil || device == nil {
+		return false
+	}
+	if err := container.DeleteDevice(ctx, device); err != nil {
+		log.Printf("⚠️  Failed to delete device of session %s: %v", sessionID, err)
+		return false
+	}
+	return true
+}
+
 // GetUserSessions gets all sessions for a user
 func (ws *WhatsAppService) GetUserSessions(userID int) ([]WhatsAppSession, error) {
 	return ws.db.GetUserSessions(userID)
@@ -855,7 +2231,7 @@ func (ws *WhatsAppService) GetUserSessions(userID int) ([]WhatsAppSession, error
 func (ws *WhatsAppService) GetSessionStatus(sessionID string, userID int) (*WhatsAppSession, error) {
 	sessionUUID, err := uuid.Parse(sessionID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid session ID")
+		return nil, apierr.ErrInvalidSessionID
 	}
 
 	session, err := ws.db.GetSession(sessionUUID, userID)
@@ -863,8 +2239,7 @@ func (ws *WhatsAppService) GetSessionStatus(sessionID string, userID int) (*What
 		return nil, err
 	}
 
-	if clientInterface, ok := ws.sessions.Load(sessionID); ok {
-		sc := clientInterface.(*SessionClient)
+	if sc, ok := ws.sessions.Get(sessionID); ok {
 		if sc.Client.IsConnected() {
 			session.Status = StatusConnected
 		} else {
@@ -914,41 +2289,19 @@ func (ws *WhatsAppService) RestoreActiveSessions() error {
 			continue
 		}
 
-		// Check if session is already loaded in memory
-		if _, exists := ws.sessions.Load(session.ID); exists {
+		sessionClient, restored, err := ws.sessions.Restore(session.ID, func() (*SessionClient, error) {
+			return ws.newSessionClient(&session, device), nil
+		})
+		if err != nil {
+			log.Printf("   ⚠️  Session %s can't be restored: %v", session.ID, err)
+			continue
+		}
+		if !restored {
 			log.Printf("   ℹ️  Session %s already loaded, skipping", session.ID)
 			continue
 		}
 
 		log.Printf("   🔄 Restoring session: %s (JID: %s)", session.SessionName, jidStr)
-
-		// Create client with existing device
-		clientLog := waLog.Stdout("Client", "INFO", true)
-		client := whatsmeow.NewClient(device, clientLog)
-		client.EnableAutoReconnect = ws.cfg.AutoReconnect
-
-		// Set push name
-		if client.Store.PushName == "" {
-			client.Store.PushName = ClientName
-		}
-
-		// Create session client
-		sessionClient := &SessionClient{
-			SessionID: session.ID,
-			UserID:    session.UserID,
-			Client:    client,
-			Device:    device,
-			QRChannel: make(chan string, 1),
-			stopChan:  make(chan struct{}),
-		}
-
-		// Register event handlers
-		ws.registerEventHandlers(sessionClient)
-
-		// Store session client in memory
-		ws.sessions.Store(session.ID, sessionClient)
-
-		// Connect client
 		go ws.connectClient(sessionClient)
 
 		restoredCount++
@@ -966,6 +2319,7 @@ func (ws *WhatsAppService) RestoreActiveSessions() error {
 
 // extractMessageContent extracts content from a WhatsApp message
 func (ws *WhatsAppService) extractMessageContent(msg *waE2E.Message) string {
+	msg, _, _ = unwrapMessage(msg)
 	if msg.GetConversation() != "" {
 		return msg.GetConversation()
 	}
@@ -984,11 +2338,24 @@ func (ws *WhatsAppService) extractMessageContent(msg *waE2E.Message) string {
 	if msg.GetDocumentMessage() != nil {
 		return "[Document]"
 	}
+	if msg.GetLocationMessage() != nil {
+		return "[Location]"
+	}
+	if msg.GetLiveLocationMessage() != nil {
+		return "[Live Location]"
+	}
+	if msg.GetContactMessage() != nil {
+		return "[Contact] " + msg.GetContactMessage().GetDisplayName()
+	}
+	if msg.GetContactsArrayMessage() != nil {
+		return fmt.Sprintf("[Contacts] %d contacts", len(msg.GetContactsArrayMessage().GetContacts()))
+	}
 	return "[Unknown Message Type]"
 }
 
 // getMessageType gets the type of a WhatsApp message
 func (ws *WhatsAppService) getMessageType(msg *waE2E.Message) string {
+	msg, _, _ = unwrapMessage(msg)
 	if msg.GetConversation() != "" || msg.GetExtendedTextMessage() != nil {
 		return "text"
 	}
@@ -1004,6 +2371,15 @@ func (ws *WhatsAppService) getMessageType(msg *waE2E.Message) string {
 	if msg.GetDocumentMessage() != nil {
 		return "document"
 	}
+	if msg.GetLocationMessage() != nil {
+		return "location"
+	}
+	if msg.GetLiveLocationMessage() != nil {
+		return "live_location"
+	}
+	if msg.GetContactMessage() != nil || msg.GetContactsArrayMessage() != nil {
+		return "contact"
+	}
 	return "unknown"
 }
 
@@ -1012,9 +2388,11 @@ func (ws *WhatsAppService) Cleanup() {
 	// Stop monitor if running
 	ws.StopSessionMonitor()
 
+	// Stop live location shares
+	ws.stopSessionLiveLocations("")
+
 	// Disconnect all sessions
-	ws.sessions.Range(func(key, value interface{}) bool {
-		sc := value.(*SessionClient)
+	ws.sessions.Range(func(sc *SessionClient) bool {
 		sc.Client.Disconnect()
 		return true
 	})
@@ -1077,69 +2455,21 @@ func parseContact(jid, pushName string, userID int) *WhatsAppContact {
 	}
 }
 
-// syncUserGroups syncs all user's WhatsApp groups to the database
-func (ws *WhatsAppService) syncUserGroups(sc *SessionClient) {
-	log.Printf("📱 Starting group sync for session %s", sc.SessionID)
-	ctx := context.Background()
-	groups, err := sc.Client.GetJoinedGroups(ctx)
-	if err != nil {
-		log.Printf("❌ Failed to fetch groups for session %s: %v", sc.SessionID, err)
-		return
-	}
-	if len(groups) == 0 {
-		log.Printf("ℹ️  No groups found for session %s", sc.SessionID)
-		return
-	}
-	log.Printf("📊 Found %d groups for session %s (will use %v delay between requests)",
-		len(groups), sc.SessionID, ws.cfg.GroupSyncDelay)
-
-	successCount := 0
-	errorCount := 0
-	rateLimitCount := 0
-
-	for i, groupInfo := range groups {
-		if i > 0 {
-			time.Sleep(ws.cfg.GroupSyncDelay)
-		}
-		err := ws.processGroupWithRetry(sc, groupInfo, ws.cfg.GroupSyncRetryAttempts)
-		if err != nil {
-			errorCount++
-			if strings.Contains(err.Error(), "429") || strings.Contains(err.Error(), "rate-overlimit") {
-				rateLimitCount++
-				log.Printf("⏸️  Rate limited on group %s, waiting 30 seconds...", groupInfo.JID.String())
-				time.Sleep(30 * time.Second)
-			} else {
-				log.Printf("❌ Failed to process group %s: %v", groupInfo.JID.String(), err)
-			}
-		} else {
-			successCount++
-		}
-		if (i+1)%10 == 0 {
-			log.Printf("📊 Progress: %d/%d groups processed", i+1, len(groups))
-		}
-	}
-	log.Printf("✅ Group sync completed for session %s: %d successful, %d failed (%d rate-limited)",
-		sc.SessionID, successCount, errorCount, rateLimitCount)
-
-	sessionUUID, _ := uuid.Parse(sc.SessionID)
-	ws.db.CreateEvent(sessionUUID, sc.UserID, "groups_synced", map[string]interface{}{
-		"total_groups": len(groups),
-		"successful":   successCount,
-		"failed":       errorCount,
-		"rate_limited": rateLimitCount,
-	})
-}
-
 // processGroup processes a single group and its participants
 func (ws *WhatsAppService) processGroup(sc *SessionClient, groupInfo *types.GroupInfo) error {
 	ctx := context.Background()
-	fullGroupInfo, err := sc.Client.GetGroupInfo(ctx, groupInfo.JID)
-	if err != nil {
-		if strings.Contains(err.Error(), "429") || strings.Contains(err.Error(), "rate-overlimit") {
-			return fmt.Errorf("rate limited: %w", err)
-		}
+	var fullGroupInfo *types.GroupInfo
+	err := ws.callWhatsApp(sc, priorityBackground, "group sync", func() (err error) {
+		fullGroupInfo, err = sc.Client.GetGroupInfo(ctx, groupInfo.JID)
+		return err
+	})
+	var throttleErr *ThrottleError
+	if errors.As(err, &throttleErr) {
+		return err
+	} else if err != nil {
 		return fmt.Errorf("failed to get full group info: %w", err)
 	}
+	syncedAt := time.Now()
 	group := &WhatsAppGroup{
 		UserID:           sc.UserID,
 		SessionID:        sc.SessionID,
@@ -1149,6 +2479,10 @@ func (ws *WhatsAppService) processGroup(sc *SessionClient, groupInfo *types.Grou
 		ParticipantCount: len(fullGroupInfo.Participants),
 		IsAnnouncement:   fullGroupInfo.IsAnnounce,
 		IsLocked:         fullGroupInfo.IsLocked,
+		EphemeralTimer:   fullGroupInfo.DisappearingTimer,
+		MemberAddMode:    string(fullGroupInfo.MemberAddMode),
+		JoinApproval:     fullGroupInfo.IsJoinApprovalRequired,
+		SyncedAt:         &syncedAt,
 	}
 	if err := ws.db.UpsertGroup(group); err != nil {
 		return fmt.Errorf("failed to save group: %w", err)
@@ -1159,8 +2493,14 @@ func (ws *WhatsAppService) processGroup(sc *SessionClient, groupInfo *types.Grou
 	}
 	if len(fullGroupInfo.Participants) > 0 {
 		participants := make([]WhatsAppContact, 0, len(fullGroupInfo.Participants))
+		var lidPairs []store.LIDMapping
 		for _, participant := range fullGroupInfo.Participants {
 			jidStr := participant.JID.String()
+			if participant.JID.Server == types.HiddenUserServer && !participant.PhoneNumber.IsEmpty() {
+				// Stored under the phone number, like the contact sync
+				lidPairs = append(lidPairs, store.LIDMapping{LID: participant.JID, PN: participant.PhoneNumber})
+				jidStr = participant.PhoneNumber.ToNonAD().String()
+			}
 			pushName := participant.DisplayName
 			if pushName == "" {
 				pushName = participant.JID.User
@@ -1175,6 +2515,7 @@ func (ws *WhatsAppService) processGroup(sc *SessionClient, groupInfo *types.Grou
 		} else {
 			log.Printf("👥 Saved %d participants for group %s", len(participants), fullGroupInfo.Name)
 		}
+		ws.recordLIDMappings(sc, lidPairs)
 	}
 	log.Printf("✅ Processed group: %s (%d participants)", fullGroupInfo.Name, len(fullGroupInfo.Participants))
 	return nil
@@ -1185,7 +2526,7 @@ func (ws *WhatsAppService) detectBusinessAccount(sc *SessionClient) {
 	sessionUUID, _ := uuid.Parse(sc.SessionID)
 
 	// Check if business name is set in the store
-	isBusiness := sc.Client.Store.BusinessName != ""
+	isBusiness := sc.Device.BusinessName != ""
 
 	// Update database
 	if err := ws.db.UpdateSessionBusinessAccount(sessionUUID, isBusiness); err != nil {
@@ -1196,11 +2537,11 @@ func (ws *WhatsAppService) detectBusinessAccount(sc *SessionClient) {
 
 	if isBusiness {
 		log.Printf("🏢 Business account detected for session %s: %s",
-			sc.SessionID, sc.Client.Store.BusinessName)
+			sc.SessionID, sc.Device.BusinessName)
 
 		// Log event
 		ws.db.CreateEvent(sessionUUID, sc.UserID, "business_account_detected", map[string]interface{}{
-			"business_name": sc.Client.Store.BusinessName,
+			"business_name": sc.Device.BusinessName,
 		})
 	} else {
 		log.Printf("👤 Personal account detected for session %s", sc.SessionID)
@@ -1209,357 +2550,255 @@ func (ws *WhatsAppService) detectBusinessAccount(sc *SessionClient) {
 
 // ============= MEDIA UPLOAD HELPER =============
 
+// errMediaUploadFailed wraps errors of uploads to WhatsApp's media servers,
+// which are worth retrying
+var errMediaUploadFailed = errors.New("failed to upload media")
+
 // uploadMedia uploads media to WhatsApp servers
 func (ws *WhatsAppService) uploadMedia(sc *SessionClient, mediaData []byte, mediaType whatsmeow.MediaType) (*whatsmeow.UploadResponse, error) {
 	ctx := context.Background()
 
 	log.Printf("📤 Uploading media of type %s (%d bytes)", mediaType, len(mediaData))
 
-	resp, err := sc.Client.Upload(ctx, mediaData, mediaType)
-	if err != nil {
-		return nil, fmt.Errorf("failed to upload media: %w", err)
+	var resp whatsmeow.UploadResponse
+	err := ws.callWhatsApp(sc, priorityInteractive, "upload media", func() error {
+		var err error
+		resp, err = sc.Client.Upload(ctx, mediaData, mediaType)
+		return err
+	})
+	var limitErr *SendLimitError
+	if errors.As(throttledSend(err), &limitErr) {
+		return nil, limitErr
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %w", errMediaUploadFailed, err)
 	}
 
 	log.Printf("✅ Media uploaded successfully - URL: %s", resp.URL)
 	return &resp, nil
 }
 
-// ============= IMAGE MESSAGE =============
-
-// SendImageMessage sends an image message with optional caption
-func (ws *WhatsAppService) SendImageMessage(sessionID string, userID int, to string, imageData []byte, caption string) error {
+// sendMediaBytes uploads in-memory media and sends it (see media.go)
+func (ws *WhatsAppService) sendMediaBytes(sessionID, to, mediaType string, data []byte, caption, filename, mimetype string, isVoice bool) (*MessageResponse, error) {
 	sc, err := ws.GetSessionClient(sessionID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if !sc.Client.IsConnected() {
-		return fmt.Errorf("client not connected")
+		return nil, apierr.ErrSessionNotConnected
 	}
 
 	// Validate recipient
 	recipient, err := ws.validateAndGetRecipient(sc, to)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Upload image
-	uploaded, err := ws.uploadMedia(sc, imageData, whatsmeow.MediaImage)
+	media, err := ws.uploadMediaBytes(sc, mediaType, data, mimetype, filename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Detect MIME type
-	mimeType := http.DetectContentType(imageData)
+	return ws.sendMedia(sc, recipient, media, caption, isVoice)
+}
 
-	// Create image message
-	imageMsg := &waE2E.ImageMessage{
-		Caption:       proto.String(caption),
-		Mimetype:      proto.String(mimeType),
-		URL:           &uploaded.URL,
-		DirectPath:    &uploaded.DirectPath,
-		MediaKey:      uploaded.MediaKey,
-		FileEncSHA256: uploaded.FileEncSHA256,
-		FileSHA256:    uploaded.FileSHA256,
-		FileLength:    &uploaded.FileLength,
-	}
+// ============= IMAGE MESSAGE =============
 
-	message := &waE2E.Message{
-		ImageMessage: imageMsg,
-	}
+// SendImageMessage sends an image message with optional caption
+func (ws *WhatsAppService) SendImageMessage(sessionID string, userID int, to string, imageData []byte, caption string) (*MessageResponse, error) {
+	return ws.sendMediaBytes(sessionID, to, "image", imageData, caption, "", "", false)
+}
 
-	// Send message
-	ctx := context.Background()
-	resp, err := sc.Client.SendMessage(ctx, recipient, message)
-	if err != nil {
-		return fmt.Errorf("failed to send image message: %w", err)
-	}
+// ============= VIDEO MESSAGE =============
 
-	log.Printf("✅ Image message sent to %s (ID: %s)", recipient.String(), resp.ID)
+// SendVideoMessage sends a video message with optional caption
+func (ws *WhatsAppService) SendVideoMessage(sessionID string, userID int, to string, videoData []byte, caption string) (*MessageResponse, error) {
+	return ws.sendMediaBytes(sessionID, to, "video", videoData, caption, "", "", false)
+}
 
-	// Send WebSocket notification
-	ws.wsManager.SendToSession(sessionID, WebSocketMessage{
-		Type: "message_sent",
-		Data: map[string]interface{}{
-			"message_id": resp.ID,
-			"to":         recipient.String(),
-			"type":       "image",
-			"timestamp":  resp.Timestamp,
-		},
-	})
+// ============= AUDIO MESSAGE =============
 
-	return nil
+// SendAudioMessage sends an audio message (voice note or audio file)
+func (ws *WhatsAppService) SendAudioMessage(sessionID string, userID int, to string, audioData []byte, isVoice bool) (*MessageResponse, error) {
+	return ws.sendMediaBytes(sessionID, to, "audio", audioData, "", "", "", isVoice)
 }
 
-// ============= VIDEO MESSAGE =============
+// ============= DOCUMENT MESSAGE =============
 
-// SendVideoMessage sends a video message with optional caption
-func (ws *WhatsAppService) SendVideoMessage(sessionID string, userID int, to string, videoData []byte, caption string) error {
-	sc, err := ws.GetSessionClient(sessionID)
-	if err != nil {
-		return err
-	}
+// SendDocumentMessage sends a document with filename and MIME type
+func (ws *WhatsAppService) SendDocumentMessage(sessionID string, userID int, to string, docData []byte, filename, mimetype string) (*MessageResponse, error) {
+	return ws.sendMediaBytes(sessionID, to, "document", docData, "", filename, mimetype, false)
+}
 
-	if !sc.Client.IsConnected() {
-		return fmt.Errorf("client not connected")
+// SendLocationMessage sends a static location pin
+func (ws *WhatsAppService) SendLocationMessage(sessionID string, userID int, to string, latitude, longitude float64, name, address string) (*MessageResponse, error) {
+	if err := validateCoordinates(latitude, longitude); err != nil {
+		return nil, err
 	}
 
-	// Validate recipient
-	recipient, err := ws.validateAndGetRecipient(sc, to)
+	sc, err := ws.getConnectedClient(sessionID, userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Upload video
-	uploaded, err := ws.uploadMedia(sc, videoData, whatsmeow.MediaVideo)
+	recipient, err := ws.validateAndGetRecipient(sc, to)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Detect MIME type
-	mimeType := http.DetectContentType(videoData)
-	if mimeType == "application/octet-stream" {
-		mimeType = "video/mp4" // Default to mp4
+	locationMsg := &waE2E.LocationMessage{
+		DegreesLatitude:  proto.Float64(latitude),
+		DegreesLongitude: proto.Float64(longitude),
 	}
-
-	// Create video message
-	videoMsg := &waE2E.VideoMessage{
-		Caption:       proto.String(caption),
-		Mimetype:      proto.String(mimeType),
-		URL:           &uploaded.URL,
-		DirectPath:    &uploaded.DirectPath,
-		MediaKey:      uploaded.MediaKey,
-		FileEncSHA256: uploaded.FileEncSHA256,
-		FileSHA256:    uploaded.FileSHA256,
-		FileLength:    &uploaded.FileLength,
+	if name != "" {
+		locationMsg.Name = proto.String(name)
+	}
+	if address != "" {
+		locationMsg.Address = proto.String(address)
 	}
 
 	message := &waE2E.Message{
-		VideoMessage: videoMsg,
+		LocationMessage: locationMsg,
 	}
 
-	// Send message
-	ctx := context.Background()
-	resp, err := sc.Client.SendMessage(ctx, recipient, message)
+	resp, err := ws.sendMessage(sc, recipient, message)
 	if err != nil {
-		return fmt.Errorf("failed to send video message: %w", err)
+		return nil, fmt.Errorf("failed to send location message: %w", err)
 	}
 
-	log.Printf("✅ Video message sent to %s (ID: %s)", recipient.String(), resp.ID)
+	log.Printf("✅ Location message sent to %s (ID: %s)", recipient.String(), resp.ID)
 
 	ws.wsManager.SendToSession(sessionID, WebSocketMessage{
 		Type: "message_sent",
 		Data: map[string]interface{}{
 			"message_id": resp.ID,
 			"to":         recipient.String(),
-			"type":       "video",
+			"type":       "location",
 			"timestamp":  resp.Timestamp,
 		},
 	})
 
-	return nil
+	return newMessageResponse(resp, recipient, "location"), nil
 }
 
-// ============= AUDIO MESSAGE =============
-
-// SendAudioMessage sends an audio message (voice note or audio file)
-func (ws *WhatsAppService) SendAudioMessage(sessionID string, userID int, to string, audioData []byte, isVoice bool) error {
-	sc, err := ws.GetSessionClient(sessionID)
-	if err != nil {
-		return err
+// SendContactMessage shares one or more contact cards. A single card is sent as a
+// ContactMessage, several cards as one ContactsArrayMessage.
+func (ws *WhatsAppService) SendContactMessage(sessionID string, userID int, to string, contacts []ContactCard) (*MessageResponse, error) {
+	if len(contacts) == 0 {
+		return nil, fmt.Errorf("at least one contact is required")
 	}
-
-	if !sc.Client.IsConnected() {
-		return fmt.Errorf("client not connected")
+	if len(contacts) > MaxContactsPerMessage {
+		return nil, fmt.Errorf("too many contacts: %d (max %d)", len(contacts), MaxContactsPerMessage)
 	}
 
-	// Validate recipient
-	recipient, err := ws.validateAndGetRecipient(sc, to)
-	if err != nil {
-		return err
+	contactMsgs := make([]*waE2E.ContactMessage, 0, len(contacts))
+	for i, card := range contacts {
+		displayName, vcard, err := card.Resolve()
+		if err != nil {
+			return nil, fmt.Errorf("contact %d: %w", i+1, err)
+		}
+		contactMsgs = append(contactMsgs, &waE2E.ContactMessage{
+			DisplayName: proto.String(displayName),
+			Vcard:       proto.String(vcard),
+		})
 	}
 
-	// Upload audio
-	uploaded, err := ws.uploadMedia(sc, audioData, whatsmeow.MediaAudio)
+	sc, err := ws.getConnectedClient(sessionID, userID)
 	if err != nil {
-		return err
-	}
-
-	// Detect MIME type
-	mimeType := http.DetectContentType(audioData)
-	if mimeType == "application/octet-stream" {
-		mimeType = "audio/ogg; codecs=opus" // Default for voice notes
+		return nil, err
 	}
 
-	// Create audio message
-	audioMsg := &waE2E.AudioMessage{
-		Mimetype:      proto.String(mimeType),
-		URL:           &uploaded.URL,
-		DirectPath:    &uploaded.DirectPath,
-		MediaKey:      uploaded.MediaKey,
-		FileEncSHA256: uploaded.FileEncSHA256,
-		FileSHA256:    uploaded.FileSHA256,
-		FileLength:    &uploaded.FileLength,
-		PTT:           proto.Bool(isVoice), // PTT = Push To Talk (voice note)
+	recipient, err := ws.validateAndGetRecipient(sc, to)
+	if err != nil {
+		return nil, err
 	}
 
-	message := &waE2E.Message{
-		AudioMessage: audioMsg,
+	message := &waE2E.Message{}
+	if len(contactMsgs) == 1 {
+		message.ContactMessage = contactMsgs[0]
+	} else {
+		message.ContactsArrayMessage = &waE2E.ContactsArrayMessage{
+			DisplayName: proto.String(fmt.Sprintf("%d contacts", len(contactMsgs))),
+			Contacts:    contactMsgs,
+		}
 	}
 
-	// Send message
-	ctx := context.Background()
-	resp, err := sc.Client.SendMessage(ctx, recipient, message)
+	resp, err := ws.sendMessage(sc, recipient, message)
 	if err != nil {
-		return fmt.Errorf("failed to send audio message: %w", err)
+		return nil, fmt.Errorf("failed to send contact message: %w", err)
 	}
 
-	audioType := "audio"
-	if isVoice {
-		audioType = "voice"
-	}
-
-	log.Printf("✅ %s message sent to %s (ID: %s)", audioType, recipient.String(), resp.ID)
+	log.Printf("✅ Contact message sent to %s (ID: %s, contacts: %d)", recipient.String(), resp.ID, len(contactMsgs))
 
 	ws.wsManager.SendToSession(sessionID, WebSocketMessage{
 		Type: "message_sent",
 		Data: map[string]interface{}{
 			"message_id": resp.ID,
 			"to":         recipient.String(),
-			"type":       audioType,
+			"type":       "contact",
+			"contacts":   len(contactMsgs),
 			"timestamp":  resp.Timestamp,
 		},
 	})
 
-	return nil
+	return newMessageResponse(resp, recipient, "contact"), nil
 }
 
-// ============= DOCUMENT MESSAGE =============
-
-// SendDocumentMessage sends a document with filename and MIME type
-func (ws *WhatsAppService) SendDocumentMessage(sessionID string, userID int, to string, docData []byte, filename, mimetype string) error {
-	sc, err := ws.GetSessionClient(sessionID)
-	if err != nil {
-		return err
-	}
-
-	if !sc.Client.IsConnected() {
-		return fmt.Errorf("client not connected")
-	}
+// ============= HELPER FUNCTIONS =============
 
-	// Validate recipient
-	recipient, err := ws.validateAndGetRecipient(sc, to)
+// CheckContactsExist checks which phone numbers are registered on WhatsApp. Numbers
+// that aren't cached are verified with a single IsOnWhatsApp query.
+func (ws *WhatsAppService) CheckContactsExist(sessionID string, userID int, phones []string, forceRefresh bool) (map[string]wajid.Lookup, map[string]string, error) {
+	sc, err := ws.getConnectedClient(sessionID, userID)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	// Upload document
-	uploaded, err := ws.uploadMedia(sc, docData, whatsmeow.MediaDocument)
+	results, invalidErrs, err := ws.jidResolver.LookupMany(context.Background(), sc.Client, phones, forceRefresh)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	// Auto-detect MIME type if not provided
-	if mimetype == "" {
-		mimetype = http.DetectContentType(docData)
-		if mimetype == "application/octet-stream" {
-			// Try to guess from filename extension
-			ext := filepath.Ext(filename)
-			mimetype = mime.TypeByExtension(ext)
-			if mimetype == "" {
-				mimetype = "application/octet-stream"
-			}
-		}
+	invalid := make(map[string]string, len(invalidErrs))
+	for phone, err := range invalidErrs {
+		invalid[phone] = err.Error()
 	}
+	return results, invalid, nil
+}
 
-	// Set default filename if not provided
-	if filename == "" {
-		filename = "document"
+// validateAndGetRecipient validates and returns the recipient JID. Phone numbers
+// are verified with IsOnWhatsApp (cached) and resolved to the JID WhatsApp returns.
+func (ws *WhatsAppService) validateAndGetRecipient(sc *SessionClient, to string) (types.JID, error) {
+	// Notes to self go to our own JID
+	if isSelfRecipient(to) {
+		return ws.ownJID(sc)
 	}
 
-	// Create document message
-	docMsg := &waE2E.DocumentMessage{
-		FileName:      proto.String(filename),
-		Mimetype:      proto.String(mimetype),
-		URL:           &uploaded.URL,
-		DirectPath:    &uploaded.DirectPath,
-		MediaKey:      uploaded.MediaKey,
-		FileEncSHA256: uploaded.FileEncSHA256,
-		FileSHA256:    uploaded.FileSHA256,
-		FileLength:    &uploaded.FileLength,
+	recipient, err := ws.jidResolver.Resolve(context.Background(), sc.Client, to)
+	if err != nil {
+		return types.JID{}, err
 	}
 
-	message := &waE2E.Message{
-		DocumentMessage: docMsg,
+	if !wajid.IsJID(to) {
+		log.Printf("📱 Verified number %s -> JID: %s", to, recipient.String())
 	}
 
-	// Send message
-	ctx := context.Background()
-	resp, err := sc.Client.SendMessage(ctx, recipient, message)
-	if err != nil {
-		return fmt.Errorf("failed to send document message: %w", err)
+	if err := ws.checkSuppressed(sc, recipient); err != nil {
+		return types.JID{}, err
 	}
-
-	log.Printf("✅ Document message sent to %s (ID: %s, file: %s)", recipient.String(), resp.ID, filename)
-
-	ws.wsManager.SendToSession(sessionID, WebSocketMessage{
-		Type: "message_sent",
-		Data: map[string]interface{}{
-			"message_id": resp.ID,
-			"to":         recipient.String(),
-			"type":       "document",
-			"filename":   filename,
-			"timestamp":  resp.Timestamp,
-		},
-	})
-
-	return nil
+	return recipient, nil
 }
 
-// ============= HELPER FUNCTIONS =============
-
-// validateAndGetRecipient validates and returns the recipient JID
-func (ws *WhatsAppService) validateAndGetRecipient(sc *SessionClient, to string) (types.JID, error) {
-	var recipient types.JID
-	var err error
-
-	// Try to parse as JID first (e.g., 201097154916@s.whatsapp.net)
-	if strings.Contains(to, "@") {
-		recipient, err = types.ParseJID(to)
-		if err != nil {
-			return types.JID{}, fmt.Errorf("invalid JID format: %w", err)
-		}
-	} else {
-		// Clean the phone number - remove + and any non-digit characters
-		cleanNumber := ""
-		for _, char := range to {
-			if char >= '0' && char <= '9' {
-				cleanNumber += string(char)
-			}
-		}
-
-		if cleanNumber == "" {
-			return types.JID{}, fmt.Errorf("invalid phone number format")
-		}
-
-		// Verify the number is on WhatsApp
-		resp, err := sc.Client.IsOnWhatsApp(context.Background(), []string{"+" + cleanNumber})
-		if err != nil {
-			return types.JID{}, fmt.Errorf("failed to verify WhatsApp number: %w", err)
-		}
-
-		if len(resp) == 0 || !resp[0].IsIn {
-			return types.JID{}, fmt.Errorf("phone number %s is not registered on WhatsApp", cleanNumber)
-		}
-
-		recipient = resp[0].JID
-		log.Printf("📱 Verified number %s -> JID: %s", cleanNumber, recipient.String())
-	}
+// downloadMediaFromURL downloads media from a URL
+// mediaHTTPError is a media URL that answered with an error status
+type mediaHTTPError struct {
+	StatusCode int
+}
 
-	return recipient, nil
+func (e *mediaHTTPError) Error() string {
+	return fmt.Sprintf("failed to download media: HTTP %d", e.StatusCode)
 }
 
-// downloadMediaFromURL downloads media from a URL
 func (ws *WhatsAppService) downloadMediaFromURL(url string, maxSize int64) ([]byte, error) {
 	log.Printf("📥 Downloading media from URL: %s", url)
 
@@ -1570,7 +2809,7 @@ func (ws *WhatsAppService) downloadMediaFromURL(url string, maxSize int64) ([]by
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to download media: HTTP %d", resp.StatusCode)
+		return nil, &mediaHTTPError{StatusCode: resp.StatusCode}
 	}
 
 	// Check content length
@@ -1589,28 +2828,6 @@ func (ws *WhatsAppService) downloadMediaFromURL(url string, maxSize int64) ([]by
 	return data, nil
 }
 
-func (ws *WhatsAppService) processGroupWithRetry(sc *SessionClient, groupInfo *types.GroupInfo, maxRetries int) error {
-	var lastErr error
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		if attempt > 0 {
-			waitTime := time.Duration(5*(1<<uint(attempt-1))) * time.Second
-			log.Printf("🔄 Retry attempt %d/%d for group %s after %v",
-				attempt+1, maxRetries, groupInfo.JID.String(), waitTime)
-			time.Sleep(waitTime)
-		}
-		err := ws.processGroup(sc, groupInfo)
-		if err == nil {
-			return nil
-		}
-		lastErr = err
-		if strings.Contains(err.Error(), "429") || strings.Contains(err.Error(), "rate-overlimit") {
-			continue
-		}
-		return err
-	}
-	return fmt.Errorf("failed after %d retries: %w", maxRetries, lastErr)
-}
-
 func (ws *WhatsAppService) StartSessionMonitor(ctx context.Context) {
 	ws.monitorCtx, ws.monitorStop = context.WithCancel(ctx)
 	go ws.sessionMonitorLoop()
@@ -1670,11 +2887,11 @@ func (ws *WhatsAppService) checkAllSessionHealth() {
 			Update("last_seen", now)
 
 		// Check if session exists in memory
-		clientInterface, exists := ws.sessions.Load(session.ID)
+		sc, exists := ws.sessions.Get(session.ID)
 		if !exists {
 			// Session not in memory but should be connected, try to restore
 			log.Printf("⚠️ Session %s not in memory, attempting restoration...", session.SessionName)
-			if err := ws.restoreSingleSession(&session); err != nil {
+			if _, err := ws.restoreSession(&session); err != nil {
 				log.Printf("❌ Failed to restore session %s: %v", session.SessionName, err)
 				failedCount++
 				ws.db.UpdateSessionStatus(sessionUUID, StatusDisconnected)
@@ -1686,12 +2903,14 @@ func (ws *WhatsAppService) checkAllSessionHealth() {
 		}
 
 		// Check if client is actually connected
-		sc := clientInterface.(*SessionClient)
 		if !sc.Client.IsConnected() {
 			log.Printf("⚠️ Session %s is disconnected, attempting reconnection...", session.SessionName)
 
 			// Try to reconnect
-			if err := ws.reconnectSession(sc); err != nil {
+			unlock := ws.sessions.Lock(session.ID)
+			err := ws.reconnectSession(sc)
+			unlock()
+			if err != nil {
 				log.Printf("❌ Failed to reconnect session %s: %v", session.SessionName, err)
 				failedCount++
 				ws.db.UpdateSessionStatus(sessionUUID, StatusDisconnected)
@@ -1729,6 +2948,10 @@ func (ws *WhatsAppService) checkAllSessionHealth() {
 
 // reconnectSession attempts to reconnect a disconnected session
 func (ws *WhatsAppService) reconnectSession(sc *SessionClient) error {
+	if !ws.sessions.SetState(sc, SessionStateConnecting) {
+		return fmt.Errorf("session was removed")
+	}
+
 	// Disconnect first if needed
 	if sc.Client.IsConnected() {
 		sc.Client.Disconnect()
@@ -1773,19 +2996,19 @@ func (ws *WhatsAppService) RefreshSession(sessionID string, userID int) error {
 	// Validate session ID
 	sessionUUID, err := uuid.Parse(sessionID)
 	if err != nil {
-		return fmt.Errorf("invalid session ID")
+		return apierr.ErrInvalidSessionID
 	}
 
 	// Check if user owns the session
 	session, err := ws.db.GetSession(sessionUUID, userID)
 	if err != nil {
-		return fmt.Errorf("session not found or unauthorized")
+		return apierr.ErrSessionNotFound
 	}
 
 	log.Printf("🔄 Manual refresh requested for session %s", session.SessionName)
 
 	// Check if session exists in memory
-	clientInterface, exists := ws.sessions.Load(sessionID)
+	sc, exists := ws.sessions.Get(sessionID)
 	if !exists {
 		// Session not in memory, try to restore it
 		log.Printf("📱 Session %s not in memory, attempting restoration...", session.SessionName)
@@ -1795,21 +3018,16 @@ func (ws *WhatsAppService) RefreshSession(sessionID string, userID int) error {
 			return fmt.Errorf("session was never connected")
 		}
 
-		if err := ws.restoreSingleSession(session); err != nil {
+		if sc, err = ws.restoreSession(session); err != nil {
 			// Update status to disconnected
 			ws.db.UpdateSessionStatus(sessionUUID, StatusDisconnected)
 			return fmt.Errorf("failed to restore session: %w", err)
 		}
-
-		// Try to load again after restoration
-		clientInterface, exists = ws.sessions.Load(sessionID)
-		if !exists {
-			return fmt.Errorf("failed to load session after restoration")
-		}
 	}
 
-	// Get the session client
-	sc := clientInterface.(*SessionClient)
+	// Deleting or logging out waits for the refresh
+	unlock := ws.sessions.Lock(sessionID)
+	defer unlock()
 
 	// Force disconnect
 	log.Printf("🔌 Disconnecting session %s...", session.SessionName)